@@ -19,6 +19,21 @@
 //	-loglevel string   Log level: debug, info, warn, error (default: info)
 //	-webaddr string    Web UI address (default ":8080")
 //	-noweb             Disable web UI
+//	-auto-rewrite-percentage int  WAL growth (%) that triggers a rewrite (default: 100, 0 = disabled)
+//	-auto-rewrite-min-size int    Minimum WAL size in MB for automatic rewrites (default: 64)
+//	-appendfsync string  WAL fsync policy: always, everysec, no (default: always)
+//	-config string     JSON config file; its appendfsync/sync_writes apply unless -appendfsync is set
+//	-save string       Snapshot save rules as "<seconds> <changes>" pairs, e.g. "900 1 60 10000" (default: none)
+//	-snapshot-keep-last int    Keep the newest N scheduled snapshots (default: 0)
+//	-snapshot-keep-hourly int  Keep one scheduled snapshot for each of the last N hours (default: 24)
+//	-snapshot-keep-daily int   Keep one scheduled snapshot for each of the last N days (default: 7)
+//	-snapshot-max-age duration Delete scheduled snapshots older than this (default: 0 = no limit)
+//	-databases int     Number of logical databases for SELECT (default: 16)
+//	-maxmemory string  Memory limit for the keyspace, e.g. 100mb (default: 0 = no limit)
+//	-maxmemory-policy string  Eviction policy when the limit is reached (default: noeviction)
+//	-maxmemory-samples int    Keys sampled per database to pick one to evict (default: 5)
+//	-notify-keyspace-events string  Keyspace notifications to publish, e.g. "KEA" (default: none)
+//	-lua-time-limit int  Script run time in ms after which other clients get BUSY (default: 5000)
 package main
 
 import (
@@ -33,9 +48,12 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/flashdb/flashdb/internal/config"
 	"github.com/flashdb/flashdb/internal/engine"
 	"github.com/flashdb/flashdb/internal/server"
+	"github.com/flashdb/flashdb/internal/store"
 	"github.com/flashdb/flashdb/internal/version"
+	"github.com/flashdb/flashdb/internal/wal"
 	"github.com/flashdb/flashdb/internal/web"
 )
 
@@ -57,11 +75,37 @@ func envIntOrDefault(key string, fallback int) int {
 	return fallback
 }
 
+// envDurationOrDefault returns the environment variable as a duration if set, otherwise the fallback.
+func envDurationOrDefault(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+// flagSet reports whether the named flag was given on the command line.
+func flagSet(name string) bool {
+	found := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
+
 func main() {
 	// Flags take precedence over environment variables.
 	// Env vars: FLASHDB_ADDR, FLASHDB_DATA, FLASHDB_PASSWORD, FLASHDB_API_TOKEN,
 	//           FLASHDB_MAXCLIENTS, FLASHDB_TIMEOUT, FLASHDB_WEB_ADDR,
-	//           FLASHDB_LOG_LEVEL, FLASHDB_NO_WEB
+	//           FLASHDB_LOG_LEVEL, FLASHDB_NO_WEB, FLASHDB_AUTO_REWRITE_PERCENTAGE,
+	//           FLASHDB_AUTO_REWRITE_MIN_SIZE, FLASHDB_APPENDFSYNC, FLASHDB_CONFIG,
+	//           FLASHDB_SAVE, FLASHDB_SNAPSHOT_KEEP_LAST, FLASHDB_SNAPSHOT_KEEP_HOURLY,
+	//           FLASHDB_SNAPSHOT_KEEP_DAILY, FLASHDB_SNAPSHOT_MAX_AGE, FLASHDB_MAXMEMORY,
+	//           FLASHDB_MAXMEMORY_POLICY, FLASHDB_MAXMEMORY_SAMPLES,
+	//           FLASHDB_NOTIFY_KEYSPACE_EVENTS, FLASHDB_LUA_TIME_LIMIT
 	addr := flag.String("addr", envOrDefault("FLASHDB_ADDR", ":6379"), "Server address")
 	dataDir := flag.String("data", envOrDefault("FLASHDB_DATA", "data"), "Data directory")
 	requirePass := flag.String("requirepass", envOrDefault("FLASHDB_PASSWORD", ""), "Password for AUTH command")
@@ -75,6 +119,21 @@ func main() {
 	logLevel := flag.String("loglevel", envOrDefault("FLASHDB_LOG_LEVEL", "info"), "Log level: debug, info, warn, error")
 	webAddr := flag.String("webaddr", envOrDefault("FLASHDB_WEB_ADDR", ":8080"), "Web UI & API address")
 	noWeb := flag.Bool("noweb", os.Getenv("FLASHDB_NO_WEB") == "true", "Disable web UI")
+	rewritePct := flag.Int("auto-rewrite-percentage", envIntOrDefault("FLASHDB_AUTO_REWRITE_PERCENTAGE", 100), "WAL growth (%) that triggers a rewrite (0 = disabled)")
+	rewriteMinMB := flag.Int("auto-rewrite-min-size", envIntOrDefault("FLASHDB_AUTO_REWRITE_MIN_SIZE", 64), "Minimum WAL size in MB for automatic rewrites")
+	appendFsync := flag.String("appendfsync", envOrDefault("FLASHDB_APPENDFSYNC", config.DefaultConfig().FsyncPolicy()), "WAL fsync policy: always, everysec, no")
+	save := flag.String("save", envOrDefault("FLASHDB_SAVE", ""), "Snapshot save rules as \"<seconds> <changes>\" pairs")
+	keepLast := flag.Int("snapshot-keep-last", envIntOrDefault("FLASHDB_SNAPSHOT_KEEP_LAST", 0), "Keep the newest N scheduled snapshots")
+	keepHourly := flag.Int("snapshot-keep-hourly", envIntOrDefault("FLASHDB_SNAPSHOT_KEEP_HOURLY", 24), "Keep one scheduled snapshot for each of the last N hours")
+	keepDaily := flag.Int("snapshot-keep-daily", envIntOrDefault("FLASHDB_SNAPSHOT_KEEP_DAILY", 7), "Keep one scheduled snapshot for each of the last N days")
+	maxAge := flag.Duration("snapshot-max-age", envDurationOrDefault("FLASHDB_SNAPSHOT_MAX_AGE", 0), "Delete scheduled snapshots older than this (0 = no limit)")
+	databases := flag.Int("databases", envIntOrDefault("FLASHDB_DATABASES", 16), "Number of logical databases")
+	maxMemory := flag.String("maxmemory", envOrDefault("FLASHDB_MAXMEMORY", "0"), "Memory limit for the keyspace, e.g. 100mb (0 = no limit)")
+	maxMemoryPolicy := flag.String("maxmemory-policy", envOrDefault("FLASHDB_MAXMEMORY_POLICY", "noeviction"), "Eviction policy when the memory limit is reached")
+	maxMemorySamples := flag.Int("maxmemory-samples", envIntOrDefault("FLASHDB_MAXMEMORY_SAMPLES", engine.DefaultMaxMemorySamples), "Keys sampled per database to pick one to evict")
+	notifyEvents := flag.String("notify-keyspace-events", envOrDefault("FLASHDB_NOTIFY_KEYSPACE_EVENTS", ""), "Keyspace notifications to publish, e.g. KEA (empty = none)")
+	luaTimeLimitMS := flag.Int("lua-time-limit", envIntOrDefault("FLASHDB_LUA_TIME_LIMIT", int(server.DefaultScriptTimeLimit/time.Millisecond)), "Script run time in ms after which other clients get BUSY and SCRIPT KILL may stop it")
+	configPath := flag.String("config", envOrDefault("FLASHDB_CONFIG", ""), "Path to a JSON config file")
 	showVersion := flag.Bool("version", false, "Show version and exit")
 	flag.Parse()
 
@@ -83,6 +142,40 @@ func main() {
 		return
 	}
 
+	fsyncName := *appendFsync
+	if *configPath != "" {
+		fileCfg, err := config.Load(*configPath)
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		if !flagSet("appendfsync") && os.Getenv("FLASHDB_APPENDFSYNC") == "" {
+			fsyncName = fileCfg.FsyncPolicy()
+		}
+	}
+	syncPolicy, err := wal.ParseSyncPolicy(fsyncName)
+	if err != nil {
+		log.Fatalf("Invalid appendfsync: %v", err)
+	}
+
+	saveRules, err := engine.ParseSaveRules(*save)
+	if err != nil {
+		log.Fatalf("Invalid save rules: %v", err)
+	}
+
+	maxMemoryBytes, err := engine.ParseMemory(*maxMemory)
+	if err != nil {
+		log.Fatalf("Invalid maxmemory: %v", err)
+	}
+	evictionPolicy, ok := store.ParseEvictionPolicy(*maxMemoryPolicy)
+	if !ok {
+		log.Fatalf("Invalid maxmemory-policy: %q", *maxMemoryPolicy)
+	}
+
+	keyspaceEvents, err := engine.ParseKeyspaceEvents(*notifyEvents)
+	if err != nil {
+		log.Fatalf("Invalid notify-keyspace-events: %v", err)
+	}
+
 	walPath := filepath.Join(*dataDir, "flashdb.wal")
 
 	// ASCII art banner
@@ -96,6 +189,13 @@ func main() {
 	log.Printf("FlashDB v%s starting...", version.Version)
 	log.Printf("Data directory: %s", *dataDir)
 	log.Printf("WAL path: %s", walPath)
+	log.Printf("WAL fsync policy: %s", syncPolicy)
+	if len(saveRules) > 0 {
+		log.Printf("Snapshot save rules: %s", *save)
+	}
+	if maxMemoryBytes > 0 {
+		log.Printf("Max memory: %d bytes, policy %s", maxMemoryBytes, evictionPolicy)
+	}
 	log.Printf("Max clients: %d", *maxClients)
 	if *requirePass != "" {
 		log.Printf("Authentication: enabled")
@@ -107,7 +207,23 @@ func main() {
 	}
 
 	// Create engine
-	e, err := engine.New(walPath)
+	engineCfg := engine.DefaultConfig()
+	engineCfg.SyncPolicy = syncPolicy
+	engineCfg.AutoRewritePercentage = *rewritePct
+	engineCfg.AutoRewriteMinSize = int64(*rewriteMinMB) << 20
+	engineCfg.SaveRules = saveRules
+	engineCfg.Databases = *databases
+	engineCfg.MaxMemory = maxMemoryBytes
+	engineCfg.MaxMemoryPolicy = evictionPolicy
+	engineCfg.MaxMemorySamples = *maxMemorySamples
+	engineCfg.NotifyKeyspaceEvents = keyspaceEvents
+	engineCfg.SnapshotRetention = engine.RetentionPolicy{
+		KeepLast:   *keepLast,
+		KeepHourly: *keepHourly,
+		KeepDaily:  *keepDaily,
+		MaxAge:     *maxAge,
+	}
+	e, err := engine.NewWithConfig(walPath, engineCfg)
 	if err != nil {
 		log.Fatalf("Failed to create engine: %v", err)
 	}
@@ -125,6 +241,7 @@ func main() {
 		SlowLogThreshold: time.Duration(*slowLogUS) * time.Microsecond,
 		SlowLogMaxLen:    128,
 		APIToken:         *apiToken,
+		ScriptTimeLimit:  time.Duration(*luaTimeLimitMS) * time.Millisecond,
 	}
 
 	// Create server
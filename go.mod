@@ -2,7 +2,10 @@ module github.com/flashdb/flashdb
 
 go 1.22
 
-require github.com/stretchr/testify v1.9.0
+require (
+	github.com/stretchr/testify v1.9.0
+	github.com/yuin/gopher-lua v1.1.1
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
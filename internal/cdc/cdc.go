@@ -25,6 +25,7 @@ const (
 	OpZAdd   OpType = "ZADD"
 	OpZRem   OpType = "ZREM"
 	OpTSAdd  OpType = "TS.ADD"
+	OpEvict  OpType = "EVICT"
 )
 
 // Event represents a single mutation captured by CDC.
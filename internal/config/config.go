@@ -23,6 +23,20 @@ type Config struct {
 
 	// Persistence
 	SyncWrites bool `json:"sync_writes"`
+	// AppendFsync selects the WAL fsync policy: "always", "everysec" or "no".
+	// When empty, SyncWrites picks "always" (true) or "everysec" (false).
+	AppendFsync string `json:"appendfsync"`
+}
+
+// FsyncPolicy returns the effective WAL fsync policy name.
+func (c *Config) FsyncPolicy() string {
+	if c.AppendFsync != "" {
+		return c.AppendFsync
+	}
+	if c.SyncWrites {
+		return "always"
+	}
+	return "everysec"
 }
 
 // DefaultConfig returns the default configuration.
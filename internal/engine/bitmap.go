@@ -0,0 +1,318 @@
+package engine
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/flashdb/flashdb/internal/store"
+	"github.com/flashdb/flashdb/internal/wal"
+)
+
+// BitFieldKind is a BITFIELD subcommand.
+type BitFieldKind uint8
+
+// BITFIELD subcommands.
+const (
+	BitFieldGet BitFieldKind = iota
+	BitFieldSet
+	BitFieldIncrBy
+)
+
+// BitFieldOp is one BITFIELD subcommand. Value is the value to set or the
+// increment; Overflow applies to SET and INCRBY.
+type BitFieldOp struct {
+	Kind     BitFieldKind
+	Type     store.BitFieldType
+	Offset   uint64
+	Value    int64
+	Overflow store.BitFieldOverflow
+}
+
+// BitFieldResult is the reply to one BITFIELD subcommand: the field read,
+// the field's previous value for SET, or its new value for INCRBY. OK is
+// false when a write failed under OVERFLOW FAIL.
+type BitFieldResult struct {
+	Value int64
+	OK    bool
+}
+
+// encodeSetRange encodes an offset + bytes for a SETRANGE WAL record.
+// Format: offset(4 bytes LE) + bytes
+func encodeSetRange(offset int, value []byte) []byte {
+	buf := make([]byte, 4+len(value))
+	binary.LittleEndian.PutUint32(buf[:4], uint32(offset))
+	copy(buf[4:], value)
+	return buf
+}
+
+func decodeSetRange(data []byte) (int, []byte) {
+	if len(data) < 4 {
+		return 0, nil
+	}
+	return int(binary.LittleEndian.Uint32(data[:4])), data[4:]
+}
+
+// setRange logs and applies a write of value into the string at key at
+// offset (must hold e.mu). Only the changed bytes are logged, so flipping a
+// bit in a large bitmap stays cheap; the key keeps its TTL.
+func (e *Engine) setRange(key string, offset int, value []byte) error {
+	rec := wal.Record{
+		Type:  wal.OpSetRange,
+		Key:   []byte(key),
+		Value: encodeSetRange(offset, value),
+	}
+	if err := e.writeWAL(rec); err != nil {
+		return fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+	e.store.SetRange(key, offset, value)
+	return nil
+}
+
+// SetRange overwrites the string at key from offset with value, padding it
+// with zero bytes, and returns its new length. An empty value leaves the key
+// alone and does not create it.
+func (e *Engine) SetRange(key string, offset int, value []byte) (_ int, err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.checkType(key, store.TypeString); err != nil {
+		e.recordCommand()
+		return 0, err
+	}
+	if len(value) == 0 {
+		e.recordCommand()
+		return e.store.StrLen(key)
+	}
+	if offset > store.MaxStringSize-len(value) {
+		e.recordCommand()
+		return 0, store.ErrStringTooLong
+	}
+
+	if err := e.setRange(key, offset, value); err != nil {
+		return 0, err
+	}
+	e.notify(NotifyString, "setrange", key)
+	e.recordWrite()
+	return e.store.StrLen(key)
+}
+
+// SetBit sets the bit at offset in the string at key to bit and returns the
+// bit's previous value. The string is padded with zero bytes as needed.
+func (e *Engine) SetBit(key string, offset uint64, bit int) (_ int, err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.checkType(key, store.TypeString); err != nil {
+		e.recordCommand()
+		return 0, err
+	}
+	if offset/8 >= store.MaxStringSize {
+		e.recordCommand()
+		return 0, store.ErrStringTooLong
+	}
+
+	value, _, _ := e.store.Get(key)
+	i := offset / 8
+	var current []byte
+	if i < uint64(len(value)) {
+		current = value[i : i+1]
+	}
+	updated, old := store.SetBit(append([]byte(nil), current...), offset%8, bit)
+	if old == bit && current != nil {
+		e.recordCommand()
+		return old, nil
+	}
+
+	if err := e.setRange(key, int(i), updated); err != nil {
+		return 0, err
+	}
+	e.notify(NotifyString, "setbit", key)
+	e.recordWrite()
+	return old, nil
+}
+
+// GetBit returns the bit at offset in the string at key.
+func (e *Engine) GetBit(key string, offset uint64) (int, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	e.recordRead()
+
+	value, _, err := e.store.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return store.GetBit(value, offset), nil
+}
+
+// BitCount counts the set bits of the string at key between start and end
+// inclusive. Negative indices count from the end; they are byte indices
+// unless bitUnit.
+func (e *Engine) BitCount(key string, start, end int64, bitUnit bool) (int64, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	e.recordRead()
+
+	value, _, err := e.store.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return store.BitCount(value, start, end, bitUnit), nil
+}
+
+// BitPos returns the offset of the first bit set to bit in the string at key
+// between start and end, or -1; see store.BitPos. A missing key reads as an
+// endless run of zero bits.
+func (e *Engine) BitPos(key string, bit int, start, end int64, hasEnd, bitUnit bool) (int64, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	e.recordRead()
+
+	value, exists, err := e.store.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		if bit == 0 {
+			return 0, nil
+		}
+		return -1, nil
+	}
+	return store.BitPos(value, bit, start, end, hasEnd, bitUnit), nil
+}
+
+// BitOp stores the result of combining the strings at keys in dest and
+// returns its length; see store.BitOp. Missing keys count as empty strings.
+// dest loses its TTL, and is deleted when the result is empty.
+func (e *Engine) BitOp(op store.BitOpKind, dest string, keys ...string) (_ int, err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	srcs := make([][]byte, len(keys))
+	for i, key := range keys {
+		if err := e.checkType(key, store.TypeString); err != nil {
+			e.recordCommand()
+			return 0, err
+		}
+		srcs[i], _, _ = e.store.Get(key)
+	}
+	result := store.BitOp(op, srcs)
+
+	e.expireIfNeeded(dest)
+	if len(result) == 0 {
+		if !e.store.Exists(dest) {
+			e.recordCommand()
+			return 0, nil
+		}
+		if err := e.writeWAL(wal.Record{Type: wal.OpDelete, Key: []byte(dest)}); err != nil {
+			return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
+		}
+		e.store.Delete(dest)
+		e.notify(NotifyGeneric, "del", dest)
+		e.recordWrite()
+		return 0, nil
+	}
+
+	if err := e.writeWAL(wal.Record{Type: wal.OpSet, Key: []byte(dest), Value: result}); err != nil {
+		return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+	e.store.Set(dest, result)
+	e.notify(NotifyString, "set", dest)
+	e.recordWrite()
+	return len(result), nil
+}
+
+// BitField runs ops in order against the string at key and returns one
+// result per op. Writes pad the string with zero bytes up to the furthest
+// field written, even when they fail under OVERFLOW FAIL; only the changed
+// bytes are logged. Ops that only read never create the key.
+func (e *Engine) BitField(key string, ops []BitFieldOp) (_ []BitFieldResult, err error) {
+	writes := false
+	var need uint64
+	for _, op := range ops {
+		if op.Kind != BitFieldGet {
+			writes = true
+			need = max(need, (op.Offset+uint64(op.Type.Bits)+7)/8)
+		}
+	}
+	if !writes {
+		e.mu.RLock()
+		defer e.mu.RUnlock()
+		e.recordRead()
+
+		value, _, err := e.store.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		return runBitField(value, ops), nil
+	}
+
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.checkType(key, store.TypeString); err != nil {
+		e.recordCommand()
+		return nil, err
+	}
+	if need > store.MaxStringSize {
+		e.recordCommand()
+		return nil, store.ErrStringTooLong
+	}
+
+	old, _, _ := e.store.Get(key)
+	value := append([]byte(nil), old...)
+	if uint64(len(value)) < need {
+		value = append(value, make([]byte, need-uint64(len(value)))...)
+	}
+	results := runBitField(value, ops)
+
+	// Log the span from the first changed byte to the last one, or to the
+	// end of the string when it grew.
+	lo, hi := 0, len(value)
+	for lo < len(old) && old[lo] == value[lo] {
+		lo++
+	}
+	for hi > lo && hi <= len(old) && old[hi-1] == value[hi-1] {
+		hi--
+	}
+	if lo == hi {
+		e.recordCommand()
+		return results, nil
+	}
+
+	if err := e.setRange(key, lo, value[lo:hi]); err != nil {
+		return nil, err
+	}
+	e.notify(NotifyString, "setbit", key)
+	e.recordWrite()
+	return results, nil
+}
+
+// runBitField applies ops to value in place; value must already be long
+// enough for every write.
+func runBitField(value []byte, ops []BitFieldOp) []BitFieldResult {
+	results := make([]BitFieldResult, len(ops))
+	for i, op := range ops {
+		current := store.GetBitField(value, op.Offset, op.Type)
+		switch op.Kind {
+		case BitFieldGet:
+			results[i] = BitFieldResult{Value: current, OK: true}
+		case BitFieldSet:
+			v, ok := store.BitFieldAdd(op.Value, 0, op.Type, op.Overflow)
+			if ok {
+				store.SetBitField(value, op.Offset, op.Type, v)
+			}
+			results[i] = BitFieldResult{Value: current, OK: ok}
+		case BitFieldIncrBy:
+			v, ok := store.BitFieldAdd(current, op.Value, op.Type, op.Overflow)
+			if ok {
+				store.SetBitField(value, op.Offset, op.Type, v)
+			}
+			results[i] = BitFieldResult{Value: v, OK: ok}
+		}
+	}
+	return results
+}
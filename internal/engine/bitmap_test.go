@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flashdb/flashdb/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngine_Bitmaps(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+
+	old, err := e.SetBit("dau", 7, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 0, old)
+	_, err = e.Expire("dau", time.Hour)
+	require.NoError(t, err)
+
+	// Flipping a bit far into a large bitmap logs only the changed byte.
+	_, err = e.SetBit("dau", 1<<20, 1)
+	require.NoError(t, err)
+	size := e.wal.Size()
+	_, err = e.SetBit("dau", 1<<20+1, 1)
+	require.NoError(t, err)
+	assert.Less(t, e.wal.Size()-size, int64(64))
+	assert.Greater(t, e.TTL("dau"), int64(3500), "bit writes keep the TTL")
+
+	n, err := e.BitCount("dau", 0, -1, false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	pos, err := e.BitPos("dau", 1, 1, -1, false, false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1<<20), pos)
+	pos, err = e.BitPos("missing", 0, 0, -1, false, false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), pos)
+
+	// SETRANGE and GETRANGE see the same bytes.
+	length, err := e.SetRange("s", 1, []byte{0xFF})
+	require.NoError(t, err)
+	assert.Equal(t, 2, length)
+	bit, err := e.GetBit("s", 8)
+	require.NoError(t, err)
+	assert.Equal(t, 1, bit)
+	length, err = e.SetRange("missing", 5, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, length)
+	assert.False(t, e.Exists("missing"))
+	_, err = e.SetRange("s", store.MaxStringSize, []byte("x"))
+	assert.ErrorIs(t, err, store.ErrStringTooLong)
+
+	i8, _ := store.ParseBitFieldType("i8")
+	u4, _ := store.ParseBitFieldType("u4")
+	results, err := e.BitField("bf", []BitFieldOp{
+		{Kind: BitFieldSet, Type: i8, Offset: 0, Value: 100},
+		{Kind: BitFieldIncrBy, Type: i8, Offset: 0, Value: 100, Overflow: store.OverflowFail},
+		{Kind: BitFieldIncrBy, Type: i8, Offset: 0, Value: 100, Overflow: store.OverflowSat},
+		{Kind: BitFieldIncrBy, Type: u4, Offset: 12, Value: 17},
+		{Kind: BitFieldGet, Type: u4, Offset: 8},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []BitFieldResult{{0, true}, {0, false}, {127, true}, {1, true}, {0, true}}, results)
+	results, err = e.BitField("ro", []BitFieldOp{{Kind: BitFieldGet, Type: u4, Offset: 100}})
+	require.NoError(t, err)
+	assert.Equal(t, []BitFieldResult{{0, true}}, results)
+	assert.False(t, e.Exists("ro"))
+
+	length, err = e.BitOp(store.BitOr, "or", "s", "bf")
+	require.NoError(t, err)
+	assert.Equal(t, 2, length)
+	length, err = e.BitOp(store.BitAnd, "or", "missing", "nothing")
+	require.NoError(t, err)
+	assert.Equal(t, 0, length)
+	assert.False(t, e.Exists("or"))
+	require.NoError(t, e.Set("dest", []byte("x")))
+	_, err = e.BitOp(store.BitNot, "dest", "bf")
+	require.NoError(t, err)
+
+	_, err = e.LPush("list", []byte("a"))
+	require.NoError(t, err)
+	_, err = e.SetBit("list", 0, 1)
+	assert.ErrorIs(t, err, store.ErrWrongType)
+	_, err = e.BitOp(store.BitOr, "dest", "list")
+	assert.ErrorIs(t, err, store.ErrWrongType)
+
+	want := map[string][]byte{}
+	for _, key := range []string{"dau", "s", "bf", "dest"} {
+		want[key], _, _ = e.Get(key)
+	}
+	require.NoError(t, e.Close())
+
+	e2, err := New(walPath)
+	require.NoError(t, err)
+	defer e2.Close()
+	for key, value := range want {
+		got, ok, err := e2.Get(key)
+		require.NoError(t, err)
+		require.True(t, ok, key)
+		assert.Equal(t, value, got, key)
+	}
+	assert.Equal(t, []byte{127, 0x01}, want["bf"])
+	assert.Equal(t, []byte{0x80, 0xFE}, want["dest"])
+	assert.Greater(t, e2.TTL("dau"), int64(3500))
+}
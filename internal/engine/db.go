@@ -0,0 +1,226 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/flashdb/flashdb/internal/store"
+	"github.com/flashdb/flashdb/internal/wal"
+)
+
+var (
+	// ErrDBIndex is returned for a database number outside the configured
+	// range.
+	ErrDBIndex = errors.New("engine: DB index is out of range")
+	// ErrSameDB is returned when moving a key to the database it is in.
+	ErrSameDB = errors.New("engine: source and destination objects are the same")
+)
+
+// KeyspaceStats describes the keys of one database.
+type KeyspaceStats struct {
+	DB      int
+	Keys    int
+	Expires int // keys with a TTL
+}
+
+// Select returns the view of database n. All views share the WAL, the
+// statistics and the background tasks; each has its own keyspace.
+func (e *Engine) Select(n int) (*Engine, error) {
+	if err := e.checkDB(n); err != nil {
+		return nil, err
+	}
+	return e.dbs[n], nil
+}
+
+// DB returns the number of the database e is a view of.
+func (e *Engine) DB() int {
+	return e.db
+}
+
+// Databases returns the number of databases.
+func (e *Engine) Databases() int {
+	return len(e.dbs)
+}
+
+// checkDB returns ErrDBIndex unless n is a database number.
+func (c *core) checkDB(n int) error {
+	if n < 0 || n >= len(c.dbs) {
+		return fmt.Errorf("%w: %d", ErrDBIndex, n)
+	}
+	return nil
+}
+
+// checkRecord returns ErrDBIndex unless the databases rec refers to exist.
+func (c *core) checkRecord(rec wal.Record) error {
+	if err := c.checkDB(rec.DB); err != nil {
+		return err
+	}
+	switch rec.Type {
+	case wal.OpSwapDB, wal.OpMove:
+		return c.checkDB(decodeDB(rec.Value))
+	}
+	return nil
+}
+
+// holder returns the view whose keyspace is s (must hold c.mu, for reading
+// or writing).
+func (c *core) holder(s *store.Store) *Engine {
+	for _, db := range c.dbs {
+		if db.store == s {
+			return db
+		}
+	}
+	return c.dbs[0]
+}
+
+// keysCount returns the number of keys over all databases.
+func (c *core) keysCount() int {
+	n := 0
+	for _, db := range c.dbs {
+		n += db.store.Size()
+	}
+	return n
+}
+
+// flush removes all keys and search indexes of the database (must hold
+// e.mu).
+func (e *Engine) flush() {
+	e.resetIndexes()
+	e.store.Clear()
+}
+
+// Move moves key, with its TTL, to database db. It returns false if key
+// does not exist or db already has a key by that name.
+func (e *Engine) Move(key string, db int) (_ bool, err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.checkDB(db); err != nil {
+		e.recordCommand()
+		return false, err
+	}
+	if db == e.db {
+		e.recordCommand()
+		return false, ErrSameDB
+	}
+	dst := e.dbs[db]
+	if !e.store.Exists(key) || dst.store.Exists(key) {
+		e.recordCommand()
+		return false, nil
+	}
+
+	rec := wal.Record{
+		Type:  wal.OpMove,
+		Key:   []byte(key),
+		Value: encodeDB(db),
+	}
+	if err := e.writeWAL(rec); err != nil {
+		return false, fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+
+	e.store.MoveTo(key, dst.store)
+	dst.keyReady(key)
+	dst.syncIndexes()
+	e.notify(NotifyGeneric, "move_from", key)
+	dst.notify(NotifyGeneric, "move_to", key)
+	e.recordWrite()
+	return true, nil
+}
+
+// SwapDB swaps the contents of databases a and b, so that clients of one
+// see the keys of the other. Search indexes and time series follow their
+// keys.
+func (e *Engine) SwapDB(a, b int) (err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.checkDB(a); err != nil {
+		e.recordCommand()
+		return err
+	}
+	if err := e.checkDB(b); err != nil {
+		e.recordCommand()
+		return err
+	}
+	if a == b {
+		e.recordCommand()
+		return nil
+	}
+
+	rec := wal.Record{Type: wal.OpSwapDB, Value: encodeDB(b)}
+	if err := e.dbs[a].writeWAL(rec); err != nil {
+		return fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+
+	e.dbs[a].touchAllWatched()
+	e.dbs[b].touchAllWatched()
+	e.dbs[a].swap(e.dbs[b])
+	e.dbs[a].touchAllWatched()
+	e.dbs[b].touchAllWatched()
+	e.recordWrite()
+	return nil
+}
+
+// swap exchanges the contents of two databases (must hold e.mu).
+func (e *Engine) swap(o *Engine) {
+	e.store, o.store = o.store, e.store
+	e.timeseries, o.timeseries = o.timeseries, e.timeseries
+	e.indexes, o.indexes = o.indexes, e.indexes
+}
+
+// FlushDB removes all keys and search indexes of the database. The other
+// databases are left alone; Clear empties them all.
+func (e *Engine) FlushDB() (err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.writeWAL(wal.Record{Type: wal.OpFlushDB}); err != nil {
+		return fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+
+	e.touchAllWatched()
+	e.flush()
+	e.recordWrite()
+	return nil
+}
+
+// Keyspace returns the key counts of the databases that hold keys.
+func (e *Engine) Keyspace() []KeyspaceStats {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	e.recordRead()
+
+	var out []KeyspaceStats
+	for _, db := range e.dbs {
+		if n := db.store.Size(); n > 0 {
+			out = append(out, KeyspaceStats{DB: db.db, Keys: n, Expires: db.store.Expires()})
+		}
+	}
+	return out
+}
+
+// applyDB replays a record that moves data between databases.
+func (e *Engine) applyDB(rec wal.Record) {
+	switch rec.Type {
+	case wal.OpFlushDB:
+		e.flush()
+	case wal.OpSwapDB:
+		e.swap(e.dbs[decodeDB(rec.Value)])
+	case wal.OpMove:
+		dst := e.dbs[decodeDB(rec.Value)]
+		e.store.MoveTo(string(rec.Key), dst.store)
+		dst.syncIndexes()
+	}
+}
+
+func encodeDB(db int) []byte {
+	return appendRecordInt(nil, int64(db))
+}
+
+func decodeDB(b []byte) int {
+	r := recordReader{b}
+	return int(r.int64())
+}
@@ -0,0 +1,163 @@
+package engine
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func selectDB(t *testing.T, e *Engine, n int) *Engine {
+	t.Helper()
+	db, err := e.Select(n)
+	require.NoError(t, err)
+	return db
+}
+
+func TestEngine_Databases(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+	defer e.Close()
+
+	assert.Equal(t, 16, e.Databases())
+	assert.Equal(t, 0, e.DB())
+	_, err = e.Select(16)
+	assert.ErrorIs(t, err, ErrDBIndex)
+	_, err = e.Select(-1)
+	assert.ErrorIs(t, err, ErrDBIndex)
+
+	db1 := selectDB(t, e, 1)
+	assert.Equal(t, 1, db1.DB())
+	require.NoError(t, e.Set("k", []byte("zero")))
+	require.NoError(t, db1.Set("k", []byte("one")))
+	require.NoError(t, db1.Set("other", []byte("x")))
+
+	val, ok, err := e.Get("k")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("zero"), val)
+	val, _, err = db1.Get("k")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("one"), val)
+	assert.Equal(t, 1, e.Size())
+	assert.Equal(t, 2, db1.Size())
+	assert.Equal(t, 3, e.GetStats().KeysCount)
+
+	_, err = db1.Expire("other", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, []KeyspaceStats{
+		{DB: 0, Keys: 1},
+		{DB: 1, Keys: 2, Expires: 1},
+	}, e.Keyspace())
+
+	// FLUSHDB only empties its own database.
+	require.NoError(t, db1.FlushDB())
+	assert.Equal(t, 0, db1.Size())
+	assert.Equal(t, 1, e.Size())
+
+	require.NoError(t, db1.Set("k", []byte("one")))
+	require.NoError(t, e.Clear())
+	assert.Equal(t, 0, e.GetStats().KeysCount)
+}
+
+func TestEngine_MoveAndSwapDB(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+
+	db2 := selectDB(t, e, 2)
+	require.NoError(t, e.Set("a", []byte("1")))
+	_, err = e.Expire("a", time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, e.Set("b", []byte("2")))
+	require.NoError(t, db2.Set("b", []byte("taken")))
+
+	ok, err := e.Move("a", 2)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, e.Exists("a"))
+	assert.True(t, db2.Exists("a"))
+	assert.Greater(t, db2.TTL("a"), int64(60))
+
+	ok, err = e.Move("b", 2)
+	require.NoError(t, err)
+	assert.False(t, ok, "destination key exists")
+	ok, err = e.Move("missing", 2)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, err = e.Move("b", 0)
+	assert.ErrorIs(t, err, ErrSameDB)
+	_, err = e.Move("b", 99)
+	assert.ErrorIs(t, err, ErrDBIndex)
+
+	require.NoError(t, e.SwapDB(0, 2))
+	val, _, err := e.Get("b")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("taken"), val)
+	assert.True(t, e.Exists("a"))
+	val, _, err = db2.Get("b")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("2"), val)
+	assert.ErrorIs(t, e.SwapDB(0, 16), ErrDBIndex)
+	require.NoError(t, e.Close())
+
+	// Replaying the WAL, a rewritten WAL and a snapshot all bring back
+	// the same databases.
+	check := func(e *Engine) {
+		t.Helper()
+		db2 := selectDB(t, e, 2)
+		val, _, err := e.Get("b")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("taken"), val)
+		assert.True(t, e.Exists("a"))
+		val, _, err = db2.Get("b")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("2"), val)
+		assert.Equal(t, 1, db2.Size())
+	}
+	e, err = New(walPath)
+	require.NoError(t, err)
+	check(e)
+
+	require.NoError(t, e.Rewrite())
+	require.NoError(t, e.Close())
+	e, err = New(walPath)
+	require.NoError(t, err)
+	check(e)
+
+	_, err = e.SnapshotCreate("dbs")
+	require.NoError(t, err)
+	require.NoError(t, e.Close())
+	e, err = New(walPath)
+	require.NoError(t, err)
+	check(e)
+
+	require.NoError(t, e.Clear())
+	require.NoError(t, e.SnapshotRestore("dbs"))
+	check(e)
+	require.NoError(t, e.Close())
+}
+
+func TestEngine_DatabasesOutOfRange(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+	require.NoError(t, selectDB(t, e, 5).Set("k", []byte("v")))
+	require.NoError(t, e.Close())
+
+	// A log written with more databases than configured is not silently
+	// truncated.
+	cfg := DefaultConfig()
+	cfg.Databases = 4
+	_, err = NewWithConfig(walPath, cfg)
+	assert.ErrorIs(t, err, ErrDBIndex)
+
+	cfg.Databases = 6
+	e, err = NewWithConfig(walPath, cfg)
+	require.NoError(t, err)
+	assert.True(t, selectDB(t, e, 5).Exists("k"))
+	require.NoError(t, e.Close())
+}
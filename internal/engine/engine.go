@@ -1,5 +1,6 @@
 // Package engine provides the storage engine that coordinates WAL and in-memory store.
-// All write operations follow the pattern: WAL append -> apply -> respond.
+// All write operations follow the pattern: WAL append -> apply -> sync -> respond,
+// where the sync step depends on the configured fsync policy.
 package engine
 
 import (
@@ -15,6 +16,7 @@ import (
 
 	"github.com/flashdb/flashdb/internal/cdc"
 	"github.com/flashdb/flashdb/internal/hotkeys"
+	"github.com/flashdb/flashdb/internal/search"
 	"github.com/flashdb/flashdb/internal/snapshot"
 	"github.com/flashdb/flashdb/internal/store"
 	"github.com/flashdb/flashdb/internal/timeseries"
@@ -27,210 +29,505 @@ type Stats struct {
 	TotalReads    int64
 	TotalWrites   int64
 	StartTime     time.Time
-	KeysCount     int
+	KeysCount     int // over all databases
 	ExpiredKeys   int64
+	EvictedKeys   int64
+}
+
+// Config holds engine persistence settings.
+type Config struct {
+	// SyncPolicy controls when WAL writes are fsynced (always, everysec or no).
+	SyncPolicy wal.SyncPolicy
+
+	// AutoRewritePercentage starts a background WAL rewrite once the log has
+	// grown by this percentage over its size after the last rewrite (0 = disabled).
+	AutoRewritePercentage int
+	// AutoRewriteMinSize is the smallest WAL size, in bytes, that is rewritten automatically.
+	AutoRewriteMinSize int64
+
+	// SaveRules take a background snapshot when any of them matches (empty = disabled).
+	SaveRules []SaveRule
+	// SnapshotRetention prunes the snapshots taken by SaveRules.
+	SnapshotRetention RetentionPolicy
+
+	// Databases is the number of logical databases, numbered from 0 (at least 1).
+	Databases int
+
+	// MaxMemory is the limit, in bytes, on the memory used by keys (0 = no
+	// limit); see FreeMemory. MaxMemoryPolicy picks the keys evicted to stay
+	// under it, from MaxMemorySamples keys sampled per database.
+	MaxMemory        int64
+	MaxMemoryPolicy  store.EvictionPolicy
+	MaxMemorySamples int
+
+	// NotifyKeyspaceEvents selects the keyspace events passed to the
+	// OnKeyspaceEvent hook (0 = none).
+	NotifyKeyspaceEvents KeyspaceEvents
+}
+
+// DefaultConfig returns the default engine configuration.
+func DefaultConfig() Config {
+	return Config{
+		SyncPolicy:            wal.SyncAlways,
+		AutoRewritePercentage: 100,
+		AutoRewriteMinSize:    64 << 20,
+		SnapshotRetention:     RetentionPolicy{KeepHourly: 24, KeepDaily: 7},
+		Databases:             16,
+		MaxMemorySamples:      DefaultMaxMemorySamples,
+	}
 }
 
 // Engine coordinates the WAL and in-memory store for durable key-value storage.
+// Each logical database is served by its own Engine: New returns the one for
+// database 0 and Select the others. They share everything but their data,
+// including the lock, the WAL and the statistics.
 // It is safe for concurrent use by multiple goroutines.
 type Engine struct {
-	mu    sync.RWMutex
-	txMu  sync.Mutex // Serializes EXEC transactions
-	store *store.Store
-	wal   *wal.WAL
+	*core
+	db int
+
+	// mu is the core's lock, or does nothing in the views of a transaction,
+	// which holds the core's lock throughout; see Tx.
+	mu rwLocker
+
+	// The database's data; SwapDB exchanges it between two Engines
+	// (guarded by mu).
+	store      *store.Store
+	timeseries *timeseries.Store
+	indexes    map[string]*search.Index // search indexes by name (their contents guarded by idxMu)
+}
+
+// core is the state shared by all databases of an Engine.
+type core struct {
+	mu     sync.RWMutex
+	wal    *wal.WAL
+	cfg    Config
+	closed bool
+	dbs    []*Engine // by number
+
+	// Background tasks (WAL rewrites and snapshots)
+	stopBg            chan struct{}
+	closeDone         chan struct{} // closed once the engine is closed
+	bgWG              sync.WaitGroup
+	rewriting         atomic.Bool
+	rewrites          atomic.Int64
+	lastRewriteFailed atomic.Bool
+	lastRewriteTime   atomic.Int64
+	walBaseSize       atomic.Int64
+	walCheckpoint     string // snapshot the WAL starts from, "" if the WAL is self-contained (guarded by mu)
+
+	snapshotting       atomic.Bool
+	snapMu             sync.Mutex
+	snapshotCur        snapshot.Meta // snapshot being written (guarded by snapMu)
+	snapshotTotal      atomic.Int64  // entries in the snapshot being written
+	snapshotDone       atomic.Int64  // entries written so far
+	snapshots          atomic.Int64
+	lastSnapshotFailed atomic.Bool
+	lastSnapshotTime   atomic.Int64
+
+	dirty       atomic.Int64 // writes since the last save
+	lastSave    atomic.Int64 // unix nanos of the last successful save
+	lastSaveTry atomic.Int64 // unix nanos of the last snapshot started by save rules
 
 	startTime     time.Time
 	totalCommands atomic.Int64
 	totalReads    atomic.Int64
 	totalWrites   atomic.Int64
 	expiredKeys   atomic.Int64
+	evictedKeys   atomic.Int64
+
+	// Memory limit, see eviction.go.
+	maxMemory        atomic.Int64
+	evictionPolicy   atomic.Uint32
+	maxMemorySamples atomic.Int64
 
 	// Phase 6 subsystems
-	hotkeys    *hotkeys.Tracker
-	timeseries *timeseries.Store
-	cdc        *cdc.Stream
-	snapMgr    *snapshot.Manager
+	hotkeys *hotkeys.Tracker
+	cdc     *cdc.Stream
+	snapMgr *snapshot.Manager
+
+	keyReadyHook func(db int, key string) // called when values are added to a list or stream (guarded by mu)
+
+	// Keyspace notifications, see notify.go.
+	keyspaceEvents atomic.Uint32
+	keyspaceHook   func(db int, flags KeyspaceEvents, event, key string) // guarded by mu
+
+	// A transaction's WAL records, written together when it commits; see
+	// tx.go (guarded by mu).
+	inTx       bool
+	txRecords  []wal.Record
+	txClearEnd int // len(txRecords) after the transaction's last Clear, 0 if none
+
+	watched map[watchedKey]map[*Watch]bool // watches by key, true if the key existed when watched; see watch.go (guarded by mu)
+
+	idxMu sync.Mutex // guards the contents of search indexes
 }
 
-// New creates a new Engine with the specified WAL path.
+// New creates a new Engine with the specified WAL path and default configuration.
 // It recovers any existing data from the WAL on startup.
 func New(walPath string) (*Engine, error) {
-	w, err := wal.Open(walPath)
+	return NewWithConfig(walPath, DefaultConfig())
+}
+
+// NewWithConfig creates a new Engine with the specified WAL path and configuration.
+func NewWithConfig(walPath string, cfg Config) (*Engine, error) {
+	w, err := wal.OpenWithPolicy(walPath, cfg.SyncPolicy)
 	if err != nil {
 		return nil, fmt.Errorf("engine: failed to open WAL: %w", err)
 	}
 
-	s := store.New()
-
 	// Snapshot directory lives next to the WAL file.
 	snapDir := fmt.Sprintf("%s/snapshots", filepath.Dir(walPath))
 	sm, err := snapshot.NewManager(snapDir)
 	if err != nil {
 		w.Close()
-		s.Close()
 		return nil, fmt.Errorf("engine: failed to init snapshot manager: %w", err)
 	}
 
-	e := &Engine{
-		store:      s,
-		wal:        w,
-		startTime:  time.Now(),
-		hotkeys:    hotkeys.New(100, 60*time.Second),
-		timeseries: timeseries.New(),
-		cdc:        cdc.NewStream(50000),
-		snapMgr:    sm,
+	c := &core{
+		wal:       w,
+		cfg:       cfg,
+		stopBg:    make(chan struct{}),
+		closeDone: make(chan struct{}),
+		startTime: time.Now(),
+		hotkeys:   hotkeys.New(100, 60*time.Second),
+		cdc:       cdc.NewStream(50000),
+		snapMgr:   sm,
+	}
+	c.initMemory(cfg)
+	c.keyspaceEvents.Store(uint32(cfg.NotifyKeyspaceEvents))
+	c.dbs = make([]*Engine, max(cfg.Databases, 1))
+	for i := range c.dbs {
+		c.dbs[i] = &Engine{
+			core:       c,
+			db:         i,
+			mu:         &c.mu,
+			store:      store.New(),
+			timeseries: timeseries.New(),
+			indexes:    make(map[string]*search.Index),
+		}
 	}
+	e := c.dbs[0]
 
-	// Recover from WAL
+	// Recover from WAL. Expiration is frozen during replay so every record
+	// applies to the same state it was logged against; keys whose TTL passed
+	// while the server was down are collected afterwards.
+	for _, db := range c.dbs {
+		db.store.PauseExpiry(true)
+	}
 	if err := e.recover(); err != nil {
 		w.Close()
-		s.Close()
+		for _, db := range c.dbs {
+			db.store.Close()
+			db.timeseries.Close()
+		}
 		return nil, fmt.Errorf("engine: failed to recover: %w", err)
 	}
+	for _, db := range c.dbs {
+		s := db.store
+		s.PauseExpiry(false)
+		// The store may have moved to another database since, see SwapDB.
+		s.OnExpire(&c.mu, func(keys []string) { c.holder(s).logExpired(keys) })
+	}
+
+	e.walBaseSize.Store(w.Size())
+	e.lastSave.Store(e.startTime.UnixNano())
+	e.bgWG.Add(1)
+	go e.persistenceLoop()
 
 	return e, nil
 }
 
-// recover replays the WAL to restore state.
+// recover restores state from the newest usable checkpoint snapshot and
+// replays the WAL records that follow it.
 func (e *Engine) recover() error {
 	records, err := e.wal.ReadAll()
 	if err != nil {
 		return err
 	}
 
-	for _, rec := range records {
-		switch rec.Type {
-		case wal.OpSet:
-			e.store.Set(string(rec.Key), rec.Value)
-		case wal.OpSetWithTTL:
-			if rec.ExpireAt > 0 {
-				expireTime := time.UnixMilli(rec.ExpireAt)
-				if time.Now().Before(expireTime) {
-					entry := &store.Entry{
-						Value:     rec.Value,
-						ExpireAt:  expireTime,
-						HasExpire: true,
-					}
-					e.store.SetEntry(string(rec.Key), entry)
-				}
-				// Skip expired keys during recovery
-			} else {
-				e.store.Set(string(rec.Key), rec.Value)
-			}
-		case wal.OpDelete:
-			e.store.Delete(string(rec.Key))
-		case wal.OpExpire:
-			if rec.ExpireAt > 0 {
-				expireTime := time.UnixMilli(rec.ExpireAt)
-				ttl := time.Until(expireTime)
-				if ttl > 0 {
-					e.store.Expire(string(rec.Key), ttl)
-				}
-			}
-		case wal.OpPersist:
-			e.store.Persist(string(rec.Key))
-
-		// Sorted set recovery
-		case wal.OpZAdd:
-			member, score := decodeZMember(rec.Value)
-			e.store.ZAdd(string(rec.Key), store.ScoredMember{Member: member, Score: score})
-		case wal.OpZRem:
-			e.store.ZRem(string(rec.Key), string(rec.Value))
-		case wal.OpZIncrBy:
-			member, increment := decodeZMember(rec.Value)
-			e.store.ZIncrBy(string(rec.Key), member, increment)
-		case wal.OpZRemRangeByRank:
-			start, stop := decodeRankRange(rec.Value)
-			e.store.ZRemRangeByRank(string(rec.Key), start, stop)
-		case wal.OpZRemRangeByScore:
-			min, max := decodeScoreRange(rec.Value)
-			e.store.ZRemRangeByScore(string(rec.Key), min, max)
-
-		// Hash recovery
-		case wal.OpHSet:
-			field, value := decodeHashField(rec.Value)
-			e.store.HSet(string(rec.Key), store.HashFieldValue{Field: field, Value: value})
-		case wal.OpHDel:
-			e.store.HDel(string(rec.Key), string(rec.Value))
-
-		// List recovery
-		case wal.OpLPush:
-			e.store.LPush(string(rec.Key), rec.Value)
-		case wal.OpRPush:
-			e.store.RPush(string(rec.Key), rec.Value)
-		case wal.OpLPop:
-			e.store.LPop(string(rec.Key))
-		case wal.OpRPop:
-			e.store.RPop(string(rec.Key))
-		case wal.OpLSet:
-			index, value := decodeListSet(rec.Value)
-			e.store.LSet(string(rec.Key), index, value)
-		case wal.OpLTrim:
-			start, stop := decodeRankRange(rec.Value)
-			e.store.LTrim(string(rec.Key), start, stop)
-
-		// Set recovery
-		case wal.OpSAdd:
-			e.store.SAdd(string(rec.Key), string(rec.Value))
-		case wal.OpSRem:
-			e.store.SRem(string(rec.Key), string(rec.Value))
-		case wal.OpSPop:
-			// SPop during recovery: we stored the member that was popped
-			e.store.SRem(string(rec.Key), string(rec.Value))
-
-		// Time-series recovery
-		case wal.OpTSAdd:
-			ts, val := decodeTSPoint(rec.Value)
-			e.timeseries.Add(string(rec.Key), ts, val, 0)
-		case wal.OpTSDel:
-			e.timeseries.Delete(string(rec.Key))
+	start, err := e.loadCheckpoint(records)
+	if err != nil {
+		return err
+	}
+	for _, rec := range records[start:] {
+		if err := e.checkRecord(rec); err != nil {
+			return err
 		}
+		e.dbs[rec.DB].apply(rec)
 	}
 
+	// Indexes were filled when created; catch up with the keys written
+	// after that.
+	for _, db := range e.dbs {
+		db.syncIndexes()
+	}
 	return nil
 }
 
+// apply replays a single WAL record against the in-memory state.
+func (e *Engine) apply(rec wal.Record) {
+	switch rec.Type {
+	case wal.OpSet:
+		e.store.Set(string(rec.Key), rec.Value)
+	case wal.OpSetWithTTL:
+		if rec.ExpireAt > 0 {
+			entry := &store.Entry{
+				Value:     rec.Value,
+				ExpireAt:  time.UnixMilli(rec.ExpireAt),
+				HasExpire: true,
+			}
+			e.store.SetEntry(string(rec.Key), entry)
+		} else {
+			e.store.Set(string(rec.Key), rec.Value)
+		}
+	case wal.OpDelete:
+		e.store.Delete(string(rec.Key))
+	case wal.OpExpire:
+		if rec.ExpireAt > 0 {
+			e.store.ExpireAt(string(rec.Key), time.UnixMilli(rec.ExpireAt))
+		}
+	case wal.OpPersist:
+		e.store.Persist(string(rec.Key))
+	case wal.OpRename:
+		e.store.Rename(string(rec.Key), string(rec.Value))
+	case wal.OpCopy:
+		e.store.Copy(string(rec.Key), string(rec.Value), true)
+	case wal.OpSetRange:
+		offset, value := decodeSetRange(rec.Value)
+		e.store.SetRange(string(rec.Key), offset, value)
+	case wal.OpCheckpoint:
+		// Only marks a snapshot position; see loadCheckpoint.
+	case wal.OpFlushDB, wal.OpSwapDB, wal.OpMove:
+		e.applyDB(rec)
+
+	// Sorted set recovery
+	case wal.OpZAdd:
+		member, score := decodeZMember(rec.Value)
+		e.store.ZAdd(string(rec.Key), store.ScoredMember{Member: member, Score: score})
+	case wal.OpZRem:
+		e.store.ZRem(string(rec.Key), string(rec.Value))
+	case wal.OpZIncrBy:
+		member, increment := decodeZMember(rec.Value)
+		e.store.ZIncrBy(string(rec.Key), member, increment)
+	case wal.OpZRemRangeByRank:
+		start, stop := decodeRankRange(rec.Value)
+		e.store.ZRemRangeByRank(string(rec.Key), start, stop)
+	case wal.OpZRemRangeByScore:
+		min, max := decodeScoreRange(rec.Value)
+		e.store.ZRemRangeByScore(string(rec.Key), min, max)
+
+	// Hash recovery
+	case wal.OpHSet:
+		field, value := decodeHashField(rec.Value)
+		e.store.HSet(string(rec.Key), store.HashFieldValue{Field: field, Value: value})
+	case wal.OpHDel:
+		e.store.HDel(string(rec.Key), string(rec.Value))
+
+	// List recovery
+	case wal.OpLPush:
+		e.store.LPush(string(rec.Key), rec.Value)
+	case wal.OpRPush:
+		e.store.RPush(string(rec.Key), rec.Value)
+	case wal.OpLPop:
+		e.store.LPop(string(rec.Key))
+	case wal.OpRPop:
+		e.store.RPop(string(rec.Key))
+	case wal.OpLSet:
+		index, value := decodeListSet(rec.Value)
+		e.store.LSet(string(rec.Key), index, value)
+	case wal.OpLTrim:
+		start, stop := decodeRankRange(rec.Value)
+		e.store.LTrim(string(rec.Key), start, stop)
+
+	// Set recovery
+	case wal.OpSAdd:
+		e.store.SAdd(string(rec.Key), string(rec.Value))
+	case wal.OpSRem:
+		e.store.SRem(string(rec.Key), string(rec.Value))
+	case wal.OpSPop:
+		// SPop during recovery: we stored the member that was popped
+		e.store.SRem(string(rec.Key), string(rec.Value))
+
+	// Time-series recovery
+	case wal.OpTSAdd:
+		ts, val := decodeTSPoint(rec.Value)
+		e.timeseries.Add(string(rec.Key), ts, val, 0)
+	case wal.OpTSMeta:
+		retention, labels := decodeTSMeta(rec.Value)
+		e.timeseries.Create(string(rec.Key), retention, labels)
+	case wal.OpTSDel:
+		e.timeseries.Delete(string(rec.Key))
+
+	// Stream recovery
+	case wal.OpXAdd, wal.OpXDel, wal.OpXTrim, wal.OpXSetID,
+		wal.OpXGroupCreate, wal.OpXGroupDestroy, wal.OpXGroupSetID,
+		wal.OpXConsumerCreate, wal.OpXConsumerDel, wal.OpXDeliver, wal.OpXAck:
+		e.applyStream(rec)
+
+	// JSON recovery
+	case wal.OpJSONSet, wal.OpJSONDel, wal.OpJSONArrInsert, wal.OpJSONArrTrim:
+		e.applyJSON(rec)
+
+	// Search index recovery
+	case wal.OpFTCreate, wal.OpFTDrop:
+		e.applySearch(rec)
+	}
+}
+
 func (e *Engine) recordRead() {
 	e.totalReads.Add(1)
 	e.totalCommands.Add(1)
 }
 
+// recordWrite counts a write and brings the search indexes up to date with
+// it (must hold e.mu).
 func (e *Engine) recordWrite() {
 	e.totalWrites.Add(1)
+	e.dirty.Add(1)
 	e.totalCommands.Add(1)
+	e.syncIndexes()
 }
 
 func (e *Engine) recordCommand() {
 	e.totalCommands.Add(1)
 }
 
-func cloneEntry(entry *store.Entry) *store.Entry {
-	cloned := &store.Entry{
-		ExpireAt:  entry.ExpireAt,
-		HasExpire: entry.HasExpire,
+// logExpired records the removal of expired keys as deletes, so that
+// replaying the WAL never applies later writes to a key that had already
+// expired (must hold e.mu).
+func (e *Engine) logExpired(keys []string) {
+	records := make([]wal.Record, len(keys))
+	for i, key := range keys {
+		records[i] = wal.Record{Type: wal.OpDelete, Key: []byte(key)}
 	}
-	if entry.Value != nil {
-		cloned.Value = append([]byte(nil), entry.Value...)
+	// The keys are already gone from memory; if this write fails, recovery
+	// still drops them once it sees their TTL has passed.
+	_ = e.writeWAL(records...)
+	e.expiredKeys.Add(int64(len(keys)))
+	for _, key := range keys {
+		e.notify(NotifyExpired, "expired", key)
 	}
-	return cloned
+	e.syncIndexes()
 }
 
-func walRecordForEntry(key string, entry *store.Entry) wal.Record {
-	rec := wal.Record{
-		Type:  wal.OpSet,
-		Key:   []byte(key),
-		Value: append([]byte(nil), entry.Value...),
+// writeWAL appends records to the WAL as records of the database, or to
+// the transaction in progress (must hold e.mu).
+func (e *Engine) writeWAL(records ...wal.Record) error {
+	if e.closed {
+		return ErrClosed
+	}
+	for i := range records {
+		records[i].DB = e.db
+	}
+	if e.inTx {
+		e.txRecords = append(e.txRecords, records...)
+		return nil
+	}
+	return e.wal.Write(records...)
+}
+
+// commit waits for the WAL records of a write to reach disk, as required by
+// the sync policy. Write paths defer it before taking e.mu so that it runs
+// after the lock is released and concurrent writers can share one fsync.
+func (e *Engine) commit(err *error) {
+	if *err != nil {
+		return
+	}
+	if syncErr := e.wal.WaitDurable(); syncErr != nil {
+		*err = fmt.Errorf("engine: failed to sync WAL: %w", syncErr)
 	}
-	if entry.HasExpire {
-		rec.Type = wal.OpSetWithTTL
-		rec.ExpireAt = entry.ExpireAt.UnixMilli()
+}
+
+// expireIfNeeded lazily deletes key if its TTL has elapsed (must hold e.mu).
+func (e *Engine) expireIfNeeded(key string) {
+	if e.store.ExpireIfNeeded(key) {
+		e.logExpired([]string{key})
 	}
-	return rec
+}
+
+// checkType expires key if it is due and then returns store.ErrWrongType if
+// key exists and holds a value other than want. Write paths call it before
+// touching the WAL so that a rejected command never produces a record
+// (must hold e.mu).
+func (e *Engine) checkType(key string, want store.ValueType) error {
+	e.expireIfNeeded(key)
+	if t := e.store.Type(key); t != store.TypeNone && t != want {
+		return store.ErrWrongType
+	}
+	return nil
+}
+
+// setRecords returns the WAL records for overwriting the string at key with
+// value while keeping its current TTL (must hold e.mu).
+func (e *Engine) setRecords(key string, value []byte) []wal.Record {
+	records := []wal.Record{{Type: wal.OpSet, Key: []byte(key), Value: value}}
+	if at, ok := e.store.ExpireTime(key); ok {
+		records = append(records, wal.Record{
+			Type:     wal.OpExpire,
+			Key:      []byte(key),
+			ExpireAt: at.UnixMilli(),
+		})
+	}
+	return records
+}
+
+// rangeRecords returns the WAL records that turn the string at key from
+// before into after while keeping its TTL (must hold e.mu). Changed runs
+// are logged with OpSetRange, so small edits to large strings stay small;
+// a string that shrinks or mostly changes is logged whole.
+func (e *Engine) rangeRecords(key string, before, after []byte) []wal.Record {
+	if len(after) < len(before) {
+		return e.setRecords(key, after)
+	}
+	// Runs closer than this are logged together.
+	const gap = 16
+	var records []wal.Record
+	logged := 0
+	for i := 0; i < len(after); i++ {
+		if i < len(before) && before[i] == after[i] {
+			continue
+		}
+		start, end := i, i+1
+		for j := end; j < len(after) && j < end+gap; j++ {
+			if j >= len(before) || before[j] != after[j] {
+				end = j + 1
+			}
+		}
+		records = append(records, wal.Record{
+			Type:  wal.OpSetRange,
+			Key:   []byte(key),
+			Value: encodeSetRange(start, after[start:end]),
+		})
+		logged += end - start
+		i = end
+	}
+	if logged > len(after)/2 {
+		return e.setRecords(key, after)
+	}
+	return records
+}
+
+// writeString logs and applies the change of the string at key from before
+// to after, keeping its TTL (must hold e.mu).
+func (e *Engine) writeString(key string, before, after []byte) error {
+	records := e.rangeRecords(key, before, after)
+	if len(records) == 0 {
+		return nil
+	}
+	if err := e.writeWAL(records...); err != nil {
+		return fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+	for _, rec := range records {
+		e.apply(rec)
+	}
+	return nil
 }
 
 // Set stores a key-value pair.
 // The operation is persisted to WAL before being applied.
-func (e *Engine) Set(key string, value []byte) error {
+func (e *Engine) Set(key string, value []byte) (err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
@@ -239,19 +536,21 @@ func (e *Engine) Set(key string, value []byte) error {
 		Key:   []byte(key),
 		Value: value,
 	}
-	if err := e.wal.Append(rec); err != nil {
+	if err := e.writeWAL(rec); err != nil {
 		return fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
 	e.store.Set(key, value)
 	e.hotkeys.Record(key)
 	e.cdc.Record(cdc.OpSet, key, string(value), "")
+	e.notify(NotifyString, "set", key)
 	e.recordWrite()
 	return nil
 }
 
 // SetWithTTL stores a key-value pair with expiration.
-func (e *Engine) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+func (e *Engine) SetWithTTL(key string, value []byte, ttl time.Duration) (err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
@@ -262,17 +561,24 @@ func (e *Engine) SetWithTTL(key string, value []byte, ttl time.Duration) error {
 		Value:    value,
 		ExpireAt: expireAt,
 	}
-	if err := e.wal.Append(rec); err != nil {
+	if err := e.writeWAL(rec); err != nil {
 		return fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
-	e.store.SetWithTTL(key, value, ttl)
+	e.store.SetEntry(key, &store.Entry{
+		Value:     value,
+		ExpireAt:  time.UnixMilli(expireAt),
+		HasExpire: true,
+	})
+	e.notify(NotifyString, "set", key)
+	e.notify(NotifyGeneric, "expire", key)
 	e.recordWrite()
 	return nil
 }
 
 // SetNX sets key if it doesn't exist. Returns true if set.
-func (e *Engine) SetNX(key string, value []byte) (bool, error) {
+func (e *Engine) SetNX(key string, value []byte) (_ bool, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
@@ -286,18 +592,19 @@ func (e *Engine) SetNX(key string, value []byte) (bool, error) {
 		Key:   []byte(key),
 		Value: value,
 	}
-	if err := e.wal.Append(rec); err != nil {
+	if err := e.writeWAL(rec); err != nil {
 		return false, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
 	e.store.Set(key, value)
+	e.notify(NotifyString, "set", key)
 	e.recordWrite()
 	return true, nil
 }
 
 // Get retrieves a value by key.
 // Returns the value and true if found, nil and false otherwise.
-func (e *Engine) Get(key string) ([]byte, bool) {
+func (e *Engine) Get(key string) ([]byte, bool, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 	e.hotkeys.Record(key)
@@ -307,7 +614,8 @@ func (e *Engine) Get(key string) ([]byte, bool) {
 
 // Delete removes a key from the store.
 // Returns true if the key existed, false otherwise.
-func (e *Engine) Delete(key string) (bool, error) {
+func (e *Engine) Delete(key string) (_ bool, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
@@ -322,12 +630,13 @@ func (e *Engine) Delete(key string) (bool, error) {
 		Key:   []byte(key),
 		Value: nil,
 	}
-	if err := e.wal.Append(rec); err != nil {
+	if err := e.writeWAL(rec); err != nil {
 		return false, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
 	e.store.Delete(key)
 	e.cdc.Record(cdc.OpDel, key, "", "")
+	e.notify(NotifyGeneric, "del", key)
 	e.recordWrite()
 	return true, nil
 }
@@ -340,8 +649,9 @@ func (e *Engine) Exists(key string) bool {
 	return e.store.Exists(key)
 }
 
-// Expire sets TTL on an existing key.
-func (e *Engine) Expire(key string, ttl time.Duration) (bool, error) {
+// Expire sets TTL on an existing key of any type.
+func (e *Engine) Expire(key string, ttl time.Duration) (_ bool, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
@@ -356,11 +666,12 @@ func (e *Engine) Expire(key string, ttl time.Duration) (bool, error) {
 		Key:      []byte(key),
 		ExpireAt: expireAt,
 	}
-	if err := e.wal.Append(rec); err != nil {
+	if err := e.writeWAL(rec); err != nil {
 		return false, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
-	e.store.Expire(key, ttl)
+	e.store.ExpireAt(key, time.UnixMilli(expireAt))
+	e.notify(NotifyGeneric, "expire", key)
 	e.recordWrite()
 	return true, nil
 }
@@ -387,12 +698,12 @@ func (e *Engine) PTTL(key string) int64 {
 }
 
 // Persist removes TTL from a key.
-func (e *Engine) Persist(key string) (bool, error) {
+func (e *Engine) Persist(key string) (_ bool, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	entry, exists := e.store.GetEntry(key)
-	if !exists || !entry.HasExpire {
+	if _, ok := e.store.ExpireTime(key); !ok {
 		e.recordCommand()
 		return false, nil
 	}
@@ -401,12 +712,13 @@ func (e *Engine) Persist(key string) (bool, error) {
 		Type: wal.OpPersist,
 		Key:  []byte(key),
 	}
-	if err := e.wal.Append(rec); err != nil {
+	if err := e.writeWAL(rec); err != nil {
 		return false, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
 	persisted := e.store.Persist(key)
 	if persisted {
+		e.notify(NotifyGeneric, "persist", key)
 		e.recordWrite()
 		return true, nil
 	}
@@ -415,13 +727,13 @@ func (e *Engine) Persist(key string) (bool, error) {
 	return false, nil
 }
 
-// Rename renames a key. If nx is true, it only renames when destination doesn't exist.
-func (e *Engine) Rename(oldKey, newKey string, nx bool) (bool, error) {
+// Rename renames a key of any type. If nx is true, it only renames when destination doesn't exist.
+func (e *Engine) Rename(oldKey, newKey string, nx bool) (_ bool, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	entry, exists := e.store.GetEntry(oldKey)
-	if !exists {
+	if !e.store.Exists(oldKey) {
 		e.recordCommand()
 		return false, nil
 	}
@@ -440,27 +752,31 @@ func (e *Engine) Rename(oldKey, newKey string, nx bool) (bool, error) {
 		return false, nil
 	}
 
-	newRec := walRecordForEntry(newKey, entry)
-	if err := e.wal.Append(newRec); err != nil {
-		return false, fmt.Errorf("engine: failed to write WAL: %w", err)
+	rec := wal.Record{
+		Type:  wal.OpRename,
+		Key:   []byte(oldKey),
+		Value: []byte(newKey),
 	}
-	if err := e.wal.Append(wal.Record{Type: wal.OpDelete, Key: []byte(oldKey)}); err != nil {
+	if err := e.writeWAL(rec); err != nil {
 		return false, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
-	e.store.SetEntry(newKey, cloneEntry(entry))
-	e.store.Delete(oldKey)
+	e.store.Rename(oldKey, newKey)
+	e.keyReady(newKey)
+	e.notify(NotifyGeneric, "rename_from", oldKey)
+	e.notify(NotifyGeneric, "rename_to", newKey)
 	e.recordWrite()
 	return true, nil
 }
 
-// Copy copies source key to destination key. If replace is false and destination exists, it returns false.
-func (e *Engine) Copy(sourceKey, destKey string, replace bool) (bool, error) {
+// Copy copies source key to destination key, whatever its type.
+// If replace is false and destination exists, it returns false.
+func (e *Engine) Copy(sourceKey, destKey string, replace bool) (_ bool, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	entry, exists := e.store.GetEntry(sourceKey)
-	if !exists {
+	if !e.store.Exists(sourceKey) {
 		e.recordCommand()
 		return false, nil
 	}
@@ -479,15 +795,79 @@ func (e *Engine) Copy(sourceKey, destKey string, replace bool) (bool, error) {
 		return false, nil
 	}
 
-	if err := e.wal.Append(walRecordForEntry(destKey, entry)); err != nil {
+	rec := wal.Record{
+		Type:  wal.OpCopy,
+		Key:   []byte(sourceKey),
+		Value: []byte(destKey),
+	}
+	if err := e.writeWAL(rec); err != nil {
 		return false, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
-	e.store.SetEntry(destKey, cloneEntry(entry))
+	e.store.Copy(sourceKey, destKey, true)
+	e.keyReady(destKey)
+	e.notify(NotifyGeneric, "copy_to", destKey)
 	e.recordWrite()
 	return true, nil
 }
 
+// Dump serializes the value at key, with its TTL, into a payload for
+// Restore. It returns false if the key does not exist.
+func (e *Engine) Dump(key string) ([]byte, bool, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	e.recordRead()
+
+	item, ok := e.store.Item(key)
+	if !ok {
+		return nil, false, nil
+	}
+	payload, err := snapshot.Dump(itemEntry(item))
+	if err != nil {
+		return nil, false, err
+	}
+	return payload, true, nil
+}
+
+// Restore recreates key from a Dump payload, expiring at expireAt (unix
+// milliseconds), or never if it is 0, as RESTORE does: the TTL stored in
+// the payload is not used. Unless replace is set, an existing key is left
+// alone and ErrBusyKey is returned.
+func (e *Engine) Restore(key string, payload []byte, expireAt int64, replace bool) (err error) {
+	entry, err := snapshot.Undump(key, payload)
+	if err != nil {
+		return err
+	}
+	entry = withExpireAt(entry, expireAt)
+
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	exists := e.store.Exists(key)
+	if exists && !replace {
+		e.recordCommand()
+		return ErrBusyKey
+	}
+
+	var records []wal.Record
+	if exists {
+		records = append(records, wal.Record{Type: wal.OpDelete, Key: []byte(key)})
+	}
+	records = appendKeyRecords(records, entry)
+	if err := e.writeWAL(records...); err != nil {
+		return fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+
+	for _, rec := range records {
+		e.apply(rec)
+	}
+	e.keyReady(key)
+	e.notify(NotifyGeneric, "restore", key)
+	e.recordWrite()
+	return nil
+}
+
 // Keys returns all keys in the store.
 func (e *Engine) Keys() []string {
 	e.mu.RLock()
@@ -505,30 +885,32 @@ func (e *Engine) Size() int {
 }
 
 // Append appends value to key and returns new length.
-func (e *Engine) Append(key string, value []byte) (int, error) {
+func (e *Engine) Append(key string, value []byte) (_ int, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	if err := e.checkType(key, store.TypeString); err != nil {
+		e.recordCommand()
+		return 0, err
+	}
+
 	// Get current value
-	current, _ := e.store.Get(key)
+	current, _, _ := e.store.Get(key)
 	newValue := append(current, value...)
 
-	rec := wal.Record{
-		Type:  wal.OpSet,
-		Key:   []byte(key),
-		Value: newValue,
-	}
-	if err := e.wal.Append(rec); err != nil {
+	if err := e.writeWAL(e.setRecords(key, newValue)...); err != nil {
 		return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
-	length := e.store.Append(key, value)
+	length, _ := e.store.Append(key, value)
+	e.notify(NotifyString, "append", key)
 	e.recordWrite()
 	return length, nil
 }
 
 // StrLen returns string length of value at key.
-func (e *Engine) StrLen(key string) int {
+func (e *Engine) StrLen(key string) (int, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 	e.recordRead()
@@ -536,39 +918,52 @@ func (e *Engine) StrLen(key string) int {
 }
 
 // IncrBy increments integer value by delta.
-func (e *Engine) IncrBy(key string, delta int64) (int64, error) {
+func (e *Engine) IncrBy(key string, delta int64) (_ int64, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	e.expireIfNeeded(key)
 	newVal, err := e.store.IncrBy(key, delta)
 	if err != nil {
 		e.recordCommand()
 		return 0, err
 	}
 
-	rec := wal.Record{
-		Type:  wal.OpSet,
-		Key:   []byte(key),
-		Value: []byte(fmt.Sprintf("%d", newVal)),
-	}
-	if err := e.wal.Append(rec); err != nil {
+	if err := e.writeWAL(e.setRecords(key, []byte(fmt.Sprintf("%d", newVal)))...); err != nil {
 		return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
+	e.notify(NotifyString, "incrby", key)
 	e.recordWrite()
 	return newVal, nil
 }
 
-// Clear removes all keys from the store and clears the WAL.
+// Clear removes all keys and search indexes from every database and clears
+// the WAL. Inside a transaction it logs a flush of each database instead,
+// and the WAL is only cleared by a Commit that writes nothing after it.
 func (e *Engine) Clear() error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	if err := e.wal.Clear(); err != nil {
-		return fmt.Errorf("engine: failed to clear WAL: %w", err)
+	if e.inTx {
+		for _, db := range e.dbs {
+			if err := db.writeWAL(wal.Record{Type: wal.OpFlushDB}); err != nil {
+				return fmt.Errorf("engine: failed to write WAL: %w", err)
+			}
+		}
+		e.txClearEnd = len(e.txRecords)
+	} else {
+		if err := e.wal.Clear(); err != nil {
+			return fmt.Errorf("engine: failed to clear WAL: %w", err)
+		}
+		e.walCheckpoint = ""
 	}
 
-	e.store.Clear()
+	for _, db := range e.dbs {
+		db.touchAllWatched()
+		db.flush()
+	}
 	e.recordWrite()
 	return nil
 }
@@ -582,23 +977,46 @@ func (e *Engine) GetStats() Stats {
 		TotalReads:    e.totalReads.Load(),
 		TotalWrites:   e.totalWrites.Load(),
 		StartTime:     e.startTime,
-		KeysCount:     e.store.Size(),
+		KeysCount:     e.keysCount(),
 		ExpiredKeys:   e.expiredKeys.Load(),
+		EvictedKeys:   e.evictedKeys.Load(),
 	}
 }
 
-// Close closes the engine and its underlying WAL.
+// Close waits for background tasks and closes the engine and its underlying WAL.
+// Closing it again only waits for the first Close to finish.
 func (e *Engine) Close() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		<-e.closeDone
+		return nil
+	}
+	e.closed = true
+	e.mu.Unlock()
+	return e.shutdown()
+}
+
+// shutdown stops the background tasks, then closes the stores and the WAL
+// of an engine just marked closed.
+func (e *Engine) shutdown() error {
+	defer close(e.closeDone)
+	close(e.stopBg)
+	e.bgWG.Wait()
+
 	e.mu.Lock()
 	defer e.mu.Unlock()
-	e.store.Close()
-	e.timeseries.Close()
+	for _, db := range e.dbs {
+		db.store.Close()
+		db.timeseries.Close()
+	}
 	return e.wal.Close()
 }
 
 // MSet atomically sets multiple key-value pairs.
 // All keys are written to WAL in a single batch before being applied in-memory.
-func (e *Engine) MSet(pairs map[string][]byte) error {
+func (e *Engine) MSet(pairs map[string][]byte) (err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
@@ -610,12 +1028,13 @@ func (e *Engine) MSet(pairs map[string][]byte) error {
 			Value: value,
 		})
 	}
-	if err := e.wal.AppendBatch(records); err != nil {
+	if err := e.writeWAL(records...); err != nil {
 		return fmt.Errorf("engine: failed to write WAL batch: %w", err)
 	}
 
 	for key, value := range pairs {
 		e.store.Set(key, value)
+		e.notify(NotifyString, "set", key)
 	}
 	e.recordWrite()
 	return nil
@@ -623,7 +1042,8 @@ func (e *Engine) MSet(pairs map[string][]byte) error {
 
 // MSetNX atomically sets multiple key-value pairs only if NONE of the keys exist.
 // Returns true if all keys were set, false if any key already existed.
-func (e *Engine) MSetNX(pairs map[string][]byte) (bool, error) {
+func (e *Engine) MSetNX(pairs map[string][]byte) (_ bool, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
@@ -644,12 +1064,13 @@ func (e *Engine) MSetNX(pairs map[string][]byte) (bool, error) {
 			Value: value,
 		})
 	}
-	if err := e.wal.AppendBatch(records); err != nil {
+	if err := e.writeWAL(records...); err != nil {
 		return false, fmt.Errorf("engine: failed to write WAL batch: %w", err)
 	}
 
 	for key, value := range pairs {
 		e.store.Set(key, value)
+		e.notify(NotifyString, "set", key)
 	}
 	e.recordWrite()
 	return true, nil
@@ -657,12 +1078,18 @@ func (e *Engine) MSetNX(pairs map[string][]byte) (bool, error) {
 
 // IncrByFloat increments the float value of key by delta.
 // This is atomic — the read-modify-write happens under a single lock.
-func (e *Engine) IncrByFloat(key string, delta float64) (float64, error) {
+func (e *Engine) IncrByFloat(key string, delta float64) (_ float64, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	e.expireIfNeeded(key)
 	var current float64
-	val, exists := e.store.Get(key)
+	val, exists, err := e.store.Get(key)
+	if err != nil {
+		e.recordCommand()
+		return 0, err
+	}
 	if exists {
 		parsed, err := strconv.ParseFloat(string(val), 64)
 		if err != nil {
@@ -675,42 +1102,27 @@ func (e *Engine) IncrByFloat(key string, delta float64) (float64, error) {
 	newValue := current + delta
 	newStr := strconv.FormatFloat(newValue, 'f', -1, 64)
 
-	rec := wal.Record{
-		Type:  wal.OpSet,
-		Key:   []byte(key),
-		Value: []byte(newStr),
-	}
-	if err := e.wal.Append(rec); err != nil {
+	records := e.setRecords(key, []byte(newStr))
+	if err := e.writeWAL(records...); err != nil {
 		return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
 	e.store.Set(key, []byte(newStr))
+	if len(records) > 1 {
+		e.store.ExpireAt(key, time.UnixMilli(records[1].ExpireAt))
+	}
+	e.notify(NotifyString, "incrbyfloat", key)
 	e.recordWrite()
 	return newValue, nil
 }
 
-// KeyType returns the type of a key: "string", "zset", "hash", "list", "set", or "none".
+// KeyType returns the type of a key: "string", "zset", "hash", "list", "set", "stream", "ReJSON-RL", or "none".
 func (e *Engine) KeyType(key string) string {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 	e.recordRead()
 
-	if e.store.ZExists(key) {
-		return "zset"
-	}
-	if e.store.HashExists(key) {
-		return "hash"
-	}
-	if e.store.ListExists(key) {
-		return "list"
-	}
-	if e.store.SetExists(key) {
-		return "set"
-	}
-	if e.store.Exists(key) {
-		return "string"
-	}
-	return "none"
+	return e.store.Type(key).String()
 }
 
 // ========================
@@ -776,10 +1188,16 @@ func decodeScoreRange(data []byte) (float64, float64) {
 
 // ZAdd adds members to a sorted set. Returns number of NEW members added.
 // Each member addition is persisted to WAL individually.
-func (e *Engine) ZAdd(key string, members ...store.ScoredMember) (int, error) {
+func (e *Engine) ZAdd(key string, members ...store.ScoredMember) (_ int, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	if err := e.checkType(key, store.TypeZSet); err != nil {
+		e.recordCommand()
+		return 0, err
+	}
+
 	// Write WAL records for each member
 	records := make([]wal.Record, len(members))
 	for i, m := range members {
@@ -789,17 +1207,18 @@ func (e *Engine) ZAdd(key string, members ...store.ScoredMember) (int, error) {
 			Value: encodeZMember(m.Member, m.Score),
 		}
 	}
-	if err := e.wal.AppendBatch(records); err != nil {
+	if err := e.writeWAL(records...); err != nil {
 		return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
-	result := e.store.ZAdd(key, members...)
+	result, _ := e.store.ZAdd(key, members...)
+	e.notify(NotifyZSet, "zadd", key)
 	e.recordWrite()
 	return result, nil
 }
 
 // ZScore returns the score of a member in a sorted set.
-func (e *Engine) ZScore(key, member string) (float64, bool) {
+func (e *Engine) ZScore(key, member string) (float64, bool, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
@@ -808,10 +1227,16 @@ func (e *Engine) ZScore(key, member string) (float64, bool) {
 }
 
 // ZRem removes members from a sorted set. Returns number removed.
-func (e *Engine) ZRem(key string, members ...string) (int, error) {
+func (e *Engine) ZRem(key string, members ...string) (_ int, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	if err := e.checkType(key, store.TypeZSet); err != nil {
+		e.recordCommand()
+		return 0, err
+	}
+
 	records := make([]wal.Record, len(members))
 	for i, m := range members {
 		records[i] = wal.Record{
@@ -820,17 +1245,20 @@ func (e *Engine) ZRem(key string, members ...string) (int, error) {
 			Value: []byte(m),
 		}
 	}
-	if err := e.wal.AppendBatch(records); err != nil {
+	if err := e.writeWAL(records...); err != nil {
 		return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
-	result := e.store.ZRem(key, members...)
+	result, _ := e.store.ZRem(key, members...)
+	if result > 0 {
+		e.notifyRemoved(NotifyZSet, "zrem", key)
+	}
 	e.recordWrite()
 	return result, nil
 }
 
 // ZCard returns the cardinality of a sorted set.
-func (e *Engine) ZCard(key string) int {
+func (e *Engine) ZCard(key string) (int, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
@@ -839,7 +1267,7 @@ func (e *Engine) ZCard(key string) int {
 }
 
 // ZRank returns the rank of a member (0-based, ascending).
-func (e *Engine) ZRank(key, member string) (int, bool) {
+func (e *Engine) ZRank(key, member string) (int, bool, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
@@ -848,7 +1276,7 @@ func (e *Engine) ZRank(key, member string) (int, bool) {
 }
 
 // ZRevRank returns the rank of a member (0-based, descending).
-func (e *Engine) ZRevRank(key, member string) (int, bool) {
+func (e *Engine) ZRevRank(key, member string) (int, bool, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
@@ -857,7 +1285,7 @@ func (e *Engine) ZRevRank(key, member string) (int, bool) {
 }
 
 // ZRange returns members by rank range.
-func (e *Engine) ZRange(key string, start, stop int, withScores bool) []store.ScoredMember {
+func (e *Engine) ZRange(key string, start, stop int, withScores bool) ([]store.ScoredMember, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
@@ -866,7 +1294,7 @@ func (e *Engine) ZRange(key string, start, stop int, withScores bool) []store.Sc
 }
 
 // ZRevRange returns members by rank range in reverse order.
-func (e *Engine) ZRevRange(key string, start, stop int, withScores bool) []store.ScoredMember {
+func (e *Engine) ZRevRange(key string, start, stop int, withScores bool) ([]store.ScoredMember, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
@@ -875,7 +1303,7 @@ func (e *Engine) ZRevRange(key string, start, stop int, withScores bool) []store
 }
 
 // ZRangeByScore returns members with scores in range.
-func (e *Engine) ZRangeByScore(key string, min, max float64, withScores bool, offset, count int) []store.ScoredMember {
+func (e *Engine) ZRangeByScore(key string, min, max float64, withScores bool, offset, count int) ([]store.ScoredMember, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
@@ -884,7 +1312,7 @@ func (e *Engine) ZRangeByScore(key string, min, max float64, withScores bool, of
 }
 
 // ZRevRangeByScore returns members with scores in range, descending.
-func (e *Engine) ZRevRangeByScore(key string, max, min float64, withScores bool, offset, count int) []store.ScoredMember {
+func (e *Engine) ZRevRangeByScore(key string, max, min float64, withScores bool, offset, count int) ([]store.ScoredMember, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
@@ -893,7 +1321,7 @@ func (e *Engine) ZRevRangeByScore(key string, max, min float64, withScores bool,
 }
 
 // ZCount returns count of members with scores in range.
-func (e *Engine) ZCount(key string, min, max float64) int {
+func (e *Engine) ZCount(key string, min, max float64) (int, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
@@ -902,68 +1330,99 @@ func (e *Engine) ZCount(key string, min, max float64) int {
 }
 
 // ZIncrBy increments score of member. Returns new score.
-func (e *Engine) ZIncrBy(key, member string, increment float64) (float64, error) {
+func (e *Engine) ZIncrBy(key, member string, increment float64) (_ float64, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	if err := e.checkType(key, store.TypeZSet); err != nil {
+		e.recordCommand()
+		return 0, err
+	}
+
 	rec := wal.Record{
 		Type:  wal.OpZIncrBy,
 		Key:   []byte(key),
 		Value: encodeZMember(member, increment),
 	}
-	if err := e.wal.Append(rec); err != nil {
+	if err := e.writeWAL(rec); err != nil {
 		return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
-	result := e.store.ZIncrBy(key, member, increment)
+	result, _ := e.store.ZIncrBy(key, member, increment)
+	e.notify(NotifyZSet, "zincr", key)
 	e.recordWrite()
 	return result, nil
 }
 
 // ZRemRangeByRank removes members by rank range.
-func (e *Engine) ZRemRangeByRank(key string, start, stop int) (int, error) {
+func (e *Engine) ZRemRangeByRank(key string, start, stop int) (_ int, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	if err := e.checkType(key, store.TypeZSet); err != nil {
+		e.recordCommand()
+		return 0, err
+	}
+
 	rec := wal.Record{
 		Type:  wal.OpZRemRangeByRank,
 		Key:   []byte(key),
 		Value: encodeRankRange(start, stop),
 	}
-	if err := e.wal.Append(rec); err != nil {
+	if err := e.writeWAL(rec); err != nil {
 		return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
-	result := e.store.ZRemRangeByRank(key, start, stop)
+	result, _ := e.store.ZRemRangeByRank(key, start, stop)
+	if result > 0 {
+		e.notifyRemoved(NotifyZSet, "zremrangebyrank", key)
+	}
 	e.recordWrite()
 	return result, nil
 }
 
 // ZRemRangeByScore removes members by score range.
-func (e *Engine) ZRemRangeByScore(key string, min, max float64) (int, error) {
+func (e *Engine) ZRemRangeByScore(key string, min, max float64) (_ int, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	if err := e.checkType(key, store.TypeZSet); err != nil {
+		e.recordCommand()
+		return 0, err
+	}
+
 	rec := wal.Record{
 		Type:  wal.OpZRemRangeByScore,
 		Key:   []byte(key),
 		Value: encodeScoreRange(min, max),
 	}
-	if err := e.wal.Append(rec); err != nil {
+	if err := e.writeWAL(rec); err != nil {
 		return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
-	result := e.store.ZRemRangeByScore(key, min, max)
+	result, _ := e.store.ZRemRangeByScore(key, min, max)
+	if result > 0 {
+		e.notifyRemoved(NotifyZSet, "zremrangebyscore", key)
+	}
 	e.recordWrite()
 	return result, nil
 }
 
 // ZPopMin removes and returns lowest-scoring members.
-func (e *Engine) ZPopMin(key string, count int) ([]store.ScoredMember, error) {
+func (e *Engine) ZPopMin(key string, count int) (_ []store.ScoredMember, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	result := e.store.ZPopMin(key, count)
+	e.expireIfNeeded(key)
+	result, err := e.store.ZPopMin(key, count)
+	if err != nil {
+		e.recordCommand()
+		return nil, err
+	}
 
 	// Write WAL records for each popped member
 	if len(result) > 0 {
@@ -975,9 +1434,10 @@ func (e *Engine) ZPopMin(key string, count int) ([]store.ScoredMember, error) {
 				Value: []byte(m.Member),
 			}
 		}
-		if err := e.wal.AppendBatch(records); err != nil {
+		if err := e.writeWAL(records...); err != nil {
 			return nil, fmt.Errorf("engine: failed to write WAL: %w", err)
 		}
+		e.notifyRemoved(NotifyZSet, "zpopmin", key)
 	}
 
 	e.recordWrite()
@@ -985,11 +1445,17 @@ func (e *Engine) ZPopMin(key string, count int) ([]store.ScoredMember, error) {
 }
 
 // ZPopMax removes and returns highest-scoring members.
-func (e *Engine) ZPopMax(key string, count int) ([]store.ScoredMember, error) {
+func (e *Engine) ZPopMax(key string, count int) (_ []store.ScoredMember, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	result := e.store.ZPopMax(key, count)
+	e.expireIfNeeded(key)
+	result, err := e.store.ZPopMax(key, count)
+	if err != nil {
+		e.recordCommand()
+		return nil, err
+	}
 
 	// Write WAL records for each popped member
 	if len(result) > 0 {
@@ -1001,27 +1467,16 @@ func (e *Engine) ZPopMax(key string, count int) ([]store.ScoredMember, error) {
 				Value: []byte(m.Member),
 			}
 		}
-		if err := e.wal.AppendBatch(records); err != nil {
+		if err := e.writeWAL(records...); err != nil {
 			return nil, fmt.Errorf("engine: failed to write WAL: %w", err)
 		}
+		e.notifyRemoved(NotifyZSet, "zpopmax", key)
 	}
 
 	e.recordWrite()
 	return result, nil
 }
 
-// ExecLock serializes transaction execution.
-// This prevents concurrent EXEC calls from interleaving.
-// Individual engine operations still acquire their own per-operation locks.
-func (e *Engine) ExecLock() {
-	e.txMu.Lock()
-}
-
-// ExecUnlock releases the transaction serialization lock.
-func (e *Engine) ExecUnlock() {
-	e.txMu.Unlock()
-}
-
 // ========================
 // Hash/List Encoding Helpers
 // ========================
@@ -1071,10 +1526,16 @@ func decodeListSet(data []byte) (int, []byte) {
 // ========================
 
 // HSet sets field(s) in a hash. Returns number of new fields added.
-func (e *Engine) HSet(key string, fields ...store.HashFieldValue) (int, error) {
+func (e *Engine) HSet(key string, fields ...store.HashFieldValue) (_ int, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	if err := e.checkType(key, store.TypeHash); err != nil {
+		e.recordCommand()
+		return 0, err
+	}
+
 	records := make([]wal.Record, len(fields))
 	for i, fv := range fields {
 		records[i] = wal.Record{
@@ -1083,17 +1544,18 @@ func (e *Engine) HSet(key string, fields ...store.HashFieldValue) (int, error) {
 			Value: encodeHashField(fv.Field, fv.Value),
 		}
 	}
-	if err := e.wal.AppendBatch(records); err != nil {
+	if err := e.writeWAL(records...); err != nil {
 		return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
-	result := e.store.HSet(key, fields...)
+	result, _ := e.store.HSet(key, fields...)
+	e.notify(NotifyHash, "hset", key)
 	e.recordWrite()
 	return result, nil
 }
 
 // HGet returns the value of a hash field.
-func (e *Engine) HGet(key, field string) ([]byte, bool) {
+func (e *Engine) HGet(key, field string) ([]byte, bool, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 	e.recordRead()
@@ -1101,10 +1563,16 @@ func (e *Engine) HGet(key, field string) ([]byte, bool) {
 }
 
 // HDel removes field(s) from a hash. Returns number removed.
-func (e *Engine) HDel(key string, fields ...string) (int, error) {
+func (e *Engine) HDel(key string, fields ...string) (_ int, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	if err := e.checkType(key, store.TypeHash); err != nil {
+		e.recordCommand()
+		return 0, err
+	}
+
 	records := make([]wal.Record, len(fields))
 	for i, f := range fields {
 		records[i] = wal.Record{
@@ -1113,17 +1581,20 @@ func (e *Engine) HDel(key string, fields ...string) (int, error) {
 			Value: []byte(f),
 		}
 	}
-	if err := e.wal.AppendBatch(records); err != nil {
+	if err := e.writeWAL(records...); err != nil {
 		return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
-	result := e.store.HDel(key, fields...)
+	result, _ := e.store.HDel(key, fields...)
+	if result > 0 {
+		e.notifyRemoved(NotifyHash, "hdel", key)
+	}
 	e.recordWrite()
 	return result, nil
 }
 
 // HExists returns whether a field exists in a hash.
-func (e *Engine) HExists(key, field string) bool {
+func (e *Engine) HExists(key, field string) (bool, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 	e.recordRead()
@@ -1131,7 +1602,7 @@ func (e *Engine) HExists(key, field string) bool {
 }
 
 // HLen returns the number of fields in a hash.
-func (e *Engine) HLen(key string) int {
+func (e *Engine) HLen(key string) (int, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 	e.recordRead()
@@ -1139,7 +1610,7 @@ func (e *Engine) HLen(key string) int {
 }
 
 // HGetAll returns all field-value pairs in a hash.
-func (e *Engine) HGetAll(key string) []store.HashFieldValue {
+func (e *Engine) HGetAll(key string) ([]store.HashFieldValue, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 	e.recordRead()
@@ -1147,7 +1618,7 @@ func (e *Engine) HGetAll(key string) []store.HashFieldValue {
 }
 
 // HKeys returns all field names in a hash.
-func (e *Engine) HKeys(key string) []string {
+func (e *Engine) HKeys(key string) ([]string, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 	e.recordRead()
@@ -1155,7 +1626,7 @@ func (e *Engine) HKeys(key string) []string {
 }
 
 // HVals returns all values in a hash.
-func (e *Engine) HVals(key string) [][]byte {
+func (e *Engine) HVals(key string) ([][]byte, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 	e.recordRead()
@@ -1163,10 +1634,12 @@ func (e *Engine) HVals(key string) [][]byte {
 }
 
 // HIncrBy increments integer value of a hash field.
-func (e *Engine) HIncrBy(key, field string, delta int64) (int64, error) {
+func (e *Engine) HIncrBy(key, field string, delta int64) (_ int64, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	e.expireIfNeeded(key)
 	result, err := e.store.HIncrBy(key, field, delta)
 	if err != nil {
 		e.recordCommand()
@@ -1179,19 +1652,22 @@ func (e *Engine) HIncrBy(key, field string, delta int64) (int64, error) {
 		Key:   []byte(key),
 		Value: encodeHashField(field, []byte(strconv.FormatInt(result, 10))),
 	}
-	if err := e.wal.Append(rec); err != nil {
+	if err := e.writeWAL(rec); err != nil {
 		return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
+	e.notify(NotifyHash, "hincrby", key)
 	e.recordWrite()
 	return result, nil
 }
 
 // HIncrByFloat increments float value of a hash field.
-func (e *Engine) HIncrByFloat(key, field string, delta float64) (float64, error) {
+func (e *Engine) HIncrByFloat(key, field string, delta float64) (_ float64, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	e.expireIfNeeded(key)
 	result, err := e.store.HIncrByFloat(key, field, delta)
 	if err != nil {
 		e.recordCommand()
@@ -1203,20 +1679,27 @@ func (e *Engine) HIncrByFloat(key, field string, delta float64) (float64, error)
 		Key:   []byte(key),
 		Value: encodeHashField(field, []byte(strconv.FormatFloat(result, 'f', -1, 64))),
 	}
-	if err := e.wal.Append(rec); err != nil {
+	if err := e.writeWAL(rec); err != nil {
 		return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
+	e.notify(NotifyHash, "hincrbyfloat", key)
 	e.recordWrite()
 	return result, nil
 }
 
 // HSetNX sets a hash field only if it does not exist.
-func (e *Engine) HSetNX(key, field string, value []byte) (bool, error) {
+func (e *Engine) HSetNX(key, field string, value []byte) (_ bool, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	if e.store.HExists(key, field) {
+	if err := e.checkType(key, store.TypeHash); err != nil {
+		e.recordCommand()
+		return false, err
+	}
+
+	if exists, _ := e.store.HExists(key, field); exists {
 		e.recordCommand()
 		return false, nil
 	}
@@ -1226,11 +1709,12 @@ func (e *Engine) HSetNX(key, field string, value []byte) (bool, error) {
 		Key:   []byte(key),
 		Value: encodeHashField(field, value),
 	}
-	if err := e.wal.Append(rec); err != nil {
+	if err := e.writeWAL(rec); err != nil {
 		return false, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
 	e.store.HSetNX(key, field, value)
+	e.notify(NotifyHash, "hset", key)
 	e.recordWrite()
 	return true, nil
 }
@@ -1240,10 +1724,16 @@ func (e *Engine) HSetNX(key, field string, value []byte) (bool, error) {
 // ========================
 
 // LPush prepends values to a list. Returns new length.
-func (e *Engine) LPush(key string, values ...[]byte) (int, error) {
+func (e *Engine) LPush(key string, values ...[]byte) (_ int, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	if err := e.checkType(key, store.TypeList); err != nil {
+		e.recordCommand()
+		return 0, err
+	}
+
 	records := make([]wal.Record, len(values))
 	for i, v := range values {
 		records[i] = wal.Record{
@@ -1252,20 +1742,28 @@ func (e *Engine) LPush(key string, values ...[]byte) (int, error) {
 			Value: v,
 		}
 	}
-	if err := e.wal.AppendBatch(records); err != nil {
+	if err := e.writeWAL(records...); err != nil {
 		return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
-	result := e.store.LPush(key, values...)
+	result, _ := e.store.LPush(key, values...)
+	e.keyReady(key)
+	e.notify(NotifyList, "lpush", key)
 	e.recordWrite()
 	return result, nil
 }
 
 // RPush appends values to a list. Returns new length.
-func (e *Engine) RPush(key string, values ...[]byte) (int, error) {
+func (e *Engine) RPush(key string, values ...[]byte) (_ int, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	if err := e.checkType(key, store.TypeList); err != nil {
+		e.recordCommand()
+		return 0, err
+	}
+
 	records := make([]wal.Record, len(values))
 	for i, v := range values {
 		records[i] = wal.Record{
@@ -1274,53 +1772,207 @@ func (e *Engine) RPush(key string, values ...[]byte) (int, error) {
 			Value: v,
 		}
 	}
-	if err := e.wal.AppendBatch(records); err != nil {
+	if err := e.writeWAL(records...); err != nil {
 		return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
-	result := e.store.RPush(key, values...)
+	result, _ := e.store.RPush(key, values...)
+	e.keyReady(key)
+	e.notify(NotifyList, "rpush", key)
 	e.recordWrite()
 	return result, nil
 }
 
 // LPop removes and returns the first element.
-func (e *Engine) LPop(key string) ([]byte, bool, error) {
+func (e *Engine) LPop(key string) (_ []byte, _ bool, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	if err := e.checkType(key, store.TypeList); err != nil {
+		e.recordCommand()
+		return nil, false, err
+	}
+
 	rec := wal.Record{
 		Type: wal.OpLPop,
 		Key:  []byte(key),
 	}
-	if err := e.wal.Append(rec); err != nil {
+	if err := e.writeWAL(rec); err != nil {
 		return nil, false, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
-	val, ok := e.store.LPop(key)
+	val, ok, _ := e.store.LPop(key)
+	if ok {
+		e.notifyRemoved(NotifyList, "lpop", key)
+	}
 	e.recordWrite()
 	return val, ok, nil
 }
 
 // RPop removes and returns the last element.
-func (e *Engine) RPop(key string) ([]byte, bool, error) {
+func (e *Engine) RPop(key string) (_ []byte, _ bool, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	if err := e.checkType(key, store.TypeList); err != nil {
+		e.recordCommand()
+		return nil, false, err
+	}
+
 	rec := wal.Record{
 		Type: wal.OpRPop,
 		Key:  []byte(key),
 	}
-	if err := e.wal.Append(rec); err != nil {
+	if err := e.writeWAL(rec); err != nil {
 		return nil, false, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
-	val, ok := e.store.RPop(key)
+	val, ok, _ := e.store.RPop(key)
+	if ok {
+		e.notifyRemoved(NotifyList, "rpop", key)
+	}
 	e.recordWrite()
 	return val, ok, nil
 }
 
+// ErrDstWrongType is returned by LMove when dst holds another type than a
+// list. It is a store.ErrWrongType, told apart so that a blocked BLMOVE
+// fails on it rather than waiting for src to change.
+var ErrDstWrongType = fmt.Errorf("%w", store.ErrWrongType)
+
+// LMove atomically pops an element from one end of src and pushes it onto
+// one end of dst. It returns false, without touching dst, if src is empty.
+func (e *Engine) LMove(src, dst string, fromLeft, toLeft bool) (_ []byte, _ bool, err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.checkType(src, store.TypeList); err != nil {
+		e.recordCommand()
+		return nil, false, err
+	}
+	var val []byte
+	var ok bool
+	if fromLeft {
+		val, ok, _ = e.store.LIndex(src, 0)
+	} else {
+		val, ok, _ = e.store.LIndex(src, -1)
+	}
+	if !ok {
+		e.recordCommand()
+		return nil, false, nil
+	}
+	if err := e.checkType(dst, store.TypeList); err != nil {
+		e.recordCommand()
+		return nil, false, ErrDstWrongType
+	}
+
+	pop := wal.Record{Type: wal.OpRPop, Key: []byte(src)}
+	if fromLeft {
+		pop.Type = wal.OpLPop
+	}
+	push := wal.Record{Type: wal.OpRPush, Key: []byte(dst), Value: val}
+	if toLeft {
+		push.Type = wal.OpLPush
+	}
+	if err := e.writeWAL(pop, push); err != nil {
+		return nil, false, fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+
+	e.apply(pop)
+	e.apply(push)
+	e.keyReady(dst)
+	e.notifyRemoved(NotifyList, listEvent(pop.Type), src)
+	e.notify(NotifyList, listEvent(push.Type), dst)
+	e.recordWrite()
+	return val, true, nil
+}
+
+// LMPop pops up to count elements from the first non-empty list among keys,
+// from the left or the right end. It returns the key popped from, or "" if
+// every list is empty.
+func (e *Engine) LMPop(keys []string, left bool, count int) (_ string, _ [][]byte, err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, key := range keys {
+		if err := e.checkType(key, store.TypeList); err != nil {
+			e.recordCommand()
+			return "", nil, err
+		}
+		n, _ := e.store.LLen(key)
+		if n == 0 {
+			continue
+		}
+		n = min(n, count)
+
+		op := wal.OpRPop
+		if left {
+			op = wal.OpLPop
+		}
+		records := make([]wal.Record, n)
+		for i := range records {
+			records[i] = wal.Record{Type: op, Key: []byte(key)}
+		}
+		if err := e.writeWAL(records...); err != nil {
+			return "", nil, fmt.Errorf("engine: failed to write WAL: %w", err)
+		}
+
+		vals := make([][]byte, n)
+		for i := range vals {
+			if left {
+				vals[i], _, _ = e.store.LPop(key)
+			} else {
+				vals[i], _, _ = e.store.RPop(key)
+			}
+		}
+		e.notifyRemoved(NotifyList, listEvent(op), key)
+		e.recordWrite()
+		return key, vals, nil
+	}
+	e.recordCommand()
+	return "", nil, nil
+}
+
+// listEvent returns the keyspace event of a list push or pop record.
+func listEvent(op byte) string {
+	switch op {
+	case wal.OpLPush:
+		return "lpush"
+	case wal.OpRPush:
+		return "rpush"
+	case wal.OpLPop:
+		return "lpop"
+	}
+	return "rpop"
+}
+
+// OnKeyReady registers fn to be called whenever values are added to a list
+// or a stream in any database, so that clients blocked on the key can be
+// woken. fn runs with the engine lock held and must not call back into the
+// engine.
+func (e *Engine) OnKeyReady(fn func(db int, key string)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.keyReadyHook = fn
+}
+
+// keyReady reports that key may now hold a list or stream with new
+// elements (must hold e.mu).
+func (e *Engine) keyReady(key string) {
+	if e.keyReadyHook == nil {
+		return
+	}
+	if t := e.store.Type(key); t == store.TypeList || t == store.TypeStream {
+		e.keyReadyHook(e.db, key)
+	}
+}
+
 // LLen returns the length of a list.
-func (e *Engine) LLen(key string) int {
+func (e *Engine) LLen(key string) (int, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 	e.recordRead()
@@ -1328,7 +1980,7 @@ func (e *Engine) LLen(key string) int {
 }
 
 // LIndex returns the element at index.
-func (e *Engine) LIndex(key string, index int) ([]byte, bool) {
+func (e *Engine) LIndex(key string, index int) ([]byte, bool, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 	e.recordRead()
@@ -1336,26 +1988,35 @@ func (e *Engine) LIndex(key string, index int) ([]byte, bool) {
 }
 
 // LSet sets the element at index.
-func (e *Engine) LSet(key string, index int, value []byte) error {
+func (e *Engine) LSet(key string, index int, value []byte) (err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	if err := e.checkType(key, store.TypeList); err != nil {
+		e.recordCommand()
+		return err
+	}
+
 	rec := wal.Record{
 		Type:  wal.OpLSet,
 		Key:   []byte(key),
 		Value: encodeListSet(index, value),
 	}
-	if err := e.wal.Append(rec); err != nil {
+	if err := e.writeWAL(rec); err != nil {
 		return fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
-	err := e.store.LSet(key, index, value)
+	err = e.store.LSet(key, index, value)
+	if err == nil {
+		e.notify(NotifyList, "lset", key)
+	}
 	e.recordWrite()
 	return err
 }
 
 // LRange returns elements from start to stop.
-func (e *Engine) LRange(key string, start, stop int) [][]byte {
+func (e *Engine) LRange(key string, start, stop int) ([][]byte, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 	e.recordRead()
@@ -1364,13 +2025,19 @@ func (e *Engine) LRange(key string, start, stop int) [][]byte {
 
 // LInsert inserts value before/after pivot.
 // Returns new length, -1 if pivot not found, 0 if key doesn't exist.
-func (e *Engine) LInsert(key string, before bool, pivot, value []byte) (int, error) {
+func (e *Engine) LInsert(key string, before bool, pivot, value []byte) (_ int, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	e.expireIfNeeded(key)
 	// We persist LPUSH/RPUSH equivalent but for LInsert we need to
 	// persist the entire operation for correct replay
-	result := e.store.LInsert(key, before, pivot, value)
+	result, err := e.store.LInsert(key, before, pivot, value)
+	if err != nil {
+		e.recordCommand()
+		return 0, err
+	}
 	if result > 0 {
 		// Only persist if the insert actually happened
 		direction := byte(0) // 0 = BEFORE
@@ -1390,9 +2057,10 @@ func (e *Engine) LInsert(key string, before bool, pivot, value []byte) (int, err
 			Key:   []byte(key),
 			Value: value,
 		}
-		if err := e.wal.Append(rec); err != nil {
+		if err := e.writeWAL(rec); err != nil {
 			return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
 		}
+		e.notify(NotifyList, "linsert", key)
 	}
 	e.recordWrite()
 	return result, nil
@@ -1403,9 +2071,17 @@ func (e *Engine) LRem(key string, count int, value []byte) (int, error) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	e.expireIfNeeded(key)
 	// For LRem, persist the complete list state after mutation is complex.
 	// We record this by just performing the store op and trusting WAL replay order.
-	result := e.store.LRem(key, count, value)
+	result, err := e.store.LRem(key, count, value)
+	if err != nil {
+		e.recordCommand()
+		return 0, err
+	}
+	if result > 0 {
+		e.notifyRemoved(NotifyList, "lrem", key)
+	}
 	// LRem is idempotent in the sense that during recovery, re-applying all ops
 	// from the beginning will produce the correct state.
 	e.recordWrite()
@@ -1413,20 +2089,27 @@ func (e *Engine) LRem(key string, count int, value []byte) (int, error) {
 }
 
 // LTrim trims the list.
-func (e *Engine) LTrim(key string, start, stop int) error {
+func (e *Engine) LTrim(key string, start, stop int) (err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	if err := e.checkType(key, store.TypeList); err != nil {
+		e.recordCommand()
+		return err
+	}
+
 	rec := wal.Record{
 		Type:  wal.OpLTrim,
 		Key:   []byte(key),
 		Value: encodeRankRange(start, stop),
 	}
-	if err := e.wal.Append(rec); err != nil {
+	if err := e.writeWAL(rec); err != nil {
 		return fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
 	e.store.LTrim(key, start, stop)
+	e.notifyRemoved(NotifyList, "ltrim", key)
 	e.recordWrite()
 	return nil
 }
@@ -1436,10 +2119,16 @@ func (e *Engine) LTrim(key string, start, stop int) error {
 // ========================
 
 // SAdd adds members to a set. Returns number added.
-func (e *Engine) SAdd(key string, members ...string) (int, error) {
+func (e *Engine) SAdd(key string, members ...string) (_ int, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	if err := e.checkType(key, store.TypeSet); err != nil {
+		e.recordCommand()
+		return 0, err
+	}
+
 	records := make([]wal.Record, len(members))
 	for i, m := range members {
 		records[i] = wal.Record{
@@ -1448,20 +2137,29 @@ func (e *Engine) SAdd(key string, members ...string) (int, error) {
 			Value: []byte(m),
 		}
 	}
-	if err := e.wal.AppendBatch(records); err != nil {
+	if err := e.writeWAL(records...); err != nil {
 		return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
-	result := e.store.SAdd(key, members...)
+	result, _ := e.store.SAdd(key, members...)
+	if result > 0 {
+		e.notify(NotifySet, "sadd", key)
+	}
 	e.recordWrite()
 	return result, nil
 }
 
 // SRem removes members from a set. Returns number removed.
-func (e *Engine) SRem(key string, members ...string) (int, error) {
+func (e *Engine) SRem(key string, members ...string) (_ int, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	if err := e.checkType(key, store.TypeSet); err != nil {
+		e.recordCommand()
+		return 0, err
+	}
+
 	records := make([]wal.Record, len(members))
 	for i, m := range members {
 		records[i] = wal.Record{
@@ -1470,17 +2168,20 @@ func (e *Engine) SRem(key string, members ...string) (int, error) {
 			Value: []byte(m),
 		}
 	}
-	if err := e.wal.AppendBatch(records); err != nil {
+	if err := e.writeWAL(records...); err != nil {
 		return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
-	result := e.store.SRem(key, members...)
+	result, _ := e.store.SRem(key, members...)
+	if result > 0 {
+		e.notifyRemoved(NotifySet, "srem", key)
+	}
 	e.recordWrite()
 	return result, nil
 }
 
 // SIsMember returns whether a member exists in a set.
-func (e *Engine) SIsMember(key, member string) bool {
+func (e *Engine) SIsMember(key, member string) (bool, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 	e.recordRead()
@@ -1488,7 +2189,7 @@ func (e *Engine) SIsMember(key, member string) bool {
 }
 
 // SCard returns the cardinality of a set.
-func (e *Engine) SCard(key string) int {
+func (e *Engine) SCard(key string) (int, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 	e.recordRead()
@@ -1496,7 +2197,7 @@ func (e *Engine) SCard(key string) int {
 }
 
 // SMembers returns all members of a set.
-func (e *Engine) SMembers(key string) []string {
+func (e *Engine) SMembers(key string) ([]string, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 	e.recordRead()
@@ -1504,7 +2205,7 @@ func (e *Engine) SMembers(key string) []string {
 }
 
 // SRandMember returns random member(s) from a set.
-func (e *Engine) SRandMember(key string, count int) []string {
+func (e *Engine) SRandMember(key string, count int) ([]string, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 	e.recordRead()
@@ -1512,11 +2213,17 @@ func (e *Engine) SRandMember(key string, count int) []string {
 }
 
 // SPop removes and returns random member(s).
-func (e *Engine) SPop(key string, count int) ([]string, error) {
+func (e *Engine) SPop(key string, count int) (_ []string, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	result := e.store.SPop(key, count)
+	e.expireIfNeeded(key)
+	result, err := e.store.SPop(key, count)
+	if err != nil {
+		e.recordCommand()
+		return nil, err
+	}
 	if len(result) > 0 {
 		records := make([]wal.Record, len(result))
 		for i, m := range result {
@@ -1526,9 +2233,10 @@ func (e *Engine) SPop(key string, count int) ([]string, error) {
 				Value: []byte(m),
 			}
 		}
-		if err := e.wal.AppendBatch(records); err != nil {
+		if err := e.writeWAL(records...); err != nil {
 			return nil, fmt.Errorf("engine: failed to write WAL: %w", err)
 		}
+		e.notifyRemoved(NotifySet, "spop", key)
 	}
 
 	e.recordWrite()
@@ -1536,7 +2244,7 @@ func (e *Engine) SPop(key string, count int) ([]string, error) {
 }
 
 // SInter returns intersection of multiple sets.
-func (e *Engine) SInter(keys ...string) []string {
+func (e *Engine) SInter(keys ...string) ([]string, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 	e.recordRead()
@@ -1544,7 +2252,7 @@ func (e *Engine) SInter(keys ...string) []string {
 }
 
 // SUnion returns union of multiple sets.
-func (e *Engine) SUnion(keys ...string) []string {
+func (e *Engine) SUnion(keys ...string) ([]string, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 	e.recordRead()
@@ -1552,7 +2260,7 @@ func (e *Engine) SUnion(keys ...string) []string {
 }
 
 // SDiff returns members in first set not in any others.
-func (e *Engine) SDiff(keys ...string) []string {
+func (e *Engine) SDiff(keys ...string) ([]string, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 	e.recordRead()
@@ -1581,23 +2289,83 @@ func decodeTSPoint(data []byte) (int64, float64) {
 	return ts, val
 }
 
+// encodeTSMeta encodes a series retention and labels for WAL storage.
+func encodeTSMeta(retention time.Duration, labels map[string]string) []byte {
+	buf := make([]byte, 12, 12+16*len(labels))
+	binary.LittleEndian.PutUint64(buf[:8], uint64(retention.Milliseconds()))
+	binary.LittleEndian.PutUint32(buf[8:12], uint32(len(labels)))
+	for k, v := range labels {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(k)))
+		buf = append(buf, k...)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(v)))
+		buf = append(buf, v...)
+	}
+	return buf
+}
+
+// decodeTSMeta decodes a series retention and labels from WAL bytes.
+func decodeTSMeta(data []byte) (time.Duration, map[string]string) {
+	if len(data) < 12 {
+		return 0, nil
+	}
+	retention := time.Duration(int64(binary.LittleEndian.Uint64(data[:8]))) * time.Millisecond
+	n := int(binary.LittleEndian.Uint32(data[8:12]))
+	labels := make(map[string]string, n)
+	data = data[12:]
+	readString := func() (string, bool) {
+		if len(data) < 4 {
+			return "", false
+		}
+		l := int(binary.LittleEndian.Uint32(data[:4]))
+		if len(data) < 4+l {
+			return "", false
+		}
+		str := string(data[4 : 4+l])
+		data = data[4+l:]
+		return str, true
+	}
+	for i := 0; i < n; i++ {
+		k, ok := readString()
+		if !ok {
+			break
+		}
+		v, ok := readString()
+		if !ok {
+			break
+		}
+		labels[k] = v
+	}
+	return retention, labels
+}
+
 // TSAdd adds a data point to a time-series key.
-func (e *Engine) TSAdd(key string, ts int64, value float64, retention time.Duration) (int64, error) {
+func (e *Engine) TSAdd(key string, ts int64, value float64, retention time.Duration) (_ int64, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	rec := wal.Record{
+	// Resolve "now" before logging so replay inserts the same timestamp.
+	if ts <= 0 {
+		ts = time.Now().UnixMilli()
+	}
+	records := []wal.Record{{
 		Type:  wal.OpTSAdd,
 		Key:   []byte(key),
 		Value: encodeTSPoint(ts, value),
+	}}
+	if !e.timeseries.Exists(key) {
+		// A new series keeps its retention across restarts.
+		meta := wal.Record{Type: wal.OpTSMeta, Key: []byte(key), Value: encodeTSMeta(retention, nil)}
+		records = append([]wal.Record{meta}, records...)
 	}
-	if err := e.wal.Append(rec); err != nil {
+	if err := e.writeWAL(records...); err != nil {
 		return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
 	inserted := e.timeseries.Add(key, ts, value, retention)
 	e.hotkeys.Record(key)
 	e.cdc.Record(cdc.OpTSAdd, key, fmt.Sprintf("%d:%f", inserted, value), "")
+	e.notify(NotifyModule, "ts.add", key)
 	e.recordWrite()
 	return inserted, nil
 }
@@ -1629,7 +2397,8 @@ func (e *Engine) TSInfo(key string) (timeseries.Info, error) {
 }
 
 // TSDel deletes a time-series key.
-func (e *Engine) TSDel(key string) (bool, error) {
+func (e *Engine) TSDel(key string) (_ bool, err error) {
+	defer e.commit(&err)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
@@ -1637,12 +2406,15 @@ func (e *Engine) TSDel(key string) (bool, error) {
 		Type: wal.OpTSDel,
 		Key:  []byte(key),
 	}
-	if err := e.wal.Append(rec); err != nil {
+	if err := e.writeWAL(rec); err != nil {
 		return false, fmt.Errorf("engine: failed to write WAL: %w", err)
 	}
 
 	ok := e.timeseries.Delete(key)
 	e.cdc.Record(cdc.OpDel, key, "", "")
+	if ok {
+		e.notify(NotifyGeneric, "del", key)
+	}
 	e.recordWrite()
 	return ok, nil
 }
@@ -1693,73 +2465,6 @@ func (e *Engine) CDCStats() cdc.Stats {
 	return e.cdc.Stats()
 }
 
-// ========================
-// Snapshot Access
-// ========================
-
-// SnapshotCreate captures a point-in-time snapshot of all string keys.
-func (e *Engine) SnapshotCreate(id string) (snapshot.Meta, error) {
-	e.mu.RLock()
-	defer e.mu.RUnlock()
-
-	snap := &snapshot.Snapshot{ID: id}
-	keys := e.store.Keys()
-	for _, k := range keys {
-		val, ok := e.store.Get(k)
-		if ok {
-			entry := snapshot.KVEntry{Key: k, Value: string(val), Type: "string"}
-			snap.Strings = append(snap.Strings, entry)
-		}
-	}
-	return e.snapMgr.Create(snap)
-}
-
-// SnapshotList returns all available snapshots.
-func (e *Engine) SnapshotList() ([]snapshot.Meta, error) {
-	return e.snapMgr.List()
-}
-
-// SnapshotRestore loads a snapshot and replaces the current string data.
-func (e *Engine) SnapshotRestore(id string) error {
-	snap, err := e.snapMgr.Load(id)
-	if err != nil {
-		return err
-	}
-
-	e.mu.Lock()
-	defer e.mu.Unlock()
-
-	// Clear current data and WAL
-	e.store.Clear()
-	if err := e.wal.Clear(); err != nil {
-		return fmt.Errorf("engine: failed to clear WAL: %w", err)
-	}
-
-	// Re-apply from snapshot
-	records := make([]wal.Record, 0, len(snap.Strings))
-	for _, kv := range snap.Strings {
-		records = append(records, wal.Record{
-			Type:  wal.OpSet,
-			Key:   []byte(kv.Key),
-			Value: []byte(kv.Value),
-		})
-		e.store.Set(kv.Key, []byte(kv.Value))
-	}
-	if len(records) > 0 {
-		if err := e.wal.AppendBatch(records); err != nil {
-			return fmt.Errorf("engine: failed to write WAL batch: %w", err)
-		}
-	}
-
-	e.recordWrite()
-	return nil
-}
-
-// SnapshotDelete removes a snapshot by ID.
-func (e *Engine) SnapshotDelete(id string) error {
-	return e.snapMgr.Delete(id)
-}
-
 // ========================
 // Built-in Benchmark
 // ========================
@@ -1818,7 +2523,7 @@ func (e *Engine) RunBenchmark(n int) BenchmarkResult {
 	// Flush a single batch WAL entry for durability (not per-op).
 	recs := make([]wal.Record, n)
 	for i := 0; i < n; i++ {
-		recs[i] = wal.Record{Type: wal.OpSet, Key: []byte(keys[i]), Value: val}
+		recs[i] = wal.Record{Type: wal.OpSet, Key: []byte(keys[i]), Value: val, DB: e.db}
 	}
 	e.wal.AppendBatch(recs)
 
@@ -1885,7 +2590,7 @@ func (e *Engine) RunBenchmark(n int) BenchmarkResult {
 	// WAL batch for deletes.
 	delRecs := make([]wal.Record, n)
 	for i := 0; i < n; i++ {
-		delRecs[i] = wal.Record{Type: wal.OpDelete, Key: []byte(keys[i])}
+		delRecs[i] = wal.Record{Type: wal.OpDelete, Key: []byte(keys[i]), DB: e.db}
 	}
 	e.wal.AppendBatch(delRecs)
 
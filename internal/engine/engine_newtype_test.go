@@ -4,6 +4,7 @@ import (
 	"path/filepath"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/flashdb/flashdb/internal/store"
 	"github.com/stretchr/testify/assert"
@@ -21,14 +22,14 @@ func TestEngine_HSetAndHGet(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, 2, n)
 
-	val, ok := e.HGet("user:1", "name")
+	val, ok, _ := e.HGet("user:1", "name")
 	assert.True(t, ok)
 	assert.Equal(t, []byte("alice"), val)
 
-	_, ok = e.HGet("user:1", "missing")
+	_, ok, _ = e.HGet("user:1", "missing")
 	assert.False(t, ok)
 
-	_, ok = e.HGet("nokey", "name")
+	_, ok, _ = e.HGet("nokey", "name")
 	assert.False(t, ok)
 }
 
@@ -42,7 +43,9 @@ func TestEngine_HDel(t *testing.T) {
 	n, err := e.HDel("h", "a", "missing")
 	require.NoError(t, err)
 	assert.Equal(t, 1, n)
-	assert.Equal(t, 1, e.HLen("h"))
+	n, err = e.HLen("h")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
 }
 
 func TestEngine_HGetAll(t *testing.T) {
@@ -51,7 +54,7 @@ func TestEngine_HGetAll(t *testing.T) {
 	defer e.Close()
 
 	e.HSet("h", store.HashFieldValue{Field: "x", Value: []byte("1")}, store.HashFieldValue{Field: "y", Value: []byte("2")})
-	pairs := e.HGetAll("h")
+	pairs, _ := e.HGetAll("h")
 	assert.Len(t, pairs, 2)
 }
 
@@ -61,9 +64,9 @@ func TestEngine_HKeysVals(t *testing.T) {
 	defer e.Close()
 
 	e.HSet("h", store.HashFieldValue{Field: "a", Value: []byte("1")}, store.HashFieldValue{Field: "b", Value: []byte("2")})
-	keys := e.HKeys("h")
+	keys, _ := e.HKeys("h")
 	assert.Len(t, keys, 2)
-	vals := e.HVals("h")
+	vals, _ := e.HVals("h")
 	assert.Len(t, vals, 2)
 }
 
@@ -104,7 +107,7 @@ func TestEngine_HSetNX(t *testing.T) {
 	require.NoError(t, err)
 	assert.False(t, ok)
 
-	val, _ := e.HGet("h", "f")
+	val, _, _ := e.HGet("h", "f")
 	assert.Equal(t, []byte("val"), val)
 }
 
@@ -114,9 +117,15 @@ func TestEngine_HExists(t *testing.T) {
 	defer e.Close()
 
 	e.HSet("h", store.HashFieldValue{Field: "a", Value: []byte("1")})
-	assert.True(t, e.HExists("h", "a"))
-	assert.False(t, e.HExists("h", "b"))
-	assert.False(t, e.HExists("nokey", "a"))
+	ok, err := e.HExists("h", "a")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = e.HExists("h", "b")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = e.HExists("nokey", "a")
+	require.NoError(t, err)
+	assert.False(t, ok)
 }
 
 // ─── List engine tests ──────────────────────────────────────────────────────
@@ -134,7 +143,9 @@ func TestEngine_LPushRPush(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, 3, n)
 
-	assert.Equal(t, 3, e.LLen("list"))
+	n, err = e.LLen("list")
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
 }
 
 func TestEngine_LPopRPop(t *testing.T) {
@@ -167,15 +178,15 @@ func TestEngine_LIndex(t *testing.T) {
 
 	e.RPush("list", []byte("a"), []byte("b"), []byte("c"))
 
-	val, ok := e.LIndex("list", 1)
+	val, ok, _ := e.LIndex("list", 1)
 	assert.True(t, ok)
 	assert.Equal(t, []byte("b"), val)
 
-	val, ok = e.LIndex("list", -1)
+	val, ok, _ = e.LIndex("list", -1)
 	assert.True(t, ok)
 	assert.Equal(t, []byte("c"), val)
 
-	_, ok = e.LIndex("list", 99)
+	_, ok, _ = e.LIndex("list", 99)
 	assert.False(t, ok)
 }
 
@@ -189,7 +200,7 @@ func TestEngine_LSet(t *testing.T) {
 	err = e.LSet("list", 1, []byte("x"))
 	require.NoError(t, err)
 
-	val, _ := e.LIndex("list", 1)
+	val, _, _ := e.LIndex("list", 1)
 	assert.Equal(t, []byte("x"), val)
 
 	err = e.LSet("list", 99, []byte("y"))
@@ -203,10 +214,10 @@ func TestEngine_LRange(t *testing.T) {
 
 	e.RPush("list", []byte("a"), []byte("b"), []byte("c"), []byte("d"))
 
-	items := e.LRange("list", 0, -1)
+	items, _ := e.LRange("list", 0, -1)
 	assert.Len(t, items, 4)
 
-	items = e.LRange("list", 1, 2)
+	items, _ = e.LRange("list", 1, 2)
 	assert.Len(t, items, 2)
 	assert.Equal(t, []byte("b"), items[0])
 }
@@ -222,7 +233,7 @@ func TestEngine_LInsert(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, 3, n)
 
-	items := e.LRange("list", 0, -1)
+	items, _ := e.LRange("list", 0, -1)
 	assert.Equal(t, []byte("b"), items[1])
 }
 
@@ -236,7 +247,9 @@ func TestEngine_LRem(t *testing.T) {
 	n, err := e.LRem("list", 1, []byte("a"))
 	require.NoError(t, err)
 	assert.Equal(t, 1, n)
-	assert.Equal(t, 3, e.LLen("list"))
+	n, err = e.LLen("list")
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
 }
 
 func TestEngine_LTrim(t *testing.T) {
@@ -248,9 +261,11 @@ func TestEngine_LTrim(t *testing.T) {
 
 	err = e.LTrim("list", 1, 2)
 	require.NoError(t, err)
-	assert.Equal(t, 2, e.LLen("list"))
+	n, err := e.LLen("list")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
 
-	items := e.LRange("list", 0, -1)
+	items, _ := e.LRange("list", 0, -1)
 	assert.Equal(t, []byte("b"), items[0])
 	assert.Equal(t, []byte("c"), items[1])
 }
@@ -265,7 +280,9 @@ func TestEngine_SAddAndSCard(t *testing.T) {
 	n, err := e.SAdd("set", "a", "b", "c")
 	require.NoError(t, err)
 	assert.Equal(t, 3, n)
-	assert.Equal(t, 3, e.SCard("set"))
+	n, err = e.SCard("set")
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
 
 	// Duplicate
 	n, err = e.SAdd("set", "a", "d")
@@ -282,7 +299,9 @@ func TestEngine_SRem(t *testing.T) {
 	n, err := e.SRem("set", "a", "missing")
 	require.NoError(t, err)
 	assert.Equal(t, 1, n)
-	assert.Equal(t, 2, e.SCard("set"))
+	n, err = e.SCard("set")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
 }
 
 func TestEngine_SIsMember(t *testing.T) {
@@ -291,9 +310,15 @@ func TestEngine_SIsMember(t *testing.T) {
 	defer e.Close()
 
 	e.SAdd("set", "x")
-	assert.True(t, e.SIsMember("set", "x"))
-	assert.False(t, e.SIsMember("set", "y"))
-	assert.False(t, e.SIsMember("nokey", "x"))
+	ok, err := e.SIsMember("set", "x")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = e.SIsMember("set", "y")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = e.SIsMember("nokey", "x")
+	require.NoError(t, err)
+	assert.False(t, ok)
 }
 
 func TestEngine_SMembers(t *testing.T) {
@@ -302,7 +327,7 @@ func TestEngine_SMembers(t *testing.T) {
 	defer e.Close()
 
 	e.SAdd("set", "a", "b", "c")
-	members := e.SMembers("set")
+	members, _ := e.SMembers("set")
 	sort.Strings(members)
 	assert.Equal(t, []string{"a", "b", "c"}, members)
 }
@@ -313,7 +338,7 @@ func TestEngine_SRandMember(t *testing.T) {
 	defer e.Close()
 
 	e.SAdd("set", "a", "b", "c")
-	members := e.SRandMember("set", 2)
+	members, _ := e.SRandMember("set", 2)
 	assert.Len(t, members, 2)
 }
 
@@ -326,7 +351,9 @@ func TestEngine_SPop(t *testing.T) {
 	popped, err := e.SPop("set", 2)
 	require.NoError(t, err)
 	assert.Len(t, popped, 2)
-	assert.Equal(t, 1, e.SCard("set"))
+	n, err := e.SCard("set")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
 }
 
 func TestEngine_SInter(t *testing.T) {
@@ -337,7 +364,7 @@ func TestEngine_SInter(t *testing.T) {
 	e.SAdd("s1", "a", "b", "c")
 	e.SAdd("s2", "b", "c", "d")
 
-	result := e.SInter("s1", "s2")
+	result, _ := e.SInter("s1", "s2")
 	sort.Strings(result)
 	assert.Equal(t, []string{"b", "c"}, result)
 }
@@ -350,7 +377,7 @@ func TestEngine_SUnion(t *testing.T) {
 	e.SAdd("s1", "a", "b")
 	e.SAdd("s2", "b", "c")
 
-	result := e.SUnion("s1", "s2")
+	result, _ := e.SUnion("s1", "s2")
 	sort.Strings(result)
 	assert.Equal(t, []string{"a", "b", "c"}, result)
 }
@@ -363,7 +390,7 @@ func TestEngine_SDiff(t *testing.T) {
 	e.SAdd("s1", "a", "b", "c")
 	e.SAdd("s2", "b")
 
-	result := e.SDiff("s1", "s2")
+	result, _ := e.SDiff("s1", "s2")
 	sort.Strings(result)
 	assert.Equal(t, []string{"a", "c"}, result)
 }
@@ -379,14 +406,88 @@ func TestEngine_KeyType_NewTypes(t *testing.T) {
 	e.HSet("hash", store.HashFieldValue{Field: "f", Value: []byte("v")})
 	e.RPush("list", []byte("a"))
 	e.SAdd("set", "x")
+	e.ZAdd("zset", store.ScoredMember{Member: "m", Score: 1})
 
 	assert.Equal(t, "string", e.KeyType("str"))
 	assert.Equal(t, "hash", e.KeyType("hash"))
 	assert.Equal(t, "list", e.KeyType("list"))
 	assert.Equal(t, "set", e.KeyType("set"))
+	assert.Equal(t, "zset", e.KeyType("zset"))
 	assert.Equal(t, "none", e.KeyType("missing"))
 }
 
+func TestEngine_WrongType(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+
+	require.NoError(t, e.Set("str", []byte("hello")))
+	_, err = e.SAdd("set", "x")
+	require.NoError(t, err)
+
+	_, err = e.HSet("str", store.HashFieldValue{Field: "f", Value: []byte("v")})
+	assert.ErrorIs(t, err, store.ErrWrongType)
+	_, err = e.LPush("set", []byte("a"))
+	assert.ErrorIs(t, err, store.ErrWrongType)
+	_, err = e.Append("set", []byte("a"))
+	assert.ErrorIs(t, err, store.ErrWrongType)
+	_, _, err = e.Get("set")
+	assert.ErrorIs(t, err, store.ErrWrongType)
+	_, err = e.SInter("set", "str")
+	assert.ErrorIs(t, err, store.ErrWrongType)
+
+	// Only the two successful writes are in the keyspace.
+	assert.Equal(t, 2, e.Size())
+	assert.ElementsMatch(t, []string{"str", "set"}, e.Keys())
+	require.NoError(t, e.Close())
+
+	// Rejected commands must not have been logged.
+	e2, err := New(walPath)
+	require.NoError(t, err)
+	defer e2.Close()
+	assert.Equal(t, "string", e2.KeyType("str"))
+	assert.Equal(t, "set", e2.KeyType("set"))
+}
+
+func TestEngine_RenameCopyContainers(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+
+	e.HSet("h", store.HashFieldValue{Field: "f", Value: []byte("v")})
+	e.ZAdd("z", store.ScoredMember{Member: "m", Score: 1})
+
+	renamed, err := e.Rename("h", "h2", false)
+	require.NoError(t, err)
+	assert.True(t, renamed)
+	copied, err := e.Copy("z", "z2", false)
+	require.NoError(t, err)
+	assert.True(t, copied)
+
+	// The copy is independent of its source.
+	e.ZAdd("z2", store.ScoredMember{Member: "other", Score: 2})
+	n, err := e.ZCard("z")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	require.NoError(t, e.Close())
+
+	e2, err := New(walPath)
+	require.NoError(t, err)
+	defer e2.Close()
+
+	assert.Equal(t, "none", e2.KeyType("h"))
+	val, ok, err := e2.HGet("h2", "f")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("v"), val)
+	n, err = e2.ZCard("z2")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = e2.ZCard("z")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
 // ─── WAL recovery tests ─────────────────────────────────────────────────────
 
 func TestEngine_HashRecovery(t *testing.T) {
@@ -405,13 +506,15 @@ func TestEngine_HashRecovery(t *testing.T) {
 	require.NoError(t, err)
 	defer e2.Close()
 
-	val, ok := e2.HGet("h", "a")
+	val, ok, _ := e2.HGet("h", "a")
 	assert.True(t, ok)
 	assert.Equal(t, []byte("1"), val)
 
-	_, ok = e2.HGet("h", "b")
+	_, ok, _ = e2.HGet("h", "b")
 	assert.False(t, ok)
-	assert.Equal(t, 1, e2.HLen("h"))
+	n, err := e2.HLen("h")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
 }
 
 func TestEngine_ListRecovery(t *testing.T) {
@@ -428,8 +531,10 @@ func TestEngine_ListRecovery(t *testing.T) {
 	require.NoError(t, err)
 	defer e2.Close()
 
-	assert.Equal(t, 2, e2.LLen("l"))
-	items := e2.LRange("l", 0, -1)
+	n, err := e2.LLen("l")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	items, _ := e2.LRange("l", 0, -1)
 	assert.Equal(t, []byte("b"), items[0])
 	assert.Equal(t, []byte("c"), items[1])
 }
@@ -448,8 +553,101 @@ func TestEngine_SetRecovery(t *testing.T) {
 	require.NoError(t, err)
 	defer e2.Close()
 
-	assert.Equal(t, 2, e2.SCard("s"))
-	assert.True(t, e2.SIsMember("s", "a"))
-	assert.False(t, e2.SIsMember("s", "b"))
-	assert.True(t, e2.SIsMember("s", "c"))
+	n, err := e2.SCard("s")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	ok, err := e2.SIsMember("s", "a")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = e2.SIsMember("s", "b")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = e2.SIsMember("s", "c")
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestEngine_ExpireContainers(t *testing.T) {
+	e, err := New(filepath.Join(t.TempDir(), "test.wal"))
+	require.NoError(t, err)
+	defer e.Close()
+
+	e.HSet("session", store.HashFieldValue{Field: "user", Value: []byte("alice")})
+	e.ZAdd("ratelimit", store.ScoredMember{Member: "req1", Score: 1})
+
+	ok, err := e.Expire("session", 50*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = e.Expire("ratelimit", time.Hour)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, e.TTL("ratelimit") > 0)
+
+	ok, err = e.Persist("ratelimit")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(-1), e.TTL("ratelimit"))
+
+	time.Sleep(80 * time.Millisecond)
+	assert.Equal(t, "none", e.KeyType("session"))
+	assert.Equal(t, int64(-2), e.TTL("session"))
+
+	// A write after expiry starts from an empty hash.
+	e.HSet("session", store.HashFieldValue{Field: "user", Value: []byte("bob")})
+	n, err := e.HLen("session")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, int64(-1), e.TTL("session"))
+	assert.Equal(t, int64(1), e.GetStats().ExpiredKeys)
+}
+
+func TestEngine_IncrKeepsTTL(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+
+	require.NoError(t, e.SetWithTTL("counter", []byte("1"), time.Hour))
+	_, err = e.IncrBy("counter", 1)
+	require.NoError(t, err)
+	_, err = e.Append("counter", []byte("0"))
+	require.NoError(t, err)
+	assert.True(t, e.TTL("counter") > 0)
+	require.NoError(t, e.Close())
+
+	e2, err := New(walPath)
+	require.NoError(t, err)
+	defer e2.Close()
+	val, _, _ := e2.Get("counter")
+	assert.Equal(t, []byte("20"), val)
+	assert.True(t, e2.TTL("counter") > 0)
+}
+
+func TestEngine_ExpireRecovery(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+
+	e.HSet("gone", store.HashFieldValue{Field: "a", Value: []byte("1")})
+	e.Expire("gone", 50*time.Millisecond)
+	e.SAdd("kept", "m")
+	e.Expire("kept", time.Hour)
+
+	// "reborn" expires and is then recreated without a TTL.
+	e.RPush("reborn", []byte("old"))
+	e.Expire("reborn", 50*time.Millisecond)
+	time.Sleep(80 * time.Millisecond)
+	e.RPush("reborn", []byte("new"))
+	require.NoError(t, e.Close())
+
+	e2, err := New(walPath)
+	require.NoError(t, err)
+	defer e2.Close()
+
+	assert.Equal(t, "none", e2.KeyType("gone"))
+	assert.Equal(t, int64(-2), e2.TTL("gone"))
+	assert.True(t, e2.TTL("kept") > 0)
+	items, err := e2.LRange("reborn", 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("new")}, items)
+	assert.Equal(t, int64(-1), e2.TTL("reborn"))
 }
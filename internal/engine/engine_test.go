@@ -1,11 +1,18 @@
 package engine
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/flashdb/flashdb/internal/snapshot"
+	"github.com/flashdb/flashdb/internal/store"
+	"github.com/flashdb/flashdb/internal/wal"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -32,7 +39,7 @@ func TestEngine_SetAndGet(t *testing.T) {
 	err = e.Set("key1", []byte("value1"))
 	require.NoError(t, err)
 
-	val, found := e.Get("key1")
+	val, found, _ := e.Get("key1")
 	assert.True(t, found)
 	assert.Equal(t, []byte("value1"), val)
 }
@@ -50,7 +57,7 @@ func TestEngine_Delete(t *testing.T) {
 	require.NoError(t, err)
 	assert.True(t, deleted)
 
-	_, found := e.Get("key1")
+	_, found, _ := e.Get("key1")
 	assert.False(t, found)
 }
 
@@ -64,13 +71,13 @@ func TestEngine_SetWithTTL(t *testing.T) {
 	err = e.SetWithTTL("key1", []byte("value1"), 100*time.Millisecond)
 	require.NoError(t, err)
 
-	val, found := e.Get("key1")
+	val, found, _ := e.Get("key1")
 	assert.True(t, found)
 	assert.Equal(t, []byte("value1"), val)
 
 	time.Sleep(150 * time.Millisecond)
 
-	_, found = e.Get("key1")
+	_, found, _ = e.Get("key1")
 	assert.False(t, found)
 }
 
@@ -110,7 +117,7 @@ func TestEngine_Expire(t *testing.T) {
 
 	time.Sleep(150 * time.Millisecond)
 
-	_, found := e.Get("key1")
+	_, found, _ := e.Get("key1")
 	assert.False(t, found)
 }
 
@@ -175,7 +182,7 @@ func TestEngine_Append(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, 11, length)
 
-	val, _ := e.Get("key1")
+	val, _, _ := e.Get("key1")
 	assert.Equal(t, []byte("Hello World"), val)
 }
 
@@ -209,10 +216,10 @@ func TestEngine_Recovery(t *testing.T) {
 	require.NoError(t, err)
 	defer e2.Close()
 
-	_, found := e2.Get("key1")
+	_, found, _ := e2.Get("key1")
 	assert.False(t, found)
 
-	val, found := e2.Get("key2")
+	val, found, _ := e2.Get("key2")
 	assert.True(t, found)
 	assert.Equal(t, []byte("value2"), val)
 }
@@ -267,10 +274,10 @@ func TestEngine_RenamePreservesTTL(t *testing.T) {
 	require.NoError(t, err)
 	assert.True(t, renamed)
 
-	_, oldExists := e.Get("old")
+	_, oldExists, _ := e.Get("old")
 	assert.False(t, oldExists)
 
-	val, newExists := e.Get("new")
+	val, newExists, _ := e.Get("new")
 	assert.True(t, newExists)
 	assert.Equal(t, []byte("value"), val)
 	assert.True(t, e.PTTL("new") > 0)
@@ -281,7 +288,7 @@ func TestEngine_RenamePreservesTTL(t *testing.T) {
 	require.NoError(t, err)
 	defer e2.Close()
 
-	val, newExists = e2.Get("new")
+	val, newExists, _ = e2.Get("new")
 	assert.True(t, newExists)
 	assert.Equal(t, []byte("value"), val)
 	assert.True(t, e2.PTTL("new") > 0)
@@ -302,11 +309,11 @@ func TestEngine_RenameNX(t *testing.T) {
 	require.NoError(t, err)
 	assert.False(t, renamed)
 
-	val, exists := e.Get("old")
+	val, exists, _ := e.Get("old")
 	assert.True(t, exists)
 	assert.Equal(t, []byte("value-old"), val)
 
-	val, exists = e.Get("new")
+	val, exists, _ = e.Get("new")
 	assert.True(t, exists)
 	assert.Equal(t, []byte("value-new"), val)
 }
@@ -325,12 +332,12 @@ func TestEngine_CopyPreservesTTLAndRecovery(t *testing.T) {
 	require.NoError(t, err)
 	assert.True(t, copied)
 
-	sourceVal, sourceExists := e.Get("source")
+	sourceVal, sourceExists, _ := e.Get("source")
 	assert.True(t, sourceExists)
 	assert.Equal(t, []byte("value"), sourceVal)
 	assert.True(t, e.PTTL("source") > 0)
 
-	destVal, destExists := e.Get("dest")
+	destVal, destExists, _ := e.Get("dest")
 	assert.True(t, destExists)
 	assert.Equal(t, []byte("value"), destVal)
 	assert.True(t, e.PTTL("dest") > 0)
@@ -345,8 +352,536 @@ func TestEngine_CopyPreservesTTLAndRecovery(t *testing.T) {
 	require.NoError(t, err)
 	defer e2.Close()
 
-	destVal, destExists = e2.Get("dest")
+	destVal, destExists, _ = e2.Get("dest")
 	assert.True(t, destExists)
 	assert.Equal(t, []byte("value"), destVal)
 	assert.True(t, e2.PTTL("dest") > 0)
 }
+
+func TestEngine_Rewrite(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, e.Set("counter", []byte(strconv.Itoa(i))))
+	}
+	require.NoError(t, e.SetWithTTL("session", []byte("s"), time.Hour))
+	e.HSet("h", store.HashFieldValue{Field: "f", Value: []byte("v")})
+	e.Expire("h", time.Hour)
+	e.RPush("l", []byte("a"), []byte("b"))
+	e.SAdd("s", "m")
+	e.ZAdd("z", store.ScoredMember{Member: "m", Score: 2.5})
+	e.TSAdd("ts", 1000, 1.5, 0)
+	e.Delete("missing")
+
+	before := e.PersistenceStats().WALSize
+	require.NoError(t, e.Rewrite())
+	stats := e.PersistenceStats()
+	assert.Less(t, stats.WALSize, before)
+	assert.Equal(t, stats.WALSize, stats.WALBaseSize)
+	assert.Equal(t, int64(1), stats.Rewrites)
+	assert.True(t, stats.LastRewriteOK)
+
+	// Writes after the rewrite are appended to the new log.
+	require.NoError(t, e.Set("after", []byte("1")))
+	require.NoError(t, e.Close())
+
+	e2, err := New(walPath)
+	require.NoError(t, err)
+	defer e2.Close()
+
+	val, _, _ := e2.Get("counter")
+	assert.Equal(t, []byte("99"), val)
+	assert.True(t, e2.TTL("session") > 0)
+	assert.True(t, e2.TTL("h") > 0)
+	items, _ := e2.LRange("l", 0, -1)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, items)
+	ok, _ := e2.SIsMember("s", "m")
+	assert.True(t, ok)
+	score, _, _ := e2.ZScore("z", "m")
+	assert.Equal(t, 2.5, score)
+	p, ok := e2.TSGet("ts")
+	assert.True(t, ok)
+	assert.Equal(t, int64(1000), p.Timestamp)
+	val, _, _ = e2.Get("after")
+	assert.Equal(t, []byte("1"), val)
+}
+
+func TestEngine_RewriteConcurrentWrites(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+
+	for i := 0; i < 200; i++ {
+		require.NoError(t, e.Set(fmt.Sprintf("key%d", i), []byte("v")))
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			e.Set(fmt.Sprintf("new%d", i), []byte("v"))
+		}
+	}()
+	require.NoError(t, e.BackgroundRewrite())
+	wg.Wait()
+
+	// Close waits for the background rewrite to finish.
+	require.NoError(t, e.Close())
+
+	e2, err := New(walPath)
+	require.NoError(t, err)
+	defer e2.Close()
+	assert.Equal(t, 400, e2.Size())
+}
+
+func TestEngine_AutoRewriteThreshold(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AutoRewriteMinSize = 1024
+	e, err := NewWithConfig(filepath.Join(t.TempDir(), "test.wal"), cfg)
+	require.NoError(t, err)
+	defer e.Close()
+
+	assert.False(t, e.needsRewrite())
+	for i := 0; i < 100; i++ {
+		require.NoError(t, e.Set("key", []byte("value")))
+	}
+	assert.True(t, e.needsRewrite())
+	require.NoError(t, e.Rewrite())
+	assert.False(t, e.needsRewrite())
+}
+
+func TestEngine_SyncPolicyEverySec(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	cfg := DefaultConfig()
+	cfg.SyncPolicy = wal.SyncEverySec
+	e, err := NewWithConfig(walPath, cfg)
+	require.NoError(t, err)
+
+	require.NoError(t, e.Set("key", []byte("value")))
+	_, err = e.HSet("hash", store.HashFieldValue{Field: "field", Value: []byte("v")})
+	require.NoError(t, err)
+
+	ps := e.PersistenceStats()
+	assert.Equal(t, wal.SyncEverySec, ps.SyncPolicy)
+	assert.Positive(t, ps.PendingBytes)
+	require.NoError(t, e.Close())
+
+	e2, err := New(walPath)
+	require.NoError(t, err)
+	defer e2.Close()
+	val, ok, _ := e2.Get("key")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value"), val)
+	assert.Zero(t, e2.PersistenceStats().PendingBytes)
+}
+
+func TestEngine_GroupCommit(t *testing.T) {
+	e, err := New(filepath.Join(t.TempDir(), "test.wal"))
+	require.NoError(t, err)
+	defer e.Close()
+
+	const writers = 32
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, e.Set(fmt.Sprintf("key%d", i), []byte("v")))
+		}(i)
+	}
+	wg.Wait()
+
+	ps := e.PersistenceStats()
+	assert.Equal(t, writers, e.Size())
+	assert.Zero(t, ps.PendingBytes)
+	assert.LessOrEqual(t, ps.Fsyncs, int64(writers))
+}
+
+func TestEngine_SnapshotRoundTrip(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+
+	require.NoError(t, e.Set("str", []byte("hello")))
+	require.NoError(t, e.SetWithTTL("temp", []byte("v"), time.Hour))
+	_, err = e.HSet("hash", store.HashFieldValue{Field: "f", Value: []byte("1")})
+	require.NoError(t, err)
+	_, err = e.Expire("hash", time.Hour)
+	require.NoError(t, err)
+	_, err = e.RPush("list", []byte("a"), []byte("b"), []byte("c"))
+	require.NoError(t, err)
+	_, err = e.SAdd("set", "x", "y")
+	require.NoError(t, err)
+	_, err = e.ZAdd("zset", store.ScoredMember{Member: "m", Score: 2.5})
+	require.NoError(t, err)
+	_, err = e.TSAdd("ts", 1000, 1.5, 24*time.Hour)
+	require.NoError(t, err)
+	e.timeseries.Create("ts", 24*time.Hour, map[string]string{"host": "a"})
+
+	_, err = e.SnapshotCreate("full")
+	require.NoError(t, err)
+
+	// Change everything after the snapshot.
+	require.NoError(t, e.Set("str", []byte("changed")))
+	_, err = e.Delete("list")
+	require.NoError(t, err)
+	_, err = e.Delete("set")
+	require.NoError(t, err)
+	require.NoError(t, e.Set("extra", []byte("gone after restore")))
+	_, err = e.TSDel("ts")
+	require.NoError(t, err)
+
+	require.NoError(t, e.SnapshotRestore("full"))
+
+	check := func(e *Engine) {
+		val, ok, _ := e.Get("str")
+		assert.True(t, ok)
+		assert.Equal(t, []byte("hello"), val)
+		_, ok, _ = e.Get("extra")
+		assert.False(t, ok)
+		assert.Greater(t, e.TTL("temp"), int64(3500))
+		assert.Greater(t, e.TTL("hash"), int64(3500))
+		fields, err := e.HGetAll("hash")
+		require.NoError(t, err)
+		assert.Len(t, fields, 1)
+		list, err := e.LRange("list", 0, -1)
+		require.NoError(t, err)
+		assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c")}, list)
+		members, err := e.SMembers("set")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"x", "y"}, members)
+		score, ok, _ := e.ZScore("zset", "m")
+		assert.True(t, ok)
+		assert.Equal(t, 2.5, score)
+		ser, ok := e.timeseries.Snapshot("ts")
+		require.True(t, ok)
+		assert.Len(t, ser.Points, 1)
+		assert.Equal(t, 24*time.Hour, ser.Retention)
+		assert.Equal(t, map[string]string{"host": "a"}, ser.Labels)
+	}
+	check(e)
+	require.NoError(t, e.Close())
+
+	// The WAL was replaced too, so a restart sees the restored dataset.
+	e2, err := New(walPath)
+	require.NoError(t, err)
+	defer e2.Close()
+	check(e2)
+}
+
+func TestEngine_SnapshotRestoreCorrupt(t *testing.T) {
+	e, err := New(filepath.Join(t.TempDir(), "test.wal"))
+	require.NoError(t, err)
+	defer e.Close()
+
+	require.NoError(t, e.Set("key", []byte("before")))
+	meta, err := e.SnapshotCreate("snap")
+	require.NoError(t, err)
+	require.NoError(t, e.Set("key", []byte("after")))
+
+	require.NoError(t, os.Truncate(meta.FilePath, meta.SizeBytes-1))
+	err = e.SnapshotRestore("snap")
+	assert.ErrorIs(t, err, snapshot.ErrTruncated)
+
+	// A bad snapshot leaves the current dataset alone.
+	val, _, _ := e.Get("key")
+	assert.Equal(t, []byte("after"), val)
+}
+
+func TestEngine_CheckpointStartup(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, e.Set(fmt.Sprintf("key%d", i), []byte("value")))
+	}
+	_, err = e.TSAdd("ts", 1000, 1, time.Hour)
+	require.NoError(t, err)
+	before := e.PersistenceStats().WALSize
+
+	_, err = e.SnapshotCreate("cp")
+	require.NoError(t, err)
+
+	// Only the checkpoint marker is left in the WAL.
+	assert.Less(t, e.PersistenceStats().WALSize, before/10)
+	assert.ErrorIs(t, e.SnapshotDelete("cp"), ErrSnapshotInUse)
+
+	require.NoError(t, e.Set("after", []byte("tail")))
+	_, err = e.Delete("key0")
+	require.NoError(t, err)
+	require.NoError(t, e.Close())
+
+	e2, err := New(walPath)
+	require.NoError(t, err)
+	assert.Equal(t, 100, e2.Size())
+	val, ok, _ := e2.Get("after")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("tail"), val)
+	_, ok, _ = e2.Get("key0")
+	assert.False(t, ok)
+	p, ok := e2.TSGet("ts")
+	assert.True(t, ok)
+	assert.Equal(t, int64(1000), p.Timestamp)
+	require.NoError(t, e2.Close())
+
+	// The older WAL records are gone, so the snapshot is required.
+	require.NoError(t, os.Remove(filepath.Join(filepath.Dir(walPath), "snapshots", "cp.snap")))
+	_, err = New(walPath)
+	assert.Error(t, err)
+}
+
+func TestEngine_CheckpointSkipsInvalidSnapshot(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+
+	require.NoError(t, e.Set("a", []byte("1")))
+	_, err = e.SnapshotCreate("first")
+	require.NoError(t, err)
+	require.NoError(t, e.Set("b", []byte("2")))
+
+	// A marker without a usable snapshot falls back to the previous one.
+	require.NoError(t, e.wal.Append(wal.Record{Type: wal.OpCheckpoint, Key: []byte("missing"), Value: encodeCheckpoint(time.Now())}))
+	require.NoError(t, e.Set("c", []byte("3")))
+	require.NoError(t, e.Close())
+
+	e2, err := New(walPath)
+	require.NoError(t, err)
+	defer e2.Close()
+	assert.Equal(t, 3, e2.Size())
+}
+
+func TestEngine_BackgroundSnapshot(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+	defer e.Close()
+
+	for i := 0; i < 5000; i++ {
+		require.NoError(t, e.Set(fmt.Sprintf("key%d", i), []byte("before")))
+	}
+	_, err = e.RPush("list", []byte("a"))
+	require.NoError(t, err)
+
+	meta, err := e.BackgroundSnapshot("bg")
+	require.NoError(t, err)
+	assert.Equal(t, "bg", meta.ID)
+
+	// Writes go ahead while the snapshot is written and are not part of it.
+	for i := 0; i < 5000; i++ {
+		require.NoError(t, e.Set(fmt.Sprintf("key%d", i), []byte("after")))
+	}
+	_, err = e.RPush("list", []byte("b"))
+	require.NoError(t, err)
+	require.NoError(t, e.Set("new", []byte("after")))
+
+	require.Eventually(t, func() bool {
+		return !e.PersistenceStats().SnapshotInProgress
+	}, 5*time.Second, 10*time.Millisecond)
+	stats := e.PersistenceStats()
+	assert.True(t, stats.LastSnapshotOK)
+	assert.Equal(t, int64(1), stats.Snapshots)
+	assert.Equal(t, "bg", stats.Checkpoint)
+
+	metas, err := e.SnapshotList()
+	require.NoError(t, err)
+	require.Len(t, metas, 1)
+	assert.Equal(t, snapshot.StatusComplete, metas[0].Status)
+
+	require.NoError(t, e.SnapshotRestore("bg"))
+	val, _, _ := e.Get("key4999")
+	assert.Equal(t, []byte("before"), val)
+	n, _ := e.LLen("list")
+	assert.Equal(t, 1, n)
+	_, ok, _ := e.Get("new")
+	assert.False(t, ok)
+}
+
+func TestParseSaveRules(t *testing.T) {
+	rules, err := ParseSaveRules("900 1  60 10000")
+	require.NoError(t, err)
+	assert.Equal(t, []SaveRule{{Interval: 900 * time.Second, Changes: 1}, {Interval: time.Minute, Changes: 10000}}, rules)
+
+	rules, err = ParseSaveRules("")
+	require.NoError(t, err)
+	assert.Empty(t, rules)
+
+	for _, s := range []string{"900", "0 1", "x 1", "60 -1"} {
+		_, err := ParseSaveRules(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestRetentionPolicy(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 30, 0, 0, time.Local)
+	var metas []snapshot.Meta
+	// Two snapshots an hour for the last three days.
+	for i := 0; i < 144; i++ {
+		at := now.Add(-time.Duration(i) * 30 * time.Minute)
+		metas = append(metas, snapshot.Meta{ID: fmt.Sprintf("auto-%d", i), CreatedAt: at})
+	}
+	ids := func(metas []snapshot.Meta) map[string]bool {
+		m := make(map[string]bool)
+		for _, meta := range metas {
+			m[meta.ID] = true
+		}
+		return m
+	}
+
+	drop := RetentionPolicy{KeepHourly: 24, KeepDaily: 7}.prune(metas, now)
+	kept := 144 - len(drop)
+	// 24 hourly snapshots reach back into yesterday, so the daily rule only
+	// adds the two days before that.
+	assert.Equal(t, 26, kept)
+	assert.False(t, ids(drop)["auto-0"])
+	assert.True(t, ids(drop)["auto-1"])
+
+	drop = RetentionPolicy{KeepLast: 3}.prune(metas, now)
+	assert.Len(t, drop, 141)
+
+	drop = RetentionPolicy{MaxAge: 2 * time.Hour}.prune(metas, now)
+	assert.Len(t, drop, 144-5)
+
+	assert.Empty(t, RetentionPolicy{}.prune(metas, now))
+}
+
+func TestEngine_SaveRules(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	cfg := DefaultConfig()
+	cfg.SaveRules = []SaveRule{{Interval: time.Second, Changes: 2}}
+	cfg.SnapshotRetention = RetentionPolicy{KeepLast: 1}
+	e, err := NewWithConfig(walPath, cfg)
+	require.NoError(t, err)
+	defer e.Close()
+
+	start := e.LastSave()
+	require.NoError(t, e.Set("a", []byte("1")))
+	time.Sleep(1500 * time.Millisecond)
+	// One change is not enough.
+	assert.Equal(t, int64(0), e.PersistenceStats().Snapshots)
+	assert.Equal(t, start, e.LastSave())
+
+	require.NoError(t, e.Set("b", []byte("2")))
+	require.Eventually(t, func() bool {
+		return e.PersistenceStats().Snapshots == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	stats := e.PersistenceStats()
+	assert.Equal(t, int64(0), stats.ChangesSinceSave)
+	assert.True(t, stats.LastSave.After(start))
+
+	// Only the newest scheduled snapshot is kept; named ones are never pruned.
+	_, err = e.SnapshotCreate("named")
+	require.NoError(t, err)
+	require.NoError(t, e.Set("c", []byte("3")))
+	require.NoError(t, e.Set("d", []byte("4")))
+	require.Eventually(t, func() bool {
+		return e.PersistenceStats().Snapshots == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	metas, err := e.SnapshotList()
+	require.NoError(t, err)
+	require.Len(t, metas, 2)
+	assert.True(t, strings.HasPrefix(metas[0].ID, "auto-"))
+	assert.Equal(t, "named", metas[1].ID)
+}
+
+func TestEngine_DumpRestore(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+
+	require.NoError(t, e.SetWithTTL("str", []byte("hello"), time.Hour))
+	_, err = e.RPush("list", []byte("a"), []byte("b"))
+	require.NoError(t, err)
+	_, err = e.ZAdd("zset", store.ScoredMember{Member: "m", Score: 2})
+	require.NoError(t, err)
+
+	_, ok, err := e.Dump("missing")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	for _, key := range []string{"str", "list", "zset"} {
+		payload, ok, err := e.Dump(key)
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.ErrorIs(t, e.Restore(key, payload, 0, false), ErrBusyKey)
+		require.NoError(t, e.Restore(key+"2", payload, 0, false))
+	}
+	// An expireAt of 0 means no expiry, whatever the payload held.
+	assert.Equal(t, int64(-1), e.TTL("str2"))
+	assert.Equal(t, int64(-1), e.TTL("list2"))
+
+	// REPLACE swaps the type and an explicit expiry overrides the payload's.
+	payload, _, err := e.Dump("list")
+	require.NoError(t, err)
+	require.NoError(t, e.Restore("str2", payload, time.Now().Add(time.Minute).UnixMilli(), true))
+	assert.LessOrEqual(t, e.TTL("str2"), int64(60))
+
+	payload[len(payload)-1] ^= 0xFF
+	assert.ErrorIs(t, e.Restore("bad", payload, 0, false), snapshot.ErrBadPayload)
+	require.NoError(t, e.Close())
+
+	e2, err := New(walPath)
+	require.NoError(t, err)
+	defer e2.Close()
+	val, ok, _ := e2.Get("str")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("hello"), val)
+	list, err := e2.LRange("str2", 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, list)
+	zs, err := e2.ZRange("zset2", 0, -1, true)
+	require.NoError(t, err)
+	assert.Equal(t, []store.ScoredMember{{Member: "m", Score: 2}}, zs)
+	assert.False(t, e2.Exists("bad"))
+}
+
+func TestEngine_LMoveLMPop(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+
+	var pushed []string
+	e.OnKeyReady(func(_ int, key string) { pushed = append(pushed, key) })
+
+	_, err = e.RPush("src", []byte("a"), []byte("b"), []byte("c"))
+	require.NoError(t, err)
+	require.NoError(t, e.Set("str", []byte("x")))
+
+	val, ok, err := e.LMove("src", "dst", true, false)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("a"), val)
+
+	_, ok, err = e.LMove("missing", "str", true, true)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, _, err = e.LMove("src", "str", true, true)
+	assert.ErrorIs(t, err, store.ErrWrongType)
+	assert.Equal(t, []string{"src", "dst"}, pushed)
+
+	key, vals, err := e.LMPop([]string{"missing", "src"}, false, 5)
+	require.NoError(t, err)
+	assert.Equal(t, "src", key)
+	assert.Equal(t, [][]byte{[]byte("c"), []byte("b")}, vals)
+	assert.False(t, e.Exists("src"))
+
+	key, _, err = e.LMPop([]string{"missing", "src"}, true, 1)
+	require.NoError(t, err)
+	assert.Empty(t, key)
+	_, _, err = e.LMPop([]string{"str", "dst"}, true, 1)
+	assert.ErrorIs(t, err, store.ErrWrongType)
+	require.NoError(t, e.Close())
+
+	e2, err := New(walPath)
+	require.NoError(t, err)
+	defer e2.Close()
+	assert.False(t, e2.Exists("src"))
+	list, err := e2.LRange("dst", 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("a")}, list)
+}
@@ -0,0 +1,263 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flashdb/flashdb/internal/cdc"
+	"github.com/flashdb/flashdb/internal/store"
+	"github.com/flashdb/flashdb/internal/wal"
+)
+
+// ErrOOM is returned by FreeMemory when memory is over the limit and the
+// eviction policy cannot bring it back under.
+var ErrOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'.")
+
+// DefaultMaxMemorySamples is the number of keys sampled per database to
+// pick one to evict, when Config.MaxMemorySamples is unset.
+const DefaultMaxMemorySamples = 5
+
+// evictionPoolSize is the number of the best candidates kept between
+// sampling rounds, as in Redis.
+const evictionPoolSize = 16
+
+// MemoryStats describes the memory used by the keyspace and the limit it
+// is kept under.
+type MemoryStats struct {
+	Used        int64 // bytes over all databases
+	MaxMemory   int64 // 0 = no limit
+	Policy      store.EvictionPolicy
+	Samples     int
+	EvictedKeys int64
+}
+
+// evictionCandidate is a key offered for eviction in one of the databases.
+type evictionCandidate struct {
+	db int
+	store.EvictionCandidate
+}
+
+// memoryUnits are the suffixes ParseMemory accepts, as in redis.conf.
+var memoryUnits = []struct {
+	suffix string
+	scale  int64
+}{
+	{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+	{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+	{"b", 1},
+}
+
+// ParseMemory parses an amount of memory in bytes, with an optional unit:
+// "100mb", "1gb" or "4096". The units are case-insensitive; k, m and g are
+// powers of 1000, kb, mb and gb powers of 1024.
+func ParseMemory(s string) (int64, error) {
+	num, scale := strings.ToLower(s), int64(1)
+	for _, u := range memoryUnits {
+		if strings.HasSuffix(num, u.suffix) {
+			num, scale = strings.TrimSuffix(num, u.suffix), u.scale
+			break
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 || n > (1<<63-1)/scale {
+		return 0, fmt.Errorf("engine: invalid memory amount %q", s)
+	}
+	return n * scale, nil
+}
+
+// initMemory applies the memory settings of cfg.
+func (c *core) initMemory(cfg Config) {
+	c.maxMemory.Store(cfg.MaxMemory)
+	c.evictionPolicy.Store(uint32(cfg.MaxMemoryPolicy))
+	samples := cfg.MaxMemorySamples
+	if samples <= 0 {
+		samples = DefaultMaxMemorySamples
+	}
+	c.maxMemorySamples.Store(int64(samples))
+}
+
+// SetMaxMemory changes the memory limit, in bytes (0 = no limit). Keys are
+// evicted, if need be, by the next FreeMemory.
+func (e *Engine) SetMaxMemory(bytes int64) {
+	e.maxMemory.Store(max(bytes, 0))
+}
+
+// SetEvictionPolicy changes how keys are picked for eviction.
+func (e *Engine) SetEvictionPolicy(p store.EvictionPolicy) {
+	e.evictionPolicy.Store(uint32(p))
+}
+
+// SetMaxMemorySamples changes the number of keys sampled per database to
+// pick one to evict. More samples approximate LRU and LFU better and cost
+// more CPU.
+func (e *Engine) SetMaxMemorySamples(n int) {
+	if n <= 0 {
+		n = DefaultMaxMemorySamples
+	}
+	e.maxMemorySamples.Store(int64(n))
+}
+
+// Memory returns the memory used by the keyspace and the eviction settings.
+func (e *Engine) Memory() MemoryStats {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return MemoryStats{
+		Used:        e.usedMemory(),
+		MaxMemory:   e.maxMemory.Load(),
+		Policy:      store.EvictionPolicy(e.evictionPolicy.Load()),
+		Samples:     int(e.maxMemorySamples.Load()),
+		EvictedKeys: e.evictedKeys.Load(),
+	}
+}
+
+// MemoryUsage returns the bytes used by key and its value, or false if the
+// key does not exist.
+func (e *Engine) MemoryUsage(key string) (int64, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	e.recordRead()
+	return e.store.KeyMemoryUsage(key)
+}
+
+// ObjectAccess returns how long ago key was last accessed and its
+// logarithmic access frequency counter, or false if the key does not
+// exist. It does not count as an access.
+func (e *Engine) ObjectAccess(key string) (time.Duration, int, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	e.recordRead()
+	return e.store.AccessInfo(key)
+}
+
+// FreeMemory evicts keys, as the eviction policy allows, until the memory
+// used is within the limit. It returns ErrOOM if that is not possible;
+// commands that may add data should then be refused. Evicted keys are
+// logged as deletes.
+func (e *Engine) FreeMemory() (err error) {
+	if e.maxMemory.Load() <= 0 {
+		return nil
+	}
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.freeMemory()
+}
+
+// freeMemory evicts keys until the memory used is within the limit
+// (must hold e.mu).
+func (c *core) freeMemory() error {
+	limit := c.maxMemory.Load()
+	if limit <= 0 {
+		return nil
+	}
+	used := c.usedMemory()
+	if used <= limit {
+		return nil
+	}
+	// Keys that expired but were not collected yet still count; collect
+	// them before evicting live keys or refusing writes.
+	used = c.collectExpired(limit)
+	if used <= limit {
+		return nil
+	}
+	policy := store.EvictionPolicy(c.evictionPolicy.Load())
+	if policy == store.NoEviction {
+		return ErrOOM
+	}
+
+	var pool []evictionCandidate
+	for used > limit {
+		pool = c.sampleEviction(pool, policy)
+		if len(pool) == 0 {
+			return ErrOOM
+		}
+		best := pool[len(pool)-1]
+		pool = pool[:len(pool)-1]
+
+		db := c.dbs[best.db]
+		// A key sampled in an earlier round may be gone since.
+		if _, ok := db.store.KeyMemoryUsage(best.Key); !ok {
+			continue
+		}
+		if err := db.evict(best.Key); err != nil {
+			return err
+		}
+		used = c.usedMemory()
+	}
+	return nil
+}
+
+// collectExpired removes expired keys from every database, sampling them
+// as the background expiration cycle does, until the memory used is within
+// limit or the samples show few expired keys left. It returns the memory
+// used (must hold c.mu).
+func (c *core) collectExpired(limit int64) int64 {
+	const sampleSize = 20
+	used := c.usedMemory()
+	for used > limit {
+		sampled, expired := 0, 0
+		for _, db := range c.dbs {
+			n, keys := db.store.ExpireSample(sampleSize)
+			sampled += n
+			expired += len(keys)
+			if len(keys) > 0 {
+				db.logExpired(keys)
+			}
+		}
+		if expired == 0 {
+			break
+		}
+		used = c.usedMemory()
+		// As in the background cycle, stop when under a quarter of the
+		// sample had expired.
+		if expired*4 < sampled {
+			break
+		}
+	}
+	return used
+}
+
+// sampleEviction adds keys sampled from every database to pool and keeps
+// the best evictionPoolSize of them, the best last (must hold c.mu).
+func (c *core) sampleEviction(pool []evictionCandidate, policy store.EvictionPolicy) []evictionCandidate {
+	n := int(c.maxMemorySamples.Load())
+	for _, db := range c.dbs {
+		for _, cand := range db.store.SampleEviction(policy, n) {
+			pool = append(pool, evictionCandidate{db: db.db, EvictionCandidate: cand})
+		}
+	}
+	sort.SliceStable(pool, func(i, j int) bool { return pool[i].Score < pool[j].Score })
+	if len(pool) > evictionPoolSize {
+		pool = pool[len(pool)-evictionPoolSize:]
+	}
+	return pool
+}
+
+// evict deletes key to free memory, logging it as a delete (must hold e.mu).
+func (e *Engine) evict(key string) error {
+	rec := wal.Record{Type: wal.OpDelete, Key: []byte(key)}
+	if err := e.writeWAL(rec); err != nil {
+		return fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+
+	e.store.Delete(key)
+	e.cdc.Record(cdc.OpEvict, key, "", "")
+	e.evictedKeys.Add(1)
+	e.notify(NotifyEvicted, "evicted", key)
+	e.syncIndexes()
+	return nil
+}
+
+// usedMemory returns the bytes used by the keys of all databases (must
+// hold c.mu, for reading or writing).
+func (c *core) usedMemory() int64 {
+	var n int64
+	for _, db := range c.dbs {
+		n += db.store.MemoryUsage()
+	}
+	return n
+}
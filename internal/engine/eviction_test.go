@@ -0,0 +1,177 @@
+package engine
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flashdb/flashdb/internal/cdc"
+	"github.com/flashdb/flashdb/internal/store"
+	"github.com/flashdb/flashdb/internal/wal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngine_MemoryUsage(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+	defer e.Close()
+
+	assert.Equal(t, int64(0), e.Memory().Used)
+	require.NoError(t, e.Set("small", []byte("v")))
+	require.NoError(t, e.Set("large", make([]byte, 4096)))
+	small, ok := e.MemoryUsage("small")
+	require.True(t, ok)
+	large, ok := e.MemoryUsage("large")
+	require.True(t, ok)
+	assert.Greater(t, large, small+4000)
+	_, ok = e.MemoryUsage("missing")
+	assert.False(t, ok)
+
+	require.NoError(t, selectDB(t, e, 3).Set("other", []byte("v")))
+	other, _ := selectDB(t, e, 3).MemoryUsage("other")
+	assert.Equal(t, small+large+other, e.Memory().Used)
+
+	require.NoError(t, e.Clear())
+	assert.Equal(t, int64(0), e.Memory().Used)
+}
+
+func TestEngine_NoEviction(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	cfg := DefaultConfig()
+	cfg.MaxMemory = 4096
+	e, err := NewWithConfig(walPath, cfg)
+	require.NoError(t, err)
+	defer e.Close()
+
+	assert.Equal(t, store.NoEviction, e.Memory().Policy)
+	for i := 0; ; i++ {
+		require.Less(t, i, 1000, "memory limit never reached")
+		if err := e.FreeMemory(); err != nil {
+			assert.ErrorIs(t, err, ErrOOM)
+			break
+		}
+		require.NoError(t, e.Set(fmt.Sprintf("key:%d", i), make([]byte, 100)))
+	}
+	assert.Equal(t, int64(0), e.GetStats().EvictedKeys)
+
+	// Freeing memory by hand, or raising the limit, lets writes in again.
+	require.NoError(t, e.FlushDB())
+	assert.NoError(t, e.FreeMemory())
+}
+
+func TestEngine_OOMCollectsExpired(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	cfg := DefaultConfig()
+	cfg.SyncPolicy = wal.SyncNo
+	e, err := NewWithConfig(walPath, cfg)
+	require.NoError(t, err)
+	defer e.Close()
+
+	// Far more keys than the background cycle collects in a few ticks.
+	for i := 0; i < 2000; i++ {
+		db := selectDB(t, e, i%2)
+		require.NoError(t, db.Set(fmt.Sprintf("key:%d", i), make([]byte, 100)))
+		_, err := db.Expire(fmt.Sprintf("key:%d", i), 300*time.Millisecond)
+		require.NoError(t, err)
+	}
+	e.SetMaxMemory(16 * 1024)
+	assert.ErrorIs(t, e.FreeMemory(), ErrOOM)
+
+	// Once the keys expire, writes are let in again even if the background
+	// cycle has not collected them yet.
+	time.Sleep(320 * time.Millisecond)
+	require.NoError(t, e.FreeMemory())
+	assert.LessOrEqual(t, e.Memory().Used, int64(16*1024))
+	assert.Equal(t, int64(0), e.GetStats().EvictedKeys)
+}
+
+func TestEngine_EvictLRU(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	cfg := DefaultConfig()
+	cfg.MaxMemoryPolicy = store.AllKeysLRU
+	// Sampling every key makes the approximation exact.
+	cfg.MaxMemorySamples = 64
+	e, err := NewWithConfig(walPath, cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, e.Set(fmt.Sprintf("key:%02d", i), make([]byte, 100)))
+	}
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		_, _, err := e.Get(fmt.Sprintf("key:%02d", i))
+		require.NoError(t, err)
+	}
+
+	e.SetMaxMemory(e.Memory().Used / 2)
+	require.NoError(t, e.FreeMemory())
+	mem := e.Memory()
+	assert.LessOrEqual(t, mem.Used, mem.MaxMemory)
+	assert.Equal(t, 10, e.Size())
+	assert.Equal(t, int64(10), mem.EvictedKeys)
+	assert.Equal(t, int64(10), e.GetStats().EvictedKeys)
+	for i := 0; i < 10; i++ {
+		assert.True(t, e.Exists(fmt.Sprintf("key:%02d", i)), "recently used key %d evicted", i)
+	}
+
+	evictions := 0
+	for _, ev := range e.CDCLatest(100) {
+		if ev.Op == cdc.OpEvict {
+			evictions++
+		}
+	}
+	assert.Equal(t, 10, evictions)
+	require.NoError(t, e.Close())
+
+	// Evictions are replayed from the WAL.
+	e, err = New(walPath)
+	require.NoError(t, err)
+	defer e.Close()
+	assert.Equal(t, 10, e.Size())
+}
+
+func TestEngine_EvictVolatile(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	cfg := DefaultConfig()
+	cfg.MaxMemoryPolicy = store.VolatileTTL
+	e, err := NewWithConfig(walPath, cfg)
+	require.NoError(t, err)
+	defer e.Close()
+
+	db1 := selectDB(t, e, 1)
+	require.NoError(t, e.Set("persistent", make([]byte, 100)))
+	require.NoError(t, db1.SetWithTTL("soon", make([]byte, 100), time.Minute))
+	require.NoError(t, e.SetWithTTL("later", make([]byte, 100), time.Hour))
+
+	e.SetMaxMemory(e.Memory().Used - 1)
+	require.NoError(t, e.FreeMemory())
+	assert.False(t, db1.Exists("soon"))
+	assert.True(t, e.Exists("later"))
+
+	// Only keys with a TTL may go.
+	e.SetMaxMemory(1)
+	assert.ErrorIs(t, e.FreeMemory(), ErrOOM)
+	assert.True(t, e.Exists("persistent"))
+	assert.False(t, e.Exists("later"))
+
+	e.SetMaxMemory(0)
+	assert.NoError(t, e.FreeMemory())
+}
+
+func TestParseMemory(t *testing.T) {
+	for s, want := range map[string]int64{
+		"0": 0, "4096": 4096, "10b": 10, "1k": 1000, "1kb": 1024,
+		"100MB": 100 << 20, "2m": 2000000, "1gb": 1 << 30, "3G": 3000000000,
+	} {
+		n, err := ParseMemory(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, want, n, s)
+	}
+	for _, s := range []string{"", "mb", "-1", "1tb", "1.5gb", "99999999999gb"} {
+		_, err := ParseMemory(s)
+		assert.Error(t, err, s)
+	}
+}
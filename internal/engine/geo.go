@@ -0,0 +1,225 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/flashdb/flashdb/internal/store"
+	"github.com/flashdb/flashdb/internal/wal"
+)
+
+// GeoSort orders the results of a geo search by distance.
+type GeoSort uint8
+
+// Geo search orders.
+const (
+	GeoUnsorted GeoSort = iota
+	GeoAsc
+	GeoDesc
+)
+
+// GeoQuery describes a geo search. With FromMember the search is centered
+// on Member's position instead of Shape.Center. Count > 0 returns only the
+// Count nearest matches, or with Any the first Count found.
+type GeoQuery struct {
+	Shape      store.GeoShape
+	FromMember bool
+	Member     string
+	Sort       GeoSort
+	Count      int
+	Any        bool
+}
+
+// GeoAdd adds members, whose scores must be geohashes from store.GeoEncode,
+// to the sorted set at key. With nx only new members are added, with xx only
+// existing ones are updated. It returns the number of members added, or with
+// ch the number added or moved.
+func (e *Engine) GeoAdd(key string, nx, xx, ch bool, members ...store.ScoredMember) (_ int, err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.checkType(key, store.TypeZSet); err != nil {
+		e.recordCommand()
+		return 0, err
+	}
+
+	// Resolve duplicates and NX/XX up front so only effective updates are
+	// logged; the last position given for a member wins.
+	last := make(map[string]int, len(members))
+	for i, m := range members {
+		last[m.Member] = i
+	}
+	var records []wal.Record
+	var updates []store.ScoredMember
+	added, moved := 0, 0
+	for i, m := range members {
+		if last[m.Member] != i {
+			continue
+		}
+		score, exists, _ := e.store.ZScore(key, m.Member)
+		if (nx && exists) || (xx && !exists) || (exists && score == m.Score) {
+			continue
+		}
+		if exists {
+			moved++
+		} else {
+			added++
+		}
+		records = append(records, wal.Record{
+			Type:  wal.OpZAdd,
+			Key:   []byte(key),
+			Value: encodeZMember(m.Member, m.Score),
+		})
+		updates = append(updates, m)
+	}
+	result := added
+	if ch {
+		result += moved
+	}
+	if len(records) == 0 {
+		e.recordCommand()
+		return result, nil
+	}
+
+	if err := e.writeWAL(records...); err != nil {
+		return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+	e.store.ZAdd(key, updates...)
+	e.notify(NotifyZSet, "zadd", key)
+	e.recordWrite()
+	return result, nil
+}
+
+// GeoPos returns the positions of members in the sorted set at key, with
+// nil for missing members.
+func (e *Engine) GeoPos(key string, members ...string) ([]*store.GeoPoint, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	e.recordRead()
+
+	points := make([]*store.GeoPoint, len(members))
+	for i, member := range members {
+		score, ok, err := e.store.ZScore(key, member)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			p := store.GeoDecode(score)
+			points[i] = &p
+		}
+	}
+	return points, nil
+}
+
+// GeoDist returns the distance in meters between two members of the sorted
+// set at key, and false if either is missing.
+func (e *Engine) GeoDist(key, member1, member2 string) (float64, bool, error) {
+	points, err := e.GeoPos(key, member1, member2)
+	if err != nil || points[0] == nil || points[1] == nil {
+		return 0, false, err
+	}
+	return store.GeoDistance(*points[0], *points[1]), true, nil
+}
+
+// GeoSearch returns the members of the sorted set at key that match q. A
+// missing key has no matches.
+func (e *Engine) GeoSearch(key string, q GeoQuery) ([]store.GeoMatch, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	e.recordRead()
+
+	return e.geoSearch(key, q)
+}
+
+// geoSearch runs q against the sorted set at key (must hold e.mu). COUNT
+// without ANY implies ascending order, so that it keeps the nearest matches.
+func (e *Engine) geoSearch(key string, q GeoQuery) ([]store.GeoMatch, error) {
+	n, err := e.store.ZCard(key)
+	if n == 0 {
+		return nil, err
+	}
+	if q.FromMember {
+		score, ok, _ := e.store.ZScore(key, q.Member)
+		if !ok {
+			return nil, store.ErrGeoNoMember
+		}
+		q.Shape.Center = store.GeoDecode(score)
+	}
+	limit := 0
+	if q.Any {
+		limit = q.Count
+	}
+	matches, err := e.store.GeoSearch(key, q.Shape, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	order := q.Sort
+	if order == GeoUnsorted && q.Count > 0 && !q.Any {
+		order = GeoAsc
+	}
+	switch order {
+	case GeoAsc:
+		sort.SliceStable(matches, func(i, j int) bool { return matches[i].Dist < matches[j].Dist })
+	case GeoDesc:
+		sort.SliceStable(matches, func(i, j int) bool { return matches[i].Dist > matches[j].Dist })
+	}
+	if q.Count > 0 && len(matches) > q.Count {
+		matches = matches[:q.Count]
+	}
+	return matches, nil
+}
+
+// GeoSearchStore replaces dest with a sorted set of the members of src that
+// match q and returns its size. The members keep their positions, or with
+// distUnit > 0 are scored by their distance in units of distUnit meters.
+// dest is deleted when nothing matches.
+func (e *Engine) GeoSearchStore(dest, src string, q GeoQuery, distUnit float64) (_ int, err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.expireIfNeeded(src)
+	matches, err := e.geoSearch(src, q)
+	if err != nil {
+		e.recordCommand()
+		return 0, err
+	}
+
+	e.expireIfNeeded(dest)
+	exists := e.store.Exists(dest)
+	if len(matches) == 0 && !exists {
+		e.recordCommand()
+		return 0, nil
+	}
+	var records []wal.Record
+	if exists {
+		records = append(records, wal.Record{Type: wal.OpDelete, Key: []byte(dest)})
+	}
+	for _, m := range matches {
+		score := m.Score
+		if distUnit > 0 {
+			score = m.Dist / distUnit
+		}
+		records = append(records, wal.Record{
+			Type:  wal.OpZAdd,
+			Key:   []byte(dest),
+			Value: encodeZMember(m.Member, score),
+		})
+	}
+	if err := e.writeWAL(records...); err != nil {
+		return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+
+	for _, rec := range records {
+		e.apply(rec)
+	}
+	if len(matches) > 0 {
+		e.notify(NotifyZSet, "geosearchstore", dest)
+	} else {
+		e.notify(NotifyGeneric, "del", dest)
+	}
+	e.recordWrite()
+	return len(matches), nil
+}
@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/flashdb/flashdb/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func geoMember(name string, lon, lat float64) store.ScoredMember {
+	return store.ScoredMember{Member: name, Score: store.GeoEncode(store.GeoPoint{Lon: lon, Lat: lat})}
+}
+
+func matchNames(matches []store.GeoMatch) []string {
+	names := make([]string, len(matches))
+	for i, m := range matches {
+		names[i] = m.Member
+	}
+	return names
+}
+
+func TestEngine_GeoAdd(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+	defer e.Close()
+
+	n, err := e.GeoAdd("sicily", false, false, false,
+		geoMember("Palermo", 13.361389, 38.115556), geoMember("Catania", 15.087269, 37.502669))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	// Re-adding an unchanged member is not logged.
+	size := e.wal.Size()
+	n, err = e.GeoAdd("sicily", false, false, true, geoMember("Palermo", 13.361389, 38.115556))
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, size, e.wal.Size())
+
+	n, err = e.GeoAdd("sicily", true, false, true, geoMember("Palermo", 0, 0), geoMember("Ragusa", 14.7306, 36.9253))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n, "NX leaves Palermo alone")
+	n, err = e.GeoAdd("sicily", false, true, true, geoMember("Palermo", 13.4, 38.1), geoMember("Enna", 14.27, 37.56))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n, "XX only moves Palermo")
+	card, _ := e.ZCard("sicily")
+	assert.Equal(t, 3, card)
+
+	points, err := e.GeoPos("sicily", "Palermo", "missing")
+	require.NoError(t, err)
+	require.NotNil(t, points[0])
+	assert.InDelta(t, 13.4, points[0].Lon, 1e-5)
+	assert.Nil(t, points[1])
+
+	dist, ok, err := e.GeoDist("sicily", "Ragusa", "Catania")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.InDelta(t, 71569.1423, dist, 0.0001)
+	_, ok, err = e.GeoDist("sicily", "Ragusa", "missing")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, e.Set("str", []byte("x")))
+	_, err = e.GeoAdd("str", false, false, false, geoMember("a", 0, 0))
+	assert.ErrorIs(t, err, store.ErrWrongType)
+}
+
+func TestEngine_GeoSearch(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+
+	_, err = e.GeoAdd("sicily", false, false, false,
+		geoMember("Palermo", 13.361389, 38.115556), geoMember("Catania", 15.087269, 37.502669),
+		geoMember("edge1", 12.758489, 38.788135), geoMember("edge2", 17.241510, 38.788135))
+	require.NoError(t, err)
+	box := store.GeoShape{Center: store.GeoPoint{Lon: 15, Lat: 37}, Box: true, Width: 400000, Height: 400000}
+
+	matches, err := e.GeoSearch("sicily", GeoQuery{Shape: box, Sort: GeoAsc})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Catania", "Palermo", "edge2", "edge1"}, matchNames(matches))
+	matches, err = e.GeoSearch("sicily", GeoQuery{Shape: box, Sort: GeoDesc, Count: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"edge1", "edge2"}, matchNames(matches))
+
+	// COUNT on its own keeps the nearest matches.
+	matches, err = e.GeoSearch("sicily", GeoQuery{Shape: box, Count: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Catania"}, matchNames(matches))
+	matches, err = e.GeoSearch("sicily", GeoQuery{Shape: box, Count: 3, Any: true})
+	require.NoError(t, err)
+	assert.Len(t, matches, 3)
+
+	radius := store.GeoShape{Radius: 100000}
+	matches, err = e.GeoSearch("sicily", GeoQuery{Shape: radius, FromMember: true, Member: "Catania", Sort: GeoAsc})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Catania"}, matchNames(matches))
+	assert.Zero(t, matches[0].Dist)
+	_, err = e.GeoSearch("sicily", GeoQuery{Shape: radius, FromMember: true, Member: "missing"})
+	assert.ErrorIs(t, err, store.ErrGeoNoMember)
+	matches, err = e.GeoSearch("missing", GeoQuery{Shape: radius, FromMember: true, Member: "missing"})
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+
+	// GeoSearchStore keeps positions, or stores distances.
+	n, err := e.GeoSearchStore("near", "sicily", GeoQuery{Shape: box, Count: 2}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	score, _, _ := e.ZScore("near", "Palermo")
+	assert.Equal(t, store.GeoEncode(store.GeoPoint{Lon: 13.361389, Lat: 38.115556}), score)
+	n, err = e.GeoSearchStore("dists", "sicily", GeoQuery{Shape: box}, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+	score, _, _ = e.ZScore("dists", "Catania")
+	assert.InDelta(t, 56.4413, score, 0.0001)
+
+	// A search without matches deletes the destination.
+	n, err = e.GeoSearchStore("dists", "sicily", GeoQuery{Shape: store.GeoShape{Radius: 1}}, 0)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	assert.False(t, e.Exists("dists"))
+	require.NoError(t, e.Close())
+
+	e2, err := New(walPath)
+	require.NoError(t, err)
+	defer e2.Close()
+	members, err := e2.ZRange("near", 0, -1, false)
+	require.NoError(t, err)
+	assert.Len(t, members, 2)
+	assert.False(t, e2.Exists("dists"))
+}
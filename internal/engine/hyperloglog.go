@@ -0,0 +1,81 @@
+package engine
+
+import "github.com/flashdb/flashdb/internal/store"
+
+// PFAdd adds elements to the HyperLogLog at key, creating it if needed, and
+// reports whether its estimate may have changed.
+func (e *Engine) PFAdd(key string, elements ...[]byte) (_ bool, err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.checkType(key, store.TypeString); err != nil {
+		e.recordCommand()
+		return false, err
+	}
+	before, _, _ := e.store.Get(key)
+	var after []byte
+	if before != nil {
+		after = append([]byte(nil), before...)
+	}
+	after, changed, err := store.PFAdd(after, elements)
+	if err != nil || !changed {
+		e.recordCommand()
+		return false, err
+	}
+
+	if err := e.writeString(key, before, after); err != nil {
+		return false, err
+	}
+	e.notify(NotifyString, "pfadd", key)
+	e.recordWrite()
+	return true, nil
+}
+
+// PFCount estimates the number of distinct elements added to the union of
+// the HyperLogLogs at keys. Missing keys count as empty.
+func (e *Engine) PFCount(keys ...string) (uint64, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	e.recordRead()
+
+	hlls := make([][]byte, len(keys))
+	for i, key := range keys {
+		value, _, err := e.store.Get(key)
+		if err != nil {
+			return 0, err
+		}
+		hlls[i] = value
+	}
+	return store.PFCount(hlls...)
+}
+
+// PFMerge stores the union of the HyperLogLogs at dest and keys in dest.
+// Missing keys count as empty, and dest keeps its TTL.
+func (e *Engine) PFMerge(dest string, keys ...string) (err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	hlls := make([][]byte, 0, len(keys)+1)
+	for _, key := range append([]string{dest}, keys...) {
+		if err := e.checkType(key, store.TypeString); err != nil {
+			e.recordCommand()
+			return err
+		}
+		value, _, _ := e.store.Get(key)
+		hlls = append(hlls, value)
+	}
+	merged, err := store.PFMerge(hlls...)
+	if err != nil {
+		e.recordCommand()
+		return err
+	}
+
+	if err := e.writeString(dest, hlls[0], merged); err != nil {
+		return err
+	}
+	e.notify(NotifyString, "pfadd", dest)
+	e.recordWrite()
+	return nil
+}
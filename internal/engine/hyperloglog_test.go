@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flashdb/flashdb/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hllElements(prefix string, n int) [][]byte {
+	elements := make([][]byte, n)
+	for i := range elements {
+		elements[i] = []byte(fmt.Sprintf("%s:%d", prefix, i))
+	}
+	return elements
+}
+
+func TestEngine_HyperLogLog(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+
+	changed, err := e.PFAdd("visitors", hllElements("v", 20000)...)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	changed, err = e.PFAdd("visitors", []byte("v:1"))
+	require.NoError(t, err)
+	assert.False(t, changed)
+	_, err = e.Expire("visitors", time.Hour)
+	require.NoError(t, err)
+
+	// Adding to a dense HyperLogLog logs only the changed registers.
+	size := e.wal.Size()
+	changed, err = e.PFAdd("visitors", []byte("new visitor"), []byte("another one"))
+	require.NoError(t, err)
+	require.True(t, changed)
+	assert.Less(t, e.wal.Size()-size, int64(256))
+	assert.Greater(t, e.TTL("visitors"), int64(3500))
+
+	_, err = e.PFAdd("small", hllElements("v", 10)...)
+	require.NoError(t, err)
+	n, err := e.PFCount("small")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), n)
+	n, err = e.PFCount("small", "missing")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), n)
+
+	require.NoError(t, e.PFMerge("all", "visitors", "small", "missing"))
+	all, err := e.PFCount("all")
+	require.NoError(t, err)
+	union, err := e.PFCount("visitors", "small")
+	require.NoError(t, err)
+	assert.Equal(t, union, all)
+	assert.InDelta(t, 20002, all, 20002*0.0405)
+
+	require.NoError(t, e.Set("str", []byte("not an hll")))
+	_, err = e.PFAdd("str", []byte("x"))
+	assert.ErrorIs(t, err, store.ErrNotHLL)
+	_, err = e.PFCount("small", "str")
+	assert.ErrorIs(t, err, store.ErrNotHLL)
+	_, err = e.LPush("list", []byte("x"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, e.PFMerge("list", "small"), store.ErrWrongType)
+
+	_, err = e.SnapshotCreate("hll")
+	require.NoError(t, err)
+	_, err = e.PFAdd("small", hllElements("w", 10)...)
+	require.NoError(t, err)
+	require.NoError(t, e.SnapshotRestore("hll"))
+	n, _ = e.PFCount("small")
+	assert.Equal(t, uint64(10), n)
+
+	want := map[string]uint64{}
+	for _, key := range []string{"visitors", "small", "all"} {
+		want[key], err = e.PFCount(key)
+		require.NoError(t, err)
+	}
+	require.NoError(t, e.Close())
+
+	e2, err := New(walPath)
+	require.NoError(t, err)
+	defer e2.Close()
+	for key, count := range want {
+		got, err := e2.PFCount(key)
+		require.NoError(t, err)
+		assert.Equal(t, count, got, key)
+	}
+	assert.Greater(t, e2.TTL("visitors"), int64(3500))
+}
@@ -0,0 +1,280 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/flashdb/flashdb/internal/store"
+	"github.com/flashdb/flashdb/internal/wal"
+)
+
+// ========================
+// JSON Encoding Helpers
+// ========================
+
+// JSON records log the edits a command planned rather than the documents
+// it produced, so a small change to a large document stays small in the
+// log. They reuse the stream record encoding.
+
+// jsonRecord returns the WAL record of an edit of the document at key.
+func jsonRecord(key string, edit store.JSONEdit) wal.Record {
+	rec := wal.Record{Key: []byte(key), Value: appendRecordString(nil, edit.Path)}
+	switch edit.Kind {
+	case store.JSONEditSet:
+		rec.Type = wal.OpJSONSet
+		rec.Value = appendRecordString(rec.Value, string(edit.Values[0]))
+	case store.JSONEditDelete:
+		rec.Type = wal.OpJSONDel
+	case store.JSONEditInsert:
+		rec.Type = wal.OpJSONArrInsert
+		rec.Value = appendRecordInt(rec.Value, int64(edit.Index))
+		for _, v := range edit.Values {
+			rec.Value = appendRecordString(rec.Value, string(v))
+		}
+	case store.JSONEditTrim:
+		rec.Type = wal.OpJSONArrTrim
+		rec.Value = appendRecordInt(appendRecordInt(rec.Value, int64(edit.Index)), int64(edit.Stop))
+	}
+	return rec
+}
+
+// decodeJSONEdit decodes the edit a JSON record logs.
+func decodeJSONEdit(rec wal.Record) store.JSONEdit {
+	r := &recordReader{buf: rec.Value}
+	edit := store.JSONEdit{Path: r.string()}
+	switch rec.Type {
+	case wal.OpJSONSet:
+		edit.Kind = store.JSONEditSet
+		edit.Values = [][]byte{r.bytes()}
+	case wal.OpJSONDel:
+		edit.Kind = store.JSONEditDelete
+	case wal.OpJSONArrInsert:
+		edit.Kind = store.JSONEditInsert
+		edit.Index = int(r.int64())
+		for len(r.buf) > 0 {
+			edit.Values = append(edit.Values, r.bytes())
+		}
+	case wal.OpJSONArrTrim:
+		edit.Kind = store.JSONEditTrim
+		edit.Index = int(r.int64())
+		edit.Stop = int(r.int64())
+	}
+	return edit
+}
+
+// applyJSON replays a JSON WAL record.
+func (e *Engine) applyJSON(rec wal.Record) {
+	e.store.JSONApply(string(rec.Key), decodeJSONEdit(rec))
+}
+
+// ========================
+// JSON Operations
+// ========================
+
+// jsonWrite plans a write to the document at key with plan, which gets nil
+// for a missing key, and then logs and applies the planned edits, announced
+// as the keyspace event event.
+func (e *Engine) jsonWrite(key, event string, plan func(doc *store.JSON) ([]store.JSONEdit, error)) (err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.checkType(key, store.TypeJSON); err != nil {
+		e.recordCommand()
+		return err
+	}
+	var edits []store.JSONEdit
+	err = e.store.JSONRead(key, func(doc *store.JSON) error {
+		var err error
+		edits, err = plan(doc)
+		return err
+	})
+	if err != nil || len(edits) == 0 {
+		e.recordCommand()
+		return err
+	}
+
+	records := make([]wal.Record, len(edits))
+	for i, edit := range edits {
+		records[i] = jsonRecord(key, edit)
+	}
+	if err := e.writeWAL(records...); err != nil {
+		return fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+	for _, rec := range records {
+		e.apply(rec)
+	}
+	e.notifyRemoved(NotifyModule, event, key)
+	e.recordWrite()
+	return nil
+}
+
+// jsonRead calls fn with the document at key, or nil for a missing key.
+func (e *Engine) jsonRead(key string, fn func(doc *store.JSON) error) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	e.recordRead()
+	return e.store.JSONRead(key, fn)
+}
+
+// JSONSet stores value at path in the document at key, which for a missing
+// key must be the root; see store.JSON.PlanSet. It returns false if nothing
+// was set because of nx or xx.
+func (e *Engine) JSONSet(key string, path *store.JSONPath, value []byte, nx, xx bool) (bool, error) {
+	set := false
+	err := e.jsonWrite(key, "json.set", func(doc *store.JSON) ([]store.JSONEdit, error) {
+		edits, err := doc.PlanSet(path, value, nx, xx)
+		set = len(edits) > 0
+		return edits, err
+	})
+	return set && err == nil, err
+}
+
+// JSONGet serializes what paths select in the document at key; see
+// store.JSON.Get. It returns false if the key does not exist.
+func (e *Engine) JSONGet(key string, f store.JSONFormat, paths ...*store.JSONPath) ([]byte, bool, error) {
+	var out []byte
+	err := e.jsonRead(key, func(doc *store.JSON) error {
+		if doc == nil {
+			return nil
+		}
+		var err error
+		out, err = doc.Get(f, paths...)
+		return err
+	})
+	return out, out != nil, err
+}
+
+// JSONMGet returns what path selects in the document at each key, as
+// JSONGet does, with nil for keys that are missing, are not documents or
+// where a legacy path matches nothing.
+func (e *Engine) JSONMGet(keys []string, path *store.JSONPath) [][]byte {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	e.recordRead()
+
+	results := make([][]byte, len(keys))
+	for i, key := range keys {
+		e.store.JSONRead(key, func(doc *store.JSON) error {
+			if doc != nil {
+				results[i], _ = doc.Get(store.JSONFormat{}, path)
+			}
+			return nil
+		})
+	}
+	return results
+}
+
+// JSONDel removes the values path selects from the document at key and
+// returns how many were removed. Removing the root deletes the key.
+func (e *Engine) JSONDel(key string, path *store.JSONPath) (int, error) {
+	n := 0
+	err := e.jsonWrite(key, "json.del", func(doc *store.JSON) ([]store.JSONEdit, error) {
+		if doc == nil {
+			return nil, nil
+		}
+		edits := doc.PlanDel(path)
+		n = len(edits)
+		return edits, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// JSONType returns the types of the values path selects in the document at
+// key, and false if the key does not exist.
+func (e *Engine) JSONType(key string, path *store.JSONPath) ([]string, bool, error) {
+	var types []string
+	exists := false
+	err := e.jsonRead(key, func(doc *store.JSON) error {
+		if doc != nil {
+			types, exists = doc.Types(path), true
+		}
+		return nil
+	})
+	return types, exists, err
+}
+
+// jsonResults runs a planner that needs an existing document and returns
+// its results.
+func (e *Engine) jsonResults(key, event string, plan func(doc *store.JSON) ([]store.JSONEdit, []store.JSONResult, error)) ([]store.JSONResult, error) {
+	var results []store.JSONResult
+	err := e.jsonWrite(key, event, func(doc *store.JSON) ([]store.JSONEdit, error) {
+		if doc == nil {
+			return nil, store.ErrJSONNoKey
+		}
+		edits, res, err := plan(doc)
+		results = res
+		return edits, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// JSONArrAppend appends values to the arrays path selects in the document
+// at key and returns their new lengths.
+func (e *Engine) JSONArrAppend(key string, path *store.JSONPath, values ...[]byte) ([]store.JSONResult, error) {
+	return e.jsonResults(key, "json.arrappend", func(doc *store.JSON) ([]store.JSONEdit, []store.JSONResult, error) {
+		return doc.PlanArrInsert(path, 0, true, values)
+	})
+}
+
+// JSONArrInsert inserts values before index in the arrays path selects in
+// the document at key and returns their new lengths.
+func (e *Engine) JSONArrInsert(key string, path *store.JSONPath, index int, values ...[]byte) ([]store.JSONResult, error) {
+	return e.jsonResults(key, "json.arrinsert", func(doc *store.JSON) ([]store.JSONEdit, []store.JSONResult, error) {
+		return doc.PlanArrInsert(path, index, false, values)
+	})
+}
+
+// JSONArrLen returns the lengths of the arrays path selects in the document
+// at key, and false if the key does not exist.
+func (e *Engine) JSONArrLen(key string, path *store.JSONPath) ([]store.JSONResult, bool, error) {
+	var results []store.JSONResult
+	exists := false
+	err := e.jsonRead(key, func(doc *store.JSON) error {
+		if doc == nil {
+			return nil
+		}
+		var err error
+		results, err = doc.ArrLen(path)
+		exists = true
+		return err
+	})
+	return results, exists, err
+}
+
+// JSONArrPop removes and returns the element at index of the arrays path
+// selects in the document at key.
+func (e *Engine) JSONArrPop(key string, path *store.JSONPath, index int) ([]store.JSONResult, error) {
+	return e.jsonResults(key, "json.arrpop", func(doc *store.JSON) ([]store.JSONEdit, []store.JSONResult, error) {
+		return doc.PlanArrPop(path, index)
+	})
+}
+
+// JSONArrTrim trims the arrays path selects in the document at key to the
+// elements from start to stop and returns their new lengths.
+func (e *Engine) JSONArrTrim(key string, path *store.JSONPath, start, stop int) ([]store.JSONResult, error) {
+	return e.jsonResults(key, "json.arrtrim", func(doc *store.JSON) ([]store.JSONEdit, []store.JSONResult, error) {
+		return doc.PlanArrTrim(path, start, stop)
+	})
+}
+
+// JSONNumIncrBy adds by to the numbers path selects in the document at key
+// and returns the new numbers.
+func (e *Engine) JSONNumIncrBy(key string, path *store.JSONPath, by []byte) ([]store.JSONResult, error) {
+	return e.jsonResults(key, "json.numincrby", func(doc *store.JSON) ([]store.JSONEdit, []store.JSONResult, error) {
+		return doc.PlanNumOp(path, by, false)
+	})
+}
+
+// JSONNumMultBy multiplies the numbers path selects in the document at key
+// by by and returns the new numbers.
+func (e *Engine) JSONNumMultBy(key string, path *store.JSONPath, by []byte) ([]store.JSONResult, error) {
+	return e.jsonResults(key, "json.nummultby", func(doc *store.JSON) ([]store.JSONEdit, []store.JSONResult, error) {
+		return doc.PlanNumOp(path, by, true)
+	})
+}
@@ -0,0 +1,146 @@
+package engine
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flashdb/flashdb/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func jsonPath(t *testing.T, path string) *store.JSONPath {
+	t.Helper()
+	p, err := store.ParseJSONPath(path)
+	require.NoError(t, err)
+	return p
+}
+
+// jsonDocText returns the document at key as compact JSON.
+func jsonDocText(t *testing.T, e *Engine, key string) string {
+	t.Helper()
+	out, ok, err := e.JSONGet(key, store.JSONFormat{})
+	require.NoError(t, err)
+	require.True(t, ok)
+	return string(out)
+}
+
+func TestEngine_JSONCommands(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+	defer e.Close()
+
+	_, err = e.JSONSet("doc", jsonPath(t, "$.a"), []byte(`1`), false, false)
+	assert.ErrorIs(t, err, store.ErrJSONNewAtRoot)
+	ok, err := e.JSONSet("doc", jsonPath(t, "$"), []byte(`{"a":[1,2],"n":{"a":[]},"x":1}`), false, false)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "ReJSON-RL", e.KeyType("doc"))
+
+	ok, err = e.JSONSet("doc", jsonPath(t, "$.x"), []byte(`2`), true, false)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	results, err := e.JSONArrAppend("doc", jsonPath(t, "$..a"), []byte(`3`))
+	require.NoError(t, err)
+	assert.Equal(t, []store.JSONResult{{N: 3, OK: true}, {N: 1, OK: true}}, results)
+	results, err = e.JSONArrInsert("doc", jsonPath(t, ".a"), 0, []byte(`0`))
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), results[0].N)
+	results, err = e.JSONNumIncrBy("doc", jsonPath(t, "$.x"), []byte(`2.5`))
+	require.NoError(t, err)
+	assert.Equal(t, "3.5", string(results[0].JSON))
+	assert.Equal(t, `{"a":[0,1,2,3],"n":{"a":[3]},"x":3.5}`, jsonDocText(t, e, "doc"))
+
+	types, ok, err := e.JSONType("doc", jsonPath(t, "$.*"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"array", "object", "number"}, types)
+
+	n, err := e.JSONDel("doc", jsonPath(t, "$..a"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, [][]byte{[]byte(`[{}]`), nil, nil}, e.JSONMGet([]string{"doc", "missing", "str"}, jsonPath(t, "$.n")))
+
+	_, err = e.JSONArrPop("missing", jsonPath(t, "."), -1)
+	assert.ErrorIs(t, err, store.ErrJSONNoKey)
+	require.NoError(t, e.Set("str", []byte("v")))
+	_, err = e.JSONSet("str", jsonPath(t, "$"), []byte(`1`), false, false)
+	assert.ErrorIs(t, err, store.ErrWrongType)
+
+	n, err = e.JSONDel("doc", jsonPath(t, "$"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.False(t, e.Exists("doc"))
+}
+
+func TestEngine_JSONLogsPathEdits(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+
+	big := `{"items":[` + strings.Repeat(`"xxxxxxxxxxxxxxxx",`, 1000) + `0],"count":0}`
+	_, err = e.JSONSet("doc", jsonPath(t, "$"), []byte(big), false, false)
+	require.NoError(t, err)
+
+	// Changing one member logs that member, not the document.
+	size := e.wal.Size()
+	_, err = e.JSONNumIncrBy("doc", jsonPath(t, "$.count"), []byte(`1`))
+	require.NoError(t, err)
+	assert.Less(t, e.wal.Size()-size, int64(100))
+
+	_, err = e.JSONArrPop("doc", jsonPath(t, "$.items"), 0)
+	require.NoError(t, err)
+	_, err = e.JSONArrTrim("doc", jsonPath(t, "$.items"), 0, 1)
+	require.NoError(t, err)
+	_, err = e.JSONSet("doc", jsonPath(t, `$["new key"]`), []byte(`{"a":[true]}`), false, false)
+	require.NoError(t, err)
+	_, err = e.JSONArrInsert("doc", jsonPath(t, `$..a`), -1, []byte(`null`), []byte(`"s"`))
+	require.NoError(t, err)
+	_, err = e.Expire("doc", time.Hour)
+	require.NoError(t, err)
+	want := jsonDocText(t, e, "doc")
+	assert.Equal(t, `{"items":["xxxxxxxxxxxxxxxx","xxxxxxxxxxxxxxxx"],"count":1,"new key":{"a":[null,"s",true]}}`, want)
+	require.NoError(t, e.Close())
+
+	e2, err := New(walPath)
+	require.NoError(t, err)
+	defer e2.Close()
+	assert.Equal(t, want, jsonDocText(t, e2, "doc"))
+	assert.Greater(t, e2.TTL("doc"), int64(3500))
+}
+
+func TestEngine_JSONPersistence(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+
+	_, err = e.JSONSet("doc", jsonPath(t, "$"), []byte(`{"a":[1,{"b":"ü"}],"n":1.5}`), false, false)
+	require.NoError(t, err)
+	want := jsonDocText(t, e, "doc")
+
+	_, err = e.SnapshotCreate("json")
+	require.NoError(t, err)
+	_, err = e.JSONDel("doc", jsonPath(t, "$.a"))
+	require.NoError(t, err)
+	require.NoError(t, e.SnapshotRestore("json"))
+	assert.Equal(t, want, jsonDocText(t, e, "doc"))
+
+	payload, ok, err := e.Dump("doc")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NoError(t, e.Restore("copy", payload, 0, false))
+	assert.Equal(t, want, jsonDocText(t, e, "copy"))
+
+	require.NoError(t, e.Rewrite())
+	require.NoError(t, e.Close())
+
+	e2, err := New(walPath)
+	require.NoError(t, err)
+	defer e2.Close()
+	assert.Equal(t, want, jsonDocText(t, e2, "doc"))
+	assert.Equal(t, want, jsonDocText(t, e2, "copy"))
+}
@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// KeyspaceEvents selects the keyspace notifications published, as Redis'
+// notify-keyspace-events does: K and E pick the channels, the other flags
+// the classes of events.
+type KeyspaceEvents uint32
+
+// Keyspace event flags.
+const (
+	NotifyKeyspace KeyspaceEvents = 1 << iota // K: __keyspace@<db>__:<key> channels
+	NotifyKeyevent                            // E: __keyevent@<db>__:<event> channels
+	NotifyGeneric                             // g: DEL, EXPIRE, RENAME, ...
+	NotifyString                              // $
+	NotifyList                                // l
+	NotifySet                                 // s
+	NotifyHash                                // h
+	NotifyZSet                                // z
+	NotifyExpired                             // x: keys removed when their TTL passed
+	NotifyEvicted                             // e: keys evicted for maxmemory
+	NotifyStream                              // t
+	NotifyModule                              // d: JSON and time series
+
+	// NotifyAll is the A alias for every class of events.
+	NotifyAll = NotifyGeneric | NotifyString | NotifyList | NotifySet | NotifyHash |
+		NotifyZSet | NotifyExpired | NotifyEvicted | NotifyStream | NotifyModule
+)
+
+// keyspaceEventFlags are the flag characters, in the order String writes
+// them.
+var keyspaceEventFlags = []struct {
+	flag  byte
+	event KeyspaceEvents
+}{
+	{'g', NotifyGeneric}, {'$', NotifyString}, {'l', NotifyList},
+	{'s', NotifySet}, {'h', NotifyHash}, {'z', NotifyZSet},
+	{'x', NotifyExpired}, {'e', NotifyEvicted}, {'t', NotifyStream},
+	{'d', NotifyModule}, {'K', NotifyKeyspace}, {'E', NotifyKeyevent},
+}
+
+// ParseKeyspaceEvents parses a notify-keyspace-events string such as "KEA"
+// or "Elg". The empty string disables notifications.
+func ParseKeyspaceEvents(s string) (KeyspaceEvents, error) {
+	var ev KeyspaceEvents
+next:
+	for i := 0; i < len(s); i++ {
+		if s[i] == 'A' {
+			ev |= NotifyAll
+			continue
+		}
+		for _, f := range keyspaceEventFlags {
+			if s[i] == f.flag {
+				ev |= f.event
+				continue next
+			}
+		}
+		return 0, fmt.Errorf("engine: invalid keyspace events flag %q in %q", s[i], s)
+	}
+	return ev, nil
+}
+
+// String returns the flags of ev, with A standing for all the classes.
+func (ev KeyspaceEvents) String() string {
+	var b strings.Builder
+	if ev&NotifyAll == NotifyAll {
+		b.WriteByte('A')
+	}
+	for _, f := range keyspaceEventFlags {
+		if ev&f.event != 0 && (ev&NotifyAll != NotifyAll || f.event&NotifyAll == 0) {
+			b.WriteByte(f.flag)
+		}
+	}
+	return b.String()
+}
+
+// OnKeyspaceEvent registers fn to be called for every keyspace event
+// enabled by SetKeyspaceEvents, in any database, with the flags in effect.
+// fn runs with the engine lock held, in the order the changes were made,
+// and must not call back into the engine.
+func (e *Engine) OnKeyspaceEvent(fn func(db int, flags KeyspaceEvents, event, key string)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.keyspaceHook = fn
+}
+
+// SetKeyspaceEvents changes the keyspace notifications published.
+func (e *Engine) SetKeyspaceEvents(ev KeyspaceEvents) {
+	e.keyspaceEvents.Store(uint32(ev))
+}
+
+// KeyspaceEvents returns the keyspace notifications published.
+func (e *Engine) KeyspaceEvents() KeyspaceEvents {
+	return KeyspaceEvents(e.keyspaceEvents.Load())
+}
+
+// notifying reports whether events of class are published (must hold e.mu).
+func (e *Engine) notifying(class KeyspaceEvents) bool {
+	ev := e.KeyspaceEvents()
+	return e.keyspaceHook != nil && ev&class != 0 && ev&(NotifyKeyspace|NotifyKeyevent) != 0
+}
+
+// notify publishes a keyspace event of class about key (must hold e.mu).
+// Every change to a key goes through here, so it also dirties the watches
+// on key.
+func (e *Engine) notify(class KeyspaceEvents, event, key string) {
+	e.touchWatched(key)
+	if e.notifying(class) {
+		e.keyspaceHook(e.db, e.KeyspaceEvents(), event, key)
+	}
+}
+
+// notifyRemoved publishes event, and a del event if it left key empty and
+// so removed it (must hold e.mu).
+func (e *Engine) notifyRemoved(class KeyspaceEvents, event, key string) {
+	if !e.notifying(class | NotifyGeneric) {
+		e.touchWatched(key)
+		return
+	}
+	e.notify(class, event, key)
+	if !e.store.Exists(key) {
+		e.notify(NotifyGeneric, "del", key)
+	}
+}
@@ -0,0 +1,147 @@
+package engine
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/flashdb/flashdb/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// keyspaceRecorder collects keyspace events as "db:event:key".
+type keyspaceRecorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *keyspaceRecorder) record(db int, _ KeyspaceEvents, event, key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, fmt.Sprintf("%d:%s:%s", db, event, key))
+}
+
+func (r *keyspaceRecorder) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
+
+func TestEngine_KeyspaceEvents(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	cfg := DefaultConfig()
+	cfg.NotifyKeyspaceEvents = NotifyKeyevent | NotifyAll
+	e, err := NewWithConfig(walPath, cfg)
+	require.NoError(t, err)
+	defer e.Close()
+	var rec keyspaceRecorder
+	e.OnKeyspaceEvent(rec.record)
+
+	require.NoError(t, e.Set("s", []byte("1")))
+	_, err = e.IncrBy("s", 2)
+	require.NoError(t, err)
+	_, err = e.Expire("s", time.Hour)
+	require.NoError(t, err)
+	_, err = e.Rename("s", "t", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0:set:s", "0:incrby:s", "0:expire:s", "0:rename_from:s", "0:rename_to:t"}, rec.take())
+
+	// Emptying a container deletes the key.
+	_, err = e.HSet("h", store.HashFieldValue{Field: "f", Value: []byte("v")})
+	require.NoError(t, err)
+	_, err = e.HDel("h", "missing")
+	require.NoError(t, err)
+	_, err = e.HDel("h", "f")
+	require.NoError(t, err)
+	_, err = e.SAdd("set", "a", "b")
+	require.NoError(t, err)
+	_, err = e.SRem("set", "a")
+	require.NoError(t, err)
+	_, err = e.ZAdd("z", store.ScoredMember{Member: "m", Score: 1})
+	require.NoError(t, err)
+	_, err = e.ZPopMin("z", 1)
+	require.NoError(t, err)
+	_, _, err = e.LMove("missing", "dst", true, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"0:hset:h", "0:hdel:h", "0:del:h", "0:sadd:set", "0:srem:set",
+		"0:zadd:z", "0:zpopmin:z", "0:del:z",
+	}, rec.take())
+
+	db2 := selectDB(t, e, 2)
+	_, err = db2.RPush("l", []byte("a"))
+	require.NoError(t, err)
+	_, _, err = db2.LMove("l", "l2", true, false)
+	require.NoError(t, err)
+	_, err = db2.Move("l2", 0)
+	require.NoError(t, err)
+	_, err = e.Delete("l2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"2:rpush:l", "2:lpop:l", "2:del:l", "2:rpush:l2",
+		"2:move_from:l2", "0:move_to:l2", "0:del:l2",
+	}, rec.take())
+
+	// Only the selected classes are published, and nothing without K or E.
+	e.SetKeyspaceEvents(NotifyKeyspace | NotifyHash)
+	require.NoError(t, e.Set("s", []byte("v")))
+	_, err = e.HSet("h", store.HashFieldValue{Field: "f", Value: []byte("v")})
+	require.NoError(t, err)
+	e.SetKeyspaceEvents(NotifyAll)
+	require.NoError(t, e.Set("s", []byte("v")))
+	assert.Equal(t, []string{"0:hset:h"}, rec.take())
+
+	// Keys removed by the expiry GC.
+	e.SetKeyspaceEvents(NotifyKeyevent | NotifyExpired)
+	require.NoError(t, db2.SetWithTTL("short", []byte("v"), time.Millisecond))
+	require.Eventually(t, func() bool {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		return len(rec.events) > 0
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"2:expired:short"}, rec.take())
+}
+
+func TestEngine_KeyspaceEventsEvicted(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	cfg := DefaultConfig()
+	cfg.MaxMemoryPolicy = store.AllKeysRandom
+	cfg.NotifyKeyspaceEvents = NotifyKeyspace | NotifyEvicted
+	e, err := NewWithConfig(walPath, cfg)
+	require.NoError(t, err)
+	defer e.Close()
+	var rec keyspaceRecorder
+	e.OnKeyspaceEvent(rec.record)
+
+	require.NoError(t, e.Set("a", make([]byte, 100)))
+	require.NoError(t, e.Set("b", make([]byte, 100)))
+	e.SetMaxMemory(e.Memory().Used - 1)
+	require.NoError(t, e.FreeMemory())
+	events := rec.take()
+	require.Len(t, events, 1)
+	assert.Contains(t, []string{"0:evicted:a", "0:evicted:b"}, events[0])
+}
+
+func TestParseKeyspaceEvents(t *testing.T) {
+	for s, want := range map[string]KeyspaceEvents{
+		"":    0,
+		"KEA": NotifyKeyspace | NotifyKeyevent | NotifyAll,
+		"Elg": NotifyKeyevent | NotifyList | NotifyGeneric,
+		"Kx$": NotifyKeyspace | NotifyExpired | NotifyString,
+	} {
+		ev, err := ParseKeyspaceEvents(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, want, ev, s)
+	}
+	_, err := ParseKeyspaceEvents("KEQ")
+	assert.Error(t, err)
+
+	assert.Equal(t, "AKE", (NotifyKeyspace | NotifyKeyevent | NotifyAll).String())
+	assert.Equal(t, "g$E", (NotifyKeyevent | NotifyString | NotifyGeneric).String())
+	assert.Equal(t, "", KeyspaceEvents(0).String())
+}
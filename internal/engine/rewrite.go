@@ -0,0 +1,195 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/flashdb/flashdb/internal/wal"
+)
+
+var (
+	// ErrClosed is returned when a background task is requested after Close.
+	ErrClosed = errors.New("engine: closed")
+	// ErrRewriteInProgress is returned when a WAL rewrite is already running.
+	ErrRewriteInProgress = errors.New("engine: WAL rewrite already in progress")
+)
+
+// PersistenceStats describes the state of the WAL, its fsyncs and its rewrites.
+type PersistenceStats struct {
+	WALSize           int64
+	WALBaseSize       int64 // size right after startup or the last rewrite
+	RewriteInProgress bool
+	Rewrites          int64
+	LastRewriteOK     bool
+	LastRewriteTime   time.Duration
+
+	SyncPolicy   wal.SyncPolicy
+	PendingBytes int64 // written but not yet fsynced
+	Fsyncs       int64
+	LastFsync    time.Duration
+	AvgFsync     time.Duration
+
+	Checkpoint string // snapshot the WAL starts from, "" if self-contained
+
+	SnapshotInProgress bool
+	SnapshotID         string  // snapshot being written
+	SnapshotProgress   float64 // fraction of entries written, 0 to 1
+	Snapshots          int64
+	LastSnapshotOK     bool
+	LastSnapshotTime   time.Duration
+
+	ChangesSinceSave int64
+	LastSave         time.Time
+}
+
+// Rewrite compacts the WAL into the minimal set of records that rebuilds the
+// current dataset and waits for it to finish.
+func (e *Engine) Rewrite() error {
+	done, err := e.startRewrite()
+	if err != nil {
+		return err
+	}
+	return <-done
+}
+
+// BackgroundRewrite starts a WAL rewrite and returns immediately.
+func (e *Engine) BackgroundRewrite() error {
+	_, err := e.startRewrite()
+	return err
+}
+
+// startRewrite launches a rewrite goroutine. The returned channel receives
+// its result.
+func (e *Engine) startRewrite() (<-chan error, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.closed {
+		return nil, ErrClosed
+	}
+	if !e.rewriting.CompareAndSwap(false, true) {
+		return nil, ErrRewriteInProgress
+	}
+
+	done := make(chan error, 1)
+	e.bgWG.Add(1)
+	go func() {
+		defer e.bgWG.Done()
+		defer e.rewriting.Store(false)
+
+		start := time.Now()
+		err := e.rewrite()
+		e.lastRewriteTime.Store(int64(time.Since(start)))
+		e.lastRewriteFailed.Store(err != nil)
+		if err == nil {
+			e.rewrites.Add(1)
+		}
+		done <- err
+	}()
+	return done, nil
+}
+
+// rewrite captures the dataset and the WAL position together, then writes
+// the new log while writers carry on.
+func (e *Engine) rewrite() error {
+	e.mu.RLock()
+	view := e.openView()
+	dirty := e.dirty.Load()
+	err := e.wal.StartRewrite()
+	e.mu.RUnlock()
+	if err != nil {
+		view.close()
+		return fmt.Errorf("engine: failed to start WAL rewrite: %w", err)
+	}
+
+	var records []wal.Record
+	db := 0
+	view.visit(func(entry any) error {
+		records = appendEntryRecords(records, &db, entry)
+		return nil
+	}, nil)
+	view.close()
+
+	if err := e.wal.FinishRewrite(records); err != nil {
+		return fmt.Errorf("engine: failed to rewrite WAL: %w", err)
+	}
+	e.walBaseSize.Store(e.wal.Size())
+	e.saved(dirty)
+
+	// The rewritten log holds the whole dataset again.
+	e.mu.Lock()
+	e.walCheckpoint = ""
+	e.mu.Unlock()
+	return nil
+}
+
+// needsRewrite reports whether the WAL has outgrown the configured thresholds.
+func (e *Engine) needsRewrite() bool {
+	pct := e.cfg.AutoRewritePercentage
+	if pct <= 0 || e.rewriting.Load() {
+		return false
+	}
+	size := e.wal.Size()
+	if size < e.cfg.AutoRewriteMinSize {
+		return false
+	}
+	base := e.walBaseSize.Load()
+	return size >= base+base*int64(pct)/100
+}
+
+// persistenceLoop periodically starts a rewrite when the WAL grows too
+// large and a snapshot when a save rule matches.
+func (e *Engine) persistenceLoop() {
+	defer e.bgWG.Done()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.stopBg:
+			return
+		case <-ticker.C:
+			if e.needsRewrite() {
+				e.BackgroundRewrite()
+			}
+			if e.needsSave() {
+				e.autoSave()
+			}
+		}
+	}
+}
+
+// PersistenceStats returns WAL size, fsync, rewrite and snapshot statistics.
+func (e *Engine) PersistenceStats() PersistenceStats {
+	ws := e.wal.Stats()
+	e.mu.RLock()
+	checkpoint := e.walCheckpoint
+	e.mu.RUnlock()
+	cur, snapshotting := e.snapshotProgress()
+	return PersistenceStats{
+		WALSize:           ws.Size,
+		WALBaseSize:       e.walBaseSize.Load(),
+		RewriteInProgress: e.rewriting.Load(),
+		Rewrites:          e.rewrites.Load(),
+		LastRewriteOK:     !e.lastRewriteFailed.Load(),
+		LastRewriteTime:   time.Duration(e.lastRewriteTime.Load()),
+		SyncPolicy:        ws.Policy,
+		PendingBytes:      ws.PendingBytes,
+		Fsyncs:            ws.Fsyncs,
+		LastFsync:         ws.LastFsync,
+		AvgFsync:          ws.AvgFsync,
+		Checkpoint:        checkpoint,
+
+		SnapshotInProgress: snapshotting,
+		SnapshotID:         cur.ID,
+		SnapshotProgress:   cur.Progress,
+		Snapshots:          e.snapshots.Load(),
+		LastSnapshotOK:     !e.lastSnapshotFailed.Load(),
+		LastSnapshotTime:   time.Duration(e.lastSnapshotTime.Load()),
+
+		ChangesSinceSave: e.dirty.Load(),
+		LastSave:         e.LastSave(),
+	}
+}
@@ -0,0 +1,170 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flashdb/flashdb/internal/snapshot"
+)
+
+// autoSnapshotPrefix marks snapshots taken by save rules. Only these are
+// pruned by the retention policy; named snapshots stay until deleted.
+const autoSnapshotPrefix = "auto-"
+
+// saveRetryDelay is how long save rules wait after a failed snapshot.
+const saveRetryDelay = 5 * time.Second
+
+// SaveRule takes a snapshot once Interval has passed since the last save
+// and at least Changes writes were made in the meantime.
+type SaveRule struct {
+	Interval time.Duration
+	Changes  int64
+}
+
+// String formats the rule as "<seconds> <changes>".
+func (r SaveRule) String() string {
+	return fmt.Sprintf("%d %d", int64(r.Interval/time.Second), r.Changes)
+}
+
+// ParseSaveRules parses Redis-style save rules: pairs of seconds and
+// changes, e.g. "900 1 300 10 60 10000". An empty string disables them.
+func ParseSaveRules(s string) ([]SaveRule, error) {
+	fields := strings.Fields(s)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("engine: invalid save rules %q: expected <seconds> <changes> pairs", s)
+	}
+	rules := make([]SaveRule, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		secs, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil || secs <= 0 {
+			return nil, fmt.Errorf("engine: invalid save interval %q", fields[i])
+		}
+		changes, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil || changes < 0 {
+			return nil, fmt.Errorf("engine: invalid save changes %q", fields[i+1])
+		}
+		rules = append(rules, SaveRule{Interval: time.Duration(secs) * time.Second, Changes: changes})
+	}
+	return rules, nil
+}
+
+// RetentionPolicy decides which snapshots taken by save rules are kept.
+// A snapshot survives if any Keep rule selects it; all Keep rules at zero
+// keep everything. Snapshots older than MaxAge are removed regardless.
+type RetentionPolicy struct {
+	KeepLast   int           // the newest N snapshots
+	KeepHourly int           // the newest snapshot of each of the last N hours that have one
+	KeepDaily  int           // the newest snapshot of each of the last N days that have one
+	MaxAge     time.Duration // 0 = no age limit
+}
+
+// prune returns the snapshots from metas, newest first, that the policy
+// drops at now.
+func (p RetentionPolicy) prune(metas []snapshot.Meta, now time.Time) []snapshot.Meta {
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].CreatedAt.After(metas[j].CreatedAt)
+	})
+
+	keep := make([]bool, len(metas))
+	counted := p.KeepLast > 0 || p.KeepHourly > 0 || p.KeepDaily > 0
+	if !counted {
+		for i := range keep {
+			keep[i] = true
+		}
+	}
+	for i := 0; i < len(metas) && i < p.KeepLast; i++ {
+		keep[i] = true
+	}
+	keepBuckets(metas, keep, p.KeepHourly, func(t time.Time) string {
+		return t.Format("2006-01-02T15")
+	})
+	keepBuckets(metas, keep, p.KeepDaily, func(t time.Time) string {
+		return t.Format("2006-01-02")
+	})
+
+	var drop []snapshot.Meta
+	for i, m := range metas {
+		if !keep[i] || (p.MaxAge > 0 && now.Sub(m.CreatedAt) > p.MaxAge) {
+			drop = append(drop, m)
+		}
+	}
+	return drop
+}
+
+// keepBuckets marks the newest snapshot of each of the first n time buckets.
+func keepBuckets(metas []snapshot.Meta, keep []bool, n int, bucket func(time.Time) string) {
+	last := ""
+	for i := 0; i < len(metas) && n > 0; i++ {
+		b := bucket(metas[i].CreatedAt.Local())
+		if b == last {
+			continue
+		}
+		last = b
+		keep[i] = true
+		n--
+	}
+}
+
+// LastSave returns the time of the last successful snapshot or WAL rewrite,
+// or the startup time if there has been none.
+func (e *Engine) LastSave() time.Time {
+	return time.Unix(0, e.lastSave.Load())
+}
+
+// saved records a successful save that covered the first dirty changes.
+func (e *Engine) saved(dirty int64) {
+	e.dirty.Add(-dirty)
+	e.lastSave.Store(time.Now().UnixNano())
+}
+
+// needsSave reports whether any save rule matches.
+func (e *Engine) needsSave() bool {
+	if len(e.cfg.SaveRules) == 0 || e.snapshotting.Load() {
+		return false
+	}
+	now := time.Now()
+	if e.lastSnapshotFailed.Load() && now.Sub(time.Unix(0, e.lastSaveTry.Load())) < saveRetryDelay {
+		return false
+	}
+	elapsed := now.Sub(e.LastSave())
+	dirty := e.dirty.Load()
+	for _, r := range e.cfg.SaveRules {
+		if elapsed >= r.Interval && dirty >= r.Changes {
+			return true
+		}
+	}
+	return false
+}
+
+// autoSave starts a snapshot for the save rules.
+func (e *Engine) autoSave() {
+	now := time.Now()
+	e.lastSaveTry.Store(now.UnixNano())
+	e.BackgroundSnapshot(fmt.Sprintf("%s%d", autoSnapshotPrefix, now.UnixMilli()))
+}
+
+// pruneSnapshots deletes the snapshots taken by save rules that the
+// retention policy no longer keeps. The snapshot the WAL starts from is
+// never deleted.
+func (e *Engine) pruneSnapshots() error {
+	metas, err := e.snapMgr.List()
+	if err != nil {
+		return err
+	}
+	var auto []snapshot.Meta
+	for _, m := range metas {
+		if strings.HasPrefix(m.ID, autoSnapshotPrefix) {
+			auto = append(auto, m)
+		}
+	}
+	for _, m := range e.cfg.SnapshotRetention.prune(auto, time.Now()) {
+		if err := e.SnapshotDelete(m.ID); err != nil && !errors.Is(err, ErrSnapshotInUse) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,346 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/flashdb/flashdb/internal/search"
+	"github.com/flashdb/flashdb/internal/snapshot"
+	"github.com/flashdb/flashdb/internal/store"
+	"github.com/flashdb/flashdb/internal/wal"
+)
+
+// ========================
+// Search Encoding Helpers
+// ========================
+
+// Only index definitions are logged and snapshotted; their contents are
+// derived from the keys whenever an index is created or loaded. Index
+// records reuse the stream record encoding.
+
+// ftCreateRecord returns the WAL record that creates the index def.
+func ftCreateRecord(def search.Definition) wal.Record {
+	buf := appendRecordInt(nil, int64(len(def.Prefixes)))
+	for _, p := range def.Prefixes {
+		buf = appendRecordString(buf, p)
+	}
+	for _, f := range def.Fields {
+		var sortable int64
+		if f.Sortable {
+			sortable = 1
+		}
+		buf = appendRecordString(buf, f.Name)
+		buf = appendRecordInt(buf, int64(f.Type))
+		buf = appendRecordInt(buf, int64(f.Separator))
+		buf = appendRecordInt(buf, sortable)
+	}
+	return wal.Record{Type: wal.OpFTCreate, Key: []byte(def.Name), Value: buf}
+}
+
+// decodeIndexDefinition decodes the definition an OpFTCreate record logs.
+func decodeIndexDefinition(rec wal.Record) search.Definition {
+	r := &recordReader{buf: rec.Value}
+	def := search.Definition{Name: string(rec.Key)}
+	for n := r.int64(); n > 0 && len(r.buf) > 0; n-- {
+		def.Prefixes = append(def.Prefixes, r.string())
+	}
+	for len(r.buf) > 0 {
+		def.Fields = append(def.Fields, search.Field{
+			Name:      r.string(),
+			Type:      search.FieldType(r.int64()),
+			Separator: byte(r.int64()),
+			Sortable:  r.int64() != 0,
+		})
+	}
+	return def
+}
+
+// indexEntry converts an index definition into a snapshot entry.
+func indexEntry(def search.Definition) snapshot.IndexEntry {
+	entry := snapshot.IndexEntry{Name: def.Name, Prefixes: def.Prefixes}
+	for _, f := range def.Fields {
+		field := snapshot.IndexField{Name: f.Name, Type: f.Type.String(), Sortable: f.Sortable}
+		if f.Separator != 0 {
+			field.Separator = string(f.Separator)
+		}
+		entry.Fields = append(entry.Fields, field)
+	}
+	return entry
+}
+
+// indexDefinition converts a snapshot entry back into an index definition.
+func indexDefinition(entry snapshot.IndexEntry) search.Definition {
+	def := search.Definition{Name: entry.Name, Prefixes: entry.Prefixes}
+	for _, f := range entry.Fields {
+		typ, _ := search.ParseFieldType(f.Type)
+		field := search.Field{Name: f.Name, Type: typ, Sortable: f.Sortable}
+		if f.Separator != "" {
+			field.Separator = f.Separator[0]
+		}
+		def.Fields = append(def.Fields, field)
+	}
+	return def
+}
+
+// applySearch replays a search index WAL record.
+func (e *Engine) applySearch(rec wal.Record) {
+	switch rec.Type {
+	case wal.OpFTCreate:
+		e.createIndex(decodeIndexDefinition(rec))
+	case wal.OpFTDrop:
+		e.dropIndex(string(rec.Key))
+	}
+}
+
+// ========================
+// Index Maintenance
+// ========================
+
+// Indexes follow the keyspace through the store's change tracking, which
+// is on while any index exists: every write and expiration ends with
+// syncIndexes, which re-reads the keys changed since the last one.
+
+// createIndex adds an index, replacing any of the same name, and fills it
+// from the keys it covers (must hold e.mu).
+func (e *Engine) createIndex(def search.Definition) {
+	ix := search.NewIndex(def)
+	e.store.TrackChanges(true)
+
+	e.idxMu.Lock()
+	defer e.idxMu.Unlock()
+	for _, key := range e.store.Keys() {
+		if !def.Matches(key) {
+			continue
+		}
+		if fields, ok := e.hashFields(key); ok {
+			ix.Put(key, fields)
+		}
+	}
+	e.indexes[def.Name] = ix
+}
+
+// dropIndex removes an index (must hold e.mu).
+func (e *Engine) dropIndex(name string) {
+	delete(e.indexes, name)
+	if len(e.indexes) == 0 {
+		e.store.TrackChanges(false)
+	}
+}
+
+// resetIndexes removes all indexes, e.g. before a whole dataset is
+// replaced (must hold e.mu).
+func (e *Engine) resetIndexes() {
+	e.indexes = make(map[string]*search.Index)
+	e.store.TrackChanges(false)
+}
+
+// syncIndexes brings the indexes up to date with the keys changed since
+// the last call (must hold e.mu, for reading or writing).
+func (e *Engine) syncIndexes() {
+	if len(e.indexes) == 0 {
+		return
+	}
+	e.idxMu.Lock()
+	defer e.idxMu.Unlock()
+
+	for _, key := range e.store.TakeChanged() {
+		var fields map[string]string
+		loaded, isHash := false, false
+		for _, ix := range e.indexes {
+			def := ix.Definition()
+			if !def.Matches(key) {
+				continue
+			}
+			if !loaded {
+				fields, isHash = e.hashFields(key)
+				loaded = true
+			}
+			if isHash {
+				ix.Put(key, fields)
+			} else {
+				ix.Remove(key)
+			}
+		}
+	}
+}
+
+// hashFields returns the fields of the hash at key, or false if key does
+// not hold a hash.
+func (e *Engine) hashFields(key string) (map[string]string, bool) {
+	fvs, err := e.store.HGetAll(key)
+	if err != nil || len(fvs) == 0 {
+		return nil, false
+	}
+	fields := make(map[string]string, len(fvs))
+	for _, fv := range fvs {
+		fields[fv.Field] = string(fv.Value)
+	}
+	return fields, true
+}
+
+// indexEntries returns the definitions of all indexes as snapshot entries,
+// ordered by name (must hold e.mu).
+func (e *Engine) indexEntries() []snapshot.IndexEntry {
+	entries := make([]snapshot.IndexEntry, 0, len(e.indexes))
+	for _, ix := range e.indexes {
+		entries = append(entries, indexEntry(ix.Definition()))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries
+}
+
+// ========================
+// Search Operations
+// ========================
+
+// SearchResult is a document found by FTSearch.
+type SearchResult struct {
+	Key    string
+	Fields []store.HashFieldValue // nil with search.Options.NoContent
+}
+
+// FTCreate creates an index over the hashes whose keys start with one of
+// the definition's prefixes, indexing the hashes that already exist.
+func (e *Engine) FTCreate(def search.Definition) (err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := def.Validate(); err != nil {
+		e.recordCommand()
+		return err
+	}
+	if _, ok := e.indexes[def.Name]; ok {
+		e.recordCommand()
+		return search.ErrIndexExists
+	}
+
+	rec := ftCreateRecord(def)
+	if err := e.writeWAL(rec); err != nil {
+		return fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+	e.apply(rec)
+	e.recordWrite()
+	return nil
+}
+
+// FTDropIndex removes an index and, if deleteDocs is set, the hashes it
+// holds.
+func (e *Engine) FTDropIndex(name string, deleteDocs bool) (err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ix, ok := e.indexes[name]
+	if !ok {
+		e.recordCommand()
+		return search.ErrNoIndex
+	}
+
+	var records []wal.Record
+	if deleteDocs {
+		e.syncIndexes()
+		e.idxMu.Lock()
+		for _, key := range ix.Keys() {
+			records = append(records, wal.Record{Type: wal.OpDelete, Key: []byte(key)})
+		}
+		e.idxMu.Unlock()
+	}
+	records = append(records, wal.Record{Type: wal.OpFTDrop, Key: []byte(name)})
+	if err := e.writeWAL(records...); err != nil {
+		return fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+	for _, rec := range records {
+		e.apply(rec)
+		if rec.Type == wal.OpDelete {
+			e.notify(NotifyGeneric, "del", string(rec.Key))
+		}
+	}
+	e.recordWrite()
+	return nil
+}
+
+// FTSearch runs query against an index and returns the number of matching
+// documents and the page of them selected by opts, with their fields.
+func (e *Engine) FTSearch(name, query string, opts search.Options) (int, []SearchResult, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	e.recordRead()
+
+	ix, ok := e.indexes[name]
+	if !ok {
+		return 0, nil, search.ErrNoIndex
+	}
+	def := ix.Definition()
+	q, err := search.Parse(&def, query)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	e.syncIndexes()
+	e.idxMu.Lock()
+	total, keys, err := ix.Search(q, opts, e.store.Exists)
+	e.idxMu.Unlock()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	results := make([]SearchResult, len(keys))
+	for i, key := range keys {
+		results[i].Key = key
+		if opts.NoContent {
+			continue
+		}
+		fields, _ := e.store.HGetAll(key)
+		if opts.Return != nil {
+			fields = selectFields(fields, opts.Return)
+		}
+		results[i].Fields = fields
+	}
+	return total, results, nil
+}
+
+// selectFields returns the named fields that fvs has, in the order named.
+func selectFields(fvs []store.HashFieldValue, names []string) []store.HashFieldValue {
+	out := make([]store.HashFieldValue, 0, len(names))
+	for _, name := range names {
+		for _, fv := range fvs {
+			if fv.Field == name {
+				out = append(out, fv)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// FTInfo returns the definition and size of an index, leaving out
+// documents that expired but were not collected yet.
+func (e *Engine) FTInfo(name string) (search.Definition, search.Stats, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	e.recordRead()
+
+	ix, ok := e.indexes[name]
+	if !ok {
+		return search.Definition{}, search.Stats{}, search.ErrNoIndex
+	}
+	e.syncIndexes()
+	e.idxMu.Lock()
+	defer e.idxMu.Unlock()
+	return ix.Definition(), ix.Stats(e.store.Exists), nil
+}
+
+// FTList returns the names of all indexes, sorted.
+func (e *Engine) FTList() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	e.recordRead()
+
+	names := make([]string, 0, len(e.indexes))
+	for name := range e.indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
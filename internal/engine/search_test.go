@@ -0,0 +1,172 @@
+package engine
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flashdb/flashdb/internal/search"
+	"github.com/flashdb/flashdb/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func productIndex() search.Definition {
+	return search.Definition{
+		Name:     "products",
+		Prefixes: []string{"product:"},
+		Fields: []search.Field{
+			{Name: "name", Type: search.FieldText},
+			{Name: "color", Type: search.FieldTag, Separator: search.DefaultSeparator},
+			{Name: "price", Type: search.FieldNumeric, Sortable: true},
+		},
+	}
+}
+
+func hset(t *testing.T, e *Engine, key string, pairs ...string) {
+	t.Helper()
+	fields := make([]store.HashFieldValue, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		fields = append(fields, store.HashFieldValue{Field: pairs[i], Value: []byte(pairs[i+1])})
+	}
+	_, err := e.HSet(key, fields...)
+	require.NoError(t, err)
+}
+
+// searchKeys runs a query sorted by key and returns the matching keys.
+func searchKeys(t *testing.T, e *Engine, query string) []string {
+	t.Helper()
+	total, results, err := e.FTSearch("products", query, search.Options{Limit: 100, NoContent: true})
+	require.NoError(t, err)
+	keys := make([]string, len(results))
+	for i, r := range results {
+		keys[i] = r.Key
+	}
+	assert.Equal(t, total, len(keys))
+	return keys
+}
+
+func TestEngine_SearchFollowsWrites(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+	defer e.Close()
+
+	hset(t, e, "product:1", "name", "Blue Shirt", "color", "blue", "price", "20")
+	hset(t, e, "other:1", "name", "blue shirt")
+
+	require.NoError(t, e.FTCreate(productIndex()))
+	assert.ErrorIs(t, e.FTCreate(productIndex()), search.ErrIndexExists)
+	assert.Equal(t, []string{"products"}, e.FTList())
+	assert.Equal(t, []string{"product:1"}, searchKeys(t, e, "shirt"))
+
+	hset(t, e, "product:2", "name", "Red shirt", "color", "red,blue", "price", "35")
+	hset(t, e, "product:3", "name", "Red hat", "color", "red", "price", "15")
+	assert.Equal(t, []string{"product:1", "product:2"}, searchKeys(t, e, "@color:{blue}"))
+	assert.Equal(t, []string{"product:2", "product:3"}, searchKeys(t, e, "red @price:[10 40]"))
+
+	// Field changes, deletions and key changes are all picked up.
+	hset(t, e, "product:1", "price", "50")
+	assert.Equal(t, []string{"product:1"}, searchKeys(t, e, "@price:[40 +inf]"))
+	_, err = e.HDel("product:2", "color")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"product:1"}, searchKeys(t, e, "@color:{blue}"))
+	_, err = e.Delete("product:3")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"product:2"}, searchKeys(t, e, "red"))
+	_, err = e.Rename("product:2", "archived:2", false)
+	require.NoError(t, err)
+	assert.Empty(t, searchKeys(t, e, "red"))
+	require.NoError(t, e.Set("product:1", []byte("not a hash")))
+	assert.Empty(t, searchKeys(t, e, "*"))
+
+	hset(t, e, "product:4", "name", "Green socks", "price", "5")
+	_, err = e.Expire("product:4", 20*time.Millisecond)
+	require.NoError(t, err)
+	time.Sleep(40 * time.Millisecond)
+	assert.Empty(t, searchKeys(t, e, "socks"))
+	_, stats, err := e.FTInfo("products")
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Docs, "expired but not collected yet")
+	e.mu.RLock()
+	e.expireIfNeeded("product:4")
+	e.mu.RUnlock()
+	_, stats, err = e.FTInfo("products")
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Docs)
+
+	_, _, err = e.FTSearch("missing", "*", search.Options{})
+	assert.ErrorIs(t, err, search.ErrNoIndex)
+	_, _, err = e.FTSearch("products", "@nope:x", search.Options{})
+	assert.Error(t, err)
+}
+
+func TestEngine_SearchResults(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+	defer e.Close()
+
+	require.NoError(t, e.FTCreate(productIndex()))
+	hset(t, e, "product:1", "name", "a", "price", "3", "stock", "7")
+	hset(t, e, "product:2", "name", "b", "price", "1")
+	hset(t, e, "product:3", "name", "c", "price", "2")
+
+	total, results, err := e.FTSearch("products", "*", search.Options{SortBy: "price", Desc: true, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 3, total)
+	require.Len(t, results, 2)
+	assert.Equal(t, "product:1", results[0].Key)
+	assert.Len(t, results[0].Fields, 3)
+	assert.Equal(t, "product:3", results[1].Key)
+
+	_, results, err = e.FTSearch("products", "@price:[3 3]", search.Options{Limit: 10, Return: []string{"stock", "missing", "name"}})
+	require.NoError(t, err)
+	assert.Equal(t, []store.HashFieldValue{
+		{Field: "stock", Value: []byte("7")},
+		{Field: "name", Value: []byte("a")},
+	}, results[0].Fields)
+
+	require.NoError(t, e.FTDropIndex("products", true))
+	assert.Empty(t, e.FTList())
+	assert.Equal(t, 0, e.Size())
+	assert.ErrorIs(t, e.FTDropIndex("products", false), search.ErrNoIndex)
+}
+
+func TestEngine_SearchIndexesPersist(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+
+	hset(t, e, "product:1", "name", "old lamp", "color", "brass", "price", "40")
+	require.NoError(t, e.FTCreate(productIndex()))
+	hset(t, e, "product:2", "name", "new lamp", "color", "steel, chrome", "price", "60")
+	require.NoError(t, e.Close())
+
+	// Indexes are rebuilt from the WAL on startup.
+	e, err = New(walPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"product:1", "product:2"}, searchKeys(t, e, "lamp"))
+
+	// And from snapshots and rewritten logs, which only hold definitions.
+	_, err = e.SnapshotCreate("search")
+	require.NoError(t, err)
+	require.NoError(t, e.FTDropIndex("products", false))
+	require.NoError(t, e.SnapshotRestore("search"))
+	def, stats, err := e.FTInfo("products")
+	require.NoError(t, err)
+	assert.Equal(t, productIndex(), def)
+	assert.Equal(t, 2, stats.Docs)
+
+	require.NoError(t, e.Rewrite())
+	require.NoError(t, e.Close())
+	e, err = New(walPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"product:2"}, searchKeys(t, e, "@color:{chrome}"))
+	assert.Equal(t, []string{"product:1"}, searchKeys(t, e, "@price:[-inf 50]"))
+
+	// FLUSHALL drops indexes along with the data.
+	require.NoError(t, e.Clear())
+	assert.Empty(t, e.FTList())
+	require.NoError(t, e.Close())
+}
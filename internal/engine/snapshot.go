@@ -0,0 +1,668 @@
+package engine
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/flashdb/flashdb/internal/snapshot"
+	"github.com/flashdb/flashdb/internal/store"
+	"github.com/flashdb/flashdb/internal/wal"
+)
+
+// ========================
+// Snapshot Access
+// ========================
+
+var (
+	// ErrSnapshotInUse is returned when deleting the snapshot the WAL starts from.
+	ErrSnapshotInUse = errors.New("engine: snapshot is the base of the WAL")
+	// ErrSnapshotInProgress is returned when a snapshot is already being written.
+	ErrSnapshotInProgress = errors.New("engine: snapshot already in progress")
+	// ErrBusyKey is returned by Restore when the target key already exists.
+	ErrBusyKey = errors.New("engine: target key already exists")
+)
+
+// snapshotResult is the outcome of a background snapshot.
+type snapshotResult struct {
+	meta snapshot.Meta
+	err  error
+}
+
+// SnapshotCreate writes a point-in-time snapshot of every key, its TTL and
+// every time series, and waits for it to finish.
+func (e *Engine) SnapshotCreate(id string) (snapshot.Meta, error) {
+	_, done, err := e.startSnapshot(id)
+	if err != nil {
+		return snapshot.Meta{}, err
+	}
+	res := <-done
+	return res.meta, res.err
+}
+
+// BackgroundSnapshot starts a snapshot and returns immediately with its
+// metadata. Progress shows up in PersistenceStats and SnapshotList.
+func (e *Engine) BackgroundSnapshot(id string) (snapshot.Meta, error) {
+	meta, _, err := e.startSnapshot(id)
+	return meta, err
+}
+
+// startSnapshot captures the dataset and writes it out in the background.
+//
+// Writers are only paused while the view is opened, which copies key and
+// object references; the store then copies an object before a writer
+// changes it, so the snapshot sees the data exactly as it was when it began.
+//
+// Every snapshot is also a checkpoint: a marker in the WAL records the
+// position it covers, so startup can load it and replay only the records
+// after the marker. Once the snapshot is safely on disk, the WAL records
+// before the marker are dropped.
+func (e *Engine) startSnapshot(id string) (snapshot.Meta, <-chan snapshotResult, error) {
+	if !e.snapshotting.CompareAndSwap(false, true) {
+		return snapshot.Meta{}, nil, ErrSnapshotInProgress
+	}
+	w, err := e.snapMgr.NewWriter(id)
+	if err != nil {
+		e.snapshotting.Store(false)
+		return snapshot.Meta{}, nil, err
+	}
+	meta := w.Meta()
+	marker := wal.Record{Type: wal.OpCheckpoint, Key: []byte(meta.ID), Value: encodeCheckpoint(meta.CreatedAt)}
+
+	// Dropping old records is a WAL rewrite; skip it if one is running.
+	truncate := e.rewriting.CompareAndSwap(false, true)
+
+	e.mu.RLock()
+	if e.closed {
+		err = ErrClosed
+	}
+	if err == nil {
+		err = e.wal.Write(marker)
+	}
+	if err == nil && truncate && e.wal.StartRewrite() != nil {
+		e.rewriting.Store(false)
+		truncate = false
+	}
+	var view *dataView
+	var dirty int64
+	if err == nil {
+		view = e.openView()
+		dirty = e.dirty.Load()
+		e.bgWG.Add(1)
+	}
+	e.mu.RUnlock()
+
+	if err != nil {
+		w.Abort()
+		if truncate {
+			e.rewriting.Store(false)
+		}
+		e.snapshotting.Store(false)
+		return snapshot.Meta{}, nil, err
+	}
+
+	e.snapMu.Lock()
+	e.snapshotCur = meta
+	e.snapMu.Unlock()
+	e.snapshotTotal.Store(int64(view.len()))
+	e.snapshotDone.Store(0)
+
+	done := make(chan snapshotResult, 1)
+	go func() {
+		defer e.bgWG.Done()
+
+		start := time.Now()
+		meta, err := e.writeSnapshot(w, view, marker, truncate)
+		e.lastSnapshotTime.Store(int64(time.Since(start)))
+		e.lastSnapshotFailed.Store(err != nil)
+		if err == nil {
+			e.snapshots.Add(1)
+			e.saved(dirty)
+		}
+		e.snapshotting.Store(false)
+		if err == nil {
+			e.pruneSnapshots()
+		}
+		done <- snapshotResult{meta: meta, err: err}
+	}()
+	return meta, done, nil
+}
+
+// writeSnapshot streams a view into w and, if truncate is set, replaces the
+// WAL with the checkpoint marker and everything logged after it.
+func (e *Engine) writeSnapshot(w *snapshot.Writer, view *dataView, marker wal.Record, truncate bool) (snapshot.Meta, error) {
+	if truncate {
+		defer e.rewriting.Store(false)
+	}
+
+	err := view.visit(w.Write, &e.snapshotDone)
+	view.close()
+
+	var meta snapshot.Meta
+	if err == nil {
+		meta, err = w.Close()
+	} else {
+		w.Abort()
+	}
+	if err != nil {
+		if truncate {
+			e.wal.AbortRewrite()
+		}
+		return snapshot.Meta{}, err
+	}
+
+	if truncate {
+		// On failure the WAL simply keeps its older records.
+		if e.wal.FinishRewrite([]wal.Record{marker}) == nil {
+			e.walBaseSize.Store(e.wal.Size())
+			e.mu.Lock()
+			e.walCheckpoint = meta.ID
+			e.mu.Unlock()
+		}
+	}
+	return meta, nil
+}
+
+// SnapshotList returns all available snapshots, newest first, preceded by
+// the one being written, if any.
+func (e *Engine) SnapshotList() ([]snapshot.Meta, error) {
+	metas, err := e.snapMgr.List()
+	if err != nil {
+		return nil, err
+	}
+	if cur, ok := e.snapshotProgress(); ok {
+		metas = append([]snapshot.Meta{cur}, metas...)
+	}
+	return metas, nil
+}
+
+// snapshotProgress describes the snapshot being written, if any.
+func (e *Engine) snapshotProgress() (snapshot.Meta, bool) {
+	if !e.snapshotting.Load() {
+		return snapshot.Meta{}, false
+	}
+	e.snapMu.Lock()
+	cur := e.snapshotCur
+	e.snapMu.Unlock()
+
+	cur.Status = snapshot.StatusInProgress
+	cur.Progress = 1
+	if total := e.snapshotTotal.Load(); total > 0 {
+		cur.Progress = float64(e.snapshotDone.Load()) / float64(total)
+	}
+	return cur, true
+}
+
+// SnapshotRestore loads a snapshot and replaces the whole dataset with it.
+// The file is read and verified completely before anything is replaced. The
+// WAL is atomically replaced as well, so the restored state is exactly what
+// comes back after a restart.
+func (e *Engine) SnapshotRestore(id string) (err error) {
+	r, err := e.snapMgr.Open(id)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var records []wal.Record
+	db := 0
+	for {
+		entry, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		records = appendEntryRecords(records, &db, entry)
+		if err := e.checkDB(db); err != nil {
+			return fmt.Errorf("engine: snapshot %q: %w", id, err)
+		}
+	}
+
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.wal.Replace(records); err != nil {
+		return fmt.Errorf("engine: failed to replace WAL: %w", err)
+	}
+	e.walBaseSize.Store(e.wal.Size())
+	e.walCheckpoint = ""
+
+	// Watched keys change if they exist before or after.
+	for _, db := range e.dbs {
+		db.touchAllWatched()
+	}
+	e.clearAll()
+	for _, rec := range records {
+		e.dbs[rec.DB].apply(rec)
+	}
+	for _, db := range e.dbs {
+		db.touchAllWatched()
+	}
+
+	e.recordWrite()
+	return nil
+}
+
+// SnapshotDelete removes a snapshot by ID. The snapshot the WAL starts from
+// cannot be deleted, since startup needs it.
+func (e *Engine) SnapshotDelete(id string) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if id == e.walCheckpoint {
+		return ErrSnapshotInUse
+	}
+	return e.snapMgr.Delete(id)
+}
+
+// encodeCheckpoint encodes a snapshot creation time for a checkpoint marker.
+func encodeCheckpoint(createdAt time.Time) []byte {
+	return binary.LittleEndian.AppendUint64(nil, uint64(createdAt.UnixNano()))
+}
+
+// decodeCheckpoint decodes a snapshot creation time from a checkpoint marker.
+func decodeCheckpoint(data []byte) int64 {
+	if len(data) < 8 {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint64(data))
+}
+
+// loadCheckpoint loads the newest snapshot that has a checkpoint marker in
+// records and returns the index of the first record after that marker. A
+// snapshot that is missing, corrupt or does not match its marker is skipped.
+// If the WAL was truncated at a checkpoint and no snapshot can be loaded
+// for it, the data before the marker is gone and startup fails.
+func (e *Engine) loadCheckpoint(records []wal.Record) (int, error) {
+	for i := len(records) - 1; i >= 0; i-- {
+		rec := records[i]
+		if rec.Type != wal.OpCheckpoint {
+			continue
+		}
+		if err := e.loadSnapshot(string(rec.Key), decodeCheckpoint(rec.Value)); err != nil {
+			e.clearAll()
+			continue
+		}
+		if i == 0 {
+			e.walCheckpoint = string(rec.Key)
+		}
+		return i + 1, nil
+	}
+
+	if len(records) > 0 && records[0].Type == wal.OpCheckpoint {
+		return 0, fmt.Errorf("engine: WAL starts at snapshot %q, which is missing or invalid", records[0].Key)
+	}
+	return 0, nil
+}
+
+// loadSnapshot applies a snapshot to the (empty) in-memory state, checking
+// that it is the one a checkpoint marker refers to.
+func (e *Engine) loadSnapshot(id string, createdAt int64) error {
+	r, err := e.snapMgr.Open(id)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if r.Meta().CreatedAt.UnixNano() != createdAt {
+		return fmt.Errorf("engine: snapshot %q does not match its checkpoint", id)
+	}
+
+	var records []wal.Record
+	db := 0
+	for {
+		entry, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		records = appendEntryRecords(records[:0], &db, entry)
+		if err := e.checkDB(db); err != nil {
+			return fmt.Errorf("engine: snapshot %q: %w", id, err)
+		}
+		for _, rec := range records {
+			e.dbs[db].apply(rec)
+		}
+	}
+}
+
+// clearAll empties every database, time series included (must hold e.mu).
+func (e *Engine) clearAll() {
+	for _, db := range e.dbs {
+		db.flush()
+		db.timeseries.Clear()
+	}
+}
+
+// dataView is a point-in-time picture of the whole dataset, every database
+// included, that can be read without holding e.mu.
+type dataView struct {
+	dbs []*dbView
+}
+
+// dbView is the part of a dataView holding one database.
+type dbView struct {
+	db      int
+	keys    *store.View
+	series  []snapshot.SeriesEntry
+	indexes []snapshot.IndexEntry
+}
+
+// openView captures the dataset (must hold e.mu). Time series and index
+// definitions are copied right away; keys are captured by reference, see
+// store.View.
+func (e *Engine) openView() *dataView {
+	v := &dataView{dbs: make([]*dbView, len(e.dbs))}
+	for i, db := range e.dbs {
+		v.dbs[i] = db.openDBView()
+	}
+	return v
+}
+
+// openDBView captures the database (must hold e.mu).
+func (e *Engine) openDBView() *dbView {
+	v := &dbView{db: e.db, keys: e.store.OpenView(), indexes: e.indexEntries()}
+	for _, key := range e.timeseries.Keys() {
+		ser, ok := e.timeseries.Snapshot(key)
+		if !ok {
+			continue
+		}
+		points := make([]snapshot.Point, len(ser.Points))
+		for i, p := range ser.Points {
+			points[i] = snapshot.Point{Timestamp: p.Timestamp, Value: p.Value}
+		}
+		v.series = append(v.series, snapshot.SeriesEntry{Key: key, Retention: ser.Retention, Labels: ser.Labels, Points: points})
+	}
+	return v
+}
+
+// len returns the number of entries in the view.
+func (v *dataView) len() int {
+	n := 0
+	for i, dv := range v.dbs {
+		if m := dv.len(); m > 0 {
+			n += m
+			if i > 0 {
+				n++ // the DatabaseEntry
+			}
+		}
+	}
+	return n
+}
+
+func (v *dbView) len() int {
+	return v.keys.Len() + len(v.series) + len(v.indexes)
+}
+
+// visit passes the entries of every database holding data to fn, each
+// database after the first one introduced by a snapshot.DatabaseEntry, and
+// counts them in done if it is non-nil.
+func (v *dataView) visit(fn func(entry any) error, done *atomic.Int64) error {
+	for i, dv := range v.dbs {
+		if dv.len() == 0 {
+			continue
+		}
+		if i > 0 {
+			if err := fn(snapshot.DatabaseEntry{DB: dv.db}); err != nil {
+				return err
+			}
+			if done != nil {
+				done.Add(1)
+			}
+		}
+		if err := dv.visit(fn, done); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// visit passes every key, with its TTL, every time series and then every
+// index definition to fn as snapshot entries, counting them in done if it
+// is non-nil. Indexes come last so that loading fills each one in a single
+// pass over the keys.
+func (v *dbView) visit(fn func(entry any) error, done *atomic.Int64) error {
+	for i := 0; i < v.keys.Len(); i++ {
+		if entry := itemEntry(v.keys.Item(i)); entry != nil {
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		if done != nil {
+			done.Add(1)
+		}
+	}
+
+	for _, entry := range v.series {
+		if err := fn(entry); err != nil {
+			return err
+		}
+		if done != nil {
+			done.Add(1)
+		}
+	}
+
+	for _, entry := range v.indexes {
+		if err := fn(entry); err != nil {
+			return err
+		}
+		if done != nil {
+			done.Add(1)
+		}
+	}
+	return nil
+}
+
+// itemEntry converts a store item into a snapshot entry, or nil for an
+// unknown type.
+func itemEntry(item store.Item) any {
+	var expireAt int64
+	if item.HasExpire {
+		expireAt = item.ExpireAt.UnixMilli()
+	}
+	switch item.Type {
+	case store.TypeString:
+		return snapshot.KVEntry{Key: item.Key, Value: string(item.Str), ExpireAt: expireAt, Type: "string"}
+	case store.TypeHash:
+		fields := make(map[string]string, len(item.Hash))
+		for _, fv := range item.Hash {
+			fields[fv.Field] = string(fv.Value)
+		}
+		return snapshot.HashEntry{Key: item.Key, Fields: fields, ExpireAt: expireAt}
+	case store.TypeList:
+		values := make([]string, len(item.List))
+		for i, v := range item.List {
+			values[i] = string(v)
+		}
+		return snapshot.ListEntry{Key: item.Key, Values: values, ExpireAt: expireAt}
+	case store.TypeSet:
+		return snapshot.SetEntry{Key: item.Key, Members: item.Set, ExpireAt: expireAt}
+	case store.TypeZSet:
+		members := make([]snapshot.ZMember, len(item.ZSet))
+		for i, m := range item.ZSet {
+			members[i] = snapshot.ZMember{Member: m.Member, Score: m.Score}
+		}
+		return snapshot.ZSetEntry{Key: item.Key, Members: members, ExpireAt: expireAt}
+	case store.TypeStream:
+		return streamEntry(item.Key, item.Stream, expireAt)
+	case store.TypeJSON:
+		return snapshot.JSONEntry{Key: item.Key, Value: string(item.JSON), ExpireAt: expireAt}
+	}
+	return nil
+}
+
+// streamEntry converts a copy of a stream into a snapshot entry.
+func streamEntry(key string, data *store.StreamData, expireAt int64) snapshot.StreamEntry {
+	entry := snapshot.StreamEntry{
+		Key:      key,
+		Entries:  make([]snapshot.StreamItem, len(data.Entries)),
+		LastID:   snapshot.StreamID(data.LastID),
+		Groups:   make([]snapshot.StreamGroup, len(data.Groups)),
+		ExpireAt: expireAt,
+	}
+	for i, se := range data.Entries {
+		fields := make([]string, len(se.Fields))
+		for j, f := range se.Fields {
+			fields[j] = string(f)
+		}
+		entry.Entries[i] = snapshot.StreamItem{ID: snapshot.StreamID(se.ID), Fields: fields}
+	}
+	for i, g := range data.Groups {
+		group := snapshot.StreamGroup{
+			Name:      g.Name,
+			LastID:    snapshot.StreamID(g.LastID),
+			Consumers: make([]snapshot.StreamConsumer, len(g.Consumers)),
+			Pending:   make([]snapshot.StreamPending, len(g.Pending)),
+		}
+		for j, c := range g.Consumers {
+			group.Consumers[j] = snapshot.StreamConsumer{Name: c.Name, SeenAt: c.SeenAt}
+		}
+		for j, pe := range g.Pending {
+			group.Pending[j] = snapshot.StreamPending{
+				ID:          snapshot.StreamID(pe.ID),
+				Consumer:    pe.Consumer,
+				DeliveredAt: pe.DeliveredAt,
+				Deliveries:  pe.Deliveries,
+			}
+		}
+		entry.Groups[i] = group
+	}
+	return entry
+}
+
+// withExpireAt returns a key entry with its expiry set to expireAt.
+func withExpireAt(entry any, expireAt int64) any {
+	switch en := entry.(type) {
+	case snapshot.KVEntry:
+		en.ExpireAt = expireAt
+		return en
+	case snapshot.HashEntry:
+		en.ExpireAt = expireAt
+		return en
+	case snapshot.ListEntry:
+		en.ExpireAt = expireAt
+		return en
+	case snapshot.SetEntry:
+		en.ExpireAt = expireAt
+		return en
+	case snapshot.ZSetEntry:
+		en.ExpireAt = expireAt
+		return en
+	case snapshot.StreamEntry:
+		en.ExpireAt = expireAt
+		return en
+	case snapshot.JSONEntry:
+		en.ExpireAt = expireAt
+		return en
+	}
+	return entry
+}
+
+// close releases the view.
+func (v *dataView) close() {
+	for _, dv := range v.dbs {
+		dv.keys.Close()
+	}
+}
+
+// appendEntryRecords appends WAL records that recreate a snapshot entry in
+// database *db. A snapshot.DatabaseEntry sets *db for the entries after it.
+func appendEntryRecords(records []wal.Record, db *int, entry any) []wal.Record {
+	if en, ok := entry.(snapshot.DatabaseEntry); ok {
+		*db = en.DB
+		return records
+	}
+	start := len(records)
+	records = appendKeyRecords(records, entry)
+	for i := start; i < len(records); i++ {
+		records[i].DB = *db
+	}
+	return records
+}
+
+// appendKeyRecords appends WAL records that recreate a key, time series or
+// index entry.
+func appendKeyRecords(records []wal.Record, entry any) []wal.Record {
+	expire := func(key []byte, expireAt int64) {
+		if expireAt > 0 {
+			records = append(records, wal.Record{Type: wal.OpExpire, Key: key, ExpireAt: expireAt})
+		}
+	}
+
+	switch en := entry.(type) {
+	case snapshot.KVEntry:
+		rec := wal.Record{Type: wal.OpSet, Key: []byte(en.Key), Value: []byte(en.Value)}
+		if en.ExpireAt > 0 {
+			rec.Type = wal.OpSetWithTTL
+			rec.ExpireAt = en.ExpireAt
+		}
+		records = append(records, rec)
+	case snapshot.HashEntry:
+		key := []byte(en.Key)
+		for field, value := range en.Fields {
+			records = append(records, wal.Record{Type: wal.OpHSet, Key: key, Value: encodeHashField(field, []byte(value))})
+		}
+		expire(key, en.ExpireAt)
+	case snapshot.ListEntry:
+		key := []byte(en.Key)
+		for _, v := range en.Values {
+			records = append(records, wal.Record{Type: wal.OpRPush, Key: key, Value: []byte(v)})
+		}
+		expire(key, en.ExpireAt)
+	case snapshot.SetEntry:
+		key := []byte(en.Key)
+		for _, m := range en.Members {
+			records = append(records, wal.Record{Type: wal.OpSAdd, Key: key, Value: []byte(m)})
+		}
+		expire(key, en.ExpireAt)
+	case snapshot.ZSetEntry:
+		key := []byte(en.Key)
+		for _, m := range en.Members {
+			records = append(records, wal.Record{Type: wal.OpZAdd, Key: key, Value: encodeZMember(m.Member, m.Score)})
+		}
+		expire(key, en.ExpireAt)
+	case snapshot.StreamEntry:
+		key := []byte(en.Key)
+		for _, item := range en.Entries {
+			fields := make([][]byte, len(item.Fields))
+			for i, f := range item.Fields {
+				fields[i] = []byte(f)
+			}
+			records = append(records, wal.Record{Type: wal.OpXAdd, Key: key, Value: encodeXAdd(store.StreamID(item.ID), fields)})
+		}
+		records = append(records, wal.Record{Type: wal.OpXSetID, Key: key, Value: appendRecordID(nil, store.StreamID(en.LastID))})
+		for _, g := range en.Groups {
+			records = append(records, wal.Record{Type: wal.OpXGroupCreate, Key: key, Value: encodeXGroupID(g.Name, store.StreamID(g.LastID))})
+			for _, c := range g.Consumers {
+				records = append(records, wal.Record{Type: wal.OpXConsumerCreate, Key: key, Value: encodeXConsumer(g.Name, c.Name, c.SeenAt)})
+			}
+			for _, p := range g.Pending {
+				pe := store.PendingEntry{ID: store.StreamID(p.ID), DeliveredAt: p.DeliveredAt, Deliveries: p.Deliveries}
+				records = append(records, wal.Record{Type: wal.OpXDeliver, Key: key, Value: encodeXDeliver(g.Name, p.Consumer, pe)})
+			}
+		}
+		expire(key, en.ExpireAt)
+	case snapshot.JSONEntry:
+		key := []byte(en.Key)
+		edit := store.JSONEdit{Kind: store.JSONEditSet, Path: "$", Values: [][]byte{[]byte(en.Value)}}
+		records = append(records, jsonRecord(en.Key, edit))
+		expire(key, en.ExpireAt)
+	case snapshot.SeriesEntry:
+		key := []byte(en.Key)
+		records = append(records, wal.Record{Type: wal.OpTSMeta, Key: key, Value: encodeTSMeta(en.Retention, en.Labels)})
+		for _, p := range en.Points {
+			records = append(records, wal.Record{Type: wal.OpTSAdd, Key: key, Value: encodeTSPoint(p.Timestamp, p.Value)})
+		}
+	case snapshot.IndexEntry:
+		records = append(records, ftCreateRecord(indexDefinition(en)))
+	}
+	return records
+}
@@ -0,0 +1,830 @@
+package engine
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/flashdb/flashdb/internal/store"
+	"github.com/flashdb/flashdb/internal/wal"
+)
+
+// XAddID selects the ID of a new stream entry.
+type XAddID struct {
+	ID      store.StreamID
+	Auto    bool // "*": derive the whole ID from the clock
+	SeqAuto bool // "<ms>-*": use ID.Ms and pick the sequence number
+}
+
+// StreamTrim limits the length of a stream, either to MaxLen entries or to
+// the entries with IDs of at least MinID.
+type StreamTrim struct {
+	ByMinID bool
+	MaxLen  int
+	MinID   store.StreamID
+}
+
+// StreamCursor names a stream to read and the ID to read after. For
+// XReadGroup, New reads entries never delivered to the group instead of the
+// consumer's pending entries after ID.
+type StreamCursor struct {
+	Key string
+	ID  store.StreamID
+	New bool
+}
+
+// StreamRead holds the entries read from one stream.
+type StreamRead struct {
+	Key     string
+	Entries []store.StreamEntry
+}
+
+// XClaimOptions adjusts how XClaim records the claimed entries.
+type XClaimOptions struct {
+	DeliveredAt   int64 // unix milliseconds of the delivery, 0 = now
+	RetryCount    int64 // delivery count to set if HasRetryCount
+	HasRetryCount bool
+	Force         bool           // claim entries that are not pending yet
+	JustID        bool           // do not count the claim as a delivery
+	LastID        store.StreamID // raise the group's last delivered ID to this
+}
+
+// ========================
+// Stream Encoding Helpers
+// ========================
+
+// Stream records are built from length-prefixed strings (4 bytes LE),
+// IDs (ms and seq, 8 bytes LE each) and integers (8 bytes LE).
+
+func appendRecordString(buf []byte, s string) []byte {
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(s)))
+	return append(buf, s...)
+}
+
+func appendRecordID(buf []byte, id store.StreamID) []byte {
+	buf = binary.LittleEndian.AppendUint64(buf, id.Ms)
+	return binary.LittleEndian.AppendUint64(buf, id.Seq)
+}
+
+func appendRecordInt(buf []byte, n int64) []byte {
+	return binary.LittleEndian.AppendUint64(buf, uint64(n))
+}
+
+// recordReader decodes stream record values. Reading past the end yields
+// zero values.
+type recordReader struct {
+	buf []byte
+}
+
+func (r *recordReader) bytes() []byte {
+	if len(r.buf) < 4 {
+		r.buf = nil
+		return nil
+	}
+	n := int(binary.LittleEndian.Uint32(r.buf))
+	if len(r.buf) < 4+n {
+		r.buf = nil
+		return nil
+	}
+	b := r.buf[4 : 4+n]
+	r.buf = r.buf[4+n:]
+	return b
+}
+
+func (r *recordReader) string() string {
+	return string(r.bytes())
+}
+
+func (r *recordReader) id() store.StreamID {
+	if len(r.buf) < 16 {
+		r.buf = nil
+		return store.StreamID{}
+	}
+	id := store.StreamID{
+		Ms:  binary.LittleEndian.Uint64(r.buf),
+		Seq: binary.LittleEndian.Uint64(r.buf[8:]),
+	}
+	r.buf = r.buf[16:]
+	return id
+}
+
+func (r *recordReader) int64() int64 {
+	if len(r.buf) < 8 {
+		r.buf = nil
+		return 0
+	}
+	n := int64(binary.LittleEndian.Uint64(r.buf))
+	r.buf = r.buf[8:]
+	return n
+}
+
+// encodeXAdd encodes an entry for XADD WAL records.
+// Format: ID + (fieldLen(4 bytes LE) + field)...
+func encodeXAdd(id store.StreamID, fields [][]byte) []byte {
+	buf := appendRecordID(nil, id)
+	for _, f := range fields {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(f)))
+		buf = append(buf, f...)
+	}
+	return buf
+}
+
+func decodeXAdd(data []byte) (store.StreamID, [][]byte) {
+	r := &recordReader{buf: data}
+	id := r.id()
+	var fields [][]byte
+	for len(r.buf) > 0 {
+		fields = append(fields, r.bytes())
+	}
+	return id, fields
+}
+
+// encodeXTrim encodes a trim for XTRIM WAL records.
+// Format: mode(1 byte, 0 = MAXLEN, 1 = MINID) + count(8 bytes LE) or ID
+func encodeXTrim(trim StreamTrim) []byte {
+	if trim.ByMinID {
+		return appendRecordID([]byte{1}, trim.MinID)
+	}
+	return appendRecordInt([]byte{0}, int64(trim.MaxLen))
+}
+
+func decodeXTrim(data []byte) StreamTrim {
+	if len(data) < 1 {
+		return StreamTrim{}
+	}
+	r := &recordReader{buf: data[1:]}
+	if data[0] == 1 {
+		return StreamTrim{ByMinID: true, MinID: r.id()}
+	}
+	return StreamTrim{MaxLen: int(r.int64())}
+}
+
+// encodeXGroupID encodes a group name and an ID.
+func encodeXGroupID(group string, id store.StreamID) []byte {
+	return appendRecordID(appendRecordString(nil, group), id)
+}
+
+func decodeXGroupID(data []byte) (string, store.StreamID) {
+	r := &recordReader{buf: data}
+	return r.string(), r.id()
+}
+
+// encodeXConsumer encodes a group, a consumer and its seen time.
+func encodeXConsumer(group, consumer string, seenAt int64) []byte {
+	return appendRecordInt(appendRecordString(appendRecordString(nil, group), consumer), seenAt)
+}
+
+func decodeXConsumer(data []byte) (string, string, int64) {
+	r := &recordReader{buf: data}
+	return r.string(), r.string(), r.int64()
+}
+
+// encodeXDeliver encodes the delivery of an entry to a consumer.
+// Format: group + consumer + ID + delivery time + delivery count
+func encodeXDeliver(group, consumer string, pe store.PendingEntry) []byte {
+	buf := appendRecordString(appendRecordString(nil, group), consumer)
+	buf = appendRecordID(buf, pe.ID)
+	buf = appendRecordInt(buf, pe.DeliveredAt)
+	return appendRecordInt(buf, pe.Deliveries)
+}
+
+func decodeXDeliver(data []byte) (string, string, store.PendingEntry) {
+	r := &recordReader{buf: data}
+	group, consumer := r.string(), r.string()
+	pe := store.PendingEntry{ID: r.id(), Consumer: consumer}
+	pe.DeliveredAt = r.int64()
+	pe.Deliveries = r.int64()
+	return group, consumer, pe
+}
+
+// applyStream replays a stream WAL record.
+func (e *Engine) applyStream(rec wal.Record) {
+	key := string(rec.Key)
+	switch rec.Type {
+	case wal.OpXAdd:
+		id, fields := decodeXAdd(rec.Value)
+		e.store.XAdd(key, id, fields)
+	case wal.OpXDel:
+		e.store.XDel(key, (&recordReader{buf: rec.Value}).id())
+	case wal.OpXTrim:
+		if trim := decodeXTrim(rec.Value); trim.ByMinID {
+			e.store.XTrimMinID(key, trim.MinID)
+		} else {
+			e.store.XTrimMaxLen(key, trim.MaxLen)
+		}
+	case wal.OpXSetID:
+		e.store.XSetID(key, (&recordReader{buf: rec.Value}).id())
+	case wal.OpXGroupCreate:
+		group, id := decodeXGroupID(rec.Value)
+		e.store.XGroupCreate(key, group, id, true)
+	case wal.OpXGroupDestroy:
+		e.store.XGroupDestroy(key, string(rec.Value))
+	case wal.OpXGroupSetID:
+		group, id := decodeXGroupID(rec.Value)
+		e.store.XGroupSetID(key, group, id)
+	case wal.OpXConsumerCreate:
+		group, consumer, seenAt := decodeXConsumer(rec.Value)
+		e.store.XGroupCreateConsumer(key, group, consumer, seenAt)
+	case wal.OpXConsumerDel:
+		group, consumer, _ := decodeXConsumer(rec.Value)
+		e.store.XGroupDelConsumer(key, group, consumer)
+	case wal.OpXDeliver:
+		group, consumer, pe := decodeXDeliver(rec.Value)
+		e.store.XDeliver(key, group, consumer, pe.ID, pe.DeliveredAt, pe.Deliveries)
+	case wal.OpXAck:
+		group, id := decodeXGroupID(rec.Value)
+		e.store.XAck(key, group, id)
+	}
+}
+
+// ========================
+// Stream Operations
+// ========================
+
+// nextStreamID returns the ID for a new entry of a stream whose highest ID
+// so far is last.
+func nextStreamID(last store.StreamID, want XAddID) (store.StreamID, error) {
+	switch {
+	case want.Auto:
+		if ms := uint64(time.Now().UnixMilli()); last.Ms < ms {
+			return store.StreamID{Ms: ms}, nil
+		}
+		id, ok := last.Next()
+		if !ok {
+			return store.StreamID{}, store.ErrStreamExhausted
+		}
+		return id, nil
+	case want.SeqAuto:
+		switch {
+		case want.ID.Ms < last.Ms:
+			return store.StreamID{}, store.ErrStreamIDTooSmall
+		case want.ID.Ms > last.Ms:
+			return store.StreamID{Ms: want.ID.Ms}, nil
+		}
+		id, ok := last.Next()
+		if !ok || id.Ms != want.ID.Ms {
+			return store.StreamID{}, store.ErrStreamIDTooSmall
+		}
+		return id, nil
+	}
+	if want.ID == (store.StreamID{}) {
+		return store.StreamID{}, store.ErrStreamIDZero
+	}
+	if !last.Less(want.ID) {
+		return store.StreamID{}, store.ErrStreamIDTooSmall
+	}
+	return want.ID, nil
+}
+
+// XAdd appends an entry to a stream and then applies trim, if given. It
+// returns the new entry's ID, or false if the stream does not exist and
+// noMkStream is set.
+func (e *Engine) XAdd(key string, want XAddID, fields [][]byte, noMkStream bool, trim *StreamTrim) (_ store.StreamID, _ bool, err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.checkType(key, store.TypeStream); err != nil {
+		e.recordCommand()
+		return store.StreamID{}, false, err
+	}
+	last, exists, _ := e.store.XLastID(key)
+	if !exists && noMkStream {
+		e.recordCommand()
+		return store.StreamID{}, false, nil
+	}
+	id, err := nextStreamID(last, want)
+	if err != nil {
+		e.recordCommand()
+		return store.StreamID{}, false, err
+	}
+
+	records := []wal.Record{{Type: wal.OpXAdd, Key: []byte(key), Value: encodeXAdd(id, fields)}}
+	if trim != nil {
+		records = append(records, wal.Record{Type: wal.OpXTrim, Key: []byte(key), Value: encodeXTrim(*trim)})
+	}
+	if err := e.writeWAL(records...); err != nil {
+		return store.StreamID{}, false, fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+
+	n, _ := e.store.XLen(key)
+	for _, rec := range records {
+		e.apply(rec)
+	}
+	e.keyReady(key)
+	e.notify(NotifyStream, "xadd", key)
+	if after, _ := e.store.XLen(key); after <= n {
+		e.notify(NotifyStream, "xtrim", key)
+	}
+	e.recordWrite()
+	return id, true, nil
+}
+
+// XTrim trims a stream. Returns the number of entries removed.
+func (e *Engine) XTrim(key string, trim StreamTrim) (_ int, err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.checkType(key, store.TypeStream); err != nil {
+		e.recordCommand()
+		return 0, err
+	}
+	n, _ := e.store.XLen(key)
+	if n == 0 {
+		e.recordCommand()
+		return 0, nil
+	}
+
+	rec := wal.Record{Type: wal.OpXTrim, Key: []byte(key), Value: encodeXTrim(trim)}
+	if err := e.writeWAL(rec); err != nil {
+		return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+
+	var removed int
+	if trim.ByMinID {
+		removed, _ = e.store.XTrimMinID(key, trim.MinID)
+	} else {
+		removed, _ = e.store.XTrimMaxLen(key, trim.MaxLen)
+	}
+	if removed > 0 {
+		e.notify(NotifyStream, "xtrim", key)
+	}
+	e.recordWrite()
+	return removed, nil
+}
+
+// XDel removes entries from a stream. Returns the number removed.
+func (e *Engine) XDel(key string, ids ...store.StreamID) (_ int, err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.checkType(key, store.TypeStream); err != nil {
+		e.recordCommand()
+		return 0, err
+	}
+	var records []wal.Record
+	for _, id := range ids {
+		if _, ok, _ := e.store.XEntry(key, id); ok {
+			records = append(records, wal.Record{Type: wal.OpXDel, Key: []byte(key), Value: appendRecordID(nil, id)})
+		}
+	}
+	if len(records) == 0 {
+		e.recordCommand()
+		return 0, nil
+	}
+	if err := e.writeWAL(records...); err != nil {
+		return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+
+	removed, _ := e.store.XDel(key, ids...)
+	e.notify(NotifyStream, "xdel", key)
+	e.recordWrite()
+	return removed, nil
+}
+
+// XSetID sets the highest ID added to a stream. It returns false if the
+// stream does not exist.
+func (e *Engine) XSetID(key string, id store.StreamID) (_ bool, err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.checkType(key, store.TypeStream); err != nil {
+		e.recordCommand()
+		return false, err
+	}
+	if _, exists, _ := e.store.XLastID(key); !exists {
+		e.recordCommand()
+		return false, nil
+	}
+	if top, _ := e.store.XRange(key, store.StreamID{}, store.MaxStreamID, 1, true); len(top) > 0 && id.Less(top[0].ID) {
+		e.recordCommand()
+		return false, store.ErrStreamSetIDTooSmall
+	}
+
+	rec := wal.Record{Type: wal.OpXSetID, Key: []byte(key), Value: appendRecordID(nil, id)}
+	if err := e.writeWAL(rec); err != nil {
+		return false, fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+
+	e.apply(rec)
+	e.notify(NotifyStream, "xsetid", key)
+	e.recordWrite()
+	return true, nil
+}
+
+// XLen returns the number of entries in a stream.
+func (e *Engine) XLen(key string) (int, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	e.recordRead()
+	return e.store.XLen(key)
+}
+
+// XLastID returns the highest ID added to a stream, and whether it exists.
+func (e *Engine) XLastID(key string) (store.StreamID, bool, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	e.recordRead()
+	return e.store.XLastID(key)
+}
+
+// XRange returns up to count entries (0 = all) with IDs in [start, end],
+// in descending order if rev is set.
+func (e *Engine) XRange(key string, start, end store.StreamID, count int, rev bool) ([]store.StreamEntry, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	e.recordRead()
+	return e.store.XRange(key, start, end, count, rev)
+}
+
+// XRead returns up to count entries (0 = all) after the cursor's ID from
+// each stream, leaving out streams without such entries.
+func (e *Engine) XRead(cursors []StreamCursor, count int) ([]StreamRead, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	e.recordRead()
+
+	var reads []StreamRead
+	for _, c := range cursors {
+		entries, err := e.store.XRead(c.Key, c.ID, count)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) > 0 {
+			reads = append(reads, StreamRead{Key: c.Key, Entries: entries})
+		}
+	}
+	return reads, nil
+}
+
+// XReadGroup reads streams as a consumer of a group, creating the consumer
+// if needed. New cursors deliver up to count entries the group has not seen
+// yet and leave out streams without any; unless noAck is set, the entries
+// become pending for the consumer. Other cursors return the consumer's
+// pending entries after their ID, counting a new delivery for each; entries
+// deleted since have nil Fields.
+func (e *Engine) XReadGroup(group, consumer string, cursors []StreamCursor, count int, noAck bool) (_ []StreamRead, err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, c := range cursors {
+		if err := e.checkType(c.Key, store.TypeStream); err != nil {
+			e.recordCommand()
+			return nil, err
+		}
+		if _, err := e.store.XGroupLastID(c.Key, group); err != nil {
+			e.recordCommand()
+			return nil, err
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	var records []wal.Record
+	var reads []StreamRead
+	for _, c := range cursors {
+		key := []byte(c.Key)
+		if ok, _ := e.store.XHasConsumer(c.Key, group, consumer); !ok {
+			records = append(records, wal.Record{Type: wal.OpXConsumerCreate, Key: key, Value: encodeXConsumer(group, consumer, now)})
+		}
+
+		if c.New {
+			lastID, _ := e.store.XGroupLastID(c.Key, group)
+			entries, _ := e.store.XRead(c.Key, lastID, count)
+			if len(entries) == 0 {
+				continue
+			}
+			if !noAck {
+				for _, entry := range entries {
+					pe := store.PendingEntry{ID: entry.ID, DeliveredAt: now, Deliveries: 1}
+					records = append(records, wal.Record{Type: wal.OpXDeliver, Key: key, Value: encodeXDeliver(group, consumer, pe)})
+				}
+			}
+			lastID = entries[len(entries)-1].ID
+			records = append(records, wal.Record{Type: wal.OpXGroupSetID, Key: key, Value: encodeXGroupID(group, lastID)})
+			reads = append(reads, StreamRead{Key: c.Key, Entries: entries})
+			continue
+		}
+
+		entries := []store.StreamEntry{}
+		if from, ok := c.ID.Next(); ok {
+			pending, _ := e.store.XPendingRange(c.Key, group, from, store.MaxStreamID, count, consumer, 0)
+			for _, pe := range pending {
+				entry, ok, _ := e.store.XEntry(c.Key, pe.ID)
+				if !ok {
+					entries = append(entries, store.StreamEntry{ID: pe.ID})
+					continue
+				}
+				pe.DeliveredAt, pe.Deliveries = now, pe.Deliveries+1
+				records = append(records, wal.Record{Type: wal.OpXDeliver, Key: key, Value: encodeXDeliver(group, consumer, pe)})
+				entries = append(entries, entry)
+			}
+		}
+		reads = append(reads, StreamRead{Key: c.Key, Entries: entries})
+	}
+
+	if len(records) == 0 {
+		e.recordCommand()
+		return reads, nil
+	}
+	if err := e.writeWAL(records...); err != nil {
+		return nil, fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+	for _, rec := range records {
+		e.apply(rec)
+		if rec.Type == wal.OpXConsumerCreate {
+			e.notify(NotifyStream, "xgroup-createconsumer", string(rec.Key))
+		}
+	}
+	e.recordWrite()
+	return reads, nil
+}
+
+// XAck acknowledges pending entries of a consumer group. Returns the number
+// that were pending; a missing stream or group has none.
+func (e *Engine) XAck(key, group string, ids ...store.StreamID) (_ int, err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.checkType(key, store.TypeStream); err != nil {
+		e.recordCommand()
+		return 0, err
+	}
+	var records []wal.Record
+	for _, id := range ids {
+		if _, ok, _ := e.store.XPending(key, group, id); ok {
+			records = append(records, wal.Record{Type: wal.OpXAck, Key: []byte(key), Value: encodeXGroupID(group, id)})
+		}
+	}
+	if len(records) == 0 {
+		e.recordCommand()
+		return 0, nil
+	}
+	if err := e.writeWAL(records...); err != nil {
+		return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+
+	acked, _ := e.store.XAck(key, group, ids...)
+	e.recordWrite()
+	return acked, nil
+}
+
+// XPendingSummary summarizes the pending entries of a consumer group.
+func (e *Engine) XPendingSummary(key, group string) (store.PendingSummary, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	e.recordRead()
+	return e.store.XPendingSummary(key, group)
+}
+
+// XPendingRange returns up to count pending entries of a consumer group
+// with IDs in [start, end], restricted to one consumer unless consumer is
+// empty and to entries idle for at least minIdle.
+func (e *Engine) XPendingRange(key, group string, start, end store.StreamID, count int, consumer string, minIdle time.Duration) ([]store.PendingEntry, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	e.recordRead()
+
+	var deliveredBy int64
+	if minIdle > 0 {
+		deliveredBy = time.Now().Add(-minIdle).UnixMilli()
+	}
+	return e.store.XPendingRange(key, group, start, end, count, consumer, deliveredBy)
+}
+
+// XClaim transfers pending entries that have been idle for at least
+// minIdle to consumer and returns them. Pending entries that were deleted
+// from the stream are acknowledged instead. With JustID only the IDs of
+// the returned entries are set.
+func (e *Engine) XClaim(key, group, consumer string, minIdle time.Duration, ids []store.StreamID, opts XClaimOptions) (_ []store.StreamEntry, err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.checkType(key, store.TypeStream); err != nil {
+		e.recordCommand()
+		return nil, err
+	}
+	lastID, err := e.store.XGroupLastID(key, group)
+	if err != nil {
+		e.recordCommand()
+		return nil, err
+	}
+
+	now := time.Now().UnixMilli()
+	deliveredAt := now
+	if opts.DeliveredAt != 0 {
+		deliveredAt = opts.DeliveredAt
+	}
+	var records []wal.Record
+	if ok, _ := e.store.XHasConsumer(key, group, consumer); !ok {
+		records = append(records, wal.Record{Type: wal.OpXConsumerCreate, Key: []byte(key), Value: encodeXConsumer(group, consumer, now)})
+	}
+	if lastID.Less(opts.LastID) {
+		records = append(records, wal.Record{Type: wal.OpXGroupSetID, Key: []byte(key), Value: encodeXGroupID(group, opts.LastID)})
+	}
+
+	var claimed []store.StreamEntry
+	for _, id := range ids {
+		pe, pending, _ := e.store.XPending(key, group, id)
+		entry, exists, _ := e.store.XEntry(key, id)
+		switch {
+		case pending && !exists:
+			records = append(records, wal.Record{Type: wal.OpXAck, Key: []byte(key), Value: encodeXGroupID(group, id)})
+			continue
+		case !pending && !(opts.Force && exists):
+			continue
+		case pending && minIdle > 0 && now-pe.DeliveredAt < minIdle.Milliseconds():
+			continue
+		}
+
+		deliveries := pe.Deliveries
+		if !opts.JustID {
+			deliveries++
+		}
+		if opts.HasRetryCount {
+			deliveries = opts.RetryCount
+		}
+		claim := store.PendingEntry{ID: id, DeliveredAt: deliveredAt, Deliveries: deliveries}
+		records = append(records, wal.Record{Type: wal.OpXDeliver, Key: []byte(key), Value: encodeXDeliver(group, consumer, claim)})
+		if opts.JustID {
+			entry = store.StreamEntry{ID: id}
+		}
+		claimed = append(claimed, entry)
+	}
+
+	if len(records) == 0 {
+		e.recordCommand()
+		return claimed, nil
+	}
+	if err := e.writeWAL(records...); err != nil {
+		return nil, fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+	for _, rec := range records {
+		e.apply(rec)
+		if rec.Type == wal.OpXConsumerCreate {
+			e.notify(NotifyStream, "xgroup-createconsumer", string(rec.Key))
+		}
+	}
+	e.recordWrite()
+	return claimed, nil
+}
+
+// XGroupCreate creates a consumer group that has been delivered everything
+// up to id, or up to the last entry if fromLast is set. With mkstream, a
+// missing stream is created empty.
+func (e *Engine) XGroupCreate(key, group string, id store.StreamID, fromLast, mkstream bool) (err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.checkType(key, store.TypeStream); err != nil {
+		e.recordCommand()
+		return err
+	}
+	last, exists, _ := e.store.XLastID(key)
+	if !exists && !mkstream {
+		e.recordCommand()
+		return store.ErrNoStream
+	}
+	if _, err := e.store.XGroupLastID(key, group); err == nil {
+		e.recordCommand()
+		return store.ErrBusyGroup
+	}
+	if fromLast {
+		id = last
+	}
+
+	rec := wal.Record{Type: wal.OpXGroupCreate, Key: []byte(key), Value: encodeXGroupID(group, id)}
+	if err := e.writeWAL(rec); err != nil {
+		return fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+
+	e.apply(rec)
+	e.notify(NotifyStream, "xgroup-create", key)
+	e.recordWrite()
+	return nil
+}
+
+// checkGroup expires key if it is due and then returns store.ErrNoStream
+// if it holds no stream and store.ErrNoGroup if the stream has no such
+// consumer group (must hold e.mu).
+func (e *Engine) checkGroup(key, group string) error {
+	if err := e.checkType(key, store.TypeStream); err != nil {
+		return err
+	}
+	if _, exists, _ := e.store.XLastID(key); !exists {
+		return store.ErrNoStream
+	}
+	_, err := e.store.XGroupLastID(key, group)
+	return err
+}
+
+// XGroupDestroy removes a consumer group. Returns false if it did not exist.
+func (e *Engine) XGroupDestroy(key, group string) (_ bool, err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.checkGroup(key, group); err != nil {
+		e.recordCommand()
+		if err == store.ErrNoGroup {
+			return false, nil
+		}
+		return false, err
+	}
+
+	rec := wal.Record{Type: wal.OpXGroupDestroy, Key: []byte(key), Value: []byte(group)}
+	if err := e.writeWAL(rec); err != nil {
+		return false, fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+
+	e.apply(rec)
+	e.notify(NotifyStream, "xgroup-destroy", key)
+	e.recordWrite()
+	return true, nil
+}
+
+// XGroupSetID sets the last ID delivered to a consumer group, or the
+// stream's last ID if fromLast is set.
+func (e *Engine) XGroupSetID(key, group string, id store.StreamID, fromLast bool) (err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.checkGroup(key, group); err != nil {
+		e.recordCommand()
+		return err
+	}
+	if fromLast {
+		id, _, _ = e.store.XLastID(key)
+	}
+
+	rec := wal.Record{Type: wal.OpXGroupSetID, Key: []byte(key), Value: encodeXGroupID(group, id)}
+	if err := e.writeWAL(rec); err != nil {
+		return fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+
+	e.apply(rec)
+	e.notify(NotifyStream, "xgroup-setid", key)
+	e.recordWrite()
+	return nil
+}
+
+// XGroupCreateConsumer adds a consumer to a group. Returns false if it
+// already existed.
+func (e *Engine) XGroupCreateConsumer(key, group, consumer string) (_ bool, err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.checkGroup(key, group); err != nil {
+		e.recordCommand()
+		return false, err
+	}
+	if ok, _ := e.store.XHasConsumer(key, group, consumer); ok {
+		e.recordCommand()
+		return false, nil
+	}
+
+	rec := wal.Record{Type: wal.OpXConsumerCreate, Key: []byte(key), Value: encodeXConsumer(group, consumer, time.Now().UnixMilli())}
+	if err := e.writeWAL(rec); err != nil {
+		return false, fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+
+	e.apply(rec)
+	e.notify(NotifyStream, "xgroup-createconsumer", key)
+	e.recordWrite()
+	return true, nil
+}
+
+// XGroupDelConsumer removes a consumer and its pending entries from a
+// group. Returns the number of pending entries it had.
+func (e *Engine) XGroupDelConsumer(key, group, consumer string) (_ int, err error) {
+	defer e.commit(&err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.checkGroup(key, group); err != nil {
+		e.recordCommand()
+		return 0, err
+	}
+	if ok, _ := e.store.XHasConsumer(key, group, consumer); !ok {
+		e.recordCommand()
+		return 0, nil
+	}
+
+	rec := wal.Record{Type: wal.OpXConsumerDel, Key: []byte(key), Value: encodeXConsumer(group, consumer, 0)}
+	if err := e.writeWAL(rec); err != nil {
+		return 0, fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+
+	pending, _ := e.store.XGroupDelConsumer(key, group, consumer)
+	e.notify(NotifyStream, "xgroup-delconsumer", key)
+	e.recordWrite()
+	return pending, nil
+}
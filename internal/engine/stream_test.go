@@ -0,0 +1,259 @@
+package engine
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flashdb/flashdb/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fieldBytes(kv ...string) [][]byte {
+	fields := make([][]byte, len(kv))
+	for i, s := range kv {
+		fields[i] = []byte(s)
+	}
+	return fields
+}
+
+// streamState returns the full contents of the stream at key.
+func streamState(t *testing.T, e *Engine, key string) store.StreamData {
+	t.Helper()
+	item, ok := e.store.Item(key)
+	require.True(t, ok, key)
+	require.NotNil(t, item.Stream, key)
+	return *item.Stream
+}
+
+func TestEngine_XAdd(t *testing.T) {
+	e, err := New(filepath.Join(t.TempDir(), "test.wal"))
+	require.NoError(t, err)
+	defer e.Close()
+
+	id, ok, err := e.XAdd("s", XAddID{ID: store.StreamID{Ms: 5, Seq: 1}}, fieldBytes("a", "1"), false, nil)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, store.StreamID{Ms: 5, Seq: 1}, id)
+
+	id, _, err = e.XAdd("s", XAddID{ID: store.StreamID{Ms: 5}, SeqAuto: true}, fieldBytes("a", "2"), false, nil)
+	require.NoError(t, err)
+	assert.Equal(t, store.StreamID{Ms: 5, Seq: 2}, id)
+
+	_, _, err = e.XAdd("s", XAddID{ID: store.StreamID{Ms: 5, Seq: 2}}, fieldBytes("a", "3"), false, nil)
+	assert.ErrorIs(t, err, store.ErrStreamIDTooSmall)
+	_, _, err = e.XAdd("s", XAddID{ID: store.StreamID{Ms: 4}, SeqAuto: true}, fieldBytes("a", "3"), false, nil)
+	assert.ErrorIs(t, err, store.ErrStreamIDTooSmall)
+	_, _, err = e.XAdd("new", XAddID{}, fieldBytes("a", "3"), false, nil)
+	assert.ErrorIs(t, err, store.ErrStreamIDZero)
+
+	id, _, err = e.XAdd("zero", XAddID{SeqAuto: true}, fieldBytes("a", "1"), false, nil)
+	require.NoError(t, err)
+	assert.Equal(t, store.StreamID{Seq: 1}, id)
+
+	before := uint64(time.Now().UnixMilli())
+	id, _, err = e.XAdd("s", XAddID{Auto: true}, fieldBytes("a", "4"), false, nil)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, id.Ms, before)
+
+	// The clock never moves IDs backwards.
+	_, err = e.XSetID("s", store.StreamID{Ms: 1 << 60})
+	require.NoError(t, err)
+	id, _, err = e.XAdd("s", XAddID{Auto: true}, fieldBytes("a", "5"), false, nil)
+	require.NoError(t, err)
+	assert.Equal(t, store.StreamID{Ms: 1 << 60, Seq: 1}, id)
+	_, err = e.XSetID("s", store.StreamID{Ms: 1})
+	assert.ErrorIs(t, err, store.ErrStreamSetIDTooSmall)
+	ok, err = e.XSetID("missing", store.StreamID{Ms: 1})
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, ok, err = e.XAdd("missing", XAddID{Auto: true}, fieldBytes("a", "1"), true, nil)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.False(t, e.Exists("missing"))
+
+	_, _, err = e.XAdd("s", XAddID{Auto: true}, fieldBytes("a", "6"), false, &StreamTrim{MaxLen: 2})
+	require.NoError(t, err)
+	n, _ := e.XLen("s")
+	assert.Equal(t, 2, n)
+
+	removed, err := e.XTrim("s", StreamTrim{ByMinID: true, MinID: store.StreamID{Ms: 1 << 60, Seq: 2}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	removed, err = e.XDel("s", store.StreamID{Ms: 1 << 60, Seq: 2}, store.StreamID{Ms: 9})
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	n, _ = e.XLen("s")
+	assert.Equal(t, 0, n)
+	assert.True(t, e.Exists("s"), "streams persist once empty")
+
+	require.NoError(t, e.Set("str", []byte("x")))
+	_, _, err = e.XAdd("str", XAddID{Auto: true}, fieldBytes("a", "1"), false, nil)
+	assert.ErrorIs(t, err, store.ErrWrongType)
+	_, err = e.XRead([]StreamCursor{{Key: "str"}}, 0)
+	assert.ErrorIs(t, err, store.ErrWrongType)
+}
+
+func TestEngine_StreamConsumerGroups(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+
+	var ready []string
+	e.OnKeyReady(func(_ int, key string) { ready = append(ready, key) })
+
+	for i := uint64(1); i <= 4; i++ {
+		_, _, err := e.XAdd("s", XAddID{ID: store.StreamID{Ms: i}}, fieldBytes("n", "v"), false, nil)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"s", "s", "s", "s"}, ready)
+
+	assert.ErrorIs(t, e.XGroupCreate("missing", "g", store.StreamID{}, false, false), store.ErrNoStream)
+	require.NoError(t, e.XGroupCreate("s", "g", store.StreamID{}, false, false))
+	assert.ErrorIs(t, e.XGroupCreate("s", "g", store.StreamID{}, false, false), store.ErrBusyGroup)
+	require.NoError(t, e.XGroupCreate("s", "tail", store.StreamID{}, true, false))
+
+	_, err = e.XReadGroup("nogroup", "c", []StreamCursor{{Key: "s", New: true}}, 0, false)
+	assert.ErrorIs(t, err, store.ErrNoGroup)
+
+	reads, err := e.XReadGroup("g", "alice", []StreamCursor{{Key: "s", New: true}}, 3, false)
+	require.NoError(t, err)
+	require.Len(t, reads, 1)
+	assert.Len(t, reads[0].Entries, 3)
+	reads, err = e.XReadGroup("g", "bob", []StreamCursor{{Key: "s", New: true}}, 0, false)
+	require.NoError(t, err)
+	require.Len(t, reads, 1)
+	assert.Equal(t, store.StreamID{Ms: 4}, reads[0].Entries[0].ID)
+	reads, err = e.XReadGroup("g", "bob", []StreamCursor{{Key: "s", New: true}}, 0, false)
+	require.NoError(t, err)
+	assert.Empty(t, reads)
+	reads, err = e.XReadGroup("tail", "carol", []StreamCursor{{Key: "s", New: true}}, 0, true)
+	require.NoError(t, err)
+	assert.Empty(t, reads)
+
+	// History reads return the consumer's pending entries and count a
+	// delivery; deleted entries come back without fields.
+	_, err = e.XDel("s", store.StreamID{Ms: 2})
+	require.NoError(t, err)
+	reads, err = e.XReadGroup("g", "alice", []StreamCursor{{Key: "s"}}, 0, false)
+	require.NoError(t, err)
+	require.Len(t, reads, 1)
+	require.Len(t, reads[0].Entries, 3)
+	assert.Nil(t, reads[0].Entries[1].Fields)
+	pending, err := e.XPendingRange("s", "g", store.StreamID{}, store.MaxStreamID, 0, "alice", 0)
+	require.NoError(t, err)
+	require.Len(t, pending, 3)
+	assert.Equal(t, int64(2), pending[0].Deliveries)
+	assert.Equal(t, int64(1), pending[1].Deliveries)
+
+	acked, err := e.XAck("s", "g", store.StreamID{Ms: 1}, store.StreamID{Ms: 9})
+	require.NoError(t, err)
+	assert.Equal(t, 1, acked)
+	acked, err = e.XAck("s", "nogroup", store.StreamID{Ms: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 0, acked)
+
+	// Claiming: bob's entry is not idle yet, alice's deleted entry is
+	// acknowledged, and FORCE takes an entry nobody was given.
+	claimed, err := e.XClaim("s", "g", "carol", time.Hour, []store.StreamID{{Ms: 2}, {Ms: 4}}, XClaimOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, claimed)
+	_, ok, _ := e.store.XPending("s", "g", store.StreamID{Ms: 2})
+	assert.False(t, ok)
+
+	old := time.Now().Add(-2 * time.Hour).UnixMilli()
+	claimed, err = e.XClaim("s", "g", "bob", 0, []store.StreamID{{Ms: 3}}, XClaimOptions{DeliveredAt: old})
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	claimed, err = e.XClaim("s", "g", "carol", time.Hour, []store.StreamID{{Ms: 3}}, XClaimOptions{JustID: true, LastID: store.StreamID{Ms: 10}})
+	require.NoError(t, err)
+	assert.Equal(t, []store.StreamEntry{{ID: store.StreamID{Ms: 3}}}, claimed)
+	pe, _, _ := e.store.XPending("s", "g", store.StreamID{Ms: 3})
+	assert.Equal(t, "carol", pe.Consumer)
+	assert.Equal(t, int64(3), pe.Deliveries, "JUSTID does not count a delivery")
+
+	claimed, err = e.XClaim("s", "tail", "dave", 0, []store.StreamID{{Ms: 1}, {Ms: 4}}, XClaimOptions{Force: true, HasRetryCount: true, RetryCount: 7})
+	require.NoError(t, err)
+	require.Len(t, claimed, 2)
+	pe, _, _ = e.store.XPending("s", "tail", store.StreamID{Ms: 4})
+	assert.Equal(t, int64(7), pe.Deliveries)
+
+	summary, err := e.XPendingSummary("s", "g")
+	require.NoError(t, err)
+	assert.Equal(t, 2, summary.Count)
+	assert.Equal(t, []store.ConsumerPending{{Name: "bob", Pending: 1}, {Name: "carol", Pending: 1}}, summary.Consumers)
+	idle, err := e.XPendingRange("s", "g", store.StreamID{}, store.MaxStreamID, 0, "", time.Hour)
+	require.NoError(t, err)
+	assert.Empty(t, idle)
+
+	created, err := e.XGroupCreateConsumer("s", "g", "erin")
+	require.NoError(t, err)
+	assert.True(t, created)
+	n, err := e.XGroupDelConsumer("s", "g", "bob")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	_, err = e.XGroupCreateConsumer("s", "nogroup", "x")
+	assert.ErrorIs(t, err, store.ErrNoGroup)
+	destroyed, err := e.XGroupDestroy("s", "tail")
+	require.NoError(t, err)
+	assert.True(t, destroyed)
+	require.NoError(t, e.XGroupSetID("s", "g", store.StreamID{}, true))
+
+	want := streamState(t, e, "s")
+	require.NoError(t, e.Close())
+
+	e2, err := New(walPath)
+	require.NoError(t, err)
+	defer e2.Close()
+	assert.Equal(t, want, streamState(t, e2, "s"))
+}
+
+func TestEngine_StreamPersistence(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+
+	for i := uint64(1); i <= 3; i++ {
+		_, _, err := e.XAdd("s", XAddID{ID: store.StreamID{Ms: i}}, fieldBytes("n", "v", "m", ""), false, nil)
+		require.NoError(t, err)
+	}
+	_, err = e.XDel("s", store.StreamID{Ms: 3})
+	require.NoError(t, err)
+	require.NoError(t, e.XGroupCreate("s", "g", store.StreamID{}, false, false))
+	_, err = e.XReadGroup("g", "alice", []StreamCursor{{Key: "s", New: true}}, 1, false)
+	require.NoError(t, err)
+	_, err = e.XGroupCreateConsumer("s", "g", "idle")
+	require.NoError(t, err)
+	require.NoError(t, e.XGroupCreate("empty", "g", store.StreamID{}, false, true))
+	_, err = e.Expire("empty", time.Hour)
+	require.NoError(t, err)
+	want := streamState(t, e, "s")
+
+	_, err = e.SnapshotCreate("streams")
+	require.NoError(t, err)
+	_, _, err = e.XAdd("s", XAddID{Auto: true}, fieldBytes("n", "v"), false, nil)
+	require.NoError(t, err)
+	_, err = e.Delete("empty")
+	require.NoError(t, err)
+	require.NoError(t, e.SnapshotRestore("streams"))
+	assert.Equal(t, want, streamState(t, e, "s"))
+	assert.Greater(t, e.TTL("empty"), int64(3500))
+
+	payload, ok, err := e.Dump("s")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NoError(t, e.Restore("copy", payload, 0, false))
+	assert.Equal(t, want, streamState(t, e, "copy"))
+
+	require.NoError(t, e.Rewrite())
+	require.NoError(t, e.Close())
+
+	e2, err := New(walPath)
+	require.NoError(t, err)
+	defer e2.Close()
+	assert.Equal(t, want, streamState(t, e2, "s"))
+	assert.Equal(t, want, streamState(t, e2, "copy"))
+	assert.True(t, e2.Exists("empty"))
+}
@@ -0,0 +1,105 @@
+package engine
+
+import "fmt"
+
+// rwLocker is the lock an Engine takes around its operations.
+type rwLocker interface {
+	Lock()
+	Unlock()
+	RLock()
+	RUnlock()
+}
+
+// noLock is the lock of the views of a transaction: the transaction holds
+// the core's lock already.
+type noLock struct{}
+
+func (noLock) Lock()    {}
+func (noLock) Unlock()  {}
+func (noLock) RLock()   {}
+func (noLock) RUnlock() {}
+
+// Tx is a transaction: a series of operations that no other client sees
+// half done and that reaches the WAL as a whole or not at all.
+type Tx struct {
+	e         *Engine
+	abandoned bool
+}
+
+// Begin starts a transaction. Until Commit, every other operation on the
+// engine waits, and the operations of the transaction must go through the
+// views returned by Tx.DB. Their WAL records are held back and written
+// together by Commit, so recovery replays all of them or none.
+func (e *Engine) Begin() *Tx {
+	e.mu.Lock()
+	e.inTx = true
+	return &Tx{e: e.dbs[0]}
+}
+
+// DB returns the view of database n for the transaction. Take a new one
+// for each operation: SwapDB changes what a database holds.
+func (tx *Tx) DB(n int) (*Engine, error) {
+	if err := tx.e.checkDB(n); err != nil {
+		return nil, err
+	}
+	db := *tx.e.dbs[n]
+	db.mu = noLock{}
+	return &db, nil
+}
+
+// Commit writes the records of the transaction to the WAL, with markers
+// that make recovery skip them unless all of them are on disk, and lets
+// other operations run again. If the write fails, the changes stay in
+// memory but will not survive a restart.
+func (tx *Tx) Commit() (err error) {
+	e := tx.e
+	if tx.abandoned {
+		return ErrClosed
+	}
+	defer e.commit(&err)
+	defer e.mu.Unlock()
+
+	records, clearEnd := e.txRecords, e.txClearEnd
+	e.inTx, e.txRecords, e.txClearEnd = false, nil, 0
+	switch len(records) {
+	case 0:
+		return nil
+	case clearEnd:
+		// The transaction ends with everything flushed: nothing before it
+		// is needed any more.
+		if err := e.wal.Clear(); err != nil {
+			return fmt.Errorf("engine: failed to clear WAL: %w", err)
+		}
+		e.walCheckpoint = ""
+		return nil
+	case 1:
+		// A single record is atomic on its own.
+		err = e.wal.Write(records...)
+	default:
+		err = e.wal.WriteTx(records...)
+	}
+	if err != nil {
+		return fmt.Errorf("engine: failed to write WAL: %w", err)
+	}
+	return nil
+}
+
+// Abandon ends the transaction without writing its records and closes the
+// engine, for shutting down while the transaction cannot finish. Its
+// changes stay in memory, but nothing written after them reaches the WAL,
+// so recovery comes back without them. Commit then returns ErrClosed.
+func (tx *Tx) Abandon() error {
+	e := tx.e
+	if tx.abandoned {
+		return nil
+	}
+	tx.abandoned = true
+	e.inTx, e.txRecords, e.txClearEnd = false, nil, 0
+	closed := e.closed
+	e.closed = true
+	e.mu.Unlock()
+	if closed {
+		return nil
+	}
+	return e.shutdown()
+}
@@ -0,0 +1,143 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func txDB(t *testing.T, tx *Tx, n int) *Engine {
+	t.Helper()
+	db, err := tx.DB(n)
+	require.NoError(t, err)
+	return db
+}
+
+func TestEngine_Tx(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+
+	require.NoError(t, e.Set("balance", []byte("100")))
+
+	tx := e.Begin()
+	_, err = txDB(t, tx, 0).IncrBy("balance", -30)
+	require.NoError(t, err)
+
+	// Other clients wait for the transaction to commit.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := e.IncrBy("balance", 1)
+		assert.NoError(t, err)
+	}()
+	select {
+	case <-done:
+		t.Fatal("write ran inside another transaction")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	_, err = txDB(t, tx, 0).IncrBy("savings", 30)
+	require.NoError(t, err)
+	require.NoError(t, txDB(t, tx, 0).SwapDB(0, 1))
+	val, _, err := txDB(t, tx, 1).Get("savings")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("30"), val)
+	_, err = tx.DB(16)
+	assert.ErrorIs(t, err, ErrDBIndex)
+	require.NoError(t, tx.Commit())
+	<-done
+
+	val, _, err = selectDB(t, e, 1).Get("balance")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("70"), val)
+	val, _, err = e.Get("balance")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1"), val)
+
+	// A transaction that did not reach disk completely is lost as a whole.
+	tx = e.Begin()
+	_, err = txDB(t, tx, 1).IncrBy("balance", -10)
+	require.NoError(t, err)
+	_, err = txDB(t, tx, 1).IncrBy("savings", 10)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+	require.NoError(t, e.Close())
+
+	info, err := os.Stat(walPath)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(walPath, info.Size()-1))
+	e, err = New(walPath)
+	require.NoError(t, err)
+	val, _, err = selectDB(t, e, 1).Get("balance")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("70"), val)
+	val, _, err = selectDB(t, e, 1).Get("savings")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("30"), val)
+
+	// Writes before a FLUSHALL in the same transaction are dropped with it,
+	// and the WAL keeps what it had until the transaction commits.
+	info, err = os.Stat(walPath)
+	require.NoError(t, err)
+	tx = e.Begin()
+	require.NoError(t, txDB(t, tx, 2).Set("gone", []byte("v")))
+	require.NoError(t, txDB(t, tx, 0).Clear())
+	require.NoError(t, txDB(t, tx, 0).Set("kept", []byte("v")))
+	after, err := os.Stat(walPath)
+	require.NoError(t, err)
+	assert.Equal(t, info.Size(), after.Size())
+	require.NoError(t, tx.Commit())
+	require.NoError(t, e.Close())
+
+	e, err = New(walPath)
+	require.NoError(t, err)
+	assert.Equal(t, 1, e.GetStats().KeysCount)
+	assert.True(t, e.Exists("kept"))
+
+	// A transaction that ends with a FLUSHALL clears the WAL.
+	tx = e.Begin()
+	require.NoError(t, txDB(t, tx, 1).Set("gone", []byte("v")))
+	require.NoError(t, txDB(t, tx, 0).Clear())
+	require.NoError(t, tx.Commit())
+	after, err = os.Stat(walPath)
+	require.NoError(t, err)
+	assert.Zero(t, after.Size())
+	require.NoError(t, e.Set("new", []byte("v")))
+	require.NoError(t, e.Close())
+
+	e, err = New(walPath)
+	require.NoError(t, err)
+	defer e.Close()
+	assert.Equal(t, 1, e.GetStats().KeysCount)
+	assert.True(t, e.Exists("new"))
+}
+
+func TestEngine_TxAbandon(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+	require.NoError(t, e.Set("kept", []byte("v")))
+
+	// Writers waiting for the transaction find the engine closed.
+	tx := e.Begin()
+	require.NoError(t, txDB(t, tx, 0).Set("lost", []byte("v")))
+	done := make(chan error, 1)
+	go func() { done <- e.Set("later", []byte("v")) }()
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, tx.Abandon())
+	assert.ErrorIs(t, <-done, ErrClosed)
+	assert.ErrorIs(t, tx.Commit(), ErrClosed)
+	require.NoError(t, e.Close())
+
+	e, err = New(walPath)
+	require.NoError(t, err)
+	defer e.Close()
+	assert.True(t, e.Exists("kept"))
+	assert.False(t, e.Exists("lost"))
+	assert.False(t, e.Exists("later"))
+}
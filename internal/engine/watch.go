@@ -0,0 +1,95 @@
+package engine
+
+// Watch is the set of keys a client watches for optimistic locking, as
+// with Redis' WATCH. It turns dirty when any of the keys is changed, by any
+// client, or expires. The zero value watches nothing.
+type Watch struct {
+	keys  []watchedKey
+	dirty bool // guarded by the engine's mu
+}
+
+// watchedKey is a key watched in one of the databases.
+type watchedKey struct {
+	db  int
+	key string
+}
+
+// Watch adds keys of the database to w. Keys that exist now make w dirty
+// if they are gone by the time it is checked, even if only their TTL
+// passed and nothing removed them yet.
+func (e *Engine) Watch(w *Watch, keys ...string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.watched == nil {
+		e.watched = make(map[watchedKey]map[*Watch]bool)
+	}
+	for _, key := range keys {
+		wk := watchedKey{db: e.db, key: key}
+		if _, ok := e.watched[wk][w]; ok {
+			continue
+		}
+		if e.watched[wk] == nil {
+			e.watched[wk] = make(map[*Watch]bool)
+		}
+		e.watched[wk][w] = e.store.Exists(key)
+		w.keys = append(w.keys, wk)
+	}
+}
+
+// Unwatch removes all the keys of w, in every database, and makes it clean
+// again.
+func (e *Engine) Unwatch(w *Watch) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, wk := range w.keys {
+		delete(e.watched[wk], w)
+		if len(e.watched[wk]) == 0 {
+			delete(e.watched, wk)
+		}
+	}
+	w.keys = nil
+	w.dirty = false
+}
+
+// WatchDirty reports whether a key of w was changed, or existed and is now
+// gone, since it was watched.
+func (e *Engine) WatchDirty(w *Watch) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if w.dirty {
+		return true
+	}
+	for _, wk := range w.keys {
+		if e.watched[wk][w] && !e.dbs[wk.db].store.Exists(wk.key) {
+			return true
+		}
+	}
+	return false
+}
+
+// touchWatched makes dirty every watch on key (must hold e.mu).
+func (e *Engine) touchWatched(key string) {
+	if len(e.watched) == 0 {
+		return
+	}
+	for w := range e.watched[watchedKey{db: e.db, key: key}] {
+		w.dirty = true
+	}
+}
+
+// touchAllWatched makes dirty every watch on a key that exists in the
+// database. FLUSHDB calls it before emptying the database, SWAPDB and
+// SnapshotRestore before and after replacing its contents (must hold e.mu).
+func (e *Engine) touchAllWatched() {
+	for wk, ws := range e.watched {
+		if wk.db != e.db || !e.store.Exists(wk.key) {
+			continue
+		}
+		for w := range ws {
+			w.dirty = true
+		}
+	}
+}
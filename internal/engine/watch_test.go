@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flashdb/flashdb/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngine_Watch(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+	e, err := New(walPath)
+	require.NoError(t, err)
+	defer e.Close()
+
+	require.NoError(t, e.Set("a", []byte("1")))
+	var w Watch
+	e.Watch(&w, "a", "b")
+	require.NoError(t, e.Set("unwatched", []byte("v")))
+	_, err = e.HDel("b", "missing")
+	require.NoError(t, err)
+	assert.False(t, e.WatchDirty(&w), "no-op writes leave watches clean")
+
+	_, err = e.HSet("b", store.HashFieldValue{Field: "f", Value: []byte("v")})
+	require.NoError(t, err)
+	assert.True(t, e.WatchDirty(&w))
+	e.Unwatch(&w)
+	assert.False(t, e.WatchDirty(&w))
+
+	// Watches are per database, and FLUSHDB and SWAPDB touch the keys
+	// they change.
+	db1 := selectDB(t, e, 1)
+	db1.Watch(&w, "a")
+	require.NoError(t, e.Set("a", []byte("2")))
+	assert.False(t, e.WatchDirty(&w))
+	require.NoError(t, db1.FlushDB())
+	assert.False(t, e.WatchDirty(&w), "flushing an empty database")
+	require.NoError(t, e.SwapDB(0, 1))
+	assert.True(t, e.WatchDirty(&w))
+	e.Unwatch(&w)
+
+	e.Watch(&w, "missing")
+	require.NoError(t, e.FlushDB())
+	assert.False(t, e.WatchDirty(&w))
+	e.Unwatch(&w)
+
+	// Restoring a snapshot touches the keys of every database, whether the
+	// snapshot has them or not.
+	require.NoError(t, e.Set("snap", []byte("v")))
+	_, err = e.SnapshotCreate("watch")
+	require.NoError(t, err)
+	e.Watch(&w, "snap")
+	db1.Watch(&w, "later")
+	require.NoError(t, e.SnapshotRestore("watch"))
+	assert.True(t, e.WatchDirty(&w))
+	e.Unwatch(&w)
+	require.NoError(t, db1.Set("later", []byte("v")))
+	db1.Watch(&w, "later")
+	require.NoError(t, e.SnapshotRestore("watch"))
+	assert.True(t, e.WatchDirty(&w))
+	e.Unwatch(&w)
+
+	// A watched key whose TTL passes is dirty before anything removes it.
+	require.NoError(t, e.SetWithTTL("short", []byte("v"), 20*time.Millisecond))
+	e.Watch(&w, "short")
+	assert.False(t, e.WatchDirty(&w))
+	time.Sleep(30 * time.Millisecond)
+	assert.True(t, e.WatchDirty(&w))
+	e.Unwatch(&w)
+	assert.Empty(t, e.watched)
+}
@@ -74,6 +74,13 @@ func (r *Reader) Buffered() int {
 	return r.rd.Buffered()
 }
 
+// Peek waits until at least one byte can be read without consuming it.
+// The server uses it to notice a blocked client disconnecting.
+func (r *Reader) Peek() error {
+	_, err := r.rd.Peek(1)
+	return err
+}
+
 // ReadValue reads a single RESP value from the reader
 func (r *Reader) ReadValue() (Value, error) {
 	typeByte, err := r.rd.ReadByte()
@@ -289,6 +296,30 @@ func (w *Writer) WriteError(msg string) error {
 	return w.flush()
 }
 
+// WriteErrorCode writes an error response with code in place of ERR, such
+// as "-WRONGTYPE ...", for errors clients tell apart by their code. An
+// empty msg writes the code alone.
+func (w *Writer) WriteErrorCode(code, msg string) error {
+	if err := w.wr.WriteByte('-'); err != nil {
+		return err
+	}
+	if _, err := w.wr.WriteString(code); err != nil {
+		return err
+	}
+	if msg != "" {
+		if err := w.wr.WriteByte(' '); err != nil {
+			return err
+		}
+		if _, err := w.wr.WriteString(msg); err != nil {
+			return err
+		}
+	}
+	if _, err := w.wr.Write(crlfBytes); err != nil {
+		return err
+	}
+	return w.flush()
+}
+
 // WriteInteger writes an integer response
 func (w *Writer) WriteInteger(n int64) error {
 	if err := w.writeTypedInt(':', n); err != nil {
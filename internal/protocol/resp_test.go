@@ -161,6 +161,19 @@ func TestWriter_Error(t *testing.T) {
 	assert.Equal(t, "-ERR unknown command\r\n", buf.String())
 }
 
+func TestWriter_ErrorCode(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+
+	err := w.WriteErrorCode("NOSCRIPT", "No matching script.")
+	require.NoError(t, err)
+	assert.Equal(t, "-NOSCRIPT No matching script.\r\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, w.WriteErrorCode("ERR", ""))
+	assert.Equal(t, "-ERR\r\n", buf.String())
+}
+
 func TestWriter_Integer(t *testing.T) {
 	var buf bytes.Buffer
 	w := NewWriter(&buf)
@@ -0,0 +1,461 @@
+// Package search maintains secondary indexes over hashes and evaluates
+// FT.SEARCH queries against them.
+package search
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	// ErrIndexExists is returned when creating an index whose name is taken.
+	ErrIndexExists = errors.New("Index already exists")
+	// ErrNoIndex is returned for an index name that does not exist.
+	ErrNoIndex = errors.New("Unknown index name")
+	// ErrInvalid matches the errors for malformed schemas, queries and
+	// options; their text says what is wrong.
+	ErrInvalid = errors.New("invalid search request")
+)
+
+// invalidError is an error matching ErrInvalid.
+type invalidError struct{ msg string }
+
+func (e invalidError) Error() string        { return e.msg }
+func (e invalidError) Is(target error) bool { return target == ErrInvalid }
+
+// invalidf returns an error matching ErrInvalid.
+func invalidf(format string, args ...any) error {
+	return invalidError{fmt.Sprintf(format, args...)}
+}
+
+// FieldType is the way a hash field is indexed.
+type FieldType uint8
+
+// Field types.
+const (
+	FieldText    FieldType = iota + 1 // words, matched case-insensitively
+	FieldTag                          // exact values split on a separator
+	FieldNumeric                      // numbers, matched by range
+)
+
+// String returns the schema name of t.
+func (t FieldType) String() string {
+	switch t {
+	case FieldText:
+		return "TEXT"
+	case FieldTag:
+		return "TAG"
+	case FieldNumeric:
+		return "NUMERIC"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// ParseFieldType returns the field type named s, in any case.
+func ParseFieldType(s string) (FieldType, bool) {
+	switch strings.ToUpper(s) {
+	case "TEXT":
+		return FieldText, true
+	case "TAG":
+		return FieldTag, true
+	case "NUMERIC":
+		return FieldNumeric, true
+	}
+	return 0, false
+}
+
+// DefaultSeparator splits the values of a TAG field.
+const DefaultSeparator = ','
+
+// Field is one hash field in an index schema.
+type Field struct {
+	Name      string
+	Type      FieldType
+	Separator byte // TAG fields only
+	Sortable  bool // reported by FT.INFO; every field can be sorted on
+}
+
+// Definition describes an index: which keys it covers and which of their
+// fields it indexes.
+type Definition struct {
+	Name     string
+	Prefixes []string // keys starting with any of these; none means every key
+	Fields   []Field
+}
+
+// Validate checks that the definition can be used to build an index.
+func (d *Definition) Validate() error {
+	if len(d.Fields) == 0 {
+		return invalidf("No fields in schema")
+	}
+	seen := make(map[string]bool, len(d.Fields))
+	for _, f := range d.Fields {
+		if seen[f.Name] {
+			return invalidf("Duplicate field in schema - %s", f.Name)
+		}
+		seen[f.Name] = true
+		if f.Type == FieldTag && (f.Separator == 0 || f.Separator == ' ') {
+			return invalidf("Invalid tag separator for field %s", f.Name)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether key is covered by the index.
+func (d *Definition) Matches(key string) bool {
+	if len(d.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range d.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// field returns the schema field called name.
+func (d *Definition) field(name string) (*Field, bool) {
+	for i := range d.Fields {
+		if d.Fields[i].Name == name {
+			return &d.Fields[i], true
+		}
+	}
+	return nil, false
+}
+
+// keySet is a set of document keys.
+type keySet map[string]struct{}
+
+// document is what an index holds about one hash.
+type document struct {
+	values map[string]string   // indexed fields, as stored
+	tokens map[string][]string // distinct terms of TEXT fields and tags of TAG fields
+	nums   map[string]float64  // values of NUMERIC fields that are numbers
+}
+
+// numEntry is one value in a numeric field's sorted list.
+type numEntry struct {
+	value float64
+	key   string
+}
+
+// less orders numeric entries by value, then key.
+func (n numEntry) less(o numEntry) bool {
+	if n.value != o.value {
+		return n.value < o.value
+	}
+	return n.key < o.key
+}
+
+// Index is an inverted index over the hashes a Definition covers. Text and
+// tag fields map each term to the keys containing it; numeric fields keep
+// their values sorted so ranges are found by binary search. An Index is not
+// safe for concurrent use.
+type Index struct {
+	def      Definition
+	docs     map[string]*document
+	postings map[string]map[string]keySet // field -> term or tag -> keys
+	nums     map[string][]numEntry        // field -> values in ascending order
+}
+
+// NewIndex returns an empty index for def, which must be valid.
+func NewIndex(def Definition) *Index {
+	ix := &Index{
+		def:      def,
+		docs:     make(map[string]*document),
+		postings: make(map[string]map[string]keySet),
+		nums:     make(map[string][]numEntry),
+	}
+	for _, f := range def.Fields {
+		if f.Type == FieldNumeric {
+			ix.nums[f.Name] = nil
+		} else {
+			ix.postings[f.Name] = make(map[string]keySet)
+		}
+	}
+	return ix
+}
+
+// Definition returns the definition the index was built from.
+func (ix *Index) Definition() Definition {
+	return ix.def
+}
+
+// Put indexes the hash at key, replacing what was indexed for it before.
+// fields holds all fields of the hash; those outside the schema are ignored.
+func (ix *Index) Put(key string, fields map[string]string) {
+	ix.Remove(key)
+
+	doc := &document{
+		values: make(map[string]string),
+		tokens: make(map[string][]string),
+		nums:   make(map[string]float64),
+	}
+	for _, f := range ix.def.Fields {
+		value, ok := fields[f.Name]
+		if !ok {
+			continue
+		}
+		doc.values[f.Name] = value
+		switch f.Type {
+		case FieldText:
+			doc.tokens[f.Name] = distinct(tokenize(value))
+		case FieldTag:
+			doc.tokens[f.Name] = distinct(splitTags(value, f.Separator))
+		case FieldNumeric:
+			n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || math.IsNaN(n) {
+				continue
+			}
+			doc.nums[f.Name] = n
+			entry := numEntry{value: n, key: key}
+			list := ix.nums[f.Name]
+			i := sort.Search(len(list), func(i int) bool { return !list[i].less(entry) })
+			list = append(list, numEntry{})
+			copy(list[i+1:], list[i:])
+			list[i] = entry
+			ix.nums[f.Name] = list
+		}
+	}
+	for field, tokens := range doc.tokens {
+		terms := ix.postings[field]
+		for _, t := range tokens {
+			keys, ok := terms[t]
+			if !ok {
+				keys = make(keySet)
+				terms[t] = keys
+			}
+			keys[key] = struct{}{}
+		}
+	}
+	ix.docs[key] = doc
+}
+
+// Remove drops the document at key from the index, if it is there.
+func (ix *Index) Remove(key string) {
+	doc, ok := ix.docs[key]
+	if !ok {
+		return
+	}
+	delete(ix.docs, key)
+	for field, tokens := range doc.tokens {
+		terms := ix.postings[field]
+		for _, t := range tokens {
+			delete(terms[t], key)
+			if len(terms[t]) == 0 {
+				delete(terms, t)
+			}
+		}
+	}
+	for field, n := range doc.nums {
+		entry := numEntry{value: n, key: key}
+		list := ix.nums[field]
+		i := sort.Search(len(list), func(i int) bool { return !list[i].less(entry) })
+		if i < len(list) && list[i] == entry {
+			ix.nums[field] = append(list[:i], list[i+1:]...)
+		}
+	}
+}
+
+// Keys returns the keys of all indexed documents.
+func (ix *Index) Keys() []string {
+	keys := make([]string, 0, len(ix.docs))
+	for key := range ix.docs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Stats describes the size of an index.
+type Stats struct {
+	Docs    int // indexed documents
+	Terms   int // distinct terms over all TEXT fields
+	Records int // term, tag and number entries over all documents
+}
+
+// Stats returns the size of the index. Documents for which keep returns
+// false are left out, as in Search; a nil keep keeps them all.
+func (ix *Index) Stats(keep func(key string) bool) Stats {
+	var st Stats
+	terms := make(map[string]map[string]bool) // TEXT field -> distinct terms
+	for key, doc := range ix.docs {
+		if keep != nil && !keep(key) {
+			continue
+		}
+		st.Docs++
+		for _, f := range ix.def.Fields {
+			switch f.Type {
+			case FieldText:
+				if terms[f.Name] == nil {
+					terms[f.Name] = make(map[string]bool)
+				}
+				for _, term := range doc.tokens[f.Name] {
+					terms[f.Name][term] = true
+				}
+				fallthrough
+			case FieldTag:
+				st.Records += len(doc.tokens[f.Name])
+			case FieldNumeric:
+				if _, ok := doc.nums[f.Name]; ok {
+					st.Records++
+				}
+			}
+		}
+	}
+	for _, ts := range terms {
+		st.Terms += len(ts)
+	}
+	return st
+}
+
+// Options control which matches Search returns and in what order.
+type Options struct {
+	SortBy string // field to order by; by key if empty
+	Desc   bool
+	Offset int
+	Limit  int
+
+	// NoContent and Return select what callers load for each match; they
+	// do not change which documents match.
+	NoContent bool
+	Return    []string
+}
+
+// DefaultLimit is the number of matches returned when no limit is given.
+const DefaultLimit = 10
+
+// Search returns the number of documents matching q and the keys of the
+// page selected by opts. Documents for which keep returns false are left
+// out, e.g. keys that expired but were not collected yet; a nil keep keeps
+// them all. Documents without the sort field come last.
+func (ix *Index) Search(q *Query, opts Options, keep func(key string) bool) (int, []string, error) {
+	var sortField *Field
+	if opts.SortBy != "" {
+		f, ok := ix.def.field(opts.SortBy)
+		if !ok {
+			return 0, nil, invalidf("Property `%s` not loaded nor in schema", opts.SortBy)
+		}
+		sortField = f
+	}
+
+	matches := q.root.match(ix)
+	keys := make([]string, 0, len(matches))
+	for key := range matches {
+		if keep == nil || keep(key) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if sortField == nil {
+			return keys[i] < keys[j]
+		}
+		c, both := ix.compare(sortField, keys[i], keys[j])
+		if both && opts.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+		return keys[i] < keys[j]
+	})
+
+	total := len(keys)
+	if opts.Offset >= len(keys) {
+		return total, nil, nil
+	}
+	keys = keys[opts.Offset:]
+	if opts.Limit < len(keys) {
+		keys = keys[:opts.Limit]
+	}
+	return total, keys, nil
+}
+
+// compare orders two documents by field f. If both have a value it returns
+// their order with both set; otherwise it returns -1 if only a has one, 1
+// if only b has one, and 0 if neither has.
+func (ix *Index) compare(f *Field, a, b string) (c int, both bool) {
+	da, db := ix.docs[a], ix.docs[b]
+	if f.Type == FieldNumeric {
+		na, oka := da.nums[f.Name]
+		nb, okb := db.nums[f.Name]
+		switch {
+		case oka && okb:
+			return cmpFloat(na, nb), true
+		case oka:
+			return -1, false
+		case okb:
+			return 1, false
+		}
+		return 0, false
+	}
+	va, oka := da.values[f.Name]
+	vb, okb := db.values[f.Name]
+	switch {
+	case oka && okb:
+		return strings.Compare(strings.ToLower(va), strings.ToLower(vb)), true
+	case oka:
+		return -1, false
+	case okb:
+		return 1, false
+	}
+	return 0, false
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// all returns the keys of every document.
+func (ix *Index) all() keySet {
+	keys := make(keySet, len(ix.docs))
+	for key := range ix.docs {
+		keys[key] = struct{}{}
+	}
+	return keys
+}
+
+// tokenize splits text into lowercase terms made of letters and digits.
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// splitTags splits a TAG value on sep into trimmed, lowercase tags.
+func splitTags(s string, sep byte) []string {
+	var tags []string
+	for _, t := range strings.Split(s, string(sep)) {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// distinct returns terms without repeats, keeping their first order.
+func distinct(terms []string) []string {
+	seen := make(map[string]bool, len(terms))
+	out := terms[:0]
+	for _, t := range terms {
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	return out
+}
@@ -0,0 +1,110 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testDefinition() Definition {
+	return Definition{
+		Name:     "idx",
+		Prefixes: []string{"doc:", "item:"},
+		Fields: []Field{
+			{Name: "title", Type: FieldText},
+			{Name: "body", Type: FieldText},
+			{Name: "tags", Type: FieldTag, Separator: DefaultSeparator},
+			{Name: "price", Type: FieldNumeric, Sortable: true},
+		},
+	}
+}
+
+func TestDefinition_ValidateAndMatch(t *testing.T) {
+	def := testDefinition()
+	require.NoError(t, def.Validate())
+	assert.True(t, def.Matches("doc:1"))
+	assert.True(t, def.Matches("item:"))
+	assert.False(t, def.Matches("user:1"))
+	assert.True(t, (&Definition{}).Matches("anything"))
+
+	def.Fields = append(def.Fields, Field{Name: "title", Type: FieldTag, Separator: ','})
+	assert.EqualError(t, def.Validate(), "Duplicate field in schema - title")
+	assert.Error(t, (&Definition{Name: "x"}).Validate())
+
+	ft, ok := ParseFieldType("numeric")
+	assert.True(t, ok)
+	assert.Equal(t, "NUMERIC", ft.String())
+	_, ok = ParseFieldType("GEO")
+	assert.False(t, ok)
+}
+
+func TestIndex_PutRemove(t *testing.T) {
+	ix := NewIndex(testDefinition())
+	ix.Put("doc:1", map[string]string{"title": "Hello, World", "tags": "Red, blue", "price": "10", "other": "x"})
+	ix.Put("doc:2", map[string]string{"title": "hello again", "price": "not a number"})
+	assert.Equal(t, Stats{Docs: 2, Terms: 3, Records: 7}, ix.Stats(nil))
+
+	// Re-indexing a document replaces its old entries.
+	ix.Put("doc:1", map[string]string{"title": "goodbye", "price": "5"})
+	assert.Equal(t, Stats{Docs: 2, Terms: 3, Records: 4}, ix.Stats(nil))
+	assert.Equal(t, []string{"doc:1", "doc:2"}, ix.Keys())
+
+	ix.Remove("doc:1")
+	ix.Remove("missing")
+	assert.Equal(t, Stats{Docs: 1, Terms: 2, Records: 2}, ix.Stats(nil))
+	assert.Empty(t, ix.nums["price"])
+
+	// Stats leaves out documents the caller does not keep.
+	ix.Put("doc:3", map[string]string{"title": "gone", "price": "1"})
+	assert.Equal(t, Stats{Docs: 2, Terms: 3, Records: 4}, ix.Stats(nil))
+	kept := func(key string) bool { return key != "doc:3" }
+	assert.Equal(t, Stats{Docs: 1, Terms: 2, Records: 2}, ix.Stats(kept))
+}
+
+func TestIndex_SearchOrderAndPaging(t *testing.T) {
+	ix := NewIndex(testDefinition())
+	ix.Put("doc:a", map[string]string{"title": "pear", "price": "3"})
+	ix.Put("doc:b", map[string]string{"title": "Apple", "price": "1"})
+	ix.Put("doc:c", map[string]string{"title": "fig"})
+	ix.Put("doc:d", map[string]string{"title": "banana", "price": "2"})
+
+	all, err := Parse(&ix.def, "*")
+	require.NoError(t, err)
+
+	search := func(opts Options) (int, []string) {
+		t.Helper()
+		total, keys, err := ix.Search(all, opts, nil)
+		require.NoError(t, err)
+		return total, keys
+	}
+
+	total, keys := search(Options{Limit: DefaultLimit})
+	assert.Equal(t, 4, total)
+	assert.Equal(t, []string{"doc:a", "doc:b", "doc:c", "doc:d"}, keys)
+
+	_, keys = search(Options{SortBy: "price", Limit: DefaultLimit})
+	assert.Equal(t, []string{"doc:b", "doc:d", "doc:a", "doc:c"}, keys)
+	_, keys = search(Options{SortBy: "price", Desc: true, Limit: DefaultLimit})
+	assert.Equal(t, []string{"doc:a", "doc:d", "doc:b", "doc:c"}, keys)
+	_, keys = search(Options{SortBy: "title", Limit: DefaultLimit})
+	assert.Equal(t, []string{"doc:b", "doc:d", "doc:c", "doc:a"}, keys)
+
+	total, keys = search(Options{SortBy: "price", Offset: 1, Limit: 2})
+	assert.Equal(t, 4, total)
+	assert.Equal(t, []string{"doc:d", "doc:a"}, keys)
+	total, keys = search(Options{Offset: 10, Limit: 2})
+	assert.Equal(t, 4, total)
+	assert.Empty(t, keys)
+	total, keys = search(Options{Limit: 0})
+	assert.Equal(t, 4, total)
+	assert.Empty(t, keys)
+
+	total, keys, err = ix.Search(all, Options{Limit: DefaultLimit}, func(key string) bool { return key != "doc:b" })
+	require.NoError(t, err)
+	assert.Equal(t, 3, total)
+	assert.NotContains(t, keys, "doc:b")
+
+	_, _, err = ix.Search(all, Options{SortBy: "nope"}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,467 @@
+package search
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Query is a parsed FT.SEARCH query. The language is a subset of
+// RediSearch's:
+//
+//	hello world         documents containing both words in any TEXT field
+//	hel*                a word prefix
+//	@title:hello        a word in one TEXT field
+//	@title:(a | b c)    a sub-query limited to one TEXT field
+//	@tags:{red | blue}  any of the tags in a TAG field
+//	@price:[10 (20]     a NUMERIC range; "(" excludes a bound, -inf and +inf are open
+//	a | b               either side
+//	-a                  documents not matching a
+//	( ... )             grouping
+//	*                   every document
+//
+// Words are matched case-insensitively; a backslash escapes the next
+// character in words and tags.
+type Query struct {
+	root node
+}
+
+// node is a query expression evaluated against an index.
+type node interface {
+	match(ix *Index) keySet
+}
+
+// Parse parses a query against the fields of def.
+func Parse(def *Definition, query string) (*Query, error) {
+	p := &parser{def: def, s: query}
+	root, err := p.union(nil)
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.s) {
+		return nil, p.syntaxError()
+	}
+	return &Query{root: root}, nil
+}
+
+// parser is a recursive descent parser over the query text.
+type parser struct {
+	def *Definition
+	s   string
+	pos int
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.s) && isSpace(p.s[p.pos]) {
+		p.pos++
+	}
+}
+
+// peek returns the next byte, or 0 at the end.
+func (p *parser) peek() byte {
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+func (p *parser) syntaxError() error {
+	near := p.s[p.pos:]
+	if near == "" {
+		return invalidf("Syntax error at offset %d: unexpected end of query", p.pos)
+	}
+	if len(near) > 10 {
+		near = near[:10]
+	}
+	return invalidf("Syntax error at offset %d near %s", p.pos, near)
+}
+
+// union parses intersections separated by "|". scope lists the TEXT
+// fields words are matched in; nil means all of them.
+func (p *parser) union(scope []string) (node, error) {
+	var alts []node
+	for {
+		n, err := p.intersect(scope)
+		if err != nil {
+			return nil, err
+		}
+		alts = append(alts, n)
+		p.skipSpace()
+		if p.peek() != '|' {
+			break
+		}
+		p.pos++
+	}
+	if len(alts) == 1 {
+		return alts[0], nil
+	}
+	return orNode(alts), nil
+}
+
+// intersect parses a run of terms that must all match.
+func (p *parser) intersect(scope []string) (node, error) {
+	var parts []node
+	for {
+		p.skipSpace()
+		if c := p.peek(); c == 0 || c == ')' || c == '|' {
+			break
+		}
+		n, err := p.unary(scope)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, n)
+	}
+	switch len(parts) {
+	case 0:
+		return nil, p.syntaxError()
+	case 1:
+		return parts[0], nil
+	}
+	return andNode(parts), nil
+}
+
+// unary parses an optionally negated atom.
+func (p *parser) unary(scope []string) (node, error) {
+	if p.peek() == '-' {
+		p.pos++
+		n, err := p.unary(scope)
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+	return p.atom(scope)
+}
+
+// atom parses a group, a field filter, "*" or a word.
+func (p *parser) atom(scope []string) (node, error) {
+	switch p.peek() {
+	case '(':
+		p.pos++
+		n, err := p.union(scope)
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.peek() != ')' {
+			return nil, p.syntaxError()
+		}
+		p.pos++
+		return n, nil
+	case '@':
+		p.pos++
+		return p.fieldFilter()
+	case '*':
+		if p.pos+1 == len(p.s) || isSpace(p.s[p.pos+1]) || p.s[p.pos+1] == ')' || p.s[p.pos+1] == '|' {
+			p.pos++
+			return allNode{}, nil
+		}
+	}
+	return p.word(scope)
+}
+
+// fieldFilter parses what follows "@": a field name, a colon and a filter
+// suited to the field's type.
+func (p *parser) fieldFilter() (node, error) {
+	start := p.pos
+	end := strings.IndexByte(p.s[start:], ':')
+	if end < 0 {
+		return nil, p.syntaxError()
+	}
+	name := p.s[start : start+end]
+	f, ok := p.def.field(name)
+	if !ok {
+		return nil, invalidf("Unknown field '%s' at offset %d", name, start)
+	}
+	p.pos = start + end + 1
+	p.skipSpace()
+
+	switch p.peek() {
+	case '[':
+		if f.Type != FieldNumeric {
+			return nil, invalidf("Field '%s' is not a NUMERIC field", name)
+		}
+		p.pos++
+		return p.numericRange(name)
+	case '{':
+		if f.Type != FieldTag {
+			return nil, invalidf("Field '%s' is not a TAG field", name)
+		}
+		p.pos++
+		return p.tagList(name)
+	}
+	if f.Type != FieldText {
+		return nil, invalidf("Field '%s' is not a TEXT field", name)
+	}
+	return p.atom([]string{name})
+}
+
+// numericRange parses "min max]" after the opening bracket.
+func (p *parser) numericRange(field string) (node, error) {
+	n := rangeNode{field: field}
+	var err error
+	if n.min, n.minExcl, err = p.bound("lower"); err != nil {
+		return nil, err
+	}
+	if n.max, n.maxExcl, err = p.bound("upper"); err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.peek() != ']' {
+		return nil, p.syntaxError()
+	}
+	p.pos++
+	return n, nil
+}
+
+// bound parses one end of a numeric range.
+func (p *parser) bound(which string) (float64, bool, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.s) && !isSpace(p.s[p.pos]) && p.s[p.pos] != ']' {
+		p.pos++
+	}
+	tok := p.s[start:p.pos]
+	if tok == "" {
+		return 0, false, p.syntaxError()
+	}
+	excl := strings.HasPrefix(tok, "(")
+	v, err := strconv.ParseFloat(strings.TrimPrefix(tok, "("), 64)
+	if err != nil || math.IsNaN(v) {
+		return 0, false, invalidf("Bad %s range: %s", which, tok)
+	}
+	return v, excl, nil
+}
+
+// tagList parses "tag | tag ...}" after the opening brace.
+func (p *parser) tagList(field string) (node, error) {
+	n := tagNode{field: field}
+	var b strings.Builder
+	flush := func() {
+		if t := strings.ToLower(strings.TrimSpace(b.String())); t != "" {
+			n.tags = append(n.tags, t)
+		}
+		b.Reset()
+	}
+	for {
+		if p.pos >= len(p.s) {
+			return nil, p.syntaxError()
+		}
+		c := p.s[p.pos]
+		p.pos++
+		switch {
+		case c == '\\' && p.pos < len(p.s):
+			b.WriteByte(p.s[p.pos])
+			p.pos++
+		case c == '|':
+			flush()
+		case c == '}':
+			flush()
+			if len(n.tags) == 0 {
+				p.pos--
+				return nil, p.syntaxError()
+			}
+			return n, nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// word parses a word, which may end in "*" to match a prefix. A word that
+// tokenizes into several terms matches documents containing all of them.
+func (p *parser) word(scope []string) (node, error) {
+	start := p.pos
+	var b strings.Builder
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if c == '\\' && p.pos+1 < len(p.s) {
+			b.WriteByte(p.s[p.pos+1])
+			p.pos += 2
+			continue
+		}
+		if isSpace(c) || strings.IndexByte(`()|{}[]@:"`, c) >= 0 {
+			break
+		}
+		b.WriteByte(c)
+		p.pos++
+	}
+	raw := b.String()
+	prefix := strings.HasSuffix(raw, "*")
+	terms := tokenize(strings.TrimSuffix(raw, "*"))
+	if len(terms) == 0 {
+		p.pos = start
+		return nil, p.syntaxError()
+	}
+
+	parts := make([]node, len(terms))
+	for i, t := range terms {
+		parts[i] = termNode{fields: scope, term: t}
+	}
+	if prefix {
+		parts[len(parts)-1] = termNode{fields: scope, term: terms[len(terms)-1], prefix: true}
+	}
+	if len(parts) == 1 {
+		return parts[0], nil
+	}
+	return andNode(parts), nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// ========================
+// Evaluation
+// ========================
+
+// allNode matches every document.
+type allNode struct{}
+
+func (allNode) match(ix *Index) keySet {
+	return ix.all()
+}
+
+// termNode matches documents with a term, or a term prefix, in any of the
+// given TEXT fields (all of them if fields is nil).
+type termNode struct {
+	fields []string
+	term   string
+	prefix bool
+}
+
+func (n termNode) match(ix *Index) keySet {
+	fields := n.fields
+	if fields == nil {
+		for _, f := range ix.def.Fields {
+			if f.Type == FieldText {
+				fields = append(fields, f.Name)
+			}
+		}
+	}
+	out := make(keySet)
+	for _, field := range fields {
+		terms := ix.postings[field]
+		if !n.prefix {
+			union(out, terms[n.term])
+			continue
+		}
+		for term, keys := range terms {
+			if strings.HasPrefix(term, n.term) {
+				union(out, keys)
+			}
+		}
+	}
+	return out
+}
+
+// tagNode matches documents with any of the tags in a TAG field.
+type tagNode struct {
+	field string
+	tags  []string
+}
+
+func (n tagNode) match(ix *Index) keySet {
+	out := make(keySet)
+	for _, t := range n.tags {
+		union(out, ix.postings[n.field][t])
+	}
+	return out
+}
+
+// rangeNode matches documents whose NUMERIC field lies in a range.
+type rangeNode struct {
+	field            string
+	min, max         float64
+	minExcl, maxExcl bool
+}
+
+func (n rangeNode) match(ix *Index) keySet {
+	list := ix.nums[n.field]
+	i := sort.Search(len(list), func(i int) bool {
+		if n.minExcl {
+			return list[i].value > n.min
+		}
+		return list[i].value >= n.min
+	})
+	out := make(keySet)
+	for ; i < len(list); i++ {
+		v := list[i].value
+		if v > n.max || (n.maxExcl && v == n.max) {
+			break
+		}
+		out[list[i].key] = struct{}{}
+	}
+	return out
+}
+
+// andNode matches documents matching all of its parts.
+type andNode []node
+
+func (n andNode) match(ix *Index) keySet {
+	// Intersect the positive parts first, smallest first, and then take
+	// out what the negated ones match.
+	var sets []keySet
+	var negated []node
+	for _, part := range n {
+		if not, ok := part.(notNode); ok {
+			negated = append(negated, not.n)
+			continue
+		}
+		sets = append(sets, part.match(ix))
+	}
+	var out keySet
+	if len(sets) == 0 {
+		out = ix.all()
+	} else {
+		sort.Slice(sets, func(i, j int) bool { return len(sets[i]) < len(sets[j]) })
+		out = sets[0]
+		for _, s := range sets[1:] {
+			for key := range out {
+				if _, ok := s[key]; !ok {
+					delete(out, key)
+				}
+			}
+		}
+	}
+	for _, part := range negated {
+		for key := range part.match(ix) {
+			delete(out, key)
+		}
+	}
+	return out
+}
+
+// orNode matches documents matching any of its parts.
+type orNode []node
+
+func (n orNode) match(ix *Index) keySet {
+	out := make(keySet)
+	for _, part := range n {
+		union(out, part.match(ix))
+	}
+	return out
+}
+
+// notNode matches documents not matching n.
+type notNode struct {
+	n node
+}
+
+func (n notNode) match(ix *Index) keySet {
+	out := ix.all()
+	for key := range n.n.match(ix) {
+		delete(out, key)
+	}
+	return out
+}
+
+// union adds the keys of src to dst.
+func union(dst, src keySet) {
+	for key := range src {
+		dst[key] = struct{}{}
+	}
+}
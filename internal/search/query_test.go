@@ -0,0 +1,85 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuery_Match(t *testing.T) {
+	ix := NewIndex(testDefinition())
+	ix.Put("doc:1", map[string]string{"title": "Red apples", "body": "fresh from the farm", "tags": "fruit,red", "price": "3"})
+	ix.Put("doc:2", map[string]string{"title": "Green apples", "body": "sour", "tags": "fruit, green", "price": "2.5"})
+	ix.Put("doc:3", map[string]string{"title": "Red car", "body": "fast and red", "tags": "vehicle,New York", "price": "20000"})
+	ix.Put("doc:4", map[string]string{"title": "Apricot jam", "price": "-1"})
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"*", []string{"doc:1", "doc:2", "doc:3", "doc:4"}},
+		{"apples", []string{"doc:1", "doc:2"}},
+		{"RED", []string{"doc:1", "doc:3"}},
+		{"red apples", []string{"doc:1"}},
+		{"red-apples", []string{"doc:1"}},
+		{"ap*", []string{"doc:1", "doc:2", "doc:4"}},
+		{"apples | car", []string{"doc:1", "doc:2", "doc:3"}},
+		{"apples -red", []string{"doc:2"}},
+		{"-apples", []string{"doc:3", "doc:4"}},
+		{"@title:red", []string{"doc:1", "doc:3"}},
+		{"@body:red", []string{"doc:3"}},
+		{"@title:(green | car)", []string{"doc:2", "doc:3"}},
+		{"@body:(fast red)", []string{"doc:3"}},
+		{"@tags:{fruit}", []string{"doc:1", "doc:2"}},
+		{"@tags:{ Green | new york }", []string{"doc:2", "doc:3"}},
+		{"@tags:{red}", []string{"doc:1"}},
+		{"@price:[2.5 3]", []string{"doc:1", "doc:2"}},
+		{"@price:[(2.5 3]", []string{"doc:1"}},
+		{"@price:[-inf (3]", []string{"doc:2", "doc:4"}},
+		{"@price:[100 +inf]", []string{"doc:3"}},
+		{"@price:[0 inf] -@tags:{fruit}", []string{"doc:3"}},
+		{"(apples | jam) @price:[-5 2.5]", []string{"doc:2", "doc:4"}},
+		{"@tags:{fruit} (@title:green | @body:farm)", []string{"doc:1", "doc:2"}},
+		{`red\-apples`, []string{"doc:1"}},
+		{"missing", nil},
+	}
+	for _, tt := range tests {
+		q, err := Parse(&ix.def, tt.query)
+		require.NoError(t, err, tt.query)
+		_, keys, err := ix.Search(q, Options{Limit: DefaultLimit}, nil)
+		require.NoError(t, err, tt.query)
+		assert.Equal(t, tt.want, keys, tt.query)
+	}
+}
+
+func TestQuery_ParseErrors(t *testing.T) {
+	def := testDefinition()
+	for _, query := range []string{
+		"",
+		"   ",
+		"(apples",
+		"apples)",
+		"a | ",
+		"@title",
+		"@nope:x",
+		"@price:x",
+		"@price:{x}",
+		"@title:[1 2]",
+		"@price:[1]",
+		"@price:[a 2]",
+		"@price:[1 2",
+		"@tags:{}",
+		"@tags:{a",
+		`"quoted"`,
+		"-",
+	} {
+		_, err := Parse(&def, query)
+		assert.ErrorIs(t, err, ErrInvalid, query)
+	}
+
+	_, err := Parse(&def, "@nope:x")
+	assert.EqualError(t, err, "Unknown field 'nope' at offset 1")
+	_, err = Parse(&def, "@price:[x 1]")
+	assert.EqualError(t, err, "Bad lower range: x")
+}
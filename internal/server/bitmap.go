@@ -0,0 +1,281 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/flashdb/flashdb/internal/engine"
+	"github.com/flashdb/flashdb/internal/protocol"
+	"github.com/flashdb/flashdb/internal/store"
+)
+
+// ─── Bitmap commands ────────────────────────────────────────────────────────
+
+const errBitOffset = "bit offset is not an integer or out of range"
+
+// parseBitOffset parses a bit offset. In BITFIELD an offset prefixed with
+// "#" counts in fields of the given width.
+func parseBitOffset(arg string, hashWidth uint) (uint64, bool) {
+	scale := uint64(1)
+	if hashWidth > 0 && strings.HasPrefix(arg, "#") {
+		arg, scale = arg[1:], uint64(hashWidth)
+	}
+	n, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil || n > (store.MaxStringSize*8-1)/scale {
+		return 0, false
+	}
+	return n * scale, true
+}
+
+// parseBitRange parses the optional "start end [BYTE|BIT]" arguments shared
+// by BITCOUNT and BITPOS. It returns the number of arguments given to start
+// and end, and an error message.
+func parseBitRange(args []protocol.Value) (start, end int64, n int, bitUnit bool, msg string) {
+	if len(args) > 3 {
+		return 0, 0, 0, false, "syntax error"
+	}
+	if len(args) == 3 {
+		switch strings.ToUpper(args[2].Str) {
+		case "BYTE":
+		case "BIT":
+			bitUnit = true
+		default:
+			return 0, 0, 0, false, "syntax error"
+		}
+		args = args[:2]
+	}
+	var err error
+	if len(args) > 0 {
+		if start, err = strconv.ParseInt(args[0].Str, 10, 64); err != nil {
+			return 0, 0, 0, false, "value is not an integer or out of range"
+		}
+	}
+	end = -1
+	if len(args) > 1 {
+		if end, err = strconv.ParseInt(args[1].Str, 10, 64); err != nil {
+			return 0, 0, 0, false, "value is not an integer or out of range"
+		}
+	}
+	return start, end, len(args), bitUnit, ""
+}
+
+// SETBIT key offset value
+func (s *Server) cmdSetBit(w *protocol.Writer, args []protocol.Value) {
+	if len(args) != 3 {
+		w.WriteError("wrong number of arguments for 'SETBIT' command")
+		return
+	}
+	offset, ok := parseBitOffset(args[1].Str, 0)
+	if !ok {
+		w.WriteError(errBitOffset)
+		return
+	}
+	if args[2].Str != "0" && args[2].Str != "1" {
+		w.WriteError("bit is not an integer or out of range")
+		return
+	}
+
+	old, err := s.engine.SetBit(args[0].Str, offset, int(args[2].Str[0]-'0'))
+	if err != nil {
+		s.writeEngineError(w, "SETBIT", err)
+		return
+	}
+	w.WriteInteger(int64(old))
+}
+
+// GETBIT key offset
+func (s *Server) cmdGetBit(w *protocol.Writer, args []protocol.Value) {
+	if len(args) != 2 {
+		w.WriteError("wrong number of arguments for 'GETBIT' command")
+		return
+	}
+	offset, ok := parseBitOffset(args[1].Str, 0)
+	if !ok {
+		w.WriteError(errBitOffset)
+		return
+	}
+
+	bit, err := s.engine.GetBit(args[0].Str, offset)
+	if err != nil {
+		s.writeEngineError(w, "GETBIT", err)
+		return
+	}
+	w.WriteInteger(int64(bit))
+}
+
+// BITCOUNT key [start end [BYTE|BIT]]
+func (s *Server) cmdBitCount(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 1 {
+		w.WriteError("wrong number of arguments for 'BITCOUNT' command")
+		return
+	}
+	start, end, n, bitUnit, msg := parseBitRange(args[1:])
+	if msg == "" && n == 1 {
+		msg = "syntax error"
+	}
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+
+	count, err := s.engine.BitCount(args[0].Str, start, end, bitUnit)
+	if err != nil {
+		s.writeEngineError(w, "BITCOUNT", err)
+		return
+	}
+	w.WriteInteger(count)
+}
+
+// BITPOS key bit [start [end [BYTE|BIT]]]
+func (s *Server) cmdBitPos(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 2 {
+		w.WriteError("wrong number of arguments for 'BITPOS' command")
+		return
+	}
+	if args[1].Str != "0" && args[1].Str != "1" {
+		w.WriteError("The bit argument must be 1 or 0.")
+		return
+	}
+	start, end, n, bitUnit, msg := parseBitRange(args[2:])
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+
+	pos, err := s.engine.BitPos(args[0].Str, int(args[1].Str[0]-'0'), start, end, n == 2, bitUnit)
+	if err != nil {
+		s.writeEngineError(w, "BITPOS", err)
+		return
+	}
+	w.WriteInteger(pos)
+}
+
+// BITOP AND|OR|XOR|NOT destkey key [key ...]
+func (s *Server) cmdBitOp(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 3 {
+		w.WriteError("wrong number of arguments for 'BITOP' command")
+		return
+	}
+	var op store.BitOpKind
+	switch strings.ToUpper(args[0].Str) {
+	case "AND":
+		op = store.BitAnd
+	case "OR":
+		op = store.BitOr
+	case "XOR":
+		op = store.BitXor
+	case "NOT":
+		op = store.BitNot
+		if len(args) != 3 {
+			w.WriteError("BITOP NOT must be called with a single source key.")
+			return
+		}
+	default:
+		w.WriteError("syntax error")
+		return
+	}
+	keys := make([]string, len(args)-2)
+	for i, arg := range args[2:] {
+		keys[i] = arg.Str
+	}
+
+	n, err := s.engine.BitOp(op, args[1].Str, keys...)
+	if err != nil {
+		s.writeEngineError(w, "BITOP", err)
+		return
+	}
+	w.WriteInteger(int64(n))
+}
+
+// BITFIELD key [GET type offset] [SET type offset value]
+// [INCRBY type offset increment] [OVERFLOW WRAP|SAT|FAIL] ...
+// BITFIELD_RO key [GET type offset] ...
+func (s *Server) cmdBitField(w *protocol.Writer, cmd string, args []protocol.Value) {
+	if len(args) < 1 {
+		w.WriteError("wrong number of arguments for '" + cmd + "' command")
+		return
+	}
+	var ops []engine.BitFieldOp
+	overflow := store.OverflowWrap
+	for i := 1; i < len(args); {
+		sub := strings.ToUpper(args[i].Str)
+		if sub == "OVERFLOW" {
+			if cmd == "BITFIELD_RO" {
+				w.WriteError("BITFIELD_RO only supports the GET subcommand")
+				return
+			}
+			if i+1 >= len(args) {
+				w.WriteError("syntax error")
+				return
+			}
+			switch strings.ToUpper(args[i+1].Str) {
+			case "WRAP":
+				overflow = store.OverflowWrap
+			case "SAT":
+				overflow = store.OverflowSat
+			case "FAIL":
+				overflow = store.OverflowFail
+			default:
+				w.WriteError("Invalid OVERFLOW type specified")
+				return
+			}
+			i += 2
+			continue
+		}
+
+		op := engine.BitFieldOp{Overflow: overflow}
+		argc := 3
+		switch sub {
+		case "GET":
+		case "SET":
+			op.Kind, argc = engine.BitFieldSet, 4
+		case "INCRBY":
+			op.Kind, argc = engine.BitFieldIncrBy, 4
+		default:
+			w.WriteError("syntax error")
+			return
+		}
+		if cmd == "BITFIELD_RO" && op.Kind != engine.BitFieldGet {
+			w.WriteError("BITFIELD_RO only supports the GET subcommand")
+			return
+		}
+		if i+argc > len(args) {
+			w.WriteError("syntax error")
+			return
+		}
+		typ, ok := store.ParseBitFieldType(args[i+1].Str)
+		if !ok {
+			w.WriteError("Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+			return
+		}
+		op.Type = typ
+		if op.Offset, ok = parseBitOffset(args[i+2].Str, typ.Bits); !ok {
+			w.WriteError(errBitOffset)
+			return
+		}
+		if argc == 4 {
+			v, err := strconv.ParseInt(args[i+3].Str, 10, 64)
+			if err != nil {
+				w.WriteError("value is not an integer or out of range")
+				return
+			}
+			op.Value = v
+		}
+		ops = append(ops, op)
+		i += argc
+	}
+
+	results, err := s.engine.BitField(args[0].Str, ops)
+	if err != nil {
+		s.writeEngineError(w, cmd, err)
+		return
+	}
+	w.WriteArrayHeader(len(results))
+	for _, r := range results {
+		if !r.OK {
+			w.WriteNull()
+			continue
+		}
+		w.WriteInteger(r.Value)
+	}
+}
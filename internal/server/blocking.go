@@ -0,0 +1,493 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/flashdb/flashdb/internal/engine"
+	"github.com/flashdb/flashdb/internal/protocol"
+	"github.com/flashdb/flashdb/internal/store"
+)
+
+// popFunc tries to serve a blocking command from the first of keys that has
+// elements. It returns a nil reply when every list or stream is empty.
+type popFunc func(keys []string) (reply func(w *protocol.Writer), err error)
+
+// blockedClient is a connection waiting in BLPOP, BRPOP, BLMOVE, BLMPOP,
+// XREAD or XREADGROUP.
+type blockedClient struct {
+	cmd   string
+	db    int
+	keys  []string
+	pop   popFunc
+	reply chan func(w *protocol.Writer) // receives the reply once served
+	// exclusive is set for list pops, which take elements away from the
+	// clients queued behind them. Stream readers each wait for their own
+	// entries, so one that is not served does not hold up the others.
+	exclusive bool
+}
+
+// dbKey is a key in one of the databases.
+type dbKey struct {
+	db  int
+	key string
+}
+
+// blocking tracks clients blocked on list and stream keys. Each key has a
+// FIFO queue of waiters; when a key receives elements, the unblock loop
+// serves the clients waiting on it in the order they blocked.
+//
+// Lock order: mu, then the engine lock, then readyMu. The engine reports
+// pushes with its lock held, so signal only takes readyMu.
+type blocking struct {
+	mu      sync.Mutex
+	waiters map[dbKey][]*blockedClient
+	blocked atomic.Int64 // clients blocked or about to block
+
+	readyMu sync.Mutex
+	ready   map[dbKey]struct{} // keys pushed to since the last pass
+	wake    chan struct{}
+	stop    chan struct{}
+}
+
+func newBlocking() *blocking {
+	return &blocking{
+		waiters: make(map[dbKey][]*blockedClient),
+		ready:   make(map[dbKey]struct{}),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+}
+
+// signal marks key of database db as ready. It is registered as the
+// engine's key ready hook.
+func (b *blocking) signal(db int, key string) {
+	if b.blocked.Load() == 0 {
+		return
+	}
+	b.readyMu.Lock()
+	b.ready[dbKey{db, key}] = struct{}{}
+	b.readyMu.Unlock()
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// signalDBs marks every key waited on in the given databases as ready,
+// after SWAPDB replaced their contents.
+func (b *blocking) signalDBs(dbs ...int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for k := range b.waiters {
+		for _, db := range dbs {
+			if k.db == db {
+				b.signal(k.db, k.key)
+			}
+		}
+	}
+}
+
+// takeReady returns the keys signalled since the last call.
+func (b *blocking) takeReady() []dbKey {
+	b.readyMu.Lock()
+	defer b.readyMu.Unlock()
+	keys := make([]dbKey, 0, len(b.ready))
+	for k := range b.ready {
+		keys = append(keys, k)
+		delete(b.ready, k)
+	}
+	return keys
+}
+
+// add queues bc on each of its keys (must hold mu).
+func (b *blocking) add(bc *blockedClient) {
+	for _, key := range bc.keys {
+		k := dbKey{bc.db, key}
+		b.waiters[k] = append(b.waiters[k], bc)
+	}
+}
+
+// remove takes bc off every queue and reports whether it was still waiting
+// (must hold mu).
+func (b *blocking) remove(bc *blockedClient) bool {
+	found := false
+	for _, key := range bc.keys {
+		k := dbKey{bc.db, key}
+		queue := b.waiters[k]
+		for i, w := range queue {
+			if w == bc {
+				queue = append(queue[:i], queue[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if len(queue) == 0 {
+			delete(b.waiters, k)
+		} else {
+			b.waiters[k] = queue
+		}
+	}
+	if found {
+		b.blocked.Add(-1)
+	}
+	return found
+}
+
+// unwaited returns the keys of database db nobody is blocked on yet (must
+// hold mu). A new client may only take elements from those, so that it
+// cannot overtake clients that blocked before it.
+func (b *blocking) unwaited(db int, keys []string) []string {
+	free := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if len(b.waiters[dbKey{db, key}]) == 0 {
+			free = append(free, key)
+		}
+	}
+	return free
+}
+
+// unblockLoop serves blocked clients whenever lists or streams they wait on
+// receive elements, from any connection or from the HTTP API.
+func (s *Server) unblockLoop() {
+	b := s.blocking
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-b.wake:
+		}
+		for keys := b.takeReady(); len(keys) > 0; keys = b.takeReady() {
+			for _, k := range keys {
+				s.serveBlocked(k)
+			}
+		}
+	}
+}
+
+// serveBlocked hands elements of a key to its waiters, oldest first. List
+// pops are served until the list is empty; stream readers are each served
+// if the stream has entries for them.
+func (s *Server) serveBlocked(k dbKey) {
+	b := s.blocking
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Once a list pop comes up empty, later pops must not be served ahead
+	// of it.
+	popsDone := false
+	for _, bc := range append([]*blockedClient(nil), b.waiters[k]...) {
+		if bc.exclusive && popsDone {
+			continue
+		}
+		reply, err := bc.pop([]string{k.key})
+		switch {
+		case errors.Is(err, engine.ErrDstWrongType):
+			// The element is there but cannot go where bc wants it.
+			reply = func(w *protocol.Writer) { s.writeEngineError(w, bc.cmd, err) }
+		case errors.Is(err, store.ErrWrongType):
+			// The key holds another type than bc waits for; keep waiting.
+			reply = nil
+		case err != nil:
+			reply = func(w *protocol.Writer) { s.writeEngineError(w, bc.cmd, err) }
+		}
+		if reply == nil {
+			popsDone = popsDone || bc.exclusive
+			continue
+		}
+		b.remove(bc)
+		bc.reply <- reply
+	}
+}
+
+// blockingPop runs a blocking list command. It serves the client at once if
+// one of the lists has elements; otherwise it waits until another client
+// pushes to one of them, the timeout passes (0 waits forever), the client
+// disconnects or the server shuts down. Inside EXEC it never waits.
+func (s *Server) blockingPop(w *protocol.Writer, client *clientConn, cmd string, keys []string, timeout time.Duration, pop popFunc, timedOut func(w *protocol.Writer)) {
+	s.block(w, client, &blockedClient{cmd: cmd, keys: keys, pop: pop, exclusive: true}, timeout, timedOut)
+}
+
+// blockingRead runs a blocking stream read, like blockingPop but for
+// XREAD and XREADGROUP, which wait for entries to be added to a stream.
+func (s *Server) blockingRead(w *protocol.Writer, client *clientConn, cmd string, keys []string, timeout time.Duration, read popFunc, timedOut func(w *protocol.Writer)) {
+	s.block(w, client, &blockedClient{cmd: cmd, keys: keys, pop: read}, timeout, timedOut)
+}
+
+// block serves bc at once if it can, and otherwise queues it until it is
+// served or gives up.
+func (s *Server) block(w *protocol.Writer, client *clientConn, bc *blockedClient, timeout time.Duration, timedOut func(w *protocol.Writer)) {
+	b := s.blocking
+	bc.db = s.engine.DB()
+	bc.reply = make(chan func(w *protocol.Writer), 1)
+	cmd := bc.cmd
+
+	// Inside EXEC the engine lock is held already, and mu must not be
+	// taken after it: try once, as if the timeout had passed at once.
+	if client.inExec {
+		reply, err := bc.pop(bc.keys)
+		switch {
+		case err != nil:
+			s.writeEngineError(w, cmd, err)
+		case reply != nil:
+			reply(w)
+		default:
+			timedOut(w)
+		}
+		return
+	}
+
+	// Count the client before trying, so that a push racing with the
+	// attempt signals the key and the unblock loop finds us queued.
+	b.blocked.Add(1)
+	b.mu.Lock()
+	keys := bc.keys
+	if bc.exclusive {
+		keys = b.unwaited(bc.db, keys)
+	}
+	reply, err := bc.pop(keys)
+	if err != nil || reply != nil {
+		b.mu.Unlock()
+		b.blocked.Add(-1)
+		if err != nil {
+			s.writeEngineError(w, cmd, err)
+		} else {
+			reply(w)
+		}
+		return
+	}
+	b.add(bc)
+	b.mu.Unlock()
+
+	// Replies to earlier pipelined commands must not wait for this one.
+	w.Flush()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	gone, stopWatching := watchDisconnect(client)
+
+	select {
+	case reply = <-bc.reply:
+	case <-expired:
+	case <-gone:
+	case <-b.stop:
+	}
+	stopWatching()
+
+	if reply == nil {
+		b.mu.Lock()
+		waiting := b.remove(bc)
+		b.mu.Unlock()
+		if !waiting {
+			// Served while giving up; the elements are ours now.
+			reply = <-bc.reply
+		}
+	}
+	if reply == nil {
+		timedOut(w)
+		return
+	}
+	reply(w)
+}
+
+// watchDisconnect reports on gone when a blocked client closes its
+// connection. stop ends the watch and must be called before the connection
+// is read again.
+func watchDisconnect(client *clientConn) (gone <-chan struct{}, stop func()) {
+	if client.reader == nil {
+		return nil, func() {}
+	}
+	// Blocked clients are exempt from the idle timeout.
+	client.conn.SetReadDeadline(time.Time{})
+
+	closed := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := client.reader.Peek(); err != nil {
+			var ne interface{ Timeout() bool }
+			if !errors.As(err, &ne) || !ne.Timeout() {
+				close(closed)
+			}
+		}
+	}()
+	return closed, func() {
+		// Interrupt the peek; the buffered reader keeps no error from it.
+		client.conn.SetReadDeadline(time.Now())
+		<-done
+		client.conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// parseBlockTimeout parses a timeout in seconds, as a float.
+func parseBlockTimeout(arg string) (time.Duration, bool, string) {
+	secs, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(secs) || math.IsInf(secs, 0) {
+		return 0, false, "timeout is not a float or out of range"
+	}
+	if secs < 0 {
+		return 0, false, "timeout is negative"
+	}
+	return time.Duration(secs * float64(time.Second)), true, ""
+}
+
+// parseListEnd parses LEFT or RIGHT and reports whether it is LEFT.
+func parseListEnd(arg string) (left, ok bool) {
+	switch strings.ToUpper(arg) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+func writeNullArray(w *protocol.Writer) {
+	w.WriteArrayHeader(-1)
+}
+
+func writeNull(w *protocol.Writer) {
+	w.WriteNull()
+}
+
+// BLPOP/BRPOP key [key ...] timeout
+func (s *Server) cmdBPop(w *protocol.Writer, client *clientConn, cmd string, args []protocol.Value) {
+	if len(args) < 2 {
+		w.WriteError("wrong number of arguments for '" + cmd + "' command")
+		return
+	}
+	timeout, ok, msg := parseBlockTimeout(args[len(args)-1].Str)
+	if !ok {
+		w.WriteError(msg)
+		return
+	}
+	keys := make([]string, len(args)-1)
+	for i := range keys {
+		keys[i] = args[i].Str
+	}
+	left := cmd == "BLPOP"
+
+	s.blockingPop(w, client, cmd, keys, timeout, func(keys []string) (func(w *protocol.Writer), error) {
+		key, vals, err := s.engine.LMPop(keys, left, 1)
+		if err != nil || key == "" {
+			return nil, err
+		}
+		return func(w *protocol.Writer) {
+			w.WriteArray([][]byte{[]byte(key), vals[0]})
+		}, nil
+	}, writeNullArray)
+}
+
+// BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+func (s *Server) cmdBLMove(w *protocol.Writer, client *clientConn, args []protocol.Value) {
+	if len(args) != 5 {
+		w.WriteError("wrong number of arguments for 'BLMOVE' command")
+		return
+	}
+	fromLeft, ok1 := parseListEnd(args[2].Str)
+	toLeft, ok2 := parseListEnd(args[3].Str)
+	if !ok1 || !ok2 {
+		w.WriteError("syntax error")
+		return
+	}
+	timeout, ok, msg := parseBlockTimeout(args[4].Str)
+	if !ok {
+		w.WriteError(msg)
+		return
+	}
+	dst := args[1].Str
+
+	s.blockingPop(w, client, "BLMOVE", []string{args[0].Str}, timeout, func(keys []string) (func(w *protocol.Writer), error) {
+		if len(keys) == 0 {
+			return nil, nil
+		}
+		val, ok, err := s.engine.LMove(keys[0], dst, fromLeft, toLeft)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return func(w *protocol.Writer) {
+			w.WriteBulkString(val)
+		}, nil
+	}, writeNull)
+}
+
+// BLMPOP timeout numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func (s *Server) cmdBLMPop(w *protocol.Writer, client *clientConn, args []protocol.Value) {
+	if len(args) < 4 {
+		w.WriteError("wrong number of arguments for 'BLMPOP' command")
+		return
+	}
+	timeout, ok, msg := parseBlockTimeout(args[0].Str)
+	if !ok {
+		w.WriteError(msg)
+		return
+	}
+	keys, left, count, msg := parseLMPopArgs(args[1:])
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+
+	s.blockingPop(w, client, "BLMPOP", keys, timeout, func(keys []string) (func(w *protocol.Writer), error) {
+		key, vals, err := s.engine.LMPop(keys, left, count)
+		if err != nil || key == "" {
+			return nil, err
+		}
+		return func(w *protocol.Writer) {
+			writeLMPopResult(w, key, vals)
+		}, nil
+	}, writeNullArray)
+}
+
+// parseLMPopArgs parses "numkeys key [key ...] LEFT|RIGHT [COUNT count]"
+// and returns an error message on failure.
+func parseLMPopArgs(args []protocol.Value) (keys []string, left bool, count int, msg string) {
+	numKeys, err := strconv.Atoi(args[0].Str)
+	if err != nil {
+		return nil, false, 0, "value is not an integer or out of range"
+	}
+	if numKeys <= 0 {
+		return nil, false, 0, "numkeys should be greater than 0"
+	}
+	if len(args) < numKeys+2 {
+		return nil, false, 0, "syntax error"
+	}
+	keys = make([]string, numKeys)
+	for i := range keys {
+		keys[i] = args[1+i].Str
+	}
+	left, ok := parseListEnd(args[1+numKeys].Str)
+	if !ok {
+		return nil, false, 0, "syntax error"
+	}
+
+	count = 1
+	rest := args[2+numKeys:]
+	switch {
+	case len(rest) == 0:
+	case len(rest) == 2 && strings.EqualFold(rest[0].Str, "COUNT"):
+		count, err = strconv.Atoi(rest[1].Str)
+		if err != nil || count <= 0 {
+			return nil, false, 0, "count should be greater than 0"
+		}
+	default:
+		return nil, false, 0, "syntax error"
+	}
+	return keys, left, count, ""
+}
+
+// writeLMPopResult writes the [key, [elements]] reply of LMPOP and BLMPOP.
+func writeLMPopResult(w *protocol.Writer, key string, vals [][]byte) {
+	w.WriteArrayHeader(2)
+	w.WriteBulkString([]byte(key))
+	w.WriteArray(vals)
+}
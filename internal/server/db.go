@@ -0,0 +1,107 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/flashdb/flashdb/internal/engine"
+	"github.com/flashdb/flashdb/internal/protocol"
+)
+
+// ─── Database commands ──────────────────────────────────────────────────────
+
+// parseDB parses a database number, or returns an error message.
+func (s *Server) parseDB(arg string) (int, string) {
+	db, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, "value is not an integer or out of range"
+	}
+	if db < 0 || db >= len(s.dbs) {
+		return 0, "DB index is out of range"
+	}
+	return db, ""
+}
+
+// SELECT index
+func (s *Server) cmdSelect(w *protocol.Writer, client *clientConn, args []protocol.Value) {
+	if len(args) != 1 {
+		w.WriteError("wrong number of arguments for 'SELECT' command")
+		return
+	}
+	db, msg := s.parseDB(args[0].Str)
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+
+	client.db = db
+	w.WriteSimpleString("OK")
+}
+
+// MOVE key db
+func (s *Server) cmdMove(w *protocol.Writer, args []protocol.Value) {
+	if len(args) != 2 {
+		w.WriteError("wrong number of arguments for 'MOVE' command")
+		return
+	}
+	db, msg := s.parseDB(args[1].Str)
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+
+	moved, err := s.engine.Move(args[0].Str, db)
+	if errors.Is(err, engine.ErrSameDB) {
+		w.WriteError("source and destination objects are the same")
+		return
+	}
+	if err != nil {
+		s.writeEngineError(w, "MOVE", err)
+		return
+	}
+	w.WriteInteger(int64(boolToInt(moved)))
+}
+
+// SWAPDB index1 index2
+func (s *Server) cmdSwapDB(w *protocol.Writer, args []protocol.Value) {
+	if len(args) != 2 {
+		w.WriteError("wrong number of arguments for 'SWAPDB' command")
+		return
+	}
+	a, msg := s.parseDB(args[0].Str)
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+	b, msg := s.parseDB(args[1].Str)
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+
+	if err := s.engine.SwapDB(a, b); err != nil {
+		s.writeEngineError(w, "SWAPDB", err)
+		return
+	}
+	// Clients blocked in either database now wait on other keys.
+	s.blocking.signalDBs(a, b)
+	w.WriteSimpleString("OK")
+}
+
+// FLUSHDB [ASYNC|SYNC]
+func (s *Server) cmdFlushDB(w *protocol.Writer, args []protocol.Value) {
+	if err := s.engine.FlushDB(); err != nil {
+		s.writeEngineError(w, "FLUSHDB", err)
+		return
+	}
+	w.WriteSimpleString("OK")
+}
+
+// FLUSHALL [ASYNC|SYNC]
+func (s *Server) cmdFlushAll(w *protocol.Writer, args []protocol.Value) {
+	if err := s.engine.Clear(); err != nil {
+		s.writeEngineError(w, "FLUSHALL", err)
+		return
+	}
+	w.WriteSimpleString("OK")
+}
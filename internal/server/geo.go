@@ -0,0 +1,369 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/flashdb/flashdb/internal/engine"
+	"github.com/flashdb/flashdb/internal/protocol"
+	"github.com/flashdb/flashdb/internal/store"
+)
+
+// ─── Geo commands ───────────────────────────────────────────────────────────
+
+// geoUnits maps distance units to meters.
+var geoUnits = map[string]float64{
+	"M":  1,
+	"KM": 1000,
+	"FT": 0.3048,
+	"MI": 1609.34,
+}
+
+// parseGeoUnit returns the size of unit in meters, or an error message.
+func parseGeoUnit(unit string) (float64, string) {
+	meters, ok := geoUnits[strings.ToUpper(unit)]
+	if !ok {
+		return 0, "unsupported unit provided. please use M, KM, FT, MI"
+	}
+	return meters, ""
+}
+
+// parseGeoPoint parses a longitude and latitude pair, or returns an error
+// message.
+func parseGeoPoint(lon, lat string) (store.GeoPoint, string) {
+	var p store.GeoPoint
+	var err error
+	if p.Lon, err = strconv.ParseFloat(lon, 64); err != nil || math.IsNaN(p.Lon) {
+		return p, "value is not a valid float"
+	}
+	if p.Lat, err = strconv.ParseFloat(lat, 64); err != nil || math.IsNaN(p.Lat) {
+		return p, "value is not a valid float"
+	}
+	if !p.Valid() {
+		return p, fmt.Sprintf("invalid longitude,latitude pair %f,%f", p.Lon, p.Lat)
+	}
+	return p, ""
+}
+
+// formatGeoDist formats a distance in meters in the given unit.
+func formatGeoDist(meters, unit float64) []byte {
+	return []byte(strconv.FormatFloat(meters/unit, 'f', 4, 64))
+}
+
+// writeGeoPoint writes p as a [longitude, latitude] array.
+func writeGeoPoint(w *protocol.Writer, p store.GeoPoint) {
+	w.WriteArrayHeader(2)
+	w.WriteBulkString([]byte(strconv.FormatFloat(p.Lon, 'f', -1, 64)))
+	w.WriteBulkString([]byte(strconv.FormatFloat(p.Lat, 'f', -1, 64)))
+}
+
+// GEOADD key [NX|XX] [CH] longitude latitude member [longitude latitude member ...]
+func (s *Server) cmdGeoAdd(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 4 {
+		w.WriteError("wrong number of arguments for 'GEOADD' command")
+		return
+	}
+	var nx, xx, ch bool
+	i := 1
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Str) {
+		case "NX":
+			nx = true
+			continue
+		case "XX":
+			xx = true
+			continue
+		case "CH":
+			ch = true
+			continue
+		}
+		break
+	}
+	if nx && xx {
+		w.WriteError("XX and NX options at the same time are not compatible")
+		return
+	}
+	if rest := len(args) - i; rest == 0 || rest%3 != 0 {
+		w.WriteError("syntax error")
+		return
+	}
+
+	members := make([]store.ScoredMember, 0, (len(args)-i)/3)
+	for ; i < len(args); i += 3 {
+		p, msg := parseGeoPoint(args[i].Str, args[i+1].Str)
+		if msg != "" {
+			w.WriteError(msg)
+			return
+		}
+		members = append(members, store.ScoredMember{Member: args[i+2].Str, Score: store.GeoEncode(p)})
+	}
+
+	n, err := s.engine.GeoAdd(args[0].Str, nx, xx, ch, members...)
+	if err != nil {
+		s.writeEngineError(w, "GEOADD", err)
+		return
+	}
+	w.WriteInteger(int64(n))
+}
+
+// GEOPOS key [member ...]
+func (s *Server) cmdGeoPos(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 1 {
+		w.WriteError("wrong number of arguments for 'GEOPOS' command")
+		return
+	}
+	members := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		members[i] = arg.Str
+	}
+
+	points, err := s.engine.GeoPos(args[0].Str, members...)
+	if err != nil {
+		s.writeEngineError(w, "GEOPOS", err)
+		return
+	}
+	w.WriteArrayHeader(len(points))
+	for _, p := range points {
+		if p == nil {
+			w.WriteNull()
+			continue
+		}
+		writeGeoPoint(w, *p)
+	}
+}
+
+// GEODIST key member1 member2 [M|KM|FT|MI]
+func (s *Server) cmdGeoDist(w *protocol.Writer, args []protocol.Value) {
+	if len(args) != 3 && len(args) != 4 {
+		w.WriteError("wrong number of arguments for 'GEODIST' command")
+		return
+	}
+	unit := 1.0
+	if len(args) == 4 {
+		var msg string
+		if unit, msg = parseGeoUnit(args[3].Str); msg != "" {
+			w.WriteError(msg)
+			return
+		}
+	}
+
+	dist, ok, err := s.engine.GeoDist(args[0].Str, args[1].Str, args[2].Str)
+	if err != nil {
+		s.writeEngineError(w, "GEODIST", err)
+		return
+	}
+	if !ok {
+		w.WriteNull()
+		return
+	}
+	w.WriteBulkString(formatGeoDist(dist, unit))
+}
+
+// GEOHASH key [member ...]
+func (s *Server) cmdGeoHash(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 1 {
+		w.WriteError("wrong number of arguments for 'GEOHASH' command")
+		return
+	}
+	members := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		members[i] = arg.Str
+	}
+
+	points, err := s.engine.GeoPos(args[0].Str, members...)
+	if err != nil {
+		s.writeEngineError(w, "GEOHASH", err)
+		return
+	}
+	w.WriteArrayHeader(len(points))
+	for _, p := range points {
+		if p == nil {
+			w.WriteNull()
+			continue
+		}
+		w.WriteBulkString([]byte(store.GeoHashString(*p)))
+	}
+}
+
+// geoSearchArgs are the parsed options of GEOSEARCH and GEOSEARCHSTORE.
+type geoSearchArgs struct {
+	query     engine.GeoQuery
+	unit      float64 // meters per unit of BYRADIUS or BYBOX
+	withCoord bool
+	withDist  bool
+	withHash  bool
+	storeDist bool
+}
+
+// parseGeoSearch parses the options of GEOSEARCH, or with storing those of
+// GEOSEARCHSTORE, and returns an error message if they are invalid.
+func parseGeoSearch(args []protocol.Value, storing bool) (geoSearchArgs, string) {
+	var a geoSearchArgs
+	var hasFrom, hasBy bool
+	cmd := "GEOSEARCH"
+	if storing {
+		cmd = "GEOSEARCHSTORE"
+	}
+	for i := 0; i < len(args); i++ {
+		// need reports whether n more arguments follow the current one.
+		need := func(n int) bool { return i+n < len(args) }
+		switch opt := strings.ToUpper(args[i].Str); {
+		case opt == "FROMMEMBER" && need(1):
+			if hasFrom {
+				return a, "exactly one of FROMMEMBER or FROMLONLAT can be specified for " + cmd
+			}
+			hasFrom = true
+			a.query.FromMember, a.query.Member = true, args[i+1].Str
+			i++
+		case opt == "FROMLONLAT" && need(2):
+			if hasFrom {
+				return a, "exactly one of FROMMEMBER or FROMLONLAT can be specified for " + cmd
+			}
+			hasFrom = true
+			p, msg := parseGeoPoint(args[i+1].Str, args[i+2].Str)
+			if msg != "" {
+				return a, msg
+			}
+			a.query.Shape.Center = p
+			i += 2
+		case opt == "BYRADIUS" && need(2):
+			if hasBy {
+				return a, "exactly one of BYRADIUS and BYBOX can be specified for " + cmd
+			}
+			hasBy = true
+			r, err := strconv.ParseFloat(args[i+1].Str, 64)
+			if err != nil || math.IsNaN(r) {
+				return a, "need numeric radius"
+			}
+			if r < 0 {
+				return a, "radius cannot be negative"
+			}
+			unit, msg := parseGeoUnit(args[i+2].Str)
+			if msg != "" {
+				return a, msg
+			}
+			a.query.Shape.Radius, a.unit = r*unit, unit
+			i += 2
+		case opt == "BYBOX" && need(3):
+			if hasBy {
+				return a, "exactly one of BYRADIUS and BYBOX can be specified for " + cmd
+			}
+			hasBy = true
+			width, err1 := strconv.ParseFloat(args[i+1].Str, 64)
+			height, err2 := strconv.ParseFloat(args[i+2].Str, 64)
+			if err1 != nil || err2 != nil || math.IsNaN(width) || math.IsNaN(height) {
+				return a, "need numeric width and height"
+			}
+			if width < 0 || height < 0 {
+				return a, "height or width cannot be negative"
+			}
+			unit, msg := parseGeoUnit(args[i+3].Str)
+			if msg != "" {
+				return a, msg
+			}
+			a.query.Shape.Box = true
+			a.query.Shape.Width, a.query.Shape.Height, a.unit = width*unit, height*unit, unit
+			i += 3
+		case opt == "ASC":
+			a.query.Sort = engine.GeoAsc
+		case opt == "DESC":
+			a.query.Sort = engine.GeoDesc
+		case opt == "COUNT" && need(1):
+			n, err := strconv.ParseInt(args[i+1].Str, 10, 64)
+			if err != nil || n <= 0 {
+				return a, "COUNT must be > 0"
+			}
+			a.query.Count = int(min(n, math.MaxInt32))
+			i++
+			if need(1) && strings.EqualFold(args[i+1].Str, "ANY") {
+				a.query.Any = true
+				i++
+			}
+		case opt == "WITHCOORD" && !storing:
+			a.withCoord = true
+		case opt == "WITHDIST" && !storing:
+			a.withDist = true
+		case opt == "WITHHASH" && !storing:
+			a.withHash = true
+		case opt == "STOREDIST" && storing:
+			a.storeDist = true
+		default:
+			return a, "syntax error"
+		}
+	}
+	if !hasFrom {
+		return a, "exactly one of FROMMEMBER or FROMLONLAT can be specified for " + cmd
+	}
+	if !hasBy {
+		return a, "exactly one of BYRADIUS and BYBOX can be specified for " + cmd
+	}
+	return a, ""
+}
+
+// GEOSEARCH key FROMMEMBER member|FROMLONLAT longitude latitude
+// BYRADIUS radius unit|BYBOX width height unit [ASC|DESC] [COUNT count [ANY]]
+// [WITHCOORD] [WITHDIST] [WITHHASH]
+func (s *Server) cmdGeoSearch(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 1 {
+		w.WriteError("wrong number of arguments for 'GEOSEARCH' command")
+		return
+	}
+	a, msg := parseGeoSearch(args[1:], false)
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+
+	matches, err := s.engine.GeoSearch(args[0].Str, a.query)
+	if err != nil {
+		s.writeEngineError(w, "GEOSEARCH", err)
+		return
+	}
+	extra := boolToInt(a.withCoord) + boolToInt(a.withDist) + boolToInt(a.withHash)
+	w.WriteArrayHeader(len(matches))
+	for _, m := range matches {
+		if extra == 0 {
+			w.WriteBulkString([]byte(m.Member))
+			continue
+		}
+		w.WriteArrayHeader(1 + extra)
+		w.WriteBulkString([]byte(m.Member))
+		if a.withDist {
+			w.WriteBulkString(formatGeoDist(m.Dist, a.unit))
+		}
+		if a.withHash {
+			w.WriteInteger(int64(m.Score))
+		}
+		if a.withCoord {
+			writeGeoPoint(w, m.Point)
+		}
+	}
+}
+
+// GEOSEARCHSTORE destination source FROMMEMBER member|FROMLONLAT longitude latitude
+// BYRADIUS radius unit|BYBOX width height unit [ASC|DESC] [COUNT count [ANY]]
+// [STOREDIST]
+func (s *Server) cmdGeoSearchStore(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 2 {
+		w.WriteError("wrong number of arguments for 'GEOSEARCHSTORE' command")
+		return
+	}
+	a, msg := parseGeoSearch(args[2:], true)
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+	distUnit := 0.0
+	if a.storeDist {
+		distUnit = a.unit
+	}
+
+	n, err := s.engine.GeoSearchStore(args[0].Str, args[1].Str, a.query, distUnit)
+	if err != nil {
+		s.writeEngineError(w, "GEOSEARCHSTORE", err)
+		return
+	}
+	w.WriteInteger(int64(n))
+}
@@ -0,0 +1,61 @@
+package server
+
+import "github.com/flashdb/flashdb/internal/protocol"
+
+// ─── HyperLogLog commands ───────────────────────────────────────────────────
+
+// PFADD key [element ...]
+func (s *Server) cmdPFAdd(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 1 {
+		w.WriteError("wrong number of arguments for 'PFADD' command")
+		return
+	}
+	elements := make([][]byte, len(args)-1)
+	for i, arg := range args[1:] {
+		elements[i] = []byte(arg.Str)
+	}
+
+	changed, err := s.engine.PFAdd(args[0].Str, elements...)
+	if err != nil {
+		s.writeEngineError(w, "PFADD", err)
+		return
+	}
+	w.WriteInteger(int64(boolToInt(changed)))
+}
+
+// PFCOUNT key [key ...]
+func (s *Server) cmdPFCount(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 1 {
+		w.WriteError("wrong number of arguments for 'PFCOUNT' command")
+		return
+	}
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = arg.Str
+	}
+
+	n, err := s.engine.PFCount(keys...)
+	if err != nil {
+		s.writeEngineError(w, "PFCOUNT", err)
+		return
+	}
+	w.WriteInteger(int64(n))
+}
+
+// PFMERGE destkey [sourcekey ...]
+func (s *Server) cmdPFMerge(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 1 {
+		w.WriteError("wrong number of arguments for 'PFMERGE' command")
+		return
+	}
+	keys := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		keys[i] = arg.Str
+	}
+
+	if err := s.engine.PFMerge(args[0].Str, keys...); err != nil {
+		s.writeEngineError(w, "PFMERGE", err)
+		return
+	}
+	w.WriteSimpleString("OK")
+}
@@ -0,0 +1,414 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/flashdb/flashdb/internal/protocol"
+	"github.com/flashdb/flashdb/internal/store"
+)
+
+// ─── JSON commands ──────────────────────────────────────────────────────────
+
+// JSON commands reply in two shapes, chosen by the path: a legacy path
+// selects one value and gets a single reply, or an error if it matches
+// nothing, while a path starting with "$" gets an array with one reply per
+// match, null where the value has the wrong type for the command.
+
+// parseJSONPathArgs parses path arguments, or returns an error message.
+func parseJSONPathArgs(args []protocol.Value) ([]*store.JSONPath, string) {
+	paths := make([]*store.JSONPath, len(args))
+	for i, arg := range args {
+		p, err := store.ParseJSONPath(arg.Str)
+		if err != nil {
+			return nil, err.Error()
+		}
+		paths[i] = p
+	}
+	return paths, ""
+}
+
+// jsonPathArg parses the optional path argument at args[i], defaulting to
+// the legacy root path, or returns an error message.
+func jsonPathArg(args []protocol.Value, i int) (*store.JSONPath, string) {
+	path := "."
+	if i < len(args) {
+		path = args[i].Str
+	}
+	p, err := store.ParseJSONPath(path)
+	if err != nil {
+		return nil, err.Error()
+	}
+	return p, ""
+}
+
+// jsonValues returns the arguments as JSON texts.
+func jsonValues(args []protocol.Value) [][]byte {
+	values := make([][]byte, len(args))
+	for i, arg := range args {
+		values[i] = []byte(arg.Str)
+	}
+	return values
+}
+
+// writeJSONLengths writes array lengths, see store.JSONResult.
+func writeJSONLengths(w *protocol.Writer, p *store.JSONPath, results []store.JSONResult) {
+	if p.Legacy() {
+		w.WriteInteger(results[0].N)
+		return
+	}
+	w.WriteArrayHeader(len(results))
+	for _, r := range results {
+		if !r.OK {
+			w.WriteNull()
+			continue
+		}
+		w.WriteInteger(r.N)
+	}
+}
+
+// writeJSONValues writes popped elements, see store.JSONResult.
+func writeJSONValues(w *protocol.Writer, p *store.JSONPath, results []store.JSONResult) {
+	if p.Legacy() {
+		if results[0].JSON == nil {
+			w.WriteNull()
+			return
+		}
+		w.WriteBulkString(results[0].JSON)
+		return
+	}
+	w.WriteArrayHeader(len(results))
+	for _, r := range results {
+		if r.JSON == nil {
+			w.WriteNull()
+			continue
+		}
+		w.WriteBulkString(r.JSON)
+	}
+}
+
+// JSON.SET key path value [NX|XX]
+func (s *Server) cmdJSONSet(w *protocol.Writer, args []protocol.Value) {
+	if len(args) != 3 && len(args) != 4 {
+		w.WriteError("wrong number of arguments for 'JSON.SET' command")
+		return
+	}
+	var nx, xx bool
+	if len(args) == 4 {
+		switch strings.ToUpper(args[3].Str) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		default:
+			w.WriteError("syntax error")
+			return
+		}
+	}
+	p, msg := jsonPathArg(args, 1)
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+
+	set, err := s.engine.JSONSet(args[0].Str, p, []byte(args[2].Str), nx, xx)
+	if err != nil {
+		s.writeEngineError(w, "JSON.SET", err)
+		return
+	}
+	if !set {
+		w.WriteNull()
+		return
+	}
+	w.WriteSimpleString("OK")
+}
+
+// JSON.GET key [INDENT indent] [NEWLINE newline] [SPACE space] [path ...]
+func (s *Server) cmdJSONGet(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 1 {
+		w.WriteError("wrong number of arguments for 'JSON.GET' command")
+		return
+	}
+	var f store.JSONFormat
+	i := 1
+	for ; i+1 < len(args); i += 2 {
+		switch strings.ToUpper(args[i].Str) {
+		case "INDENT":
+			f.Indent = args[i+1].Str
+			continue
+		case "NEWLINE":
+			f.Newline = args[i+1].Str
+			continue
+		case "SPACE":
+			f.Space = args[i+1].Str
+			continue
+		}
+		break
+	}
+	var paths []*store.JSONPath
+	if i == len(args) {
+		p, _ := jsonPathArg(args, i)
+		paths = []*store.JSONPath{p}
+	} else {
+		var msg string
+		if paths, msg = parseJSONPathArgs(args[i:]); msg != "" {
+			w.WriteError(msg)
+			return
+		}
+	}
+
+	out, ok, err := s.engine.JSONGet(args[0].Str, f, paths...)
+	if err != nil {
+		s.writeEngineError(w, "JSON.GET", err)
+		return
+	}
+	if !ok {
+		w.WriteNull()
+		return
+	}
+	w.WriteBulkString(out)
+}
+
+// JSON.MGET key [key ...] path
+func (s *Server) cmdJSONMGet(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 2 {
+		w.WriteError("wrong number of arguments for 'JSON.MGET' command")
+		return
+	}
+	p, msg := jsonPathArg(args, len(args)-1)
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+	keys := make([]string, len(args)-1)
+	for i, arg := range args[:len(args)-1] {
+		keys[i] = arg.Str
+	}
+
+	results := s.engine.JSONMGet(keys, p)
+	w.WriteArrayHeader(len(results))
+	for _, r := range results {
+		if r == nil {
+			w.WriteNull()
+			continue
+		}
+		w.WriteBulkString(r)
+	}
+}
+
+// JSON.DEL key [path]
+// JSON.FORGET key [path]
+func (s *Server) cmdJSONDel(w *protocol.Writer, cmd string, args []protocol.Value) {
+	if len(args) != 1 && len(args) != 2 {
+		w.WriteError("wrong number of arguments for '" + cmd + "' command")
+		return
+	}
+	p, msg := jsonPathArg(args, 1)
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+
+	n, err := s.engine.JSONDel(args[0].Str, p)
+	if err != nil {
+		s.writeEngineError(w, cmd, err)
+		return
+	}
+	w.WriteInteger(int64(n))
+}
+
+// JSON.TYPE key [path]
+func (s *Server) cmdJSONType(w *protocol.Writer, args []protocol.Value) {
+	if len(args) != 1 && len(args) != 2 {
+		w.WriteError("wrong number of arguments for 'JSON.TYPE' command")
+		return
+	}
+	p, msg := jsonPathArg(args, 1)
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+
+	types, ok, err := s.engine.JSONType(args[0].Str, p)
+	if err != nil {
+		s.writeEngineError(w, "JSON.TYPE", err)
+		return
+	}
+	switch {
+	case !ok:
+		w.WriteNull()
+	case !p.Legacy():
+		w.WriteArrayHeader(len(types))
+		for _, t := range types {
+			w.WriteSimpleString(t)
+		}
+	case len(types) == 0:
+		w.WriteNull()
+	default:
+		w.WriteSimpleString(types[0])
+	}
+}
+
+// JSON.ARRAPPEND key path value [value ...]
+func (s *Server) cmdJSONArrAppend(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 3 {
+		w.WriteError("wrong number of arguments for 'JSON.ARRAPPEND' command")
+		return
+	}
+	p, msg := jsonPathArg(args, 1)
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+
+	results, err := s.engine.JSONArrAppend(args[0].Str, p, jsonValues(args[2:])...)
+	if err != nil {
+		s.writeEngineError(w, "JSON.ARRAPPEND", err)
+		return
+	}
+	writeJSONLengths(w, p, results)
+}
+
+// JSON.ARRINSERT key path index value [value ...]
+func (s *Server) cmdJSONArrInsert(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 4 {
+		w.WriteError("wrong number of arguments for 'JSON.ARRINSERT' command")
+		return
+	}
+	p, msg := jsonPathArg(args, 1)
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+	index, err := strconv.Atoi(args[2].Str)
+	if err != nil {
+		w.WriteError("value is not an integer or out of range")
+		return
+	}
+
+	results, err := s.engine.JSONArrInsert(args[0].Str, p, index, jsonValues(args[3:])...)
+	if err != nil {
+		s.writeEngineError(w, "JSON.ARRINSERT", err)
+		return
+	}
+	writeJSONLengths(w, p, results)
+}
+
+// JSON.ARRLEN key [path]
+func (s *Server) cmdJSONArrLen(w *protocol.Writer, args []protocol.Value) {
+	if len(args) != 1 && len(args) != 2 {
+		w.WriteError("wrong number of arguments for 'JSON.ARRLEN' command")
+		return
+	}
+	p, msg := jsonPathArg(args, 1)
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+
+	results, ok, err := s.engine.JSONArrLen(args[0].Str, p)
+	if err != nil {
+		s.writeEngineError(w, "JSON.ARRLEN", err)
+		return
+	}
+	if !ok {
+		w.WriteNull()
+		return
+	}
+	writeJSONLengths(w, p, results)
+}
+
+// JSON.ARRPOP key [path [index]]
+func (s *Server) cmdJSONArrPop(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 1 || len(args) > 3 {
+		w.WriteError("wrong number of arguments for 'JSON.ARRPOP' command")
+		return
+	}
+	p, msg := jsonPathArg(args, 1)
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+	index := -1
+	if len(args) == 3 {
+		var err error
+		if index, err = strconv.Atoi(args[2].Str); err != nil {
+			w.WriteError("value is not an integer or out of range")
+			return
+		}
+	}
+
+	results, err := s.engine.JSONArrPop(args[0].Str, p, index)
+	if err != nil {
+		s.writeEngineError(w, "JSON.ARRPOP", err)
+		return
+	}
+	writeJSONValues(w, p, results)
+}
+
+// JSON.ARRTRIM key path start stop
+func (s *Server) cmdJSONArrTrim(w *protocol.Writer, args []protocol.Value) {
+	if len(args) != 4 {
+		w.WriteError("wrong number of arguments for 'JSON.ARRTRIM' command")
+		return
+	}
+	p, msg := jsonPathArg(args, 1)
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+	start, err1 := strconv.Atoi(args[2].Str)
+	stop, err2 := strconv.Atoi(args[3].Str)
+	if err1 != nil || err2 != nil {
+		w.WriteError("value is not an integer or out of range")
+		return
+	}
+
+	results, err := s.engine.JSONArrTrim(args[0].Str, p, start, stop)
+	if err != nil {
+		s.writeEngineError(w, "JSON.ARRTRIM", err)
+		return
+	}
+	writeJSONLengths(w, p, results)
+}
+
+// JSON.NUMINCRBY key path value
+// JSON.NUMMULTBY key path value
+func (s *Server) cmdJSONNumOp(w *protocol.Writer, cmd string, args []protocol.Value) {
+	if len(args) != 3 {
+		w.WriteError("wrong number of arguments for '" + cmd + "' command")
+		return
+	}
+	p, msg := jsonPathArg(args, 1)
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+
+	op := s.engine.JSONNumIncrBy
+	if cmd == "JSON.NUMMULTBY" {
+		op = s.engine.JSONNumMultBy
+	}
+	results, err := op(args[0].Str, p, []byte(args[2].Str))
+	if err != nil {
+		s.writeEngineError(w, cmd, err)
+		return
+	}
+	if p.Legacy() {
+		w.WriteBulkString(results[0].JSON)
+		return
+	}
+	// Like RedisJSON, reply with the new numbers as one JSON array.
+	out := []byte{'['}
+	for i, r := range results {
+		if i > 0 {
+			out = append(out, ',')
+		}
+		if r.JSON == nil {
+			out = append(out, "null"...)
+			continue
+		}
+		out = append(out, r.JSON...)
+	}
+	w.WriteBulkString(append(out, ']'))
+}
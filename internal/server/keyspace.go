@@ -0,0 +1,85 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/flashdb/flashdb/internal/engine"
+)
+
+// keyspaceEvent is a keyspace notification waiting to be published.
+type keyspaceEvent struct {
+	db    int
+	flags engine.KeyspaceEvents
+	event string
+	key   string
+}
+
+// keyspaceQueue holds the keyspace notifications the engine reported, in
+// order, until the publish loop sends them. The engine reports them with
+// its lock held, so they are only queued there and published once it is
+// released: subscribers never hold up writes.
+type keyspaceQueue struct {
+	mu     sync.Mutex
+	events []keyspaceEvent
+	wake   chan struct{}
+	stop   chan struct{}
+}
+
+func newKeyspaceQueue() *keyspaceQueue {
+	return &keyspaceQueue{
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+	}
+}
+
+// push queues a notification. It is registered as the engine's keyspace
+// event hook.
+func (q *keyspaceQueue) push(db int, flags engine.KeyspaceEvents, event, key string) {
+	q.mu.Lock()
+	q.events = append(q.events, keyspaceEvent{db: db, flags: flags, event: event, key: key})
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the notifications queued since the last call.
+func (q *keyspaceQueue) take() []keyspaceEvent {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	events := q.events
+	q.events = nil
+	return events
+}
+
+// keyspaceLoop publishes queued keyspace notifications until the server is
+// closed.
+func (s *Server) keyspaceLoop() {
+	q := s.keyspace
+	for {
+		select {
+		case <-q.stop:
+			return
+		case <-q.wake:
+		}
+		for events := q.take(); len(events) > 0; events = q.take() {
+			for _, ev := range events {
+				s.publishKeyspaceEvent(ev)
+			}
+		}
+	}
+}
+
+// publishKeyspaceEvent publishes a keyspace notification on the channels
+// its flags select: __keyspace@<db>__:<key> with the event as the message,
+// and __keyevent@<db>__:<event> with the key.
+func (c *core) publishKeyspaceEvent(ev keyspaceEvent) {
+	if ev.flags&engine.NotifyKeyspace != 0 {
+		c.pubsub.Publish(fmt.Sprintf("__keyspace@%d__:%s", ev.db, ev.key), ev.event)
+	}
+	if ev.flags&engine.NotifyKeyevent != 0 {
+		c.pubsub.Publish(fmt.Sprintf("__keyevent@%d__:%s", ev.db, ev.event), ev.key)
+	}
+}
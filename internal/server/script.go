@@ -0,0 +1,513 @@
+package server
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/flashdb/flashdb/internal/protocol"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// DefaultScriptTimeLimit is how long a script runs before other clients
+// are told the server is busy and SCRIPT KILL may stop it.
+const DefaultScriptTimeLimit = 5 * time.Second
+
+// busyError is the BUSY error reply to other clients while a script runs
+// past the time limit.
+const busyError = "Redis is busy running a script. You can only call SCRIPT KILL."
+
+// noScriptCmds are the commands scripts may not call: those not allowed in
+// MULTI, since scripts run in a transaction too, and those that make no
+// sense inside one.
+var noScriptCmds = func() map[string]bool {
+	cmds := map[string]bool{
+		"MULTI": true, "EXEC": true, "DISCARD": true, "WATCH": true, "UNWATCH": true,
+		"EVAL": true, "EVALSHA": true, "SCRIPT": true, "AUTH": true, "QUIT": true,
+		"SUBSCRIBE": true, "UNSUBSCRIBE": true, "PSUBSCRIBE": true, "PUNSUBSCRIBE": true,
+	}
+	for cmd := range noMultiCmds {
+		cmds[cmd] = true
+	}
+	return cmds
+}()
+
+// scripting holds the scripts cached by SHA1 and the one running, if any.
+// Scripts run one at a time, inside an engine transaction.
+type scripting struct {
+	mu        sync.Mutex
+	cache     map[string]*lua.FunctionProto // compiled scripts by SHA1 of the source
+	running   *runningScript
+	timeLimit atomic.Int64 // nanoseconds
+}
+
+// runningScript is a script being run (its fields guarded by scripting.mu).
+type runningScript struct {
+	start     time.Time
+	cancel    context.CancelFunc
+	wrote     bool // called a command that may write; SCRIPT KILL refuses then
+	killed    bool
+	abandoned bool // stopped by SHUTDOWN NOSAVE, which drops its writes
+}
+
+func newScripting(timeLimit time.Duration) *scripting {
+	sc := &scripting{cache: make(map[string]*lua.FunctionProto)}
+	if timeLimit <= 0 {
+		timeLimit = DefaultScriptTimeLimit
+	}
+	sc.timeLimit.Store(int64(timeLimit))
+	return sc
+}
+
+// busy reports whether a script has run past the time limit.
+func (sc *scripting) busy() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.running != nil && time.Since(sc.running.start) > time.Duration(sc.timeLimit.Load())
+}
+
+// abandon stops the running script, if any, for SHUTDOWN NOSAVE: its
+// transaction is dropped rather than committed, whether it wrote or not.
+func (sc *scripting) abandon() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if rs := sc.running; rs != nil {
+		rs.abandoned = true
+		rs.cancel()
+	}
+}
+
+// busyAllowed reports whether cmd may run while a script is busy: only
+// SCRIPT KILL and SHUTDOWN NOSAVE, which stop it.
+func busyAllowed(cmd string, args []protocol.Value) bool {
+	switch cmd {
+	case "SCRIPT":
+		return len(args) > 0 && strings.EqualFold(args[0].Str, "KILL")
+	case "SHUTDOWN":
+		return len(args) > 0 && strings.EqualFold(args[0].Str, "NOSAVE")
+	}
+	return false
+}
+
+// load compiles src and caches it, returning its SHA1.
+func (sc *scripting) load(src string) (string, *lua.FunctionProto, error) {
+	sum := sha1.Sum([]byte(src))
+	sha := hex.EncodeToString(sum[:])
+
+	sc.mu.Lock()
+	proto, ok := sc.cache[sha]
+	sc.mu.Unlock()
+	if ok {
+		return sha, proto, nil
+	}
+
+	chunk, err := parse.Parse(strings.NewReader(src), "@user_script")
+	if err == nil {
+		proto, err = lua.Compile(chunk, "@user_script")
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("Error compiling script (new function): %s", oneLine(err.Error()))
+	}
+	sc.mu.Lock()
+	sc.cache[sha] = proto
+	sc.mu.Unlock()
+	return sha, proto, nil
+}
+
+// lookup returns the cached script with the given SHA1.
+func (sc *scripting) lookup(sha string) (*lua.FunctionProto, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	proto, ok := sc.cache[strings.ToLower(sha)]
+	return proto, ok
+}
+
+// Scripting commands
+
+// EVAL script numkeys [key ...] [arg ...]
+func (s *Server) cmdEval(w *protocol.Writer, client *clientConn, args []protocol.Value) {
+	if len(args) < 2 {
+		w.WriteError("wrong number of arguments for 'EVAL' command")
+		return
+	}
+	sha, proto, err := s.scripts.load(args[0].Str)
+	if err != nil {
+		w.WriteError(err.Error())
+		return
+	}
+	s.runScript(w, client, sha, proto, args[1:])
+}
+
+// EVALSHA sha1 numkeys [key ...] [arg ...]
+func (s *Server) cmdEvalSHA(w *protocol.Writer, client *clientConn, args []protocol.Value) {
+	if len(args) < 2 {
+		w.WriteError("wrong number of arguments for 'EVALSHA' command")
+		return
+	}
+	proto, ok := s.scripts.lookup(args[0].Str)
+	if !ok {
+		w.WriteErrorCode("NOSCRIPT", "No matching script. Please use EVAL.")
+		return
+	}
+	s.runScript(w, client, strings.ToLower(args[0].Str), proto, args[1:])
+}
+
+// SCRIPT LOAD script | EXISTS sha1 [sha1 ...] | FLUSH | KILL
+func (s *Server) cmdScript(w *protocol.Writer, args []protocol.Value) {
+	if len(args) == 0 {
+		w.WriteError("wrong number of arguments for 'SCRIPT' command")
+		return
+	}
+	sc := s.scripts
+	sub := strings.ToUpper(args[0].Str)
+	switch sub {
+	case "LOAD":
+		if len(args) != 2 {
+			w.WriteError("wrong number of arguments for 'SCRIPT LOAD'")
+			return
+		}
+		sha, _, err := sc.load(args[1].Str)
+		if err != nil {
+			w.WriteError(err.Error())
+			return
+		}
+		w.WriteBulkString([]byte(sha))
+
+	case "EXISTS":
+		if len(args) < 2 {
+			w.WriteError("wrong number of arguments for 'SCRIPT EXISTS'")
+			return
+		}
+		w.WriteArrayHeader(len(args) - 1)
+		for _, arg := range args[1:] {
+			if _, ok := sc.lookup(arg.Str); ok {
+				w.WriteInteger(1)
+			} else {
+				w.WriteInteger(0)
+			}
+		}
+
+	case "FLUSH":
+		sc.mu.Lock()
+		sc.cache = make(map[string]*lua.FunctionProto)
+		sc.mu.Unlock()
+		w.WriteSimpleString("OK")
+
+	case "KILL":
+		sc.mu.Lock()
+		defer sc.mu.Unlock()
+		switch rs := sc.running; {
+		case rs == nil:
+			w.WriteErrorCode("NOTBUSY", "No scripts in execution right now.")
+		case rs.wrote:
+			w.WriteErrorCode("UNKILLABLE", "Sorry the script already executed write commands against the dataset. You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command.")
+		default:
+			rs.killed = true
+			rs.cancel()
+			w.WriteSimpleString("OK")
+		}
+
+	default:
+		w.WriteError(fmt.Sprintf("Unknown SCRIPT subcommand '%s'", sub))
+	}
+}
+
+// runScript runs a compiled script with its numkeys, keys and arguments.
+// The script runs inside an engine transaction, the one of EXEC if called
+// from it, so no other client sees its changes half done and they reach
+// the WAL as one batch.
+func (s *Server) runScript(w *protocol.Writer, client *clientConn, sha string, proto *lua.FunctionProto, args []protocol.Value) {
+	numKeys, err := strconv.Atoi(args[0].Str)
+	if err != nil {
+		w.WriteError("value is not an integer or out of range")
+		return
+	}
+	if numKeys < 0 {
+		w.WriteError("Number of keys can't be negative")
+		return
+	}
+	if numKeys > len(args)-1 {
+		w.WriteError("Number of keys can't be greater than number of args")
+		return
+	}
+
+	tx := client.tx
+	if tx == nil {
+		tx = s.engine.Begin()
+	}
+	// Commands called by the script run as a client of their own, in the
+	// caller's database, with the caller's permissions.
+	sclient := &clientConn{
+		authenticated: true,
+		aclUser:       client.aclUser,
+		db:            client.db,
+		inExec:        true,
+		tx:            tx,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rs := &runningScript{start: time.Now(), cancel: cancel}
+	s.scripts.mu.Lock()
+	s.scripts.running = rs
+	s.scripts.mu.Unlock()
+
+	L := s.newScriptState(sclient, args[1:1+numKeys], args[1+numKeys:])
+	L.SetContext(ctx)
+	L.Push(L.NewFunctionFromProto(proto))
+	err = L.PCall(0, 1, nil)
+
+	// Encode the reply before the state goes, send it after committing.
+	var buf strings.Builder
+	reply := protocol.NewWriter(&buf)
+	s.scripts.mu.Lock()
+	killed, abandoned := rs.killed, rs.abandoned
+	s.scripts.running = nil
+	s.scripts.mu.Unlock()
+	if abandoned {
+		// The server is shutting down; the script's writes go with it.
+		L.Close()
+		cancel()
+		tx.Abandon()
+		return
+	}
+	switch {
+	case killed:
+		reply.WriteError("Script killed by user with SCRIPT KILL...")
+	case err != nil:
+		reply.WriteError(fmt.Sprintf("Error running script (call to f_%s): %s", sha, scriptErrorMessage(err)))
+	default:
+		writeLuaReply(reply, L.Get(-1))
+	}
+	L.Close()
+	cancel()
+
+	if client.tx == nil {
+		if err := tx.Commit(); err != nil {
+			s.writeEngineError(w, "EVAL", err)
+			return
+		}
+	}
+	w.WriteRaw([]byte(buf.String()))
+}
+
+// scriptErrorMessage returns the message of a script error without the Lua
+// stack trace.
+func scriptErrorMessage(err error) string {
+	if apiErr, ok := err.(*lua.ApiError); ok {
+		return oneLine(apiErr.Object.String())
+	}
+	return oneLine(err.Error())
+}
+
+// oneLine replaces the line breaks of msg, which may not appear in an error
+// reply.
+func oneLine(msg string) string {
+	return strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(strings.TrimSpace(msg))
+}
+
+// newScriptState returns a Lua state with the libraries scripts may use,
+// the KEYS and ARGV tables and the redis table, whose functions call
+// commands as client.
+func (s *Server) newScriptState(client *clientConn, keys, argv []protocol.Value) *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	// Scripts must not reach the file system or load modules.
+	for _, name := range []string{"dofile", "loadfile", "require", "module"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	L.SetGlobal("KEYS", luaStrings(L, keys))
+	L.SetGlobal("ARGV", luaStrings(L, argv))
+
+	redis := L.NewTable()
+	L.SetFuncs(redis, map[string]lua.LGFunction{
+		"call": func(L *lua.LState) int {
+			v := s.scriptCall(L, client)
+			if t, ok := v.(*lua.LTable); ok {
+				if msg, ok := t.RawGetString("err").(lua.LString); ok {
+					L.RaiseError("%s", string(msg))
+				}
+			}
+			L.Push(v)
+			return 1
+		},
+		"pcall": func(L *lua.LState) int {
+			L.Push(s.scriptCall(L, client))
+			return 1
+		},
+		"status_reply": func(L *lua.LState) int {
+			t := L.NewTable()
+			t.RawSetString("ok", lua.LString(L.CheckString(1)))
+			L.Push(t)
+			return 1
+		},
+		"error_reply": func(L *lua.LState) int {
+			t := L.NewTable()
+			t.RawSetString("err", lua.LString(L.CheckString(1)))
+			L.Push(t)
+			return 1
+		},
+		"sha1hex": func(L *lua.LState) int {
+			sum := sha1.Sum([]byte(L.CheckString(1)))
+			L.Push(lua.LString(hex.EncodeToString(sum[:])))
+			return 1
+		},
+	})
+	L.SetGlobal("redis", redis)
+	return L
+}
+
+// luaStrings returns vals as a Lua array of strings.
+func luaStrings(L *lua.LState, vals []protocol.Value) *lua.LTable {
+	t := L.CreateTable(len(vals), 0)
+	for _, v := range vals {
+		t.Append(lua.LString(v.Str))
+	}
+	return t
+}
+
+// scriptCall runs the command given as the arguments of redis.call or
+// redis.pcall and returns its reply as a Lua value; errors are returned as
+// tables with an err field.
+func (s *Server) scriptCall(L *lua.LState, client *clientConn) lua.LValue {
+	fail := func(msg string) lua.LValue {
+		t := L.NewTable()
+		t.RawSetString("err", lua.LString(msg))
+		return t
+	}
+	n := L.GetTop()
+	if n == 0 {
+		return fail("Please specify at least one argument for this redis lib call")
+	}
+	args := make([]protocol.Value, n)
+	for i := range args {
+		switch v := L.Get(i + 1).(type) {
+		case lua.LString:
+			args[i] = protocol.Value{Type: protocol.TypeBulkString, Str: string(v)}
+		case lua.LNumber:
+			args[i] = protocol.Value{Type: protocol.TypeBulkString, Str: v.String()}
+		default:
+			return fail("Lua redis lib command arguments must be strings or integers")
+		}
+	}
+	cmd := strings.ToUpper(args[0].Str)
+	args = args[1:]
+
+	arity, ok := commandArity[cmd]
+	switch {
+	case noScriptCmds[cmd]:
+		return fail("This Redis command is not allowed from script")
+	case !ok:
+		return fail("Unknown Redis command called from script")
+	case arity > 0 && len(args)+1 != arity, arity < 0 && len(args)+1 < -arity:
+		return fail("Wrong number of args calling Redis command from script")
+	case client.aclUser != nil && !client.aclUser.AllCommands && !s.aclAllowed(client.aclUser, cmd):
+		return fail(fmt.Sprintf("NOPERM this user has no permissions to run the '%s' command", strings.ToLower(cmd)))
+	}
+	if !readOnlyCmds[cmd] {
+		s.scripts.mu.Lock()
+		if rs := s.scripts.running; rs != nil {
+			rs.wrote = true
+		}
+		s.scripts.mu.Unlock()
+	}
+
+	var buf strings.Builder
+	s.executeCommand(protocol.NewWriter(&buf), client, cmd, args)
+	reply, err := protocol.NewReader(strings.NewReader(buf.String())).ReadValue()
+	if err != nil {
+		return fail(err.Error())
+	}
+	return luaValue(L, reply)
+}
+
+// luaValue converts a command reply to Lua as Redis does: nulls become
+// false, status replies {ok=...} and errors {err=...}.
+func luaValue(L *lua.LState, v protocol.Value) lua.LValue {
+	switch v.Type {
+	case protocol.TypeInteger:
+		return lua.LNumber(v.Num)
+	case protocol.TypeSimpleString:
+		t := L.NewTable()
+		t.RawSetString("ok", lua.LString(v.Str))
+		return t
+	case protocol.TypeError:
+		t := L.NewTable()
+		t.RawSetString("err", lua.LString(v.Str))
+		return t
+	case protocol.TypeArray:
+		if v.Null {
+			return lua.LFalse
+		}
+		t := L.CreateTable(len(v.Array), 0)
+		for _, item := range v.Array {
+			t.Append(luaValue(L, item))
+		}
+		return t
+	default:
+		if v.Null {
+			return lua.LFalse
+		}
+		return lua.LString(v.Str)
+	}
+}
+
+// writeLuaReply writes the value a script returned as Redis does: numbers
+// are truncated to integers, false is null, true is 1, an array stops at
+// its first nil, and an error goes out as written, with ERR only if empty.
+func writeLuaReply(w *protocol.Writer, v lua.LValue) {
+	switch v := v.(type) {
+	case lua.LString:
+		w.WriteBulkString([]byte(v))
+	case lua.LNumber:
+		w.WriteInteger(int64(v))
+	case lua.LBool:
+		if v {
+			w.WriteInteger(1)
+		} else {
+			w.WriteNull()
+		}
+	case *lua.LTable:
+		if msg, ok := v.RawGetString("err").(lua.LString); ok {
+			code, rest, _ := strings.Cut(oneLine(string(msg)), " ")
+			if code == "" {
+				code = "ERR"
+			}
+			w.WriteErrorCode(code, rest)
+			return
+		}
+		if msg, ok := v.RawGetString("ok").(lua.LString); ok {
+			w.WriteSimpleString(string(msg))
+			return
+		}
+		n := 0
+		for v.RawGetInt(n+1) != lua.LNil {
+			n++
+		}
+		w.WriteArrayHeader(n)
+		for i := 1; i <= n; i++ {
+			writeLuaReply(w, v.RawGetInt(i))
+		}
+	default:
+		w.WriteNull()
+	}
+}
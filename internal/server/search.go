@@ -0,0 +1,274 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/flashdb/flashdb/internal/protocol"
+	"github.com/flashdb/flashdb/internal/search"
+)
+
+// ─── Search commands ────────────────────────────────────────────────────────
+
+// parseIndexDefinition parses the FT.CREATE arguments that follow the index
+// name, or returns an error message.
+func parseIndexDefinition(name string, args []protocol.Value) (search.Definition, string) {
+	def := search.Definition{Name: name}
+	for i := 0; i < len(args); {
+		switch strings.ToUpper(args[i].Str) {
+		case "ON":
+			if i+1 >= len(args) || !strings.EqualFold(args[i+1].Str, "HASH") {
+				return def, "only ON HASH is supported"
+			}
+			i += 2
+		case "PREFIX":
+			if i+1 >= len(args) {
+				return def, "syntax error"
+			}
+			n, err := strconv.Atoi(args[i+1].Str)
+			if err != nil || n < 0 || i+2+n > len(args) {
+				return def, "bad number of prefixes"
+			}
+			for _, arg := range args[i+2 : i+2+n] {
+				def.Prefixes = append(def.Prefixes, arg.Str)
+			}
+			i += 2 + n
+		case "SCHEMA":
+			fields, msg := parseSchema(args[i+1:])
+			def.Fields = fields
+			return def, msg
+		default:
+			return def, "syntax error"
+		}
+	}
+	return def, "missing SCHEMA"
+}
+
+// parseSchema parses "field type [SEPARATOR sep] [SORTABLE] ...", or
+// returns an error message.
+func parseSchema(args []protocol.Value) ([]search.Field, string) {
+	var fields []search.Field
+	for i := 0; i < len(args); {
+		if i+1 >= len(args) {
+			return nil, "missing type for field '" + args[i].Str + "'"
+		}
+		typ, ok := search.ParseFieldType(args[i+1].Str)
+		if !ok {
+			return nil, "unsupported field type '" + args[i+1].Str + "'"
+		}
+		f := search.Field{Name: args[i].Str, Type: typ}
+		if typ == search.FieldTag {
+			f.Separator = search.DefaultSeparator
+		}
+		i += 2
+
+	options:
+		for i < len(args) {
+			switch strings.ToUpper(args[i].Str) {
+			case "SEPARATOR":
+				if typ != search.FieldTag || i+1 >= len(args) || len(args[i+1].Str) != 1 {
+					return nil, "bad SEPARATOR for field '" + f.Name + "'"
+				}
+				f.Separator = args[i+1].Str[0]
+				i += 2
+			case "SORTABLE":
+				f.Sortable = true
+				i++
+			default:
+				break options
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields, ""
+}
+
+// parseSearchOptions parses the FT.SEARCH arguments that follow the query,
+// or returns an error message.
+func parseSearchOptions(args []protocol.Value) (search.Options, string) {
+	opts := search.Options{Limit: search.DefaultLimit}
+	for i := 0; i < len(args); {
+		switch strings.ToUpper(args[i].Str) {
+		case "NOCONTENT":
+			opts.NoContent = true
+			i++
+		case "RETURN":
+			if i+1 >= len(args) {
+				return opts, "syntax error"
+			}
+			n, err := strconv.Atoi(args[i+1].Str)
+			if err != nil || n < 0 || i+2+n > len(args) {
+				return opts, "bad arguments for RETURN"
+			}
+			if n == 0 {
+				opts.NoContent = true
+			}
+			opts.Return = make([]string, n)
+			for j, arg := range args[i+2 : i+2+n] {
+				opts.Return[j] = arg.Str
+			}
+			i += 2 + n
+		case "SORTBY":
+			if i+1 >= len(args) {
+				return opts, "syntax error"
+			}
+			opts.SortBy = args[i+1].Str
+			i += 2
+			if i < len(args) {
+				switch strings.ToUpper(args[i].Str) {
+				case "ASC":
+					i++
+				case "DESC":
+					opts.Desc = true
+					i++
+				}
+			}
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return opts, "syntax error"
+			}
+			offset, err1 := strconv.Atoi(args[i+1].Str)
+			limit, err2 := strconv.Atoi(args[i+2].Str)
+			if err1 != nil || err2 != nil || offset < 0 || limit < 0 {
+				return opts, "value is not an integer or out of range"
+			}
+			opts.Offset, opts.Limit = offset, limit
+			i += 3
+		default:
+			return opts, "syntax error"
+		}
+	}
+	return opts, ""
+}
+
+// FT.CREATE index [ON HASH] [PREFIX count prefix ...] SCHEMA field type [options] ...
+func (s *Server) cmdFTCreate(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 3 {
+		w.WriteError("wrong number of arguments for 'FT.CREATE' command")
+		return
+	}
+	def, msg := parseIndexDefinition(args[0].Str, args[1:])
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+
+	if err := s.engine.FTCreate(def); err != nil {
+		s.writeEngineError(w, "FT.CREATE", err)
+		return
+	}
+	w.WriteSimpleString("OK")
+}
+
+// FT.DROPINDEX index [DD]
+func (s *Server) cmdFTDropIndex(w *protocol.Writer, args []protocol.Value) {
+	if len(args) != 1 && len(args) != 2 {
+		w.WriteError("wrong number of arguments for 'FT.DROPINDEX' command")
+		return
+	}
+	deleteDocs := false
+	if len(args) == 2 {
+		if !strings.EqualFold(args[1].Str, "DD") {
+			w.WriteError("syntax error")
+			return
+		}
+		deleteDocs = true
+	}
+
+	if err := s.engine.FTDropIndex(args[0].Str, deleteDocs); err != nil {
+		s.writeEngineError(w, "FT.DROPINDEX", err)
+		return
+	}
+	w.WriteSimpleString("OK")
+}
+
+// FT.SEARCH index query [NOCONTENT] [RETURN count field ...] [SORTBY field [ASC|DESC]] [LIMIT offset num]
+func (s *Server) cmdFTSearch(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 2 {
+		w.WriteError("wrong number of arguments for 'FT.SEARCH' command")
+		return
+	}
+	opts, msg := parseSearchOptions(args[2:])
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+
+	total, results, err := s.engine.FTSearch(args[0].Str, args[1].Str, opts)
+	if err != nil {
+		s.writeEngineError(w, "FT.SEARCH", err)
+		return
+	}
+	n := 1 + len(results)
+	if !opts.NoContent {
+		n += len(results)
+	}
+	w.WriteArrayHeader(n)
+	w.WriteInteger(int64(total))
+	for _, r := range results {
+		w.WriteBulkString([]byte(r.Key))
+		if opts.NoContent {
+			continue
+		}
+		w.WriteArrayHeader(2 * len(r.Fields))
+		for _, fv := range r.Fields {
+			w.WriteBulkString([]byte(fv.Field))
+			w.WriteBulkString(fv.Value)
+		}
+	}
+}
+
+// FT.INFO index
+func (s *Server) cmdFTInfo(w *protocol.Writer, args []protocol.Value) {
+	if len(args) != 1 {
+		w.WriteError("wrong number of arguments for 'FT.INFO' command")
+		return
+	}
+
+	def, stats, err := s.engine.FTInfo(args[0].Str)
+	if err != nil {
+		s.writeEngineError(w, "FT.INFO", err)
+		return
+	}
+	prefixes := def.Prefixes
+	if len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+
+	w.WriteArrayHeader(12)
+	w.WriteSimpleString("index_name")
+	w.WriteBulkString([]byte(def.Name))
+	w.WriteSimpleString("index_definition")
+	w.WriteArrayHeader(4)
+	w.WriteSimpleString("key_type")
+	w.WriteSimpleString("HASH")
+	w.WriteSimpleString("prefixes")
+	w.WriteStringArray(prefixes)
+	w.WriteSimpleString("attributes")
+	w.WriteArrayHeader(len(def.Fields))
+	for _, f := range def.Fields {
+		attr := []string{"identifier", f.Name, "attribute", f.Name, "type", f.Type.String()}
+		if f.Type == search.FieldTag {
+			attr = append(attr, "SEPARATOR", string(f.Separator))
+		}
+		if f.Sortable {
+			attr = append(attr, "SORTABLE")
+		}
+		w.WriteStringArray(attr)
+	}
+	w.WriteSimpleString("num_docs")
+	w.WriteInteger(int64(stats.Docs))
+	w.WriteSimpleString("num_terms")
+	w.WriteInteger(int64(stats.Terms))
+	w.WriteSimpleString("num_records")
+	w.WriteInteger(int64(stats.Records))
+}
+
+// FT._LIST
+func (s *Server) cmdFTList(w *protocol.Writer, args []protocol.Value) {
+	if len(args) != 0 {
+		w.WriteError("wrong number of arguments for 'FT._LIST' command")
+		return
+	}
+	w.WriteStringArray(s.engine.FTList())
+}
@@ -26,6 +26,8 @@ import (
 
 	"github.com/flashdb/flashdb/internal/engine"
 	"github.com/flashdb/flashdb/internal/protocol"
+	"github.com/flashdb/flashdb/internal/search"
+	"github.com/flashdb/flashdb/internal/snapshot"
 	"github.com/flashdb/flashdb/internal/store"
 	"github.com/flashdb/flashdb/internal/version"
 )
@@ -76,6 +78,10 @@ type Config struct {
 
 	// Web API token (shared secret for HTTP endpoints, empty = no auth).
 	APIToken string
+
+	// Scripts running longer than this make other clients get BUSY until
+	// they end or SCRIPT KILL stops them (0 = DefaultScriptTimeLimit).
+	ScriptTimeLimit time.Duration
 }
 
 // DefaultConfig returns default server configuration.
@@ -88,6 +94,7 @@ func DefaultConfig() Config {
 		SlowLogMaxLen:    128,
 		SlowLogThreshold: 0,
 		RateLimit:        0,
+		ScriptTimeLimit:  DefaultScriptTimeLimit,
 	}
 }
 
@@ -101,14 +108,22 @@ type clientConn struct {
 	createdAt     time.Time
 	lastCommand   time.Time
 	cmdCount      int64
+	reader        *protocol.Reader
 	// Transaction state
 	inMulti    bool
+	inExec     bool // running queued commands; blocking commands must not block
 	multiQueue []queuedCommand
+	multiError bool         // a command was refused while queueing; EXEC aborts
+	watch      engine.Watch // keys that abort EXEC if changed
+	tx         *engine.Tx   // the transaction EXEC is running, if any
 	// Pub/Sub state
 	subscriptions  map[string]bool
 	psubscriptions map[string]bool
+	outbox         chan []byte // messages to deliver; guarded by PubSub.mu
 	// ACL state
 	aclUser *ACLUser // nil when legacy single-password mode
+	// Selected database
+	db int
 	// Rate limiting state
 	rateBucket  int64 // remaining tokens this second
 	rateResetAt time.Time
@@ -120,25 +135,46 @@ type queuedCommand struct {
 	args []protocol.Value
 }
 
-// PubSub manages publish/subscribe functionality
+// PubSub manages publish/subscribe functionality. Messages are queued in
+// each subscriber's outbox and written by a goroutine of its own, so a slow
+// subscriber never holds up publishers; one that falls outboxSize messages
+// behind is disconnected.
 type PubSub struct {
 	mu       sync.RWMutex
 	channels map[string]map[*clientConn]bool
 	patterns map[string]map[*clientConn]bool
+	matchers map[string]*regexp.Regexp // compiled patterns
 }
 
+// outboxSize is the number of messages a subscriber may fall behind by
+// before it is disconnected.
+const outboxSize = 4096
+
+// outboxWriteTimeout is how long writing one message to a subscriber may
+// take before it is disconnected.
+const outboxWriteTimeout = 10 * time.Second
+
 // NewPubSub creates a new PubSub manager
 func NewPubSub() *PubSub {
 	return &PubSub{
 		channels: make(map[string]map[*clientConn]bool),
 		patterns: make(map[string]map[*clientConn]bool),
+		matchers: make(map[string]*regexp.Regexp),
 	}
 }
 
-// Server represents the FlashDB TCP server.
+// Server represents the FlashDB TCP server. Commands run on the Server of
+// the client's selected database, whose engine is that database's view;
+// everything else is shared by the Servers of all databases.
 type Server struct {
+	*core
+	engine *engine.Engine
+}
+
+// core is the state shared by the Servers of all databases.
+type core struct {
 	addr       string
-	engine     *engine.Engine
+	dbs        []*Server // by database number
 	config     Config
 	listener   net.Listener
 	wg         sync.WaitGroup
@@ -151,6 +187,9 @@ type Server struct {
 	totalCmds  int64
 	totalConns int64
 	pubsub     *PubSub
+	blocking   *blocking
+	keyspace   *keyspaceQueue
+	scripts    *scripting
 	// Slow query log
 	slowLog   []slowLogEntry
 	slowLogMu sync.Mutex
@@ -180,15 +219,28 @@ func NewWithConfig(addr string, e *engine.Engine, cfg Config) *Server {
 	}
 	logger := slog.New(slog.NewJSONHandler(log.Writer(), &slog.HandlerOptions{Level: level}))
 
-	return &Server{
+	c := &core{
 		addr:      addr,
-		engine:    e,
 		config:    cfg,
 		clients:   make(map[int64]*clientConn),
 		startTime: time.Now(),
 		pubsub:    NewPubSub(),
+		blocking:  newBlocking(),
+		keyspace:  newKeyspaceQueue(),
+		scripts:   newScripting(cfg.ScriptTimeLimit),
 		logger:    logger,
 	}
+	c.dbs = make([]*Server, e.Databases())
+	for i := range c.dbs {
+		db, _ := e.Select(i)
+		c.dbs[i] = &Server{core: c, engine: db}
+	}
+	s := c.dbs[0]
+	e.OnKeyReady(s.blocking.signal)
+	e.OnKeyspaceEvent(s.keyspace.push)
+	go s.unblockLoop()
+	go s.keyspaceLoop()
+	return s
 }
 
 // Start starts the server and listens for connections.
@@ -290,8 +342,9 @@ func (s *Server) Start(ctx context.Context) error {
 		go func(c *clientConn) {
 			defer s.wg.Done()
 			defer func() {
-				// Clean up pub/sub subscriptions
+				// Clean up pub/sub subscriptions and watched keys
 				s.pubsub.UnsubscribeAll(c)
+				s.engine.Unwatch(&c.watch)
 				s.mu.Lock()
 				delete(s.clients, c.id)
 				s.connCount--
@@ -304,6 +357,17 @@ func (s *Server) Start(ctx context.Context) error {
 
 // Close gracefully shuts down the server.
 func (s *Server) Close() error {
+	err := s.stop()
+
+	// Wait for all connections to finish
+	s.wg.Wait()
+
+	return err
+}
+
+// stop stops accepting connections and releases blocked clients. Stopping
+// again does nothing.
+func (s *Server) stop() error {
 	s.mu.Lock()
 	if s.closed {
 		s.mu.Unlock()
@@ -313,15 +377,26 @@ func (s *Server) Close() error {
 	listener := s.listener
 	s.mu.Unlock()
 
-	var err error
+	// Release blocked clients and stop serving them.
+	close(s.blocking.stop)
+	close(s.keyspace.stop)
+
 	if listener != nil {
-		err = listener.Close()
+		return listener.Close()
 	}
+	return nil
+}
 
-	// Wait for all connections to finish
+// shutdown stops the server for SHUTDOWN: unlike Close, it disconnects
+// the clients rather than waiting for them to leave.
+func (s *Server) shutdown() {
+	s.stop()
+	s.mu.RLock()
+	for _, c := range s.clients {
+		c.conn.Close()
+	}
+	s.mu.RUnlock()
 	s.wg.Wait()
-
-	return err
 }
 
 // handleConnection handles a single client connection.
@@ -330,6 +405,7 @@ func (s *Server) handleConnection(ctx context.Context, client *clientConn) {
 
 	reader := protocol.NewReader(client.conn)
 	writer := protocol.NewWriter(client.conn)
+	client.reader = reader
 
 	for {
 		select {
@@ -419,6 +495,12 @@ func (s *Server) dispatchCommand(w *protocol.Writer, client *clientConn, val pro
 		return
 	}
 
+	// --- Busy script check ---
+	if s.scripts.busy() && !busyAllowed(cmd, args) {
+		w.WriteErrorCode("BUSY", busyError)
+		return
+	}
+
 	// --- ACL permission check ---
 	if client.aclUser != nil && !client.aclUser.AllCommands {
 		if !s.aclAllowed(client.aclUser, cmd) {
@@ -444,7 +526,7 @@ func (s *Server) dispatchCommand(w *protocol.Writer, client *clientConn, val pro
 
 	// --- Audit logging for security-sensitive commands ---
 	switch cmd {
-	case "AUTH", "FLUSHDB", "FLUSHALL", "CONFIG", "ACL", "DEBUG", "SAVE", "BGSAVE", "SHUTDOWN":
+	case "AUTH", "FLUSHDB", "FLUSHALL", "SWAPDB", "CONFIG", "ACL", "DEBUG", "SAVE", "BGSAVE", "BGREWRITEAOF", "SHUTDOWN":
 		user := "default"
 		if client.aclUser != nil {
 			user = client.aclUser.Username
@@ -461,12 +543,31 @@ func (s *Server) dispatchCommand(w *protocol.Writer, client *clientConn, val pro
 // executeCommand executes a command and writes the response.
 func (s *Server) executeCommand(w *protocol.Writer, client *clientConn, cmd string, args []protocol.Value) {
 	// Handle MULTI transaction queueing
-	if client.inMulti && cmd != "EXEC" && cmd != "DISCARD" && cmd != "MULTI" {
-		client.multiQueue = append(client.multiQueue, queuedCommand{cmd: cmd, args: args})
-		w.WriteSimpleString("QUEUED")
+	if client.inMulti && cmd != "EXEC" && cmd != "DISCARD" && cmd != "MULTI" && cmd != "WATCH" {
+		s.queueCommand(w, client, cmd, args)
 		return
 	}
 
+	// Run the command against the client's database, inside EXEC through
+	// the transaction.
+	s = s.dbs[client.db]
+	if client.tx != nil {
+		db, err := client.tx.DB(client.db)
+		if err != nil {
+			s.writeEngineError(w, cmd, err)
+			return
+		}
+		s = &Server{core: s.core, engine: db}
+	}
+
+	// Commands that may add data need memory under the limit.
+	if denyOOMCmds[cmd] {
+		if err := s.engine.FreeMemory(); err != nil {
+			s.writeEngineError(w, cmd, err)
+			return
+		}
+	}
+
 	switch cmd {
 	// Connection
 	case "PING":
@@ -478,7 +579,7 @@ func (s *Server) executeCommand(w *protocol.Writer, client *clientConn, cmd stri
 	case "AUTH":
 		s.cmdAuth(w, client, args)
 	case "SELECT":
-		s.cmdSelect(w, args)
+		s.cmdSelect(w, client, args)
 	case "CLIENT":
 		s.cmdClient(w, client, args)
 
@@ -489,6 +590,18 @@ func (s *Server) executeCommand(w *protocol.Writer, client *clientConn, cmd stri
 		s.cmdExec(w, client)
 	case "DISCARD":
 		s.cmdDiscard(w, client)
+	case "WATCH":
+		s.cmdWatch(w, client, args)
+	case "UNWATCH":
+		s.cmdUnwatch(w, client)
+
+	// Scripting commands
+	case "EVAL":
+		s.cmdEval(w, client, args)
+	case "EVALSHA":
+		s.cmdEvalSHA(w, client, args)
+	case "SCRIPT":
+		s.cmdScript(w, args)
 
 	// String commands
 	case "SET":
@@ -532,6 +645,80 @@ func (s *Server) executeCommand(w *protocol.Writer, client *clientConn, cmd stri
 	case "DECRBY":
 		s.cmdDecrBy(w, args)
 
+	// Bitmap commands
+	case "SETBIT":
+		s.cmdSetBit(w, args)
+	case "GETBIT":
+		s.cmdGetBit(w, args)
+	case "BITCOUNT":
+		s.cmdBitCount(w, args)
+	case "BITPOS":
+		s.cmdBitPos(w, args)
+	case "BITOP":
+		s.cmdBitOp(w, args)
+	case "BITFIELD":
+		s.cmdBitField(w, "BITFIELD", args)
+	case "BITFIELD_RO":
+		s.cmdBitField(w, "BITFIELD_RO", args)
+
+	// HyperLogLog commands
+	case "PFADD":
+		s.cmdPFAdd(w, args)
+	case "PFCOUNT":
+		s.cmdPFCount(w, args)
+	case "PFMERGE":
+		s.cmdPFMerge(w, args)
+
+	// Geo commands
+	case "GEOADD":
+		s.cmdGeoAdd(w, args)
+	case "GEOPOS":
+		s.cmdGeoPos(w, args)
+	case "GEODIST":
+		s.cmdGeoDist(w, args)
+	case "GEOHASH":
+		s.cmdGeoHash(w, args)
+	case "GEOSEARCH":
+		s.cmdGeoSearch(w, args)
+	case "GEOSEARCHSTORE":
+		s.cmdGeoSearchStore(w, args)
+
+	// JSON commands
+	case "JSON.SET":
+		s.cmdJSONSet(w, args)
+	case "JSON.GET":
+		s.cmdJSONGet(w, args)
+	case "JSON.MGET":
+		s.cmdJSONMGet(w, args)
+	case "JSON.DEL", "JSON.FORGET":
+		s.cmdJSONDel(w, cmd, args)
+	case "JSON.TYPE":
+		s.cmdJSONType(w, args)
+	case "JSON.ARRAPPEND":
+		s.cmdJSONArrAppend(w, args)
+	case "JSON.ARRINSERT":
+		s.cmdJSONArrInsert(w, args)
+	case "JSON.ARRLEN":
+		s.cmdJSONArrLen(w, args)
+	case "JSON.ARRPOP":
+		s.cmdJSONArrPop(w, args)
+	case "JSON.ARRTRIM":
+		s.cmdJSONArrTrim(w, args)
+	case "JSON.NUMINCRBY", "JSON.NUMMULTBY":
+		s.cmdJSONNumOp(w, cmd, args)
+
+	// Search commands
+	case "FT.CREATE":
+		s.cmdFTCreate(w, args)
+	case "FT.DROPINDEX":
+		s.cmdFTDropIndex(w, args)
+	case "FT.SEARCH":
+		s.cmdFTSearch(w, args)
+	case "FT.INFO":
+		s.cmdFTInfo(w, args)
+	case "FT._LIST":
+		s.cmdFTList(w, args)
+
 	// Key commands
 	case "DEL":
 		s.cmdDel(w, args)
@@ -571,6 +758,8 @@ func (s *Server) executeCommand(w *protocol.Writer, client *clientConn, cmd stri
 		s.cmdObject(w, args)
 	case "DUMP":
 		s.cmdDump(w, args)
+	case "RESTORE":
+		s.cmdRestore(w, args)
 	case "COPY":
 		s.cmdCopy(w, args)
 
@@ -591,8 +780,14 @@ func (s *Server) executeCommand(w *protocol.Writer, client *clientConn, cmd stri
 	// Server commands
 	case "DBSIZE":
 		s.cmdDBSize(w, args)
-	case "FLUSHDB", "FLUSHALL":
+	case "FLUSHDB":
 		s.cmdFlushDB(w, args)
+	case "FLUSHALL":
+		s.cmdFlushAll(w, args)
+	case "MOVE":
+		s.cmdMove(w, args)
+	case "SWAPDB":
+		s.cmdSwapDB(w, args)
 	case "INFO":
 		s.cmdInfo(w, args)
 	case "TIME":
@@ -607,8 +802,12 @@ func (s *Server) executeCommand(w *protocol.Writer, client *clientConn, cmd stri
 		s.cmdMemory(w, args)
 	case "LASTSAVE":
 		s.cmdLastSave(w)
-	case "BGSAVE", "SAVE":
+	case "SAVE":
 		s.cmdSave(w)
+	case "BGSAVE", "BGREWRITEAOF":
+		s.cmdBgSave(w, cmd)
+	case "SHUTDOWN":
+		s.cmdShutdown(w, args)
 	case "SLOWLOG":
 		s.cmdSlowLog(w, args)
 	case "ACL":
@@ -699,6 +898,42 @@ func (s *Server) executeCommand(w *protocol.Writer, client *clientConn, cmd stri
 		s.cmdLRem(w, args)
 	case "LTRIM":
 		s.cmdLTrim(w, args)
+	case "LMOVE":
+		s.cmdLMove(w, args)
+	case "LMPOP":
+		s.cmdLMPop(w, args)
+	case "BLPOP", "BRPOP":
+		s.cmdBPop(w, client, cmd, args)
+	case "BLMOVE":
+		s.cmdBLMove(w, client, args)
+	case "BLMPOP":
+		s.cmdBLMPop(w, client, args)
+
+	// Stream commands
+	case "XADD":
+		s.cmdXAdd(w, args)
+	case "XLEN":
+		s.cmdXLen(w, args)
+	case "XRANGE", "XREVRANGE":
+		s.cmdXRange(w, cmd, args)
+	case "XDEL":
+		s.cmdXDel(w, args)
+	case "XTRIM":
+		s.cmdXTrim(w, args)
+	case "XSETID":
+		s.cmdXSetID(w, args)
+	case "XREAD":
+		s.cmdXRead(w, client, args)
+	case "XREADGROUP":
+		s.cmdXReadGroup(w, client, args)
+	case "XACK":
+		s.cmdXAck(w, args)
+	case "XPENDING":
+		s.cmdXPending(w, args)
+	case "XCLAIM":
+		s.cmdXClaim(w, args)
+	case "XGROUP":
+		s.cmdXGroup(w, args)
 
 	// Set commands
 	case "SADD":
@@ -898,7 +1133,11 @@ func (s *Server) cmdGet(w *protocol.Writer, args []protocol.Value) {
 	}
 
 	key := args[0].Str
-	value, ok := s.engine.Get(key)
+	value, ok, err := s.engine.Get(key)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
 
 	if !ok {
 		w.WriteNull()
@@ -1003,7 +1242,8 @@ func (s *Server) cmdMGet(w *protocol.Writer, args []protocol.Value) {
 	nulls := make([]bool, len(args))
 
 	for i, arg := range args {
-		value, ok := s.engine.Get(arg.Str)
+		// Keys holding a non-string value read as nil, as in Redis.
+		value, ok, _ := s.engine.Get(arg.Str)
 		if ok {
 			results[i] = value
 		} else {
@@ -1025,7 +1265,7 @@ func (s *Server) cmdAppend(w *protocol.Writer, args []protocol.Value) {
 
 	length, err := s.engine.Append(key, value)
 	if err != nil {
-		w.WriteError("internal error")
+		s.writeEngineError(w, "APPEND", err)
 		return
 	}
 
@@ -1038,7 +1278,11 @@ func (s *Server) cmdStrLen(w *protocol.Writer, args []protocol.Value) {
 		return
 	}
 
-	length := s.engine.StrLen(args[0].Str)
+	length, err := s.engine.StrLen(args[0].Str)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
 	w.WriteInteger(int64(length))
 }
 
@@ -1050,7 +1294,7 @@ func (s *Server) cmdIncr(w *protocol.Writer, args []protocol.Value) {
 
 	val, err := s.engine.IncrBy(args[0].Str, 1)
 	if err != nil {
-		w.WriteError(err.Error())
+		writeErrorReply(w, err.Error())
 		return
 	}
 
@@ -1071,7 +1315,7 @@ func (s *Server) cmdIncrBy(w *protocol.Writer, args []protocol.Value) {
 
 	val, err := s.engine.IncrBy(args[0].Str, delta)
 	if err != nil {
-		w.WriteError(err.Error())
+		writeErrorReply(w, err.Error())
 		return
 	}
 
@@ -1086,7 +1330,7 @@ func (s *Server) cmdDecr(w *protocol.Writer, args []protocol.Value) {
 
 	val, err := s.engine.IncrBy(args[0].Str, -1)
 	if err != nil {
-		w.WriteError(err.Error())
+		writeErrorReply(w, err.Error())
 		return
 	}
 
@@ -1107,7 +1351,7 @@ func (s *Server) cmdDecrBy(w *protocol.Writer, args []protocol.Value) {
 
 	val, err := s.engine.IncrBy(args[0].Str, -delta)
 	if err != nil {
-		w.WriteError(err.Error())
+		writeErrorReply(w, err.Error())
 		return
 	}
 
@@ -1293,39 +1537,93 @@ func (s *Server) cmdDBSize(w *protocol.Writer, args []protocol.Value) {
 	w.WriteInteger(int64(s.engine.Size()))
 }
 
-func (s *Server) cmdFlushDB(w *protocol.Writer, args []protocol.Value) {
-	if err := s.engine.Clear(); err != nil {
-		w.WriteError("internal error")
-		log.Printf("server: FLUSHDB error: %v", err)
-		return
-	}
-
-	w.WriteSimpleString("OK")
-}
-
+// INFO [section ...] — the named sections, or all of them.
 func (s *Server) cmdInfo(w *protocol.Writer, args []protocol.Value) {
 	stats := s.engine.GetStats()
+	mem := s.engine.Memory()
 	uptime := time.Since(stats.StartTime).Seconds()
 
 	s.mu.Lock()
 	connCount := s.connCount
 	s.mu.Unlock()
 
-	info := fmt.Sprintf(`# Server
-flashdb_version:%s
+	ps := s.engine.PersistenceStats()
+	rewriteStatus := "ok"
+	if !ps.LastRewriteOK {
+		rewriteStatus = "err"
+	}
+	snapshotStatus := "ok"
+	if !ps.LastSnapshotOK {
+		snapshotStatus = "err"
+	}
+
+	keyspace := ""
+	for _, ks := range s.engine.Keyspace() {
+		keyspace += fmt.Sprintf("db%d:keys=%d,expires=%d\n", ks.DB, ks.Keys, ks.Expires)
+	}
+
+	sections := []struct{ name, body string }{
+		{"server", fmt.Sprintf(`flashdb_version:%s
 uptime_in_seconds:%.0f
 connected_clients:%d
-
-# Stats
-total_commands_processed:%d
+`, Version, uptime, connCount)},
+		{"stats", fmt.Sprintf(`total_commands_processed:%d
 total_reads:%d
 total_writes:%d
+expired_keys:%d
+evicted_keys:%d
+`, stats.TotalCommands, stats.TotalReads, stats.TotalWrites, stats.ExpiredKeys, stats.EvictedKeys)},
+		{"memory", fmt.Sprintf(`used_memory:%d
+used_memory_human:%s
+maxmemory:%d
+maxmemory_human:%s
+maxmemory_policy:%s
+`, mem.Used, humanBytes(mem.Used), mem.MaxMemory, humanBytes(mem.MaxMemory), mem.Policy)},
+		{"persistence", fmt.Sprintf(`aof_enabled:1
+aof_rewrite_in_progress:%d
+aof_rewrites:%d
+aof_last_rewrite_time_sec:%.0f
+aof_last_bgrewrite_status:%s
+aof_current_size:%d
+aof_base_size:%d
+aof_fsync_policy:%s
+aof_pending_bytes:%d
+aof_fsyncs:%d
+aof_last_fsync_latency_us:%d
+aof_avg_fsync_latency_us:%d
+wal_checkpoint:%s
+rdb_bgsave_in_progress:%d
+rdb_current_snapshot:%s
+rdb_bgsave_progress:%.1f
+rdb_saves:%d
+rdb_last_bgsave_status:%s
+rdb_last_bgsave_time_sec:%.0f
+rdb_changes_since_last_save:%d
+rdb_last_save_time:%d
+`, boolToInt(ps.RewriteInProgress), ps.Rewrites, ps.LastRewriteTime.Seconds(), rewriteStatus, ps.WALSize, ps.WALBaseSize,
+			ps.SyncPolicy, ps.PendingBytes, ps.Fsyncs, ps.LastFsync.Microseconds(), ps.AvgFsync.Microseconds(),
+			ps.Checkpoint, boolToInt(ps.SnapshotInProgress), ps.SnapshotID, ps.SnapshotProgress*100, ps.Snapshots,
+			snapshotStatus, ps.LastSnapshotTime.Seconds(), ps.ChangesSinceSave, ps.LastSave.Unix())},
+		{"keyspace", keyspace},
+	}
+
+	want := make(map[string]bool, len(args))
+	for _, arg := range args {
+		want[strings.ToLower(arg.Str)] = true
+	}
+	all := len(want) == 0 || want["all"] || want["default"] || want["everything"]
 
-# Keyspace
-db0:keys=%d
-`, Version, uptime, connCount, stats.TotalCommands, stats.TotalReads, stats.TotalWrites, stats.KeysCount)
-
-	w.WriteBulkString([]byte(info))
+	var info strings.Builder
+	for _, sec := range sections {
+		if !all && !want[sec.name] {
+			continue
+		}
+		if info.Len() > 0 {
+			info.WriteString("\n")
+		}
+		fmt.Fprintf(&info, "# %s%s\n%s", strings.ToUpper(sec.name[:1]), sec.name[1:], sec.body)
+	}
+	w.WriteBulkString([]byte(info.String()))
 }
 
 func (s *Server) cmdTime(w *protocol.Writer) {
@@ -1409,6 +1707,83 @@ var readOnlyCmds = map[string]bool{
 	"ZRANGE": true, "ZREVRANGE": true, "ZRANGEBYSCORE": true,
 	"ZREVRANGEBYSCORE": true, "ZCOUNT": true, "SUBSCRIBE": true,
 	"PSUBSCRIBE": true, "PUBSUB": true, "SLOWLOG": true,
+	"XLEN": true, "XRANGE": true, "XREVRANGE": true, "XREAD": true,
+	"XPENDING": true, "GETBIT": true, "BITCOUNT": true, "BITPOS": true,
+	"BITFIELD_RO": true, "PFCOUNT": true, "GEOPOS": true, "GEODIST": true,
+	"GEOHASH": true, "GEOSEARCH": true, "JSON.GET": true, "JSON.MGET": true,
+	"JSON.TYPE": true, "JSON.ARRLEN": true, "FT.SEARCH": true, "FT.INFO": true,
+	"FT._LIST": true,
+}
+
+// denyOOMCmds are the commands that may add data, refused when memory is
+// over the limit and the eviction policy cannot free any.
+var denyOOMCmds = map[string]bool{
+	"SET": true, "SETNX": true, "SETEX": true, "PSETEX": true, "GETSET": true,
+	"MSET": true, "MSETNX": true, "APPEND": true, "SETRANGE": true,
+	"INCR": true, "INCRBY": true, "INCRBYFLOAT": true, "DECR": true,
+	"DECRBY": true, "SETBIT": true, "BITOP": true, "BITFIELD": true,
+	"PFADD": true, "PFMERGE": true, "GEOADD": true, "GEOSEARCHSTORE": true,
+	"JSON.SET": true, "JSON.ARRAPPEND": true, "JSON.ARRINSERT": true,
+	"JSON.NUMINCRBY": true, "JSON.NUMMULTBY": true, "FT.CREATE": true,
+	"RESTORE": true, "COPY": true, "ZADD": true, "ZINCRBY": true,
+	"HSET": true, "HMSET": true, "HSETNX": true, "HINCRBY": true,
+	"HINCRBYFLOAT": true, "LPUSH": true, "RPUSH": true, "LSET": true,
+	"LINSERT": true, "LMOVE": true, "BLMOVE": true, "SADD": true,
+	"XADD": true, "XGROUP": true, "XREADGROUP": true, "TS.ADD": true,
+}
+
+// commandArity is the number of arguments of each command, the name
+// included; -N means N or more. It is checked when a command is queued in
+// MULTI. MULTI, EXEC, DISCARD and WATCH are never queued. TestCommandArity
+// keeps it in step with the dispatch switch of executeCommand.
+var commandArity = map[string]int{
+	"PING": -1, "ECHO": 2, "QUIT": -1, "AUTH": -2, "SELECT": 2, "CLIENT": -2,
+	"UNWATCH": -1, "SET": -3, "GET": 2, "GETSET": 3, "GETEX": -2, "GETDEL": 2,
+	"GETRANGE": 4, "SETRANGE": 4, "SETNX": 3, "SETEX": 4, "PSETEX": 4,
+	"MSET": -3, "MGET": -2, "MSETNX": -3, "APPEND": 3, "STRLEN": 2, "INCR": 2,
+	"INCRBY": 3, "INCRBYFLOAT": 3, "DECR": 2, "DECRBY": 3, "SETBIT": 4,
+	"GETBIT": 3, "BITCOUNT": -2, "BITPOS": -3, "BITOP": -4, "BITFIELD": -2,
+	"BITFIELD_RO": -2, "PFADD": -2, "PFCOUNT": -2, "PFMERGE": -2, "GEOADD": -5,
+	"GEOPOS": -2, "GEODIST": -4, "GEOHASH": -2, "GEOSEARCH": -2,
+	"GEOSEARCHSTORE": -3, "JSON.SET": -4, "JSON.GET": -2, "JSON.MGET": -3,
+	"JSON.DEL": -2, "JSON.FORGET": -2, "JSON.TYPE": -2, "JSON.ARRAPPEND": -4,
+	"JSON.ARRINSERT": -5, "JSON.ARRLEN": -2, "JSON.ARRPOP": -2,
+	"JSON.ARRTRIM": 5, "JSON.NUMINCRBY": 4, "JSON.NUMMULTBY": 4,
+	"FT.CREATE": -4, "FT.DROPINDEX": -2, "FT.SEARCH": -3, "FT.INFO": 2,
+	"FT._LIST": 1, "DEL": -2, "UNLINK": -2, "EXISTS": -2, "KEYS": 2, "SCAN": -2,
+	"EXPIRE": 3, "PEXPIRE": 3, "EXPIREAT": 3, "PEXPIREAT": 3, "TTL": 2,
+	"PTTL": 2, "PERSIST": 2, "TYPE": 2, "RENAME": 3, "RENAMENX": 3,
+	"RANDOMKEY": -1, "TOUCH": -2, "OBJECT": -2, "DUMP": 2, "RESTORE": -4,
+	"COPY": -3, "PUBLISH": 3, "SUBSCRIBE": -2, "UNSUBSCRIBE": -2,
+	"PSUBSCRIBE": -2, "PUNSUBSCRIBE": -2, "PUBSUB": -2, "DBSIZE": 1,
+	"FLUSHDB": -1, "FLUSHALL": -1, "MOVE": 3, "SWAPDB": 3, "INFO": -1,
+	"TIME": -1, "COMMAND": -1, "CONFIG": -2, "DEBUG": -2, "MEMORY": -2,
+	"LASTSAVE": -1, "SAVE": -1, "BGSAVE": -1, "BGREWRITEAOF": -1, "SHUTDOWN": -1, "SLOWLOG": -2,
+	"ACL": -2, "ZADD": -4, "ZSCORE": 3, "ZREM": -3, "ZCARD": 2, "ZRANK": 3,
+	"ZREVRANK": 3, "ZRANGE": -4, "ZREVRANGE": -4, "ZRANGEBYSCORE": -4,
+	"ZREVRANGEBYSCORE": -4, "ZCOUNT": 4, "ZINCRBY": 4, "ZREMRANGEBYRANK": 4,
+	"ZREMRANGEBYSCORE": 4, "ZPOPMIN": -2, "ZPOPMAX": -2, "HSET": -4, "HGET": 3,
+	"HMSET": -4, "HMGET": -3, "HDEL": -3, "HEXISTS": 3, "HLEN": 2, "HGETALL": 2,
+	"HKEYS": 2, "HVALS": 2, "HINCRBY": 4, "HINCRBYFLOAT": 4, "HSETNX": 4,
+	"LPUSH": -3, "RPUSH": -3, "LPOP": 2, "RPOP": 2, "LLEN": 2, "LINDEX": 3,
+	"LSET": 4, "LRANGE": 4, "LINSERT": 5, "LREM": 4, "LTRIM": 4, "LMOVE": 5,
+	"LMPOP": -4, "BLPOP": -3, "BRPOP": -3, "BLMOVE": 6, "BLMPOP": -5,
+	"XADD": -5, "XLEN": 2, "XRANGE": -4, "XREVRANGE": -4, "XDEL": -3,
+	"XTRIM": -4, "XSETID": -3, "XREAD": -4, "XREADGROUP": -7, "XACK": -4,
+	"XPENDING": -3, "XCLAIM": -6, "XGROUP": -2, "SADD": -3, "SREM": -3,
+	"SISMEMBER": 3, "SCARD": 2, "SMEMBERS": 2, "SRANDMEMBER": -2, "SPOP": -2,
+	"SINTER": -2, "SUNION": -2, "SDIFF": -2, "TS.ADD": -4, "TS.GET": 2,
+	"TS.RANGE": 4, "TS.INFO": 2, "TS.DEL": 2, "TS.KEYS": -1, "HOTKEYS": -1,
+	"SNAPSHOT": -2, "CDC": -2, "BENCHMARK": -1, "EVAL": -3, "EVALSHA": -3,
+	"SCRIPT": -2,
+}
+
+// noMultiCmds are the commands refused inside MULTI: they start or wait
+// for background work that needs the engine, which would capture the
+// transaction's uncommitted state, or bypass the transaction's WAL batch.
+var noMultiCmds = map[string]bool{
+	"SAVE": true, "BGSAVE": true, "BGREWRITEAOF": true, "SNAPSHOT": true,
+	"BENCHMARK": true, "SHUTDOWN": true,
 }
 
 func (s *Server) aclAllowed(u *ACLUser, cmd string) bool {
@@ -1534,27 +1909,6 @@ func (s *Server) cmdSlowLog(w *protocol.Writer, args []protocol.Value) {
 	}
 }
 
-// SELECT command (for compatibility - we only have db0)
-func (s *Server) cmdSelect(w *protocol.Writer, args []protocol.Value) {
-	if len(args) != 1 {
-		w.WriteError("wrong number of arguments for 'SELECT' command")
-		return
-	}
-
-	db, err := strconv.Atoi(args[0].Str)
-	if err != nil || db < 0 {
-		w.WriteError("invalid DB index")
-		return
-	}
-
-	if db != 0 {
-		w.WriteError("invalid DB index")
-		return
-	}
-
-	w.WriteSimpleString("OK")
-}
-
 // CLIENT command
 func (s *Server) cmdClient(w *protocol.Writer, client *clientConn, args []protocol.Value) {
 	if len(args) == 0 {
@@ -1824,12 +2178,66 @@ func (s *Server) cmdConfig(w *protocol.Writer, args []protocol.Value) {
 			} else {
 				w.WriteStringArray([]string{"requirepass", ""})
 			}
+		case "maxmemory":
+			w.WriteStringArray([]string{"maxmemory", strconv.FormatInt(s.engine.Memory().MaxMemory, 10)})
+		case "maxmemory-policy":
+			w.WriteStringArray([]string{"maxmemory-policy", s.engine.Memory().Policy.String()})
+		case "maxmemory-samples":
+			w.WriteStringArray([]string{"maxmemory-samples", strconv.Itoa(s.engine.Memory().Samples)})
+		case "notify-keyspace-events":
+			w.WriteStringArray([]string{"notify-keyspace-events", s.engine.KeyspaceEvents().String()})
+		case "lua-time-limit":
+			ms := time.Duration(s.scripts.timeLimit.Load()).Milliseconds()
+			w.WriteStringArray([]string{"lua-time-limit", strconv.FormatInt(ms, 10)})
 		default:
 			w.WriteStringArray([]string{})
 		}
 
 	case "SET":
-		// Read-only config for now
+		if len(args) != 3 {
+			w.WriteError("wrong number of arguments for 'CONFIG SET'")
+			return
+		}
+		// Only the memory, notification and script settings can change at
+		// runtime; the rest is accepted and ignored.
+		param, value := strings.ToLower(args[1].Str), args[2].Str
+		switch param {
+		case "maxmemory":
+			n, err := engine.ParseMemory(value)
+			if err != nil {
+				w.WriteError("Invalid argument '" + value + "' for CONFIG SET 'maxmemory'")
+				return
+			}
+			s.engine.SetMaxMemory(n)
+		case "maxmemory-policy":
+			p, ok := store.ParseEvictionPolicy(strings.ToLower(value))
+			if !ok {
+				w.WriteError("Invalid argument '" + value + "' for CONFIG SET 'maxmemory-policy'")
+				return
+			}
+			s.engine.SetEvictionPolicy(p)
+		case "maxmemory-samples":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				w.WriteError("Invalid argument '" + value + "' for CONFIG SET 'maxmemory-samples'")
+				return
+			}
+			s.engine.SetMaxMemorySamples(n)
+		case "notify-keyspace-events":
+			ev, err := engine.ParseKeyspaceEvents(value)
+			if err != nil {
+				w.WriteError("Invalid argument '" + value + "' for CONFIG SET 'notify-keyspace-events'")
+				return
+			}
+			s.engine.SetKeyspaceEvents(ev)
+		case "lua-time-limit":
+			ms, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || ms <= 0 {
+				w.WriteError("Invalid argument '" + value + "' for CONFIG SET 'lua-time-limit'")
+				return
+			}
+			s.scripts.timeLimit.Store(int64(time.Duration(ms) * time.Millisecond))
+		}
 		w.WriteSimpleString("OK")
 
 	case "RESETSTAT":
@@ -1882,15 +2290,12 @@ func (s *Server) cmdMemory(w *protocol.Writer, args []protocol.Value) {
 			w.WriteError("wrong number of arguments for 'MEMORY USAGE'")
 			return
 		}
-		key := args[1].Str
-		val, exists := s.engine.Get(key)
-		if !exists {
+		usage, ok := s.engine.MemoryUsage(args[1].Str)
+		if !ok {
 			w.WriteNull()
 			return
 		}
-		// Rough estimate: key length + value length + overhead
-		usage := len(key) + len(val) + 64
-		w.WriteInteger(int64(usage))
+		w.WriteInteger(usage)
 
 	case "STATS":
 		var m runtime.MemStats
@@ -1915,30 +2320,126 @@ keys.bytes-per-key:64
 	}
 }
 
-// LASTSAVE command
+// humanBytes formats n like Redis' *_human INFO fields, e.g. "1.50M".
+func humanBytes(n int64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return strconv.FormatInt(n, 10) + "B"
+	}
+	v, i := float64(n)/1024, 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.2f%c", v, units[i])
+}
+
+// LASTSAVE command — the time of the last successful snapshot or WAL rewrite.
 func (s *Server) cmdLastSave(w *protocol.Writer) {
-	// Return current time as we persist immediately via WAL
-	w.WriteInteger(time.Now().Unix())
+	w.WriteInteger(s.engine.LastSave().Unix())
 }
 
-// SAVE/BGSAVE command
+// SAVE command — compacts the WAL and waits for it to finish.
 func (s *Server) cmdSave(w *protocol.Writer) {
-	// No-op as we use WAL for persistence
+	if err := s.engine.Rewrite(); err != nil {
+		s.writeRewriteError(w, err)
+		return
+	}
 	w.WriteSimpleString("OK")
 }
 
+// BGSAVE/BGREWRITEAOF command — compacts the WAL in the background.
+func (s *Server) cmdBgSave(w *protocol.Writer, cmd string) {
+	if err := s.engine.BackgroundRewrite(); err != nil {
+		s.writeRewriteError(w, err)
+		return
+	}
+	if cmd == "BGREWRITEAOF" {
+		w.WriteSimpleString("Background append only file rewriting started")
+		return
+	}
+	w.WriteSimpleString("Background saving started")
+}
+
+// SHUTDOWN [NOSAVE|SAVE] — disconnects every client and stops the server.
+// Every write is in the WAL already; SAVE also rewrites it first. NOSAVE
+// stops a running script, even one that wrote, and drops its writes.
+func (s *Server) cmdShutdown(w *protocol.Writer, args []protocol.Value) {
+	var save, nosave bool
+	if len(args) > 1 {
+		w.WriteError("syntax error")
+		return
+	}
+	if len(args) == 1 {
+		switch strings.ToUpper(args[0].Str) {
+		case "SAVE":
+			save = true
+		case "NOSAVE":
+			nosave = true
+		default:
+			w.WriteError("syntax error")
+			return
+		}
+	}
+
+	if nosave {
+		s.scripts.abandon()
+	}
+	if save {
+		if err := s.engine.Rewrite(); err != nil {
+			s.logger.Error("WAL rewrite before shutdown failed", "error", err)
+			w.WriteError("Errors trying to SHUTDOWN. Check logs.")
+			return
+		}
+	}
+	s.logger.Info("shutting down", "save", save)
+	// The connection running this command is one of those to close.
+	go s.shutdown()
+}
+
+func (s *Server) writeRewriteError(w *protocol.Writer, err error) {
+	if errors.Is(err, engine.ErrRewriteInProgress) {
+		w.WriteError("Background rewrite already in progress")
+		return
+	}
+	s.logger.Error("WAL rewrite failed", "error", err)
+	w.WriteError("WAL rewrite failed")
+}
+
 // Transaction commands
 
 func (s *Server) cmdMulti(w *protocol.Writer, client *clientConn) {
 	if client.inMulti {
+		client.multiError = true
 		w.WriteError("MULTI calls can not be nested")
 		return
 	}
 	client.inMulti = true
 	client.multiQueue = make([]queuedCommand, 0)
+	client.multiError = false
 	w.WriteSimpleString("OK")
 }
 
+// queueCommand queues a command for EXEC. Unknown commands, commands with
+// the wrong number of arguments and commands not allowed in a transaction
+// are refused now, and make EXEC discard the transaction.
+func (s *Server) queueCommand(w *protocol.Writer, client *clientConn, cmd string, args []protocol.Value) {
+	arity, ok := commandArity[cmd]
+	switch {
+	case !ok:
+		w.WriteError(fmt.Sprintf("unknown command '%s'", cmd))
+	case arity > 0 && len(args)+1 != arity, arity < 0 && len(args)+1 < -arity:
+		w.WriteError(fmt.Sprintf("wrong number of arguments for '%s' command", cmd))
+	case noMultiCmds[cmd]:
+		w.WriteError("Command not allowed inside a transaction")
+	default:
+		client.multiQueue = append(client.multiQueue, queuedCommand{cmd: cmd, args: args})
+		w.WriteSimpleString("QUEUED")
+		return
+	}
+	client.multiError = true
+}
+
 func (s *Server) cmdExec(w *protocol.Writer, client *clientConn) {
 	if !client.inMulti {
 		w.WriteError("EXEC without MULTI")
@@ -1947,14 +2448,37 @@ func (s *Server) cmdExec(w *protocol.Writer, client *clientConn) {
 
 	// Save the queue and reset transaction state BEFORE executing
 	queue := client.multiQueue
+	failed := client.multiError
 	client.inMulti = false
 	client.multiQueue = nil
+	client.multiError = false
+	if failed {
+		s.engine.Unwatch(&client.watch)
+		w.WriteErrorCode("EXECABORT", "Transaction discarded because of previous errors.")
+		return
+	}
+
+	// Nothing else runs until the transaction commits, and its writes
+	// reach the WAL as one batch.
+	tx := s.engine.Begin()
+	db, err := tx.DB(client.db)
+	if err != nil {
+		tx.Commit()
+		s.writeEngineError(w, "EXEC", err)
+		return
+	}
 
-	// Acquire engine-level lock for isolation
-	s.engine.ExecLock()
-	defer s.engine.ExecUnlock()
+	// A watched key changed since WATCH: abort with a null array.
+	dirty := db.WatchDirty(&client.watch)
+	db.Unwatch(&client.watch)
+	if dirty {
+		tx.Commit()
+		w.WriteArrayHeader(-1)
+		return
+	}
 
-	// Execute all queued commands under the engine lock
+	client.tx = tx
+	client.inExec = true
 	results := make([][]byte, len(queue))
 	for i, qc := range queue {
 		// Create a buffer to capture the response
@@ -1963,6 +2487,13 @@ func (s *Server) cmdExec(w *protocol.Writer, client *clientConn) {
 		s.executeCommand(tempWriter, client, qc.cmd, qc.args)
 		results[i] = []byte(buf.String())
 	}
+	client.tx = nil
+	client.inExec = false
+
+	if err := tx.Commit(); err != nil {
+		s.writeEngineError(w, "EXEC", err)
+		return
+	}
 
 	// Write the array of results
 	w.WriteArrayHeader(len(results))
@@ -1978,6 +2509,31 @@ func (s *Server) cmdDiscard(w *protocol.Writer, client *clientConn) {
 	}
 	client.inMulti = false
 	client.multiQueue = nil
+	client.multiError = false
+	s.engine.Unwatch(&client.watch)
+	w.WriteSimpleString("OK")
+}
+
+func (s *Server) cmdWatch(w *protocol.Writer, client *clientConn, args []protocol.Value) {
+	if len(args) < 1 {
+		w.WriteError("wrong number of arguments for 'WATCH' command")
+		return
+	}
+	if client.inMulti {
+		client.multiError = true
+		w.WriteError("WATCH inside MULTI is not allowed")
+		return
+	}
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = arg.Str
+	}
+	s.engine.Watch(&client.watch, keys...)
+	w.WriteSimpleString("OK")
+}
+
+func (s *Server) cmdUnwatch(w *protocol.Writer, client *clientConn) {
+	s.engine.Unwatch(&client.watch)
 	w.WriteSimpleString("OK")
 }
 
@@ -2146,6 +2702,7 @@ func (ps *PubSub) Subscribe(client *clientConn, channel string) {
 		ps.channels[channel] = make(map[*clientConn]bool)
 	}
 	ps.channels[channel][client] = true
+	ps.openOutbox(client)
 }
 
 func (ps *PubSub) Unsubscribe(client *clientConn, channel string) {
@@ -2166,8 +2723,10 @@ func (ps *PubSub) PSubscribe(client *clientConn, pattern string) {
 
 	if ps.patterns[pattern] == nil {
 		ps.patterns[pattern] = make(map[*clientConn]bool)
+		ps.matchers[pattern] = globRegexp(pattern)
 	}
 	ps.patterns[pattern][client] = true
+	ps.openOutbox(client)
 }
 
 func (ps *PubSub) PUnsubscribe(client *clientConn, pattern string) {
@@ -2178,6 +2737,7 @@ func (ps *PubSub) PUnsubscribe(client *clientConn, pattern string) {
 		delete(ps.patterns[pattern], client)
 		if len(ps.patterns[pattern]) == 0 {
 			delete(ps.patterns, pattern)
+			delete(ps.matchers, pattern)
 		}
 	}
 }
@@ -2190,17 +2750,19 @@ func (ps *PubSub) Publish(channel string, message string) int {
 
 	// Send to direct subscribers
 	if subscribers, ok := ps.channels[channel]; ok {
+		msg := encodeMessage("message", channel, message)
 		for client := range subscribers {
-			ps.sendMessage(client, "message", channel, message)
+			ps.deliver(client, msg)
 			count++
 		}
 	}
 
 	// Send to pattern subscribers
 	for pattern, subscribers := range ps.patterns {
-		if ps.matchPattern(pattern, channel) {
+		if ps.matchers[pattern].MatchString(channel) {
+			msg := encodeMessage("pmessage", pattern, channel, message)
 			for client := range subscribers {
-				ps.sendPMessage(client, pattern, channel, message)
+				ps.deliver(client, msg)
 				count++
 			}
 		}
@@ -2209,31 +2771,51 @@ func (ps *PubSub) Publish(channel string, message string) int {
 	return count
 }
 
-func (ps *PubSub) sendMessage(client *clientConn, msgType, channel, message string) {
+// encodeMessage returns a pub/sub message as a RESP array of bulk strings.
+func encodeMessage(parts ...string) []byte {
 	var buf bytes.Buffer
 	w := protocol.NewWriter(&buf)
-	w.WriteArrayHeader(3)
-	w.WriteBulkString([]byte(msgType))
-	w.WriteBulkString([]byte(channel))
-	w.WriteBulkString([]byte(message))
+	w.WriteArrayHeader(len(parts))
+	for _, part := range parts {
+		w.WriteBulkString([]byte(part))
+	}
+	return buf.Bytes()
+}
 
-	client.writeMu.Lock()
-	defer client.writeMu.Unlock()
-	_ = writeAll(client.conn, buf.Bytes())
+// openOutbox gives client an outbox and starts writing it to the
+// connection, unless it has one already (must hold ps.mu).
+func (ps *PubSub) openOutbox(client *clientConn) {
+	if client.outbox != nil {
+		return
+	}
+	client.outbox = make(chan []byte, outboxSize)
+	go client.writeOutbox(client.outbox)
 }
 
-func (ps *PubSub) sendPMessage(client *clientConn, pattern, channel, message string) {
-	var buf bytes.Buffer
-	w := protocol.NewWriter(&buf)
-	w.WriteArrayHeader(4)
-	w.WriteBulkString([]byte("pmessage"))
-	w.WriteBulkString([]byte(pattern))
-	w.WriteBulkString([]byte(channel))
-	w.WriteBulkString([]byte(message))
+// deliver queues msg in the outbox of client, disconnecting the client if
+// it is full (must hold ps.mu).
+func (ps *PubSub) deliver(client *clientConn, msg []byte) {
+	select {
+	case client.outbox <- msg:
+	default:
+		client.conn.Close()
+	}
+}
 
-	client.writeMu.Lock()
-	defer client.writeMu.Unlock()
-	_ = writeAll(client.conn, buf.Bytes())
+// writeOutbox writes the messages queued in outbox to the connection until
+// the outbox is closed. A write that fails or times out closes the
+// connection.
+func (c *clientConn) writeOutbox(outbox chan []byte) {
+	for msg := range outbox {
+		c.writeMu.Lock()
+		c.conn.SetWriteDeadline(time.Now().Add(outboxWriteTimeout))
+		err := writeAll(c.conn, msg)
+		c.conn.SetWriteDeadline(time.Time{})
+		c.writeMu.Unlock()
+		if err != nil {
+			c.conn.Close()
+		}
+	}
 }
 
 func writeAll(conn net.Conn, data []byte) error {
@@ -2247,9 +2829,8 @@ func writeAll(conn net.Conn, data []byte) error {
 	return nil
 }
 
-func (ps *PubSub) matchPattern(pattern, channel string) bool {
-	// Simple glob pattern matching
-	// Convert glob to regex
+// globRegexp compiles a glob-style pattern, with * and ?, to a regexp.
+func globRegexp(pattern string) *regexp.Regexp {
 	regexStr := "^"
 	for _, c := range pattern {
 		switch c {
@@ -2265,8 +2846,8 @@ func (ps *PubSub) matchPattern(pattern, channel string) bool {
 	}
 	regexStr += "$"
 
-	matched, _ := regexp.MatchString(regexStr, channel)
-	return matched
+	// Every special character is escaped, so this always compiles.
+	return regexp.MustCompile(regexStr)
 }
 
 func (ps *PubSub) Channels(pattern string) []string {
@@ -2274,8 +2855,9 @@ func (ps *PubSub) Channels(pattern string) []string {
 	defer ps.mu.RUnlock()
 
 	result := make([]string, 0)
+	re := globRegexp(pattern)
 	for channel := range ps.channels {
-		if pattern == "*" || ps.matchPattern(pattern, channel) {
+		if pattern == "*" || re.MatchString(channel) {
 			result = append(result, channel)
 		}
 	}
@@ -2320,8 +2902,15 @@ func (ps *PubSub) UnsubscribeAll(client *clientConn) {
 		delete(subscribers, client)
 		if len(subscribers) == 0 {
 			delete(ps.patterns, pattern)
+			delete(ps.matchers, pattern)
 		}
 	}
+
+	// Stop writing messages once those queued are out
+	if client.outbox != nil {
+		close(client.outbox)
+		client.outbox = nil
+	}
 }
 
 // Additional String commands
@@ -2336,7 +2925,11 @@ func (s *Server) cmdGetSet(w *protocol.Writer, args []protocol.Value) {
 	newValue := []byte(args[1].Str)
 
 	// Get old value
-	oldValue, exists := s.engine.Get(key)
+	oldValue, exists, err := s.engine.Get(key)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
 
 	// Set new value
 	if err := s.engine.Set(key, newValue); err != nil {
@@ -2358,7 +2951,11 @@ func (s *Server) cmdGetEx(w *protocol.Writer, args []protocol.Value) {
 	}
 
 	key := args[0].Str
-	value, exists := s.engine.Get(key)
+	value, exists, err := s.engine.Get(key)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
 
 	if !exists {
 		w.WriteNull()
@@ -2472,7 +3069,11 @@ func (s *Server) cmdGetDel(w *protocol.Writer, args []protocol.Value) {
 	}
 
 	key := args[0].Str
-	value, exists := s.engine.Get(key)
+	value, exists, err := s.engine.Get(key)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
 
 	if !exists {
 		w.WriteNull()
@@ -2498,7 +3099,11 @@ func (s *Server) cmdGetRange(w *protocol.Writer, args []protocol.Value) {
 		return
 	}
 
-	value, exists := s.engine.Get(key)
+	value, exists, err := s.engine.Get(key)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
 	if !exists {
 		w.WriteBulkString([]byte{})
 		return
@@ -2547,27 +3152,13 @@ func (s *Server) cmdSetRange(w *protocol.Writer, args []protocol.Value) {
 		return
 	}
 
-	replacement := []byte(args[2].Str)
-
-	value, _ := s.engine.Get(key)
-
-	// Extend value if necessary
-	neededLen := offset + len(replacement)
-	if neededLen > len(value) {
-		newValue := make([]byte, neededLen)
-		copy(newValue, value)
-		value = newValue
-	}
-
-	// Apply replacement
-	copy(value[offset:], replacement)
-
-	if err := s.engine.Set(key, value); err != nil {
-		w.WriteError("internal error")
+	length, err := s.engine.SetRange(key, offset, []byte(args[2].Str))
+	if err != nil {
+		s.writeEngineError(w, "SETRANGE", err)
 		return
 	}
 
-	w.WriteInteger(int64(len(value)))
+	w.WriteInteger(int64(length))
 }
 
 func (s *Server) cmdMSetNX(w *protocol.Writer, args []protocol.Value) {
@@ -2609,7 +3200,7 @@ func (s *Server) cmdIncrByFloat(w *protocol.Writer, args []protocol.Value) {
 
 	newValue, err := s.engine.IncrByFloat(key, increment)
 	if err != nil {
-		w.WriteError(err.Error())
+		writeErrorReply(w, err.Error())
 		return
 	}
 
@@ -2661,16 +3252,24 @@ func (s *Server) cmdObject(w *protocol.Writer, args []protocol.Value) {
 			w.WriteError("wrong number of arguments for 'OBJECT FREQ'")
 			return
 		}
-		// Return 0 as we don't track access frequency
-		w.WriteInteger(0)
+		_, freq, ok := s.engine.ObjectAccess(args[1].Str)
+		if !ok {
+			w.WriteNull()
+			return
+		}
+		w.WriteInteger(int64(freq))
 
 	case "IDLETIME":
 		if len(args) != 2 {
 			w.WriteError("wrong number of arguments for 'OBJECT IDLETIME'")
 			return
 		}
-		// Return 0 as we don't track idle time
-		w.WriteInteger(0)
+		idle, _, ok := s.engine.ObjectAccess(args[1].Str)
+		if !ok {
+			w.WriteNull()
+			return
+		}
+		w.WriteInteger(int64(idle / time.Second))
 
 	case "REFCOUNT":
 		if len(args) != 2 {
@@ -2704,15 +3303,65 @@ func (s *Server) cmdDump(w *protocol.Writer, args []protocol.Value) {
 		return
 	}
 
-	key := args[0].Str
-	value, exists := s.engine.Get(key)
+	payload, exists, err := s.engine.Dump(args[0].Str)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
 	if !exists {
 		w.WriteNull()
 		return
 	}
+	w.WriteBulkString(payload)
+}
+
+// RESTORE key ttl payload [REPLACE] [ABSTTL]
+func (s *Server) cmdRestore(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 3 {
+		w.WriteError("wrong number of arguments for 'RESTORE' command")
+		return
+	}
 
-	// Return raw value (simplified dump - real Redis uses RDB format)
-	w.WriteBulkString(value)
+	key := args[0].Str
+	ttl, err := strconv.ParseInt(args[1].Str, 10, 64)
+	if err != nil {
+		w.WriteError("value is not an integer or out of range")
+		return
+	}
+	if ttl < 0 {
+		w.WriteError("Invalid TTL value, must be >= 0")
+		return
+	}
+	replace, absTTL := false, false
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Str) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absTTL = true
+		default:
+			w.WriteError("syntax error")
+			return
+		}
+	}
+
+	// A TTL of 0 means no expiry.
+	expireAt := ttl
+	if ttl > 0 && !absTTL {
+		expireAt = time.Now().UnixMilli() + ttl
+	}
+
+	err = s.engine.Restore(key, []byte(args[2].Str), expireAt, replace)
+	switch {
+	case err == nil:
+		w.WriteSimpleString("OK")
+	case errors.Is(err, engine.ErrBusyKey):
+		w.WriteErrorCode("BUSYKEY", "Target key name already exists.")
+	case errors.Is(err, snapshot.ErrBadPayload):
+		w.WriteError("DUMP payload version or checksum are wrong")
+	default:
+		writeErrorReply(w, err.Error())
+	}
 }
 
 func (s *Server) cmdCopy(w *protocol.Writer, args []protocol.Value) {
@@ -2762,7 +3411,7 @@ func (s *Server) cmdZAdd(w *protocol.Writer, args []protocol.Value) {
 	// Parse score-member pairs (must have even number after key)
 	for i := 1; i+1 < len(args); i += 2 {
 		score, err := strconv.ParseFloat(args[i].Str, 64)
-		if err != nil {
+		if err != nil || math.IsNaN(score) {
 			w.WriteError("value is not a valid float")
 			return
 		}
@@ -2779,8 +3428,7 @@ func (s *Server) cmdZAdd(w *protocol.Writer, args []protocol.Value) {
 
 	added, err := s.engine.ZAdd(key, members...)
 	if err != nil {
-		w.WriteError("internal error")
-		log.Printf("server: ZADD error: %v", err)
+		s.writeEngineError(w, "ZADD", err)
 		return
 	}
 	w.WriteInteger(int64(added))
@@ -2795,7 +3443,11 @@ func (s *Server) cmdZScore(w *protocol.Writer, args []protocol.Value) {
 	key := args[0].Str
 	member := args[1].Str
 
-	score, exists := s.engine.ZScore(key, member)
+	score, exists, err := s.engine.ZScore(key, member)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
 	if !exists {
 		w.WriteNull()
 		return
@@ -2817,8 +3469,7 @@ func (s *Server) cmdZRem(w *protocol.Writer, args []protocol.Value) {
 
 	removed, err := s.engine.ZRem(key, members...)
 	if err != nil {
-		w.WriteError("internal error")
-		log.Printf("server: ZREM error: %v", err)
+		s.writeEngineError(w, "ZREM", err)
 		return
 	}
 	w.WriteInteger(int64(removed))
@@ -2830,7 +3481,11 @@ func (s *Server) cmdZCard(w *protocol.Writer, args []protocol.Value) {
 		return
 	}
 
-	card := s.engine.ZCard(args[0].Str)
+	card, err := s.engine.ZCard(args[0].Str)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
 	w.WriteInteger(int64(card))
 }
 
@@ -2840,7 +3495,11 @@ func (s *Server) cmdZRank(w *protocol.Writer, args []protocol.Value) {
 		return
 	}
 
-	rank, exists := s.engine.ZRank(args[0].Str, args[1].Str)
+	rank, exists, err := s.engine.ZRank(args[0].Str, args[1].Str)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
 	if !exists {
 		w.WriteNull()
 		return
@@ -2854,7 +3513,11 @@ func (s *Server) cmdZRevRank(w *protocol.Writer, args []protocol.Value) {
 		return
 	}
 
-	rank, exists := s.engine.ZRevRank(args[0].Str, args[1].Str)
+	rank, exists, err := s.engine.ZRevRank(args[0].Str, args[1].Str)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
 	if !exists {
 		w.WriteNull()
 		return
@@ -2885,7 +3548,11 @@ func (s *Server) cmdZRange(w *protocol.Writer, args []protocol.Value) {
 		withScores = true
 	}
 
-	members := s.engine.ZRange(key, start, stop, withScores)
+	members, err := s.engine.ZRange(key, start, stop, withScores)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
 	s.writeZRangeResult(w, members, withScores)
 }
 
@@ -2912,7 +3579,11 @@ func (s *Server) cmdZRevRange(w *protocol.Writer, args []protocol.Value) {
 		withScores = true
 	}
 
-	members := s.engine.ZRevRange(key, start, stop, withScores)
+	members, err := s.engine.ZRevRange(key, start, stop, withScores)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
 	s.writeZRangeResult(w, members, withScores)
 }
 
@@ -2949,7 +3620,11 @@ func (s *Server) cmdZRangeByScore(w *protocol.Writer, args []protocol.Value) {
 		}
 	}
 
-	members := s.engine.ZRangeByScore(key, min, max, withScores, offset, count)
+	members, err := s.engine.ZRangeByScore(key, min, max, withScores, offset, count)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
 	s.writeZRangeResult(w, members, withScores)
 }
 
@@ -2986,7 +3661,11 @@ func (s *Server) cmdZRevRangeByScore(w *protocol.Writer, args []protocol.Value)
 		}
 	}
 
-	members := s.engine.ZRevRangeByScore(key, max, min, withScores, offset, count)
+	members, err := s.engine.ZRevRangeByScore(key, max, min, withScores, offset, count)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
 	s.writeZRangeResult(w, members, withScores)
 }
 
@@ -3008,7 +3687,11 @@ func (s *Server) cmdZCount(w *protocol.Writer, args []protocol.Value) {
 		return
 	}
 
-	count := s.engine.ZCount(key, min, max)
+	count, err := s.engine.ZCount(key, min, max)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
 	w.WriteInteger(int64(count))
 }
 
@@ -3020,7 +3703,7 @@ func (s *Server) cmdZIncrBy(w *protocol.Writer, args []protocol.Value) {
 
 	key := args[0].Str
 	increment, err := strconv.ParseFloat(args[1].Str, 64)
-	if err != nil {
+	if err != nil || math.IsNaN(increment) {
 		w.WriteError("value is not a valid float")
 		return
 	}
@@ -3028,8 +3711,11 @@ func (s *Server) cmdZIncrBy(w *protocol.Writer, args []protocol.Value) {
 
 	newScore, err := s.engine.ZIncrBy(key, member, increment)
 	if err != nil {
-		w.WriteError("internal error")
-		log.Printf("server: ZINCRBY error: %v", err)
+		s.writeEngineError(w, "ZINCRBY", err)
+		return
+	}
+	if math.IsNaN(newScore) {
+		w.WriteError("resulting score is not a number (NaN)")
 		return
 	}
 	w.WriteBulkString([]byte(strconv.FormatFloat(newScore, 'f', -1, 64)))
@@ -3055,8 +3741,7 @@ func (s *Server) cmdZRemRangeByRank(w *protocol.Writer, args []protocol.Value) {
 
 	removed, err := s.engine.ZRemRangeByRank(key, start, stop)
 	if err != nil {
-		w.WriteError("internal error")
-		log.Printf("server: ZREMRANGEBYRANK error: %v", err)
+		s.writeEngineError(w, "ZREMRANGEBYRANK", err)
 		return
 	}
 	w.WriteInteger(int64(removed))
@@ -3082,8 +3767,7 @@ func (s *Server) cmdZRemRangeByScore(w *protocol.Writer, args []protocol.Value)
 
 	removed, err := s.engine.ZRemRangeByScore(key, min, max)
 	if err != nil {
-		w.WriteError("internal error")
-		log.Printf("server: ZREMRANGEBYSCORE error: %v", err)
+		s.writeEngineError(w, "ZREMRANGEBYSCORE", err)
 		return
 	}
 	w.WriteInteger(int64(removed))
@@ -3108,8 +3792,7 @@ func (s *Server) cmdZPopMin(w *protocol.Writer, args []protocol.Value) {
 
 	members, err := s.engine.ZPopMin(key, count)
 	if err != nil {
-		w.WriteError("internal error")
-		log.Printf("server: ZPOPMIN error: %v", err)
+		s.writeEngineError(w, "ZPOPMIN", err)
 		return
 	}
 	s.writeZRangeResult(w, members, true)
@@ -3134,13 +3817,84 @@ func (s *Server) cmdZPopMax(w *protocol.Writer, args []protocol.Value) {
 
 	members, err := s.engine.ZPopMax(key, count)
 	if err != nil {
-		w.WriteError("internal error")
-		log.Printf("server: ZPOPMAX error: %v", err)
+		s.writeEngineError(w, "ZPOPMAX", err)
 		return
 	}
 	s.writeZRangeResult(w, members, true)
 }
 
+// writeEngineError reports a failed engine call. Errors caused by the command,
+// such as type mismatches, are returned to the client as-is; anything else is
+// logged and reported as an internal error.
+func (s *Server) writeEngineError(w *protocol.Writer, cmd string, err error) {
+	for _, clientErr := range clientErrors {
+		if errors.Is(err, clientErr) {
+			writeErrorReply(w, err.Error())
+			return
+		}
+	}
+	w.WriteError("internal error")
+	log.Printf("server: %s error: %v", cmd, err)
+}
+
+// errorCodes are the error codes clients tell errors apart by, which error
+// replies carry in place of ERR.
+var errorCodes = map[string]bool{
+	"WRONGTYPE": true, "BUSYKEY": true, "NOGROUP": true, "BUSYGROUP": true,
+	"OOM": true, "EXECABORT": true, "NOSCRIPT": true, "BUSY": true,
+	"NOTBUSY": true, "UNKILLABLE": true,
+}
+
+// writeErrorReply writes msg as an error reply, with its code in place of
+// ERR if it starts with one of errorCodes.
+func writeErrorReply(w *protocol.Writer, msg string) {
+	code, rest, ok := strings.Cut(msg, " ")
+	switch {
+	case ok && errorCodes[code]:
+		w.WriteErrorCode(code, rest)
+	case ok && code == "ERR":
+		w.WriteError(rest)
+	default:
+		w.WriteError(msg)
+	}
+}
+
+// clientErrors are engine errors caused by the command, which are reported
+// to the client as they are.
+var clientErrors = []error{
+	store.ErrWrongType,
+	store.ErrInvalidStreamID,
+	store.ErrNoGroup,
+	store.ErrBusyGroup,
+	store.ErrNoStream,
+	store.ErrStreamIDTooSmall,
+	store.ErrStreamIDZero,
+	store.ErrStreamSetIDTooSmall,
+	store.ErrStreamExhausted,
+	store.ErrStringTooLong,
+	store.ErrNotHLL,
+	store.ErrHLLCorrupt,
+	store.ErrGeoNoMember,
+	store.ErrJSONSyntax,
+	store.ErrJSONNoPath,
+	store.ErrJSONWrongType,
+	store.ErrJSONNoKey,
+	store.ErrJSONNewAtRoot,
+	store.ErrJSONIndex,
+	store.ErrJSONNaN,
+	search.ErrIndexExists,
+	search.ErrNoIndex,
+	search.ErrInvalid,
+	engine.ErrOOM,
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 // Helper functions for sorted sets
 
 func (s *Server) writeZRangeResult(w *protocol.Writer, members []store.ScoredMember, withScores bool) {
@@ -3188,7 +3942,7 @@ func (s *Server) cmdHSet(w *protocol.Writer, args []protocol.Value) {
 	}
 	n, err := s.engine.HSet(key, fields...)
 	if err != nil {
-		w.WriteError("internal error")
+		s.writeEngineError(w, "HSET", err)
 		return
 	}
 	w.WriteInteger(int64(n))
@@ -3199,7 +3953,11 @@ func (s *Server) cmdHGet(w *protocol.Writer, args []protocol.Value) {
 		w.WriteError("wrong number of arguments for 'HGET' command")
 		return
 	}
-	val, ok := s.engine.HGet(args[0].Str, args[1].Str)
+	val, ok, err := s.engine.HGet(args[0].Str, args[1].Str)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
 	if !ok {
 		w.WriteNull()
 		return
@@ -3218,7 +3976,7 @@ func (s *Server) cmdHMSet(w *protocol.Writer, args []protocol.Value) {
 		fields = append(fields, store.HashFieldValue{Field: args[i].Str, Value: []byte(args[i+1].Str)})
 	}
 	if _, err := s.engine.HSet(key, fields...); err != nil {
-		w.WriteError("internal error")
+		s.writeEngineError(w, "HMSET", err)
 		return
 	}
 	w.WriteSimpleString("OK")
@@ -3233,7 +3991,11 @@ func (s *Server) cmdHMGet(w *protocol.Writer, args []protocol.Value) {
 	results := make([][]byte, len(args)-1)
 	nulls := make([]bool, len(args)-1)
 	for i := 1; i < len(args); i++ {
-		val, ok := s.engine.HGet(key, args[i].Str)
+		val, ok, err := s.engine.HGet(key, args[i].Str)
+		if err != nil {
+			writeErrorReply(w, err.Error())
+			return
+		}
 		if ok {
 			results[i-1] = val
 		} else {
@@ -3255,7 +4017,7 @@ func (s *Server) cmdHDel(w *protocol.Writer, args []protocol.Value) {
 	}
 	n, err := s.engine.HDel(key, fields...)
 	if err != nil {
-		w.WriteError("internal error")
+		s.writeEngineError(w, "HDEL", err)
 		return
 	}
 	w.WriteInteger(int64(n))
@@ -3266,7 +4028,12 @@ func (s *Server) cmdHExists(w *protocol.Writer, args []protocol.Value) {
 		w.WriteError("wrong number of arguments for 'HEXISTS' command")
 		return
 	}
-	if s.engine.HExists(args[0].Str, args[1].Str) {
+	exists, err := s.engine.HExists(args[0].Str, args[1].Str)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
+	if exists {
 		w.WriteInteger(1)
 	} else {
 		w.WriteInteger(0)
@@ -3278,7 +4045,12 @@ func (s *Server) cmdHLen(w *protocol.Writer, args []protocol.Value) {
 		w.WriteError("wrong number of arguments for 'HLEN' command")
 		return
 	}
-	w.WriteInteger(int64(s.engine.HLen(args[0].Str)))
+	n, err := s.engine.HLen(args[0].Str)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
+	w.WriteInteger(int64(n))
 }
 
 func (s *Server) cmdHGetAll(w *protocol.Writer, args []protocol.Value) {
@@ -3286,7 +4058,11 @@ func (s *Server) cmdHGetAll(w *protocol.Writer, args []protocol.Value) {
 		w.WriteError("wrong number of arguments for 'HGETALL' command")
 		return
 	}
-	pairs := s.engine.HGetAll(args[0].Str)
+	pairs, err := s.engine.HGetAll(args[0].Str)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
 	w.WriteArrayHeader(len(pairs) * 2)
 	for _, p := range pairs {
 		w.WriteBulkString([]byte(p.Field))
@@ -3299,7 +4075,12 @@ func (s *Server) cmdHKeys(w *protocol.Writer, args []protocol.Value) {
 		w.WriteError("wrong number of arguments for 'HKEYS' command")
 		return
 	}
-	w.WriteStringArray(s.engine.HKeys(args[0].Str))
+	items, err := s.engine.HKeys(args[0].Str)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
+	w.WriteStringArray(items)
 }
 
 func (s *Server) cmdHVals(w *protocol.Writer, args []protocol.Value) {
@@ -3307,7 +4088,12 @@ func (s *Server) cmdHVals(w *protocol.Writer, args []protocol.Value) {
 		w.WriteError("wrong number of arguments for 'HVALS' command")
 		return
 	}
-	w.WriteArray(s.engine.HVals(args[0].Str))
+	items, err := s.engine.HVals(args[0].Str)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
+	w.WriteArray(items)
 }
 
 func (s *Server) cmdHIncrBy(w *protocol.Writer, args []protocol.Value) {
@@ -3322,7 +4108,7 @@ func (s *Server) cmdHIncrBy(w *protocol.Writer, args []protocol.Value) {
 	}
 	result, err := s.engine.HIncrBy(args[0].Str, args[1].Str, delta)
 	if err != nil {
-		w.WriteError(err.Error())
+		writeErrorReply(w, err.Error())
 		return
 	}
 	w.WriteInteger(result)
@@ -3340,7 +4126,7 @@ func (s *Server) cmdHIncrByFloat(w *protocol.Writer, args []protocol.Value) {
 	}
 	result, err := s.engine.HIncrByFloat(args[0].Str, args[1].Str, delta)
 	if err != nil {
-		w.WriteError(err.Error())
+		writeErrorReply(w, err.Error())
 		return
 	}
 	w.WriteBulkString([]byte(strconv.FormatFloat(result, 'f', -1, 64)))
@@ -3353,7 +4139,7 @@ func (s *Server) cmdHSetNX(w *protocol.Writer, args []protocol.Value) {
 	}
 	ok, err := s.engine.HSetNX(args[0].Str, args[1].Str, []byte(args[2].Str))
 	if err != nil {
-		w.WriteError("internal error")
+		s.writeEngineError(w, "HSETNX", err)
 		return
 	}
 	if ok {
@@ -3377,7 +4163,7 @@ func (s *Server) cmdLPush(w *protocol.Writer, args []protocol.Value) {
 	}
 	n, err := s.engine.LPush(key, values...)
 	if err != nil {
-		w.WriteError("internal error")
+		s.writeEngineError(w, "LPUSH", err)
 		return
 	}
 	w.WriteInteger(int64(n))
@@ -3395,7 +4181,7 @@ func (s *Server) cmdRPush(w *protocol.Writer, args []protocol.Value) {
 	}
 	n, err := s.engine.RPush(key, values...)
 	if err != nil {
-		w.WriteError("internal error")
+		s.writeEngineError(w, "RPUSH", err)
 		return
 	}
 	w.WriteInteger(int64(n))
@@ -3408,7 +4194,7 @@ func (s *Server) cmdLPop(w *protocol.Writer, args []protocol.Value) {
 	}
 	val, ok, err := s.engine.LPop(args[0].Str)
 	if err != nil {
-		w.WriteError("internal error")
+		s.writeEngineError(w, "LPOP", err)
 		return
 	}
 	if !ok {
@@ -3425,7 +4211,7 @@ func (s *Server) cmdRPop(w *protocol.Writer, args []protocol.Value) {
 	}
 	val, ok, err := s.engine.RPop(args[0].Str)
 	if err != nil {
-		w.WriteError("internal error")
+		s.writeEngineError(w, "RPOP", err)
 		return
 	}
 	if !ok {
@@ -3440,7 +4226,12 @@ func (s *Server) cmdLLen(w *protocol.Writer, args []protocol.Value) {
 		w.WriteError("wrong number of arguments for 'LLEN' command")
 		return
 	}
-	w.WriteInteger(int64(s.engine.LLen(args[0].Str)))
+	n, err := s.engine.LLen(args[0].Str)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
+	w.WriteInteger(int64(n))
 }
 
 func (s *Server) cmdLIndex(w *protocol.Writer, args []protocol.Value) {
@@ -3453,7 +4244,11 @@ func (s *Server) cmdLIndex(w *protocol.Writer, args []protocol.Value) {
 		w.WriteError("value is not an integer or out of range")
 		return
 	}
-	val, ok := s.engine.LIndex(args[0].Str, index)
+	val, ok, err := s.engine.LIndex(args[0].Str, index)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
 	if !ok {
 		w.WriteNull()
 		return
@@ -3472,7 +4267,7 @@ func (s *Server) cmdLSet(w *protocol.Writer, args []protocol.Value) {
 		return
 	}
 	if err := s.engine.LSet(args[0].Str, index, []byte(args[2].Str)); err != nil {
-		w.WriteError(err.Error())
+		writeErrorReply(w, err.Error())
 		return
 	}
 	w.WriteSimpleString("OK")
@@ -3493,7 +4288,11 @@ func (s *Server) cmdLRange(w *protocol.Writer, args []protocol.Value) {
 		w.WriteError("value is not an integer or out of range")
 		return
 	}
-	items := s.engine.LRange(args[0].Str, start, stop)
+	items, err := s.engine.LRange(args[0].Str, start, stop)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
 	w.WriteArray(items)
 }
 
@@ -3515,7 +4314,7 @@ func (s *Server) cmdLInsert(w *protocol.Writer, args []protocol.Value) {
 	}
 	n, err := s.engine.LInsert(args[0].Str, before, []byte(args[2].Str), []byte(args[3].Str))
 	if err != nil {
-		w.WriteError(err.Error())
+		writeErrorReply(w, err.Error())
 		return
 	}
 	w.WriteInteger(int64(n))
@@ -3533,7 +4332,7 @@ func (s *Server) cmdLRem(w *protocol.Writer, args []protocol.Value) {
 	}
 	n, err := s.engine.LRem(args[0].Str, count, []byte(args[2].Str))
 	if err != nil {
-		w.WriteError(err.Error())
+		writeErrorReply(w, err.Error())
 		return
 	}
 	w.WriteInteger(int64(n))
@@ -3555,12 +4354,57 @@ func (s *Server) cmdLTrim(w *protocol.Writer, args []protocol.Value) {
 		return
 	}
 	if err := s.engine.LTrim(args[0].Str, start, stop); err != nil {
-		w.WriteError(err.Error())
+		writeErrorReply(w, err.Error())
 		return
 	}
 	w.WriteSimpleString("OK")
 }
 
+func (s *Server) cmdLMove(w *protocol.Writer, args []protocol.Value) {
+	if len(args) != 4 {
+		w.WriteError("wrong number of arguments for 'LMOVE' command")
+		return
+	}
+	fromLeft, ok1 := parseListEnd(args[2].Str)
+	toLeft, ok2 := parseListEnd(args[3].Str)
+	if !ok1 || !ok2 {
+		w.WriteError("syntax error")
+		return
+	}
+	val, ok, err := s.engine.LMove(args[0].Str, args[1].Str, fromLeft, toLeft)
+	if err != nil {
+		s.writeEngineError(w, "LMOVE", err)
+		return
+	}
+	if !ok {
+		w.WriteNull()
+		return
+	}
+	w.WriteBulkString(val)
+}
+
+func (s *Server) cmdLMPop(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 3 {
+		w.WriteError("wrong number of arguments for 'LMPOP' command")
+		return
+	}
+	keys, left, count, msg := parseLMPopArgs(args)
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+	key, vals, err := s.engine.LMPop(keys, left, count)
+	if err != nil {
+		s.writeEngineError(w, "LMPOP", err)
+		return
+	}
+	if key == "" {
+		w.WriteArrayHeader(-1)
+		return
+	}
+	writeLMPopResult(w, key, vals)
+}
+
 // ─── Set commands ───────────────────────────────────────────────────────────
 
 func (s *Server) cmdSAdd(w *protocol.Writer, args []protocol.Value) {
@@ -3575,7 +4419,7 @@ func (s *Server) cmdSAdd(w *protocol.Writer, args []protocol.Value) {
 	}
 	n, err := s.engine.SAdd(key, members...)
 	if err != nil {
-		w.WriteError("internal error")
+		s.writeEngineError(w, "SADD", err)
 		return
 	}
 	w.WriteInteger(int64(n))
@@ -3593,7 +4437,7 @@ func (s *Server) cmdSRem(w *protocol.Writer, args []protocol.Value) {
 	}
 	n, err := s.engine.SRem(key, members...)
 	if err != nil {
-		w.WriteError("internal error")
+		s.writeEngineError(w, "SREM", err)
 		return
 	}
 	w.WriteInteger(int64(n))
@@ -3604,7 +4448,12 @@ func (s *Server) cmdSIsMember(w *protocol.Writer, args []protocol.Value) {
 		w.WriteError("wrong number of arguments for 'SISMEMBER' command")
 		return
 	}
-	if s.engine.SIsMember(args[0].Str, args[1].Str) {
+	exists, err := s.engine.SIsMember(args[0].Str, args[1].Str)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
+	if exists {
 		w.WriteInteger(1)
 	} else {
 		w.WriteInteger(0)
@@ -3616,7 +4465,12 @@ func (s *Server) cmdSCard(w *protocol.Writer, args []protocol.Value) {
 		w.WriteError("wrong number of arguments for 'SCARD' command")
 		return
 	}
-	w.WriteInteger(int64(s.engine.SCard(args[0].Str)))
+	n, err := s.engine.SCard(args[0].Str)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
+	w.WriteInteger(int64(n))
 }
 
 func (s *Server) cmdSMembers(w *protocol.Writer, args []protocol.Value) {
@@ -3624,7 +4478,12 @@ func (s *Server) cmdSMembers(w *protocol.Writer, args []protocol.Value) {
 		w.WriteError("wrong number of arguments for 'SMEMBERS' command")
 		return
 	}
-	w.WriteStringArray(s.engine.SMembers(args[0].Str))
+	items, err := s.engine.SMembers(args[0].Str)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
+	w.WriteStringArray(items)
 }
 
 func (s *Server) cmdSRandMember(w *protocol.Writer, args []protocol.Value) {
@@ -3641,7 +4500,11 @@ func (s *Server) cmdSRandMember(w *protocol.Writer, args []protocol.Value) {
 			return
 		}
 	}
-	members := s.engine.SRandMember(args[0].Str, count)
+	members, err := s.engine.SRandMember(args[0].Str, count)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
 	if len(args) == 1 {
 		// Single element mode: return bulk string or nil
 		if len(members) == 0 {
@@ -3674,7 +4537,7 @@ func (s *Server) cmdSPop(w *protocol.Writer, args []protocol.Value) {
 	}
 	members, err := s.engine.SPop(args[0].Str, count)
 	if err != nil {
-		w.WriteError("internal error")
+		s.writeEngineError(w, "SPOP", err)
 		return
 	}
 	if len(args) == 1 {
@@ -3698,7 +4561,12 @@ func (s *Server) cmdSInter(w *protocol.Writer, args []protocol.Value) {
 	for i, a := range args {
 		keys[i] = a.Str
 	}
-	w.WriteStringArray(s.engine.SInter(keys...))
+	items, err := s.engine.SInter(keys...)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
+	w.WriteStringArray(items)
 }
 
 func (s *Server) cmdSUnion(w *protocol.Writer, args []protocol.Value) {
@@ -3710,7 +4578,12 @@ func (s *Server) cmdSUnion(w *protocol.Writer, args []protocol.Value) {
 	for i, a := range args {
 		keys[i] = a.Str
 	}
-	w.WriteStringArray(s.engine.SUnion(keys...))
+	items, err := s.engine.SUnion(keys...)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
+	w.WriteStringArray(items)
 }
 
 func (s *Server) cmdSDiff(w *protocol.Writer, args []protocol.Value) {
@@ -3722,7 +4595,12 @@ func (s *Server) cmdSDiff(w *protocol.Writer, args []protocol.Value) {
 	for i, a := range args {
 		keys[i] = a.Str
 	}
-	w.WriteStringArray(s.engine.SDiff(keys...))
+	items, err := s.engine.SDiff(keys...)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
+	w.WriteStringArray(items)
 }
 
 // ========================
@@ -3769,7 +4647,7 @@ func (s *Server) cmdTSAdd(w *protocol.Writer, args []protocol.Value) {
 
 	inserted, err := s.engine.TSAdd(key, ts, val, retention)
 	if err != nil {
-		w.WriteError(err.Error())
+		writeErrorReply(w, err.Error())
 		return
 	}
 	w.WriteInteger(inserted)
@@ -3809,7 +4687,7 @@ func (s *Server) cmdTSRange(w *protocol.Writer, args []protocol.Value) {
 
 	points, err := s.engine.TSRange(args[0].Str, fromTS, toTS)
 	if err != nil {
-		w.WriteError(err.Error())
+		writeErrorReply(w, err.Error())
 		return
 	}
 
@@ -3828,7 +4706,7 @@ func (s *Server) cmdTSInfo(w *protocol.Writer, args []protocol.Value) {
 	}
 	info, err := s.engine.TSInfo(args[0].Str)
 	if err != nil {
-		w.WriteError(err.Error())
+		writeErrorReply(w, err.Error())
 		return
 	}
 	w.WriteArrayHeader(10)
@@ -3851,7 +4729,7 @@ func (s *Server) cmdTSDel(w *protocol.Writer, args []protocol.Value) {
 	}
 	ok, err := s.engine.TSDel(args[0].Str)
 	if err != nil {
-		w.WriteError(err.Error())
+		writeErrorReply(w, err.Error())
 		return
 	}
 	if ok {
@@ -3903,27 +4781,39 @@ func (s *Server) cmdSnapshot(w *protocol.Writer, args []protocol.Value) {
 		if len(args) >= 2 {
 			id = args[1].Str
 		}
-		meta, err := s.engine.SnapshotCreate(id)
+		meta, err := s.engine.BackgroundSnapshot(id)
+		if errors.Is(err, engine.ErrSnapshotInProgress) {
+			w.WriteError("snapshot already in progress")
+			return
+		}
 		if err != nil {
-			w.WriteError(err.Error())
+			writeErrorReply(w, err.Error())
 			return
 		}
 		w.WriteArrayHeader(4)
 		w.WriteBulkString([]byte("id"))
 		w.WriteBulkString([]byte(meta.ID))
+		w.WriteBulkString([]byte("status"))
+		w.WriteBulkString([]byte("started"))
 	case "LIST":
 		metas, err := s.engine.SnapshotList()
 		if err != nil {
-			w.WriteError(err.Error())
+			writeErrorReply(w, err.Error())
 			return
 		}
 		w.WriteArrayHeader(len(metas))
 		for _, m := range metas {
-			w.WriteArrayHeader(6)
+			w.WriteArrayHeader(10)
 			w.WriteBulkString([]byte("id"))
 			w.WriteBulkString([]byte(m.ID))
 			w.WriteBulkString([]byte("size"))
 			w.WriteInteger(m.SizeBytes)
+			w.WriteBulkString([]byte("created"))
+			w.WriteInteger(m.CreatedAt.Unix())
+			w.WriteBulkString([]byte("status"))
+			w.WriteBulkString([]byte(m.Status))
+			w.WriteBulkString([]byte("progress"))
+			w.WriteBulkString([]byte(strconv.FormatFloat(m.Progress, 'f', 2, 64)))
 		}
 	case "RESTORE":
 		if len(args) < 2 {
@@ -3931,7 +4821,7 @@ func (s *Server) cmdSnapshot(w *protocol.Writer, args []protocol.Value) {
 			return
 		}
 		if err := s.engine.SnapshotRestore(args[1].Str); err != nil {
-			w.WriteError(err.Error())
+			writeErrorReply(w, err.Error())
 			return
 		}
 		w.WriteSimpleString("OK")
@@ -3941,7 +4831,7 @@ func (s *Server) cmdSnapshot(w *protocol.Writer, args []protocol.Value) {
 			return
 		}
 		if err := s.engine.SnapshotDelete(args[1].Str); err != nil {
-			w.WriteError(err.Error())
+			writeErrorReply(w, err.Error())
 			return
 		}
 		w.WriteSimpleString("OK")
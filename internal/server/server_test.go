@@ -2,11 +2,17 @@ package server
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -254,6 +260,66 @@ func TestServer_TYPE(t *testing.T) {
 	assert.Equal(t, "none", resp)
 }
 
+func TestServer_WRONGTYPE(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	sendCommand(t, addr, "SET", "str", "value")
+	sendCommand(t, addr, "HSET", "hash", "f", "v")
+
+	resp := sendCommand(t, addr, "HSET", "str", "f", "v")
+	assert.Equal(t, "ERR: WRONGTYPE Operation against a key holding the wrong kind of value", resp)
+
+	// The error carries its code in place of ERR.
+	c := dialTestConn(t, addr)
+	assert.Equal(t, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", c.doRaw("GET", "hash"))
+	assert.Equal(t, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", c.doRaw("INCR", "hash"))
+
+	resp = sendCommand(t, addr, "LPUSH", "hash", "a")
+	assert.Contains(t, resp, "WRONGTYPE")
+
+	resp = sendCommand(t, addr, "SMEMBERS", "str")
+	assert.Contains(t, resp, "WRONGTYPE")
+
+	// The rejected writes must not have changed anything
+	resp = sendCommand(t, addr, "GET", "str")
+	assert.Equal(t, "value", resp)
+
+	// MGET reports non-string keys as nil rather than failing
+	resp = sendCommand(t, addr, "MGET", "str", "hash")
+	assert.Equal(t, "value,(nil)", resp)
+
+	// SET overwrites a key regardless of its type
+	resp = sendCommand(t, addr, "SET", "hash", "now-a-string")
+	assert.Equal(t, "OK", resp)
+	resp = sendCommand(t, addr, "TYPE", "hash")
+	assert.Equal(t, "string", resp)
+}
+
+func TestServer_KeyspaceAcrossTypes(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	sendCommand(t, addr, "SET", "s", "v")
+	sendCommand(t, addr, "HSET", "h", "f", "v")
+	sendCommand(t, addr, "RPUSH", "l", "a")
+	sendCommand(t, addr, "SADD", "set", "m")
+	sendCommand(t, addr, "ZADD", "z", "1", "m")
+
+	resp := sendCommand(t, addr, "DBSIZE")
+	assert.Equal(t, "5", resp)
+
+	resp = sendCommand(t, addr, "KEYS", "*")
+	keys := strings.Split(resp, ",")
+	assert.ElementsMatch(t, []string{"s", "h", "l", "set", "z"}, keys)
+
+	resp = sendCommand(t, addr, "DEL", "h", "l", "set", "z")
+	assert.Equal(t, "4", resp)
+
+	resp = sendCommand(t, addr, "DBSIZE")
+	assert.Equal(t, "1", resp)
+}
+
 func TestServer_UnknownCommand(t *testing.T) {
 	s, _ := setupTestServer(t)
 	addr := startTestServer(t, s)
@@ -285,6 +351,96 @@ func TestServer_SELECT(t *testing.T) {
 	assert.Equal(t, "OK", resp)
 }
 
+func TestServer_Databases(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+	c := dialTestConn(t, addr)
+
+	assert.Contains(t, c.do("SELECT", "16").Str, "DB index is out of range")
+	assert.Contains(t, c.do("SELECT", "one").Str, "not an integer")
+
+	c.do("SET", "k", "zero")
+	assert.Equal(t, "OK", c.do("SELECT", "1").Str)
+	assert.True(t, c.do("GET", "k").Null)
+	c.do("SET", "k", "one")
+	c.do("SET", "only1", "x", "EX", "100")
+	assert.Equal(t, int64(2), c.do("DBSIZE").Num)
+
+	// Other connections start in database 0.
+	assert.Equal(t, "zero", sendCommand(t, addr, "GET", "k"))
+	assert.Equal(t, "1", sendCommand(t, addr, "DBSIZE"))
+
+	info := c.do("INFO").Str
+	assert.Contains(t, info, "db0:keys=1,expires=0")
+	assert.Contains(t, info, "db1:keys=2,expires=1")
+
+	// A section argument selects that section alone.
+	assert.Equal(t, "# Keyspace\ndb0:keys=1,expires=0\ndb1:keys=2,expires=1\n", c.do("INFO", "keyspace").Str)
+	info = c.do("INFO", "MEMORY", "keyspace").Str
+	assert.True(t, strings.HasPrefix(info, "# Memory\n"))
+	assert.Contains(t, info, "\n\n# Keyspace\ndb0:keys=1")
+	assert.NotContains(t, info, "# Server")
+	assert.Empty(t, c.do("INFO", "nosuchsection").Str)
+	assert.Contains(t, c.do("INFO", "all").Str, "# Persistence")
+
+	// MOVE takes the key with its TTL, and never overwrites.
+	assert.Equal(t, int64(1), c.do("MOVE", "only1", "0").Num)
+	assert.Equal(t, int64(0), c.do("MOVE", "k", "0").Num)
+	assert.Equal(t, int64(0), c.do("MOVE", "missing", "0").Num)
+	assert.Contains(t, c.do("MOVE", "k", "1").Str, "same")
+	assert.Contains(t, c.do("MOVE", "k", "99").Str, "out of range")
+	assert.Equal(t, "x", sendCommand(t, addr, "GET", "only1"))
+	ttl, err := strconv.Atoi(sendCommand(t, addr, "TTL", "only1"))
+	require.NoError(t, err)
+	assert.InDelta(t, 100, ttl, 2)
+
+	assert.Equal(t, "OK", c.do("SWAPDB", "0", "1").Str)
+	assert.Equal(t, "zero", c.do("GET", "k").Str)
+	assert.Equal(t, "one", sendCommand(t, addr, "GET", "k"))
+	assert.Contains(t, c.do("SWAPDB", "0", "16").Str, "out of range")
+
+	// A transaction follows SELECT.
+	c.do("MULTI")
+	c.do("SELECT", "2")
+	c.do("SET", "k", "two")
+	resp := c.do("EXEC")
+	require.Len(t, resp.Array, 2)
+	assert.Equal(t, "two", c.do("GET", "k").Str)
+
+	// FLUSHDB empties one database, FLUSHALL all of them.
+	assert.Equal(t, "OK", c.do("FLUSHDB").Str)
+	assert.Equal(t, int64(0), c.do("DBSIZE").Num)
+	assert.Equal(t, "1", sendCommand(t, addr, "DBSIZE"))
+	assert.Equal(t, "OK", c.do("FLUSHALL").Str)
+	assert.Equal(t, "0", sendCommand(t, addr, "DBSIZE"))
+	assert.NotContains(t, c.do("INFO").Str, "keys=")
+}
+
+func TestServer_BlockingPerDatabase(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	c := dialTestConn(t, addr)
+	c.do("SELECT", "1")
+	c.send("BLPOP", "q", "2")
+	require.Eventually(t, func() bool {
+		s.blocking.mu.Lock()
+		defer s.blocking.mu.Unlock()
+		return len(s.blocking.waiters[dbKey{1, "q"}]) == 1
+	}, 2*time.Second, 5*time.Millisecond)
+
+	// A push to the same key in another database does not serve it.
+	sendCommand(t, addr, "RPUSH", "q", "db0")
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, "1", sendCommand(t, addr, "LLEN", "q"))
+
+	// Swapping brings the pushed list into database 1.
+	sendCommand(t, addr, "SWAPDB", "0", "1")
+	resp := c.read()
+	require.Len(t, resp.Array, 2)
+	assert.Equal(t, "db0", resp.Array[1].Str)
+}
+
 func TestServer_CONFIG(t *testing.T) {
 	s, _ := setupTestServer(t)
 	addr := startTestServer(t, s)
@@ -302,6 +458,49 @@ func TestServer_MEMORY(t *testing.T) {
 	assert.NotEmpty(t, resp)
 }
 
+func TestServer_MaxMemory(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+	c := dialTestConn(t, addr)
+
+	c.do("SET", "small", "v")
+	c.do("SET", "large", strings.Repeat("x", 1000))
+	small, large := c.do("MEMORY", "USAGE", "small").Num, c.do("MEMORY", "USAGE", "large").Num
+	assert.Greater(t, large, small+900)
+	assert.True(t, c.do("MEMORY", "USAGE", "missing").Null)
+
+	info := c.do("INFO").Str
+	assert.Contains(t, info, "used_memory:"+strconv.FormatInt(small+large, 10))
+	assert.Contains(t, info, "maxmemory:0")
+	assert.Contains(t, info, "maxmemory_policy:noeviction")
+
+	// Under noeviction, writes that add data are refused; reads and deletes
+	// are not.
+	assert.Equal(t, "OK", c.do("CONFIG", "SET", "maxmemory", "1kb").Str)
+	assert.Equal(t, []protocol.Value{{Type: protocol.TypeBulkString, Str: "maxmemory"}, {Type: protocol.TypeBulkString, Str: "1024"}},
+		c.do("CONFIG", "GET", "maxmemory").Array)
+	assert.Equal(t, "-OOM command not allowed when used memory > 'maxmemory'.\r\n", c.doRaw("SET", "more", "v"))
+	assert.Contains(t, c.do("LPUSH", "list", "v").Str, "OOM command not allowed")
+	assert.Equal(t, "v", c.do("GET", "small").Str)
+	assert.Equal(t, int64(1), c.do("DEL", "large").Num)
+	assert.Equal(t, "OK", c.do("SET", "more", "v").Str)
+
+	// Under allkeys-lru, old keys make room for new ones.
+	assert.Contains(t, c.do("CONFIG", "SET", "maxmemory-policy", "lru").Str, "Invalid argument")
+	assert.Equal(t, "OK", c.do("CONFIG", "SET", "maxmemory-policy", "allkeys-lru").Str)
+	assert.Equal(t, "OK", c.do("CONFIG", "SET", "maxmemory-samples", "10").Str)
+	for i := 0; i < 50; i++ {
+		assert.Equal(t, "OK", c.do("SET", fmt.Sprintf("key:%d", i), strings.Repeat("x", 100)).Str)
+	}
+	info = c.do("INFO").Str
+	assert.Contains(t, info, "maxmemory_policy:allkeys-lru")
+	assert.NotContains(t, info, "evicted_keys:0\n")
+	assert.Less(t, c.do("DBSIZE").Num, int64(50))
+	assert.Greater(t, s.engine.GetStats().EvictedKeys, int64(0))
+
+	assert.Equal(t, "OK", c.do("CONFIG", "SET", "maxmemory", "0").Str)
+}
+
 func TestServer_TIME(t *testing.T) {
 	s, _ := setupTestServer(t)
 	addr := startTestServer(t, s)
@@ -492,6 +691,11 @@ func TestServer_OBJECT(t *testing.T) {
 
 	sendCommand(t, addr, "SET", "objkey", "value")
 
+	// A new key starts with the initial LFU counter and no idle time.
+	assert.Equal(t, "5", sendCommand(t, addr, "OBJECT", "FREQ", "objkey"))
+	assert.Equal(t, "0", sendCommand(t, addr, "OBJECT", "IDLETIME", "objkey"))
+	assert.Equal(t, "(nil)", sendCommand(t, addr, "OBJECT", "FREQ", "missing"))
+
 	resp := sendCommand(t, addr, "OBJECT", "ENCODING", "objkey")
 	assert.Equal(t, "raw", resp)
 
@@ -596,3 +800,1222 @@ func TestWALFilePath(t *testing.T) {
 
 // Suppress unused import warning
 var _ = bufio.Reader{}
+
+func TestServer_ExpireAcrossTypes(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	sendCommand(t, addr, "HSET", "session", "user", "alice")
+	sendCommand(t, addr, "ZADD", "ratelimit", "1", "req1")
+
+	resp := sendCommand(t, addr, "EXPIRE", "ratelimit", "100")
+	assert.Equal(t, "1", resp)
+	resp = sendCommand(t, addr, "TTL", "ratelimit")
+	assert.NotEqual(t, "-1", resp)
+	resp = sendCommand(t, addr, "PERSIST", "ratelimit")
+	assert.Equal(t, "1", resp)
+	resp = sendCommand(t, addr, "TTL", "ratelimit")
+	assert.Equal(t, "-1", resp)
+
+	resp = sendCommand(t, addr, "PEXPIRE", "session", "50")
+	assert.Equal(t, "1", resp)
+	time.Sleep(80 * time.Millisecond)
+
+	resp = sendCommand(t, addr, "HGET", "session", "user")
+	assert.Equal(t, "(nil)", resp)
+	resp = sendCommand(t, addr, "EXISTS", "session")
+	assert.Equal(t, "0", resp)
+	resp = sendCommand(t, addr, "TTL", "session")
+	assert.Equal(t, "-2", resp)
+}
+
+func TestServer_SaveRewritesWAL(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	for i := 0; i < 20; i++ {
+		sendCommand(t, addr, "SET", "key", strconv.Itoa(i))
+	}
+
+	resp := sendCommand(t, addr, "SAVE")
+	assert.Equal(t, "OK", resp)
+
+	resp = sendCommand(t, addr, "INFO")
+	assert.Contains(t, resp, "aof_rewrites:1")
+	assert.Contains(t, resp, "aof_last_bgrewrite_status:ok")
+	assert.Contains(t, resp, "aof_fsync_policy:always")
+	assert.Contains(t, resp, "aof_pending_bytes:0")
+	assert.Contains(t, resp, "rdb_bgsave_in_progress:0")
+	assert.Contains(t, resp, "rdb_changes_since_last_save:0")
+
+	resp = sendCommand(t, addr, "LASTSAVE")
+	lastSave, err := strconv.ParseInt(resp, 10, 64)
+	assert.NoError(t, err)
+	assert.Equal(t, s.engine.LastSave().Unix(), lastSave)
+
+	resp = sendCommand(t, addr, "BGREWRITEAOF")
+	assert.Contains(t, resp, "rewriting started")
+
+	resp = sendCommand(t, addr, "GET", "key")
+	assert.Equal(t, "19", resp)
+}
+
+func TestServer_DumpRestore(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	sendCommand(t, addr, "HSET", "h", "f1", "v1", "f2", "v2")
+	payload := sendCommand(t, addr, "DUMP", "h")
+	assert.NotEqual(t, "(nil)", payload)
+	assert.Equal(t, "(nil)", sendCommand(t, addr, "DUMP", "missing"))
+
+	assert.Equal(t, "OK", sendCommand(t, addr, "RESTORE", "h2", "0", payload))
+	assert.Equal(t, "v2", sendCommand(t, addr, "HGET", "h2", "f2"))
+	assert.Equal(t, "-1", sendCommand(t, addr, "TTL", "h2"))
+
+	c := dialTestConn(t, addr)
+	assert.Equal(t, "-BUSYKEY Target key name already exists.\r\n", c.doRaw("RESTORE", "h2", "0", payload))
+	assert.Equal(t, "OK", sendCommand(t, addr, "RESTORE", "h2", "10000", payload, "REPLACE"))
+	ttl, _ := strconv.Atoi(sendCommand(t, addr, "TTL", "h2"))
+	assert.InDelta(t, 10, ttl, 1)
+
+	abs := strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10)
+	assert.Equal(t, "OK", sendCommand(t, addr, "RESTORE", "h3", abs, payload, "ABSTTL"))
+	ttl, _ = strconv.Atoi(sendCommand(t, addr, "TTL", "h3"))
+	assert.InDelta(t, 3600, ttl, 2)
+
+	// A TTL of 0 restores without expiry, whatever the dumped key had.
+	payload = sendCommand(t, addr, "DUMP", "h3")
+	assert.Equal(t, "OK", sendCommand(t, addr, "RESTORE", "h4", "0", payload))
+	assert.Equal(t, "-1", sendCommand(t, addr, "TTL", "h4"))
+	assert.Equal(t, "OK", sendCommand(t, addr, "RESTORE", "h4", "0", payload, "REPLACE", "ABSTTL"))
+	assert.Equal(t, "-1", sendCommand(t, addr, "TTL", "h4"))
+
+	resp := sendCommand(t, addr, "RESTORE", "bad", "0", "garbage")
+	assert.Equal(t, "ERR: ERR DUMP payload version or checksum are wrong", resp)
+	resp = sendCommand(t, addr, "RESTORE", "bad", "-1", payload)
+	assert.Equal(t, "ERR: ERR Invalid TTL value, must be >= 0", resp)
+	resp = sendCommand(t, addr, "RESTORE", "bad", "0", payload, "NOSUCHOPT")
+	assert.Equal(t, "ERR: ERR syntax error", resp)
+}
+
+func TestServer_ZINCRBY_NaN(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	sendCommand(t, addr, "ZADD", "z", "inf", "m")
+	resp := sendCommand(t, addr, "ZINCRBY", "z", "-inf", "m")
+	assert.Equal(t, "ERR: ERR resulting score is not a number (NaN)", resp)
+}
+
+// testConn is a persistent client connection for tests that need one.
+type testConn struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *protocol.Reader
+	writer *protocol.Writer
+	raw    bytes.Buffer // bytes received since the last command was sent
+}
+
+func dialTestConn(t *testing.T, addr string) *testConn {
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	c := &testConn{t: t, conn: conn, writer: protocol.NewWriter(conn)}
+	c.reader = protocol.NewReader(io.TeeReader(conn, &c.raw))
+	return c
+}
+
+func (c *testConn) send(args ...string) {
+	byteArgs := make([][]byte, len(args))
+	for i, arg := range args {
+		byteArgs[i] = []byte(arg)
+	}
+	c.raw.Reset()
+	require.NoError(c.t, c.writer.WriteArray(byteArgs))
+}
+
+func (c *testConn) read() protocol.Value {
+	val, err := c.reader.ReadValue()
+	require.NoError(c.t, err)
+	return val
+}
+
+func (c *testConn) do(args ...string) protocol.Value {
+	c.send(args...)
+	return c.read()
+}
+
+// doRaw sends a command and returns its reply as it came over the wire.
+func (c *testConn) doRaw(args ...string) string {
+	c.do(args...)
+	return c.raw.String()
+}
+
+// waitBlocked waits until n clients are blocked on key.
+func waitBlocked(t *testing.T, s *Server, key string, n int) {
+	require.Eventually(t, func() bool {
+		s.blocking.mu.Lock()
+		defer s.blocking.mu.Unlock()
+		return len(s.blocking.waiters[dbKey{0, key}]) == n
+	}, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestServer_LMoveLMPop(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	sendCommand(t, addr, "RPUSH", "src", "a", "b", "c")
+	assert.Equal(t, "c", sendCommand(t, addr, "LMOVE", "src", "dst", "RIGHT", "LEFT"))
+	assert.Equal(t, "(nil)", sendCommand(t, addr, "LMOVE", "missing", "dst", "LEFT", "LEFT"))
+	assert.Contains(t, sendCommand(t, addr, "LMOVE", "src", "dst", "UP", "LEFT"), "syntax error")
+
+	c := dialTestConn(t, addr)
+	resp := c.do("LMPOP", "2", "missing", "src", "LEFT", "COUNT", "5")
+	require.Len(t, resp.Array, 2)
+	assert.Equal(t, "src", resp.Array[0].Str)
+	require.Len(t, resp.Array[1].Array, 2)
+	assert.Equal(t, "a", resp.Array[1].Array[0].Str)
+	assert.Equal(t, "b", resp.Array[1].Array[1].Str)
+
+	assert.True(t, c.do("LMPOP", "1", "src", "LEFT").Null)
+	assert.Contains(t, c.do("LMPOP", "0", "src", "LEFT").Str, "numkeys")
+	assert.Contains(t, c.do("LMPOP", "1", "src", "LEFT", "COUNT", "0").Str, "count")
+}
+
+func TestServer_BlockingPops(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	// A push from another connection wakes the blocked client.
+	c := dialTestConn(t, addr)
+	c.send("BLPOP", "k1", "k2", "0")
+	waitBlocked(t, s, "k2", 1)
+	assert.Equal(t, "1", sendCommand(t, addr, "RPUSH", "k2", "v"))
+	resp := c.read()
+	require.Len(t, resp.Array, 2)
+	assert.Equal(t, "k2", resp.Array[0].Str)
+	assert.Equal(t, "v", resp.Array[1].Str)
+	assert.Equal(t, "0", sendCommand(t, addr, "EXISTS", "k2"))
+
+	// Elements already there are returned at once.
+	sendCommand(t, addr, "RPUSH", "k1", "x", "y")
+	assert.Equal(t, "y", c.do("BRPOP", "k1", "0").Array[1].Str)
+
+	// Timeouts are in seconds and may be fractional.
+	start := time.Now()
+	assert.True(t, c.do("BLPOP", "empty", "0.1").Null)
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	assert.True(t, c.do("BLMOVE", "empty", "dst", "LEFT", "LEFT", "0.05").Null)
+	assert.True(t, c.do("BLMPOP", "0.05", "1", "empty", "LEFT").Null)
+	assert.Contains(t, c.do("BLPOP", "empty", "-1").Str, "negative")
+	assert.Contains(t, c.do("BLPOP", "empty", "soon").Str, "not a float")
+
+	sendCommand(t, addr, "SET", "str", "v")
+	assert.Contains(t, c.do("BLPOP", "str", "0").Str, "WRONGTYPE")
+}
+
+func TestServer_BlockingPopsFIFO(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	clients := make([]*testConn, 3)
+	for i := range clients {
+		clients[i] = dialTestConn(t, addr)
+		clients[i].send("BLPOP", "queue", "0")
+		waitBlocked(t, s, "queue", i+1)
+	}
+
+	// A late arrival must not overtake clients that blocked earlier.
+	late := dialTestConn(t, addr)
+	sendCommand(t, addr, "RPUSH", "queue", "a", "b", "c", "d")
+	for i, want := range []string{"a", "b", "c"} {
+		assert.Equal(t, want, clients[i].read().Array[1].Str)
+	}
+	assert.Equal(t, "d", late.do("BLPOP", "queue", "0").Array[1].Str)
+}
+
+func TestServer_BLMoveChain(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	// BLMOVE's push to dst wakes the client blocked on dst.
+	mover := dialTestConn(t, addr)
+	mover.send("BLMOVE", "src", "dst", "LEFT", "RIGHT", "0")
+	waitBlocked(t, s, "src", 1)
+	popper := dialTestConn(t, addr)
+	popper.send("BLMPOP", "0", "1", "dst", "LEFT", "COUNT", "10")
+	waitBlocked(t, s, "dst", 1)
+
+	sendCommand(t, addr, "LPUSH", "src", "v")
+	assert.Equal(t, "v", mover.read().Str)
+	resp := popper.read()
+	require.Len(t, resp.Array, 2)
+	assert.Equal(t, "dst", resp.Array[0].Str)
+	require.Len(t, resp.Array[1].Array, 1)
+	assert.Equal(t, "v", resp.Array[1].Array[0].Str)
+}
+
+func TestServer_BLMoveWrongTypeDst(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	// A destination of another type fails the move once src has elements,
+	// and leaves them for the next client.
+	sendCommand(t, addr, "SET", "dst", "x")
+	mover := dialTestConn(t, addr)
+	mover.send("BLMOVE", "src", "dst", "LEFT", "RIGHT", "0")
+	waitBlocked(t, s, "src", 1)
+	popper := dialTestConn(t, addr)
+	popper.send("BLPOP", "src", "0")
+	waitBlocked(t, s, "src", 2)
+
+	sendCommand(t, addr, "LPUSH", "src", "v")
+	assert.Equal(t, "WRONGTYPE Operation against a key holding the wrong kind of value", mover.read().Str)
+	assert.Equal(t, "v", popper.read().Array[1].Str)
+	assert.Equal(t, "x", sendCommand(t, addr, "GET", "dst"))
+}
+
+func TestServer_BlockingPopInMulti(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	c := dialTestConn(t, addr)
+	assert.Equal(t, "OK", c.do("MULTI").Str)
+	assert.Equal(t, "QUEUED", c.do("BLPOP", "empty", "0").Str)
+	assert.Equal(t, "QUEUED", c.do("RPUSH", "empty", "v").Str)
+	assert.Equal(t, "QUEUED", c.do("BLPOP", "empty", "0").Str)
+	resp := c.do("EXEC")
+	require.Len(t, resp.Array, 3)
+	assert.True(t, resp.Array[0].Null)
+	assert.Equal(t, "v", resp.Array[2].Array[1].Str)
+}
+
+func TestServer_BlockingPopDisconnect(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	c := dialTestConn(t, addr)
+	c.send("BLPOP", "k", "0")
+	waitBlocked(t, s, "k", 1)
+	c.conn.Close()
+	waitBlocked(t, s, "k", 0)
+
+	// The element stays in the list instead of going to the closed client.
+	sendCommand(t, addr, "RPUSH", "k", "v")
+	assert.Equal(t, "1", sendCommand(t, addr, "LLEN", "k"))
+}
+
+func TestServer_Streams(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	c := dialTestConn(t, addr)
+	assert.Equal(t, "1-1", c.do("XADD", "s", "1-1", "f", "a").Str)
+	assert.Equal(t, "1-2", c.do("XADD", "s", "1-*", "f", "b").Str)
+	assert.Equal(t, "5-0", c.do("XADD", "s", "5", "f", "c").Str)
+	assert.Contains(t, c.do("XADD", "s", "4-0", "f", "d").Str, "equal or smaller")
+	assert.True(t, c.do("XADD", "missing", "NOMKSTREAM", "*", "f", "v").Null)
+	assert.Equal(t, "3", sendCommand(t, addr, "XLEN", "s"))
+	assert.Equal(t, "stream", sendCommand(t, addr, "TYPE", "s"))
+
+	resp := c.do("XRANGE", "s", "-", "+", "COUNT", "2")
+	require.Len(t, resp.Array, 2)
+	assert.Equal(t, "1-1", resp.Array[0].Array[0].Str)
+	assert.Equal(t, "a", resp.Array[0].Array[1].Array[1].Str)
+	resp = c.do("XREVRANGE", "s", "+", "(1-2")
+	require.Len(t, resp.Array, 1)
+	assert.Equal(t, "5-0", resp.Array[0].Array[0].Str)
+
+	assert.Equal(t, int64(1), c.do("XDEL", "s", "1-2", "9-9").Num)
+	assert.Equal(t, int64(1), c.do("XTRIM", "s", "MAXLEN", "1").Num)
+	assert.Equal(t, "OK", c.do("XSETID", "s", "10-0").Str)
+	assert.Contains(t, c.do("XSETID", "s", "1-0").Str, "smaller")
+
+	resp = c.do("XREAD", "COUNT", "10", "STREAMS", "s", "0")
+	require.Len(t, resp.Array, 1)
+	assert.Equal(t, "s", resp.Array[0].Array[0].Str)
+	require.Len(t, resp.Array[0].Array[1].Array, 1)
+	assert.True(t, c.do("XREAD", "STREAMS", "s", "$").Null)
+
+	sendCommand(t, addr, "SET", "str", "v")
+	assert.Contains(t, c.do("XADD", "str", "*", "f", "v").Str, "WRONGTYPE")
+}
+
+func TestServer_StreamConsumerGroups(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	c := dialTestConn(t, addr)
+	assert.Contains(t, c.do("XGROUP", "CREATE", "s", "g", "$").Str, "MKSTREAM")
+	assert.Equal(t, "OK", c.do("XGROUP", "CREATE", "s", "g", "$", "MKSTREAM").Str)
+	assert.Equal(t, "-BUSYGROUP Consumer Group name already exists\r\n", c.doRaw("XGROUP", "CREATE", "s", "g", "$"))
+	c.do("XADD", "s", "1-0", "f", "a")
+	c.do("XADD", "s", "2-0", "f", "b")
+
+	resp := c.do("XREADGROUP", "GROUP", "g", "alice", "COUNT", "1", "STREAMS", "s", ">")
+	require.Len(t, resp.Array, 1)
+	assert.Equal(t, "1-0", resp.Array[0].Array[1].Array[0].Array[0].Str)
+	resp = c.do("XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", ">")
+	assert.Equal(t, "2-0", resp.Array[0].Array[1].Array[0].Array[0].Str)
+
+	// Reading history returns the consumer's own pending entries.
+	resp = c.do("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "0")
+	require.Len(t, resp.Array[0].Array[1].Array, 1)
+	assert.Equal(t, "1-0", resp.Array[0].Array[1].Array[0].Array[0].Str)
+
+	resp = c.do("XPENDING", "s", "g")
+	require.Len(t, resp.Array, 4)
+	assert.Equal(t, int64(2), resp.Array[0].Num)
+	assert.Equal(t, "1-0", resp.Array[1].Str)
+	assert.Equal(t, "2-0", resp.Array[2].Str)
+	require.Len(t, resp.Array[3].Array, 2)
+
+	resp = c.do("XPENDING", "s", "g", "-", "+", "10", "alice")
+	require.Len(t, resp.Array, 1)
+	assert.Equal(t, "alice", resp.Array[0].Array[1].Str)
+	assert.Equal(t, int64(2), resp.Array[0].Array[3].Num)
+
+	resp = c.do("XCLAIM", "s", "g", "bob", "0", "1-0", "JUSTID")
+	require.Len(t, resp.Array, 1)
+	assert.Equal(t, "1-0", resp.Array[0].Str)
+	resp = c.do("XPENDING", "s", "g", "-", "+", "10", "bob")
+	assert.Len(t, resp.Array, 2)
+
+	assert.Equal(t, int64(2), c.do("XACK", "s", "g", "1-0", "2-0", "3-0").Num)
+	assert.Equal(t, int64(0), c.do("XPENDING", "s", "g").Array[0].Num)
+	assert.Equal(t, int64(0), c.do("XGROUP", "DELCONSUMER", "s", "g", "bob").Num)
+	assert.Equal(t, "-NOGROUP No such key or consumer group\r\n", c.doRaw("XREADGROUP", "GROUP", "nope", "c", "STREAMS", "s", ">"))
+	assert.Equal(t, int64(1), c.do("XGROUP", "DESTROY", "s", "g").Num)
+	assert.Equal(t, int64(0), c.do("XGROUP", "DESTROY", "s", "g").Num)
+}
+
+func TestServer_BlockingStreamReads(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	// Every client blocked on the stream sees the new entry.
+	readers := make([]*testConn, 2)
+	for i := range readers {
+		readers[i] = dialTestConn(t, addr)
+		readers[i].send("XREAD", "BLOCK", "0", "STREAMS", "s", "$")
+		waitBlocked(t, s, "s", i+1)
+	}
+	sendCommand(t, addr, "XADD", "s", "1-0", "f", "v")
+	for _, r := range readers {
+		resp := r.read()
+		require.Len(t, resp.Array, 1)
+		assert.Equal(t, "1-0", resp.Array[0].Array[1].Array[0].Array[0].Str)
+	}
+
+	// A group read hands each new entry to one consumer only.
+	c := dialTestConn(t, addr)
+	assert.Equal(t, "OK", c.do("XGROUP", "CREATE", "s", "g", "$").Str)
+	consumers := make([]*testConn, 2)
+	for i := range consumers {
+		consumers[i] = dialTestConn(t, addr)
+		consumers[i].send("XREADGROUP", "GROUP", "g", fmt.Sprintf("c%d", i), "BLOCK", "0", "STREAMS", "s", ">")
+		waitBlocked(t, s, "s", i+1)
+	}
+	sendCommand(t, addr, "XADD", "s", "2-0", "f", "v")
+	resp := consumers[0].read()
+	assert.Equal(t, "2-0", resp.Array[0].Array[1].Array[0].Array[0].Str)
+	waitBlocked(t, s, "s", 1)
+	sendCommand(t, addr, "XADD", "s", "3-0", "f", "v")
+	resp = consumers[1].read()
+	assert.Equal(t, "3-0", resp.Array[0].Array[1].Array[0].Array[0].Str)
+
+	start := time.Now()
+	assert.True(t, c.do("XREAD", "BLOCK", "50", "STREAMS", "s", "$").Null)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestServer_Bitmaps(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	c := dialTestConn(t, addr)
+	assert.Equal(t, int64(0), c.do("SETBIT", "k", "7", "1").Num)
+	assert.Equal(t, int64(1), c.do("SETBIT", "k", "7", "0").Num)
+	assert.Equal(t, int64(0), c.do("SETBIT", "k", "14", "1").Num)
+	assert.Equal(t, int64(1), c.do("GETBIT", "k", "14").Num)
+	assert.Equal(t, int64(0), c.do("GETBIT", "k", "1000").Num)
+	assert.Equal(t, "\x00\x02", c.do("GET", "k").Str)
+	assert.Contains(t, c.do("SETBIT", "k", "-1", "1").Str, "bit offset")
+	assert.Contains(t, c.do("SETBIT", "k", "4294967296", "1").Str, "bit offset")
+	assert.Contains(t, c.do("SETBIT", "k", "0", "2").Str, "bit is not an integer")
+
+	// Bit commands work on the bytes SETRANGE writes.
+	assert.Equal(t, int64(6), c.do("SETRANGE", "str", "0", "foobar").Num)
+	assert.Equal(t, int64(26), c.do("BITCOUNT", "str").Num)
+	assert.Equal(t, int64(6), c.do("BITCOUNT", "str", "1", "1").Num)
+	assert.Equal(t, int64(17), c.do("BITCOUNT", "str", "5", "30", "BIT").Num)
+	assert.Contains(t, c.do("BITCOUNT", "str", "1").Str, "syntax error")
+	assert.Equal(t, int64(0), c.do("BITCOUNT", "missing").Num)
+	assert.Equal(t, "oo", c.do("GETRANGE", "str", "1", "2").Str)
+
+	c.do("SETRANGE", "pos", "0", "\xff\xf0\x00")
+	assert.Equal(t, int64(12), c.do("BITPOS", "pos", "0").Num)
+	assert.Equal(t, int64(-1), c.do("BITPOS", "pos", "1", "2").Num)
+	assert.Equal(t, int64(7), c.do("BITPOS", "pos", "1", "7", "15", "BIT").Num)
+	assert.Equal(t, int64(0), c.do("BITPOS", "missing", "0").Num)
+	assert.Contains(t, c.do("BITPOS", "pos", "2").Str, "1 or 0")
+
+	c.do("SET", "a", "\xf0\xff")
+	c.do("SET", "b", "\x3c")
+	assert.Equal(t, int64(2), c.do("BITOP", "AND", "dest", "a", "b").Num)
+	assert.Equal(t, "\x30\x00", c.do("GET", "dest").Str)
+	assert.Equal(t, int64(2), c.do("BITOP", "NOT", "dest", "a").Num)
+	assert.Equal(t, "\x0f\x00", c.do("GET", "dest").Str)
+	assert.Contains(t, c.do("BITOP", "NOT", "dest", "a", "b").Str, "single source key")
+	assert.Contains(t, c.do("BITOP", "NAND", "dest", "a").Str, "syntax error")
+
+	c.do("RPUSH", "list", "x")
+	assert.Contains(t, c.do("SETBIT", "list", "0", "1").Str, "WRONGTYPE")
+	assert.Contains(t, c.do("BITOP", "OR", "dest", "a", "list").Str, "WRONGTYPE")
+}
+
+func TestServer_BitField(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	c := dialTestConn(t, addr)
+	resp := c.do("BITFIELD", "bf", "SET", "i8", "0", "100", "INCRBY", "i8", "0", "100", "GET", "u8", "0")
+	require.Len(t, resp.Array, 3)
+	assert.Equal(t, int64(0), resp.Array[0].Num)
+	assert.Equal(t, int64(-56), resp.Array[1].Num)
+	assert.Equal(t, int64(200), resp.Array[2].Num)
+
+	resp = c.do("BITFIELD", "bf", "OVERFLOW", "FAIL", "INCRBY", "i8", "0", "-100", "OVERFLOW", "SAT", "INCRBY", "i8", "0", "-100")
+	require.Len(t, resp.Array, 2)
+	assert.True(t, resp.Array[0].Null)
+	assert.Equal(t, int64(-128), resp.Array[1].Num)
+
+	// "#n" offsets count in fields of the given width.
+	resp = c.do("BITFIELD", "counters", "INCRBY", "u4", "#1", "17", "GET", "u4", "4")
+	assert.Equal(t, int64(1), resp.Array[0].Num)
+	assert.Equal(t, int64(1), resp.Array[1].Num)
+	assert.Equal(t, "\x01", c.do("GET", "counters").Str)
+
+	resp = c.do("BITFIELD_RO", "counters", "GET", "u4", "#1")
+	require.Len(t, resp.Array, 1)
+	assert.Equal(t, int64(1), resp.Array[0].Num)
+	assert.Contains(t, c.do("BITFIELD_RO", "counters", "SET", "u4", "0", "1").Str, "only supports the GET")
+	assert.Contains(t, c.do("BITFIELD", "bf", "GET", "u64", "0").Str, "Invalid bitfield type")
+	assert.Contains(t, c.do("BITFIELD", "bf", "OVERFLOW", "MAYBE").Str, "Invalid OVERFLOW")
+	assert.Contains(t, c.do("BITFIELD", "bf", "GET", "i8").Str, "syntax error")
+	assert.Equal(t, "0", sendCommand(t, addr, "EXISTS", "ro"))
+	assert.Len(t, c.do("BITFIELD", "ro", "GET", "i8", "0").Array, 1)
+	assert.Equal(t, "0", sendCommand(t, addr, "EXISTS", "ro"))
+}
+
+func TestServer_HyperLogLog(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	c := dialTestConn(t, addr)
+	assert.Equal(t, int64(1), c.do("PFADD", "hll", "a", "b", "c", "d").Num)
+	assert.Equal(t, int64(0), c.do("PFADD", "hll", "a", "b").Num)
+	assert.Equal(t, int64(1), c.do("PFADD", "empty").Num)
+	assert.Equal(t, int64(4), c.do("PFCOUNT", "hll").Num)
+	assert.Equal(t, int64(0), c.do("PFCOUNT", "empty", "missing").Num)
+	assert.Equal(t, "string", sendCommand(t, addr, "TYPE", "hll"))
+
+	c.do("PFADD", "other", "c", "d", "e")
+	assert.Equal(t, int64(5), c.do("PFCOUNT", "hll", "other").Num)
+	assert.Equal(t, "OK", c.do("PFMERGE", "merged", "hll", "other").Str)
+	assert.Equal(t, int64(5), c.do("PFCOUNT", "merged").Num)
+
+	// The value is a plain string that can be copied around.
+	raw := c.do("GET", "merged").Str
+	assert.Equal(t, "HYLL", raw[:4])
+	assert.Equal(t, "OK", c.do("SET", "copy", raw).Str)
+	assert.Equal(t, int64(5), c.do("PFCOUNT", "copy").Num)
+
+	c.do("SET", "str", "hello")
+	assert.Contains(t, c.do("PFADD", "str", "x").Str, "not a valid HyperLogLog")
+	assert.Contains(t, c.do("PFCOUNT", "hll", "str").Str, "not a valid HyperLogLog")
+	c.do("SADD", "set", "x")
+	assert.Contains(t, c.do("PFMERGE", "set", "hll").Str, "WRONGTYPE")
+	assert.Contains(t, c.do("PFCOUNT").Str, "wrong number of arguments")
+}
+
+func TestServer_Geo(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	c := dialTestConn(t, addr)
+	assert.Equal(t, int64(2), c.do("GEOADD", "Sicily", "13.361389", "38.115556", "Palermo",
+		"15.087269", "37.502669", "Catania").Num)
+	assert.Equal(t, "166274.1516", c.do("GEODIST", "Sicily", "Palermo", "Catania").Str)
+	assert.Equal(t, "166.2742", c.do("GEODIST", "Sicily", "Palermo", "Catania", "km").Str)
+	assert.True(t, c.do("GEODIST", "Sicily", "Palermo", "missing").Null)
+	assert.Contains(t, c.do("GEODIST", "Sicily", "Palermo", "Catania", "yd").Str, "unsupported unit")
+
+	// Positions are ordinary sorted set scores.
+	assert.Equal(t, "3479099956230698", c.do("ZSCORE", "Sicily", "Palermo").Str)
+	pos := c.do("GEOPOS", "Sicily", "Palermo", "missing").Array
+	require.Len(t, pos, 2)
+	assert.True(t, strings.HasPrefix(pos[0].Array[0].Str, "13.361389"))
+	assert.True(t, strings.HasPrefix(pos[0].Array[1].Str, "38.115556"))
+	assert.True(t, pos[1].Null)
+	hashes := c.do("GEOHASH", "Sicily", "Palermo", "Catania").Array
+	require.Len(t, hashes, 2)
+	assert.Equal(t, "sqc8b49rny0", hashes[0].Str)
+	assert.Equal(t, "sqdtr74hyu0", hashes[1].Str)
+
+	assert.Equal(t, int64(0), c.do("GEOADD", "Sicily", "NX", "13", "38", "Palermo").Num)
+	assert.Equal(t, int64(1), c.do("GEOADD", "Sicily", "XX", "CH", "13.361389", "38.2", "Palermo").Num)
+	c.do("GEOADD", "Sicily", "13.361389", "38.115556", "Palermo")
+	assert.Contains(t, c.do("GEOADD", "Sicily", "200", "10", "x").Str, "invalid longitude,latitude pair 200.000000,10.000000")
+	assert.Contains(t, c.do("GEOADD", "Sicily", "NX", "XX", "1", "1", "x").Str, "not compatible")
+	assert.Contains(t, c.do("GEOADD", "Sicily", "CH", "1", "1").Str, "syntax error")
+
+	c.do("GEOADD", "Sicily", "12.758489", "38.788135", "edge1", "17.241510", "38.788135", "edge2")
+	names := func(v protocol.Value) []string {
+		var out []string
+		for _, item := range v.Array {
+			if item.Array != nil {
+				item = item.Array[0]
+			}
+			out = append(out, item.Str)
+		}
+		return out
+	}
+	assert.Equal(t, []string{"Catania", "Palermo"},
+		names(c.do("GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC")))
+	assert.Equal(t, []string{"edge1", "edge2", "Palermo", "Catania"},
+		names(c.do("GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "DESC")))
+	assert.Equal(t, []string{"Catania"},
+		names(c.do("GEOSEARCH", "Sicily", "FROMMEMBER", "Catania", "BYRADIUS", "100", "km", "COUNT", "1")))
+
+	res := c.do("GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km",
+		"ASC", "WITHCOORD", "WITHDIST", "WITHHASH").Array
+	require.Len(t, res, 2)
+	require.Len(t, res[0].Array, 4)
+	assert.Equal(t, "Catania", res[0].Array[0].Str)
+	assert.Equal(t, "56.4413", res[0].Array[1].Str)
+	assert.Equal(t, int64(3479447370796909), res[0].Array[2].Num)
+	assert.Len(t, res[0].Array[3].Array, 2)
+	assert.Equal(t, "190.4424", res[1].Array[1].Str)
+
+	assert.Equal(t, int64(2), c.do("GEOSEARCHSTORE", "near", "Sicily", "FROMLONLAT", "15", "37",
+		"BYRADIUS", "200", "km", "STOREDIST").Num)
+	assert.True(t, strings.HasPrefix(c.do("ZSCORE", "near", "Catania").Str, "56.441"))
+	assert.Equal(t, int64(0), c.do("GEOSEARCHSTORE", "near", "Sicily", "FROMLONLAT", "0", "0",
+		"BYRADIUS", "1", "m").Num)
+	assert.Equal(t, int64(0), c.do("EXISTS", "near").Num)
+
+	assert.Contains(t, c.do("GEOSEARCH", "Sicily", "FROMMEMBER", "missing", "BYRADIUS", "1", "m").Str, "could not decode")
+	assert.Empty(t, c.do("GEOSEARCH", "missing", "FROMMEMBER", "x", "BYRADIUS", "1", "m").Array)
+	assert.Contains(t, c.do("GEOSEARCH", "Sicily", "BYRADIUS", "1", "m").Str, "FROMMEMBER or FROMLONLAT")
+	assert.Contains(t, c.do("GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37").Str, "BYRADIUS and BYBOX")
+	assert.Contains(t, c.do("GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "-1", "m").Str, "negative")
+	assert.Contains(t, c.do("GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "1", "m", "COUNT", "0").Str, "COUNT must be > 0")
+	assert.Contains(t, c.do("GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "1", "m", "STOREDIST").Str, "syntax error")
+	assert.Contains(t, c.do("GEOSEARCHSTORE", "d", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "1", "m", "WITHDIST").Str, "syntax error")
+	c.do("SET", "str", "x")
+	assert.Contains(t, c.do("GEOADD", "str", "1", "1", "x").Str, "WRONGTYPE")
+}
+
+func TestServer_JSON(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	c := dialTestConn(t, addr)
+	assert.Equal(t, "OK", c.do("JSON.SET", "doc", "$", `{"name":"x","tags":["a"],"n":{"tags":[]},"count":1}`).Str)
+	assert.Equal(t, "ReJSON-RL", c.do("TYPE", "doc").Str)
+	assert.True(t, c.do("JSON.SET", "doc", "$.name", `"y"`, "NX").Null)
+	assert.Contains(t, c.do("JSON.SET", "new", "$.a", "1").Str, "new objects must be created at the root")
+	assert.Contains(t, c.do("JSON.SET", "doc", "$.a", "{").Str, "invalid JSON")
+
+	// Legacy paths reply with one value, JSONPaths with one per match.
+	assert.Equal(t, `"x"`, c.do("JSON.GET", "doc", ".name").Str)
+	assert.Equal(t, `["x"]`, c.do("JSON.GET", "doc", "$.name").Str)
+	assert.Equal(t, `{".name":"x","count":1}`, c.do("JSON.GET", "doc", ".name", "count").Str)
+	assert.Equal(t, "[\n\t\"a\"\n]", c.do("JSON.GET", "doc", "INDENT", "\t", "NEWLINE", "\n", ".tags").Str)
+	assert.Contains(t, c.do("JSON.GET", "doc", ".missing").Str, "Path '.missing' does not exist")
+	assert.Contains(t, c.do("JSON.GET", "doc", "$[").Str, "JSON Path error")
+	assert.True(t, c.do("JSON.GET", "missing").Null)
+
+	assert.Equal(t, int64(3), c.do("JSON.ARRAPPEND", "doc", ".tags", `"b"`, `"c"`).Num)
+	lens := c.do("JSON.ARRAPPEND", "doc", "$..tags", `"d"`).Array
+	require.Len(t, lens, 2)
+	assert.Equal(t, int64(4), lens[0].Num)
+	assert.Equal(t, int64(1), lens[1].Num)
+	assert.Equal(t, int64(5), c.do("JSON.ARRINSERT", "doc", ".tags", "0", `"z"`).Num)
+	assert.Contains(t, c.do("JSON.ARRINSERT", "doc", ".tags", "9", `"z"`).Str, "index out of bounds")
+	assert.Contains(t, c.do("JSON.ARRAPPEND", "doc", ".name", "1").Str, "WRONGTYPE")
+	assert.Equal(t, int64(5), c.do("JSON.ARRLEN", "doc", ".tags").Num)
+	lens = c.do("JSON.ARRLEN", "doc", "$.*").Array
+	require.Len(t, lens, 4)
+	assert.True(t, lens[0].Null)
+	assert.Equal(t, int64(5), lens[1].Num)
+	assert.Equal(t, `"d"`, c.do("JSON.ARRPOP", "doc", ".tags").Str)
+	assert.Equal(t, `"z"`, c.do("JSON.ARRPOP", "doc", ".tags", "0").Str)
+	assert.Equal(t, int64(2), c.do("JSON.ARRTRIM", "doc", ".tags", "1", "-1").Num)
+	assert.Equal(t, `["b","c"]`, c.do("JSON.GET", "doc", ".tags").Str)
+
+	assert.Equal(t, "3", c.do("JSON.NUMINCRBY", "doc", ".count", "2").Str)
+	assert.Equal(t, "[7.5,null]", c.do("JSON.NUMMULTBY", "doc", `$["count","name"]`, "2.5").Str)
+	assert.Equal(t, "number", c.do("JSON.TYPE", "doc", ".count").Str)
+	types := c.do("JSON.TYPE", "doc", "$..tags").Array
+	require.Len(t, types, 2)
+	assert.Equal(t, "array", types[0].Str)
+
+	mget := c.do("JSON.MGET", "doc", "missing", ".name").Array
+	require.Len(t, mget, 2)
+	assert.Equal(t, `"x"`, mget[0].Str)
+	assert.True(t, mget[1].Null)
+
+	assert.Equal(t, int64(2), c.do("JSON.DEL", "doc", "$..tags").Num)
+	assert.Equal(t, int64(0), c.do("JSON.FORGET", "doc", "$.missing").Num)
+	assert.Equal(t, `{"name":"x","n":{},"count":7.5}`, c.do("JSON.GET", "doc").Str)
+	assert.Equal(t, int64(1), c.do("JSON.DEL", "doc").Num)
+	assert.Equal(t, int64(0), c.do("EXISTS", "doc").Num)
+
+	c.do("SET", "str", "v")
+	assert.Contains(t, c.do("JSON.GET", "str").Str, "WRONGTYPE")
+}
+
+func TestServer_Search(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	c := dialTestConn(t, addr)
+	c.do("HSET", "book:1", "title", "The Go Programming Language", "genre", "tech,go", "year", "2015")
+	assert.Equal(t, "OK", c.do("FT.CREATE", "books", "ON", "HASH", "PREFIX", "1", "book:",
+		"SCHEMA", "title", "TEXT", "genre", "TAG", "SEPARATOR", ",", "year", "NUMERIC", "SORTABLE").Str)
+	assert.Contains(t, c.do("FT.CREATE", "books", "SCHEMA", "title", "TEXT").Str, "Index already exists")
+	assert.Contains(t, c.do("FT.CREATE", "bad", "SCHEMA", "title", "GEO").Str, "unsupported field type")
+	assert.Contains(t, c.do("FT.CREATE", "bad", "PREFIX", "1", "x:").Str, "missing SCHEMA")
+
+	c.do("HSET", "book:2", "title", "Programming Pearls", "genre", "tech", "year", "1986")
+	c.do("HSET", "book:3", "title", "Dune", "genre", "fiction", "year", "1965")
+	c.do("HSET", "note:1", "title", "programming notes")
+
+	res := c.do("FT.SEARCH", "books", "programming").Array
+	require.Len(t, res, 5)
+	assert.Equal(t, int64(2), res[0].Num)
+	assert.Equal(t, "book:1", res[1].Str)
+	require.Len(t, res[2].Array, 6)
+	fields := make(map[string]string)
+	for i := 0; i < len(res[2].Array); i += 2 {
+		fields[res[2].Array[i].Str] = res[2].Array[i+1].Str
+	}
+	assert.Equal(t, "2015", fields["year"])
+
+	res = c.do("FT.SEARCH", "books", "@genre:{tech} -@year:[2000 +inf]", "RETURN", "1", "year").Array
+	require.Len(t, res, 3)
+	assert.Equal(t, "book:2", res[1].Str)
+	require.Len(t, res[2].Array, 2)
+	assert.Equal(t, "1986", res[2].Array[1].Str)
+
+	res = c.do("FT.SEARCH", "books", "*", "NOCONTENT", "SORTBY", "year", "DESC", "LIMIT", "0", "2").Array
+	require.Len(t, res, 3)
+	assert.Equal(t, int64(3), res[0].Num)
+	assert.Equal(t, "book:1", res[1].Str)
+	assert.Equal(t, "book:2", res[2].Str)
+
+	// The index follows deletes.
+	c.do("DEL", "book:1")
+	res = c.do("FT.SEARCH", "books", "@genre:{go}").Array
+	require.Len(t, res, 1)
+	assert.Equal(t, int64(0), res[0].Num)
+
+	assert.Contains(t, c.do("FT.SEARCH", "books", "@nope:x").Str, "Unknown field")
+	assert.Contains(t, c.do("FT.SEARCH", "books", "(x").Str, "Syntax error")
+	assert.Contains(t, c.do("FT.SEARCH", "books", "*", "LIMIT", "0").Str, "syntax error")
+	assert.Contains(t, c.do("FT.SEARCH", "missing", "*").Str, "Unknown index name")
+
+	info := c.do("FT.INFO", "books").Array
+	require.Len(t, info, 12)
+	assert.Equal(t, "books", info[1].Str)
+	assert.Equal(t, "book:", info[3].Array[3].Array[0].Str)
+	require.Len(t, info[5].Array, 3)
+	assert.Equal(t, "SORTABLE", info[5].Array[2].Array[6].Str)
+	assert.Equal(t, int64(2), info[7].Num)
+
+	list := c.do("FT._LIST").Array
+	require.Len(t, list, 1)
+	assert.Equal(t, "books", list[0].Str)
+	assert.Equal(t, "OK", c.do("FT.DROPINDEX", "books", "DD").Str)
+	assert.Equal(t, int64(0), c.do("EXISTS", "book:2", "book:3").Num)
+	assert.Equal(t, int64(1), c.do("EXISTS", "note:1").Num)
+	assert.Contains(t, c.do("FT.DROPINDEX", "books").Str, "Unknown index name")
+}
+
+func TestServer_KeyspaceNotifications(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	sub := dialTestConn(t, addr)
+	sub.do("PSUBSCRIBE", "__key*__:*")
+	next := func() (string, string) {
+		msg := sub.read().Array
+		require.Len(t, msg, 4)
+		require.Equal(t, "pmessage", msg[0].Str)
+		return msg[2].Str, msg[3].Str
+	}
+	expect := func(channel, message string) {
+		t.Helper()
+		ch, msg := next()
+		assert.Equal(t, channel, ch)
+		assert.Equal(t, message, msg)
+	}
+
+	// Off by default.
+	assert.Equal(t, "notify-keyspace-events,", sendCommand(t, addr, "CONFIG", "GET", "notify-keyspace-events"))
+	sendCommand(t, addr, "SET", "quiet", "v")
+
+	assert.Equal(t, "OK", sendCommand(t, addr, "CONFIG", "SET", "notify-keyspace-events", "KEA"))
+	assert.Equal(t, "notify-keyspace-events,AKE", sendCommand(t, addr, "CONFIG", "GET", "notify-keyspace-events"))
+	assert.Contains(t, sendCommand(t, addr, "CONFIG", "SET", "notify-keyspace-events", "Q"), "Invalid argument")
+
+	sendCommand(t, addr, "SET", "foo", "bar")
+	expect("__keyspace@0__:foo", "set")
+	expect("__keyevent@0__:set", "foo")
+
+	c := dialTestConn(t, addr)
+	c.do("SELECT", "1")
+	c.do("RPUSH", "list", "a")
+	expect("__keyspace@1__:list", "rpush")
+	expect("__keyevent@1__:rpush", "list")
+	c.do("LPOP", "list")
+	expect("__keyspace@1__:list", "lpop")
+	expect("__keyevent@1__:lpop", "list")
+	expect("__keyspace@1__:list", "del")
+	expect("__keyevent@1__:del", "list")
+
+	// Only keyevent channels, and only generic and expired events.
+	sendCommand(t, addr, "CONFIG", "SET", "notify-keyspace-events", "Egx")
+	sendCommand(t, addr, "HSET", "h", "f", "v")
+	sendCommand(t, addr, "SET", "short", "v", "PX", "1")
+	time.Sleep(5 * time.Millisecond)
+	sendCommand(t, addr, "RENAME", "foo", "foo2")
+	expect("__keyevent@0__:expire", "short")
+	expect("__keyevent@0__:rename_from", "foo")
+	expect("__keyevent@0__:rename_to", "foo2")
+	// A write that finds the key expired removes it.
+	sendCommand(t, addr, "APPEND", "short", "x")
+	expect("__keyevent@0__:expired", "short")
+	sendCommand(t, addr, "DEL", "h")
+	expect("__keyevent@0__:del", "h")
+}
+
+func TestServer_SlowSubscriber(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	// A subscriber that stops reading holds up neither publishers nor
+	// writes that notify it; once too far behind, it is disconnected.
+	sub := dialTestConn(t, addr)
+	sub.do("PSUBSCRIBE", "*")
+	c := dialTestConn(t, addr)
+	c.do("CONFIG", "SET", "notify-keyspace-events", "KEA")
+
+	const n = 3 * outboxSize
+	payload := strings.Repeat("x", 4096)
+	for i := 0; i < n; i++ {
+		c.send("PUBLISH", "ch", payload)
+	}
+	for i := 0; i < n; i++ {
+		c.read()
+	}
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, "OK", c.do("SET", "k", "v").Str)
+	}
+
+	for {
+		if _, err := sub.reader.ReadValue(); err != nil {
+			assert.NotErrorIs(t, err, os.ErrDeadlineExceeded)
+			break
+		}
+	}
+}
+
+func TestServer_Watch(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	c := dialTestConn(t, addr)
+	c.do("SET", "balance", "100")
+
+	// Untouched watched keys let EXEC run.
+	assert.Equal(t, "OK", c.do("WATCH", "balance", "other").Str)
+	c.do("MULTI")
+	c.do("SET", "balance", "90")
+	resp := c.do("EXEC")
+	require.False(t, resp.Null)
+	require.Len(t, resp.Array, 1)
+	assert.Equal(t, "90", sendCommand(t, addr, "GET", "balance"))
+
+	// A change by another client aborts EXEC, and clears the watches.
+	c.do("WATCH", "balance")
+	sendCommand(t, addr, "INCRBY", "balance", "5")
+	c.do("MULTI")
+	c.do("SET", "balance", "80")
+	assert.True(t, c.do("EXEC").Null)
+	assert.Equal(t, "95", sendCommand(t, addr, "GET", "balance"))
+	c.do("MULTI")
+	c.do("SET", "balance", "80")
+	assert.False(t, c.do("EXEC").Null)
+
+	// So does a key expiring, or one created in the watched database.
+	c.do("SET", "short", "v", "PX", "20")
+	c.do("WATCH", "short")
+	time.Sleep(30 * time.Millisecond)
+	c.do("MULTI")
+	assert.True(t, c.do("EXEC").Null)
+
+	c.do("SELECT", "1")
+	c.do("WATCH", "new")
+	sendCommand(t, addr, "SET", "new", "v")
+	c.do("MULTI")
+	c.do("PING")
+	assert.False(t, c.do("EXEC").Null, "key created in another database")
+	c.do("WATCH", "new")
+	other := dialTestConn(t, addr)
+	other.do("SELECT", "1")
+	other.do("SET", "new", "v")
+	c.do("MULTI")
+	assert.True(t, c.do("EXEC").Null)
+
+	// UNWATCH and DISCARD clear the watches.
+	c.do("WATCH", "new")
+	assert.Equal(t, "OK", c.do("UNWATCH").Str)
+	other.do("DEL", "new")
+	c.do("MULTI")
+	assert.False(t, c.do("EXEC").Null)
+	c.do("WATCH", "new")
+	c.do("MULTI")
+	assert.Equal(t, "OK", c.do("DISCARD").Str)
+	other.do("SET", "new", "v2")
+	c.do("MULTI")
+	assert.False(t, c.do("EXEC").Null)
+
+	c.do("MULTI")
+	assert.Contains(t, c.do("WATCH", "new").Str, "inside MULTI")
+	c.do("DISCARD")
+	assert.Contains(t, c.do("WATCH").Str, "wrong number of arguments")
+}
+
+func TestServer_ExecAbort(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	c := dialTestConn(t, addr)
+	c.do("SET", "a", "1")
+	c.do("WATCH", "a")
+	c.do("MULTI")
+	assert.Equal(t, "QUEUED", c.do("INCR", "a").Str)
+	assert.Contains(t, c.do("INCR").Str, "wrong number of arguments")
+	assert.Contains(t, c.do("NOSUCHCMD", "x").Str, "unknown command")
+	assert.Contains(t, c.do("SAVE").Str, "not allowed inside a transaction")
+	assert.Equal(t, "-EXECABORT Transaction discarded because of previous errors.\r\n", c.doRaw("EXEC"))
+	assert.Equal(t, "1", sendCommand(t, addr, "GET", "a"))
+
+	// The abort clears the transaction and the watches.
+	sendCommand(t, addr, "SET", "a", "2")
+	c.do("MULTI")
+	c.do("INCR", "a")
+	resp := c.do("EXEC")
+	require.Len(t, resp.Array, 1)
+	assert.Equal(t, int64(3), resp.Array[0].Num)
+
+	// Errors while running do not abort the rest, as in Redis.
+	c.do("SET", "str", "x")
+	c.do("MULTI")
+	c.do("INCR", "str")
+	c.do("SELECT", "1")
+	c.do("SET", "b", "2")
+	resp = c.do("EXEC")
+	require.Len(t, resp.Array, 3)
+	assert.EqualValues(t, protocol.TypeError, resp.Array[0].Type)
+	assert.Equal(t, "2", c.do("GET", "b").Str)
+
+	// Nested MULTI aborts too.
+	c.do("MULTI")
+	assert.Contains(t, c.do("MULTI").Str, "nested")
+	assert.Contains(t, c.do("EXEC").Str, "EXECABORT")
+	assert.Contains(t, c.do("EXEC").Str, "EXEC without MULTI")
+}
+
+func TestServer_ExecBackgroundWork(t *testing.T) {
+	s, tmpDir := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	// Background rewrites and snapshots would capture the transaction's
+	// uncommitted state, and its records would be replayed twice.
+	c := dialTestConn(t, addr)
+	c.do("RPUSH", "list", "a")
+	for _, cmd := range []string{"BGREWRITEAOF", "BGSAVE"} {
+		c.do("MULTI")
+		c.do("RPUSH", "list", "b")
+		assert.Contains(t, c.do(cmd).Str, "not allowed inside a transaction")
+		assert.Contains(t, c.do("EXEC").Str, "EXECABORT")
+	}
+	c.do("MULTI")
+	c.do("RPUSH", "list", "b")
+	require.Len(t, c.do("EXEC").Array, 1)
+	assert.Equal(t, "Background append only file rewriting started", c.do("BGREWRITEAOF").Str)
+	require.Eventually(t, func() bool {
+		return !strings.Contains(c.do("BGREWRITEAOF").Str, "in progress")
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// The data is the same after a restart.
+	require.NoError(t, s.engine.Close())
+	e, err := engine.New(filepath.Join(tmpDir, "test.wal"))
+	require.NoError(t, err)
+	s = New("127.0.0.1:0", e)
+	addr = startTestServer(t, s)
+	assert.Equal(t, "2", sendCommand(t, addr, "LLEN", "list"))
+}
+
+// TestCommandArity checks that commandArity lists every command the
+// dispatch switch of executeCommand handles, so none is refused as unknown
+// inside MULTI, and nothing else.
+func TestCommandArity(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "server.go", nil, 0)
+	require.NoError(t, err)
+
+	dispatched := make(map[string]bool)
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Name.Name != "executeCommand" {
+			continue
+		}
+		for _, stmt := range fn.Body.List {
+			sw, ok := stmt.(*ast.SwitchStmt)
+			if !ok {
+				continue
+			}
+			if tag, ok := sw.Tag.(*ast.Ident); !ok || tag.Name != "cmd" {
+				continue
+			}
+			for _, clause := range sw.Body.List {
+				for _, expr := range clause.(*ast.CaseClause).List {
+					lit := expr.(*ast.BasicLit)
+					name, err := strconv.Unquote(lit.Value)
+					require.NoError(t, err)
+					dispatched[name] = true
+				}
+			}
+		}
+	}
+	require.NotEmpty(t, dispatched, "dispatch switch not found")
+
+	// MULTI, EXEC, DISCARD and WATCH are never queued.
+	for _, cmd := range []string{"MULTI", "EXEC", "DISCARD", "WATCH"} {
+		assert.True(t, dispatched[cmd], cmd)
+		delete(dispatched, cmd)
+	}
+	for cmd := range dispatched {
+		assert.Contains(t, commandArity, cmd, "dispatched command without arity")
+	}
+	for cmd := range commandArity {
+		assert.Contains(t, dispatched, cmd, "arity of a command that is not dispatched")
+	}
+}
+
+func TestServer_BlockingInsideExec(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	// A client starts to block while EXEC holds the engine lock: it waits
+	// for the lock while holding the blocking state, which the BLPOP in
+	// EXEC needs too.
+	tx := dialTestConn(t, addr)
+	tx.do("MULTI")
+	tx.do("DEBUG", "SLEEP", "0.05")
+	tx.do("BLPOP", "empty", "0")
+	tx.send("EXEC")
+	time.Sleep(10 * time.Millisecond)
+	other := dialTestConn(t, addr)
+	other.send("BLPOP", "empty", "0.01")
+
+	resp := tx.read()
+	require.Len(t, resp.Array, 2)
+	assert.True(t, resp.Array[1].Null)
+	assert.True(t, other.read().Null)
+}
+
+func TestServer_Eval(t *testing.T) {
+	s, _ := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	c := dialTestConn(t, addr)
+	resp := c.do("EVAL", "return redis.call('INCRBY', KEYS[1], ARGV[1])", "1", "counter", "5")
+	assert.Equal(t, int64(5), resp.Num)
+	resp = c.do("EVAL", "return {1, 'two', 3.9, true, false, nil, 'lost'}", "0")
+	require.Len(t, resp.Array, 5)
+	assert.Equal(t, int64(1), resp.Array[0].Num)
+	assert.Equal(t, "two", resp.Array[1].Str)
+	assert.Equal(t, int64(3), resp.Array[2].Num)
+	assert.Equal(t, int64(1), resp.Array[3].Num)
+	assert.True(t, resp.Array[4].Null)
+	assert.Equal(t, "OK", c.do("EVAL", "return redis.call('SET', KEYS[1], 'v')", "1", "k").Str)
+	assert.Equal(t, "false", c.do("EVAL", "return tostring(redis.call('GET', 'missing'))", "0").Str)
+
+	// Scripts are cached by the SHA1 of their source.
+	sha := c.do("SCRIPT", "LOAD", "return KEYS[1] .. ARGV[1]").Str
+	assert.Len(t, sha, 40)
+	assert.Equal(t, "ab", c.do("EVALSHA", sha, "1", "a", "b").Str)
+	resp = c.do("SCRIPT", "EXISTS", sha, "0000000000000000000000000000000000000000")
+	require.Len(t, resp.Array, 2)
+	assert.Equal(t, int64(1), resp.Array[0].Num)
+	assert.Equal(t, int64(0), resp.Array[1].Num)
+	assert.Equal(t, "OK", c.do("SCRIPT", "FLUSH").Str)
+	assert.Equal(t, "-NOSCRIPT No matching script. Please use EVAL.\r\n", c.doRaw("EVALSHA", sha, "0"))
+
+	// Errors: redis.call raises them, redis.pcall returns them.
+	c.do("SET", "str", "x")
+	resp = c.do("EVAL", "redis.call('INCR', 'str'); redis.call('SET', 'after', 'v')", "0")
+	assert.EqualValues(t, protocol.TypeError, resp.Type)
+	assert.Contains(t, resp.Str, "Error running script")
+	assert.Equal(t, "(nil)", sendCommand(t, addr, "GET", "after"))
+	resp = c.do("EVAL", "return redis.pcall('INCR', 'str')", "0")
+	assert.EqualValues(t, protocol.TypeError, resp.Type)
+	assert.Equal(t, "ERR value is not an integer", resp.Str)
+	assert.Equal(t, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n",
+		c.doRaw("EVAL", "return redis.pcall('LPUSH', 'str', 'x')", "0"))
+	// Error replies of scripts go out as written, whatever their code.
+	assert.Equal(t, "-MYCODE oops\r\n", c.doRaw("EVAL", "return redis.error_reply('MYCODE oops')", "0"))
+	assert.Equal(t, "-ERR\r\n", c.doRaw("EVAL", "return redis.error_reply('')", "0"))
+	assert.Equal(t, "-ERR value is not an integer\r\n", c.doRaw("EVAL", "return {err='ERR value is not an integer'}", "0"))
+	for _, cmd := range []string{"MULTI", "BGREWRITEAOF", "BGSAVE", "SAVE"} {
+		resp = c.do("EVAL", "return redis.call('"+cmd+"')", "0")
+		assert.Contains(t, resp.Str, "not allowed from script", cmd)
+	}
+	assert.Contains(t, c.do("EVAL", "return 1", "2", "a").Str, "greater than number of args")
+	assert.Contains(t, c.do("EVAL", "return +", "0").Str, "Error compiling script")
+
+	// Inside MULTI the script is part of the transaction, in the caller's
+	// database.
+	c.do("SELECT", "1")
+	c.do("MULTI")
+	assert.Equal(t, "QUEUED", c.do("EVAL", "return redis.call('INCR', KEYS[1])", "1", "counter").Str)
+	resp = c.do("EXEC")
+	require.Len(t, resp.Array, 1)
+	assert.Equal(t, int64(1), resp.Array[0].Num)
+	assert.Equal(t, "5", sendCommand(t, addr, "GET", "counter"))
+
+}
+
+func TestServer_ScriptKill(t *testing.T) {
+	s, _ := setupTestServer(t)
+	s.scripts.timeLimit.Store(int64(20 * time.Millisecond))
+	addr := startTestServer(t, s)
+
+	c := dialTestConn(t, addr)
+	other := dialTestConn(t, addr)
+	assert.Equal(t, "-NOTBUSY No scripts in execution right now.\r\n", other.doRaw("SCRIPT", "KILL"))
+
+	// Other clients get BUSY once the script runs past the limit, and may
+	// kill it while it has not written.
+	c.send("EVAL", "while true do end", "0")
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "-BUSY "+busyError+"\r\n", other.doRaw("GET", "k"))
+	assert.Equal(t, "OK", other.do("SCRIPT", "KILL").Str)
+	assert.Contains(t, c.read().Str, "Script killed")
+	assert.True(t, other.do("GET", "k").Null)
+
+	// A script that wrote cannot be killed.
+	c.send("EVAL", "redis.call('SET', 'k', 'v'); local i = 0; while i < 1e9 do i = i + 1 end", "0")
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, strings.HasPrefix(other.doRaw("SCRIPT", "KILL"), "-UNKILLABLE "))
+	s.scripts.mu.Lock()
+	s.scripts.running.cancel()
+	s.scripts.mu.Unlock()
+	c.read()
+}
+
+func TestServer_ShutdownNoSave(t *testing.T) {
+	tmpDir := t.TempDir()
+	walPath := filepath.Join(tmpDir, "test.wal")
+	e, err := engine.New(walPath)
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	cfg := DefaultConfig()
+	cfg.ScriptTimeLimit = 20 * time.Millisecond
+	s := NewWithConfig(addr, e, cfg)
+	done := make(chan error, 1)
+	go func() { done <- s.Start(context.Background()) }()
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+
+	c := dialTestConn(t, addr)
+	other := dialTestConn(t, addr)
+	c.do("SET", "kept", "v")
+	assert.Equal(t, "-ERR syntax error\r\n", other.doRaw("SHUTDOWN", "NOW"))
+
+	// A script that wrote and never ends leaves only SHUTDOWN NOSAVE, which
+	// stops it and drops its writes.
+	c.send("EVAL", "redis.call('SET', 'lost', 'v'); while true do end", "0")
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, strings.HasPrefix(other.doRaw("SCRIPT", "KILL"), "-UNKILLABLE "))
+	assert.Equal(t, "-BUSY "+busyError+"\r\n", other.doRaw("SHUTDOWN"))
+	other.send("SHUTDOWN", "NOSAVE")
+	_, err = other.reader.ReadValue()
+	assert.Error(t, err)
+	_, err = c.reader.ReadValue()
+	assert.Error(t, err)
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("server did not stop")
+	}
+	require.NoError(t, e.Close())
+
+	e, err = engine.New(walPath)
+	require.NoError(t, err)
+	defer e.Close()
+	assert.True(t, e.Exists("kept"))
+	assert.False(t, e.Exists("lost"))
+}
+
+func TestServer_EvalRestart(t *testing.T) {
+	s, tmpDir := setupTestServer(t)
+	addr := startTestServer(t, s)
+
+	// Commands that flush, switch or swap databases go through the
+	// script's transaction like any other, so they replay as they ran.
+	c := dialTestConn(t, addr)
+	c.do("RPUSH", "list", "a")
+	c.do("SELECT", "3")
+	c.do("SET", "gone", "v")
+	c.do("SELECT", "0")
+	script := `
+redis.call('RPUSH', 'list', 'x')
+redis.call('FLUSHALL')
+redis.call('RPUSH', 'list', 'b')
+redis.call('SELECT', '2')
+redis.call('SET', 'in2', 'v')
+redis.call('SWAPDB', '0', '2')
+return redis.call('LLEN', 'list')`
+	assert.Equal(t, int64(1), c.do("EVAL", script, "0").Num)
+
+	require.NoError(t, s.engine.Close())
+	e, err := engine.New(filepath.Join(tmpDir, "test.wal"))
+	require.NoError(t, err)
+	s = New("127.0.0.1:0", e)
+	addr = startTestServer(t, s)
+	c = dialTestConn(t, addr)
+	assert.Equal(t, "v", c.do("GET", "in2").Str)
+	assert.Equal(t, int64(0), c.do("EXISTS", "list").Num)
+	c.do("SELECT", "2")
+	assert.Equal(t, int64(1), c.do("LLEN", "list").Num)
+	c.do("SELECT", "3")
+	assert.Equal(t, int64(0), c.do("DBSIZE").Num)
+}
@@ -0,0 +1,854 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flashdb/flashdb/internal/engine"
+	"github.com/flashdb/flashdb/internal/protocol"
+	"github.com/flashdb/flashdb/internal/store"
+)
+
+// ─── Stream commands ────────────────────────────────────────────────────────
+
+// parseXAddID parses the ID argument of XADD: "*", "<ms>-*" or an explicit
+// ID, where "<ms>" alone means "<ms>-0".
+func parseXAddID(arg string) (engine.XAddID, error) {
+	if arg == "*" {
+		return engine.XAddID{Auto: true}, nil
+	}
+	if ms, ok := strings.CutSuffix(arg, "-*"); ok {
+		id, err := store.ParseStreamID(ms, 0)
+		if err != nil || strings.Contains(ms, "-") {
+			return engine.XAddID{}, store.ErrInvalidStreamID
+		}
+		return engine.XAddID{ID: id, SeqAuto: true}, nil
+	}
+	id, err := store.ParseStreamID(arg, 0)
+	return engine.XAddID{ID: id}, err
+}
+
+// parseRangeID parses a bound of XRANGE and XPENDING: "-", "+", an ID, or an
+// ID prefixed with "(" to exclude it. An end bound without a sequence number
+// includes the whole millisecond. It returns false if an exclusive bound
+// leaves nothing to return.
+func parseRangeID(arg string, end bool) (store.StreamID, bool, error) {
+	switch arg {
+	case "-":
+		return store.StreamID{}, true, nil
+	case "+":
+		return store.MaxStreamID, true, nil
+	}
+	exclusive := strings.HasPrefix(arg, "(")
+	arg = strings.TrimPrefix(arg, "(")
+	var seq uint64
+	if end {
+		seq = store.MaxStreamID.Seq
+	}
+	id, err := store.ParseStreamID(arg, seq)
+	if err != nil || !exclusive {
+		return id, true, err
+	}
+	if end {
+		id, ok := id.Prev()
+		return id, ok, nil
+	}
+	id, ok := id.Next()
+	return id, ok, nil
+}
+
+// parseStreamTrim parses "MAXLEN|MINID [=|~] threshold [LIMIT count]" at the
+// start of args and returns the number of arguments used, or an error
+// message.
+func parseStreamTrim(args []protocol.Value) (engine.StreamTrim, int, string) {
+	var trim engine.StreamTrim
+	if len(args) < 2 {
+		return trim, 0, "syntax error"
+	}
+	trim.ByMinID = strings.EqualFold(args[0].Str, "MINID")
+	n := 1
+	approx := false
+	if args[n].Str == "=" || args[n].Str == "~" {
+		approx = args[n].Str == "~"
+		n++
+		if n >= len(args) {
+			return trim, 0, "syntax error"
+		}
+	}
+	if trim.ByMinID {
+		id, err := store.ParseStreamID(args[n].Str, 0)
+		if err != nil {
+			return trim, 0, err.Error()
+		}
+		trim.MinID = id
+	} else {
+		maxLen, err := strconv.Atoi(args[n].Str)
+		if err != nil {
+			return trim, 0, "value is not an integer or out of range"
+		}
+		if maxLen < 0 {
+			return trim, 0, "The MAXLEN argument must be >= 0."
+		}
+		trim.MaxLen = maxLen
+	}
+	n++
+	// Trimming is always exact, which LIMIT allows for.
+	if n+1 < len(args) && strings.EqualFold(args[n].Str, "LIMIT") {
+		if !approx {
+			return trim, 0, "syntax error, LIMIT cannot be used without the special ~ option"
+		}
+		if limit, err := strconv.Atoi(args[n+1].Str); err != nil || limit < 0 {
+			return trim, 0, "The LIMIT argument must be >= 0."
+		}
+		n += 2
+	}
+	return trim, n, ""
+}
+
+// writeStreamEntry writes an entry as [id, [field, value, ...]]. Deleted
+// entries, which have no fields, are written as [id, nil].
+func writeStreamEntry(w *protocol.Writer, entry store.StreamEntry) {
+	w.WriteArrayHeader(2)
+	w.WriteBulkString([]byte(entry.ID.String()))
+	if entry.Fields == nil {
+		w.WriteArrayHeader(-1)
+		return
+	}
+	w.WriteArray(entry.Fields)
+}
+
+func writeStreamEntries(w *protocol.Writer, entries []store.StreamEntry) {
+	w.WriteArrayHeader(len(entries))
+	for _, entry := range entries {
+		writeStreamEntry(w, entry)
+	}
+}
+
+// writeStreamReads writes the [[key, [entries]], ...] reply of XREAD and
+// XREADGROUP.
+func writeStreamReads(w *protocol.Writer, reads []engine.StreamRead) {
+	w.WriteArrayHeader(len(reads))
+	for _, r := range reads {
+		w.WriteArrayHeader(2)
+		w.WriteBulkString([]byte(r.Key))
+		writeStreamEntries(w, r.Entries)
+	}
+}
+
+// XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [field value ...]
+func (s *Server) cmdXAdd(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 4 {
+		w.WriteError("wrong number of arguments for 'XADD' command")
+		return
+	}
+	key := args[0].Str
+	noMkStream := false
+	var trim *engine.StreamTrim
+	i := 1
+options:
+	for i < len(args) {
+		switch strings.ToUpper(args[i].Str) {
+		case "NOMKSTREAM":
+			noMkStream = true
+			i++
+		case "MAXLEN", "MINID":
+			t, n, msg := parseStreamTrim(args[i:])
+			if msg != "" {
+				w.WriteError(msg)
+				return
+			}
+			trim = &t
+			i += n
+		default:
+			break options
+		}
+	}
+	if i >= len(args) {
+		w.WriteError("syntax error")
+		return
+	}
+	id, err := parseXAddID(args[i].Str)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
+	pairs := args[i+1:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		w.WriteError("wrong number of arguments for 'XADD' command")
+		return
+	}
+	fields := make([][]byte, len(pairs))
+	for j, v := range pairs {
+		fields[j] = []byte(v.Str)
+	}
+
+	added, ok, err := s.engine.XAdd(key, id, fields, noMkStream, trim)
+	if err != nil {
+		s.writeEngineError(w, "XADD", err)
+		return
+	}
+	if !ok {
+		w.WriteNull()
+		return
+	}
+	w.WriteBulkString([]byte(added.String()))
+}
+
+// XLEN key
+func (s *Server) cmdXLen(w *protocol.Writer, args []protocol.Value) {
+	if len(args) != 1 {
+		w.WriteError("wrong number of arguments for 'XLEN' command")
+		return
+	}
+	n, err := s.engine.XLen(args[0].Str)
+	if err != nil {
+		s.writeEngineError(w, "XLEN", err)
+		return
+	}
+	w.WriteInteger(int64(n))
+}
+
+// XRANGE key start end [COUNT count]
+// XREVRANGE key end start [COUNT count]
+func (s *Server) cmdXRange(w *protocol.Writer, cmd string, args []protocol.Value) {
+	if len(args) != 3 && len(args) != 5 {
+		w.WriteError("wrong number of arguments for '" + cmd + "' command")
+		return
+	}
+	rev := cmd == "XREVRANGE"
+	startArg, endArg := args[1].Str, args[2].Str
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+	start, ok1, err := parseRangeID(startArg, false)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
+	end, ok2, err := parseRangeID(endArg, true)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
+	count := 0
+	if len(args) == 5 {
+		if !strings.EqualFold(args[3].Str, "COUNT") {
+			w.WriteError("syntax error")
+			return
+		}
+		if count, err = strconv.Atoi(args[4].Str); err != nil {
+			w.WriteError("value is not an integer or out of range")
+			return
+		}
+		if count <= 0 {
+			ok1 = false
+		}
+	}
+	if !ok1 || !ok2 {
+		w.WriteArrayHeader(0)
+		return
+	}
+
+	entries, err := s.engine.XRange(args[0].Str, start, end, count, rev)
+	if err != nil {
+		s.writeEngineError(w, cmd, err)
+		return
+	}
+	writeStreamEntries(w, entries)
+}
+
+// XDEL key id [id ...]
+func (s *Server) cmdXDel(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 2 {
+		w.WriteError("wrong number of arguments for 'XDEL' command")
+		return
+	}
+	ids := make([]store.StreamID, len(args)-1)
+	for i, arg := range args[1:] {
+		id, err := store.ParseStreamID(arg.Str, 0)
+		if err != nil {
+			writeErrorReply(w, err.Error())
+			return
+		}
+		ids[i] = id
+	}
+	n, err := s.engine.XDel(args[0].Str, ids...)
+	if err != nil {
+		s.writeEngineError(w, "XDEL", err)
+		return
+	}
+	w.WriteInteger(int64(n))
+}
+
+// XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT count]
+func (s *Server) cmdXTrim(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 3 {
+		w.WriteError("wrong number of arguments for 'XTRIM' command")
+		return
+	}
+	mode := strings.ToUpper(args[1].Str)
+	if mode != "MAXLEN" && mode != "MINID" {
+		w.WriteError("syntax error")
+		return
+	}
+	trim, n, msg := parseStreamTrim(args[1:])
+	if msg == "" && 1+n != len(args) {
+		msg = "syntax error"
+	}
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+	removed, err := s.engine.XTrim(args[0].Str, trim)
+	if err != nil {
+		s.writeEngineError(w, "XTRIM", err)
+		return
+	}
+	w.WriteInteger(int64(removed))
+}
+
+// XSETID key last-id [ENTRIESADDED entries-added] [MAXDELETEDID max-deleted-id]
+func (s *Server) cmdXSetID(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 2 {
+		w.WriteError("wrong number of arguments for 'XSETID' command")
+		return
+	}
+	id, err := store.ParseStreamID(args[1].Str, 0)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
+	// The entry counters are not tracked; the options are only validated.
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			w.WriteError("syntax error")
+			return
+		}
+		switch strings.ToUpper(args[i].Str) {
+		case "ENTRIESADDED":
+			if _, err := strconv.ParseUint(args[i+1].Str, 10, 64); err != nil {
+				w.WriteError("value is not an integer or out of range")
+				return
+			}
+		case "MAXDELETEDID":
+			if _, err := store.ParseStreamID(args[i+1].Str, 0); err != nil {
+				writeErrorReply(w, err.Error())
+				return
+			}
+		default:
+			w.WriteError("syntax error")
+			return
+		}
+	}
+
+	ok, err := s.engine.XSetID(args[0].Str, id)
+	if err != nil {
+		s.writeEngineError(w, "XSETID", err)
+		return
+	}
+	if !ok {
+		w.WriteError("no such key")
+		return
+	}
+	w.WriteSimpleString("OK")
+}
+
+// streamReadArgs holds the options shared by XREAD and XREADGROUP.
+type streamReadArgs struct {
+	count   int
+	block   bool
+	timeout time.Duration
+	noAck   bool
+	keys    []string
+	ids     []string
+}
+
+// parseStreamReadArgs parses "[COUNT count] [BLOCK ms] [NOACK] STREAMS key
+// [key ...] id [id ...]"; NOACK is only accepted for XREADGROUP.
+func parseStreamReadArgs(cmd string, args []protocol.Value) (streamReadArgs, string) {
+	var r streamReadArgs
+	i := 0
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Str) {
+		case "COUNT":
+			if i+1 >= len(args) {
+				return r, "syntax error"
+			}
+			i++
+			n, err := strconv.Atoi(args[i].Str)
+			if err != nil {
+				return r, "value is not an integer or out of range"
+			}
+			r.count = max(n, 0)
+		case "BLOCK":
+			if i+1 >= len(args) {
+				return r, "syntax error"
+			}
+			i++
+			ms, err := strconv.ParseInt(args[i].Str, 10, 64)
+			if err != nil {
+				return r, "timeout is not an integer or out of range"
+			}
+			if ms < 0 {
+				return r, "timeout is negative"
+			}
+			r.block = true
+			r.timeout = time.Duration(ms) * time.Millisecond
+		case "NOACK":
+			if cmd != "XREADGROUP" {
+				return r, "syntax error"
+			}
+			r.noAck = true
+		case "STREAMS":
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return r, fmt.Sprintf("Unbalanced '%s' list of streams: for each stream key an ID or '$' must be specified.", strings.ToLower(cmd))
+			}
+			n := len(rest) / 2
+			for j := 0; j < n; j++ {
+				r.keys = append(r.keys, rest[j].Str)
+				r.ids = append(r.ids, rest[n+j].Str)
+			}
+			return r, ""
+		default:
+			return r, "syntax error"
+		}
+	}
+	return r, "syntax error"
+}
+
+// streamCursors keeps the cursors of keys, in the order of keys.
+func streamCursors(cursors map[string]engine.StreamCursor, keys []string) []engine.StreamCursor {
+	out := make([]engine.StreamCursor, 0, len(keys))
+	for _, key := range keys {
+		if c, ok := cursors[key]; ok {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// XREAD [COUNT count] [BLOCK ms] STREAMS key [key ...] id [id ...]
+func (s *Server) cmdXRead(w *protocol.Writer, client *clientConn, args []protocol.Value) {
+	if len(args) < 3 {
+		w.WriteError("wrong number of arguments for 'XREAD' command")
+		return
+	}
+	r, msg := parseStreamReadArgs("XREAD", args)
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+	cursors := make(map[string]engine.StreamCursor, len(r.keys))
+	for i, key := range r.keys {
+		c := engine.StreamCursor{Key: key}
+		if r.ids[i] == "$" {
+			last, _, err := s.engine.XLastID(key)
+			if err != nil {
+				s.writeEngineError(w, "XREAD", err)
+				return
+			}
+			c.ID = last
+		} else {
+			id, err := store.ParseStreamID(r.ids[i], 0)
+			if err != nil {
+				writeErrorReply(w, err.Error())
+				return
+			}
+			c.ID = id
+		}
+		cursors[key] = c
+	}
+
+	read := func(keys []string) (func(w *protocol.Writer), error) {
+		reads, err := s.engine.XRead(streamCursors(cursors, keys), r.count)
+		if err != nil || len(reads) == 0 {
+			return nil, err
+		}
+		return func(w *protocol.Writer) { writeStreamReads(w, reads) }, nil
+	}
+	if !r.block {
+		reply, err := read(r.keys)
+		switch {
+		case err != nil:
+			s.writeEngineError(w, "XREAD", err)
+		case reply == nil:
+			writeNullArray(w)
+		default:
+			reply(w)
+		}
+		return
+	}
+	s.blockingRead(w, client, "XREAD", r.keys, r.timeout, read, writeNullArray)
+}
+
+// XREADGROUP GROUP group consumer [COUNT count] [BLOCK ms] [NOACK] STREAMS key [key ...] id [id ...]
+func (s *Server) cmdXReadGroup(w *protocol.Writer, client *clientConn, args []protocol.Value) {
+	if len(args) < 6 {
+		w.WriteError("wrong number of arguments for 'XREADGROUP' command")
+		return
+	}
+	if !strings.EqualFold(args[0].Str, "GROUP") {
+		w.WriteError("syntax error")
+		return
+	}
+	group, consumer := args[1].Str, args[2].Str
+	r, msg := parseStreamReadArgs("XREADGROUP", args[3:])
+	if msg != "" {
+		w.WriteError(msg)
+		return
+	}
+	cursors := make(map[string]engine.StreamCursor, len(r.keys))
+	for i, key := range r.keys {
+		c := engine.StreamCursor{Key: key, New: r.ids[i] == ">"}
+		if !c.New {
+			id, err := store.ParseStreamID(r.ids[i], 0)
+			if err != nil {
+				writeErrorReply(w, err.Error())
+				return
+			}
+			c.ID = id
+		}
+		cursors[key] = c
+	}
+
+	read := func(keys []string) (func(w *protocol.Writer), error) {
+		reads, err := s.engine.XReadGroup(group, consumer, streamCursors(cursors, keys), r.count, r.noAck)
+		if err != nil || len(reads) == 0 {
+			return nil, err
+		}
+		return func(w *protocol.Writer) { writeStreamReads(w, reads) }, nil
+	}
+	if !r.block {
+		reply, err := read(r.keys)
+		switch {
+		case err != nil:
+			s.writeEngineError(w, "XREADGROUP", err)
+		case reply == nil:
+			writeNullArray(w)
+		default:
+			reply(w)
+		}
+		return
+	}
+	s.blockingRead(w, client, "XREADGROUP", r.keys, r.timeout, read, writeNullArray)
+}
+
+// XACK key group id [id ...]
+func (s *Server) cmdXAck(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 3 {
+		w.WriteError("wrong number of arguments for 'XACK' command")
+		return
+	}
+	ids := make([]store.StreamID, len(args)-2)
+	for i, arg := range args[2:] {
+		id, err := store.ParseStreamID(arg.Str, 0)
+		if err != nil {
+			writeErrorReply(w, err.Error())
+			return
+		}
+		ids[i] = id
+	}
+	n, err := s.engine.XAck(args[0].Str, args[1].Str, ids...)
+	if err != nil {
+		s.writeEngineError(w, "XACK", err)
+		return
+	}
+	w.WriteInteger(int64(n))
+}
+
+// XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+func (s *Server) cmdXPending(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 2 {
+		w.WriteError("wrong number of arguments for 'XPENDING' command")
+		return
+	}
+	key, group := args[0].Str, args[1].Str
+
+	if len(args) == 2 {
+		summary, err := s.engine.XPendingSummary(key, group)
+		if err != nil {
+			s.writeEngineError(w, "XPENDING", err)
+			return
+		}
+		w.WriteArrayHeader(4)
+		w.WriteInteger(int64(summary.Count))
+		if summary.Count == 0 {
+			w.WriteNull()
+			w.WriteNull()
+			w.WriteArrayHeader(-1)
+			return
+		}
+		w.WriteBulkString([]byte(summary.Min.String()))
+		w.WriteBulkString([]byte(summary.Max.String()))
+		w.WriteArrayHeader(len(summary.Consumers))
+		for _, c := range summary.Consumers {
+			w.WriteArray([][]byte{[]byte(c.Name), []byte(strconv.Itoa(c.Pending))})
+		}
+		return
+	}
+
+	rest := args[2:]
+	var minIdle time.Duration
+	if strings.EqualFold(rest[0].Str, "IDLE") {
+		if len(rest) < 2 {
+			w.WriteError("syntax error")
+			return
+		}
+		ms, err := strconv.ParseInt(rest[1].Str, 10, 64)
+		if err != nil {
+			w.WriteError("value is not an integer or out of range")
+			return
+		}
+		minIdle = time.Duration(ms) * time.Millisecond
+		rest = rest[2:]
+	}
+	if len(rest) != 3 && len(rest) != 4 {
+		w.WriteError("syntax error")
+		return
+	}
+	start, ok1, err := parseRangeID(rest[0].Str, false)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
+	end, ok2, err := parseRangeID(rest[1].Str, true)
+	if err != nil {
+		writeErrorReply(w, err.Error())
+		return
+	}
+	count, err := strconv.Atoi(rest[2].Str)
+	if err != nil {
+		w.WriteError("value is not an integer or out of range")
+		return
+	}
+	var consumer string
+	if len(rest) == 4 {
+		consumer = rest[3].Str
+	}
+
+	var pending []store.PendingEntry
+	if ok1 && ok2 && count > 0 {
+		pending, err = s.engine.XPendingRange(key, group, start, end, count, consumer, minIdle)
+	} else {
+		// Still report a missing group.
+		_, err = s.engine.XPendingSummary(key, group)
+	}
+	if err != nil {
+		s.writeEngineError(w, "XPENDING", err)
+		return
+	}
+	now := time.Now().UnixMilli()
+	w.WriteArrayHeader(len(pending))
+	for _, pe := range pending {
+		w.WriteArrayHeader(4)
+		w.WriteBulkString([]byte(pe.ID.String()))
+		w.WriteBulkString([]byte(pe.Consumer))
+		w.WriteInteger(max(now-pe.DeliveredAt, 0))
+		w.WriteInteger(pe.Deliveries)
+	}
+}
+
+// XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds]
+// [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid]
+func (s *Server) cmdXClaim(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 5 {
+		w.WriteError("wrong number of arguments for 'XCLAIM' command")
+		return
+	}
+	key, group, consumer := args[0].Str, args[1].Str, args[2].Str
+	ms, err := strconv.ParseInt(args[3].Str, 10, 64)
+	if err != nil {
+		w.WriteError("Invalid min-idle-time argument for XCLAIM")
+		return
+	}
+	minIdle := time.Duration(max(ms, 0)) * time.Millisecond
+
+	i := 4
+	var ids []store.StreamID
+	for ; i < len(args); i++ {
+		id, err := store.ParseStreamID(args[i].Str, 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		w.WriteError(store.ErrInvalidStreamID.Error())
+		return
+	}
+
+	var opts engine.XClaimOptions
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(args[i].Str)
+		switch opt {
+		case "FORCE":
+			opts.Force = true
+			continue
+		case "JUSTID":
+			opts.JustID = true
+			continue
+		case "IDLE", "TIME", "RETRYCOUNT", "LASTID":
+		default:
+			w.WriteError(fmt.Sprintf("Unrecognized XCLAIM option '%s'", args[i].Str))
+			return
+		}
+		if i+1 >= len(args) {
+			w.WriteError("syntax error")
+			return
+		}
+		i++
+		if opt == "LASTID" {
+			id, err := store.ParseStreamID(args[i].Str, 0)
+			if err != nil {
+				writeErrorReply(w, err.Error())
+				return
+			}
+			opts.LastID = id
+			continue
+		}
+		n, err := strconv.ParseInt(args[i].Str, 10, 64)
+		if err != nil {
+			w.WriteError(fmt.Sprintf("Invalid %s option argument for XCLAIM", opt))
+			return
+		}
+		switch opt {
+		case "IDLE":
+			opts.DeliveredAt = time.Now().UnixMilli() - max(n, 0)
+		case "TIME":
+			opts.DeliveredAt = n
+		case "RETRYCOUNT":
+			opts.RetryCount, opts.HasRetryCount = max(n, 0), true
+		}
+	}
+
+	claimed, err := s.engine.XClaim(key, group, consumer, minIdle, ids, opts)
+	if err != nil {
+		s.writeEngineError(w, "XCLAIM", err)
+		return
+	}
+	if opts.JustID {
+		w.WriteArrayHeader(len(claimed))
+		for _, entry := range claimed {
+			w.WriteBulkString([]byte(entry.ID.String()))
+		}
+		return
+	}
+	writeStreamEntries(w, claimed)
+}
+
+// parseGroupID parses the ID argument of XGROUP CREATE and SETID, where "$"
+// stands for the stream's last ID.
+func parseGroupID(arg string) (id store.StreamID, fromLast bool, err error) {
+	if arg == "$" {
+		return store.StreamID{}, true, nil
+	}
+	id, err = store.ParseStreamID(arg, 0)
+	return id, false, err
+}
+
+// XGROUP CREATE key group id|$ [MKSTREAM] [ENTRIESREAD entries-read]
+// XGROUP SETID key group id|$ [ENTRIESREAD entries-read]
+// XGROUP DESTROY key group
+// XGROUP CREATECONSUMER key group consumer
+// XGROUP DELCONSUMER key group consumer
+func (s *Server) cmdXGroup(w *protocol.Writer, args []protocol.Value) {
+	if len(args) < 1 {
+		w.WriteError("wrong number of arguments for 'XGROUP' command")
+		return
+	}
+	sub := strings.ToUpper(args[0].Str)
+	args = args[1:]
+	wrongArgs := func() {
+		w.WriteError("wrong number of arguments for 'XGROUP|" + sub + "' command")
+	}
+
+	switch sub {
+	case "CREATE", "SETID":
+		if len(args) < 3 {
+			wrongArgs()
+			return
+		}
+		id, fromLast, err := parseGroupID(args[2].Str)
+		if err != nil {
+			writeErrorReply(w, err.Error())
+			return
+		}
+		mkstream := false
+		for i := 3; i < len(args); i++ {
+			switch strings.ToUpper(args[i].Str) {
+			case "MKSTREAM":
+				if sub != "CREATE" {
+					w.WriteError("syntax error")
+					return
+				}
+				mkstream = true
+			case "ENTRIESREAD":
+				// The entries-read counter is not tracked; only validate it.
+				if i+1 >= len(args) {
+					w.WriteError("syntax error")
+					return
+				}
+				i++
+				if _, err := strconv.ParseInt(args[i].Str, 10, 64); err != nil {
+					w.WriteError("value is not an integer or out of range")
+					return
+				}
+			default:
+				w.WriteError("syntax error")
+				return
+			}
+		}
+		if sub == "CREATE" {
+			err = s.engine.XGroupCreate(args[0].Str, args[1].Str, id, fromLast, mkstream)
+		} else {
+			err = s.engine.XGroupSetID(args[0].Str, args[1].Str, id, fromLast)
+		}
+		if err != nil {
+			s.writeEngineError(w, "XGROUP", err)
+			return
+		}
+		w.WriteSimpleString("OK")
+
+	case "DESTROY":
+		if len(args) != 2 {
+			wrongArgs()
+			return
+		}
+		ok, err := s.engine.XGroupDestroy(args[0].Str, args[1].Str)
+		if err != nil {
+			s.writeEngineError(w, "XGROUP", err)
+			return
+		}
+		w.WriteInteger(int64(boolToInt(ok)))
+
+	case "CREATECONSUMER":
+		if len(args) != 3 {
+			wrongArgs()
+			return
+		}
+		ok, err := s.engine.XGroupCreateConsumer(args[0].Str, args[1].Str, args[2].Str)
+		if err != nil {
+			s.writeEngineError(w, "XGROUP", err)
+			return
+		}
+		w.WriteInteger(int64(boolToInt(ok)))
+
+	case "DELCONSUMER":
+		if len(args) != 3 {
+			wrongArgs()
+			return
+		}
+		pending, err := s.engine.XGroupDelConsumer(args[0].Str, args[1].Str, args[2].Str)
+		if err != nil {
+			s.writeEngineError(w, "XGROUP", err)
+			return
+		}
+		w.WriteInteger(int64(pending))
+
+	default:
+		w.WriteError(fmt.Sprintf("unknown subcommand '%s' for XGROUP", sub))
+	}
+}
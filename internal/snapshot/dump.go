@@ -0,0 +1,112 @@
+package snapshot
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// DUMP payload layout (integers are little-endian):
+//
+//	section  u8      type of the value, as in snapshot chunks
+//	entry    ...     the snapshot encoding of the entry, with an empty key
+//	version  u16     DumpVersion
+//	crc      u32     CRC32 (IEEE) of everything before it
+//
+// The entry carries the TTL as an absolute expiry time, so a payload fully
+// describes one key apart from its name.
+
+// DumpVersion is the version of the payload written by Dump.
+const DumpVersion = 1
+
+// ErrBadPayload indicates a DUMP payload with a wrong checksum, an unknown
+// version or data that does not decode.
+var ErrBadPayload = errors.New("snapshot: DUMP payload version or checksum are wrong")
+
+// Dump serializes a KVEntry, HashEntry, ListEntry, SetEntry, ZSetEntry,
+// StreamEntry or JSONEntry into a self-describing payload. The entry's key is not included.
+func Dump(entry any) ([]byte, error) {
+	section, ok := entrySection(entry)
+	if !ok || !keySection(section) {
+		return nil, errors.New("snapshot: unsupported entry type")
+	}
+	buf := []byte{byte(section)}
+	buf = appendEntry(buf, withKey(entry, ""))
+	buf = binary.LittleEndian.AppendUint16(buf, DumpVersion)
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf)), nil
+}
+
+// Undump verifies a payload written by Dump and returns its entry under key.
+func Undump(key string, payload []byte) (any, error) {
+	if len(payload) < 1+2+4 {
+		return nil, ErrBadPayload
+	}
+	body, sum := payload[:len(payload)-4], payload[len(payload)-4:]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(sum) {
+		return nil, ErrBadPayload
+	}
+	version := binary.LittleEndian.Uint16(body[len(body)-2:])
+	if version == 0 || version > DumpVersion {
+		return nil, ErrBadPayload
+	}
+
+	section := Section(body[0])
+	if !keySection(section) {
+		return nil, ErrBadPayload
+	}
+	d := &decoder{buf: body[1 : len(body)-2]}
+	entry := decodeEntry(d, section)
+	if entry == nil || d.err != nil || len(d.buf) != 0 || entryEmpty(entry) {
+		return nil, ErrBadPayload
+	}
+	return withKey(entry, key), nil
+}
+
+// keySection reports whether section holds keys, which can be dumped.
+func keySection(section Section) bool {
+	return section != SectionTimeSeries && section != SectionIndexes && section != SectionDatabase
+}
+
+// withKey returns entry renamed to key.
+func withKey(entry any, key string) any {
+	switch e := entry.(type) {
+	case KVEntry:
+		e.Key = key
+		return e
+	case HashEntry:
+		e.Key = key
+		return e
+	case ListEntry:
+		e.Key = key
+		return e
+	case SetEntry:
+		e.Key = key
+		return e
+	case ZSetEntry:
+		e.Key = key
+		return e
+	case StreamEntry:
+		e.Key = key
+		return e
+	case JSONEntry:
+		e.Key = key
+		return e
+	}
+	return entry
+}
+
+// entryEmpty reports whether entry is a collection without elements, which
+// cannot exist as a key. Streams exist even when empty.
+func entryEmpty(entry any) bool {
+	switch e := entry.(type) {
+	case HashEntry:
+		return len(e.Fields) == 0
+	case ListEntry:
+		return len(e.Values) == 0
+	case SetEntry:
+		return len(e.Members) == 0
+	case ZSetEntry:
+		return len(e.Members) == 0
+	}
+	return false
+}
@@ -0,0 +1,875 @@
+package snapshot
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// File layout (integers are little-endian):
+//
+//	header:   magic "FLASHSNP" | version uint16 | created unix nanos int64 |
+//	          id length uint16 | id | CRC32 of the preceding header bytes
+//	chunk:    section byte | entry count uint32 | payload length uint32 |
+//	          payload | CRC32 of section..payload
+//	manifest: a final chunk of section SectionManifest whose payload lists,
+//	          for every section written, section byte | chunks uint32 |
+//	          entries uint64
+//
+// Each section is written as a run of chunks of up to chunkSize bytes, so a
+// snapshot is produced and consumed one chunk at a time. A file that ends
+// before the manifest is truncated; any checksum, count or encoding mismatch
+// means it is corrupt. Files without the magic are legacy gob snapshots.
+
+// Section identifies the kind of entries stored in a chunk.
+type Section byte
+
+const (
+	SectionStrings    Section = 0x01
+	SectionHashes     Section = 0x02
+	SectionLists      Section = 0x03
+	SectionSets       Section = 0x04
+	SectionZSets      Section = 0x05
+	SectionTimeSeries Section = 0x06
+	SectionStreams    Section = 0x07
+	SectionJSON       Section = 0x08
+	SectionIndexes    Section = 0x09
+	SectionDatabase   Section = 0x0A
+	SectionManifest   Section = 0xFF
+)
+
+// FormatVersion is the version of the binary format written by Writer.
+// Version 2 added SectionStreams, version 3 SectionJSON, version 4
+// SectionIndexes, version 5 SectionDatabase.
+const FormatVersion = 5
+
+const (
+	magic        = "FLASHSNP"
+	chunkSize    = 64 << 10
+	maxChunkSize = 1 << 30
+)
+
+var (
+	// ErrCorrupt indicates a checksum or structural mismatch in a snapshot file.
+	ErrCorrupt = errors.New("snapshot: file is corrupt")
+	// ErrTruncated indicates a snapshot file that ends before its manifest.
+	ErrTruncated = errors.New("snapshot: file is truncated")
+	// ErrUnsupportedVersion indicates a snapshot written by a newer format.
+	ErrUnsupportedVersion = errors.New("snapshot: unsupported format version")
+)
+
+type sectionStats struct {
+	chunks  uint32
+	entries uint64
+}
+
+// Writer streams entries into a new snapshot file. The file only appears
+// under its final name once Close succeeds.
+type Writer struct {
+	f       *os.File
+	bw      *bufio.Writer
+	path    string
+	tmpPath string
+	meta    Meta
+
+	section Section
+	buf     []byte
+	count   uint32
+	stats   map[Section]*sectionStats
+	order   []Section
+}
+
+// NewWriter starts a snapshot with the given ID (generated when empty).
+func (m *Manager) NewWriter(id string) (*Writer, error) {
+	if id == "" {
+		id = fmt.Sprintf("snap-%d", time.Now().UnixMilli())
+	}
+	path := filepath.Join(m.dir, id+".snap")
+	tmpPath := path + ".tmp"
+
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot: create file: %w", err)
+	}
+
+	w := &Writer{
+		f:       f,
+		bw:      bufio.NewWriterSize(f, chunkSize),
+		path:    path,
+		tmpPath: tmpPath,
+		meta:    Meta{ID: id, CreatedAt: time.Now(), FilePath: path, Version: FormatVersion},
+		stats:   make(map[Section]*sectionStats),
+	}
+	if _, err := w.bw.Write(encodeHeader(id, w.meta.CreatedAt)); err != nil {
+		w.Abort()
+		return nil, fmt.Errorf("snapshot: write header: %w", err)
+	}
+	return w, nil
+}
+
+// Meta returns the metadata of the snapshot being written. SizeBytes is only
+// known once Close returns.
+func (w *Writer) Meta() Meta {
+	return w.meta
+}
+
+// Write adds one entry: a KVEntry, HashEntry, ListEntry, SetEntry,
+// ZSetEntry, StreamEntry, JSONEntry, SeriesEntry, IndexEntry or
+// DatabaseEntry.
+func (w *Writer) Write(entry any) error {
+	section, ok := entrySection(entry)
+	if !ok {
+		return fmt.Errorf("snapshot: unsupported entry type %T", entry)
+	}
+	if section != w.section && w.count > 0 {
+		if err := w.flushChunk(); err != nil {
+			return err
+		}
+	}
+	w.section = section
+	w.buf = appendEntry(w.buf, entry)
+	w.count++
+	if len(w.buf) > maxChunkSize {
+		return fmt.Errorf("snapshot: entry too large")
+	}
+	if len(w.buf) >= chunkSize {
+		return w.flushChunk()
+	}
+	return nil
+}
+
+// Close writes the manifest, syncs the file and moves it into place.
+func (w *Writer) Close() (Meta, error) {
+	if w.count > 0 {
+		if err := w.flushChunk(); err != nil {
+			w.Abort()
+			return Meta{}, err
+		}
+	}
+
+	var manifest []byte
+	for _, sec := range w.order {
+		st := w.stats[sec]
+		manifest = append(manifest, byte(sec))
+		manifest = binary.LittleEndian.AppendUint32(manifest, st.chunks)
+		manifest = binary.LittleEndian.AppendUint64(manifest, st.entries)
+	}
+	if err := w.writeChunk(SectionManifest, uint32(len(w.order)), manifest); err != nil {
+		w.Abort()
+		return Meta{}, err
+	}
+
+	if err := w.bw.Flush(); err != nil {
+		w.Abort()
+		return Meta{}, fmt.Errorf("snapshot: write: %w", err)
+	}
+	if err := w.f.Sync(); err != nil {
+		w.Abort()
+		return Meta{}, fmt.Errorf("snapshot: sync: %w", err)
+	}
+	info, err := w.f.Stat()
+	if err != nil {
+		w.Abort()
+		return Meta{}, fmt.Errorf("snapshot: stat: %w", err)
+	}
+	if err := w.f.Close(); err != nil {
+		os.Remove(w.tmpPath)
+		return Meta{}, fmt.Errorf("snapshot: close: %w", err)
+	}
+	if err := os.Rename(w.tmpPath, w.path); err != nil {
+		os.Remove(w.tmpPath)
+		return Meta{}, fmt.Errorf("snapshot: rename: %w", err)
+	}
+	syncDir(filepath.Dir(w.path))
+
+	w.meta.SizeBytes = info.Size()
+	w.meta.Status = StatusComplete
+	w.meta.Progress = 1
+	return w.meta, nil
+}
+
+// Abort discards the partially written snapshot.
+func (w *Writer) Abort() {
+	w.f.Close()
+	os.Remove(w.tmpPath)
+}
+
+func (w *Writer) flushChunk() error {
+	if err := w.writeChunk(w.section, w.count, w.buf); err != nil {
+		return err
+	}
+	st, ok := w.stats[w.section]
+	if !ok {
+		st = &sectionStats{}
+		w.stats[w.section] = st
+		w.order = append(w.order, w.section)
+	}
+	st.chunks++
+	st.entries += uint64(w.count)
+	w.buf = w.buf[:0]
+	w.count = 0
+	return nil
+}
+
+func (w *Writer) writeChunk(section Section, count uint32, payload []byte) error {
+	hdr := make([]byte, 9)
+	hdr[0] = byte(section)
+	binary.LittleEndian.PutUint32(hdr[1:5], count)
+	binary.LittleEndian.PutUint32(hdr[5:9], uint32(len(payload)))
+
+	crc := crc32.NewIEEE()
+	crc.Write(hdr)
+	crc.Write(payload)
+
+	if _, err := w.bw.Write(hdr); err != nil {
+		return fmt.Errorf("snapshot: write: %w", err)
+	}
+	if _, err := w.bw.Write(payload); err != nil {
+		return fmt.Errorf("snapshot: write: %w", err)
+	}
+	if _, err := w.bw.Write(binary.LittleEndian.AppendUint32(nil, crc.Sum32())); err != nil {
+		return fmt.Errorf("snapshot: write: %w", err)
+	}
+	return nil
+}
+
+// Reader streams the entries of a snapshot file, verifying every chunk.
+type Reader struct {
+	f    *os.File
+	br   *bufio.Reader
+	meta Meta
+
+	section   Section
+	dec       decoder
+	remaining uint32
+	chunks    int
+	stats     map[Section]*sectionStats
+	done      bool
+
+	legacy []any
+}
+
+// Open opens a snapshot for streaming by ID.
+func (m *Manager) Open(id string) (*Reader, error) {
+	path := filepath.Join(m.dir, id+".snap")
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot: open %s: %w", id, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("snapshot: stat %s: %w", id, err)
+	}
+
+	r := &Reader{
+		f:     f,
+		br:    bufio.NewReaderSize(f, chunkSize),
+		meta:  Meta{ID: id, SizeBytes: info.Size(), FilePath: path, Status: StatusComplete, Progress: 1},
+		stats: make(map[Section]*sectionStats),
+	}
+
+	if prefix, _ := r.br.Peek(len(magic)); string(prefix) != magic {
+		if err := r.openLegacy(info.ModTime()); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return r, nil
+	}
+
+	hdr, err := readHeader(r.br)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%w: %s: header: %v", headerErr(err), id, err)
+	}
+	r.meta.CreatedAt = hdr.CreatedAt
+	r.meta.Version = hdr.Version
+	return r, nil
+}
+
+// openLegacy decodes a gob snapshot written before the binary format.
+func (r *Reader) openLegacy(modTime time.Time) error {
+	var snap Snapshot
+	if err := gob.NewDecoder(r.br).Decode(&snap); err != nil {
+		return fmt.Errorf("snapshot: decode %s: %w", r.meta.ID, err)
+	}
+	r.meta.CreatedAt = snap.CreatedAt
+	if r.meta.CreatedAt.IsZero() {
+		r.meta.CreatedAt = modTime
+	}
+	for _, e := range snap.Strings {
+		r.legacy = append(r.legacy, e)
+	}
+	for _, e := range snap.Hashes {
+		r.legacy = append(r.legacy, e)
+	}
+	for _, e := range snap.Lists {
+		r.legacy = append(r.legacy, e)
+	}
+	for _, e := range snap.Sets {
+		r.legacy = append(r.legacy, e)
+	}
+	for _, e := range snap.ZSets {
+		r.legacy = append(r.legacy, e)
+	}
+	for _, e := range snap.TimeSeries {
+		r.legacy = append(r.legacy, e)
+	}
+	r.done = true
+	return nil
+}
+
+// Meta returns the snapshot metadata read from the file header.
+func (r *Reader) Meta() Meta {
+	return r.meta
+}
+
+// Next returns the next entry (see Writer.Write for the types), or io.EOF
+// once the whole file has been read and its manifest verified.
+func (r *Reader) Next() (any, error) {
+	if r.legacy != nil {
+		if len(r.legacy) == 0 {
+			return nil, io.EOF
+		}
+		entry := r.legacy[0]
+		r.legacy = r.legacy[1:]
+		return entry, nil
+	}
+
+	for r.remaining == 0 {
+		if r.done {
+			return nil, io.EOF
+		}
+		if len(r.dec.buf) > 0 {
+			return nil, r.corrupt("chunk %d has trailing bytes", r.chunks)
+		}
+		if err := r.readChunk(); err != nil {
+			return nil, err
+		}
+	}
+
+	entry := decodeEntry(&r.dec, r.section)
+	if r.dec.err != nil {
+		return nil, r.corrupt("chunk %d: %v", r.chunks, r.dec.err)
+	}
+	r.remaining--
+	return entry, nil
+}
+
+// Close releases the file.
+func (r *Reader) Close() error {
+	return r.f.Close()
+}
+
+func (r *Reader) readChunk() error {
+	hdr := make([]byte, 9)
+	if _, err := io.ReadFull(r.br, hdr); err != nil {
+		return r.readErr(err)
+	}
+	section := Section(hdr[0])
+	count := binary.LittleEndian.Uint32(hdr[1:5])
+	length := binary.LittleEndian.Uint32(hdr[5:9])
+	if length > maxChunkSize {
+		return r.corrupt("chunk %d length %d out of range", r.chunks+1, length)
+	}
+
+	payload := make([]byte, length+4)
+	if _, err := io.ReadFull(r.br, payload); err != nil {
+		return r.readErr(err)
+	}
+	sum := binary.LittleEndian.Uint32(payload[length:])
+	payload = payload[:length]
+
+	crc := crc32.NewIEEE()
+	crc.Write(hdr)
+	crc.Write(payload)
+	r.chunks++
+	if crc.Sum32() != sum {
+		return r.corrupt("chunk %d checksum mismatch", r.chunks)
+	}
+
+	if section == SectionManifest {
+		if err := r.verifyManifest(count, payload); err != nil {
+			return err
+		}
+		if _, err := r.br.Peek(1); err != io.EOF {
+			return r.corrupt("data after manifest")
+		}
+		r.done = true
+		return nil
+	}
+	if _, ok := sectionNames[section]; !ok {
+		return r.corrupt("chunk %d has unknown section 0x%02x", r.chunks, byte(section))
+	}
+
+	st, ok := r.stats[section]
+	if !ok {
+		st = &sectionStats{}
+		r.stats[section] = st
+	}
+	st.chunks++
+	st.entries += uint64(count)
+
+	r.section = section
+	r.dec = decoder{buf: payload}
+	r.remaining = count
+	return nil
+}
+
+func (r *Reader) verifyManifest(count uint32, payload []byte) error {
+	if uint64(len(payload)) != uint64(count)*13 || int(count) != len(r.stats) {
+		return r.corrupt("manifest lists %d sections, read %d", count, len(r.stats))
+	}
+	for i := 0; i < int(count); i++ {
+		rec := payload[i*13:]
+		section := Section(rec[0])
+		chunks := binary.LittleEndian.Uint32(rec[1:5])
+		entries := binary.LittleEndian.Uint64(rec[5:13])
+		st, ok := r.stats[section]
+		if !ok || st.chunks != chunks || st.entries != entries {
+			return r.corrupt("%s section does not match manifest", sectionNames[section])
+		}
+	}
+	return nil
+}
+
+func (r *Reader) readErr(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: %s: ends after %d chunks", ErrTruncated, r.meta.ID, r.chunks)
+	}
+	return fmt.Errorf("snapshot: read %s: %w", r.meta.ID, err)
+}
+
+func (r *Reader) corrupt(format string, args ...any) error {
+	return fmt.Errorf("%w: %s: %s", ErrCorrupt, r.meta.ID, fmt.Sprintf(format, args...))
+}
+
+var sectionNames = map[Section]string{
+	SectionStrings:    "strings",
+	SectionHashes:     "hashes",
+	SectionLists:      "lists",
+	SectionSets:       "sets",
+	SectionZSets:      "zsets",
+	SectionTimeSeries: "timeseries",
+	SectionStreams:    "streams",
+	SectionJSON:       "json",
+	SectionIndexes:    "indexes",
+	SectionDatabase:   "database",
+}
+
+// header is the fixed preamble of a binary snapshot.
+type header struct {
+	Version   int
+	CreatedAt time.Time
+	ID        string
+}
+
+func encodeHeader(id string, createdAt time.Time) []byte {
+	buf := []byte(magic)
+	buf = binary.LittleEndian.AppendUint16(buf, FormatVersion)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(createdAt.UnixNano()))
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(id)))
+	buf = append(buf, id...)
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+}
+
+// errHeaderVersion marks a header that is intact but too new to read.
+var errHeaderVersion = errors.New("unsupported version")
+
+func readHeader(r io.Reader) (header, error) {
+	fixed := make([]byte, len(magic)+12)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return header{}, err
+	}
+	if string(fixed[:len(magic)]) != magic {
+		return header{}, errors.New("bad magic")
+	}
+	version := int(binary.LittleEndian.Uint16(fixed[8:10]))
+	created := int64(binary.LittleEndian.Uint64(fixed[10:18]))
+	idLen := int(binary.LittleEndian.Uint16(fixed[18:20]))
+
+	rest := make([]byte, idLen+4)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return header{}, err
+	}
+	sum := binary.LittleEndian.Uint32(rest[idLen:])
+	crc := crc32.NewIEEE()
+	crc.Write(fixed)
+	crc.Write(rest[:idLen])
+	if crc.Sum32() != sum {
+		return header{}, errors.New("checksum mismatch")
+	}
+	if version > FormatVersion {
+		return header{}, fmt.Errorf("%w %d", errHeaderVersion, version)
+	}
+	return header{Version: version, CreatedAt: time.Unix(0, created), ID: string(rest[:idLen])}, nil
+}
+
+// headerErr classifies a readHeader failure.
+func headerErr(err error) error {
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return ErrTruncated
+	case errors.Is(err, errHeaderVersion):
+		return ErrUnsupportedVersion
+	default:
+		return ErrCorrupt
+	}
+}
+
+// syncDir fsyncs a directory so that a rename inside it is durable.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// ========================
+// Entry encoding
+// ========================
+
+func entrySection(entry any) (Section, bool) {
+	switch entry.(type) {
+	case KVEntry:
+		return SectionStrings, true
+	case HashEntry:
+		return SectionHashes, true
+	case ListEntry:
+		return SectionLists, true
+	case SetEntry:
+		return SectionSets, true
+	case ZSetEntry:
+		return SectionZSets, true
+	case StreamEntry:
+		return SectionStreams, true
+	case JSONEntry:
+		return SectionJSON, true
+	case SeriesEntry:
+		return SectionTimeSeries, true
+	case IndexEntry:
+		return SectionIndexes, true
+	case DatabaseEntry:
+		return SectionDatabase, true
+	}
+	return 0, false
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func appendFloat(buf []byte, f float64) []byte {
+	return binary.LittleEndian.AppendUint64(buf, math.Float64bits(f))
+}
+
+func appendStreamID(buf []byte, id StreamID) []byte {
+	buf = binary.AppendUvarint(buf, id.Ms)
+	return binary.AppendUvarint(buf, id.Seq)
+}
+
+func appendEntry(buf []byte, entry any) []byte {
+	switch e := entry.(type) {
+	case KVEntry:
+		buf = appendString(buf, e.Key)
+		buf = binary.AppendVarint(buf, e.ExpireAt)
+		buf = appendString(buf, e.Value)
+	case HashEntry:
+		buf = appendString(buf, e.Key)
+		buf = binary.AppendVarint(buf, e.ExpireAt)
+		buf = binary.AppendUvarint(buf, uint64(len(e.Fields)))
+		for f, v := range e.Fields {
+			buf = appendString(buf, f)
+			buf = appendString(buf, v)
+		}
+	case ListEntry:
+		buf = appendString(buf, e.Key)
+		buf = binary.AppendVarint(buf, e.ExpireAt)
+		buf = binary.AppendUvarint(buf, uint64(len(e.Values)))
+		for _, v := range e.Values {
+			buf = appendString(buf, v)
+		}
+	case SetEntry:
+		buf = appendString(buf, e.Key)
+		buf = binary.AppendVarint(buf, e.ExpireAt)
+		buf = binary.AppendUvarint(buf, uint64(len(e.Members)))
+		for _, m := range e.Members {
+			buf = appendString(buf, m)
+		}
+	case ZSetEntry:
+		buf = appendString(buf, e.Key)
+		buf = binary.AppendVarint(buf, e.ExpireAt)
+		buf = binary.AppendUvarint(buf, uint64(len(e.Members)))
+		for _, m := range e.Members {
+			buf = appendString(buf, m.Member)
+			buf = appendFloat(buf, m.Score)
+		}
+	case StreamEntry:
+		buf = appendString(buf, e.Key)
+		buf = binary.AppendVarint(buf, e.ExpireAt)
+		buf = appendStreamID(buf, e.LastID)
+		buf = binary.AppendUvarint(buf, uint64(len(e.Entries)))
+		for _, item := range e.Entries {
+			buf = appendStreamID(buf, item.ID)
+			buf = binary.AppendUvarint(buf, uint64(len(item.Fields)))
+			for _, f := range item.Fields {
+				buf = appendString(buf, f)
+			}
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(e.Groups)))
+		for _, g := range e.Groups {
+			buf = appendString(buf, g.Name)
+			buf = appendStreamID(buf, g.LastID)
+			buf = binary.AppendUvarint(buf, uint64(len(g.Consumers)))
+			for _, c := range g.Consumers {
+				buf = appendString(buf, c.Name)
+				buf = binary.AppendVarint(buf, c.SeenAt)
+			}
+			buf = binary.AppendUvarint(buf, uint64(len(g.Pending)))
+			for _, p := range g.Pending {
+				buf = appendStreamID(buf, p.ID)
+				buf = appendString(buf, p.Consumer)
+				buf = binary.AppendVarint(buf, p.DeliveredAt)
+				buf = binary.AppendVarint(buf, p.Deliveries)
+			}
+		}
+	case JSONEntry:
+		buf = appendString(buf, e.Key)
+		buf = binary.AppendVarint(buf, e.ExpireAt)
+		buf = appendString(buf, e.Value)
+	case SeriesEntry:
+		buf = appendString(buf, e.Key)
+		buf = binary.AppendVarint(buf, int64(e.Retention))
+		buf = binary.AppendUvarint(buf, uint64(len(e.Labels)))
+		for k, v := range e.Labels {
+			buf = appendString(buf, k)
+			buf = appendString(buf, v)
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(e.Points)))
+		for _, p := range e.Points {
+			buf = binary.AppendVarint(buf, p.Timestamp)
+			buf = appendFloat(buf, p.Value)
+		}
+	case IndexEntry:
+		buf = appendString(buf, e.Name)
+		buf = binary.AppendUvarint(buf, uint64(len(e.Prefixes)))
+		for _, p := range e.Prefixes {
+			buf = appendString(buf, p)
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(e.Fields)))
+		for _, f := range e.Fields {
+			buf = appendString(buf, f.Name)
+			buf = appendString(buf, f.Type)
+			buf = appendString(buf, f.Separator)
+			sortable := byte(0)
+			if f.Sortable {
+				sortable = 1
+			}
+			buf = append(buf, sortable)
+		}
+	case DatabaseEntry:
+		buf = binary.AppendUvarint(buf, uint64(e.DB))
+	}
+	return buf
+}
+
+// decoder reads entry fields from a chunk payload. The first failure is
+// kept in err and turns every later read into a no-op.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+var errShortPayload = errors.New("entry runs past end of chunk")
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errShortPayload
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errShortPayload
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+// count reads a collection length, rejecting values that could not fit in
+// the rest of the payload.
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.buf)) {
+		d.err = errShortPayload
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) str() string {
+	n := d.count()
+	if d.err != nil {
+		return ""
+	}
+	s := string(d.buf[:n])
+	d.buf = d.buf[n:]
+	return s
+}
+
+func (d *decoder) float() float64 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.buf) < 8 {
+		d.err = errShortPayload
+		return 0
+	}
+	f := math.Float64frombits(binary.LittleEndian.Uint64(d.buf))
+	d.buf = d.buf[8:]
+	return f
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.buf) < 1 {
+		d.err = errShortPayload
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *decoder) streamID() StreamID {
+	return StreamID{Ms: d.uvarint(), Seq: d.uvarint()}
+}
+
+func decodeEntry(d *decoder, section Section) any {
+	switch section {
+	case SectionStrings:
+		e := KVEntry{Key: d.str(), ExpireAt: d.varint(), Type: "string"}
+		e.Value = d.str()
+		return e
+	case SectionHashes:
+		e := HashEntry{Key: d.str(), ExpireAt: d.varint()}
+		n := d.count()
+		e.Fields = make(map[string]string, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			f := d.str()
+			e.Fields[f] = d.str()
+		}
+		return e
+	case SectionLists:
+		e := ListEntry{Key: d.str(), ExpireAt: d.varint()}
+		n := d.count()
+		e.Values = make([]string, 0, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			e.Values = append(e.Values, d.str())
+		}
+		return e
+	case SectionSets:
+		e := SetEntry{Key: d.str(), ExpireAt: d.varint()}
+		n := d.count()
+		e.Members = make([]string, 0, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			e.Members = append(e.Members, d.str())
+		}
+		return e
+	case SectionZSets:
+		e := ZSetEntry{Key: d.str(), ExpireAt: d.varint()}
+		n := d.count()
+		e.Members = make([]ZMember, 0, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			e.Members = append(e.Members, ZMember{Member: d.str(), Score: d.float()})
+		}
+		return e
+	case SectionStreams:
+		e := StreamEntry{Key: d.str(), ExpireAt: d.varint(), LastID: d.streamID()}
+		n := d.count()
+		e.Entries = make([]StreamItem, 0, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			item := StreamItem{ID: d.streamID()}
+			nf := d.count()
+			item.Fields = make([]string, 0, nf)
+			for j := 0; j < nf && d.err == nil; j++ {
+				item.Fields = append(item.Fields, d.str())
+			}
+			e.Entries = append(e.Entries, item)
+		}
+		n = d.count()
+		e.Groups = make([]StreamGroup, 0, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			g := StreamGroup{Name: d.str(), LastID: d.streamID()}
+			nc := d.count()
+			g.Consumers = make([]StreamConsumer, 0, nc)
+			for j := 0; j < nc && d.err == nil; j++ {
+				g.Consumers = append(g.Consumers, StreamConsumer{Name: d.str(), SeenAt: d.varint()})
+			}
+			np := d.count()
+			g.Pending = make([]StreamPending, 0, np)
+			for j := 0; j < np && d.err == nil; j++ {
+				g.Pending = append(g.Pending, StreamPending{ID: d.streamID(), Consumer: d.str(), DeliveredAt: d.varint(), Deliveries: d.varint()})
+			}
+			e.Groups = append(e.Groups, g)
+		}
+		return e
+	case SectionJSON:
+		return JSONEntry{Key: d.str(), ExpireAt: d.varint(), Value: d.str()}
+	case SectionTimeSeries:
+		e := SeriesEntry{Key: d.str(), Retention: time.Duration(d.varint())}
+		n := d.count()
+		e.Labels = make(map[string]string, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			k := d.str()
+			e.Labels[k] = d.str()
+		}
+		n = d.count()
+		e.Points = make([]Point, 0, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			e.Points = append(e.Points, Point{Timestamp: d.varint(), Value: d.float()})
+		}
+		return e
+	case SectionIndexes:
+		e := IndexEntry{Name: d.str()}
+		n := d.count()
+		e.Prefixes = make([]string, 0, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			e.Prefixes = append(e.Prefixes, d.str())
+		}
+		n = d.count()
+		e.Fields = make([]IndexField, 0, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			f := IndexField{Name: d.str(), Type: d.str(), Separator: d.str()}
+			f.Sortable = d.byte() != 0
+			e.Fields = append(e.Fields, f)
+		}
+		return e
+	case SectionDatabase:
+		return DatabaseEntry{DB: int(d.uvarint())}
+	}
+	return nil
+}
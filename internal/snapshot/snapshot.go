@@ -1,8 +1,8 @@
 package snapshot
 
 import (
-	"encoding/gob"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
@@ -20,16 +20,139 @@ type KVEntry struct {
 
 // HashEntry is a full hash map snapshot.
 type HashEntry struct {
-	Key    string
-	Fields map[string]string
+	Key      string
+	Fields   map[string]string
+	ExpireAt int64
+}
+
+// ListEntry is a full list snapshot, head first.
+type ListEntry struct {
+	Key      string
+	Values   []string
+	ExpireAt int64
+}
+
+// SetEntry is a full set snapshot.
+type SetEntry struct {
+	Key      string
+	Members  []string
+	ExpireAt int64
+}
+
+// ZMember is a sorted set member with its score.
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// ZSetEntry is a full sorted set snapshot.
+type ZSetEntry struct {
+	Key      string
+	Members  []ZMember
+	ExpireAt int64
+}
+
+// StreamID identifies a stream entry.
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+// StreamItem is one stream entry; Fields holds names and values alternately.
+type StreamItem struct {
+	ID     StreamID
+	Fields []string
+}
+
+// StreamConsumer is a consumer of a stream consumer group.
+type StreamConsumer struct {
+	Name   string
+	SeenAt int64
+}
+
+// StreamPending is an entry delivered to a consumer and not acknowledged.
+type StreamPending struct {
+	ID          StreamID
+	Consumer    string
+	DeliveredAt int64
+	Deliveries  int64
 }
 
-// Snapshot is the full serialisable state captured at a moment in time.
+// StreamGroup is a stream consumer group with its pending entries.
+type StreamGroup struct {
+	Name      string
+	LastID    StreamID
+	Consumers []StreamConsumer
+	Pending   []StreamPending
+}
+
+// StreamEntry is a full stream snapshot with its consumer groups.
+type StreamEntry struct {
+	Key      string
+	Entries  []StreamItem
+	LastID   StreamID
+	Groups   []StreamGroup
+	ExpireAt int64
+}
+
+// JSONEntry is a JSON document as compact JSON text.
+type JSONEntry struct {
+	Key      string
+	Value    string
+	ExpireAt int64
+}
+
+// Point is a single time-series sample.
+type Point struct {
+	Timestamp int64
+	Value     float64
+}
+
+// SeriesEntry is a full time series snapshot with its settings.
+type SeriesEntry struct {
+	Key       string
+	Retention time.Duration
+	Labels    map[string]string
+	Points    []Point
+}
+
+// IndexField is one field in a search index schema.
+type IndexField struct {
+	Name      string
+	Type      string // TEXT, TAG or NUMERIC
+	Separator string // TAG fields only
+	Sortable  bool
+}
+
+// IndexEntry is the definition of a search index. Indexes are rebuilt from
+// the keys when loaded, so only the definition is stored.
+type IndexEntry struct {
+	Name     string
+	Prefixes []string
+	Fields   []IndexField
+}
+
+// DatabaseEntry starts the entries of a logical database. Entries before
+// the first one belong to database 0.
+type DatabaseEntry struct {
+	DB int
+}
+
+// Snapshot is the full serialisable state of database 0 captured at a
+// moment in time; snapshots of several databases are read with Open.
+// ExpireAt fields hold Unix milliseconds; 0 means no expiry.
 type Snapshot struct {
-	ID        string
-	CreatedAt time.Time
-	Strings   []KVEntry
-	Hashes    []HashEntry
+	ID         string
+	CreatedAt  time.Time
+	Strings    []KVEntry
+	Hashes     []HashEntry
+	Lists      []ListEntry
+	Sets       []SetEntry
+	ZSets      []ZSetEntry
+	TimeSeries []SeriesEntry
+	Streams    []StreamEntry
+	JSON       []JSONEntry
+	Indexes    []IndexEntry
 }
 
 // Meta describes a snapshot without loading the full data.
@@ -38,8 +161,17 @@ type Meta struct {
 	CreatedAt time.Time `json:"created_at"`
 	SizeBytes int64     `json:"size_bytes"`
 	FilePath  string    `json:"file_path"`
+	Version   int       `json:"version"` // 0 for legacy gob snapshots
+	Status    string    `json:"status"`
+	Progress  float64   `json:"progress"` // fraction of entries written, 0 to 1
 }
 
+// Snapshot statuses reported in Meta.
+const (
+	StatusComplete   = "complete"
+	StatusInProgress = "in_progress"
+)
+
 // Manager handles snapshot CRUD backed by a directory on disk.
 type Manager struct {
 	dir string
@@ -53,34 +185,57 @@ func NewManager(dir string) (*Manager, error) {
 	return &Manager{dir: dir}, nil
 }
 
-// Create serialises snap to disk and returns its metadata.
+// Create writes snap to disk and returns its metadata.
 func (m *Manager) Create(snap *Snapshot) (Meta, error) {
-	if snap.ID == "" {
-		snap.ID = fmt.Sprintf("snap-%d", time.Now().UnixMilli())
-	}
-	snap.CreatedAt = time.Now()
-
-	filename := snap.ID + ".snap"
-	path := filepath.Join(m.dir, filename)
-
-	f, err := os.Create(path)
+	w, err := m.NewWriter(snap.ID)
 	if err != nil {
-		return Meta{}, fmt.Errorf("snapshot: create file: %w", err)
+		return Meta{}, err
 	}
-	defer f.Close()
+	snap.ID = w.meta.ID
+	snap.CreatedAt = w.meta.CreatedAt
 
-	enc := gob.NewEncoder(f)
-	if err := enc.Encode(snap); err != nil {
-		return Meta{}, fmt.Errorf("snapshot: encode: %w", err)
+	for _, entries := range snap.entries() {
+		for _, e := range entries {
+			if err := w.Write(e); err != nil {
+				w.Abort()
+				return Meta{}, err
+			}
+		}
 	}
+	return w.Close()
+}
 
-	info, _ := f.Stat()
-	return Meta{
-		ID:        snap.ID,
-		CreatedAt: snap.CreatedAt,
-		SizeBytes: info.Size(),
-		FilePath:  path,
-	}, nil
+// entries returns the snapshot contents grouped by section.
+func (snap *Snapshot) entries() [][]any {
+	groups := make([][]any, 9)
+	for _, e := range snap.Strings {
+		groups[0] = append(groups[0], e)
+	}
+	for _, e := range snap.Hashes {
+		groups[1] = append(groups[1], e)
+	}
+	for _, e := range snap.Lists {
+		groups[2] = append(groups[2], e)
+	}
+	for _, e := range snap.Sets {
+		groups[3] = append(groups[3], e)
+	}
+	for _, e := range snap.ZSets {
+		groups[4] = append(groups[4], e)
+	}
+	for _, e := range snap.TimeSeries {
+		groups[5] = append(groups[5], e)
+	}
+	for _, e := range snap.Streams {
+		groups[6] = append(groups[6], e)
+	}
+	for _, e := range snap.JSON {
+		groups[7] = append(groups[7], e)
+	}
+	for _, e := range snap.Indexes {
+		groups[8] = append(groups[8], e)
+	}
+	return groups
 }
 
 // List returns metadata for all snapshots, sorted newest first.
@@ -99,13 +254,20 @@ func (m *Manager) List() ([]Meta, error) {
 		if err != nil {
 			continue
 		}
-		id := strings.TrimSuffix(e.Name(), ".snap")
-		metas = append(metas, Meta{
-			ID:        id,
+		path := filepath.Join(m.dir, e.Name())
+		meta := Meta{
+			ID:        strings.TrimSuffix(e.Name(), ".snap"),
 			CreatedAt: info.ModTime(),
 			SizeBytes: info.Size(),
-			FilePath:  filepath.Join(m.dir, e.Name()),
-		})
+			FilePath:  path,
+			Status:    StatusComplete,
+			Progress:  1,
+		}
+		if hdr, ok := readFileHeader(path); ok {
+			meta.CreatedAt = hdr.CreatedAt
+			meta.Version = hdr.Version
+		}
+		metas = append(metas, meta)
 	}
 
 	sort.Slice(metas, func(i, j int) bool {
@@ -114,21 +276,60 @@ func (m *Manager) List() ([]Meta, error) {
 	return metas, nil
 }
 
-// Load reads and decodes a snapshot from disk by ID.
-func (m *Manager) Load(id string) (*Snapshot, error) {
-	path := filepath.Join(m.dir, id+".snap")
+// readFileHeader reads the header of a binary snapshot file.
+func readFileHeader(path string) (header, bool) {
 	f, err := os.Open(path)
 	if err != nil {
-		return nil, fmt.Errorf("snapshot: open %s: %w", id, err)
+		return header{}, false
 	}
 	defer f.Close()
+	hdr, err := readHeader(f)
+	return hdr, err == nil
+}
+
+// Load reads a whole snapshot into memory by ID. Prefer Open for large
+// snapshots.
+func (m *Manager) Load(id string) (*Snapshot, error) {
+	r, err := m.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
 
-	var snap Snapshot
-	dec := gob.NewDecoder(f)
-	if err := dec.Decode(&snap); err != nil {
-		return nil, fmt.Errorf("snapshot: decode %s: %w", id, err)
+	snap := &Snapshot{ID: id, CreatedAt: r.Meta().CreatedAt}
+	for {
+		entry, err := r.Next()
+		if err == io.EOF {
+			return snap, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch e := entry.(type) {
+		case KVEntry:
+			snap.Strings = append(snap.Strings, e)
+		case HashEntry:
+			snap.Hashes = append(snap.Hashes, e)
+		case ListEntry:
+			snap.Lists = append(snap.Lists, e)
+		case SetEntry:
+			snap.Sets = append(snap.Sets, e)
+		case ZSetEntry:
+			snap.ZSets = append(snap.ZSets, e)
+		case SeriesEntry:
+			snap.TimeSeries = append(snap.TimeSeries, e)
+		case StreamEntry:
+			snap.Streams = append(snap.Streams, e)
+		case JSONEntry:
+			snap.JSON = append(snap.JSON, e)
+		case IndexEntry:
+			snap.Indexes = append(snap.Indexes, e)
+		case DatabaseEntry:
+			if e.DB != 0 {
+				return nil, fmt.Errorf("snapshot: %s holds several databases; read it with Open", id)
+			}
+		}
 	}
-	return &snap, nil
 }
 
 // Delete removes a snapshot file by ID.
@@ -1,8 +1,15 @@
 package snapshot
 
 import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func tempDir(t *testing.T) string {
@@ -118,3 +125,307 @@ func TestLoad_NotFound(t *testing.T) {
 		t.Fatal("expected error for missing snapshot")
 	}
 }
+
+func TestCreateAndLoad_AllTypes(t *testing.T) {
+	mgr, err := NewManager(tempDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snap := &Snapshot{
+		ID:     "all",
+		Hashes: []HashEntry{{Key: "h", Fields: map[string]string{"f": "v"}, ExpireAt: 42}},
+		Lists:  []ListEntry{{Key: "l", Values: []string{"a", "b"}}},
+		Sets:   []SetEntry{{Key: "s", Members: []string{"x"}}},
+		ZSets:  []ZSetEntry{{Key: "z", Members: []ZMember{{Member: "m", Score: 1.5}}}},
+		TimeSeries: []SeriesEntry{{
+			Key:       "ts",
+			Retention: time.Hour,
+			Labels:    map[string]string{"host": "a"},
+			Points:    []Point{{Timestamp: 1000, Value: 2}},
+		}},
+		Streams: []StreamEntry{{
+			Key:     "st",
+			Entries: []StreamItem{{ID: StreamID{Ms: 5, Seq: 1}, Fields: []string{"f", "v"}}},
+			LastID:  StreamID{Ms: 9},
+			Groups:  []StreamGroup{{Name: "g", LastID: StreamID{Ms: 5, Seq: 1}}},
+		}},
+		JSON: []JSONEntry{{Key: "doc", Value: `{"a":[1]}`, ExpireAt: 7}},
+		Indexes: []IndexEntry{{
+			Name:     "idx",
+			Prefixes: []string{"doc:"},
+			Fields:   []IndexField{{Name: "tags", Type: "TAG", Separator: ";", Sortable: true}, {Name: "n", Type: "NUMERIC"}},
+		}},
+	}
+	if _, err := mgr.Create(snap); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := mgr.Load("all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Hashes[0].ExpireAt != 42 {
+		t.Fatalf("hash TTL lost: %+v", loaded.Hashes[0])
+	}
+	if len(loaded.Lists) != 1 || len(loaded.Lists[0].Values) != 2 {
+		t.Fatalf("unexpected lists: %+v", loaded.Lists)
+	}
+	if len(loaded.Sets) != 1 || len(loaded.ZSets) != 1 || loaded.ZSets[0].Members[0].Score != 1.5 {
+		t.Fatalf("unexpected sets: %+v %+v", loaded.Sets, loaded.ZSets)
+	}
+	ts := loaded.TimeSeries[0]
+	if ts.Retention != time.Hour || ts.Labels["host"] != "a" || len(ts.Points) != 1 {
+		t.Fatalf("unexpected series: %+v", ts)
+	}
+	if len(loaded.Streams) != 1 || loaded.Streams[0].LastID != (StreamID{Ms: 9}) || len(loaded.Streams[0].Groups) != 1 {
+		t.Fatalf("unexpected streams: %+v", loaded.Streams)
+	}
+	if len(loaded.JSON) != 1 || loaded.JSON[0] != snap.JSON[0] {
+		t.Fatalf("unexpected JSON: %+v", loaded.JSON)
+	}
+	if len(loaded.Indexes) != 1 || !reflect.DeepEqual(loaded.Indexes[0], snap.Indexes[0]) {
+		t.Fatalf("unexpected indexes: %+v", loaded.Indexes)
+	}
+}
+
+func writeTestSnapshot(t *testing.T, mgr *Manager, id string) Meta {
+	t.Helper()
+	snap := &Snapshot{ID: id}
+	for i := 0; i < 5000; i++ {
+		snap.Strings = append(snap.Strings, KVEntry{Key: fmt.Sprintf("key%d", i), Value: "some value", Type: "string"})
+	}
+	snap.Sets = []SetEntry{{Key: "s", Members: []string{"a", "b"}}}
+	meta, err := mgr.Create(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return meta
+}
+
+func TestWriterReader_Streaming(t *testing.T) {
+	mgr, err := NewManager(tempDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := writeTestSnapshot(t, mgr, "stream")
+	if meta.Version != FormatVersion {
+		t.Fatalf("expected version %d, got %d", FormatVersion, meta.Version)
+	}
+
+	r, err := mgr.Open("stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if !r.Meta().CreatedAt.Equal(meta.CreatedAt) {
+		t.Fatalf("created at mismatch: %v vs %v", r.Meta().CreatedAt, meta.CreatedAt)
+	}
+
+	strs, sets := 0, 0
+	for {
+		entry, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch entry.(type) {
+		case KVEntry:
+			strs++
+		case SetEntry:
+			sets++
+		}
+	}
+	if strs != 5000 || sets != 1 {
+		t.Fatalf("expected 5000 strings and 1 set, got %d and %d", strs, sets)
+	}
+	if r.chunks < 3 {
+		t.Fatalf("expected the strings to span several chunks, got %d chunks", r.chunks)
+	}
+}
+
+func TestWriterReader_Databases(t *testing.T) {
+	mgr, err := NewManager(tempDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := mgr.NewWriter("dbs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []any{
+		KVEntry{Key: "a", Value: "0", Type: "string"},
+		DatabaseEntry{DB: 3},
+		KVEntry{Key: "a", Value: "3", Type: "string"},
+		SetEntry{Key: "s", Members: []string{"x"}},
+		DatabaseEntry{DB: 15},
+		KVEntry{Key: "b", Value: "15", Type: "string"},
+	}
+	for _, e := range entries {
+		if err := w.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := mgr.Open("dbs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var got []any
+	for {
+		entry, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, entry)
+	}
+	if !reflect.DeepEqual(got, entries) {
+		t.Fatalf("entries mismatch:\n got %v\nwant %v", got, entries)
+	}
+
+	// Load only handles database 0.
+	if _, err := mgr.Load("dbs"); err == nil {
+		t.Fatal("expected Load to reject a snapshot of several databases")
+	}
+	if _, err := Dump(DatabaseEntry{DB: 1}); err == nil {
+		t.Fatal("expected Dump to reject a database entry")
+	}
+}
+
+func TestLoad_Truncated(t *testing.T) {
+	mgr, err := NewManager(tempDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := writeTestSnapshot(t, mgr, "trunc")
+
+	for _, size := range []int64{meta.SizeBytes - 1, meta.SizeBytes / 2, 10} {
+		if err := os.Truncate(meta.FilePath, size); err != nil {
+			t.Fatal(err)
+		}
+		_, err = mgr.Load("trunc")
+		if !errors.Is(err, ErrTruncated) {
+			t.Fatalf("size %d: expected ErrTruncated, got %v", size, err)
+		}
+	}
+}
+
+func TestLoad_Corrupt(t *testing.T) {
+	mgr, err := NewManager(tempDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := writeTestSnapshot(t, mgr, "bad")
+
+	data, err := os.ReadFile(meta.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xFF
+	if err := os.WriteFile(meta.FilePath, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = mgr.Load("bad")
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt, got %v", err)
+	}
+}
+
+func TestLoad_LegacyGob(t *testing.T) {
+	dir := tempDir(t)
+	mgr, err := NewManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(filepath.Join(dir, "old.snap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := &Snapshot{ID: "old", Strings: []KVEntry{{Key: "k", Value: "v", Type: "string"}}}
+	if err := gob.NewEncoder(f).Encode(legacy); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	loaded, err := mgr.Load("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Strings) != 1 || loaded.Strings[0].Value != "v" {
+		t.Fatalf("unexpected legacy contents: %+v", loaded.Strings)
+	}
+
+	metas, err := mgr.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != 1 || metas[0].Version != 0 {
+		t.Fatalf("expected one legacy snapshot, got %+v", metas)
+	}
+}
+
+func TestDumpUndump(t *testing.T) {
+	entries := []any{
+		KVEntry{Key: "k", Value: "hello", ExpireAt: 42, Type: "string"},
+		HashEntry{Key: "k", Fields: map[string]string{"f": "v"}},
+		ListEntry{Key: "k", Values: []string{"a", "b"}, ExpireAt: 7},
+		SetEntry{Key: "k", Members: []string{"x"}},
+		ZSetEntry{Key: "k", Members: []ZMember{{Member: "m", Score: -1.5}}},
+		StreamEntry{
+			Key:     "k",
+			Entries: []StreamItem{{ID: StreamID{Ms: 1, Seq: 2}, Fields: []string{"f", "v"}}},
+			LastID:  StreamID{Ms: 3},
+			Groups: []StreamGroup{{
+				Name:      "g",
+				LastID:    StreamID{Ms: 1, Seq: 2},
+				Consumers: []StreamConsumer{{Name: "c", SeenAt: 10}},
+				Pending:   []StreamPending{{ID: StreamID{Ms: 1, Seq: 2}, Consumer: "c", DeliveredAt: 10, Deliveries: 1}},
+			}},
+			ExpireAt: 99,
+		},
+		JSONEntry{Key: "k", Value: `{"a":"b"}`, ExpireAt: 5},
+	}
+	for _, entry := range entries {
+		payload, err := Dump(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Undump("k", payload)
+		if err != nil {
+			t.Fatalf("%T: %v", entry, err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(entry) {
+			t.Fatalf("round trip: got %+v, want %+v", got, entry)
+		}
+
+		// Any flipped bit and any truncation is rejected.
+		for i := range payload {
+			bad := append([]byte(nil), payload...)
+			bad[i] ^= 0x10
+			if _, err := Undump("k", bad); !errors.Is(err, ErrBadPayload) {
+				t.Fatalf("%T: flipped byte %d accepted", entry, i)
+			}
+			if _, err := Undump("k", payload[:i]); !errors.Is(err, ErrBadPayload) {
+				t.Fatalf("%T: truncation at %d accepted", entry, i)
+			}
+		}
+	}
+
+	if _, err := Dump(SeriesEntry{Key: "ts"}); err == nil {
+		t.Fatal("time series dumped")
+	}
+	if _, err := Dump(IndexEntry{Name: "idx"}); err == nil {
+		t.Fatal("index dumped")
+	}
+}
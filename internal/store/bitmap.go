@@ -0,0 +1,282 @@
+// Package store - Bitmap operations for FlashDB
+//
+// Bitmaps are plain string values addressed bit by bit, equivalent to the
+// Redis bit commands. Bit 0 is the most significant bit of the first byte.
+// Reading past the end of a string yields zero bits, and writing past it
+// pads the string with zero bytes.
+package store
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+	"strconv"
+)
+
+// MaxStringSize is the largest string the bit and range commands will
+// grow a value to.
+const MaxStringSize = 512 << 20
+
+// ErrStringTooLong is returned when a write would grow a string past
+// MaxStringSize.
+var ErrStringTooLong = errors.New("string exceeds maximum allowed size (proto-max-bulk-len)")
+
+// GetBit returns the bit at offset in b.
+func GetBit(b []byte, offset uint64) int {
+	i := offset / 8
+	if i >= uint64(len(b)) {
+		return 0
+	}
+	return int(b[i]>>(7-offset%8)) & 1
+}
+
+// SetBit sets the bit at offset in b to bit, growing b as needed. It
+// returns the updated slice and the bit's previous value.
+func SetBit(b []byte, offset uint64, bit int) ([]byte, int) {
+	i := offset / 8
+	if need := i + 1; uint64(len(b)) < need {
+		b = append(b, make([]byte, need-uint64(len(b)))...)
+	}
+	mask := byte(1) << (7 - offset%8)
+	old := 0
+	if b[i]&mask != 0 {
+		old = 1
+	}
+	if bit != 0 {
+		b[i] |= mask
+	} else {
+		b[i] &^= mask
+	}
+	return b, old
+}
+
+// bitRange resolves the inclusive start and end indices of BITCOUNT and
+// BITPOS to bit offsets in b. Negative indices count from the end; they
+// are byte indices unless bitUnit. It returns false for an empty range.
+func bitRange(b []byte, start, end int64, bitUnit bool) (first, last uint64, ok bool) {
+	total := int64(len(b))
+	if bitUnit {
+		total *= 8
+	}
+	if start < 0 {
+		start += total
+	}
+	if end < 0 {
+		end += total
+	}
+	start, end = max(start, 0), max(end, 0)
+	end = min(end, total-1)
+	if start > end {
+		return 0, 0, false
+	}
+	if bitUnit {
+		return uint64(start), uint64(end), true
+	}
+	return uint64(start) * 8, uint64(end)*8 + 7, true
+}
+
+// BitCount counts the set bits of b between start and end inclusive; see
+// bitRange for how the indices are read.
+func BitCount(b []byte, start, end int64, bitUnit bool) int64 {
+	first, last, ok := bitRange(b, start, end, bitUnit)
+	if !ok {
+		return 0
+	}
+	firstByte, lastByte := first/8, last/8
+	headMask := byte(0xFF) >> (first % 8)
+	tailMask := byte(0xFF) << (7 - last%8)
+	if firstByte == lastByte {
+		return int64(bits.OnesCount8(b[firstByte] & headMask & tailMask))
+	}
+	n := bits.OnesCount8(b[firstByte]&headMask) + bits.OnesCount8(b[lastByte]&tailMask)
+	for _, c := range b[firstByte+1 : lastByte] {
+		n += bits.OnesCount8(c)
+	}
+	return int64(n)
+}
+
+// BitPos returns the offset of the first bit set to bit in b between start
+// and end inclusive, or -1 if there is none; see bitRange for how the
+// indices are read. Without an explicit end, b counts as padded with zero
+// bits, so looking for a 0 bit finds the first bit past the end.
+func BitPos(b []byte, bit int, start, end int64, hasEnd, bitUnit bool) int64 {
+	if !hasEnd {
+		end = -1
+	}
+	first, last, ok := bitRange(b, start, end, bitUnit)
+	if !ok {
+		return -1
+	}
+	skip := byte(0)
+	if bit == 0 {
+		skip = 0xFF
+	}
+	for i := first; i <= last; {
+		if i%8 == 0 && i+7 <= last && b[i/8] == skip {
+			i += 8
+			continue
+		}
+		if GetBit(b, i) == bit {
+			return int64(i)
+		}
+		i++
+	}
+	if bit == 0 && !hasEnd {
+		return int64(last) + 1
+	}
+	return -1
+}
+
+// BitOpKind is a BITOP operation.
+type BitOpKind uint8
+
+// BITOP operations.
+const (
+	BitAnd BitOpKind = iota
+	BitOr
+	BitXor
+	BitNot
+)
+
+// BitOp combines srcs byte by byte. Shorter sources count as padded with
+// zero bytes, so the result is as long as the longest source. BitNot takes
+// a single source.
+func BitOp(op BitOpKind, srcs [][]byte) []byte {
+	n := 0
+	for _, src := range srcs {
+		n = max(n, len(src))
+	}
+	out := make([]byte, n)
+	if op == BitNot {
+		for i, c := range srcs[0] {
+			out[i] = ^c
+		}
+		return out
+	}
+	copy(out, srcs[0])
+	for _, src := range srcs[1:] {
+		for i := range out {
+			var c byte
+			if i < len(src) {
+				c = src[i]
+			}
+			switch op {
+			case BitAnd:
+				out[i] &= c
+			case BitOr:
+				out[i] |= c
+			case BitXor:
+				out[i] ^= c
+			}
+		}
+	}
+	return out
+}
+
+// BitFieldType is the integer type of a BITFIELD field: signed with 1 to 64
+// bits, or unsigned with 1 to 63 bits.
+type BitFieldType struct {
+	Signed bool
+	Bits   uint
+}
+
+// ParseBitFieldType parses a type such as "i16" or "u8".
+func ParseBitFieldType(s string) (BitFieldType, bool) {
+	if len(s) < 2 || (s[0] != 'i' && s[0] != 'u' && s[0] != 'I' && s[0] != 'U') {
+		return BitFieldType{}, false
+	}
+	n, err := strconv.ParseUint(s[1:], 10, 8)
+	t := BitFieldType{Signed: s[0] == 'i' || s[0] == 'I', Bits: uint(n)}
+	if err != nil || t.Bits == 0 || t.Bits > 64 || (!t.Signed && t.Bits == 64) {
+		return BitFieldType{}, false
+	}
+	return t, true
+}
+
+// BitFieldOverflow selects how BITFIELD SET and INCRBY handle values that
+// do not fit the field.
+type BitFieldOverflow uint8
+
+// BITFIELD overflow behaviours.
+const (
+	OverflowWrap BitFieldOverflow = iota // wrap around, like C integers
+	OverflowSat                          // saturate at the minimum or maximum
+	OverflowFail                         // leave the field unchanged
+)
+
+// GetBitField reads the field of type t at bit offset in b.
+func GetBitField(b []byte, offset uint64, t BitFieldType) int64 {
+	var v uint64
+	for i := uint64(0); i < uint64(t.Bits); i++ {
+		v = v<<1 | uint64(GetBit(b, offset+i))
+	}
+	if t.Signed && t.Bits < 64 && v&(1<<(t.Bits-1)) != 0 {
+		v |= math.MaxUint64 << t.Bits
+	}
+	return int64(v)
+}
+
+// SetBitField writes the low bits of v as the field of type t at bit
+// offset in b, growing b as needed, and returns the updated slice.
+func SetBitField(b []byte, offset uint64, t BitFieldType, v int64) []byte {
+	for i := uint64(0); i < uint64(t.Bits); i++ {
+		bit := int(uint64(v)>>(uint64(t.Bits)-1-i)) & 1
+		b, _ = SetBit(b, offset+i, bit)
+	}
+	return b
+}
+
+// BitFieldAdd adds incr to value, a field of type t, handling overflow as
+// ow says. It returns false when the sum overflows under OverflowFail.
+// BITFIELD SET checks its value with an increment of 0.
+func BitFieldAdd(value, incr int64, t BitFieldType, ow BitFieldOverflow) (int64, bool) {
+	wrap := func() int64 {
+		sum := uint64(value) + uint64(incr)
+		if t.Bits == 64 {
+			return int64(sum)
+		}
+		high := uint64(math.MaxUint64) << t.Bits
+		if t.Signed && sum&(1<<(t.Bits-1)) != 0 {
+			return int64(sum | high)
+		}
+		return int64(sum &^ high)
+	}
+
+	var lo, hi int64
+	overflow := 0
+	if t.Signed {
+		hi = math.MaxInt64
+		if t.Bits < 64 {
+			hi = 1<<(t.Bits-1) - 1
+		}
+		lo = -hi - 1
+		// The differences wrap like the sum would, and the sign checks
+		// make up for it when t.Bits is 64.
+		maxIncr, minIncr := hi-value, lo-value
+		switch {
+		case value > hi || (t.Bits != 64 && incr > maxIncr) || (value >= 0 && incr > 0 && incr > maxIncr):
+			overflow = 1
+		case value < lo || (t.Bits != 64 && incr < minIncr) || (value < 0 && incr < 0 && incr < minIncr):
+			overflow = -1
+		}
+	} else {
+		hi = 1<<t.Bits - 1
+		switch {
+		case uint64(value) > uint64(hi) || (incr > 0 && incr > hi-value):
+			overflow = 1
+		case incr < 0 && incr < -value:
+			overflow = -1
+		}
+	}
+
+	switch {
+	case overflow == 0 || ow == OverflowWrap:
+		return wrap(), true
+	case ow == OverflowFail:
+		return 0, false
+	case overflow > 0:
+		return hi, true
+	default:
+		return lo, true
+	}
+}
@@ -0,0 +1,131 @@
+package store
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBitmap_SetGetBit(t *testing.T) {
+	b, old := SetBit(nil, 7, 1)
+	assert.Equal(t, 0, old)
+	assert.Equal(t, []byte{0x01}, b)
+	b, _ = SetBit(b, 16, 1)
+	assert.Equal(t, []byte{0x01, 0x00, 0x80}, b)
+	b, old = SetBit(b, 7, 0)
+	assert.Equal(t, 1, old)
+	assert.Equal(t, []byte{0x00, 0x00, 0x80}, b)
+
+	assert.Equal(t, 1, GetBit(b, 16))
+	assert.Equal(t, 0, GetBit(b, 17))
+	assert.Equal(t, 0, GetBit(b, 1000))
+}
+
+func TestBitmap_CountPos(t *testing.T) {
+	b := []byte("foobar")
+	assert.Equal(t, int64(26), BitCount(b, 0, -1, false))
+	assert.Equal(t, int64(4), BitCount(b, 0, 0, false))
+	assert.Equal(t, int64(6), BitCount(b, 1, 1, false))
+	assert.Equal(t, int64(18), BitCount(b, 1, -2, false))
+	assert.Equal(t, int64(17), BitCount(b, 5, 30, true))
+	assert.Equal(t, int64(0), BitCount(b, 4, 2, false))
+	assert.Equal(t, int64(0), BitCount(nil, 0, -1, false))
+
+	b = []byte{0xFF, 0xF0, 0x00}
+	assert.Equal(t, int64(12), BitPos(b, 0, 0, -1, false, false))
+	assert.Equal(t, int64(8), BitPos(b, 1, 1, -1, false, false))
+	assert.Equal(t, int64(-1), BitPos(b, 1, 2, -1, false, false))
+	assert.Equal(t, int64(7), BitPos(b, 1, 7, 15, true, true))
+
+	// Without an end the string is padded with zero bits.
+	b = []byte{0xFF, 0xFF}
+	assert.Equal(t, int64(16), BitPos(b, 0, 0, -1, false, false))
+	assert.Equal(t, int64(-1), BitPos(b, 0, 0, -1, true, false))
+	assert.Equal(t, int64(-1), BitPos(nil, 0, 0, -1, false, false))
+}
+
+func TestBitmap_BitOp(t *testing.T) {
+	a, b := []byte{0xF0, 0xFF}, []byte{0x3C}
+	assert.Equal(t, []byte{0x30, 0x00}, BitOp(BitAnd, [][]byte{a, b}))
+	assert.Equal(t, []byte{0xFC, 0xFF}, BitOp(BitOr, [][]byte{a, b}))
+	assert.Equal(t, []byte{0xCC, 0xFF}, BitOp(BitXor, [][]byte{a, b}))
+	assert.Equal(t, []byte{0x0F, 0x00}, BitOp(BitNot, [][]byte{a}))
+	assert.Empty(t, BitOp(BitOr, [][]byte{nil, nil}))
+}
+
+func TestBitmap_Fields(t *testing.T) {
+	for _, bad := range []string{"", "i", "x8", "i0", "i65", "u64", "u-1"} {
+		_, ok := ParseBitFieldType(bad)
+		assert.False(t, ok, bad)
+	}
+	i8, ok := ParseBitFieldType("i8")
+	require.True(t, ok)
+	u4, _ := ParseBitFieldType("u4")
+	i64, _ := ParseBitFieldType("i64")
+	u63, _ := ParseBitFieldType("u63")
+
+	// Fields may straddle bytes.
+	b := SetBitField(nil, 4, i8, -2)
+	assert.Equal(t, []byte{0x0F, 0xE0}, b)
+	assert.Equal(t, int64(-2), GetBitField(b, 4, i8))
+	assert.Equal(t, int64(15), GetBitField(b, 4, u4))
+	b = SetBitField(b, 64, i64, math.MinInt64)
+	assert.Equal(t, int64(math.MinInt64), GetBitField(b, 64, i64))
+
+	cases := []struct {
+		value, incr int64
+		typ         BitFieldType
+		ow          BitFieldOverflow
+		want        int64
+		ok          bool
+	}{
+		{100, 50, i8, OverflowWrap, -106, true},
+		{100, 50, i8, OverflowSat, 127, true},
+		{100, 50, i8, OverflowFail, 0, false},
+		{-100, -50, i8, OverflowSat, -128, true},
+		{-100, -50, i8, OverflowWrap, 106, true},
+		{300, 0, i8, OverflowWrap, 44, true},
+		{14, 3, u4, OverflowWrap, 1, true},
+		{14, 3, u4, OverflowSat, 15, true},
+		{2, -3, u4, OverflowSat, 0, true},
+		{2, -3, u4, OverflowWrap, 15, true},
+		{-1, 0, u4, OverflowFail, 0, false},
+		{math.MaxInt64, 1, i64, OverflowWrap, math.MinInt64, true},
+		{math.MaxInt64, 1, i64, OverflowSat, math.MaxInt64, true},
+		{math.MinInt64, -1, i64, OverflowFail, 0, false},
+		{-1, math.MaxInt64, i64, OverflowFail, math.MaxInt64 - 1, true},
+		{math.MaxInt64, 1, u63, OverflowWrap, 0, true},
+	}
+	for _, c := range cases {
+		got, ok := BitFieldAdd(c.value, c.incr, c.typ, c.ow)
+		assert.Equal(t, c.ok, ok, "%+v", c)
+		if ok {
+			assert.Equal(t, c.want, got, "%+v", c)
+		}
+	}
+}
+
+func TestStore_SetRange(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	n, err := s.SetRange("k", 3, []byte("ab"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	v, _, _ := s.Get("k")
+	assert.Equal(t, []byte("\x00\x00\x00ab"), v)
+
+	require.True(t, s.Expire("k", time.Hour))
+	n, _ = s.SetRange("k", 0, []byte("x"))
+	assert.Equal(t, 5, n)
+	v, _, _ = s.Get("k")
+	assert.Equal(t, []byte("x\x00\x00ab"), v)
+	assert.Greater(t, s.TTL("k"), time.Duration(0), "the TTL is kept")
+
+	s.HSet("h", HashFieldValue{Field: "f", Value: []byte("v")})
+	_, err = s.SetRange("h", 0, []byte("x"))
+	assert.ErrorIs(t, err, ErrWrongType)
+}
@@ -0,0 +1,183 @@
+// Package store - eviction support for FlashDB
+//
+// Objects remember when they were last accessed (for LRU) and how often
+// (for LFU), like Redis: the LFU counter is a logarithmic 8-bit counter
+// that decays by one for every minute the key goes unused, kept together
+// with the minute of its last decay. The engine picks keys to evict from
+// random samples scored by policy, an approximation of true LRU or LFU
+// that needs no global ordering of the keys.
+package store
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// EvictionPolicy selects which keys are evicted when memory is full.
+type EvictionPolicy uint8
+
+// Eviction policies, as in Redis' maxmemory-policy.
+const (
+	NoEviction EvictionPolicy = iota
+	AllKeysLRU
+	AllKeysLFU
+	AllKeysRandom
+	VolatileLRU
+	VolatileLFU
+	VolatileRandom
+	VolatileTTL
+)
+
+var evictionPolicyNames = [...]string{
+	NoEviction:     "noeviction",
+	AllKeysLRU:     "allkeys-lru",
+	AllKeysLFU:     "allkeys-lfu",
+	AllKeysRandom:  "allkeys-random",
+	VolatileLRU:    "volatile-lru",
+	VolatileLFU:    "volatile-lfu",
+	VolatileRandom: "volatile-random",
+	VolatileTTL:    "volatile-ttl",
+}
+
+// String returns the Redis name of p.
+func (p EvictionPolicy) String() string {
+	if int(p) < len(evictionPolicyNames) {
+		return evictionPolicyNames[p]
+	}
+	return "unknown"
+}
+
+// ParseEvictionPolicy parses a Redis maxmemory-policy name.
+func ParseEvictionPolicy(name string) (EvictionPolicy, bool) {
+	for p, n := range evictionPolicyNames {
+		if n == name {
+			return EvictionPolicy(p), true
+		}
+	}
+	return NoEviction, false
+}
+
+// Volatile reports whether p only evicts keys with a TTL.
+func (p EvictionPolicy) Volatile() bool {
+	return p >= VolatileLRU
+}
+
+// LFU counter parameters, Redis' defaults for lfu-log-factor and
+// lfu-decay-time.
+const (
+	lfuInitVal   = 5
+	lfuLogFactor = 10
+	lfuDecayTime = 1 // minutes per decrement
+)
+
+// resetAccess marks a new object as just accessed, with the initial LFU
+// counter so it is not evicted before it had a chance to be used.
+func (o *object) resetAccess() {
+	now := time.Now()
+	o.lru.Store(now.UnixMilli())
+	o.lfu.Store(lfuMinutes(now)<<8 | lfuInitVal)
+}
+
+// access records a read or write of the object. Concurrent readers may
+// lose each other's counter increments, which the approximation tolerates.
+func (o *object) access() {
+	now := time.Now()
+	o.lru.Store(now.UnixMilli())
+	counter := lfuLogIncr(o.lfuCounter(now))
+	o.lfu.Store(lfuMinutes(now)<<8 | uint32(counter))
+}
+
+// lfuCounter returns the LFU counter, decayed for the minutes since it was
+// last updated.
+func (o *object) lfuCounter(now time.Time) uint8 {
+	v := o.lfu.Load()
+	counter := uint8(v)
+	elapsed := (lfuMinutes(now) - v>>8) & 0xFFFFFF
+	if periods := elapsed / lfuDecayTime; periods < uint32(counter) {
+		return counter - uint8(periods)
+	}
+	return 0
+}
+
+// idle returns how long ago the object was last accessed.
+func (o *object) idle(now time.Time) time.Duration {
+	return time.Duration(now.UnixMilli()-o.lru.Load()) * time.Millisecond
+}
+
+// lfuMinutes returns the time in minutes, modulo 2^24.
+func lfuMinutes(now time.Time) uint32 {
+	return uint32(now.Unix()/60) & 0xFFFFFF
+}
+
+// lfuLogIncr increments an LFU counter with a probability that falls as
+// the counter grows, so 255 stands for about a million accesses.
+func lfuLogIncr(counter uint8) uint8 {
+	if counter == math.MaxUint8 {
+		return counter
+	}
+	base := float64(counter) - lfuInitVal
+	if base < 0 {
+		base = 0
+	}
+	if rand.Float64() < 1/(base*lfuLogFactor+1) {
+		counter++
+	}
+	return counter
+}
+
+// AccessInfo returns how long ago key was last accessed and its LFU
+// counter, or false if the key does not exist. It does not count as an
+// access.
+func (s *Store) AccessInfo(key string) (time.Duration, int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, ok := s.data[key]
+	if !ok || s.isExpired(obj) {
+		return 0, 0, false
+	}
+	now := time.Now()
+	return obj.idle(now), int(obj.lfuCounter(now)), true
+}
+
+// EvictionCandidate is a key offered for eviction. Keys with higher scores
+// are better to evict.
+type EvictionCandidate struct {
+	Key   string
+	Score uint64
+}
+
+// SampleEviction returns up to n keys picked at random and scored for
+// policy: by idle time, by inverse access frequency or by nearness of
+// expiry. Volatile policies only sample keys with a TTL, looking at up to
+// 20 times n keys to find them. Sampling does not count as an access.
+func (s *Store) SampleEviction(policy EvictionPolicy, n int) []EvictionCandidate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	out := make([]EvictionCandidate, 0, n)
+	scanned := 0
+	// Use map iteration which is pseudo-random in Go
+	for key, obj := range s.data {
+		if len(out) >= n || scanned >= 20*n {
+			break
+		}
+		scanned++
+		if s.isExpired(obj) || (policy.Volatile() && !obj.hasExpire) {
+			continue
+		}
+		c := EvictionCandidate{Key: key}
+		switch policy {
+		case AllKeysLRU, VolatileLRU:
+			c.Score = uint64(max(obj.idle(now), 0))
+		case AllKeysLFU, VolatileLFU:
+			c.Score = math.MaxUint8 - uint64(obj.lfuCounter(now))
+		case VolatileTTL:
+			c.Score = uint64(math.MaxInt64 - obj.expireAt.UnixMilli())
+		}
+		out = append(out, c)
+	}
+	return out
+}
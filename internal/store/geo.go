@@ -0,0 +1,257 @@
+// Package store - Geospatial indexing for FlashDB
+//
+// Geo commands keep positions in ordinary sorted sets, like Redis: each
+// member's score is a 52-bit geohash of its longitude and latitude, with
+// the latitude bits in the even positions and the longitude bits in the
+// odd ones. Nearby points share score prefixes, so a search covers the
+// geohash cell around its center and the eight neighbouring cells with
+// score ranges of the sorted set and then filters the members by exact
+// distance.
+package store
+
+import (
+	"errors"
+	"math"
+)
+
+// ErrGeoNoMember is returned when a search starts from a member that is
+// not in the sorted set.
+var ErrGeoNoMember = errors.New("could not decode requested zset member")
+
+// Geohash limits, as in Redis. Latitudes are limited to the range EPSG:3857
+// (web Mercator) can project.
+const (
+	GeoLonMin = -180.0
+	GeoLonMax = 180.0
+	GeoLatMin = -85.05112878
+	GeoLatMax = 85.05112878
+
+	geoStepMax      = 26 // bits per coordinate
+	geoEarthRadius  = 6372797.560856
+	geoMercatorMax  = 20037726.37
+	geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+// GeoPoint is a position in degrees.
+type GeoPoint struct {
+	Lon float64
+	Lat float64
+}
+
+// Valid reports whether p lies within the range geohashes can encode.
+func (p GeoPoint) Valid() bool {
+	return p.Lon >= GeoLonMin && p.Lon <= GeoLonMax && p.Lat >= GeoLatMin && p.Lat <= GeoLatMax
+}
+
+// interleave spreads the bits of lat over the even positions of the result
+// and those of lon over the odd ones.
+func interleave(lat, lon uint32) uint64 {
+	var bits uint64
+	for i := 0; i < 32; i++ {
+		bits |= uint64(lat>>i&1)<<(2*i) | uint64(lon>>i&1)<<(2*i+1)
+	}
+	return bits
+}
+
+// deinterleave undoes interleave.
+func deinterleave(bits uint64) (lat, lon uint32) {
+	for i := 0; i < 32; i++ {
+		lat |= uint32(bits>>(2*i)&1) << i
+		lon |= uint32(bits>>(2*i+1)&1) << i
+	}
+	return lat, lon
+}
+
+// geoCell returns the indices of the cell containing p in a grid of
+// 2^step cells per coordinate over the given latitude range.
+func geoCell(p GeoPoint, step uint, latMin, latMax float64) (lat, lon uint32) {
+	cells := float64(uint64(1) << step)
+	last := uint32(1<<step - 1)
+	lat = min(uint32((p.Lat-latMin)/(latMax-latMin)*cells), last)
+	lon = min(uint32((p.Lon-GeoLonMin)/(GeoLonMax-GeoLonMin)*cells), last)
+	return lat, lon
+}
+
+// GeoEncode returns the sorted set score of p, which must be valid.
+func GeoEncode(p GeoPoint) float64 {
+	lat, lon := geoCell(p, geoStepMax, GeoLatMin, GeoLatMax)
+	return float64(interleave(lat, lon))
+}
+
+// GeoDecode returns the center of the geohash cell a score encodes.
+func GeoDecode(score float64) GeoPoint {
+	lat, lon := deinterleave(uint64(score))
+	cells := float64(uint64(1) << geoStepMax)
+	p := GeoPoint{
+		Lon: GeoLonMin + (float64(lon)+0.5)/cells*(GeoLonMax-GeoLonMin),
+		Lat: GeoLatMin + (float64(lat)+0.5)/cells*(GeoLatMax-GeoLatMin),
+	}
+	p.Lon = min(max(p.Lon, GeoLonMin), GeoLonMax)
+	p.Lat = min(max(p.Lat, GeoLatMin), GeoLatMax)
+	return p
+}
+
+// GeoHashString returns the standard 11-character geohash of p. Unlike the
+// scores it uses the full -90 to 90 latitude range; the last character is
+// always '0' since scores only carry 52 bits.
+func GeoHashString(p GeoPoint) string {
+	lat, lon := geoCell(p, geoStepMax, -90, 90)
+	bits := interleave(lat, lon)
+	buf := make([]byte, 11)
+	for i := range buf {
+		idx := 0
+		if i < 10 {
+			idx = int(bits>>(52-(i+1)*5)) & 0x1F
+		}
+		buf[i] = geohashAlphabet[idx]
+	}
+	return string(buf)
+}
+
+func degToRad(d float64) float64 { return d * math.Pi / 180 }
+
+// GeoDistance returns the distance between a and b in meters, by the
+// haversine formula.
+func GeoDistance(a, b GeoPoint) float64 {
+	lat1, lat2 := degToRad(a.Lat), degToRad(b.Lat)
+	u := math.Sin((lat2 - lat1) / 2)
+	v := math.Sin(degToRad(b.Lon-a.Lon) / 2)
+	return 2 * geoEarthRadius * math.Asin(math.Sqrt(u*u+math.Cos(lat1)*math.Cos(lat2)*v*v))
+}
+
+// GeoShape is the area of a search: a circle of Radius meters around
+// Center, or with Box a Width by Height meters rectangle centered on it.
+type GeoShape struct {
+	Center GeoPoint
+	Radius float64
+	Box    bool
+	Width  float64
+	Height float64
+}
+
+// contains reports whether p lies in the shape and returns its distance
+// from the center.
+func (s GeoShape) contains(p GeoPoint) (float64, bool) {
+	if s.Box {
+		// The latitude distance is the cheaper one, so check it first.
+		if geoEarthRadius*math.Abs(degToRad(p.Lat-s.Center.Lat)) > s.Height/2 {
+			return 0, false
+		}
+		if GeoDistance(p, GeoPoint{Lon: s.Center.Lon, Lat: p.Lat}) > s.Width/2 {
+			return 0, false
+		}
+		return GeoDistance(s.Center, p), true
+	}
+	d := GeoDistance(s.Center, p)
+	return d, d <= s.Radius
+}
+
+// geoSteps returns the geohash precision whose cells are about as large as
+// a search of the given radius, coarser near the poles where cells shrink.
+func geoSteps(radius, lat float64) uint {
+	if radius == 0 {
+		return geoStepMax
+	}
+	step := 1
+	for radius < geoMercatorMax {
+		radius *= 2
+		step++
+	}
+	step -= 2
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+	return uint(min(max(step, 1), geoStepMax))
+}
+
+// geoRanges returns the inclusive score ranges of the cell around the
+// shape's center and its neighbours, which together cover the shape.
+func geoRanges(s GeoShape) [][2]float64 {
+	radius := s.Radius
+	if s.Box {
+		radius = math.Hypot(s.Width/2, s.Height/2)
+	}
+	step := geoSteps(radius, s.Center.Lat)
+	lat, lon := geoCell(s.Center, step, GeoLatMin, GeoLatMax)
+
+	// The estimate may leave the neighbours short of the radius; if so,
+	// use cells twice as large.
+	edge := func(i int64, lo, hi float64) float64 {
+		return lo + float64(i)/float64(uint64(1)<<step)*(hi-lo)
+	}
+	if step > 1 {
+		north := GeoPoint{Lon: s.Center.Lon, Lat: edge(int64(lat)+2, GeoLatMin, GeoLatMax)}
+		south := GeoPoint{Lon: s.Center.Lon, Lat: edge(int64(lat)-1, GeoLatMin, GeoLatMax)}
+		east := GeoPoint{Lon: edge(int64(lon)+2, GeoLonMin, GeoLonMax), Lat: s.Center.Lat}
+		west := GeoPoint{Lon: edge(int64(lon)-1, GeoLonMin, GeoLonMax), Lat: s.Center.Lat}
+		if GeoDistance(s.Center, north) < radius || GeoDistance(s.Center, south) < radius ||
+			GeoDistance(s.Center, east) < radius || GeoDistance(s.Center, west) < radius {
+			step--
+			lat, lon = lat/2, lon/2
+		}
+	}
+
+	cells := int64(1) << step
+	shift := 2 * (geoStepMax - step)
+	var ranges [][2]float64
+	seen := make(map[uint64]bool, 9)
+	for _, dLat := range []int64{0, 1, -1} {
+		for _, dLon := range []int64{0, 1, -1} {
+			cellLat := int64(lat) + dLat
+			if cellLat < 0 || cellLat >= cells {
+				continue
+			}
+			cellLon := (int64(lon) + dLon + cells) % cells
+			bits := interleave(uint32(cellLat), uint32(cellLon))
+			if seen[bits] {
+				continue
+			}
+			seen[bits] = true
+			ranges = append(ranges, [2]float64{float64(bits << shift), float64((bits+1)<<shift - 1)})
+		}
+	}
+	return ranges
+}
+
+// GeoMatch is a member found by a geo search, with its distance from the
+// center in meters.
+type GeoMatch struct {
+	Member string
+	Score  float64
+	Point  GeoPoint
+	Dist   float64
+}
+
+// GeoSearch returns the members of z within the shape, in no particular
+// order. With limit > 0 it stops after that many.
+func (z *SortedSet) GeoSearch(s GeoShape, limit int) []GeoMatch {
+	var matches []GeoMatch
+	for _, r := range geoRanges(s) {
+		for _, m := range z.RangeByScore(r[0], r[1], true, 0, 0) {
+			p := GeoDecode(m.Score)
+			if d, ok := s.contains(p); ok {
+				matches = append(matches, GeoMatch{Member: m.Member, Score: m.Score, Point: p, Dist: d})
+				if limit > 0 && len(matches) == limit {
+					return matches
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// GeoSearch returns the members of the sorted set at key within the shape;
+// see SortedSet.GeoSearch.
+func (s *Store) GeoSearch(key string, shape GeoShape, limit int) ([]GeoMatch, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	zset, err := s.zsetAt(key)
+	if zset == nil {
+		return nil, err
+	}
+	return zset.GeoSearch(shape, limit), nil
+}
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	palermo = GeoPoint{Lon: 13.361389, Lat: 38.115556}
+	catania = GeoPoint{Lon: 15.087269, Lat: 37.502669}
+)
+
+func TestGeo_EncodeMatchesRedis(t *testing.T) {
+	assert.Equal(t, 3479099956230698.0, GeoEncode(palermo))
+	assert.Equal(t, 3479447370796909.0, GeoEncode(catania))
+
+	p := GeoDecode(GeoEncode(palermo))
+	assert.InDelta(t, 13.36138933897018433, p.Lon, 1e-12)
+	assert.InDelta(t, 38.11555639549629859, p.Lat, 1e-12)
+
+	assert.Equal(t, "sqc8b49rny0", GeoHashString(GeoDecode(GeoEncode(palermo))))
+	assert.Equal(t, "sqdtr74hyu0", GeoHashString(GeoDecode(GeoEncode(catania))))
+}
+
+func TestGeo_Distance(t *testing.T) {
+	d := GeoDistance(GeoDecode(GeoEncode(palermo)), GeoDecode(GeoEncode(catania)))
+	assert.InDelta(t, 166274.1516, d, 0.0001)
+	assert.Zero(t, GeoDistance(palermo, palermo))
+}
+
+func TestGeo_Valid(t *testing.T) {
+	assert.True(t, GeoPoint{Lon: 180, Lat: GeoLatMax}.Valid())
+	assert.False(t, GeoPoint{Lon: 180.1, Lat: 0}.Valid())
+	assert.False(t, GeoPoint{Lon: 0, Lat: 86}.Valid())
+
+	// The corners of the grid still encode into 52 bits.
+	for _, p := range []GeoPoint{{GeoLonMin, GeoLatMin}, {GeoLonMax, GeoLatMax}} {
+		assert.Less(t, GeoEncode(p), float64(uint64(1)<<52))
+	}
+}
+
+func geoMembers(matches []GeoMatch) []string {
+	names := make([]string, len(matches))
+	for i, m := range matches {
+		names[i] = m.Member
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestGeo_Search(t *testing.T) {
+	z := NewSortedSet()
+	z.Add(
+		ScoredMember{Member: "Palermo", Score: GeoEncode(palermo)},
+		ScoredMember{Member: "Catania", Score: GeoEncode(catania)},
+		ScoredMember{Member: "edge1", Score: GeoEncode(GeoPoint{Lon: 12.758489, Lat: 38.788135})},
+		ScoredMember{Member: "edge2", Score: GeoEncode(GeoPoint{Lon: 17.241510, Lat: 38.788135})},
+	)
+	center := GeoPoint{Lon: 15, Lat: 37}
+
+	matches := z.GeoSearch(GeoShape{Center: center, Radius: 200000}, 0)
+	assert.Equal(t, []string{"Catania", "Palermo"}, geoMembers(matches))
+	for _, m := range matches {
+		if m.Member == "Catania" {
+			assert.InDelta(t, 56441.2645, m.Dist, 0.01)
+		}
+	}
+
+	matches = z.GeoSearch(GeoShape{Center: center, Box: true, Width: 400000, Height: 400000}, 0)
+	assert.Equal(t, []string{"Catania", "Palermo", "edge1", "edge2"}, geoMembers(matches))
+
+	assert.Len(t, z.GeoSearch(GeoShape{Center: center, Radius: 200000}, 1), 1)
+	assert.Empty(t, z.GeoSearch(GeoShape{Center: GeoPoint{Lon: -70, Lat: 40}, Radius: 500000}, 0))
+}
+
+func TestGeo_SearchCoversNeighbours(t *testing.T) {
+	// Points around the center in every direction, and across the
+	// antimeridian, are found whichever cell they fall in.
+	z := NewSortedSet()
+	var want []string
+	for i, p := range []GeoPoint{
+		{Lon: 179.99, Lat: 0}, {Lon: -179.99, Lat: 0}, {Lon: 180, Lat: 0.01}, {Lon: -180, Lat: -0.01},
+	} {
+		name := string(rune('a' + i))
+		z.Add(ScoredMember{Member: name, Score: GeoEncode(p)})
+		want = append(want, name)
+	}
+	matches := z.GeoSearch(GeoShape{Center: GeoPoint{Lon: 180, Lat: 0}, Radius: 5000}, 0)
+	assert.Equal(t, want, geoMembers(matches))
+
+	// Every member within the radius is found, and no other.
+	z = NewSortedSet()
+	center := GeoPoint{Lon: 2.3522, Lat: 48.8566}
+	inside := 0
+	for i := 0; i < 400; i++ {
+		p := GeoPoint{Lon: center.Lon - 1 + float64(i%20)*0.1, Lat: center.Lat - 1 + float64(i/20)*0.1}
+		z.Add(ScoredMember{Member: string(rune(0x100 + i)), Score: GeoEncode(p)})
+		if GeoDistance(center, GeoDecode(GeoEncode(p))) <= 50000 {
+			inside++
+		}
+	}
+	require.NotZero(t, inside)
+	assert.Len(t, z.GeoSearch(GeoShape{Center: center, Radius: 50000}, 0), inside)
+}
+
+func TestStore_GeoSearch(t *testing.T) {
+	s := New()
+	matches, err := s.GeoSearch("missing", GeoShape{Radius: 1}, 0)
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+
+	s.Set("str", []byte("x"))
+	_, err = s.GeoSearch("str", GeoShape{Radius: 1}, 0)
+	assert.ErrorIs(t, err, ErrWrongType)
+}
@@ -10,6 +10,7 @@ package store
 // The Hash itself is NOT thread-safe; concurrency is managed by the Store.
 type Hash struct {
 	fields map[string][]byte
+	bytes  int // total length of fields and values, for memory accounting
 }
 
 // NewHash creates a new empty Hash.
@@ -22,7 +23,7 @@ func NewHash() *Hash {
 // Set sets field to value. Returns true if the field is new (didn't exist before).
 func (h *Hash) Set(field string, value []byte) bool {
 	_, existed := h.fields[field]
-	h.fields[field] = append([]byte(nil), value...)
+	h.put(field, append([]byte(nil), value...))
 	return !existed
 }
 
@@ -32,10 +33,19 @@ func (h *Hash) SetNX(field string, value []byte) bool {
 	if _, exists := h.fields[field]; exists {
 		return false
 	}
-	h.fields[field] = append([]byte(nil), value...)
+	h.put(field, append([]byte(nil), value...))
 	return true
 }
 
+// put stores value, which the hash takes ownership of, under field.
+func (h *Hash) put(field string, value []byte) {
+	if old, exists := h.fields[field]; exists {
+		h.bytes -= len(field) + len(old)
+	}
+	h.fields[field] = value
+	h.bytes += len(field) + len(value)
+}
+
 // Get returns the value of a field.
 func (h *Hash) Get(field string) ([]byte, bool) {
 	val, exists := h.fields[field]
@@ -51,8 +61,9 @@ func (h *Hash) Get(field string) ([]byte, bool) {
 func (h *Hash) Del(fields ...string) int {
 	removed := 0
 	for _, f := range fields {
-		if _, exists := h.fields[f]; exists {
+		if val, exists := h.fields[f]; exists {
 			delete(h.fields, f)
+			h.bytes -= len(f) + len(val)
 			removed++
 		}
 	}
@@ -116,7 +127,7 @@ func (h *Hash) IncrBy(field string, delta int64) (int64, error) {
 	}
 
 	newVal := current + delta
-	h.fields[field] = formatInt64(newVal)
+	h.put(field, formatInt64(newVal))
 	return newVal, nil
 }
 
@@ -134,7 +145,7 @@ func (h *Hash) IncrByFloat(field string, delta float64) (float64, error) {
 	}
 
 	newVal := current + delta
-	h.fields[field] = formatFloat64(newVal)
+	h.put(field, formatFloat64(newVal))
 	return newVal, nil
 }
 
@@ -143,3 +154,12 @@ type HashFieldValue struct {
 	Field string
 	Value []byte
 }
+
+// clone returns a deep copy of the hash.
+func (h *Hash) clone() *Hash {
+	c := &Hash{fields: make(map[string][]byte, len(h.fields)), bytes: h.bytes}
+	for field, value := range h.fields {
+		c.fields[field] = cloneBytes(value)
+	}
+	return c
+}
@@ -0,0 +1,375 @@
+// Package store - HyperLogLog implementation for FlashDB
+//
+// A HyperLogLog estimates the number of distinct elements added to it with
+// a standard error of 0.81%, in at most 12 KB. Like in Redis it is stored as
+// a plain string in the Redis HYLL format, so HyperLogLogs persist, dump and
+// replicate like any other string and stay byte-compatible with Redis.
+//
+// The string starts with a 16-byte header: the magic "HYLL", the encoding,
+// three unused bytes and the cached cardinality as a little-endian uint64
+// whose most significant bit marks it stale. 16384 registers follow, each
+// holding the longest run of trailing zeros (plus one) seen among the hashes
+// that select it. Small HyperLogLogs use the sparse encoding, which
+// run-length encodes the registers; once that grows past
+// hllSparseMaxBytes or a register exceeds 32 it becomes dense, packing
+// the registers into 6 bits each.
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+)
+
+var (
+	// ErrNotHLL is returned for a string that is not a HyperLogLog.
+	ErrNotHLL = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	// ErrHLLCorrupt is returned for a HyperLogLog with a broken encoding.
+	ErrHLLCorrupt = errors.New("INVALIDOBJ Corrupted HLL object detected")
+)
+
+const (
+	hllP         = 14 // bits of the hash that select a register
+	hllQ         = 64 - hllP
+	hllRegisters = 1 << hllP
+	hllBits      = 6
+	hllHdrSize   = 16
+	hllDenseSize = hllHdrSize + (hllRegisters*hllBits+7)/8
+
+	hllDense  = 0
+	hllSparse = 1
+
+	hllSparseMaxValue = 32
+	hllSparseMaxBytes = 3000
+
+	// Sparse opcodes: ZERO 00xxxxxx is a run of 1-64 zero registers, XZERO
+	// 01xxxxxx yyyyyyyy a run of 1-16384, and VAL 1vvvvvxx a run of 1-4
+	// registers holding 1-32.
+	hllZeroMaxLen  = 64
+	hllXZeroMaxLen = 16384
+	hllValMaxLen   = 4
+)
+
+// hllMagic starts every HyperLogLog string.
+var hllMagic = []byte("HYLL")
+
+// newHLL returns an empty sparse HyperLogLog.
+func newHLL() []byte {
+	b := make([]byte, hllHdrSize, hllHdrSize+2)
+	copy(b, hllMagic)
+	b[4] = hllSparse
+	return append(b, 0x40|byte((hllRegisters-1)>>8), byte((hllRegisters-1)&0xFF))
+}
+
+// checkHLL returns ErrNotHLL unless b has a HyperLogLog header and a valid
+// length for its encoding.
+func checkHLL(b []byte) error {
+	if len(b) < hllHdrSize || string(b[:4]) != string(hllMagic) || b[4] > hllSparse {
+		return ErrNotHLL
+	}
+	if b[4] == hllDense && len(b) != hllDenseSize {
+		return ErrNotHLL
+	}
+	return nil
+}
+
+// invalidateHLLCache marks the cached cardinality of b stale.
+func invalidateHLLCache(b []byte) {
+	b[15] |= 0x80
+}
+
+// hllHash returns the register an element selects and the count to store
+// there: one plus the number of trailing zeros in the rest of its hash.
+func hllHash(element []byte) (int, uint8) {
+	h := murmurHash64A(element, 0xadc83b19)
+	index := int(h & (hllRegisters - 1))
+	h >>= hllP
+	h |= 1 << hllQ
+	return index, uint8(bits.TrailingZeros64(h)) + 1
+}
+
+// murmurHash64A is MurmurHash2, 64-bit version, as used by Redis.
+func murmurHash64A(data []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := seed ^ (uint64(len(data)) * m)
+	for len(data) >= 8 {
+		k := binary.LittleEndian.Uint64(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+		data = data[8:]
+	}
+	if len(data) > 0 {
+		for i := len(data) - 1; i >= 0; i-- {
+			h ^= uint64(data[i]) << (8 * i)
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// denseRegister returns register i of the dense registers regs.
+func denseRegister(regs []byte, i int) uint8 {
+	pos := i * hllBits
+	b, fb := pos/8, uint(pos%8)
+	v := uint(regs[b]) >> fb
+	if b+1 < len(regs) {
+		v |= uint(regs[b+1]) << (8 - fb)
+	}
+	return uint8(v & 63)
+}
+
+// setDenseRegister sets register i of the dense registers regs to v.
+func setDenseRegister(regs []byte, i int, v uint8) {
+	pos := i * hllBits
+	b, fb := pos/8, uint(pos%8)
+	regs[b] &^= byte(63 << fb)
+	regs[b] |= byte(uint(v) << fb)
+	if b+1 < len(regs) {
+		regs[b+1] &^= byte(63 >> (8 - fb))
+		regs[b+1] |= byte(uint(v) >> (8 - fb))
+	}
+}
+
+// hllMaxRegisters decodes the registers of the HyperLogLog b into regs,
+// keeping the larger value where regs already holds one.
+func hllMaxRegisters(b []byte, regs *[hllRegisters]uint8) error {
+	if err := checkHLL(b); err != nil {
+		return err
+	}
+	if b[4] == hllDense {
+		for i := range regs {
+			regs[i] = max(regs[i], denseRegister(b[hllHdrSize:], i))
+		}
+		return nil
+	}
+
+	i := 0
+	for p := hllHdrSize; p < len(b); p++ {
+		var v uint8
+		n := 0
+		switch op := b[p]; {
+		case op&0xC0 == 0x00:
+			n = int(op&0x3F) + 1
+		case op&0xC0 == 0x40:
+			if p+1 >= len(b) {
+				return ErrHLLCorrupt
+			}
+			n = (int(op&0x3F)<<8 | int(b[p+1])) + 1
+			p++
+		default:
+			v = (op>>2)&0x1F + 1
+			n = int(op&0x03) + 1
+		}
+		if i+n > hllRegisters {
+			return ErrHLLCorrupt
+		}
+		for ; n > 0; n-- {
+			regs[i] = max(regs[i], v)
+			i++
+		}
+	}
+	if i != hllRegisters {
+		return ErrHLLCorrupt
+	}
+	return nil
+}
+
+// encodeHLL encodes regs as a HyperLogLog, sparse if allowed and small
+// enough, dense otherwise. The cached cardinality starts out stale.
+func encodeHLL(regs *[hllRegisters]uint8, sparse bool) []byte {
+	if sparse {
+		if b, ok := encodeSparseHLL(regs); ok {
+			return b
+		}
+	}
+	b := make([]byte, hllDenseSize)
+	copy(b, hllMagic)
+	b[4] = hllDense
+	invalidateHLLCache(b)
+	for i, v := range regs {
+		setDenseRegister(b[hllHdrSize:], i, v)
+	}
+	return b
+}
+
+// encodeSparseHLL encodes regs in the sparse encoding. It returns false if
+// a register is too large for it or the result exceeds hllSparseMaxBytes.
+func encodeSparseHLL(regs *[hllRegisters]uint8) ([]byte, bool) {
+	b := make([]byte, hllHdrSize, 64)
+	copy(b, hllMagic)
+	b[4] = hllSparse
+	invalidateHLLCache(b)
+	for i := 0; i < hllRegisters; {
+		v := regs[i]
+		run := 1
+		for i+run < hllRegisters && regs[i+run] == v {
+			run++
+		}
+		i += run
+		switch {
+		case v > hllSparseMaxValue:
+			return nil, false
+		case v == 0:
+			for ; run > 0; run -= min(run, hllXZeroMaxLen) {
+				n := min(run, hllXZeroMaxLen)
+				if n <= hllZeroMaxLen {
+					b = append(b, byte(n-1))
+				} else {
+					b = append(b, 0x40|byte((n-1)>>8), byte((n-1)&0xFF))
+				}
+			}
+		default:
+			for ; run > 0; run -= min(run, hllValMaxLen) {
+				n := min(run, hllValMaxLen)
+				b = append(b, 0x80|(v-1)<<2|byte(n-1))
+			}
+		}
+		if len(b) > hllSparseMaxBytes {
+			return nil, false
+		}
+	}
+	return b, true
+}
+
+// PFAdd adds elements to the HyperLogLog b, creating it if b is nil. It
+// returns the updated HyperLogLog, which may share memory with b, and
+// whether any register changed. Creating a HyperLogLog counts as a change.
+func PFAdd(b []byte, elements [][]byte) ([]byte, bool, error) {
+	changed := false
+	if b == nil {
+		b, changed = newHLL(), true
+	} else if err := checkHLL(b); err != nil {
+		return nil, false, err
+	}
+
+	if b[4] == hllDense {
+		regs := b[hllHdrSize:]
+		for _, element := range elements {
+			i, count := hllHash(element)
+			if count > denseRegister(regs, i) {
+				setDenseRegister(regs, i, count)
+				changed = true
+			}
+		}
+	} else {
+		var regs [hllRegisters]uint8
+		if err := hllMaxRegisters(b, &regs); err != nil {
+			return nil, false, err
+		}
+		updated := false
+		for _, element := range elements {
+			i, count := hllHash(element)
+			if count > regs[i] {
+				regs[i] = count
+				updated = true
+			}
+		}
+		if updated {
+			b, changed = encodeHLL(&regs, true), true
+		}
+	}
+	if changed {
+		invalidateHLLCache(b)
+	}
+	return b, changed, nil
+}
+
+// PFCount estimates the number of distinct elements added to the union of
+// the HyperLogLogs hlls; nil entries count as empty. A single HyperLogLog
+// answers from its cached cardinality when that is fresh.
+func PFCount(hlls ...[]byte) (uint64, error) {
+	if len(hlls) == 1 && hlls[0] != nil {
+		if err := checkHLL(hlls[0]); err != nil {
+			return 0, err
+		}
+		if hlls[0][15]&0x80 == 0 {
+			return binary.LittleEndian.Uint64(hlls[0][8:16]), nil
+		}
+	}
+	var regs [hllRegisters]uint8
+	for _, b := range hlls {
+		if b == nil {
+			continue
+		}
+		if err := hllMaxRegisters(b, &regs); err != nil {
+			return 0, err
+		}
+	}
+	return hllEstimate(&regs), nil
+}
+
+// PFMerge returns a HyperLogLog holding the union of hlls; nil entries
+// count as empty. The result stays sparse while every input is sparse and
+// it fits.
+func PFMerge(hlls ...[]byte) ([]byte, error) {
+	var regs [hllRegisters]uint8
+	sparse := true
+	for _, b := range hlls {
+		if b == nil {
+			continue
+		}
+		if err := hllMaxRegisters(b, &regs); err != nil {
+			return nil, err
+		}
+		sparse = sparse && b[4] == hllSparse
+	}
+	return encodeHLL(&regs, sparse), nil
+}
+
+// hllEstimate estimates the cardinality from the registers with the
+// improved estimator by Otmar Ertl, as Redis does.
+func hllEstimate(regs *[hllRegisters]uint8) uint64 {
+	var histogram [64]int
+	for _, v := range regs {
+		histogram[v]++
+	}
+	const m = float64(hllRegisters)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	const alphaInf = 0.721347520444481703680 // 1/(2 ln 2)
+	return uint64(math.Round(alphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if prev == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if prev == z {
+			return z / 3
+		}
+	}
+}
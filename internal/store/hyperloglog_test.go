@@ -0,0 +1,138 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hllElements(prefix string, n int) [][]byte {
+	elements := make([][]byte, n)
+	for i := range elements {
+		elements[i] = []byte(fmt.Sprintf("%s:%d", prefix, i))
+	}
+	return elements
+}
+
+func TestHLL_SmallCountsAreExact(t *testing.T) {
+	b, changed, err := PFAdd(nil, nil)
+	require.NoError(t, err)
+	assert.True(t, changed, "creating counts as a change")
+	n, err := PFCount(b)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), n)
+
+	b, changed, err = PFAdd(b, hllElements("a", 10))
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, byte(hllSparse), b[4])
+	n, _ = PFCount(b)
+	assert.Equal(t, uint64(10), n)
+
+	_, changed, err = PFAdd(b, hllElements("a", 10))
+	require.NoError(t, err)
+	assert.False(t, changed)
+}
+
+func TestHLL_ErrorBounds(t *testing.T) {
+	var b []byte
+	for _, n := range []int{1000, 10000, 100000} {
+		var err error
+		b, _, err = PFAdd(nil, hllElements("e", n))
+		require.NoError(t, err)
+		got, err := PFCount(b)
+		require.NoError(t, err)
+		// Five standard errors of 0.81%.
+		assert.InDelta(t, n, got, float64(n)*0.0405, "n=%d", n)
+	}
+	assert.Equal(t, byte(hllDense), b[4])
+	assert.Len(t, b, hllDenseSize)
+
+	// Dense adds update registers in place.
+	before := append([]byte(nil), b...)
+	b, changed, err := PFAdd(b, hllElements("more", 100))
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Len(t, b, hllDenseSize)
+	assert.NotEqual(t, before, b)
+}
+
+func TestHLL_SparseToDense(t *testing.T) {
+	b, _, err := PFAdd(nil, hllElements("s", 200))
+	require.NoError(t, err)
+	assert.Equal(t, byte(hllSparse), b[4])
+	assert.LessOrEqual(t, len(b), hllSparseMaxBytes)
+
+	var regs [hllRegisters]uint8
+	require.NoError(t, hllMaxRegisters(b, &regs))
+	sparse := encodeHLL(&regs, true)
+	dense := encodeHLL(&regs, false)
+	assert.Equal(t, byte(hllDense), dense[4])
+	n1, _ := PFCount(sparse)
+	n2, _ := PFCount(dense)
+	assert.Equal(t, n1, n2)
+
+	// A register too large for the sparse encoding forces dense.
+	regs[5] = hllSparseMaxValue + 1
+	assert.Equal(t, byte(hllDense), encodeHLL(&regs, true)[4])
+
+	b, _, err = PFAdd(b, hllElements("t", 3000))
+	require.NoError(t, err)
+	assert.Equal(t, byte(hllDense), b[4])
+}
+
+func TestHLL_Merge(t *testing.T) {
+	a, _, _ := PFAdd(nil, hllElements("x", 5000))
+	b, _, _ := PFAdd(nil, hllElements("x", 7000)[2000:])
+	c, _, _ := PFAdd(nil, hllElements("y", 50))
+
+	union, err := PFCount(a, b, nil)
+	require.NoError(t, err)
+	assert.InDelta(t, 7000, union, 7000*0.0405)
+
+	merged, err := PFMerge(a, b)
+	require.NoError(t, err)
+	n, _ := PFCount(merged)
+	assert.Equal(t, union, n)
+
+	small, err := PFMerge(nil, c)
+	require.NoError(t, err)
+	assert.Equal(t, byte(hllSparse), small[4], "merging sparse inputs stays sparse")
+	n, _ = PFCount(small)
+	assert.Equal(t, uint64(50), n)
+}
+
+func TestHLL_Cache(t *testing.T) {
+	b, _, _ := PFAdd(nil, hllElements("c", 100))
+	assert.NotZero(t, b[15]&0x80, "adds invalidate the cache")
+
+	// A fresh cached cardinality is trusted as is.
+	b[15] = 0
+	b[8] = 42
+	n, err := PFCount(b)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(42), n)
+	n, _ = PFCount(b, nil)
+	assert.NotEqual(t, uint64(42), n)
+}
+
+func TestHLL_Invalid(t *testing.T) {
+	for _, bad := range [][]byte{{}, []byte("hello"), []byte("HYLL\x02" + string(make([]byte, 11))), []byte("HYLL\x00" + string(make([]byte, 20)))} {
+		_, _, err := PFAdd(bad, nil)
+		assert.ErrorIs(t, err, ErrNotHLL, "%q", bad)
+		_, err = PFCount(bad)
+		assert.ErrorIs(t, err, ErrNotHLL, "%q", bad)
+	}
+
+	// Sparse runs must cover exactly every register.
+	b := newHLL()
+	b = append(b[:hllHdrSize], 0x7F, 0xFE)
+	invalidateHLLCache(b)
+	_, err := PFCount(b)
+	assert.ErrorIs(t, err, ErrHLLCorrupt)
+	b = append(b, 0x00, 0x00)
+	_, _, err = PFAdd(b, hllElements("z", 1))
+	assert.ErrorIs(t, err, ErrHLLCorrupt)
+}
@@ -0,0 +1,937 @@
+// Package store - JSON documents for FlashDB
+//
+// A JSON key holds a parsed document, so commands read and change parts of
+// it in place instead of rewriting a serialized string. Objects keep their
+// members in insertion order, and numbers are int64 when they are integers
+// that fit and float64 otherwise, so JSON.TYPE tells "integer" from
+// "number" and integer arithmetic stays exact.
+//
+// Commands never change a document directly. They plan a list of JSONEdits
+// against it, each a change at one location named by a normalized path such
+// as $["a"][0]; the engine logs the edits and applies them with
+// Store.JSONApply. A command whose path matches many locations, or whose
+// result depends on the document, therefore replays exactly.
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// ErrJSONSyntax is returned for a value that is not valid JSON.
+	ErrJSONSyntax = errors.New("invalid JSON")
+	// ErrJSONNoPath is returned when a legacy path matches nothing.
+	ErrJSONNoPath = errors.New("path does not exist")
+	// ErrJSONWrongType is returned when a legacy path selects a value of the
+	// wrong type for the command.
+	ErrJSONWrongType = errors.New("WRONGTYPE wrong type of path value")
+	// ErrJSONNoKey is returned by commands that need an existing document.
+	ErrJSONNoKey = errors.New("could not perform this operation on a key that doesn't exist")
+	// ErrJSONNewAtRoot is returned when a missing key is set below the root.
+	ErrJSONNewAtRoot = errors.New("new objects must be created at the root")
+	// ErrJSONIndex is returned for an array index out of range.
+	ErrJSONIndex = errors.New("index out of bounds")
+	// ErrJSONNaN is returned when arithmetic overflows to infinity.
+	ErrJSONNaN = errors.New("result is not a number")
+
+	// errJSONEdit is returned for an edit that does not fit the document.
+	errJSONEdit = errors.New("store: JSON edit does not apply to the document")
+)
+
+// noPathError reports a legacy path that matches nothing.
+type noPathError struct{ path string }
+
+func (e noPathError) Error() string        { return fmt.Sprintf("Path '%s' does not exist", e.path) }
+func (e noPathError) Is(target error) bool { return target == ErrJSONNoPath }
+
+// jsonObject is a JSON object that keeps its members in insertion order, as
+// RedisJSON does.
+type jsonObject struct {
+	keys   []string
+	values map[string]any
+}
+
+func newJSONObject() *jsonObject {
+	return &jsonObject{values: make(map[string]any)}
+}
+
+// set stores a member, appending it if it is new.
+func (o *jsonObject) set(key string, v any) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = v
+}
+
+// remove deletes a member and reports whether it existed.
+func (o *jsonObject) remove(key string) bool {
+	if _, ok := o.values[key]; !ok {
+		return false
+	}
+	delete(o.values, key)
+	i := slices.Index(o.keys, key)
+	o.keys = slices.Delete(o.keys, i, i+1)
+	return true
+}
+
+// cloneJSON returns a deep copy of a JSON value.
+func cloneJSON(v any) any {
+	switch v := v.(type) {
+	case *jsonObject:
+		c := &jsonObject{keys: slices.Clone(v.keys), values: make(map[string]any, len(v.values))}
+		for k, e := range v.values {
+			c.values[k] = cloneJSON(e)
+		}
+		return c
+	case []any:
+		c := make([]any, len(v))
+		for i, e := range v {
+			c[i] = cloneJSON(e)
+		}
+		return c
+	}
+	return v
+}
+
+// ─── Parsing and serialization ──────────────────────────────────────────────
+
+// parseJSON parses a JSON text.
+func parseJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := decodeJSON(dec)
+	if err == nil {
+		if _, err = dec.Token(); err == io.EOF {
+			return v, nil
+		}
+		if err == nil {
+			err = errors.New("trailing characters after the value")
+		}
+	}
+	return nil, fmt.Errorf("%w: %v", ErrJSONSyntax, err)
+}
+
+func decodeJSON(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '{' {
+			obj := newJSONObject()
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				v, err := decodeJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				obj.set(key.(string), v)
+			}
+			_, err := dec.Token()
+			return obj, err
+		}
+		arr := []any{}
+		for dec.More() {
+			v, err := decodeJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		_, err := dec.Token()
+		return arr, err
+	case json.Number:
+		n, ok := parseJSONNumber(t.String())
+		if !ok {
+			return nil, fmt.Errorf("number %s out of range", t)
+		}
+		return n, nil
+	}
+	return tok, nil
+}
+
+// parseJSONNumber parses a JSON number as an int64 if it is an integer that
+// fits, and as a float64 otherwise.
+func parseJSONNumber(s string) (any, bool) {
+	if !strings.ContainsAny(s, ".eE") {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n, true
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, false
+	}
+	return f, true
+}
+
+// JSONFormat lays out serialized JSON: Indent is repeated once per nesting
+// level, Newline ends each line and Space follows each colon. The zero
+// value is compact.
+type JSONFormat struct {
+	Indent  string
+	Newline string
+	Space   string
+}
+
+// marshalJSON returns compact JSON text for v.
+func marshalJSON(v any) []byte {
+	return appendJSON(nil, v, JSONFormat{}, 0)
+}
+
+func appendJSON(b []byte, v any, f JSONFormat, depth int) []byte {
+	line := func(b []byte, depth int) []byte {
+		b = append(b, f.Newline...)
+		for i := 0; i < depth; i++ {
+			b = append(b, f.Indent...)
+		}
+		return b
+	}
+	switch v := v.(type) {
+	case nil:
+		return append(b, "null"...)
+	case bool:
+		return strconv.AppendBool(b, v)
+	case int64:
+		return strconv.AppendInt(b, v, 10)
+	case float64:
+		return appendJSONFloat(b, v)
+	case string:
+		return appendJSONString(b, v)
+	case []any:
+		if len(v) == 0 {
+			return append(b, "[]"...)
+		}
+		b = append(b, '[')
+		for i, e := range v {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = line(b, depth+1)
+			b = appendJSON(b, e, f, depth+1)
+		}
+		return append(line(b, depth), ']')
+	case *jsonObject:
+		if len(v.keys) == 0 {
+			return append(b, "{}"...)
+		}
+		b = append(b, '{')
+		for i, k := range v.keys {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = line(b, depth+1)
+			b = appendJSONString(b, k)
+			b = append(b, ':')
+			b = append(b, f.Space...)
+			b = appendJSON(b, v.values[k], f, depth+1)
+		}
+		return append(line(b, depth), '}')
+	}
+	return b
+}
+
+// appendJSONFloat appends f so that it reads back as a float.
+func appendJSONFloat(b []byte, f float64) []byte {
+	start := len(b)
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	b = strconv.AppendFloat(b, f, format, -1, 64)
+	if !bytes.ContainsAny(b[start:], ".e") {
+		b = append(b, ".0"...)
+	}
+	return b
+}
+
+// appendJSONString appends s as a JSON string literal.
+func appendJSONString(b []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	b = append(b, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && size == 1 {
+				b = append(b, "\ufffd"...)
+			} else {
+				b = append(b, s[i:i+size]...)
+			}
+			i += size
+			continue
+		}
+		switch {
+		case c == '"' || c == '\\':
+			b = append(b, '\\', c)
+		case c == '\n':
+			b = append(b, `\n`...)
+		case c == '\r':
+			b = append(b, `\r`...)
+		case c == '\t':
+			b = append(b, `\t`...)
+		case c < 0x20:
+			b = append(b, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xF])
+		default:
+			b = append(b, c)
+		}
+		i++
+	}
+	return append(b, '"')
+}
+
+// jsonTypeName returns the JSON.TYPE name of a value.
+func jsonTypeName(v any) string {
+	switch v.(type) {
+	case bool:
+		return "boolean"
+	case int64:
+		return "integer"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case *jsonObject:
+		return "object"
+	}
+	return "null"
+}
+
+// jsonFloat returns a number as a float64.
+func jsonFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// jsonEqual reports whether two JSON values are equal; numbers compare by
+// value whatever their representation.
+func jsonEqual(a, b any) bool {
+	if x, ok := jsonFloat(a); ok {
+		y, ok := jsonFloat(b)
+		return ok && x == y
+	}
+	switch a := a.(type) {
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !jsonEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case *jsonObject:
+		b, ok := b.(*jsonObject)
+		if !ok || len(a.keys) != len(b.keys) {
+			return false
+		}
+		for k, v := range a.values {
+			if w, ok := b.values[k]; !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// ─── Documents ──────────────────────────────────────────────────────────────
+
+// JSON is a JSON document.
+type JSON struct {
+	root any
+}
+
+func (d *JSON) clone() *JSON {
+	return &JSON{root: cloneJSON(d.root)}
+}
+
+// Marshal returns the document as compact JSON text.
+func (d *JSON) Marshal() []byte {
+	return marshalJSON(d.root)
+}
+
+// lookup returns the value at a location.
+func (d *JSON) lookup(path []jsonStep) (any, bool) {
+	v := d.root
+	for _, step := range path {
+		switch c := v.(type) {
+		case *jsonObject:
+			var ok bool
+			if v, ok = c.values[step.name]; !ok || step.index >= 0 {
+				return nil, false
+			}
+		case []any:
+			if step.index < 0 || step.index >= len(c) {
+				return nil, false
+			}
+			v = c[step.index]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// setAt stores v at a location, adding a missing last member to its object.
+// It reports whether the location's parent exists.
+func (d *JSON) setAt(path []jsonStep, v any) bool {
+	if len(path) == 0 {
+		d.root = v
+		return true
+	}
+	parent, ok := d.lookup(path[:len(path)-1])
+	if !ok {
+		return false
+	}
+	last := path[len(path)-1]
+	switch c := parent.(type) {
+	case *jsonObject:
+		if last.index < 0 {
+			c.set(last.name, v)
+			return true
+		}
+	case []any:
+		if last.index >= 0 && last.index < len(c) {
+			c[last.index] = v
+			return true
+		}
+	}
+	return false
+}
+
+// deleteAt removes the value at a location below the root and reports
+// whether it existed.
+func (d *JSON) deleteAt(path []jsonStep) bool {
+	if len(path) == 0 {
+		return false
+	}
+	parent, ok := d.lookup(path[:len(path)-1])
+	if !ok {
+		return false
+	}
+	last := path[len(path)-1]
+	switch c := parent.(type) {
+	case *jsonObject:
+		return last.index < 0 && c.remove(last.name)
+	case []any:
+		if last.index < 0 || last.index >= len(c) {
+			return false
+		}
+		return d.setAt(path[:len(path)-1], append(c[:last.index:last.index], c[last.index+1:]...))
+	}
+	return false
+}
+
+// JSONEditKind is the kind of a JSONEdit.
+type JSONEditKind uint8
+
+// JSON edit kinds.
+const (
+	JSONEditSet    JSONEditKind = iota // store Values[0] at Path
+	JSONEditDelete                     // remove the value at Path
+	JSONEditInsert                     // insert Values into the array at Path before Index
+	JSONEditTrim                       // keep elements Index to Stop of the array at Path; Stop < Index empties it
+)
+
+// JSONEdit is a change at one location of a document, the unit in which
+// JSON commands are logged. Path is a normalized path; Values are compact
+// JSON texts.
+type JSONEdit struct {
+	Kind   JSONEditKind
+	Path   string
+	Values [][]byte
+	Index  int
+	Stop   int
+}
+
+// apply makes an edit planned against the document.
+func (d *JSON) apply(e JSONEdit) error {
+	path, err := parseJSONLocation(e.Path)
+	if err != nil {
+		return err
+	}
+	values := make([]any, len(e.Values))
+	for i, text := range e.Values {
+		if values[i], err = parseJSON(text); err != nil {
+			return err
+		}
+	}
+
+	switch e.Kind {
+	case JSONEditSet:
+		if len(values) == 1 && d.setAt(path, values[0]) {
+			return nil
+		}
+	case JSONEditDelete:
+		if d.deleteAt(path) {
+			return nil
+		}
+	case JSONEditInsert:
+		v, _ := d.lookup(path)
+		if arr, ok := v.([]any); ok && e.Index >= 0 && e.Index <= len(arr) {
+			grown := make([]any, 0, len(arr)+len(values))
+			grown = append(append(append(grown, arr[:e.Index]...), values...), arr[e.Index:]...)
+			d.setAt(path, grown)
+			return nil
+		}
+	case JSONEditTrim:
+		v, _ := d.lookup(path)
+		if arr, ok := v.([]any); ok {
+			if e.Stop < e.Index {
+				d.setAt(path, []any{})
+				return nil
+			}
+			if e.Index >= 0 && e.Stop < len(arr) {
+				d.setAt(path, append([]any(nil), arr[e.Index:e.Stop+1]...))
+				return nil
+			}
+		}
+	}
+	return errJSONEdit
+}
+
+// ─── Queries ────────────────────────────────────────────────────────────────
+
+// matches returns the locations p selects, or for a legacy path only the
+// first.
+func (d *JSON) matches(p *JSONPath) []jsonNode {
+	nodes := evalJSONPath(p.segments, d.root, d.root)
+	if p.legacy && len(nodes) > 1 {
+		nodes = nodes[:1]
+	}
+	return nodes
+}
+
+// typedMatches returns the locations p selects for a command that works on
+// values of type want ("array" or "number"). A legacy path must select a
+// value of that type.
+func (d *JSON) typedMatches(p *JSONPath, want string) ([]jsonNode, error) {
+	nodes := d.matches(p)
+	if !p.legacy {
+		return nodes, nil
+	}
+	if len(nodes) == 0 {
+		return nil, noPathError{p.raw}
+	}
+	if found := jsonTypeName(nodes[0].value); !isJSONType(nodes[0].value, want) {
+		return nil, fmt.Errorf("%w - expected %s but found %s", ErrJSONWrongType, want, found)
+	}
+	return nodes, nil
+}
+
+func isJSONType(v any, want string) bool {
+	if want == "number" {
+		_, ok := jsonFloat(v)
+		return ok
+	}
+	return jsonTypeName(v) == want
+}
+
+// Get serializes what paths select as JSON.GET replies: the value a single
+// legacy path selects, an array of the values a single JSONPath selects, or
+// for several paths an object mapping each path to its result. With any
+// JSONPath among several paths, every result is an array. With no paths it
+// serializes the whole document.
+func (d *JSON) Get(f JSONFormat, paths ...*JSONPath) ([]byte, error) {
+	if len(paths) == 0 {
+		return appendJSON(nil, d.root, f, 0), nil
+	}
+	legacy := true
+	for _, p := range paths {
+		legacy = legacy && p.legacy
+	}
+	result := func(p *JSONPath) (any, error) {
+		nodes := d.matches(p)
+		if legacy {
+			if len(nodes) == 0 {
+				return nil, noPathError{p.raw}
+			}
+			return nodes[0].value, nil
+		}
+		values := make([]any, len(nodes))
+		for i, n := range nodes {
+			values[i] = n.value
+		}
+		return values, nil
+	}
+
+	if len(paths) == 1 {
+		v, err := result(paths[0])
+		if err != nil {
+			return nil, err
+		}
+		return appendJSON(nil, v, f, 0), nil
+	}
+	obj := newJSONObject()
+	for _, p := range paths {
+		v, err := result(p)
+		if err != nil {
+			return nil, err
+		}
+		obj.set(p.raw, v)
+	}
+	return appendJSON(nil, obj, f, 0), nil
+}
+
+// Types returns the JSON.TYPE names of the values p selects.
+func (d *JSON) Types(p *JSONPath) []string {
+	nodes := d.matches(p)
+	types := make([]string, len(nodes))
+	for i, n := range nodes {
+		types[i] = jsonTypeName(n.value)
+	}
+	return types
+}
+
+// JSONResult is the outcome of a JSON command at one location its path
+// selects. OK is false where the value there has the wrong type.
+type JSONResult struct {
+	N    int64  // array length
+	JSON []byte // popped element or new number; nil if nothing was popped
+	OK   bool
+}
+
+// ArrLen returns the lengths of the arrays p selects.
+func (d *JSON) ArrLen(p *JSONPath) ([]JSONResult, error) {
+	nodes, err := d.typedMatches(p, "array")
+	if err != nil {
+		return nil, err
+	}
+	results := make([]JSONResult, len(nodes))
+	for i, n := range nodes {
+		if arr, ok := n.value.([]any); ok {
+			results[i] = JSONResult{N: int64(len(arr)), OK: true}
+		}
+	}
+	return results, nil
+}
+
+// ─── Planning ───────────────────────────────────────────────────────────────
+
+// compareJSONLocations orders locations by their steps, indexes numerically
+// and before names, parents before children.
+func compareJSONLocations(a, b []jsonStep) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		x, y := a[i], b[i]
+		switch {
+		case x.index >= 0 && y.index >= 0:
+			if x.index != y.index {
+				return x.index - y.index
+			}
+		case x.index >= 0:
+			return -1
+		case y.index >= 0:
+			return 1
+		default:
+			if c := strings.Compare(x.name, y.name); c != 0 {
+				return c
+			}
+		}
+	}
+	return len(a) - len(b)
+}
+
+// sortJSONEdits orders edits so that none moves the location of a later
+// one: children before parents, and later array elements before earlier
+// ones.
+func sortJSONEdits(edits []JSONEdit, locs [][]jsonStep) {
+	idx := make([]int, len(edits))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return compareJSONLocations(locs[idx[i]], locs[idx[j]]) > 0
+	})
+	sorted := make([]JSONEdit, len(edits))
+	for i, k := range idx {
+		sorted[i] = edits[k]
+	}
+	copy(edits, sorted)
+}
+
+// distinct drops repeated locations, and with outermost the locations
+// nested in others.
+func distinct(nodes []jsonNode, outermost bool) []jsonNode {
+	seen := make(map[string]bool, len(nodes))
+	out := nodes[:0:0]
+	for _, n := range nodes {
+		if p := formatJSONPath(n.path); !seen[p] {
+			seen[p] = true
+			out = append(out, n)
+		}
+	}
+	if !outermost {
+		return out
+	}
+	kept := out[:0:0]
+	for _, n := range out {
+		nested := false
+		for i := 0; i < len(n.path) && !nested; i++ {
+			nested = seen[formatJSONPath(n.path[:i])]
+		}
+		if !nested {
+			kept = append(kept, n)
+		}
+	}
+	return kept
+}
+
+// PlanSet plans JSON.SET on the document, or with d nil on a missing key:
+// storing value at every location p selects or, when p selects nothing and
+// ends in a member name, adding that member to the objects the rest of p
+// selects. With nx only new members are added, with xx only existing values
+// replaced. It plans nothing when nothing would be set.
+func (d *JSON) PlanSet(p *JSONPath, value []byte, nx, xx bool) ([]JSONEdit, error) {
+	v, err := parseJSON(value)
+	if err != nil {
+		return nil, err
+	}
+	text := marshalJSON(v)
+	set := func(path string) JSONEdit {
+		return JSONEdit{Kind: JSONEditSet, Path: path, Values: [][]byte{text}}
+	}
+
+	if d == nil {
+		if !p.isRoot() {
+			return nil, ErrJSONNewAtRoot
+		}
+		if xx {
+			return nil, nil
+		}
+		return []JSONEdit{set("$")}, nil
+	}
+
+	var edits []JSONEdit
+	if nodes := d.matches(p); len(nodes) > 0 {
+		if nx {
+			return nil, nil
+		}
+		for _, n := range distinct(nodes, true) {
+			edits = append(edits, set(formatJSONPath(n.path)))
+		}
+		return edits, nil
+	}
+	if xx {
+		return nil, nil
+	}
+	last := p.segments[len(p.segments)-1]
+	if last.descendant || len(last.selectors) != 1 || last.selectors[0].kind != selName {
+		return nil, nil
+	}
+	parent := &JSONPath{raw: p.raw, legacy: p.legacy, segments: p.segments[:len(p.segments)-1]}
+	for _, n := range distinct(d.matches(parent), false) {
+		if _, ok := n.value.(*jsonObject); ok {
+			step := jsonStep{name: last.selectors[0].name, index: -1}
+			edits = append(edits, set(formatJSONPath(n.child(step, nil).path)))
+		}
+	}
+	return edits, nil
+}
+
+// PlanDel plans JSON.DEL: removing every value p selects. Removing the root
+// deletes the key.
+func (d *JSON) PlanDel(p *JSONPath) []JSONEdit {
+	if p.isRoot() {
+		return []JSONEdit{{Kind: JSONEditDelete, Path: "$"}}
+	}
+	nodes := distinct(d.matches(p), true)
+	edits := make([]JSONEdit, len(nodes))
+	locs := make([][]jsonStep, len(nodes))
+	for i, n := range nodes {
+		edits[i] = JSONEdit{Kind: JSONEditDelete, Path: formatJSONPath(n.path)}
+		locs[i] = n.path
+	}
+	sortJSONEdits(edits, locs)
+	return edits
+}
+
+// planArrays plans a command on each array p selects. fn returns the result
+// for one array and its edit, if any; it is called once per location.
+func (d *JSON) planArrays(p *JSONPath, fn func(path string, arr []any) (JSONResult, *JSONEdit, error)) ([]JSONEdit, []JSONResult, error) {
+	nodes, err := d.typedMatches(p, "array")
+	if err != nil {
+		return nil, nil, err
+	}
+	results := make([]JSONResult, len(nodes))
+	done := make(map[string]JSONResult)
+	var edits []JSONEdit
+	var locs [][]jsonStep
+	for i, n := range nodes {
+		arr, ok := n.value.([]any)
+		if !ok {
+			continue
+		}
+		path := formatJSONPath(n.path)
+		if r, ok := done[path]; ok {
+			results[i] = r
+			continue
+		}
+		r, edit, err := fn(path, arr)
+		if err != nil {
+			return nil, nil, err
+		}
+		results[i], done[path] = r, r
+		if edit != nil {
+			edits = append(edits, *edit)
+			locs = append(locs, n.path)
+		}
+	}
+	sortJSONEdits(edits, locs)
+	return edits, results, nil
+}
+
+// jsonTexts parses values and returns them as compact JSON texts.
+func jsonTexts(values [][]byte) ([][]byte, error) {
+	texts := make([][]byte, len(values))
+	for i, value := range values {
+		v, err := parseJSON(value)
+		if err != nil {
+			return nil, err
+		}
+		texts[i] = marshalJSON(v)
+	}
+	return texts, nil
+}
+
+// PlanArrInsert plans JSON.ARRINSERT, inserting values before index in each
+// array p selects, or with atEnd JSON.ARRAPPEND. A negative index counts
+// from the end. The results hold the new lengths.
+func (d *JSON) PlanArrInsert(p *JSONPath, index int, atEnd bool, values [][]byte) ([]JSONEdit, []JSONResult, error) {
+	texts, err := jsonTexts(values)
+	if err != nil {
+		return nil, nil, err
+	}
+	return d.planArrays(p, func(path string, arr []any) (JSONResult, *JSONEdit, error) {
+		at := len(arr)
+		if !atEnd {
+			if at = index; at < 0 {
+				at += len(arr)
+			}
+			if at < 0 || at > len(arr) {
+				return JSONResult{}, nil, ErrJSONIndex
+			}
+		}
+		edit := &JSONEdit{Kind: JSONEditInsert, Path: path, Values: texts, Index: at}
+		return JSONResult{N: int64(len(arr) + len(texts)), OK: true}, edit, nil
+	})
+}
+
+// PlanArrPop plans JSON.ARRPOP, removing the element at index from each
+// array p selects. Indexes count from the end when negative and are clamped
+// to the array. The results hold the removed elements.
+func (d *JSON) PlanArrPop(p *JSONPath, index int) ([]JSONEdit, []JSONResult, error) {
+	return d.planArrays(p, func(path string, arr []any) (JSONResult, *JSONEdit, error) {
+		if len(arr) == 0 {
+			return JSONResult{OK: true}, nil, nil
+		}
+		at := index
+		if at < 0 {
+			at += len(arr)
+		}
+		at = min(max(at, 0), len(arr)-1)
+		edit := &JSONEdit{Kind: JSONEditDelete, Path: path + "[" + strconv.Itoa(at) + "]"}
+		return JSONResult{JSON: marshalJSON(arr[at]), OK: true}, edit, nil
+	})
+}
+
+// PlanArrTrim plans JSON.ARRTRIM, keeping only the elements from start to
+// stop inclusive of each array p selects, with the index rules of LTRIM.
+// The results hold the new lengths.
+func (d *JSON) PlanArrTrim(p *JSONPath, start, stop int) ([]JSONEdit, []JSONResult, error) {
+	return d.planArrays(p, func(path string, arr []any) (JSONResult, *JSONEdit, error) {
+		n := len(arr)
+		lo, hi := start, stop
+		if lo < 0 {
+			lo += n
+		}
+		if hi < 0 {
+			hi += n
+		}
+		lo, hi = max(lo, 0), min(hi, n-1)
+		if lo > hi {
+			lo, hi = 0, -1
+		}
+		if lo == 0 && hi == n-1 {
+			return JSONResult{N: int64(n), OK: true}, nil, nil
+		}
+		edit := &JSONEdit{Kind: JSONEditTrim, Path: path, Index: lo, Stop: hi}
+		return JSONResult{N: int64(hi - lo + 1), OK: true}, edit, nil
+	})
+}
+
+// PlanNumOp plans JSON.NUMINCRBY, or with mult JSON.NUMMULTBY, on each
+// number p selects. Integers stay integers unless the other operand is a
+// float or the result overflows. The results hold the new numbers.
+func (d *JSON) PlanNumOp(p *JSONPath, operand []byte, mult bool) ([]JSONEdit, []JSONResult, error) {
+	v, err := parseJSON(operand)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, ok := jsonFloat(v); !ok {
+		return nil, nil, fmt.Errorf("%w: expected a number", ErrJSONSyntax)
+	}
+	nodes, err := d.typedMatches(p, "number")
+	if err != nil {
+		return nil, nil, err
+	}
+	nodes = distinct(nodes, false)
+
+	results := make([]JSONResult, len(nodes))
+	var edits []JSONEdit
+	for i, n := range nodes {
+		x, ok := jsonFloat(n.value)
+		if !ok {
+			continue
+		}
+		var r any
+		a, aInt := n.value.(int64)
+		b, bInt := v.(int64)
+		switch {
+		case aInt && bInt && !mult && (b <= 0 || a <= math.MaxInt64-b) && (b >= 0 || a >= math.MinInt64-b):
+			r = a + b
+		case aInt && bInt && mult && (a == 0 || (a*b/a == b && !(a == -1 && b == math.MinInt64))):
+			r = a * b
+		default:
+			y, _ := jsonFloat(v)
+			f := x + y
+			if mult {
+				f = x * y
+			}
+			if math.IsInf(f, 0) || math.IsNaN(f) {
+				return nil, nil, ErrJSONNaN
+			}
+			r = f
+		}
+		text := marshalJSON(r)
+		results[i] = JSONResult{JSON: text, OK: true}
+		edits = append(edits, JSONEdit{Kind: JSONEditSet, Path: formatJSONPath(n.path), Values: [][]byte{text}})
+	}
+	return edits, results, nil
+}
@@ -0,0 +1,310 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustPath(t *testing.T, path string) *JSONPath {
+	t.Helper()
+	p, err := ParseJSONPath(path)
+	require.NoError(t, err)
+	return p
+}
+
+// jsonDoc parses text into a document.
+func jsonDoc(t *testing.T, text string) *JSON {
+	t.Helper()
+	v, err := parseJSON([]byte(text))
+	require.NoError(t, err)
+	return &JSON{root: v}
+}
+
+// applyEdits applies edits to the document at key.
+func applyEdits(t *testing.T, s *Store, key string, edits []JSONEdit) {
+	t.Helper()
+	for _, edit := range edits {
+		require.NoError(t, s.JSONApply(key, edit))
+	}
+}
+
+// docText returns the compact text of the document at key.
+func docText(t *testing.T, s *Store, key string) string {
+	t.Helper()
+	item, ok := s.Item(key)
+	require.True(t, ok)
+	return string(item.JSON)
+}
+
+func TestJSON_ParseAndMarshal(t *testing.T) {
+	for text, want := range map[string]string{
+		`{"b":1,"a":[true,null,"x"]}`: `{"b":1,"a":[true,null,"x"]}`,
+		` [ 1 , 2.5 , -0.0, 1e3 ] `:   `[1,2.5,-0.0,1000.0]`,
+		`"tab\t<é>"`:                  `"tab\t<é>"`,
+		`9223372036854775808`:         `9223372036854776000.0`,
+		`{"a":1,"a":2}`:               `{"a":2}`,
+	} {
+		v, err := parseJSON([]byte(text))
+		require.NoError(t, err, text)
+		assert.Equal(t, want, string(marshalJSON(v)), text)
+	}
+
+	for _, text := range []string{``, `{`, `[1,]`, `1 2`, `{"a"}`, `nul`} {
+		_, err := parseJSON([]byte(text))
+		assert.ErrorIs(t, err, ErrJSONSyntax, text)
+	}
+}
+
+func TestJSON_NumberTypes(t *testing.T) {
+	d := jsonDoc(t, `{"i":1,"f":1.0,"e":1e2,"s":"1","n":null,"b":false,"a":[],"o":{}}`)
+	assert.Equal(t, []string{"integer", "number", "number", "string", "null", "boolean", "array", "object"},
+		d.Types(mustPath(t, "$.*")))
+	assert.True(t, jsonEqual(int64(1), 1.0))
+}
+
+func TestJSONPath_Queries(t *testing.T) {
+	d := jsonDoc(t, `{
+		"store": {
+			"book": [
+				{"title": "A", "price": 8.95, "tags": ["x"]},
+				{"title": "B", "price": 12.99},
+				{"title": "C", "price": 8.99, "isbn": "0-553"},
+				{"title": "D", "price": 22.99, "isbn": "0-395"}
+			],
+			"bicycle": {"color": "red", "price": 19.95}
+		}
+	}`)
+	get := func(path string) string {
+		out, err := d.Get(JSONFormat{}, mustPath(t, path))
+		require.NoError(t, err, path)
+		return string(out)
+	}
+
+	assert.Equal(t, `["A","B","C","D"]`, get("$.store.book[*].title"))
+	assert.Equal(t, `[8.95,12.99,8.99,22.99,19.95]`, get("$..price"))
+	assert.Equal(t, `["D"]`, get("$.store.book[-1].title"))
+	assert.Equal(t, `["A","C"]`, get("$.store.book[0:4:2].title"))
+	assert.Equal(t, `["D","C"]`, get("$.store.book[:1:-1].title"))
+	assert.Equal(t, `["A","B"]`, get(`$.store.book[0,1]["title"]`))
+	assert.Equal(t, `["A","C"]`, get("$.store.book[?(@.price < 10)].title"))
+	assert.Equal(t, `["C","D"]`, get("$.store.book[?(@.isbn)].title"))
+	assert.Equal(t, `["B","D"]`, get(`$.store.book[?(@.price > 10 && @.title != "x")].title`))
+	assert.Equal(t, `["A"]`, get(`$.store.book[?(@.title =~ "^A" || !@.price)].title`))
+	assert.Equal(t, `["C"]`, get(`$.store.book[?(@.price == $.store.book[2].price)].title`))
+	assert.Equal(t, `[]`, get("$.nothing"))
+
+	// Legacy paths return their first match alone.
+	assert.Equal(t, `"A"`, get(".store.book[0].title"))
+	assert.Equal(t, `8.95`, get("..price"))
+	assert.Equal(t, `"red"`, get("store.bicycle.color"))
+	_, err := d.Get(JSONFormat{}, mustPath(t, ".nothing"))
+	assert.ErrorIs(t, err, ErrJSONNoPath)
+	assert.EqualError(t, err, "Path '.nothing' does not exist")
+
+	for _, bad := range []string{"$.", "$[", "$[?(@.a ==)]", "$['a'", "$..", "$.a]", "$[1:2"} {
+		_, err := ParseJSONPath(bad)
+		assert.ErrorIs(t, err, ErrJSONPathSyntax, bad)
+	}
+}
+
+func TestJSON_GetFormats(t *testing.T) {
+	d := jsonDoc(t, `{"a":[1,{"b":null}],"c":{}}`)
+
+	out, err := d.Get(JSONFormat{Indent: "  ", Newline: "\n", Space: " "})
+	require.NoError(t, err)
+	assert.Equal(t, "{\n  \"a\": [\n    1,\n    {\n      \"b\": null\n    }\n  ],\n  \"c\": {}\n}", string(out))
+
+	out, err = d.Get(JSONFormat{}, mustPath(t, ".a"), mustPath(t, ".c"))
+	require.NoError(t, err)
+	assert.Equal(t, `{".a":[1,{"b":null}],".c":{}}`, string(out))
+
+	out, err = d.Get(JSONFormat{}, mustPath(t, "$.a[0]"), mustPath(t, ".c"))
+	require.NoError(t, err)
+	assert.Equal(t, `{"$.a[0]":[1],".c":[{}]}`, string(out))
+}
+
+func TestJSON_SetAndDel(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	var missing *JSON
+	_, err := missing.PlanSet(mustPath(t, "$.a"), []byte(`1`), false, false)
+	assert.ErrorIs(t, err, ErrJSONNewAtRoot)
+	edits, err := missing.PlanSet(mustPath(t, "$"), []byte(` {"a": [1, 2], "b": {"c": 1}} `), false, false)
+	require.NoError(t, err)
+	assert.Equal(t, []JSONEdit{{Kind: JSONEditSet, Path: "$", Values: [][]byte{[]byte(`{"a":[1,2],"b":{"c":1}}`)}}}, edits)
+	applyEdits(t, s, "doc", edits)
+	assert.Equal(t, TypeJSON, s.Type("doc"))
+
+	plan := func(path, value string, nx, xx bool) []JSONEdit {
+		var edits []JSONEdit
+		require.NoError(t, s.JSONRead("doc", func(doc *JSON) error {
+			var err error
+			edits, err = doc.PlanSet(mustPath(t, path), []byte(value), nx, xx)
+			return err
+		}))
+		return edits
+	}
+
+	// A missing last member is added; NX and XX limit what is set.
+	assert.Empty(t, plan("$.b.d", `2`, false, true))
+	applyEdits(t, s, "doc", plan("$.b.d", `2`, true, false))
+	assert.Empty(t, plan("$.b.d", `3`, true, false))
+	applyEdits(t, s, "doc", plan("$.a[*]", `"x"`, false, true))
+	assert.Empty(t, plan("$.x.y", `1`, false, false))
+	assert.Equal(t, `{"a":["x","x"],"b":{"c":1,"d":2}}`, docText(t, s, "doc"))
+
+	// Nested matches are replaced once, by their outermost match.
+	edits = plan("$..*", `0`, false, false)
+	assert.Len(t, edits, 2)
+	applyEdits(t, s, "doc", edits)
+	assert.Equal(t, `{"a":0,"b":0}`, docText(t, s, "doc"))
+
+	// Deletions remove later array elements first.
+	applyEdits(t, s, "doc", plan("$", `{"a":[0,1,2,3],"b":{"c":[1]}}`, false, false))
+	var del []JSONEdit
+	require.NoError(t, s.JSONRead("doc", func(doc *JSON) error {
+		del = doc.PlanDel(mustPath(t, "$..[?(@ < 3)]"))
+		return nil
+	}))
+	assert.Equal(t, []string{"$[\"b\"][\"c\"][0]", "$[\"a\"][2]", "$[\"a\"][1]", "$[\"a\"][0]"},
+		[]string{del[0].Path, del[1].Path, del[2].Path, del[3].Path})
+	applyEdits(t, s, "doc", del)
+	assert.Equal(t, `{"a":[3],"b":{"c":[]}}`, docText(t, s, "doc"))
+
+	// Deleting the root deletes the key.
+	applyEdits(t, s, "doc", (&JSON{}).PlanDel(mustPath(t, ".")))
+	assert.False(t, s.Exists("doc"))
+}
+
+func TestJSON_ArrayOps(t *testing.T) {
+	d := jsonDoc(t, `{"a":[1,2,3],"b":{"a":[]},"c":{"a":"str"}}`)
+	s := New()
+	defer s.Close()
+	applyEdits(t, s, "doc", []JSONEdit{{Kind: JSONEditSet, Path: "$", Values: [][]byte{d.Marshal()}}})
+	run := func(fn func(doc *JSON) ([]JSONEdit, []JSONResult, error)) ([]JSONResult, error) {
+		var edits []JSONEdit
+		var results []JSONResult
+		err := s.JSONRead("doc", func(doc *JSON) error {
+			var err error
+			edits, results, err = fn(doc)
+			return err
+		})
+		if err == nil {
+			applyEdits(t, s, "doc", edits)
+		}
+		return results, err
+	}
+
+	results, err := run(func(doc *JSON) ([]JSONEdit, []JSONResult, error) {
+		return doc.PlanArrInsert(mustPath(t, "$..a"), 0, true, [][]byte{[]byte(`4`), []byte(` "five" `)})
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []JSONResult{{N: 5, OK: true}, {N: 2, OK: true}, {}}, results)
+	assert.Equal(t, `{"a":[1,2,3,4,"five"],"b":{"a":[4,"five"]},"c":{"a":"str"}}`, docText(t, s, "doc"))
+
+	results, err = run(func(doc *JSON) ([]JSONEdit, []JSONResult, error) {
+		return doc.PlanArrInsert(mustPath(t, ".a"), -1, false, [][]byte{[]byte(`0`)})
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), results[0].N)
+	_, err = run(func(doc *JSON) ([]JSONEdit, []JSONResult, error) {
+		return doc.PlanArrInsert(mustPath(t, ".a"), 7, false, [][]byte{[]byte(`0`)})
+	})
+	assert.ErrorIs(t, err, ErrJSONIndex)
+	_, err = run(func(doc *JSON) ([]JSONEdit, []JSONResult, error) {
+		return doc.PlanArrInsert(mustPath(t, ".c"), 0, true, [][]byte{[]byte(`0`)})
+	})
+	assert.ErrorIs(t, err, ErrJSONWrongType)
+	assert.EqualError(t, err, "WRONGTYPE wrong type of path value - expected array but found object")
+
+	results, err = run(func(doc *JSON) ([]JSONEdit, []JSONResult, error) {
+		return doc.PlanArrPop(mustPath(t, "$.a"), 100)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `"five"`, string(results[0].JSON))
+	assert.Equal(t, `[1,2,3,4,0]`, jsonText(t, s, "$.a"))
+
+	results, err = run(func(doc *JSON) ([]JSONEdit, []JSONResult, error) {
+		return doc.PlanArrTrim(mustPath(t, "$.a"), 1, -2)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), results[0].N)
+	assert.Equal(t, `[2,3,4]`, jsonText(t, s, "$.a"))
+
+	results, err = run(func(doc *JSON) ([]JSONEdit, []JSONResult, error) {
+		return doc.PlanArrTrim(mustPath(t, "$.a"), 5, 10)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), results[0].N)
+	results, err = run(func(doc *JSON) ([]JSONEdit, []JSONResult, error) {
+		return doc.PlanArrPop(mustPath(t, "$.a"), -1)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []JSONResult{{OK: true}}, results)
+
+	lens, err := jsonDoc(t, docText(t, s, "doc")).ArrLen(mustPath(t, "$..a"))
+	require.NoError(t, err)
+	assert.Equal(t, []JSONResult{{N: 0, OK: true}, {N: 2, OK: true}, {}}, lens)
+}
+
+// jsonText returns the values path selects in the document at key.
+func jsonText(t *testing.T, s *Store, path string) string {
+	t.Helper()
+	var out []byte
+	require.NoError(t, s.JSONRead("doc", func(doc *JSON) error {
+		v, err := doc.Get(JSONFormat{}, mustPath(t, path))
+		out = v
+		return err
+	}))
+	// Strip the array of matches around a single value.
+	return string(out[1 : len(out)-1])
+}
+
+func TestJSON_NumOps(t *testing.T) {
+	d := jsonDoc(t, `{"i":2,"f":1.5,"s":"x","big":9223372036854775807}`)
+	num := func(path, by string, mult bool) ([]JSONResult, error) {
+		edits, results, err := d.PlanNumOp(mustPath(t, path), []byte(by), mult)
+		for _, e := range edits {
+			require.NoError(t, d.apply(e))
+		}
+		return results, err
+	}
+
+	results, err := num("$.i", "3", false)
+	require.NoError(t, err)
+	assert.Equal(t, "5", string(results[0].JSON))
+	results, err = num("$.i", "0.5", true)
+	require.NoError(t, err)
+	assert.Equal(t, "2.5", string(results[0].JSON))
+	results, err = num("$.*", "2", true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"5.0", "3.0", "", "18446744073709552000.0"},
+		[]string{string(results[0].JSON), string(results[1].JSON), string(results[2].JSON), string(results[3].JSON)})
+	assert.Equal(t, []string{"number", "number", "string", "number"}, d.Types(mustPath(t, "$.*")))
+
+	_, err = num(".s", "1", false)
+	assert.ErrorIs(t, err, ErrJSONWrongType)
+	_, err = num(".f", `"1"`, false)
+	assert.ErrorIs(t, err, ErrJSONSyntax)
+	_, err = num(".f", "1e308", true)
+	assert.ErrorIs(t, err, ErrJSONNaN)
+}
+
+func TestJSON_CopyOnWrite(t *testing.T) {
+	s := New()
+	defer s.Close()
+	require.NoError(t, s.JSONApply("doc", JSONEdit{Kind: JSONEditSet, Path: "$", Values: [][]byte{[]byte(`{"a":[1]}`)}}))
+
+	v := s.OpenView()
+	require.NoError(t, s.JSONApply("doc", JSONEdit{Kind: JSONEditInsert, Path: `$["a"]`, Index: 1, Values: [][]byte{[]byte(`2`)}}))
+	assert.Equal(t, `{"a":[1]}`, string(v.Item(0).JSON))
+	v.Close()
+	assert.Equal(t, `{"a":[1,2]}`, docText(t, s, "doc"))
+
+	assert.ErrorIs(t, s.JSONApply("missing", JSONEdit{Kind: JSONEditDelete, Path: `$["a"]`}), ErrJSONNoKey)
+	s.Set("str", []byte("x"))
+	assert.ErrorIs(t, s.JSONApply("str", JSONEdit{Kind: JSONEditSet, Path: "$", Values: [][]byte{[]byte(`1`)}}), ErrWrongType)
+}
@@ -0,0 +1,764 @@
+// Package store - JSONPath queries over JSON documents
+//
+// Paths follow the JSONPath dialect RedisJSON accepts. A path starting with
+// "$" selects every match, and commands reply with one result per match.
+// Any other path is a legacy path: it is read relative to the root, selects
+// only its first match, and commands reply with that result alone.
+//
+// Supported selectors are member names (.name, ['name'], ["name"]), array
+// indexes ([0], [-1]), wildcards (.*, [*]), recursive descent (..name,
+// ..*, ..[0]), slices ([start:end:step]), unions ([0,2], ['a','b']) and
+// filters ([?(@.price < 10 && @.tags)]) with the comparisons ==, !=, <, <=,
+// >, >= and =~ (regular expression match), combined with &&, || and !.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// ErrJSONPathSyntax is returned for a path that does not parse.
+var ErrJSONPathSyntax = errors.New("JSON Path error")
+
+type jsonSelectorKind uint8
+
+const (
+	selName jsonSelectorKind = iota
+	selIndex
+	selWildcard
+	selSlice
+	selFilter
+)
+
+// jsonSelector picks children of a node.
+type jsonSelector struct {
+	kind  jsonSelectorKind
+	name  string
+	index int
+
+	// Slice bounds; start and end default to the ends of the array.
+	start, end       int
+	hasStart, hasEnd bool
+	step             int
+
+	filter *jsonExpr
+}
+
+// jsonSegment selects the union of its selectors' picks among the children
+// of each node, or with descendant among the children of each node and of
+// all its descendants.
+type jsonSegment struct {
+	descendant bool
+	selectors  []jsonSelector
+}
+
+// JSONPath is a parsed JSONPath.
+type JSONPath struct {
+	raw      string
+	legacy   bool
+	segments []jsonSegment
+}
+
+// String returns the path as it was written.
+func (p *JSONPath) String() string { return p.raw }
+
+// Legacy reports whether p is a legacy path, which selects only its first
+// match.
+func (p *JSONPath) Legacy() bool { return p.legacy }
+
+// isRoot reports whether p selects the document itself.
+func (p *JSONPath) isRoot() bool { return len(p.segments) == 0 }
+
+// ParseJSONPath parses a JSONPath or a legacy path.
+func ParseJSONPath(path string) (*JSONPath, error) {
+	p := &JSONPath{raw: path}
+	src := path
+	if !strings.HasPrefix(path, "$") {
+		p.legacy = true
+		switch {
+		case path == "" || path == ".":
+			src = "$"
+		case path[0] == '.' || path[0] == '[':
+			src = "$" + path
+		default:
+			src = "$." + path
+		}
+	}
+
+	pp := &jsonPathParser{s: src, pos: 1}
+	segments, err := pp.segments(false)
+	if err == nil && pp.pos < len(src) {
+		err = pp.errorf("unexpected %q", src[pp.pos])
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.segments = segments
+	return p, nil
+}
+
+// jsonPathParser is a recursive descent parser for paths and filters.
+type jsonPathParser struct {
+	s   string
+	pos int
+}
+
+func (pp *jsonPathParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s at position %d of %q", ErrJSONPathSyntax, fmt.Sprintf(format, args...), pp.pos, pp.s)
+}
+
+func (pp *jsonPathParser) peek() byte {
+	if pp.pos < len(pp.s) {
+		return pp.s[pp.pos]
+	}
+	return 0
+}
+
+func (pp *jsonPathParser) skipSpaces() {
+	for pp.pos < len(pp.s) && (pp.s[pp.pos] == ' ' || pp.s[pp.pos] == '\t') {
+		pp.pos++
+	}
+}
+
+// segments parses segments up to the first character that cannot start
+// one. In a filter, names also end at spaces and operators.
+func (pp *jsonPathParser) segments(inFilter bool) ([]jsonSegment, error) {
+	segments := []jsonSegment{}
+	for pp.pos < len(pp.s) {
+		var seg jsonSegment
+		switch {
+		case strings.HasPrefix(pp.s[pp.pos:], ".."):
+			seg.descendant = true
+			pp.pos += 2
+			if pp.peek() == '[' {
+				sels, err := pp.bracket()
+				if err != nil {
+					return nil, err
+				}
+				seg.selectors = sels
+			} else {
+				sel, err := pp.dotSelector(inFilter)
+				if err != nil {
+					return nil, err
+				}
+				seg.selectors = []jsonSelector{sel}
+			}
+		case pp.peek() == '.':
+			pp.pos++
+			sel, err := pp.dotSelector(inFilter)
+			if err != nil {
+				return nil, err
+			}
+			seg.selectors = []jsonSelector{sel}
+		case pp.peek() == '[':
+			sels, err := pp.bracket()
+			if err != nil {
+				return nil, err
+			}
+			seg.selectors = sels
+		default:
+			return segments, nil
+		}
+		segments = append(segments, seg)
+	}
+	return segments, nil
+}
+
+// dotSelector parses the wildcard or member name after a dot.
+func (pp *jsonPathParser) dotSelector(inFilter bool) (jsonSelector, error) {
+	if pp.peek() == '*' {
+		pp.pos++
+		return jsonSelector{kind: selWildcard}, nil
+	}
+	start := pp.pos
+	for pp.pos < len(pp.s) {
+		c := pp.s[pp.pos]
+		if c == '.' || c == '[' || c == ']' || (inFilter && strings.IndexByte(" \t)=!<>&|,", c) >= 0) {
+			break
+		}
+		pp.pos++
+	}
+	if pp.pos == start {
+		return jsonSelector{}, pp.errorf("expected a member name")
+	}
+	return jsonSelector{kind: selName, name: pp.s[start:pp.pos]}, nil
+}
+
+// bracket parses a bracketed list of selectors, or a filter.
+func (pp *jsonPathParser) bracket() ([]jsonSelector, error) {
+	pp.pos++ // '['
+	pp.skipSpaces()
+	if pp.peek() == '?' {
+		pp.pos++
+		pp.skipSpaces()
+		paren := pp.peek() == '('
+		if paren {
+			pp.pos++
+		}
+		x, err := pp.orExpr()
+		if err != nil {
+			return nil, err
+		}
+		pp.skipSpaces()
+		if paren {
+			if pp.peek() != ')' {
+				return nil, pp.errorf("expected ')'")
+			}
+			pp.pos++
+			pp.skipSpaces()
+		}
+		if pp.peek() != ']' {
+			return nil, pp.errorf("expected ']'")
+		}
+		pp.pos++
+		return []jsonSelector{{kind: selFilter, filter: x}}, nil
+	}
+
+	var sels []jsonSelector
+	for {
+		pp.skipSpaces()
+		var sel jsonSelector
+		switch c := pp.peek(); {
+		case c == '*':
+			pp.pos++
+			sel.kind = selWildcard
+		case c == '\'' || c == '"':
+			name, err := pp.str()
+			if err != nil {
+				return nil, err
+			}
+			sel = jsonSelector{kind: selName, name: name}
+		case c == '-' || c == ':' || (c >= '0' && c <= '9'):
+			var err error
+			if sel, err = pp.indexOrSlice(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, pp.errorf("expected a selector")
+		}
+		sels = append(sels, sel)
+
+		pp.skipSpaces()
+		switch pp.peek() {
+		case ',':
+			pp.pos++
+		case ']':
+			pp.pos++
+			return sels, nil
+		default:
+			return nil, pp.errorf("expected ',' or ']'")
+		}
+	}
+}
+
+// int parses an optionally negative integer.
+func (pp *jsonPathParser) int() (int, bool) {
+	start := pp.pos
+	if pp.peek() == '-' {
+		pp.pos++
+	}
+	for pp.pos < len(pp.s) && pp.s[pp.pos] >= '0' && pp.s[pp.pos] <= '9' {
+		pp.pos++
+	}
+	n, err := strconv.Atoi(pp.s[start:pp.pos])
+	if err != nil {
+		pp.pos = start
+		return 0, false
+	}
+	return n, true
+}
+
+// indexOrSlice parses an index or a start:end:step slice.
+func (pp *jsonPathParser) indexOrSlice() (jsonSelector, error) {
+	n, ok := pp.int()
+	if pp.peek() != ':' {
+		if !ok {
+			return jsonSelector{}, pp.errorf("expected an index")
+		}
+		return jsonSelector{kind: selIndex, index: n}, nil
+	}
+
+	sel := jsonSelector{kind: selSlice, start: n, hasStart: ok, step: 1}
+	pp.pos++ // ':'
+	pp.skipSpaces()
+	sel.end, sel.hasEnd = pp.int()
+	pp.skipSpaces()
+	if pp.peek() == ':' {
+		pp.pos++
+		pp.skipSpaces()
+		if step, ok := pp.int(); ok {
+			sel.step = step
+		}
+	}
+	return sel, nil
+}
+
+// str parses a quoted string with JSON escapes.
+func (pp *jsonPathParser) str() (string, error) {
+	q := pp.s[pp.pos]
+	var b strings.Builder
+	for i := pp.pos + 1; i < len(pp.s); i++ {
+		c := pp.s[i]
+		if c == q {
+			pp.pos = i + 1
+			return b.String(), nil
+		}
+		if c != '\\' || i+1 == len(pp.s) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch e := pp.s[i]; e {
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'u':
+			r, n := decodeJSONEscape(pp.s[i-1:])
+			if n == 0 {
+				pp.pos = i
+				return "", pp.errorf("invalid escape")
+			}
+			b.WriteRune(r)
+			i += n - 2
+		default:
+			b.WriteByte(e)
+		}
+	}
+	return "", pp.errorf("unterminated string")
+}
+
+// decodeJSONEscape decodes the \uXXXX escape, or surrogate pair, that s
+// starts with. It returns the rune and the length of the escape, or 0.
+func decodeJSONEscape(s string) (rune, int) {
+	hex := func(s string) (rune, bool) {
+		if len(s) < 6 || s[0] != '\\' || s[1] != 'u' {
+			return 0, false
+		}
+		n, err := strconv.ParseUint(s[2:6], 16, 16)
+		return rune(n), err == nil
+	}
+	r, ok := hex(s)
+	if !ok {
+		return 0, 0
+	}
+	if utf16.IsSurrogate(r) {
+		if r2, ok := hex(s[6:]); ok {
+			if dec := utf16.DecodeRune(r, r2); dec != unicode.ReplacementChar {
+				return dec, 12
+			}
+		}
+	}
+	return r, 6
+}
+
+// ─── Filter expressions ─────────────────────────────────────────────────────
+
+// jsonExpr is a node of a filter expression: a logical operator over subs,
+// a comparison of a with b, or with no op a test that a matches something.
+type jsonExpr struct {
+	op   string
+	subs []*jsonExpr
+	a, b jsonOperand
+}
+
+// jsonOperand is a literal, or a query relative to the current node (@) or
+// to the root ($), which yields its first match.
+type jsonOperand struct {
+	query    []jsonSegment
+	isQuery  bool
+	absolute bool
+	literal  any
+}
+
+func (pp *jsonPathParser) orExpr() (*jsonExpr, error) {
+	x, err := pp.andExpr()
+	for err == nil {
+		pp.skipSpaces()
+		if !strings.HasPrefix(pp.s[pp.pos:], "||") {
+			break
+		}
+		pp.pos += 2
+		var y *jsonExpr
+		if y, err = pp.andExpr(); err == nil {
+			x = &jsonExpr{op: "||", subs: []*jsonExpr{x, y}}
+		}
+	}
+	return x, err
+}
+
+func (pp *jsonPathParser) andExpr() (*jsonExpr, error) {
+	x, err := pp.unaryExpr()
+	for err == nil {
+		pp.skipSpaces()
+		if !strings.HasPrefix(pp.s[pp.pos:], "&&") {
+			break
+		}
+		pp.pos += 2
+		var y *jsonExpr
+		if y, err = pp.unaryExpr(); err == nil {
+			x = &jsonExpr{op: "&&", subs: []*jsonExpr{x, y}}
+		}
+	}
+	return x, err
+}
+
+func (pp *jsonPathParser) unaryExpr() (*jsonExpr, error) {
+	pp.skipSpaces()
+	switch {
+	case pp.peek() == '!' && !strings.HasPrefix(pp.s[pp.pos:], "!="):
+		pp.pos++
+		x, err := pp.unaryExpr()
+		if err != nil {
+			return nil, err
+		}
+		return &jsonExpr{op: "!", subs: []*jsonExpr{x}}, nil
+	case pp.peek() == '(':
+		pp.pos++
+		x, err := pp.orExpr()
+		if err != nil {
+			return nil, err
+		}
+		pp.skipSpaces()
+		if pp.peek() != ')' {
+			return nil, pp.errorf("expected ')'")
+		}
+		pp.pos++
+		return x, nil
+	}
+
+	a, err := pp.operand()
+	if err != nil {
+		return nil, err
+	}
+	pp.skipSpaces()
+	for _, op := range []string{"==", "!=", "<=", ">=", "=~", "<", ">"} {
+		if strings.HasPrefix(pp.s[pp.pos:], op) {
+			pp.pos += len(op)
+			b, err := pp.operand()
+			if err != nil {
+				return nil, err
+			}
+			return &jsonExpr{op: op, a: a, b: b}, nil
+		}
+	}
+	return &jsonExpr{a: a}, nil
+}
+
+func (pp *jsonPathParser) operand() (jsonOperand, error) {
+	pp.skipSpaces()
+	switch c := pp.peek(); {
+	case c == '@' || c == '$':
+		pp.pos++
+		query, err := pp.segments(true)
+		return jsonOperand{query: query, isQuery: true, absolute: c == '$'}, err
+	case c == '\'' || c == '"':
+		s, err := pp.str()
+		return jsonOperand{literal: s}, err
+	case c == '-' || (c >= '0' && c <= '9'):
+		start := pp.pos
+		for pp.pos < len(pp.s) && strings.IndexByte("+-.0123456789eE", pp.s[pp.pos]) >= 0 {
+			pp.pos++
+		}
+		n, ok := parseJSONNumber(pp.s[start:pp.pos])
+		if !ok {
+			pp.pos = start
+			return jsonOperand{}, pp.errorf("invalid number")
+		}
+		return jsonOperand{literal: n}, nil
+	}
+	for word, v := range map[string]any{"true": true, "false": false, "null": nil} {
+		if strings.HasPrefix(pp.s[pp.pos:], word) {
+			pp.pos += len(word)
+			return jsonOperand{literal: v}, nil
+		}
+	}
+	return jsonOperand{}, pp.errorf("expected a value")
+}
+
+// value returns the operand's value at the current node cur.
+func (o jsonOperand) value(root, cur any) (any, bool) {
+	if !o.isQuery {
+		return o.literal, true
+	}
+	start := cur
+	if o.absolute {
+		start = root
+	}
+	nodes := evalJSONPath(o.query, root, start)
+	if len(nodes) == 0 {
+		return nil, false
+	}
+	return nodes[0].value, true
+}
+
+// eval reports whether the filter holds at the current node cur.
+func (x *jsonExpr) eval(root, cur any) bool {
+	switch x.op {
+	case "||":
+		return x.subs[0].eval(root, cur) || x.subs[1].eval(root, cur)
+	case "&&":
+		return x.subs[0].eval(root, cur) && x.subs[1].eval(root, cur)
+	case "!":
+		return !x.subs[0].eval(root, cur)
+	case "":
+		v, ok := x.a.value(root, cur)
+		if !x.a.isQuery {
+			return ok && v != nil && v != false
+		}
+		return ok
+	}
+	a, aok := x.a.value(root, cur)
+	b, bok := x.b.value(root, cur)
+	return jsonCompare(x.op, a, aok, b, bok)
+}
+
+// jsonCompare compares two operands; a missing operand only equals another
+// missing one.
+func jsonCompare(op string, a any, aok bool, b any, bok bool) bool {
+	if !aok || !bok {
+		switch op {
+		case "==", "<=", ">=":
+			return aok == bok
+		case "!=":
+			return aok != bok
+		}
+		return false
+	}
+	switch op {
+	case "==":
+		return jsonEqual(a, b)
+	case "!=":
+		return !jsonEqual(a, b)
+	case "=~":
+		s, ok1 := a.(string)
+		pattern, ok2 := b.(string)
+		if !ok1 || !ok2 {
+			return false
+		}
+		re, err := regexp.Compile(pattern)
+		return err == nil && re.MatchString(s)
+	}
+
+	c, ok := jsonOrder(a, b)
+	if !ok {
+		return (op == "<=" || op == ">=") && jsonEqual(a, b)
+	}
+	switch op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+// jsonOrder compares two numbers or two strings.
+func jsonOrder(a, b any) (int, bool) {
+	if x, ok := jsonFloat(a); ok {
+		y, ok := jsonFloat(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	x, ok1 := a.(string)
+	y, ok2 := b.(string)
+	if !ok1 || !ok2 {
+		return 0, false
+	}
+	return strings.Compare(x, y), true
+}
+
+// ─── Evaluation ─────────────────────────────────────────────────────────────
+
+// jsonStep is one step of a concrete location: a member name, or with
+// index >= 0 an array index.
+type jsonStep struct {
+	name  string
+	index int
+}
+
+// jsonNode is a value matched by a path, with its location.
+type jsonNode struct {
+	path  []jsonStep
+	value any
+}
+
+// child returns the node of a child of n.
+func (n jsonNode) child(step jsonStep, value any) jsonNode {
+	return jsonNode{path: append(n.path[:len(n.path):len(n.path)], step), value: value}
+}
+
+// evalJSONPath returns the nodes segments select starting from start, in
+// document order.
+func evalJSONPath(segments []jsonSegment, root, start any) []jsonNode {
+	nodes := []jsonNode{{value: start}}
+	for _, seg := range segments {
+		var next []jsonNode
+		for _, n := range nodes {
+			if seg.descendant {
+				walkJSON(n, func(d jsonNode) { next = seg.apply(next, d, root) })
+			} else {
+				next = seg.apply(next, n, root)
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// walkJSON calls fn for n and each of its descendants, parents first.
+func walkJSON(n jsonNode, fn func(jsonNode)) {
+	fn(n)
+	switch v := n.value.(type) {
+	case *jsonObject:
+		for _, k := range v.keys {
+			walkJSON(n.child(jsonStep{name: k, index: -1}, v.values[k]), fn)
+		}
+	case []any:
+		for i, e := range v {
+			walkJSON(n.child(jsonStep{index: i}, e), fn)
+		}
+	}
+}
+
+// apply appends the children of n the segment selects to out.
+func (seg jsonSegment) apply(out []jsonNode, n jsonNode, root any) []jsonNode {
+	obj, _ := n.value.(*jsonObject)
+	arr, isArr := n.value.([]any)
+	for _, sel := range seg.selectors {
+		switch sel.kind {
+		case selName:
+			if obj != nil {
+				if v, ok := obj.values[sel.name]; ok {
+					out = append(out, n.child(jsonStep{name: sel.name, index: -1}, v))
+				}
+			}
+		case selIndex:
+			i := sel.index
+			if i < 0 {
+				i += len(arr)
+			}
+			if isArr && i >= 0 && i < len(arr) {
+				out = append(out, n.child(jsonStep{index: i}, arr[i]))
+			}
+		case selWildcard, selFilter:
+			keep := func(v any) bool { return sel.kind == selWildcard || sel.filter.eval(root, v) }
+			if obj != nil {
+				for _, k := range obj.keys {
+					if v := obj.values[k]; keep(v) {
+						out = append(out, n.child(jsonStep{name: k, index: -1}, v))
+					}
+				}
+			}
+			for i, v := range arr {
+				if keep(v) {
+					out = append(out, n.child(jsonStep{index: i}, v))
+				}
+			}
+		case selSlice:
+			for _, i := range sel.sliceIndexes(len(arr)) {
+				out = append(out, n.child(jsonStep{index: i}, arr[i]))
+			}
+		}
+	}
+	return out
+}
+
+// sliceIndexes returns the indexes a slice selects in an array of length n.
+func (sel jsonSelector) sliceIndexes(n int) []int {
+	if sel.step == 0 || n == 0 {
+		return nil
+	}
+	norm := func(i int) int {
+		if i < 0 {
+			return i + n
+		}
+		return i
+	}
+	var idx []int
+	if sel.step > 0 {
+		lo, hi := 0, n
+		if sel.hasStart {
+			lo = min(max(norm(sel.start), 0), n)
+		}
+		if sel.hasEnd {
+			hi = min(max(norm(sel.end), 0), n)
+		}
+		for i := lo; i < hi; i += sel.step {
+			idx = append(idx, i)
+		}
+		return idx
+	}
+	hi, lo := n-1, -1
+	if sel.hasStart {
+		hi = min(max(norm(sel.start), -1), n-1)
+	}
+	if sel.hasEnd {
+		lo = min(max(norm(sel.end), -1), n-1)
+	}
+	for i := hi; i > lo; i += sel.step {
+		idx = append(idx, i)
+	}
+	return idx
+}
+
+// formatJSONPath returns the normalized path of a location, which selects
+// exactly that location.
+func formatJSONPath(path []jsonStep) string {
+	b := []byte{'$'}
+	for _, step := range path {
+		b = append(b, '[')
+		if step.index >= 0 {
+			b = strconv.AppendInt(b, int64(step.index), 10)
+		} else {
+			b = appendJSONString(b, step.name)
+		}
+		b = append(b, ']')
+	}
+	return string(b)
+}
+
+// parseJSONLocation parses a normalized path back into a location.
+func parseJSONLocation(path string) ([]jsonStep, error) {
+	p, err := ParseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+	steps := make([]jsonStep, len(p.segments))
+	for i, seg := range p.segments {
+		if seg.descendant || len(seg.selectors) != 1 {
+			return nil, fmt.Errorf("%w: %q is not a normalized path", ErrJSONPathSyntax, path)
+		}
+		switch sel := seg.selectors[0]; {
+		case sel.kind == selName:
+			steps[i] = jsonStep{name: sel.name, index: -1}
+		case sel.kind == selIndex && sel.index >= 0:
+			steps[i] = jsonStep{index: sel.index}
+		default:
+			return nil, fmt.Errorf("%w: %q is not a normalized path", ErrJSONPathSyntax, path)
+		}
+	}
+	return steps, nil
+}
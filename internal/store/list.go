@@ -1,23 +1,41 @@
 // Package store - List data type implementation for FlashDB
 //
-// A List is a doubly-linked list of byte values stored under a single key.
-// Equivalent to Redis Lists. Push/Pop operations are O(1).
-// Index-based operations are O(N) where N is the distance to the target.
+// A List is a sequence of byte values stored under a single key, equivalent
+// to Redis Lists. It is kept as a doubly-linked list of small chunks, like
+// Redis' quicklist: Push/Pop at either end are O(1) and the memory of popped
+// values is released right away. Index-based operations are O(N/C) to find
+// the chunk, where N is the distance to the target and C the chunk size.
 package store
 
 import "fmt"
 
-// List represents a Redis-like list data structure backed by a Go slice.
+// listChunkSize is the maximum number of values in one chunk.
+const listChunkSize = 128
+
+// List represents a Redis-like list data structure backed by a chunked deque.
 // The List itself is NOT thread-safe; concurrency is managed by the Store.
 type List struct {
-	items [][]byte
+	head   *listNode
+	tail   *listNode
+	length int
+
+	// For memory accounting: the number of chunks and the total length of
+	// the values.
+	nodes int
+	bytes int
+}
+
+// listNode is one chunk of at most listChunkSize values.
+type listNode struct {
+	prev  *listNode
+	next  *listNode
+	vals  [][]byte
+	bytes int // total length of vals
 }
 
 // NewList creates a new empty List.
 func NewList() *List {
-	return &List{
-		items: make([][]byte, 0),
-	}
+	return &List{}
 }
 
 // LPush prepends one or more values to the list.
@@ -25,77 +43,99 @@ func NewList() *List {
 // LPUSH mylist a b c will result in c b a (c is head).
 // Returns the new length of the list.
 func (l *List) LPush(values ...[]byte) int {
-	// Each value is prepended, so we reverse to match Redis behavior:
-	// LPUSH key a b c → c at head, then b at head, then a at head? No.
-	// Actually Redis LPUSH key a b c pushes a first, then b before a, then c before b.
-	// Result: c b a existing...
-	newItems := make([][]byte, len(values)+len(l.items))
-	for i, v := range values {
-		newItems[len(values)-1-i] = cloneBytes(v)
+	for _, v := range values {
+		if l.head == nil || len(l.head.vals) == listChunkSize {
+			l.linkBefore(l.head, &listNode{vals: make([][]byte, 0, 8)})
+		}
+		n := l.head
+		n.vals = append(n.vals, nil)
+		copy(n.vals[1:], n.vals)
+		n.vals[0] = cloneBytes(v)
+		l.grow(n, len(v))
+		l.length++
 	}
-	copy(newItems[len(values):], l.items)
-	l.items = newItems
-	return len(l.items)
+	return l.length
 }
 
 // RPush appends one or more values to the list.
 // Returns the new length of the list.
 func (l *List) RPush(values ...[]byte) int {
 	for _, v := range values {
-		l.items = append(l.items, cloneBytes(v))
+		if l.tail == nil || len(l.tail.vals) == listChunkSize {
+			l.linkAfter(l.tail, &listNode{vals: make([][]byte, 0, 8)})
+		}
+		l.tail.vals = append(l.tail.vals, cloneBytes(v))
+		l.grow(l.tail, len(v))
+		l.length++
 	}
-	return len(l.items)
+	return l.length
 }
 
 // LPop removes and returns the first element.
 func (l *List) LPop() ([]byte, bool) {
-	if len(l.items) == 0 {
+	if l.length == 0 {
 		return nil, false
 	}
-	val := l.items[0]
-	l.items = l.items[1:]
+	n := l.head
+	val := n.vals[0]
+	copy(n.vals, n.vals[1:])
+	n.vals[len(n.vals)-1] = nil
+	n.vals = n.vals[:len(n.vals)-1]
+	l.grow(n, -len(val))
+	l.length--
+	if len(n.vals) == 0 {
+		l.unlink(n)
+	}
 	return val, true
 }
 
 // RPop removes and returns the last element.
 func (l *List) RPop() ([]byte, bool) {
-	if len(l.items) == 0 {
+	if l.length == 0 {
 		return nil, false
 	}
-	val := l.items[len(l.items)-1]
-	l.items = l.items[:len(l.items)-1]
+	n := l.tail
+	val := n.vals[len(n.vals)-1]
+	n.vals[len(n.vals)-1] = nil
+	n.vals = n.vals[:len(n.vals)-1]
+	l.grow(n, -len(val))
+	l.length--
+	if len(n.vals) == 0 {
+		l.unlink(n)
+	}
 	return val, true
 }
 
 // Len returns the number of elements in the list.
 func (l *List) Len() int {
-	return len(l.items)
+	return l.length
 }
 
 // Index returns the element at the given index.
 // Negative indices count from the end (-1 is the last element).
 func (l *List) Index(index int) ([]byte, bool) {
-	idx := l.resolveIndex(index)
-	if idx < 0 || idx >= len(l.items) {
+	n, i := l.locate(l.resolveIndex(index))
+	if n == nil {
 		return nil, false
 	}
-	return cloneBytes(l.items[idx]), true
+	return cloneBytes(n.vals[i]), true
 }
 
 // Set sets the element at index to value.
 // Returns an error if the index is out of range.
 func (l *List) Set(index int, value []byte) error {
-	idx := l.resolveIndex(index)
-	if idx < 0 || idx >= len(l.items) {
+	n, i := l.locate(l.resolveIndex(index))
+	if n == nil {
 		return fmt.Errorf("index out of range")
 	}
-	l.items[idx] = cloneBytes(value)
+	l.grow(n, len(value)-len(n.vals[i]))
+	n.vals[i] = cloneBytes(value)
 	return nil
 }
 
 // Range returns elements from start to stop (inclusive), supporting negative indices.
 func (l *List) Range(start, stop int) [][]byte {
-	length := len(l.items)
+	length := l.length
 	if length == 0 {
 		return nil
 	}
@@ -115,9 +155,12 @@ func (l *List) Range(start, stop int) [][]byte {
 		return nil
 	}
 
-	result := make([][]byte, e-s+1)
-	for i := s; i <= e; i++ {
-		result[i-s] = cloneBytes(l.items[i])
+	result := make([][]byte, 0, e-s+1)
+	n, i := l.locate(s)
+	for ; n != nil && len(result) < cap(result); n, i = n.next, 0 {
+		for ; i < len(n.vals) && len(result) < cap(result); i++ {
+			result = append(result, cloneBytes(n.vals[i]))
+		}
 	}
 	return result
 }
@@ -125,21 +168,20 @@ func (l *List) Range(start, stop int) [][]byte {
 // Insert inserts value before or after the pivot element.
 // Returns the new length, or -1 if pivot not found, 0 if list is empty.
 func (l *List) Insert(before bool, pivot, value []byte) int {
-	if len(l.items) == 0 {
+	if l.length == 0 {
 		return 0
 	}
 
-	for i, item := range l.items {
-		if bytesEqual(item, pivot) {
-			pos := i
-			if !before {
-				pos = i + 1
+	for n := l.head; n != nil; n = n.next {
+		for i, item := range n.vals {
+			if bytesEqual(item, pivot) {
+				pos := i
+				if !before {
+					pos = i + 1
+				}
+				l.insertAt(n, pos, cloneBytes(value))
+				return l.length
 			}
-			// Insert at position
-			l.items = append(l.items, nil)
-			copy(l.items[pos+1:], l.items[pos:])
-			l.items[pos] = cloneBytes(value)
-			return len(l.items)
 		}
 	}
 	return -1
@@ -152,45 +194,58 @@ func (l *List) Insert(before bool, pivot, value []byte) int {
 //
 // Returns the number of removed elements.
 func (l *List) Rem(count int, value []byte) int {
-	if len(l.items) == 0 {
+	if l.length == 0 {
 		return 0
 	}
 
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
 	removed := 0
-	absCount := count
-	if absCount < 0 {
-		absCount = -absCount
+	match := func(item []byte) bool {
+		if (limit == 0 || removed < limit) && bytesEqual(item, value) {
+			removed++
+			return true
+		}
+		return false
 	}
 
 	if count >= 0 {
 		// Remove from head to tail
-		newItems := make([][]byte, 0, len(l.items))
-		for _, item := range l.items {
-			if bytesEqual(item, value) && (count == 0 || removed < absCount) {
-				removed++
-				continue
+		for n := l.head; n != nil; {
+			next := n.next
+			kept := n.vals[:0]
+			for _, item := range n.vals {
+				if !match(item) {
+					kept = append(kept, item)
+				}
 			}
-			newItems = append(newItems, item)
+			l.shrink(n, kept)
+			n = next
 		}
-		l.items = newItems
 	} else {
 		// Remove from tail to head
-		newItems := make([][]byte, 0, len(l.items))
-		for i := len(l.items) - 1; i >= 0; i-- {
-			if bytesEqual(l.items[i], value) && removed < absCount {
-				removed++
-				continue
+		for n := l.tail; n != nil; {
+			prev := n.prev
+			w := len(n.vals)
+			for i := len(n.vals) - 1; i >= 0; i-- {
+				if !match(n.vals[i]) {
+					w--
+					n.vals[w] = n.vals[i]
+				}
 			}
-			newItems = append([][]byte{l.items[i]}, newItems...)
+			kept := n.vals[:copy(n.vals, n.vals[w:])]
+			l.shrink(n, kept)
+			n = prev
 		}
-		l.items = newItems
 	}
 	return removed
 }
 
 // Trim trims the list to only contain elements between start and stop (inclusive).
 func (l *List) Trim(start, stop int) {
-	length := len(l.items)
+	length := l.length
 	if length == 0 {
 		return
 	}
@@ -205,21 +260,193 @@ func (l *List) Trim(start, stop int) {
 		e = length - 1
 	}
 	if s > e || s >= length {
-		l.items = l.items[:0]
+		*l = List{}
 		return
 	}
 
-	l.items = l.items[s : e+1]
+	l.dropTail(length - 1 - e)
+	l.dropHead(s)
+}
+
+// dropHead removes the first k elements, whole chunks at a time.
+func (l *List) dropHead(k int) {
+	for k > 0 && l.head != nil {
+		n := l.head
+		if k >= len(n.vals) {
+			k -= len(n.vals)
+			l.length -= len(n.vals)
+			l.unlink(n)
+			continue
+		}
+		l.shrink(n, n.vals[:copy(n.vals, n.vals[k:])])
+		k = 0
+	}
+}
+
+// dropTail removes the last k elements, whole chunks at a time.
+func (l *List) dropTail(k int) {
+	for k > 0 && l.tail != nil {
+		n := l.tail
+		if k >= len(n.vals) {
+			k -= len(n.vals)
+			l.length -= len(n.vals)
+			l.unlink(n)
+			continue
+		}
+		l.shrink(n, n.vals[:len(n.vals)-k])
+		k = 0
+	}
+}
+
+// clone returns a deep copy of the list.
+func (l *List) clone() *List {
+	c := NewList()
+	for n := l.head; n != nil; n = n.next {
+		vals := make([][]byte, len(n.vals), cap(n.vals))
+		for i, v := range n.vals {
+			vals[i] = cloneBytes(v)
+		}
+		c.linkAfter(c.tail, &listNode{vals: vals, bytes: n.bytes})
+	}
+	c.length = l.length
+	return c
 }
 
 // resolveIndex converts a possibly-negative index to a non-negative one.
 func (l *List) resolveIndex(index int) int {
 	if index < 0 {
-		return len(l.items) + index
+		return l.length + index
 	}
 	return index
 }
 
+// locate returns the chunk holding the element at a non-negative index and
+// the element's position in it, walking from the nearer end.
+func (l *List) locate(index int) (*listNode, int) {
+	if index < 0 || index >= l.length {
+		return nil, 0
+	}
+	if index < l.length/2 {
+		for n := l.head; n != nil; n = n.next {
+			if index < len(n.vals) {
+				return n, index
+			}
+			index -= len(n.vals)
+		}
+		return nil, 0
+	}
+	index = l.length - 1 - index
+	for n := l.tail; n != nil; n = n.prev {
+		if index < len(n.vals) {
+			return n, len(n.vals) - 1 - index
+		}
+		index -= len(n.vals)
+	}
+	return nil, 0
+}
+
+// insertAt inserts value at position pos of chunk n, splitting n if full.
+func (l *List) insertAt(n *listNode, pos int, value []byte) {
+	if len(n.vals) == listChunkSize {
+		half := listChunkSize / 2
+		right := &listNode{vals: make([][]byte, listChunkSize-half, listChunkSize)}
+		copy(right.vals, n.vals[half:])
+		clear(n.vals[half:])
+		n.vals = n.vals[:half]
+		right.bytes = valsLen(right.vals)
+		l.grow(n, -right.bytes)
+		l.linkAfter(n, right)
+		if pos > half {
+			n, pos = right, pos-half
+		}
+	}
+	n.vals = append(n.vals, nil)
+	copy(n.vals[pos+1:], n.vals[pos:])
+	n.vals[pos] = value
+	l.grow(n, len(value))
+	l.length++
+}
+
+// shrink replaces the values of chunk n with kept, a prefix-aligned subset
+// of them, releasing the dropped values and the chunk if it is now empty.
+func (l *List) shrink(n *listNode, kept [][]byte) {
+	l.length -= len(n.vals) - len(kept)
+	clear(n.vals[len(kept):])
+	n.vals = kept
+	l.grow(n, valsLen(kept)-n.bytes)
+	if len(n.vals) == 0 {
+		l.unlink(n)
+	}
+}
+
+// grow accounts for the values of chunk n growing by d bytes.
+func (l *List) grow(n *listNode, d int) {
+	n.bytes += d
+	l.bytes += d
+}
+
+// linkBefore links n in front of at, or as the only chunk if at is nil.
+func (l *List) linkBefore(at, n *listNode) {
+	l.nodes++
+	l.bytes += n.bytes
+	if at == nil {
+		l.head, l.tail = n, n
+		return
+	}
+	n.next = at
+	n.prev = at.prev
+	if at.prev != nil {
+		at.prev.next = n
+	} else {
+		l.head = n
+	}
+	at.prev = n
+}
+
+// linkAfter links n behind at, or as the only chunk if at is nil.
+func (l *List) linkAfter(at, n *listNode) {
+	l.nodes++
+	l.bytes += n.bytes
+	if at == nil {
+		l.head, l.tail = n, n
+		return
+	}
+	n.prev = at
+	n.next = at.next
+	if at.next != nil {
+		at.next.prev = n
+	} else {
+		l.tail = n
+	}
+	at.next = n
+}
+
+// unlink removes chunk n from the list.
+func (l *List) unlink(n *listNode) {
+	l.nodes--
+	l.bytes -= n.bytes
+	if n.prev != nil {
+		n.prev.next = n.next
+	} else {
+		l.head = n.next
+	}
+	if n.next != nil {
+		n.next.prev = n.prev
+	} else {
+		l.tail = n.prev
+	}
+	n.prev, n.next = nil, nil
+}
+
+// valsLen returns the total length of vals.
+func valsLen(vals [][]byte) int {
+	n := 0
+	for _, v := range vals {
+		n += len(v)
+	}
+	return n
+}
+
 // Helper: deep clone bytes
 func cloneBytes(b []byte) []byte {
 	if b == nil {
@@ -1,6 +1,8 @@
 package store
 
 import (
+	"fmt"
+	"math/rand"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -181,3 +183,152 @@ func TestList_LPush_Order(t *testing.T) {
 	assert.Equal(t, []byte("b"), items[1])
 	assert.Equal(t, []byte("a"), items[2])
 }
+
+// checkList verifies l against the reference slice and its chunk invariants.
+func checkList(t *testing.T, l *List, want [][]byte) {
+	t.Helper()
+	assert.Equal(t, len(want), l.Len())
+	if len(want) == 0 {
+		assert.Nil(t, l.Range(0, -1))
+	} else {
+		assert.Equal(t, want, l.Range(0, -1))
+	}
+	count := 0
+	for n := l.head; n != nil; n = n.next {
+		assert.NotEmpty(t, n.vals, "empty chunk")
+		assert.LessOrEqual(t, len(n.vals), listChunkSize)
+		if n.next == nil {
+			assert.Same(t, l.tail, n)
+		} else {
+			assert.Same(t, n, n.next.prev)
+		}
+		count += len(n.vals)
+	}
+	assert.Equal(t, len(want), count)
+}
+
+func TestList_MatchesModel(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	l := NewList()
+	var want [][]byte
+
+	for step := 0; step < 20000; step++ {
+		v := []byte(fmt.Sprintf("v%d", rng.Intn(50)))
+		switch op := rng.Intn(10); op {
+		case 0, 1:
+			l.LPush(v)
+			want = append([][]byte{v}, want...)
+		case 2, 3:
+			l.RPush(v)
+			want = append(want, v)
+		case 4:
+			got, ok := l.LPop()
+			assert.Equal(t, len(want) > 0, ok)
+			if ok {
+				assert.Equal(t, want[0], got)
+				want = want[1:]
+			}
+		case 5:
+			got, ok := l.RPop()
+			assert.Equal(t, len(want) > 0, ok)
+			if ok {
+				assert.Equal(t, want[len(want)-1], got)
+				want = want[:len(want)-1]
+			}
+		case 6:
+			if len(want) > 0 {
+				i := rng.Intn(len(want))
+				got, ok := l.Index(i)
+				assert.True(t, ok)
+				assert.Equal(t, want[i], got)
+				assert.NoError(t, l.Set(i-len(want), v))
+				want[i] = v
+			}
+		case 7:
+			pivot := []byte(fmt.Sprintf("v%d", rng.Intn(50)))
+			before := rng.Intn(2) == 0
+			n := l.Insert(before, pivot, v)
+			pos := -1
+			for i, w := range want {
+				if string(w) == string(pivot) {
+					pos = i
+					break
+				}
+			}
+			if pos < 0 {
+				assert.Equal(t, map[bool]int{true: 0, false: -1}[len(want) == 0], n)
+				break
+			}
+			if !before {
+				pos++
+			}
+			want = append(want[:pos], append([][]byte{v}, want[pos:]...)...)
+			assert.Equal(t, len(want), n)
+		case 8:
+			count := rng.Intn(5) - 2
+			removed := l.Rem(count, v)
+			var kept [][]byte
+			n := 0
+			if count >= 0 {
+				for _, w := range want {
+					if string(w) == string(v) && (count == 0 || n < count) {
+						n++
+						continue
+					}
+					kept = append(kept, w)
+				}
+			} else {
+				for i := len(want) - 1; i >= 0; i-- {
+					if string(want[i]) == string(v) && n < -count {
+						n++
+						continue
+					}
+					kept = append([][]byte{want[i]}, kept...)
+				}
+			}
+			assert.Equal(t, n, removed)
+			want = kept
+		case 9:
+			if rng.Intn(20) == 0 {
+				start, stop := rng.Intn(200)-20, rng.Intn(2000)-100
+				l.Trim(start, stop)
+				s, e := start, stop
+				if s < 0 {
+					s += len(want)
+				}
+				if e < 0 {
+					e += len(want)
+				}
+				if s < 0 {
+					s = 0
+				}
+				if e >= len(want) {
+					e = len(want) - 1
+				}
+				if s > e {
+					want = nil
+				} else {
+					want = want[s : e+1]
+				}
+			}
+		}
+		if step%100 == 0 {
+			checkList(t, l, want)
+		}
+	}
+	checkList(t, l, want)
+	c := l.clone()
+	checkList(t, c, want)
+}
+
+func TestList_PopReleasesChunks(t *testing.T) {
+	l := NewList()
+	for i := 0; i < 10*listChunkSize; i++ {
+		l.RPush([]byte("x"))
+	}
+	for i := 0; i < 10*listChunkSize-1; i++ {
+		l.LPop()
+	}
+	assert.Same(t, l.head, l.tail)
+	assert.Equal(t, 1, l.Len())
+}
@@ -0,0 +1,157 @@
+// Package store - memory accounting for FlashDB
+//
+// Every object records the number of bytes it is accounted for in
+// Store.used. Writers touch a key before they change, replace or remove
+// its object, which notes the size the key is accounted for; settling then
+// measures the keys noted since the last settle and adjusts the total.
+// Containers keep running totals of their payload, so measuring a key is
+// O(1) for every type but JSON, whose document is walked.
+//
+// Sizes estimate what the Go runtime allocates on a 64-bit platform: the
+// payload plus fixed overheads for headers, map entries and nodes. They do
+// not include allocator slack or memory the runtime has yet to give back.
+package store
+
+// Fixed overheads, in bytes.
+const (
+	// keyOverhead covers a keyspace map entry and the object struct.
+	keyOverhead = 176
+
+	containerOverhead = 48 // a container struct and its map or list header
+	sliceHeader       = 24
+	stringHeader      = 16
+	interfaceHeader   = 16
+
+	hashFieldOverhead   = 56  // map entry with the field and value headers
+	setMemberOverhead   = 32  // map entry with the member header
+	zsetMemberOverhead  = 112 // map entry and skip list node
+	listChunkOverhead   = 64  // chunk struct and its slice header
+	listValueOverhead   = sliceHeader
+	streamEntryOverhead = 40 // StreamEntry: ID and fields header
+	streamGroupOverhead = 128
+	streamPELOverhead   = 96 // PendingEntry, its map entry and order slot
+	streamConsOverhead  = 64
+	jsonMemberOverhead  = 56 // key in the order slice and the map entry
+)
+
+// memoryUsage returns the bytes taken by key and obj.
+func memoryUsage(key string, obj *object) int64 {
+	n := keyOverhead + len(key)
+	switch obj.typ {
+	case TypeString:
+		n += cap(obj.str)
+	case TypeHash:
+		n += containerOverhead + obj.hash.Len()*hashFieldOverhead + obj.hash.bytes
+	case TypeList:
+		l := obj.list
+		n += containerOverhead + l.nodes*listChunkOverhead + l.length*listValueOverhead + l.bytes
+	case TypeSet:
+		n += containerOverhead + obj.set.Card()*setMemberOverhead + obj.set.bytes
+	case TypeZSet:
+		n += containerOverhead + obj.zset.Card()*zsetMemberOverhead + obj.zset.bytes
+	case TypeStream:
+		n += obj.stream.memoryUsage()
+	case TypeJSON:
+		n += interfaceHeader + jsonSize(obj.json.root)
+	}
+	return int64(n)
+}
+
+// entrySize returns the memory of the fields of a stream entry.
+func entrySize(fields [][]byte) int {
+	return len(fields)*sliceHeader + valsLen(fields)
+}
+
+// memoryUsage returns the memory of the stream. Consumer groups are
+// counted one by one; there are few of them.
+func (s *Stream) memoryUsage() int {
+	n := containerOverhead + len(s.entries)*streamEntryOverhead + s.bytes
+	for name, g := range s.groups {
+		n += streamGroupOverhead + len(name) + len(g.pending)*streamPELOverhead
+		for cname := range g.consumers {
+			n += streamConsOverhead + len(cname)
+		}
+	}
+	return n
+}
+
+// jsonSize returns the memory of a JSON value, apart from the interface
+// holding it.
+func jsonSize(v any) int {
+	switch v := v.(type) {
+	case *jsonObject:
+		n := containerOverhead
+		for key, e := range v.values {
+			n += jsonMemberOverhead + len(key) + interfaceHeader + jsonSize(e)
+		}
+		return n
+	case []any:
+		n := sliceHeader
+		for _, e := range v {
+			n += interfaceHeader + jsonSize(e)
+		}
+		return n
+	case string:
+		return stringHeader + len(v)
+	case int64, float64:
+		return 8
+	}
+	return 0
+}
+
+// unsize notes that key must be measured at the next settle (must hold
+// write lock).
+func (s *Store) unsize(key string) {
+	if _, ok := s.unsized[key]; ok {
+		return
+	}
+	var size int64
+	if obj, ok := s.data[key]; ok {
+		size = obj.size
+	}
+	s.unsized[key] = size
+}
+
+// settle measures the keys changed since the last settle (must hold write
+// lock).
+func (s *Store) settle() {
+	for key, old := range s.unsized {
+		var size int64
+		if obj, ok := s.data[key]; ok {
+			size = memoryUsage(key, obj)
+			obj.size = size
+		}
+		s.used += size - old
+	}
+	clear(s.unsized)
+}
+
+// settleMemory settles the accounting, so the set of changed keys does not
+// grow without bound when nobody asks for the memory used.
+func (s *Store) settleMemory() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settle()
+}
+
+// MemoryUsage returns the bytes used by all keys, expired ones included
+// until they are removed.
+func (s *Store) MemoryUsage() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settle()
+	return s.used
+}
+
+// KeyMemoryUsage returns the bytes used by key and its value, or false if
+// the key does not exist.
+func (s *Store) KeyMemoryUsage(key string) (int64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, ok := s.data[key]
+	if !ok || s.isExpired(obj) {
+		return 0, false
+	}
+	return memoryUsage(key, obj), true
+}
@@ -0,0 +1,209 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checkMemory verifies the running totals of every container against a
+// count from scratch, and the store's total against the keys' sizes.
+func checkMemory(t *testing.T, s *Store) {
+	t.Helper()
+	used := s.MemoryUsage()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var sum int64
+	for key, obj := range s.data {
+		sum += memoryUsage(key, obj)
+		switch obj.typ {
+		case TypeHash:
+			n := 0
+			for f, v := range obj.hash.fields {
+				n += len(f) + len(v)
+			}
+			assert.Equal(t, n, obj.hash.bytes, "hash %s", key)
+		case TypeSet:
+			n := 0
+			for m := range obj.set.members {
+				n += len(m)
+			}
+			assert.Equal(t, n, obj.set.bytes, "set %s", key)
+		case TypeZSet:
+			n := 0
+			for m := range obj.zset.members {
+				n += len(m)
+			}
+			assert.Equal(t, n, obj.zset.bytes, "zset %s", key)
+		case TypeList:
+			nodes, n := 0, 0
+			for c := obj.list.head; c != nil; c = c.next {
+				assert.Equal(t, valsLen(c.vals), c.bytes, "list %s chunk", key)
+				nodes++
+				n += valsLen(c.vals)
+			}
+			assert.Equal(t, nodes, obj.list.nodes, "list %s", key)
+			assert.Equal(t, n, obj.list.bytes, "list %s", key)
+		case TypeStream:
+			n := 0
+			for _, e := range obj.stream.entries {
+				n += entrySize(e.Fields)
+			}
+			assert.Equal(t, n, obj.stream.bytes, "stream %s", key)
+		}
+	}
+	assert.Equal(t, sum, used)
+}
+
+func TestStore_MemoryAccounting(t *testing.T) {
+	s := New()
+	defer s.Close()
+	assert.Equal(t, int64(0), s.MemoryUsage())
+
+	s.Set("str", []byte("hello"))
+	_, err := s.Append("str", []byte(" world"))
+	require.NoError(t, err)
+	_, err = s.IncrBy("counter", 41)
+	require.NoError(t, err)
+	size, ok := s.KeyMemoryUsage("str")
+	require.True(t, ok)
+	assert.Greater(t, size, int64(len("str")+len("hello world")))
+	checkMemory(t, s)
+
+	_, err = s.HSet("hash", HashFieldValue{Field: "a", Value: []byte("1")}, HashFieldValue{Field: "bb", Value: []byte("22")})
+	require.NoError(t, err)
+	_, err = s.HSet("hash", HashFieldValue{Field: "a", Value: []byte("longer")})
+	require.NoError(t, err)
+	_, err = s.HIncrBy("hash", "n", 100)
+	require.NoError(t, err)
+	_, err = s.HDel("hash", "bb")
+	require.NoError(t, err)
+
+	_, err = s.SAdd("set", "x", "yy", "zzz")
+	require.NoError(t, err)
+	_, err = s.SRem("set", "yy")
+	require.NoError(t, err)
+	_, err = s.SPop("set", 1)
+	require.NoError(t, err)
+
+	_, err = s.ZAdd("zset", ScoredMember{Member: "a", Score: 1}, ScoredMember{Member: "bb", Score: 2}, ScoredMember{Member: "ccc", Score: 3})
+	require.NoError(t, err)
+	_, err = s.ZIncrBy("zset", "a", 5)
+	require.NoError(t, err)
+	_, err = s.ZPopMin("zset", 1)
+	require.NoError(t, err)
+	_, err = s.ZRemRangeByScore("zset", 5, 6)
+	require.NoError(t, err)
+	checkMemory(t, s)
+
+	for i := 0; i < 300; i++ {
+		_, err = s.RPush("list", []byte(fmt.Sprintf("v%d", i)))
+		require.NoError(t, err)
+		_, err = s.LPush("list", []byte("head"))
+		require.NoError(t, err)
+	}
+	_, err = s.LInsert("list", true, []byte("v150"), []byte("pivot"))
+	require.NoError(t, err)
+	require.NoError(t, s.LSet("list", 3, []byte("replaced")))
+	_, err = s.LRem("list", 0, []byte("head"))
+	require.NoError(t, err)
+	_, _, err = s.LPop("list")
+	require.NoError(t, err)
+	_, _, err = s.RPop("list")
+	require.NoError(t, err)
+	require.NoError(t, s.LTrim("list", 10, -10))
+	checkMemory(t, s)
+
+	for i := 1; i <= 10; i++ {
+		require.NoError(t, s.XAdd("stream", StreamID{Ms: uint64(i)}, [][]byte{[]byte("f"), []byte("value")}))
+	}
+	require.NoError(t, s.XGroupCreate("stream", "group", StreamID{}, false))
+	require.NoError(t, s.XDeliver("stream", "group", "alice", StreamID{Ms: 1}, 1, 1))
+	_, err = s.XDel("stream", StreamID{Ms: 5})
+	require.NoError(t, err)
+	_, err = s.XTrimMaxLen("stream", 4)
+	require.NoError(t, err)
+
+	require.NoError(t, s.JSONApply("doc", JSONEdit{Kind: JSONEditSet, Path: "$", Values: [][]byte{[]byte(`{"a":[1,"two",3.5],"b":{"c":true}}`)}}))
+	checkMemory(t, s)
+
+	// Keys that are renamed, copied, moved, expired or replaced keep the
+	// total right.
+	require.True(t, s.Rename("hash", "hash2"))
+	require.True(t, s.Copy("list", "list2", false))
+	other := New()
+	defer other.Close()
+	require.True(t, s.MoveTo("set", other))
+	checkMemory(t, other)
+	s.Set("zset", []byte("now a string"))
+	s.SetWithTTL("short", []byte("v"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	s.ExpireIfNeeded("short")
+	checkMemory(t, s)
+
+	// Changes made while a view is open go to copies.
+	v := s.OpenView()
+	_, err = s.RPush("list", []byte("more"))
+	require.NoError(t, err)
+	checkMemory(t, s)
+	v.Close()
+
+	for _, key := range s.Keys() {
+		s.Delete(key)
+	}
+	assert.Equal(t, int64(0), s.MemoryUsage())
+	other.Clear()
+	assert.Equal(t, int64(0), other.MemoryUsage())
+}
+
+func TestStore_SampleEviction(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	s.Set("cold", []byte("v"))
+	s.SetWithTTL("soon", []byte("v"), time.Minute)
+	s.SetWithTTL("later", []byte("v"), time.Hour)
+	time.Sleep(20 * time.Millisecond)
+	s.Set("new", []byte("v"))
+	s.Set("hot", []byte("v"))
+	for i := 0; i < 100; i++ {
+		s.Get("hot")
+	}
+
+	scores := func(policy EvictionPolicy) map[string]uint64 {
+		out := make(map[string]uint64)
+		for _, c := range s.SampleEviction(policy, 10) {
+			out[c.Key] = c.Score
+		}
+		return out
+	}
+
+	lru := scores(AllKeysLRU)
+	assert.Len(t, lru, 5)
+	assert.Greater(t, lru["cold"], lru["new"])
+
+	lfu := scores(AllKeysLFU)
+	assert.Greater(t, lfu["cold"], lfu["hot"])
+	_, freq, ok := s.AccessInfo("hot")
+	require.True(t, ok)
+	assert.Greater(t, freq, lfuInitVal)
+
+	ttl := scores(VolatileTTL)
+	assert.Len(t, ttl, 2)
+	assert.Greater(t, ttl["soon"], ttl["later"])
+	assert.Len(t, scores(VolatileRandom), 2)
+}
+
+func TestParseEvictionPolicy(t *testing.T) {
+	for _, name := range []string{"noeviction", "allkeys-lru", "allkeys-lfu", "allkeys-random", "volatile-lru", "volatile-lfu", "volatile-random", "volatile-ttl"} {
+		p, ok := ParseEvictionPolicy(name)
+		require.True(t, ok, name)
+		assert.Equal(t, name, p.String())
+	}
+	_, ok := ParseEvictionPolicy("lru")
+	assert.False(t, ok)
+}
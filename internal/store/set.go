@@ -11,6 +11,7 @@ import "math/rand"
 // The Set itself is NOT thread-safe; concurrency is managed by the Store.
 type Set struct {
 	members map[string]struct{}
+	bytes   int // total length of members, for memory accounting
 }
 
 // NewSet creates a new empty Set.
@@ -26,6 +27,7 @@ func (s *Set) Add(members ...string) int {
 	for _, m := range members {
 		if _, exists := s.members[m]; !exists {
 			s.members[m] = struct{}{}
+			s.bytes += len(m)
 			added++
 		}
 	}
@@ -38,6 +40,7 @@ func (s *Set) Rem(members ...string) int {
 	for _, m := range members {
 		if _, exists := s.members[m]; exists {
 			delete(s.members, m)
+			s.bytes -= len(m)
 			removed++
 		}
 	}
@@ -115,6 +118,7 @@ func (s *Set) Pop(count int) []string {
 	popped := members[:count]
 	for _, m := range popped {
 		delete(s.members, m)
+		s.bytes -= len(m)
 	}
 	return popped
 }
@@ -175,3 +179,12 @@ func (s *Set) Diff(others ...*Set) []string {
 	}
 	return result
 }
+
+// clone returns a deep copy of the set.
+func (s *Set) clone() *Set {
+	c := &Set{members: make(map[string]struct{}, len(s.members)), bytes: s.bytes}
+	for m := range s.members {
+		c.members[m] = struct{}{}
+	}
+	return c
+}
@@ -0,0 +1,225 @@
+package store
+
+import "math/rand/v2"
+
+const (
+	skipListMaxLevel = 32
+	skipListP        = 0.25
+)
+
+// skipList orders sorted set members by (score, member). Every link records
+// its span, the number of nodes it skips, so ranks are found on the way down
+// and both rank and score lookups take O(log n).
+type skipList struct {
+	header *skipListNode
+	tail   *skipListNode
+	length int
+	level  int
+}
+
+type skipListNode struct {
+	member   string
+	score    float64
+	backward *skipListNode
+	level    []skipListLevel
+}
+
+type skipListLevel struct {
+	forward *skipListNode
+	span    int
+}
+
+func newSkipList() *skipList {
+	return &skipList{
+		header: &skipListNode{level: make([]skipListLevel, skipListMaxLevel)},
+		level:  1,
+	}
+}
+
+func randomSkipListLevel() int {
+	level := 1
+	for level < skipListMaxLevel && rand.Float64() < skipListP {
+		level++
+	}
+	return level
+}
+
+// less reports whether n sorts before (score, member).
+func (n *skipListNode) less(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// insert adds a member that is not in the list yet.
+func (sl *skipList) insert(score float64, member string) {
+	var update [skipListMaxLevel]*skipListNode
+	var rank [skipListMaxLevel]int
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.less(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomSkipListLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = sl.header
+			update[i].level[i].span = sl.length
+		}
+		sl.level = level
+	}
+
+	x = &skipListNode{member: member, score: score, level: make([]skipListLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < sl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != sl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		sl.tail = x
+	}
+	sl.length++
+}
+
+// unlink removes x given the rightmost node before it on every level.
+func (sl *skipList) unlink(x *skipListNode, update *[skipListMaxLevel]*skipListNode) {
+	for i := 0; i < sl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		sl.tail = x.backward
+	}
+	for sl.level > 1 && sl.header.level[sl.level-1].forward == nil {
+		sl.level--
+	}
+	sl.length--
+}
+
+// delete removes (score, member) and reports whether it was found.
+func (sl *skipList) delete(score float64, member string) bool {
+	var update [skipListMaxLevel]*skipListNode
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.less(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+	sl.unlink(x, &update)
+	return true
+}
+
+// rank returns the 0-based rank of (score, member), which must be in the list.
+func (sl *skipList) rank(score float64, member string) int {
+	rank := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.less(score, member) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+	}
+	return rank
+}
+
+// byRank returns the node at a 0-based rank, or nil if out of range.
+func (sl *skipList) byRank(rank int) *skipListNode {
+	if rank < 0 || rank >= sl.length {
+		return nil
+	}
+	traversed := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank+1 {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank+1 {
+			return x
+		}
+	}
+	return nil
+}
+
+// firstAtLeast returns the first node with a score >= min and its rank.
+func (sl *skipList) firstAtLeast(min float64) (*skipListNode, int) {
+	rank := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.score < min {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+	}
+	return x.level[0].forward, rank
+}
+
+// lastAtMost returns the last node with a score <= max and its rank, or nil.
+func (sl *skipList) lastAtMost(max float64) (*skipListNode, int) {
+	rank := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.score <= max {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+	}
+	if x == sl.header {
+		return nil, -1
+	}
+	return x, rank - 1
+}
+
+// deleteRange removes up to n nodes starting at a 0-based rank, calling fn
+// for each one, and returns how many were removed.
+func (sl *skipList) deleteRange(start, n int, fn func(*skipListNode)) int {
+	var update [skipListMaxLevel]*skipListNode
+	traversed := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= start {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	removed := 0
+	x = x.level[0].forward
+	for x != nil && removed < n {
+		next := x.level[0].forward
+		sl.unlink(x, &update)
+		fn(x)
+		removed++
+		x = next
+	}
+	return removed
+}
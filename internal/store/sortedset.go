@@ -2,7 +2,7 @@
 package store
 
 import (
-	"sort"
+	"math"
 	"sync"
 )
 
@@ -13,19 +13,37 @@ type ScoredMember struct {
 }
 
 // SortedSet represents a Redis-like sorted set data structure.
-// It maintains elements sorted by score, with O(log n) operations.
+// A member map gives O(1) score lookups and a skip list keeps members
+// ordered by score, then member, for O(log n) rank and range operations.
 type SortedSet struct {
 	mu      sync.RWMutex
 	members map[string]float64 // member -> score
+	zsl     *skipList
+	bytes   int // total length of members, for memory accounting
 }
 
 // NewSortedSet creates a new sorted set.
 func NewSortedSet() *SortedSet {
 	return &SortedSet{
 		members: make(map[string]float64),
+		zsl:     newSkipList(),
 	}
 }
 
+// set adds member or moves it to a new score (must hold write lock).
+func (z *SortedSet) set(member string, score float64) {
+	if old, exists := z.members[member]; exists {
+		if old == score {
+			return
+		}
+		z.zsl.delete(old, member)
+	} else {
+		z.bytes += len(member)
+	}
+	z.members[member] = score
+	z.zsl.insert(score, member)
+}
+
 // Add adds one or more members with scores. Returns the number of new members added.
 func (z *SortedSet) Add(members ...ScoredMember) int {
 	z.mu.Lock()
@@ -36,7 +54,7 @@ func (z *SortedSet) Add(members ...ScoredMember) int {
 		if _, exists := z.members[m.Member]; !exists {
 			added++
 		}
-		z.members[m.Member] = m.Score
+		z.set(m.Member, m.Score)
 	}
 	return added
 }
@@ -49,7 +67,7 @@ func (z *SortedSet) AddNX(members ...ScoredMember) int {
 	added := 0
 	for _, m := range members {
 		if _, exists := z.members[m.Member]; !exists {
-			z.members[m.Member] = m.Score
+			z.set(m.Member, m.Score)
 			added++
 		}
 	}
@@ -64,7 +82,7 @@ func (z *SortedSet) AddXX(members ...ScoredMember) int {
 	updated := 0
 	for _, m := range members {
 		if _, exists := z.members[m.Member]; exists {
-			z.members[m.Member] = m.Score
+			z.set(m.Member, m.Score)
 			updated++
 		}
 	}
@@ -81,12 +99,18 @@ func (z *SortedSet) Score(member string) (float64, bool) {
 }
 
 // IncrBy increments the score of a member. Creates member if not exists.
+// If the result is not a number, the member is left unchanged and NaN is
+// returned.
 func (z *SortedSet) IncrBy(member string, increment float64) float64 {
 	z.mu.Lock()
 	defer z.mu.Unlock()
 
-	z.members[member] += increment
-	return z.members[member]
+	score := z.members[member] + increment
+	if math.IsNaN(score) {
+		return score
+	}
+	z.set(member, score)
+	return score
 }
 
 // Remove removes members from the set. Returns number removed.
@@ -96,8 +120,10 @@ func (z *SortedSet) Remove(members ...string) int {
 
 	removed := 0
 	for _, m := range members {
-		if _, exists := z.members[m]; exists {
+		if score, exists := z.members[m]; exists {
+			z.zsl.delete(score, m)
 			delete(z.members, m)
+			z.bytes -= len(m)
 			removed++
 		}
 	}
@@ -120,14 +146,7 @@ func (z *SortedSet) Rank(member string) (int, bool) {
 	if !exists {
 		return -1, false
 	}
-
-	rank := 0
-	for m, s := range z.members {
-		if s < score || (s == score && m < member) {
-			rank++
-		}
-	}
-	return rank, true
+	return z.zsl.rank(score, member), true
 }
 
 // RevRank returns the rank of a member (0-based, descending by score).
@@ -139,14 +158,7 @@ func (z *SortedSet) RevRank(member string) (int, bool) {
 	if !exists {
 		return -1, false
 	}
-
-	rank := 0
-	for m, s := range z.members {
-		if s > score || (s == score && m > member) {
-			rank++
-		}
-	}
-	return rank, true
+	return z.zsl.length - 1 - z.zsl.rank(score, member), true
 }
 
 // Count returns the number of elements with scores between min and max (inclusive).
@@ -154,51 +166,23 @@ func (z *SortedSet) Count(min, max float64) int {
 	z.mu.RLock()
 	defer z.mu.RUnlock()
 
-	count := 0
-	for _, score := range z.members {
-		if score >= min && score <= max {
-			count++
-		}
-	}
-	return count
-}
-
-// getSortedMembers returns all members sorted by score (ascending).
-func (z *SortedSet) getSortedMembers() []ScoredMember {
-	result := make([]ScoredMember, 0, len(z.members))
-	for member, score := range z.members {
-		result = append(result, ScoredMember{Member: member, Score: score})
+	first, firstRank := z.zsl.firstAtLeast(min)
+	if first == nil || first.score > max {
+		return 0
 	}
-	sort.Slice(result, func(i, j int) bool {
-		if result[i].Score != result[j].Score {
-			return result[i].Score < result[j].Score
-		}
-		return result[i].Member < result[j].Member
-	})
-	return result
+	_, lastRank := z.zsl.lastAtMost(max)
+	return lastRank - firstRank + 1
 }
 
-// Range returns members by index range (inclusive, 0-based).
-// Supports negative indices (-1 = last element).
-func (z *SortedSet) Range(start, stop int, withScores bool) []ScoredMember {
-	z.mu.RLock()
-	defer z.mu.RUnlock()
-
-	sorted := z.getSortedMembers()
-	n := len(sorted)
-	if n == 0 {
-		return nil
-	}
-
-	// Handle negative indices
+// rankRange resolves start and stop (negative counts from the end) into a
+// clamped, inclusive rank range. ok is false if the range is empty.
+func rankRange(start, stop, n int) (int, int, bool) {
 	if start < 0 {
 		start = n + start
 	}
 	if stop < 0 {
 		stop = n + stop
 	}
-
-	// Clamp to valid range
 	if start < 0 {
 		start = 0
 	}
@@ -206,58 +190,49 @@ func (z *SortedSet) Range(start, stop int, withScores bool) []ScoredMember {
 		stop = n - 1
 	}
 	if start > stop || start >= n {
-		return nil
+		return 0, 0, false
 	}
+	return start, stop, true
+}
 
-	result := sorted[start : stop+1]
-	if !withScores {
-		for i := range result {
-			result[i].Score = 0
-		}
+// appendNode appends a node to result, dropping its score unless withScores.
+func appendNode(result []ScoredMember, x *skipListNode, withScores bool) []ScoredMember {
+	if withScores {
+		return append(result, ScoredMember{Member: x.member, Score: x.score})
 	}
-	return result
+	return append(result, ScoredMember{Member: x.member})
 }
 
-// RevRange returns members by index range in reverse order (descending by score).
-func (z *SortedSet) RevRange(start, stop int, withScores bool) []ScoredMember {
+// Range returns members by index range (inclusive, 0-based).
+// Supports negative indices (-1 = last element).
+func (z *SortedSet) Range(start, stop int, withScores bool) []ScoredMember {
 	z.mu.RLock()
 	defer z.mu.RUnlock()
 
-	sorted := z.getSortedMembers()
-	n := len(sorted)
-	if n == 0 {
+	start, stop, ok := rankRange(start, stop, z.zsl.length)
+	if !ok {
 		return nil
 	}
-
-	// Reverse the sorted slice
-	for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
-		sorted[i], sorted[j] = sorted[j], sorted[i]
+	result := make([]ScoredMember, 0, stop-start+1)
+	for x := z.zsl.byRank(start); x != nil && len(result) < cap(result); x = x.level[0].forward {
+		result = appendNode(result, x, withScores)
 	}
+	return result
+}
 
-	// Handle negative indices
-	if start < 0 {
-		start = n + start
-	}
-	if stop < 0 {
-		stop = n + stop
-	}
+// RevRange returns members by index range in reverse order (descending by score).
+func (z *SortedSet) RevRange(start, stop int, withScores bool) []ScoredMember {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
 
-	// Clamp to valid range
-	if start < 0 {
-		start = 0
-	}
-	if stop >= n {
-		stop = n - 1
-	}
-	if start > stop || start >= n {
+	n := z.zsl.length
+	start, stop, ok := rankRange(start, stop, n)
+	if !ok {
 		return nil
 	}
-
-	result := sorted[start : stop+1]
-	if !withScores {
-		for i := range result {
-			result[i].Score = 0
-		}
+	result := make([]ScoredMember, 0, stop-start+1)
+	for x := z.zsl.byRank(n - 1 - start); x != nil && len(result) < cap(result); x = x.backward {
+		result = appendNode(result, x, withScores)
 	}
 	return result
 }
@@ -267,25 +242,17 @@ func (z *SortedSet) RangeByScore(min, max float64, withScores bool, offset, coun
 	z.mu.RLock()
 	defer z.mu.RUnlock()
 
-	sorted := z.getSortedMembers()
+	x, rank := z.zsl.firstAtLeast(min)
+	if offset > 0 {
+		x = z.zsl.byRank(rank + offset)
+	}
 
 	var result []ScoredMember
-	skipped := 0
-	for _, m := range sorted {
-		if m.Score >= min && m.Score <= max {
-			if skipped < offset {
-				skipped++
-				continue
-			}
-			if count > 0 && len(result) >= count {
-				break
-			}
-			if withScores {
-				result = append(result, m)
-			} else {
-				result = append(result, ScoredMember{Member: m.Member})
-			}
+	for ; x != nil && x.score <= max; x = x.level[0].forward {
+		if count > 0 && len(result) >= count {
+			break
 		}
+		result = appendNode(result, x, withScores)
 	}
 	return result
 }
@@ -295,70 +262,37 @@ func (z *SortedSet) RevRangeByScore(max, min float64, withScores bool, offset, c
 	z.mu.RLock()
 	defer z.mu.RUnlock()
 
-	sorted := z.getSortedMembers()
-
-	// Reverse
-	for i, j := 0, len(sorted)-1; i < j; i, j = i+1, j-1 {
-		sorted[i], sorted[j] = sorted[j], sorted[i]
+	x, rank := z.zsl.lastAtMost(max)
+	if offset > 0 && x != nil {
+		x = z.zsl.byRank(rank - offset)
 	}
 
 	var result []ScoredMember
-	skipped := 0
-	for _, m := range sorted {
-		if m.Score >= min && m.Score <= max {
-			if skipped < offset {
-				skipped++
-				continue
-			}
-			if count > 0 && len(result) >= count {
-				break
-			}
-			if withScores {
-				result = append(result, m)
-			} else {
-				result = append(result, ScoredMember{Member: m.Member})
-			}
+	for ; x != nil && x.score >= min; x = x.backward {
+		if count > 0 && len(result) >= count {
+			break
 		}
+		result = appendNode(result, x, withScores)
 	}
 	return result
 }
 
+// removeNode drops a node unlinked from the skip list from the member map.
+func (z *SortedSet) removeNode(x *skipListNode) {
+	delete(z.members, x.member)
+	z.bytes -= len(x.member)
+}
+
 // RemoveRangeByRank removes members by rank range (inclusive).
 func (z *SortedSet) RemoveRangeByRank(start, stop int) int {
 	z.mu.Lock()
 	defer z.mu.Unlock()
 
-	sorted := z.getSortedMembers()
-	n := len(sorted)
-	if n == 0 {
-		return 0
-	}
-
-	// Handle negative indices
-	if start < 0 {
-		start = n + start
-	}
-	if stop < 0 {
-		stop = n + stop
-	}
-
-	// Clamp
-	if start < 0 {
-		start = 0
-	}
-	if stop >= n {
-		stop = n - 1
-	}
-	if start > stop || start >= n {
+	start, stop, ok := rankRange(start, stop, z.zsl.length)
+	if !ok {
 		return 0
 	}
-
-	removed := 0
-	for i := start; i <= stop; i++ {
-		delete(z.members, sorted[i].Member)
-		removed++
-	}
-	return removed
+	return z.zsl.deleteRange(start, stop-start+1, z.removeNode)
 }
 
 // RemoveRangeByScore removes members with scores in the given range.
@@ -366,14 +300,12 @@ func (z *SortedSet) RemoveRangeByScore(min, max float64) int {
 	z.mu.Lock()
 	defer z.mu.Unlock()
 
-	removed := 0
-	for member, score := range z.members {
-		if score >= min && score <= max {
-			delete(z.members, member)
-			removed++
-		}
+	first, firstRank := z.zsl.firstAtLeast(min)
+	if first == nil || first.score > max {
+		return 0
 	}
-	return removed
+	_, lastRank := z.zsl.lastAtMost(max)
+	return z.zsl.deleteRange(firstRank, lastRank-firstRank+1, z.removeNode)
 }
 
 // PopMin removes and returns the member with the lowest score.
@@ -384,23 +316,15 @@ func (z *SortedSet) PopMin(count int) []ScoredMember {
 	if count <= 0 {
 		count = 1
 	}
-
-	sorted := z.getSortedMembers()
-	if len(sorted) == 0 {
+	if z.zsl.length == 0 {
 		return nil
 	}
 
-	if count > len(sorted) {
-		count = len(sorted)
-	}
-
-	result := make([]ScoredMember, count)
-	copy(result, sorted[:count])
-
-	for _, m := range result {
-		delete(z.members, m.Member)
-	}
-
+	var result []ScoredMember
+	z.zsl.deleteRange(0, count, func(x *skipListNode) {
+		result = appendNode(result, x, true)
+		z.removeNode(x)
+	})
 	return result
 }
 
@@ -412,26 +336,19 @@ func (z *SortedSet) PopMax(count int) []ScoredMember {
 	if count <= 0 {
 		count = 1
 	}
-
-	sorted := z.getSortedMembers()
-	n := len(sorted)
+	n := z.zsl.length
 	if n == 0 {
 		return nil
 	}
-
 	if count > n {
 		count = n
 	}
 
-	result := make([]ScoredMember, count)
-	for i := 0; i < count; i++ {
-		result[i] = sorted[n-1-i]
-	}
-
-	for _, m := range result {
-		delete(z.members, m.Member)
+	result := make([]ScoredMember, 0, count)
+	for x := z.zsl.tail; x != nil && len(result) < count; x = x.backward {
+		result = appendNode(result, x, true)
 	}
-
+	z.zsl.deleteRange(n-count, count, z.removeNode)
 	return result
 }
 
@@ -492,3 +409,15 @@ func (z *SortedSet) Members() map[string]float64 {
 	}
 	return result
 }
+
+// clone returns a deep copy of the sorted set.
+func (z *SortedSet) clone() *SortedSet {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	c := NewSortedSet()
+	for x := z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		c.set(x.member, x.score)
+	}
+	return c
+}
@@ -1,6 +1,11 @@
 package store
 
 import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
 	"testing"
 )
 
@@ -344,16 +349,19 @@ func TestStore_ZAdd(t *testing.T) {
 	s := New()
 	defer s.Close()
 
-	added := s.ZAdd("leaderboard",
+	added, err := s.ZAdd("leaderboard",
 		ScoredMember{Member: "player1", Score: 100},
 		ScoredMember{Member: "player2", Score: 200},
 	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 
 	if added != 2 {
 		t.Errorf("expected 2 added, got %d", added)
 	}
 
-	card := s.ZCard("leaderboard")
+	card, _ := s.ZCard("leaderboard")
 	if card != 2 {
 		t.Errorf("expected cardinality 2, got %d", card)
 	}
@@ -369,13 +377,13 @@ func TestStore_ZRange(t *testing.T) {
 		ScoredMember{Member: "c", Score: 3},
 	)
 
-	members := s.ZRange("scores", 0, -1, true)
+	members, _ := s.ZRange("scores", 0, -1, true)
 	if len(members) != 3 {
 		t.Errorf("expected 3 members, got %d", len(members))
 	}
 
 	// Non-existent key
-	members = s.ZRange("nonexistent", 0, -1, false)
+	members, _ = s.ZRange("nonexistent", 0, -1, false)
 	if members != nil {
 		t.Error("expected nil for non-existent key")
 	}
@@ -390,7 +398,131 @@ func TestStore_Clear_SortedSets(t *testing.T) {
 
 	s.Clear()
 
-	if s.ZCard("zset1") != 0 || s.ZCard("zset2") != 0 {
+	if s.Size() != 0 {
 		t.Error("expected sorted sets to be cleared")
 	}
 }
+
+// sortedModel is the reference ordering: score, then member name.
+func sortedModel(m map[string]float64) []ScoredMember {
+	result := make([]ScoredMember, 0, len(m))
+	for member, score := range m {
+		result = append(result, ScoredMember{Member: member, Score: score})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score < result[j].Score
+		}
+		return result[i].Member < result[j].Member
+	})
+	return result
+}
+
+func TestSortedSet_MatchesModel(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	z := NewSortedSet()
+	model := make(map[string]float64)
+
+	for step := 0; step < 5000; step++ {
+		member := fmt.Sprintf("m%d", rng.Intn(300))
+		// Few distinct scores, so ties are broken by member name often.
+		score := float64(rng.Intn(20))
+		switch rng.Intn(8) {
+		case 0, 1, 2:
+			z.Add(ScoredMember{Member: member, Score: score})
+			model[member] = score
+		case 3:
+			got := z.IncrBy(member, score-10)
+			model[member] += score - 10
+			if got != model[member] {
+				t.Fatalf("step %d: IncrBy = %v, want %v", step, got, model[member])
+			}
+		case 4:
+			z.Remove(member)
+			delete(model, member)
+		case 5:
+			start, stop := rng.Intn(10), rng.Intn(10)
+			want := sortedModel(model)
+			if s, e, ok := rankRange(start, stop, len(want)); ok {
+				for _, m := range want[s : e+1] {
+					delete(model, m.Member)
+				}
+			}
+			z.RemoveRangeByRank(start, stop)
+		case 6:
+			min, max := float64(rng.Intn(20)), float64(rng.Intn(20))
+			for m, s := range model {
+				if s >= min && s <= max {
+					delete(model, m)
+				}
+			}
+			z.RemoveRangeByScore(min, max)
+		case 7:
+			want := sortedModel(model)
+			if len(want) > 0 {
+				got := z.PopMin(2)
+				n := int(math.Min(2, float64(len(want))))
+				if !reflect.DeepEqual(got, want[:n]) {
+					t.Fatalf("step %d: PopMin = %v, want %v", step, got, want[:n])
+				}
+				for _, m := range got {
+					delete(model, m.Member)
+				}
+			}
+		}
+
+		if step%50 != 0 {
+			continue
+		}
+		want := sortedModel(model)
+		if got := z.Range(0, -1, true); len(want) > 0 && !reflect.DeepEqual(got, want) {
+			t.Fatalf("step %d: Range = %v, want %v", step, got, want)
+		}
+		for i, m := range want {
+			if r, _ := z.Rank(m.Member); r != i {
+				t.Fatalf("step %d: Rank(%s) = %d, want %d", step, m.Member, r, i)
+			}
+			if r, _ := z.RevRank(m.Member); r != len(want)-1-i {
+				t.Fatalf("step %d: RevRank(%s) = %d, want %d", step, m.Member, r, len(want)-1-i)
+			}
+		}
+		min, max := float64(rng.Intn(20)-5), float64(rng.Intn(20)-5)
+		var inRange []ScoredMember
+		for _, m := range want {
+			if m.Score >= min && m.Score <= max {
+				inRange = append(inRange, m)
+			}
+		}
+		if got := z.Count(min, max); got != len(inRange) {
+			t.Fatalf("step %d: Count(%v, %v) = %d, want %d", step, min, max, got, len(inRange))
+		}
+		got := z.RangeByScore(min, max, true, 1, 3)
+		var exp []ScoredMember
+		if len(inRange) > 1 {
+			exp = inRange[1:int(math.Min(4, float64(len(inRange))))]
+		}
+		if !reflect.DeepEqual(got, exp) {
+			t.Fatalf("step %d: RangeByScore = %v, want %v", step, got, exp)
+		}
+		got = z.RevRangeByScore(max, min, true, 0, 0)
+		var rev []ScoredMember
+		for i := len(inRange) - 1; i >= 0; i-- {
+			rev = append(rev, inRange[i])
+		}
+		if !reflect.DeepEqual(got, rev) {
+			t.Fatalf("step %d: RevRangeByScore = %v, want %v", step, got, rev)
+		}
+	}
+}
+
+func TestSortedSet_IncrByNaN(t *testing.T) {
+	z := NewSortedSet()
+	z.Add(ScoredMember{Member: "m", Score: math.Inf(1)})
+
+	if got := z.IncrBy("m", math.Inf(-1)); !math.IsNaN(got) {
+		t.Fatalf("expected NaN, got %v", got)
+	}
+	if score, _ := z.Score("m"); !math.IsInf(score, 1) {
+		t.Errorf("score changed to %v", score)
+	}
+}
@@ -2,12 +2,55 @@
 package store
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ErrWrongType is returned when an operation is applied to a key holding
+// a different kind of value.
+var ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// ValueType identifies the kind of value stored under a key.
+type ValueType uint8
+
+// Value types held in the keyspace.
+const (
+	TypeNone ValueType = iota
+	TypeString
+	TypeHash
+	TypeList
+	TypeSet
+	TypeZSet
+	TypeStream
+	TypeJSON
+)
+
+// String returns the Redis TYPE name for t.
+func (t ValueType) String() string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeHash:
+		return "hash"
+	case TypeList:
+		return "list"
+	case TypeSet:
+		return "set"
+	case TypeZSet:
+		return "zset"
+	case TypeStream:
+		return "stream"
+	case TypeJSON:
+		return "ReJSON-RL"
+	default:
+		return "none"
+	}
+}
+
 // Entry represents a value with optional expiration.
 type Entry struct {
 	Value     []byte
@@ -15,38 +58,121 @@ type Entry struct {
 	HasExpire bool
 }
 
+// object is a single slot in the keyspace. Exactly one of the value
+// fields is set, as indicated by typ. The expiration applies to the key
+// itself, whatever type of value it holds.
+type object struct {
+	typ       ValueType
+	expireAt  time.Time
+	hasExpire bool
+
+	str    []byte
+	hash   *Hash
+	list   *List
+	set    *Set
+	zset   *SortedSet
+	stream *Stream
+	json   *JSON
+
+	// viewEpoch is the epoch of the newest view that captured this object.
+	viewEpoch uint64
+
+	// size is the number of bytes the object is accounted for, see
+	// memory.go. lru and lfu track accesses for eviction, see eviction.go;
+	// readers update them under the read lock.
+	size int64
+	lru  atomic.Int64
+	lfu  atomic.Uint32
+}
+
+// newObject creates an empty object of the given container type.
+func newObject(typ ValueType) *object {
+	obj := &object{typ: typ}
+	obj.resetAccess()
+	switch typ {
+	case TypeHash:
+		obj.hash = NewHash()
+	case TypeList:
+		obj.list = NewList()
+	case TypeSet:
+		obj.set = NewSet()
+	case TypeZSet:
+		obj.zset = NewSortedSet()
+	case TypeStream:
+		obj.stream = NewStream()
+	case TypeJSON:
+		obj.json = &JSON{}
+	}
+	return obj
+}
+
+// clone returns a deep copy of the object.
+func (o *object) clone() *object {
+	c := &object{typ: o.typ, expireAt: o.expireAt, hasExpire: o.hasExpire, size: o.size}
+	c.lru.Store(o.lru.Load())
+	c.lfu.Store(o.lfu.Load())
+	switch o.typ {
+	case TypeString:
+		c.str = append([]byte(nil), o.str...)
+	case TypeHash:
+		c.hash = o.hash.clone()
+	case TypeList:
+		c.list = o.list.clone()
+	case TypeSet:
+		c.set = o.set.clone()
+	case TypeZSet:
+		c.zset = o.zset.clone()
+	case TypeStream:
+		c.stream = o.stream.clone()
+	case TypeJSON:
+		c.json = o.json.clone()
+	}
+	return c
+}
+
 // Store represents an in-memory key-value store with TTL support.
-// It is safe for concurrent use by multiple goroutines.
+// All data types share a single keyspace, so each key holds exactly one
+// typed value. It is safe for concurrent use by multiple goroutines.
 type Store struct {
-	mu         sync.RWMutex
-	data       map[string]*Entry
-	sortedSets map[string]*SortedSet
-	hashes     map[string]*Hash
-	lists      map[string]*List
-	sets       map[string]*Set
-	stopGC     chan struct{}
+	mu     sync.RWMutex
+	data   map[string]*object
+	stopGC chan struct{}
+
+	// expiryPaused freezes expiration, e.g. while the engine replays its log.
+	expiryPaused atomic.Bool
+
+	// Active expiry hooks, see OnExpire.
+	expireGuard sync.Locker
+	onExpire    func(keys []string)
+
+	// Open views, see OpenView. openViews holds their epochs in ascending order.
+	viewEpoch uint64
+	openViews []uint64
+
+	// Keys written or removed since the last TakeChanged, nil when changes
+	// are not tracked.
+	changed map[string]struct{}
+
+	// Memory accounting, see memory.go: the bytes used by all keys, as of
+	// the last settle, and the keys changed since with the size they were
+	// accounted for.
+	used    int64
+	unsized map[string]int64
 }
 
-func cloneEntry(entry *Entry) *Entry {
-	cloned := &Entry{
-		ExpireAt:  entry.ExpireAt,
-		HasExpire: entry.HasExpire,
-	}
-	if entry.Value != nil {
-		cloned.Value = append([]byte(nil), entry.Value...)
-	}
-	return cloned
+// newString creates a string object holding a private copy of value.
+func newString(value []byte) *object {
+	obj := &object{typ: TypeString, str: append([]byte(nil), value...)}
+	obj.resetAccess()
+	return obj
 }
 
 // New creates a new empty Store and starts the background expiration goroutine.
 func New() *Store {
 	s := &Store{
-		data:       make(map[string]*Entry),
-		sortedSets: make(map[string]*SortedSet),
-		hashes:     make(map[string]*Hash),
-		lists:      make(map[string]*List),
-		sets:       make(map[string]*Set),
-		stopGC:     make(chan struct{}),
+		data:    make(map[string]*object),
+		stopGC:  make(chan struct{}),
+		unsized: make(map[string]int64),
 	}
 	go s.gcLoop()
 	return s
@@ -63,6 +189,7 @@ func (s *Store) gcLoop() {
 			return
 		case <-ticker.C:
 			s.removeExpired()
+			s.settleMemory()
 		}
 	}
 }
@@ -78,41 +205,85 @@ func (s *Store) removeExpired() {
 		expiredRatio = 0.25
 	)
 
+	s.mu.RLock()
+	guard, onExpire := s.expireGuard, s.onExpire
+	s.mu.RUnlock()
+
 	for round := 0; round < maxRounds; round++ {
-		s.mu.Lock()
+		if guard != nil {
+			guard.Lock()
+		}
+		sampled, expired := s.ExpireSample(sampleSize)
+		if len(expired) > 0 && onExpire != nil {
+			onExpire(expired)
+		}
+		if guard != nil {
+			guard.Unlock()
+		}
 
-		n := len(s.data)
-		if n == 0 {
-			s.mu.Unlock()
+		// If fewer than 25% of sampled keys were expired, stop
+		if sampled == 0 || float64(len(expired))/float64(sampled) < expiredRatio {
 			return
 		}
+	}
+}
 
-		// Collect all keys into a slice for random sampling.
-		// For very large datasets this could be optimized with a separate expiry index,
-		// but for now a random sample from the key space is adequate.
-		now := time.Now()
-		sampled := 0
-		expired := 0
-
-		// Use map iteration which is pseudo-random in Go
-		for key, entry := range s.data {
-			if sampled >= sampleSize {
-				break
-			}
-			sampled++
-			if entry.HasExpire && now.After(entry.ExpireAt) {
-				delete(s.data, key)
-				expired++
-			}
-		}
+// ExpireSample inspects up to n keys and deletes the expired ones.
+// It returns how many keys were inspected and which were deleted. Callers
+// outside the expiration cycle must hold the OnExpire guard.
+func (s *Store) ExpireSample(n int) (int, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-		s.mu.Unlock()
+	// For very large datasets this could be optimized with a separate expiry index,
+	// but for now a random sample from the key space is adequate.
+	sampled := 0
+	var expired []string
 
-		// If fewer than 25% of sampled keys were expired, stop
-		if sampled == 0 || float64(expired)/float64(sampled) < expiredRatio {
-			return
+	// Use map iteration which is pseudo-random in Go
+	for key, obj := range s.data {
+		if sampled >= n {
+			break
+		}
+		sampled++
+		if s.isExpired(obj) {
+			s.touch(key)
+			delete(s.data, key)
+			expired = append(expired, key)
 		}
 	}
+	return sampled, expired
+}
+
+// OnExpire registers fn to be called with the keys removed by the background
+// expiration cycle. If guard is non-nil it is held while keys are removed and
+// fn runs, letting the caller order expirations with its own writes.
+func (s *Store) OnExpire(guard sync.Locker, fn func(keys []string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireGuard = guard
+	s.onExpire = fn
+}
+
+// PauseExpiry stops (or resumes) treating keys as expired. While paused, keys
+// keep their deadlines but stay visible and are not collected.
+func (s *Store) PauseExpiry(paused bool) {
+	s.expiryPaused.Store(paused)
+}
+
+// ExpireIfNeeded deletes key if its TTL has elapsed.
+// Returns true if the key was removed.
+func (s *Store) ExpireIfNeeded(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, ok := s.data[key]
+	if !ok || !s.isExpired(obj) {
+		return false
+	}
+	s.touch(key)
+	delete(s.data, key)
+	return true
 }
 
 // Close stops the background GC goroutine.
@@ -120,27 +291,76 @@ func (s *Store) Close() {
 	close(s.stopGC)
 }
 
-// isExpired checks if an entry is expired (must hold lock).
-func (s *Store) isExpired(entry *Entry) bool {
-	return entry.HasExpire && time.Now().After(entry.ExpireAt)
+// isExpired checks if an object is expired (must hold lock).
+func (s *Store) isExpired(obj *object) bool {
+	return obj.hasExpire && !s.expiryPaused.Load() && time.Now().After(obj.expireAt)
+}
+
+// lookup returns the live object stored at key (must hold lock).
+func (s *Store) lookup(key string) (*object, bool) {
+	obj, ok := s.data[key]
+	if !ok || s.isExpired(obj) {
+		return nil, false
+	}
+	obj.access()
+	return obj, true
+}
+
+// lookupType returns the live object at key if it holds a value of typ (must hold lock).
+// A missing key yields a nil object and no error.
+func (s *Store) lookupType(key string, typ ValueType) (*object, error) {
+	obj, ok := s.lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	if obj.typ != typ {
+		return nil, ErrWrongType
+	}
+	return obj, nil
+}
+
+// lookupOrCreate returns the object at key, creating an empty value of typ
+// if the key does not exist (must hold write lock).
+func (s *Store) lookupOrCreate(key string, typ ValueType) (*object, error) {
+	obj, err := s.lookupType(key, typ)
+	if err != nil || obj != nil {
+		return obj, err
+	}
+	obj = newObject(typ)
+	s.data[key] = obj
+	return obj, nil
+}
+
+// Type returns the type of the value stored at key, or TypeNone if the key does not exist.
+func (s *Store) Type(key string) ValueType {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, ok := s.lookup(key)
+	if !ok {
+		return TypeNone
+	}
+	return obj.typ
 }
 
 // Set stores a key-value pair in the store without expiration.
+// Any existing value is replaced regardless of its type.
 func (s *Store) Set(key string, value []byte) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.data[key] = &Entry{Value: append([]byte(nil), value...)}
+	s.touch(key)
+	s.data[key] = newString(value)
 }
 
 // SetWithTTL stores a key-value pair with a TTL.
 func (s *Store) SetWithTTL(key string, value []byte, ttl time.Duration) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.data[key] = &Entry{
-		Value:     append([]byte(nil), value...),
-		ExpireAt:  time.Now().Add(ttl),
-		HasExpire: true,
-	}
+	obj := newString(value)
+	obj.expireAt = time.Now().Add(ttl)
+	obj.hasExpire = true
+	s.touch(key)
+	s.data[key] = obj
 }
 
 // SetNX sets key to value if key does not exist. Returns true if set.
@@ -148,42 +368,42 @@ func (s *Store) SetNX(key string, value []byte) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	entry, exists := s.data[key]
-	if exists && !s.isExpired(entry) {
+	if _, exists := s.lookup(key); exists {
 		return false
 	}
 
-	s.data[key] = &Entry{Value: append([]byte(nil), value...)}
+	s.touch(key)
+	s.data[key] = newString(value)
 	return true
 }
 
-// Get retrieves a value by key from the store.
+// Get retrieves a string value by key from the store.
 // Returns the value and true if found and not expired, nil and false otherwise.
-func (s *Store) Get(key string) ([]byte, bool) {
+func (s *Store) Get(key string) ([]byte, bool, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	entry, ok := s.data[key]
-	if !ok || s.isExpired(entry) {
-		return nil, false
+	obj, err := s.lookupType(key, TypeString)
+	if obj == nil {
+		return nil, false, err
 	}
 
 	// Return a copy to prevent external mutation
-	result := make([]byte, len(entry.Value))
-	copy(result, entry.Value)
-	return result, true
+	result := make([]byte, len(obj.str))
+	copy(result, obj.str)
+	return result, true, nil
 }
 
-// Delete removes a key from the store.
+// Delete removes a key of any type from the store.
 // Returns true if the key existed, false otherwise.
 func (s *Store) Delete(key string) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	entry, ok := s.data[key]
-	if !ok || s.isExpired(entry) {
+	if _, ok := s.lookup(key); !ok {
 		return false
 	}
+	s.touch(key)
 	delete(s.data, key)
 	return true
 }
@@ -193,63 +413,149 @@ func (s *Store) Exists(key string) bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	entry, ok := s.data[key]
-	return ok && !s.isExpired(entry)
+	_, ok := s.lookup(key)
+	return ok
 }
 
-// Expire sets a TTL on an existing key. Returns true if successful.
+// Expire sets a TTL on an existing key of any type. Returns true if successful.
 func (s *Store) Expire(key string, ttl time.Duration) bool {
+	return s.ExpireAt(key, time.Now().Add(ttl))
+}
+
+// ExpireAt sets an absolute expiration time on an existing key of any type.
+// Returns true if successful.
+func (s *Store) ExpireAt(key string, at time.Time) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	entry, ok := s.data[key]
-	if !ok || s.isExpired(entry) {
+	obj, ok := s.lookup(key)
+	if !ok {
 		return false
 	}
 
-	entry.ExpireAt = time.Now().Add(ttl)
-	entry.HasExpire = true
+	obj.expireAt = at
+	obj.hasExpire = true
 	return true
 }
 
+// ExpireTime returns the expiration time of key.
+// The boolean is false if the key does not exist or has no TTL.
+func (s *Store) ExpireTime(key string) (time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, ok := s.lookup(key)
+	if !ok || !obj.hasExpire {
+		return time.Time{}, false
+	}
+	return obj.expireAt, true
+}
+
 // TTL returns the remaining TTL for a key.
 // Returns -2 if key doesn't exist, -1 if no TTL, otherwise TTL in duration.
 func (s *Store) TTL(key string) time.Duration {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	entry, ok := s.data[key]
-	if !ok || s.isExpired(entry) {
+	obj, ok := s.lookup(key)
+	if !ok {
 		return -2 * time.Second
 	}
 
-	if !entry.HasExpire {
+	if !obj.hasExpire {
 		return -1 * time.Second
 	}
 
-	remaining := time.Until(entry.ExpireAt)
+	remaining := time.Until(obj.expireAt)
 	if remaining < 0 {
 		return -2 * time.Second
 	}
 	return remaining
 }
 
-// Persist removes the TTL from a key. Returns true if successful.
+// Persist removes the TTL from a key of any type. Returns true if successful.
 func (s *Store) Persist(key string) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	entry, ok := s.data[key]
-	if !ok || s.isExpired(entry) {
+	obj, ok := s.lookup(key)
+	if !ok || !obj.hasExpire {
 		return false
 	}
 
-	if !entry.HasExpire {
+	obj.hasExpire = false
+	obj.expireAt = time.Time{}
+	return true
+}
+
+// Rename moves the value at oldKey to newKey, replacing any value at newKey.
+// Returns false if oldKey does not exist.
+func (s *Store) Rename(oldKey, newKey string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, ok := s.lookup(oldKey)
+	if !ok {
 		return false
 	}
+	if oldKey == newKey {
+		return true
+	}
+	s.touch(oldKey)
+	s.touch(newKey)
+	delete(s.data, oldKey)
+	s.data[newKey] = obj
+	return true
+}
 
-	entry.HasExpire = false
-	entry.ExpireAt = time.Time{}
+// Copy stores a deep copy of the value at src under dst.
+// Returns false if src does not exist, or if dst exists and replace is false.
+func (s *Store) Copy(src, dst string, replace bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, ok := s.lookup(src)
+	if !ok {
+		return false
+	}
+	if _, exists := s.lookup(dst); exists && !replace {
+		return false
+	}
+	if src != dst {
+		s.touch(dst)
+		s.data[dst] = obj.clone()
+	}
+	return true
+}
+
+// MoveTo moves the value at key, with its TTL, to dst, which must be
+// another store. Returns false if key does not exist or already exists in
+// dst. The store's lock is taken before dst's.
+func (s *Store) MoveTo(key string, dst *Store) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dst.mu.Lock()
+	defer dst.mu.Unlock()
+
+	obj, ok := s.lookup(key)
+	if !ok {
+		return false
+	}
+	if _, exists := dst.lookup(key); exists {
+		return false
+	}
+	s.touch(key)
+	delete(s.data, key)
+	// A view of s may still reference the object; dst knows nothing of
+	// those views, so it gets a copy of its own.
+	if len(s.openViews) > 0 && obj.viewEpoch >= s.openViews[0] {
+		obj = obj.clone()
+	}
+	obj.viewEpoch = 0
+	dst.touch(key)
+	dst.data[key] = obj
 	return true
 }
 
@@ -259,22 +565,224 @@ func (s *Store) Keys() []string {
 	defer s.mu.RUnlock()
 
 	keys := make([]string, 0, len(s.data))
-	for k, entry := range s.data {
-		if !s.isExpired(entry) {
+	for k, obj := range s.data {
+		if !s.isExpired(obj) {
 			keys = append(keys, k)
 		}
 	}
 	return keys
 }
 
+// Item is a point-in-time copy of a single key, its value and its TTL.
+// Only the field matching Type is set.
+type Item struct {
+	Key       string
+	Type      ValueType
+	ExpireAt  time.Time
+	HasExpire bool
+
+	Str    []byte
+	Hash   []HashFieldValue
+	List   [][]byte
+	Set    []string
+	ZSet   []ScoredMember
+	Stream *StreamData
+	JSON   []byte
+}
+
+// Items returns a copy of every non-expired key in the store.
+func (s *Store) Items() []Item {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	items := make([]Item, 0, len(s.data))
+	for k, obj := range s.data {
+		if s.isExpired(obj) {
+			continue
+		}
+		items = append(items, itemOf(k, obj))
+	}
+	return items
+}
+
+// Item returns a copy of a single key, or false if it does not exist.
+func (s *Store) Item(key string) (Item, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, ok := s.lookup(key)
+	if !ok {
+		return Item{}, false
+	}
+	return itemOf(key, obj), true
+}
+
+// itemOf copies key and obj into an Item.
+func itemOf(key string, obj *object) Item {
+	item := Item{Key: key, Type: obj.typ, ExpireAt: obj.expireAt, HasExpire: obj.hasExpire}
+	switch obj.typ {
+	case TypeString:
+		item.Str = append([]byte(nil), obj.str...)
+	case TypeHash:
+		item.Hash = obj.hash.GetAll()
+	case TypeList:
+		item.List = obj.list.Range(0, -1)
+	case TypeSet:
+		item.Set = obj.set.Members()
+	case TypeZSet:
+		item.ZSet = obj.zset.Range(0, -1, true)
+	case TypeStream:
+		data := obj.stream.Data()
+		item.Stream = &data
+	case TypeJSON:
+		item.JSON = obj.json.Marshal()
+	}
+	return item
+}
+
+// View is a read-only, point-in-time picture of the keyspace. While a view
+// is open, writers copy an object before changing it in place (see cow), so
+// the view can be read without holding the store lock and without blocking
+// writers.
+type View struct {
+	s     *Store
+	epoch uint64
+	keys  []string
+	objs  []*object
+}
+
+// OpenView captures the current keyspace. It only copies key and object
+// references; values are copied lazily, by writers, when they change.
+// The view must be closed when done.
+func (s *Store) OpenView() *View {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.viewEpoch++
+	v := &View{
+		s:     s,
+		epoch: s.viewEpoch,
+		keys:  make([]string, 0, len(s.data)),
+		objs:  make([]*object, 0, len(s.data)),
+	}
+	for k, obj := range s.data {
+		if s.isExpired(obj) {
+			continue
+		}
+		obj.viewEpoch = v.epoch
+		v.keys = append(v.keys, k)
+		v.objs = append(v.objs, obj)
+	}
+	s.openViews = append(s.openViews, v.epoch)
+	return v
+}
+
+// Len returns the number of keys in the view.
+func (v *View) Len() int {
+	return len(v.keys)
+}
+
+// Item returns a copy of the i-th key in the view.
+func (v *View) Item(i int) Item {
+	return itemOf(v.keys[i], v.objs[i])
+}
+
+// Close releases the view, letting writers change objects in place again.
+func (v *View) Close() {
+	v.s.mu.Lock()
+	defer v.s.mu.Unlock()
+
+	for i, epoch := range v.s.openViews {
+		if epoch == v.epoch {
+			v.s.openViews = append(v.s.openViews[:i], v.s.openViews[i+1:]...)
+			break
+		}
+	}
+	v.keys, v.objs = nil, nil
+}
+
+// cow replaces the object at key with a private copy if an open view still
+// references it, so it can be changed in place (must hold write lock). All
+// writers call it first, so it also marks key as changed.
+// Every object that existed when a view opened carries that view's epoch or
+// a later one, so comparing with the oldest open view is enough.
+func (s *Store) cow(key string) {
+	s.touch(key)
+	if len(s.openViews) == 0 {
+		return
+	}
+	obj, ok := s.data[key]
+	if !ok || obj.viewEpoch < s.openViews[0] {
+		return
+	}
+	s.data[key] = obj.clone()
+}
+
+// TrackChanges turns on (or off) recording which keys are written or
+// removed, for callers that keep data derived from the keyspace up to date.
+// Turning it off forgets the keys recorded so far.
+func (s *Store) TrackChanges(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case !on:
+		s.changed = nil
+	case s.changed == nil:
+		s.changed = make(map[string]struct{})
+	}
+}
+
+// TakeChanged returns the keys written or removed since the last call, in
+// no particular order, and starts a new record. A key is included even if
+// the write left it as it was, e.g. a failed or no-op command.
+func (s *Store) TakeChanged() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.changed) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(s.changed))
+	for key := range s.changed {
+		keys = append(keys, key)
+	}
+	s.changed = make(map[string]struct{})
+	return keys
+}
+
+// touch records that key changed, if changes are tracked, and that its
+// size must be measured again (must hold write lock). It must be called
+// before the object at key is replaced or removed.
+func (s *Store) touch(key string) {
+	if s.changed != nil {
+		s.changed[key] = struct{}{}
+	}
+	s.unsize(key)
+}
+
 // Size returns the number of non-expired keys in the store.
 func (s *Store) Size() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	count := 0
-	for _, entry := range s.data {
-		if !s.isExpired(entry) {
+	for _, obj := range s.data {
+		if !s.isExpired(obj) {
+			count++
+		}
+	}
+	return count
+}
+
+// Expires returns the number of non-expired keys that have a TTL.
+func (s *Store) Expires() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, obj := range s.data {
+		if obj.hasExpire && !s.isExpired(obj) {
 			count++
 		}
 	}
@@ -285,52 +793,83 @@ func (s *Store) Size() int {
 func (s *Store) Clear() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.data = make(map[string]*Entry)
-	s.sortedSets = make(map[string]*SortedSet)
-	s.hashes = make(map[string]*Hash)
-	s.lists = make(map[string]*List)
-	s.sets = make(map[string]*Set)
+	for key := range s.data {
+		s.touch(key)
+	}
+	s.data = make(map[string]*object)
+	s.used = 0
+	clear(s.unsized)
 }
 
 // Append appends value to existing key. Returns new length.
-func (s *Store) Append(key string, value []byte) int {
+func (s *Store) Append(key string, value []byte) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	entry, ok := s.data[key]
-	if !ok || s.isExpired(entry) {
-		s.data[key] = &Entry{Value: append([]byte(nil), value...)}
-		return len(value)
+	obj, err := s.lookupType(key, TypeString)
+	if err != nil {
+		return 0, err
+	}
+	if obj == nil {
+		s.data[key] = newString(value)
+		return len(value), nil
 	}
 
-	entry.Value = append(entry.Value, value...)
-	return len(entry.Value)
+	obj.str = append(obj.str, value...)
+	return len(obj.str), nil
+}
+
+// SetRange overwrites the value at key from offset with value, padding it
+// with zero bytes and keeping its TTL. Returns the new length.
+func (s *Store) SetRange(key string, offset int, value []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cow(key)
+
+	obj, err := s.lookupType(key, TypeString)
+	if err != nil {
+		return 0, err
+	}
+	if obj == nil {
+		obj = newString(nil)
+		s.data[key] = obj
+	}
+	if need := offset + len(value); need > len(obj.str) {
+		obj.str = append(obj.str, make([]byte, need-len(obj.str))...)
+	}
+	copy(obj.str[offset:], value)
+	return len(obj.str), nil
 }
 
 // StrLen returns the length of the value at key.
-func (s *Store) StrLen(key string) int {
+func (s *Store) StrLen(key string) (int, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	entry, ok := s.data[key]
-	if !ok || s.isExpired(entry) {
-		return 0
+	obj, err := s.lookupType(key, TypeString)
+	if obj == nil {
+		return 0, err
 	}
-	return len(entry.Value)
+	return len(obj.str), nil
 }
 
-// IncrBy increments the integer value of key by delta.
+// IncrBy increments the integer value of key by delta, keeping its TTL.
 // Returns the new value and any error.
 func (s *Store) IncrBy(key string, delta int64) (int64, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	entry, ok := s.data[key]
+	obj, err := s.lookupType(key, TypeString)
+	if err != nil {
+		return 0, err
+	}
 	var currentVal int64 = 0
 
-	if ok && !s.isExpired(entry) {
+	if obj != nil {
 		// Parse existing value
-		val, err := parseInt64(entry.Value)
+		val, err := parseInt64(obj.str)
 		if err != nil {
 			return 0, err
 		}
@@ -338,27 +877,40 @@ func (s *Store) IncrBy(key string, delta int64) (int64, error) {
 	}
 
 	newVal := currentVal + delta
-	s.data[key] = &Entry{Value: formatInt64(newVal)}
+	if obj == nil {
+		s.data[key] = newString(formatInt64(newVal))
+	} else {
+		obj.str = formatInt64(newVal)
+	}
 	return newVal, nil
 }
 
-// GetEntry returns the raw entry for a key (used by engine for WAL).
+// GetEntry returns the raw string entry for a key (used by engine for WAL).
 func (s *Store) GetEntry(key string) (*Entry, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	entry, ok := s.data[key]
-	if !ok || s.isExpired(entry) {
+	obj, ok := s.lookup(key)
+	if !ok || obj.typ != TypeString {
 		return nil, false
 	}
-	return cloneEntry(entry), true
+	return &Entry{
+		Value:     append([]byte(nil), obj.str...),
+		ExpireAt:  obj.expireAt,
+		HasExpire: obj.hasExpire,
+	}, true
 }
 
-// SetEntry sets a raw entry (used by engine for recovery).
+// SetEntry sets a raw string entry (used by engine for recovery).
 func (s *Store) SetEntry(key string, entry *Entry) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.data[key] = cloneEntry(entry)
+
+	obj := newString(entry.Value)
+	obj.expireAt = entry.ExpireAt
+	obj.hasExpire = entry.HasExpire
+	s.touch(key)
+	s.data[key] = obj
 }
 
 // Helper functions for integer parsing
@@ -432,226 +984,232 @@ func (e *intError) Error() string {
 // Sorted Set Operations
 // ========================
 
+// zsetAt returns the sorted set at key, or nil if the key does not exist (must hold lock).
+func (s *Store) zsetAt(key string) (*SortedSet, error) {
+	obj, err := s.lookupType(key, TypeZSet)
+	if obj == nil {
+		return nil, err
+	}
+	return obj.zset, nil
+}
+
+// removeIfEmpty deletes key once its container has no elements left (must hold write lock).
+func (s *Store) removeIfEmpty(key string, n int) {
+	if n == 0 {
+		delete(s.data, key)
+	}
+}
+
 // ZAdd adds members to a sorted set. Returns number of NEW members added.
-func (s *Store) ZAdd(key string, members ...ScoredMember) int {
+func (s *Store) ZAdd(key string, members ...ScoredMember) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	zset, exists := s.sortedSets[key]
-	if !exists {
-		zset = NewSortedSet()
-		s.sortedSets[key] = zset
+	obj, err := s.lookupOrCreate(key, TypeZSet)
+	if err != nil {
+		return 0, err
 	}
-	return zset.Add(members...)
+	return obj.zset.Add(members...), nil
 }
 
 // ZScore returns the score of a member in a sorted set.
-func (s *Store) ZScore(key, member string) (float64, bool) {
+func (s *Store) ZScore(key, member string) (float64, bool, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	zset, exists := s.sortedSets[key]
-	if !exists {
-		return 0, false
+	zset, err := s.zsetAt(key)
+	if zset == nil {
+		return 0, false, err
 	}
-	return zset.Score(member)
+	score, ok := zset.Score(member)
+	return score, ok, nil
 }
 
 // ZRem removes members from a sorted set. Returns number removed.
-func (s *Store) ZRem(key string, members ...string) int {
+func (s *Store) ZRem(key string, members ...string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	zset, exists := s.sortedSets[key]
-	if !exists {
-		return 0
+	zset, err := s.zsetAt(key)
+	if zset == nil {
+		return 0, err
 	}
 	removed := zset.Remove(members...)
-	if zset.Card() == 0 {
-		delete(s.sortedSets, key)
-	}
-	return removed
+	s.removeIfEmpty(key, zset.Card())
+	return removed, nil
 }
 
 // ZCard returns the cardinality of a sorted set.
-func (s *Store) ZCard(key string) int {
+func (s *Store) ZCard(key string) (int, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	zset, exists := s.sortedSets[key]
-	if !exists {
-		return 0
+	zset, err := s.zsetAt(key)
+	if zset == nil {
+		return 0, err
 	}
-	return zset.Card()
+	return zset.Card(), nil
 }
 
 // ZRank returns the rank of a member (0-based, ascending).
-func (s *Store) ZRank(key, member string) (int, bool) {
+func (s *Store) ZRank(key, member string) (int, bool, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	zset, exists := s.sortedSets[key]
-	if !exists {
-		return -1, false
+	zset, err := s.zsetAt(key)
+	if zset == nil {
+		return -1, false, err
 	}
-	return zset.Rank(member)
+	rank, ok := zset.Rank(member)
+	return rank, ok, nil
 }
 
 // ZRevRank returns the rank of a member (0-based, descending).
-func (s *Store) ZRevRank(key, member string) (int, bool) {
+func (s *Store) ZRevRank(key, member string) (int, bool, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	zset, exists := s.sortedSets[key]
-	if !exists {
-		return -1, false
+	zset, err := s.zsetAt(key)
+	if zset == nil {
+		return -1, false, err
 	}
-	return zset.RevRank(member)
+	rank, ok := zset.RevRank(member)
+	return rank, ok, nil
 }
 
 // ZRange returns members by rank range.
-func (s *Store) ZRange(key string, start, stop int, withScores bool) []ScoredMember {
+func (s *Store) ZRange(key string, start, stop int, withScores bool) ([]ScoredMember, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	zset, exists := s.sortedSets[key]
-	if !exists {
-		return nil
+	zset, err := s.zsetAt(key)
+	if zset == nil {
+		return nil, err
 	}
-	return zset.Range(start, stop, withScores)
+	return zset.Range(start, stop, withScores), nil
 }
 
 // ZRevRange returns members by rank range in reverse order.
-func (s *Store) ZRevRange(key string, start, stop int, withScores bool) []ScoredMember {
+func (s *Store) ZRevRange(key string, start, stop int, withScores bool) ([]ScoredMember, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	zset, exists := s.sortedSets[key]
-	if !exists {
-		return nil
+	zset, err := s.zsetAt(key)
+	if zset == nil {
+		return nil, err
 	}
-	return zset.RevRange(start, stop, withScores)
+	return zset.RevRange(start, stop, withScores), nil
 }
 
 // ZRangeByScore returns members with scores in range.
-func (s *Store) ZRangeByScore(key string, min, max float64, withScores bool, offset, count int) []ScoredMember {
+func (s *Store) ZRangeByScore(key string, min, max float64, withScores bool, offset, count int) ([]ScoredMember, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	zset, exists := s.sortedSets[key]
-	if !exists {
-		return nil
+	zset, err := s.zsetAt(key)
+	if zset == nil {
+		return nil, err
 	}
-	return zset.RangeByScore(min, max, withScores, offset, count)
+	return zset.RangeByScore(min, max, withScores, offset, count), nil
 }
 
 // ZRevRangeByScore returns members with scores in range, descending.
-func (s *Store) ZRevRangeByScore(key string, max, min float64, withScores bool, offset, count int) []ScoredMember {
+func (s *Store) ZRevRangeByScore(key string, max, min float64, withScores bool, offset, count int) ([]ScoredMember, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	zset, exists := s.sortedSets[key]
-	if !exists {
-		return nil
+	zset, err := s.zsetAt(key)
+	if zset == nil {
+		return nil, err
 	}
-	return zset.RevRangeByScore(max, min, withScores, offset, count)
+	return zset.RevRangeByScore(max, min, withScores, offset, count), nil
 }
 
 // ZCount returns count of members with scores in range.
-func (s *Store) ZCount(key string, min, max float64) int {
+func (s *Store) ZCount(key string, min, max float64) (int, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	zset, exists := s.sortedSets[key]
-	if !exists {
-		return 0
+	zset, err := s.zsetAt(key)
+	if zset == nil {
+		return 0, err
 	}
-	return zset.Count(min, max)
+	return zset.Count(min, max), nil
 }
 
 // ZIncrBy increments score of member. Returns new score.
-func (s *Store) ZIncrBy(key, member string, increment float64) float64 {
+func (s *Store) ZIncrBy(key, member string, increment float64) (float64, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	zset, exists := s.sortedSets[key]
-	if !exists {
-		zset = NewSortedSet()
-		s.sortedSets[key] = zset
+	obj, err := s.lookupOrCreate(key, TypeZSet)
+	if err != nil {
+		return 0, err
 	}
-	return zset.IncrBy(member, increment)
+	return obj.zset.IncrBy(member, increment), nil
 }
 
 // ZRemRangeByRank removes members by rank range.
-func (s *Store) ZRemRangeByRank(key string, start, stop int) int {
+func (s *Store) ZRemRangeByRank(key string, start, stop int) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	zset, exists := s.sortedSets[key]
-	if !exists {
-		return 0
+	zset, err := s.zsetAt(key)
+	if zset == nil {
+		return 0, err
 	}
 	removed := zset.RemoveRangeByRank(start, stop)
-	if zset.Card() == 0 {
-		delete(s.sortedSets, key)
-	}
-	return removed
+	s.removeIfEmpty(key, zset.Card())
+	return removed, nil
 }
 
 // ZRemRangeByScore removes members by score range.
-func (s *Store) ZRemRangeByScore(key string, min, max float64) int {
+func (s *Store) ZRemRangeByScore(key string, min, max float64) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	zset, exists := s.sortedSets[key]
-	if !exists {
-		return 0
+	zset, err := s.zsetAt(key)
+	if zset == nil {
+		return 0, err
 	}
 	removed := zset.RemoveRangeByScore(min, max)
-	if zset.Card() == 0 {
-		delete(s.sortedSets, key)
-	}
-	return removed
+	s.removeIfEmpty(key, zset.Card())
+	return removed, nil
 }
 
 // ZPopMin removes and returns lowest-scoring members.
-func (s *Store) ZPopMin(key string, count int) []ScoredMember {
+func (s *Store) ZPopMin(key string, count int) ([]ScoredMember, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	zset, exists := s.sortedSets[key]
-	if !exists {
-		return nil
+	zset, err := s.zsetAt(key)
+	if zset == nil {
+		return nil, err
 	}
 	result := zset.PopMin(count)
-	if zset.Card() == 0 {
-		delete(s.sortedSets, key)
-	}
-	return result
+	s.removeIfEmpty(key, zset.Card())
+	return result, nil
 }
 
 // ZPopMax removes and returns highest-scoring members.
-func (s *Store) ZPopMax(key string, count int) []ScoredMember {
+func (s *Store) ZPopMax(key string, count int) ([]ScoredMember, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	zset, exists := s.sortedSets[key]
-	if !exists {
-		return nil
+	zset, err := s.zsetAt(key)
+	if zset == nil {
+		return nil, err
 	}
 	result := zset.PopMax(count)
-	if zset.Card() == 0 {
-		delete(s.sortedSets, key)
-	}
-	return result
-}
-
-// ZExists checks if a sorted set exists.
-func (s *Store) ZExists(key string) bool {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	_, exists := s.sortedSets[key]
-	return exists
+	s.removeIfEmpty(key, zset.Card())
+	return result, nil
 }
 
 // ========================
@@ -670,497 +1228,899 @@ func formatFloat64(f float64) []byte {
 // Hash Operations
 // ========================
 
+// hashAt returns the hash at key, or nil if the key does not exist (must hold lock).
+func (s *Store) hashAt(key string) (*Hash, error) {
+	obj, err := s.lookupType(key, TypeHash)
+	if obj == nil {
+		return nil, err
+	}
+	return obj.hash, nil
+}
+
 // HSet sets field(s) in a hash. Returns number of new fields added.
-func (s *Store) HSet(key string, fields ...HashFieldValue) int {
+func (s *Store) HSet(key string, fields ...HashFieldValue) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	h, exists := s.hashes[key]
-	if !exists {
-		h = NewHash()
-		s.hashes[key] = h
+	obj, err := s.lookupOrCreate(key, TypeHash)
+	if err != nil {
+		return 0, err
 	}
 	added := 0
 	for _, fv := range fields {
-		if h.Set(fv.Field, fv.Value) {
+		if obj.hash.Set(fv.Field, fv.Value) {
 			added++
 		}
 	}
-	return added
+	return added, nil
 }
 
 // HGet returns the value of a hash field.
-func (s *Store) HGet(key, field string) ([]byte, bool) {
+func (s *Store) HGet(key, field string) ([]byte, bool, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	h, exists := s.hashes[key]
-	if !exists {
-		return nil, false
+	h, err := s.hashAt(key)
+	if h == nil {
+		return nil, false, err
 	}
-	return h.Get(field)
+	val, ok := h.Get(field)
+	return val, ok, nil
 }
 
 // HDel removes field(s) from a hash. Returns number removed.
-func (s *Store) HDel(key string, fields ...string) int {
+func (s *Store) HDel(key string, fields ...string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	h, exists := s.hashes[key]
-	if !exists {
-		return 0
+	h, err := s.hashAt(key)
+	if h == nil {
+		return 0, err
 	}
 	removed := h.Del(fields...)
-	if h.Len() == 0 {
-		delete(s.hashes, key)
-	}
-	return removed
+	s.removeIfEmpty(key, h.Len())
+	return removed, nil
 }
 
 // HExists returns whether a field exists in a hash.
-func (s *Store) HExists(key, field string) bool {
+func (s *Store) HExists(key, field string) (bool, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	h, exists := s.hashes[key]
-	if !exists {
-		return false
+	h, err := s.hashAt(key)
+	if h == nil {
+		return false, err
 	}
-	return h.Exists(field)
+	return h.Exists(field), nil
 }
 
 // HLen returns the number of fields in a hash.
-func (s *Store) HLen(key string) int {
+func (s *Store) HLen(key string) (int, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	h, exists := s.hashes[key]
-	if !exists {
-		return 0
+	h, err := s.hashAt(key)
+	if h == nil {
+		return 0, err
 	}
-	return h.Len()
+	return h.Len(), nil
 }
 
 // HGetAll returns all field-value pairs in a hash.
-func (s *Store) HGetAll(key string) []HashFieldValue {
+func (s *Store) HGetAll(key string) ([]HashFieldValue, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	h, exists := s.hashes[key]
-	if !exists {
-		return nil
+	h, err := s.hashAt(key)
+	if h == nil {
+		return nil, err
 	}
-	return h.GetAll()
+	return h.GetAll(), nil
 }
 
 // HKeys returns all field names in a hash.
-func (s *Store) HKeys(key string) []string {
+func (s *Store) HKeys(key string) ([]string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	h, exists := s.hashes[key]
-	if !exists {
-		return nil
+	h, err := s.hashAt(key)
+	if h == nil {
+		return nil, err
 	}
-	return h.Keys()
+	return h.Keys(), nil
 }
 
 // HVals returns all values in a hash.
-func (s *Store) HVals(key string) [][]byte {
+func (s *Store) HVals(key string) ([][]byte, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	h, exists := s.hashes[key]
-	if !exists {
-		return nil
+	h, err := s.hashAt(key)
+	if h == nil {
+		return nil, err
 	}
-	return h.Vals()
+	return h.Vals(), nil
 }
 
 // HIncrBy increments integer value of a hash field.
 func (s *Store) HIncrBy(key, field string, delta int64) (int64, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	h, exists := s.hashes[key]
-	if !exists {
-		h = NewHash()
-		s.hashes[key] = h
+	obj, err := s.lookupOrCreate(key, TypeHash)
+	if err != nil {
+		return 0, err
 	}
-	return h.IncrBy(field, delta)
+	return obj.hash.IncrBy(field, delta)
 }
 
 // HIncrByFloat increments float value of a hash field.
 func (s *Store) HIncrByFloat(key, field string, delta float64) (float64, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	h, exists := s.hashes[key]
-	if !exists {
-		h = NewHash()
-		s.hashes[key] = h
+	obj, err := s.lookupOrCreate(key, TypeHash)
+	if err != nil {
+		return 0, err
 	}
-	return h.IncrByFloat(field, delta)
+	return obj.hash.IncrByFloat(field, delta)
 }
 
 // HSetNX sets a field only if it does not exist. Returns true if set.
-func (s *Store) HSetNX(key, field string, value []byte) bool {
+func (s *Store) HSetNX(key, field string, value []byte) (bool, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	h, exists := s.hashes[key]
-	if !exists {
-		h = NewHash()
-		s.hashes[key] = h
+	obj, err := s.lookupOrCreate(key, TypeHash)
+	if err != nil {
+		return false, err
 	}
-	return h.SetNX(field, value)
-}
-
-// HashExists checks if a hash key exists.
-func (s *Store) HashExists(key string) bool {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	_, exists := s.hashes[key]
-	return exists
+	return obj.hash.SetNX(field, value), nil
 }
 
 // ========================
 // List Operations
 // ========================
 
+// listAt returns the list at key, or nil if the key does not exist (must hold lock).
+func (s *Store) listAt(key string) (*List, error) {
+	obj, err := s.lookupType(key, TypeList)
+	if obj == nil {
+		return nil, err
+	}
+	return obj.list, nil
+}
+
 // LPush prepends values to a list. Returns new length.
-func (s *Store) LPush(key string, values ...[]byte) int {
+func (s *Store) LPush(key string, values ...[]byte) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	l, exists := s.lists[key]
-	if !exists {
-		l = NewList()
-		s.lists[key] = l
+	obj, err := s.lookupOrCreate(key, TypeList)
+	if err != nil {
+		return 0, err
 	}
-	return l.LPush(values...)
+	return obj.list.LPush(values...), nil
 }
 
 // RPush appends values to a list. Returns new length.
-func (s *Store) RPush(key string, values ...[]byte) int {
+func (s *Store) RPush(key string, values ...[]byte) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	l, exists := s.lists[key]
-	if !exists {
-		l = NewList()
-		s.lists[key] = l
+	obj, err := s.lookupOrCreate(key, TypeList)
+	if err != nil {
+		return 0, err
 	}
-	return l.RPush(values...)
+	return obj.list.RPush(values...), nil
 }
 
 // LPop removes and returns the first element.
-func (s *Store) LPop(key string) ([]byte, bool) {
+func (s *Store) LPop(key string) ([]byte, bool, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	l, exists := s.lists[key]
-	if !exists {
-		return nil, false
+	l, err := s.listAt(key)
+	if l == nil {
+		return nil, false, err
 	}
 	val, ok := l.LPop()
-	if l.Len() == 0 {
-		delete(s.lists, key)
-	}
-	return val, ok
+	s.removeIfEmpty(key, l.Len())
+	return val, ok, nil
 }
 
 // RPop removes and returns the last element.
-func (s *Store) RPop(key string) ([]byte, bool) {
+func (s *Store) RPop(key string) ([]byte, bool, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	l, exists := s.lists[key]
-	if !exists {
-		return nil, false
+	l, err := s.listAt(key)
+	if l == nil {
+		return nil, false, err
 	}
 	val, ok := l.RPop()
-	if l.Len() == 0 {
-		delete(s.lists, key)
-	}
-	return val, ok
+	s.removeIfEmpty(key, l.Len())
+	return val, ok, nil
 }
 
 // LLen returns the length of a list.
-func (s *Store) LLen(key string) int {
+func (s *Store) LLen(key string) (int, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	l, exists := s.lists[key]
-	if !exists {
-		return 0
+	l, err := s.listAt(key)
+	if l == nil {
+		return 0, err
 	}
-	return l.Len()
+	return l.Len(), nil
 }
 
 // LIndex returns the element at the given index.
-func (s *Store) LIndex(key string, index int) ([]byte, bool) {
+func (s *Store) LIndex(key string, index int) ([]byte, bool, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	l, exists := s.lists[key]
-	if !exists {
-		return nil, false
+	l, err := s.listAt(key)
+	if l == nil {
+		return nil, false, err
 	}
-	return l.Index(index)
+	val, ok := l.Index(index)
+	return val, ok, nil
 }
 
 // LSet sets the element at index.
 func (s *Store) LSet(key string, index int, value []byte) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	l, exists := s.lists[key]
-	if !exists {
+	l, err := s.listAt(key)
+	if err != nil {
+		return err
+	}
+	if l == nil {
 		return fmt.Errorf("no such key")
 	}
 	return l.Set(index, value)
 }
 
 // LRange returns elements from start to stop.
-func (s *Store) LRange(key string, start, stop int) [][]byte {
+func (s *Store) LRange(key string, start, stop int) ([][]byte, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	l, exists := s.lists[key]
-	if !exists {
-		return nil
+	l, err := s.listAt(key)
+	if l == nil {
+		return nil, err
 	}
-	return l.Range(start, stop)
+	return l.Range(start, stop), nil
 }
 
 // LInsert inserts value before/after pivot.
-func (s *Store) LInsert(key string, before bool, pivot, value []byte) int {
+func (s *Store) LInsert(key string, before bool, pivot, value []byte) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	l, exists := s.lists[key]
-	if !exists {
-		return 0
+	l, err := s.listAt(key)
+	if l == nil {
+		return 0, err
 	}
-	return l.Insert(before, pivot, value)
+	return l.Insert(before, pivot, value), nil
 }
 
 // LRem removes count occurrences of value.
-func (s *Store) LRem(key string, count int, value []byte) int {
+func (s *Store) LRem(key string, count int, value []byte) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	l, exists := s.lists[key]
-	if !exists {
-		return 0
+	l, err := s.listAt(key)
+	if l == nil {
+		return 0, err
 	}
 	removed := l.Rem(count, value)
-	if l.Len() == 0 {
-		delete(s.lists, key)
-	}
-	return removed
+	s.removeIfEmpty(key, l.Len())
+	return removed, nil
 }
 
 // LTrim trims the list to elements between start and stop.
-func (s *Store) LTrim(key string, start, stop int) {
+func (s *Store) LTrim(key string, start, stop int) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	l, exists := s.lists[key]
-	if !exists {
-		return
+	l, err := s.listAt(key)
+	if l == nil {
+		return err
 	}
 	l.Trim(start, stop)
-	if l.Len() == 0 {
-		delete(s.lists, key)
-	}
-}
-
-// ListExists checks if a list key exists.
-func (s *Store) ListExists(key string) bool {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	_, exists := s.lists[key]
-	return exists
+	s.removeIfEmpty(key, l.Len())
+	return nil
 }
 
 // ========================
 // Set Operations
 // ========================
 
+// setAt returns the set at key, or nil if the key does not exist (must hold lock).
+func (s *Store) setAt(key string) (*Set, error) {
+	obj, err := s.lookupType(key, TypeSet)
+	if obj == nil {
+		return nil, err
+	}
+	return obj.set, nil
+}
+
 // SAdd adds members to a set. Returns number added.
-func (s *Store) SAdd(key string, members ...string) int {
+func (s *Store) SAdd(key string, members ...string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	set, exists := s.sets[key]
-	if !exists {
-		set = NewSet()
-		s.sets[key] = set
+	obj, err := s.lookupOrCreate(key, TypeSet)
+	if err != nil {
+		return 0, err
 	}
-	return set.Add(members...)
+	return obj.set.Add(members...), nil
 }
 
 // SRem removes members from a set. Returns number removed.
-func (s *Store) SRem(key string, members ...string) int {
+func (s *Store) SRem(key string, members ...string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	set, exists := s.sets[key]
-	if !exists {
-		return 0
+	set, err := s.setAt(key)
+	if set == nil {
+		return 0, err
 	}
 	removed := set.Rem(members...)
-	if set.Card() == 0 {
-		delete(s.sets, key)
-	}
-	return removed
+	s.removeIfEmpty(key, set.Card())
+	return removed, nil
 }
 
 // SIsMember returns whether a member exists in a set.
-func (s *Store) SIsMember(key, member string) bool {
+func (s *Store) SIsMember(key, member string) (bool, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	set, exists := s.sets[key]
-	if !exists {
-		return false
+	set, err := s.setAt(key)
+	if set == nil {
+		return false, err
 	}
-	return set.IsMember(member)
+	return set.IsMember(member), nil
 }
 
 // SCard returns the cardinality of a set.
-func (s *Store) SCard(key string) int {
+func (s *Store) SCard(key string) (int, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	set, exists := s.sets[key]
-	if !exists {
-		return 0
+	set, err := s.setAt(key)
+	if set == nil {
+		return 0, err
 	}
-	return set.Card()
+	return set.Card(), nil
 }
 
 // SMembers returns all members of a set.
-func (s *Store) SMembers(key string) []string {
+func (s *Store) SMembers(key string) ([]string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	set, exists := s.sets[key]
-	if !exists {
-		return nil
+	set, err := s.setAt(key)
+	if set == nil {
+		return nil, err
 	}
-	return set.Members()
+	return set.Members(), nil
 }
 
 // SRandMember returns random member(s) from a set.
-func (s *Store) SRandMember(key string, count int) []string {
+func (s *Store) SRandMember(key string, count int) ([]string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	set, exists := s.sets[key]
-	if !exists {
-		return nil
+	set, err := s.setAt(key)
+	if set == nil {
+		return nil, err
 	}
-	return set.RandMember(count)
+	return set.RandMember(count), nil
 }
 
 // SPop removes and returns random member(s).
-func (s *Store) SPop(key string, count int) []string {
+func (s *Store) SPop(key string, count int) ([]string, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.cow(key)
 
-	set, exists := s.sets[key]
-	if !exists {
-		return nil
+	set, err := s.setAt(key)
+	if set == nil {
+		return nil, err
 	}
 	result := set.Pop(count)
-	if set.Card() == 0 {
-		delete(s.sets, key)
+	s.removeIfEmpty(key, set.Card())
+	return result, nil
+}
+
+// setsAt resolves every key to its set, leaving nil entries for missing keys (must hold lock).
+func (s *Store) setsAt(keys []string) ([]*Set, error) {
+	sets := make([]*Set, len(keys))
+	for i, key := range keys {
+		set, err := s.setAt(key)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = set
 	}
-	return result
+	return sets, nil
 }
 
 // SInter returns intersection of multiple sets.
-func (s *Store) SInter(keys ...string) []string {
+func (s *Store) SInter(keys ...string) ([]string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	if len(keys) == 0 {
-		return nil
+		return nil, nil
 	}
 
-	first, exists := s.sets[keys[0]]
-	if !exists {
-		return nil
+	sets, err := s.setsAt(keys)
+	if err != nil {
+		return nil, err
 	}
-
-	others := make([]*Set, 0, len(keys)-1)
-	for _, key := range keys[1:] {
-		other, exists := s.sets[key]
-		if !exists {
-			return nil // Intersection with empty set is empty
+	for _, set := range sets {
+		if set == nil {
+			return nil, nil // Intersection with empty set is empty
 		}
-		others = append(others, other)
 	}
-	return first.Inter(others...)
+	return sets[0].Inter(sets[1:]...), nil
 }
 
 // SUnion returns union of multiple sets.
-func (s *Store) SUnion(keys ...string) []string {
+func (s *Store) SUnion(keys ...string) ([]string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	if len(keys) == 0 {
-		return nil
+		return nil, nil
 	}
 
-	first, exists := s.sets[keys[0]]
-	if !exists {
+	sets, err := s.setsAt(keys)
+	if err != nil {
+		return nil, err
+	}
+	first := sets[0]
+	if first == nil {
 		first = NewSet() // Treat missing key as empty set
 	}
+	return first.Union(sets[1:]...), nil
+}
 
-	others := make([]*Set, 0, len(keys)-1)
-	for _, key := range keys[1:] {
-		other, exists := s.sets[key]
-		if !exists {
-			continue
+// SDiff returns members in first set not in any others.
+func (s *Store) SDiff(keys ...string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	sets, err := s.setsAt(keys)
+	if err != nil {
+		return nil, err
+	}
+	if sets[0] == nil {
+		return nil, nil
+	}
+	return sets[0].Diff(sets[1:]...), nil
+}
+
+// ========================
+// Stream Operations
+// ========================
+
+// streamAt returns the stream at key, or nil if the key does not exist (must hold lock).
+func (s *Store) streamAt(key string) (*Stream, error) {
+	obj, err := s.lookupType(key, TypeStream)
+	if obj == nil {
+		return nil, err
+	}
+	return obj.stream, nil
+}
+
+// groupStream returns the stream at key if it has the consumer group,
+// and ErrNoGroup otherwise (must hold lock).
+func (s *Store) groupStream(key, group string) (*Stream, error) {
+	st, err := s.streamAt(key)
+	if err != nil {
+		return nil, err
+	}
+	if st == nil {
+		return nil, ErrNoGroup
+	}
+	if _, ok := st.GroupLastID(group); !ok {
+		return nil, ErrNoGroup
+	}
+	return st, nil
+}
+
+// XAdd appends an entry to the stream at key, creating the stream if
+// needed. The ID must be greater than every ID in the stream.
+func (s *Store) XAdd(key string, id StreamID, fields [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cow(key)
+
+	obj, err := s.lookupOrCreate(key, TypeStream)
+	if err != nil {
+		return err
+	}
+	obj.stream.Add(id, fields)
+	return nil
+}
+
+// XSetID sets the highest ID added to the stream at key, creating an empty
+// stream if needed.
+func (s *Store) XSetID(key string, id StreamID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cow(key)
+
+	obj, err := s.lookupOrCreate(key, TypeStream)
+	if err != nil {
+		return err
+	}
+	obj.stream.SetLastID(id)
+	return nil
+}
+
+// XLastID returns the highest ID added to the stream at key, and whether
+// the stream exists.
+func (s *Store) XLastID(key string) (StreamID, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	st, err := s.streamAt(key)
+	if st == nil {
+		return StreamID{}, false, err
+	}
+	return st.LastID(), true, nil
+}
+
+// XLen returns the number of entries in a stream.
+func (s *Store) XLen(key string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	st, err := s.streamAt(key)
+	if st == nil {
+		return 0, err
+	}
+	return st.Len(), nil
+}
+
+// XRange returns up to count entries (0 = all) with IDs in [start, end],
+// in descending order if rev is set.
+func (s *Store) XRange(key string, start, end StreamID, count int, rev bool) ([]StreamEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	st, err := s.streamAt(key)
+	if st == nil {
+		return nil, err
+	}
+	return st.Range(start, end, count, rev), nil
+}
+
+// XRead returns up to count entries (0 = all) with IDs greater than after.
+func (s *Store) XRead(key string, after StreamID, count int) ([]StreamEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	st, err := s.streamAt(key)
+	if st == nil {
+		return nil, err
+	}
+	return st.After(after, count), nil
+}
+
+// XEntry returns the entry with the given ID.
+func (s *Store) XEntry(key string, id StreamID) (StreamEntry, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	st, err := s.streamAt(key)
+	if st == nil {
+		return StreamEntry{}, false, err
+	}
+	entry, ok := st.Get(id)
+	return entry, ok, nil
+}
+
+// XDel removes entries by ID. Returns the number removed.
+func (s *Store) XDel(key string, ids ...StreamID) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cow(key)
+
+	st, err := s.streamAt(key)
+	if st == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, id := range ids {
+		if st.Delete(id) {
+			removed++
 		}
-		others = append(others, other)
 	}
-	return first.Union(others...)
+	return removed, nil
 }
 
-// SDiff returns members in first set not in any others.
-func (s *Store) SDiff(keys ...string) []string {
+// XTrimMaxLen removes the oldest entries until at most n remain.
+// Returns the number removed.
+func (s *Store) XTrimMaxLen(key string, n int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cow(key)
+
+	st, err := s.streamAt(key)
+	if st == nil {
+		return 0, err
+	}
+	return st.TrimMaxLen(n), nil
+}
+
+// XTrimMinID removes the entries with IDs lower than id.
+// Returns the number removed.
+func (s *Store) XTrimMinID(key string, id StreamID) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cow(key)
+
+	st, err := s.streamAt(key)
+	if st == nil {
+		return 0, err
+	}
+	return st.TrimMinID(id), nil
+}
+
+// XGroupCreate creates a consumer group that has been delivered everything
+// up to id. With mkstream, a missing stream is created empty.
+func (s *Store) XGroupCreate(key, group string, id StreamID, mkstream bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cow(key)
+
+	st, err := s.streamAt(key)
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		if !mkstream {
+			return ErrNoStream
+		}
+		obj, _ := s.lookupOrCreate(key, TypeStream)
+		st = obj.stream
+	}
+	if !st.CreateGroup(group, id) {
+		return ErrBusyGroup
+	}
+	return nil
+}
+
+// XGroupDestroy removes a consumer group. Returns true if it existed.
+func (s *Store) XGroupDestroy(key, group string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cow(key)
+
+	st, err := s.streamAt(key)
+	if st == nil {
+		return false, err
+	}
+	return st.DestroyGroup(group), nil
+}
+
+// XGroupLastID returns the last ID delivered to a consumer group.
+func (s *Store) XGroupLastID(key, group string) (StreamID, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if len(keys) == 0 {
-		return nil
+	st, err := s.groupStream(key, group)
+	if err != nil {
+		return StreamID{}, err
 	}
+	id, _ := st.GroupLastID(group)
+	return id, nil
+}
 
-	first, exists := s.sets[keys[0]]
-	if !exists {
-		return nil
+// XGroupSetID sets the last ID delivered to a consumer group.
+func (s *Store) XGroupSetID(key, group string, id StreamID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cow(key)
+
+	st, err := s.groupStream(key, group)
+	if err != nil {
+		return err
 	}
+	st.SetGroupLastID(group, id)
+	return nil
+}
 
-	others := make([]*Set, 0, len(keys)-1)
-	for _, key := range keys[1:] {
-		other, exists := s.sets[key]
-		if !exists {
-			continue
+// XHasConsumer reports whether a consumer group has the named consumer.
+func (s *Store) XHasConsumer(key, group, consumer string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	st, err := s.groupStream(key, group)
+	if err != nil {
+		return false, err
+	}
+	return st.HasConsumer(group, consumer), nil
+}
+
+// XGroupCreateConsumer adds a consumer to a group. Returns true if it was
+// created.
+func (s *Store) XGroupCreateConsumer(key, group, consumer string, seenAt int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cow(key)
+
+	st, err := s.groupStream(key, group)
+	if err != nil {
+		return false, err
+	}
+	return st.CreateConsumer(group, consumer, seenAt), nil
+}
+
+// XGroupDelConsumer removes a consumer and its pending entries from a group.
+// Returns the number of pending entries it had.
+func (s *Store) XGroupDelConsumer(key, group, consumer string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cow(key)
+
+	st, err := s.groupStream(key, group)
+	if err != nil {
+		return 0, err
+	}
+	return st.DeleteConsumer(group, consumer), nil
+}
+
+// XDeliver records the delivery of entry id to a consumer, see Stream.Deliver.
+func (s *Store) XDeliver(key, group, consumer string, id StreamID, at, deliveries int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cow(key)
+
+	st, err := s.groupStream(key, group)
+	if err != nil {
+		return err
+	}
+	st.Deliver(group, consumer, id, at, deliveries)
+	return nil
+}
+
+// XAck acknowledges pending entries of a group. Returns the number that
+// were pending.
+func (s *Store) XAck(key, group string, ids ...StreamID) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cow(key)
+
+	st, err := s.groupStream(key, group)
+	if err != nil {
+		return 0, err
+	}
+	acked := 0
+	for _, id := range ids {
+		if st.Ack(group, id) {
+			acked++
 		}
-		others = append(others, other)
 	}
-	return first.Diff(others...)
+	return acked, nil
 }
 
-// SetExists checks if a set key exists.
-func (s *Store) SetExists(key string) bool {
+// XPending returns the pending entry for id in a group.
+func (s *Store) XPending(key, group string, id StreamID) (PendingEntry, bool, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	_, exists := s.sets[key]
-	return exists
+
+	st, err := s.groupStream(key, group)
+	if err != nil {
+		return PendingEntry{}, false, err
+	}
+	pe, ok := st.Pending(group, id)
+	return pe, ok, nil
+}
+
+// XPendingRange returns pending entries of a group, see Stream.PendingRange.
+func (s *Store) XPendingRange(key, group string, start, end StreamID, count int, consumer string, deliveredBy int64) ([]PendingEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	st, err := s.groupStream(key, group)
+	if err != nil {
+		return nil, err
+	}
+	return st.PendingRange(group, start, end, count, consumer, deliveredBy), nil
+}
+
+// XPendingSummary summarizes the pending entries of a group.
+func (s *Store) XPendingSummary(key, group string) (PendingSummary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	st, err := s.groupStream(key, group)
+	if err != nil {
+		return PendingSummary{}, err
+	}
+	return st.PendingSummary(group), nil
+}
+
+// ========================
+// JSON Operations
+// ========================
+
+// JSONRead calls fn with the document at key, or with nil if the key does
+// not exist, under the read lock. fn must not keep the document.
+func (s *Store) JSONRead(key string, fn func(doc *JSON) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, err := s.lookupType(key, TypeJSON)
+	if err != nil {
+		return err
+	}
+	var doc *JSON
+	if obj != nil {
+		doc = obj.json
+	}
+	return fn(doc)
+}
+
+// JSONApply applies an edit planned by one of the JSON Plan methods to the
+// document at key. Setting the root creates the document or replaces it,
+// keeping its TTL; deleting the root deletes the key.
+func (s *Store) JSONApply(key string, edit JSONEdit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cow(key)
+
+	if edit.Path == "$" && edit.Kind == JSONEditSet && len(edit.Values) == 1 {
+		v, err := parseJSON(edit.Values[0])
+		if err != nil {
+			return err
+		}
+		obj, err := s.lookupOrCreate(key, TypeJSON)
+		if err != nil {
+			return err
+		}
+		obj.json.root = v
+		return nil
+	}
+
+	obj, err := s.lookupType(key, TypeJSON)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		return ErrJSONNoKey
+	}
+	if edit.Path == "$" && edit.Kind == JSONEditDelete {
+		delete(s.data, key)
+		return nil
+	}
+	return obj.json.apply(edit)
 }
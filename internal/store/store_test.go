@@ -13,11 +13,11 @@ func TestStore_SetAndGet(t *testing.T) {
 	defer s.Close()
 
 	s.Set("key1", []byte("value1"))
-	val, ok := s.Get("key1")
+	val, ok, _ := s.Get("key1")
 	assert.True(t, ok)
 	assert.Equal(t, []byte("value1"), val)
 
-	val, ok = s.Get("nonexistent")
+	val, ok, _ = s.Get("nonexistent")
 	assert.False(t, ok)
 	assert.Nil(t, val)
 }
@@ -30,7 +30,7 @@ func TestStore_Delete(t *testing.T) {
 	deleted := s.Delete("key1")
 	assert.True(t, deleted)
 
-	val, ok := s.Get("key1")
+	val, ok, _ := s.Get("key1")
 	assert.False(t, ok)
 	assert.Nil(t, val)
 }
@@ -41,13 +41,13 @@ func TestStore_TTL(t *testing.T) {
 
 	s.SetWithTTL("key1", []byte("value1"), 100*time.Millisecond)
 
-	val, ok := s.Get("key1")
+	val, ok, _ := s.Get("key1")
 	assert.True(t, ok)
 	assert.Equal(t, []byte("value1"), val)
 
 	time.Sleep(150 * time.Millisecond)
 
-	_, ok = s.Get("key1")
+	_, ok, _ = s.Get("key1")
 	assert.False(t, ok)
 }
 
@@ -88,7 +88,7 @@ func TestStore_SetCopiesInput(t *testing.T) {
 	s.Set("key1", source)
 	source[0] = 'X'
 
-	val, ok := s.Get("key1")
+	val, ok, _ := s.Get("key1")
 	assert.True(t, ok)
 	assert.Equal(t, []byte("value1"), val)
 }
@@ -105,7 +105,7 @@ func TestStore_GetEntryReturnsCopy(t *testing.T) {
 	entry.Value[0] = 'X'
 	entry.HasExpire = false
 
-	val, ok := s.Get("key1")
+	val, ok, _ := s.Get("key1")
 	assert.True(t, ok)
 	assert.Equal(t, []byte("value1"), val)
 	assert.True(t, s.TTL("key1") > 0)
@@ -125,8 +125,209 @@ func TestStore_SetEntryCopiesInput(t *testing.T) {
 	entry.Value[0] = 'X'
 	entry.HasExpire = false
 
-	val, ok := s.Get("key1")
+	val, ok, _ := s.Get("key1")
 	assert.True(t, ok)
 	assert.Equal(t, []byte("value1"), val)
 	assert.True(t, s.TTL("key1") > 0)
 }
+
+func TestStore_WrongType(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	s.Set("str", []byte("v"))
+	_, err := s.HSet("h", HashFieldValue{Field: "f", Value: []byte("v")})
+	assert.NoError(t, err)
+
+	_, err = s.LPush("str", []byte("a"))
+	assert.ErrorIs(t, err, ErrWrongType)
+	_, _, err = s.Get("h")
+	assert.ErrorIs(t, err, ErrWrongType)
+	_, err = s.SMembers("h")
+	assert.ErrorIs(t, err, ErrWrongType)
+
+	assert.Equal(t, TypeString, s.Type("str"))
+	assert.Equal(t, TypeHash, s.Type("h"))
+	assert.Equal(t, TypeNone, s.Type("missing"))
+	assert.Equal(t, 2, s.Size())
+}
+
+func TestStore_RenameAndCopy(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	_, err := s.RPush("l", []byte("a"), []byte("b"))
+	assert.NoError(t, err)
+
+	assert.True(t, s.Rename("l", "l2"))
+	assert.False(t, s.Exists("l"))
+	assert.Equal(t, TypeList, s.Type("l2"))
+
+	assert.True(t, s.Copy("l2", "l3", false))
+	assert.False(t, s.Copy("l2", "l3", false))
+	_, err = s.RPush("l3", []byte("c"))
+	assert.NoError(t, err)
+
+	n, _ := s.LLen("l2")
+	assert.Equal(t, 2, n)
+	n, _ = s.LLen("l3")
+	assert.Equal(t, 3, n)
+}
+
+func TestStore_MoveTo(t *testing.T) {
+	src, dst := New(), New()
+	defer src.Close()
+	defer dst.Close()
+
+	src.SetWithTTL("k", []byte("v"), time.Hour)
+	src.Set("both", []byte("src"))
+	dst.Set("both", []byte("dst"))
+
+	// A view of the source keeps the moved value as it was.
+	view := src.OpenView()
+	defer view.Close()
+
+	assert.True(t, src.MoveTo("k", dst))
+	assert.False(t, src.Exists("k"))
+	_, hasTTL := dst.ExpireTime("k")
+	assert.True(t, hasTTL)
+	assert.Equal(t, 1, dst.Expires())
+	_, err := dst.Append("k", []byte("2"))
+	assert.NoError(t, err)
+	for i := 0; i < view.Len(); i++ {
+		if item := view.Item(i); item.Key == "k" {
+			assert.Equal(t, []byte("v"), item.Str)
+		}
+	}
+
+	assert.False(t, src.MoveTo("both", dst))
+	assert.False(t, src.MoveTo("missing", dst))
+	val, _, _ := dst.Get("both")
+	assert.Equal(t, []byte("dst"), val)
+}
+
+func TestStore_ExpireContainers(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	_, err := s.HSet("h", HashFieldValue{Field: "f", Value: []byte("v")})
+	assert.NoError(t, err)
+	_, err = s.ZAdd("z", ScoredMember{Member: "m", Score: 1})
+	assert.NoError(t, err)
+
+	assert.True(t, s.Expire("h", 50*time.Millisecond))
+	assert.True(t, s.Expire("z", time.Hour))
+	assert.True(t, s.TTL("h") > 0)
+	assert.True(t, s.Persist("z"))
+	assert.Equal(t, -1*time.Second, s.TTL("z"))
+
+	// Writes to a live container keep its TTL.
+	_, err = s.HSet("h", HashFieldValue{Field: "g", Value: []byte("w")})
+	assert.NoError(t, err)
+	assert.True(t, s.TTL("h") > 0)
+
+	time.Sleep(80 * time.Millisecond)
+
+	assert.False(t, s.Exists("h"))
+	assert.Equal(t, TypeNone, s.Type("h"))
+	_, ok, err := s.HGet("h", "f")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.True(t, s.ExpireIfNeeded("h"))
+	assert.False(t, s.ExpireIfNeeded("z"))
+}
+
+func TestStore_PauseExpiry(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	s.PauseExpiry(true)
+	s.SetWithTTL("k", []byte("v"), -time.Second)
+	assert.True(t, s.Exists("k"))
+
+	s.PauseExpiry(false)
+	assert.False(t, s.Exists("k"))
+}
+
+func TestStore_ViewIsPointInTime(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	s.Set("s", []byte("a"))
+	_, err := s.RPush("l", []byte("a"))
+	assert.NoError(t, err)
+	_, err = s.HSet("h", HashFieldValue{Field: "f", Value: []byte("v")})
+	assert.NoError(t, err)
+	assert.True(t, s.Expire("h", time.Hour))
+
+	v := s.OpenView()
+	assert.Equal(t, 3, v.Len())
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			s.Append("s", []byte("b"))
+			s.RPush("l", []byte("b"))
+			s.HSet("h", HashFieldValue{Field: "g", Value: []byte("w")})
+			s.Persist("h")
+			s.Set("new", []byte("x"))
+		}
+		s.Delete("s")
+		s.Rename("l", "l2")
+	}()
+
+	items := make(map[string]Item)
+	for i := 0; i < v.Len(); i++ {
+		item := v.Item(i)
+		items[item.Key] = item
+	}
+	wg.Wait()
+	for i := 0; i < v.Len(); i++ {
+		assert.Equal(t, items[v.Item(i).Key], v.Item(i))
+	}
+	v.Close()
+
+	assert.Equal(t, []byte("a"), items["s"].Str)
+	assert.Equal(t, [][]byte{[]byte("a")}, items["l"].List)
+	assert.Equal(t, []HashFieldValue{{Field: "f", Value: []byte("v")}}, items["h"].Hash)
+	assert.True(t, items["h"].HasExpire)
+	assert.NotContains(t, items, "new")
+
+	// Once closed, writers change objects in place again.
+	n, err := s.RPush("l2", []byte("c"))
+	assert.NoError(t, err)
+	assert.Equal(t, 102, n)
+}
+
+func TestStore_TrackChanges(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	s.Set("untracked", []byte("v"))
+	assert.Nil(t, s.TakeChanged())
+
+	s.TrackChanges(true)
+	_, err := s.HSet("h", HashFieldValue{Field: "f", Value: []byte("v")})
+	assert.NoError(t, err)
+	_, err = s.HDel("h", "f")
+	assert.NoError(t, err)
+	s.Set("a", []byte("1"))
+	assert.True(t, s.Rename("a", "b"))
+	assert.True(t, s.Copy("b", "c", false))
+	assert.ElementsMatch(t, []string{"h", "a", "b", "c"}, s.TakeChanged())
+	assert.Nil(t, s.TakeChanged())
+
+	s.SetWithTTL("gone", []byte("v"), -time.Second)
+	assert.True(t, s.ExpireIfNeeded("gone"))
+	assert.True(t, s.Delete("b"))
+	assert.ElementsMatch(t, []string{"gone", "b"}, s.TakeChanged())
+
+	s.Clear()
+	assert.ElementsMatch(t, []string{"untracked", "c"}, s.TakeChanged())
+
+	s.TrackChanges(false)
+	s.Set("x", []byte("v"))
+	assert.Nil(t, s.TakeChanged())
+}
@@ -0,0 +1,553 @@
+// Package store - Stream data type implementation for FlashDB
+//
+// A Stream is an append-only log of field/value entries identified by
+// monotonically increasing IDs, equivalent to Redis Streams. Entries are
+// kept in ID order, so appends are O(1) and lookups by ID are O(log N).
+// Consumer groups track the last entry handed out and, for every consumer,
+// the entries delivered but not yet acknowledged (the pending entries list).
+package store
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidStreamID is returned for an ID that does not parse.
+	ErrInvalidStreamID = errors.New("Invalid stream ID specified as stream command argument")
+	// ErrNoGroup is returned when a stream or consumer group does not exist.
+	ErrNoGroup = errors.New("NOGROUP No such key or consumer group")
+	// ErrBusyGroup is returned when creating a group that already exists.
+	ErrBusyGroup = errors.New("BUSYGROUP Consumer Group name already exists")
+	// ErrNoStream is returned when creating a group on a missing stream
+	// without MKSTREAM.
+	ErrNoStream = errors.New("The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+	// ErrStreamIDTooSmall is returned when adding an entry whose ID is not
+	// greater than the stream's last ID.
+	ErrStreamIDTooSmall = errors.New("The ID specified in XADD is equal or smaller than the target stream top item")
+	// ErrStreamIDZero is returned when adding an entry with ID 0-0.
+	ErrStreamIDZero = errors.New("The ID specified in XADD must be greater than 0-0")
+	// ErrStreamSetIDTooSmall is returned when setting a stream's last ID
+	// below the ID of its last entry.
+	ErrStreamSetIDTooSmall = errors.New("The ID specified in XSETID is smaller than the target stream top item")
+	// ErrStreamExhausted is returned when a stream has used the largest ID.
+	ErrStreamExhausted = errors.New("The stream has exhausted the last possible ID, unable to add more items")
+)
+
+// StreamID identifies a stream entry by a millisecond time and a sequence
+// number within that millisecond.
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+// MaxStreamID is the largest possible stream ID.
+var MaxStreamID = StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+
+// ParseStreamID parses "<ms>-<seq>", or "<ms>" with seq as the sequence.
+func ParseStreamID(s string, seq uint64) (StreamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, ErrInvalidStreamID
+	}
+	if hasSeq {
+		if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return StreamID{}, ErrInvalidStreamID
+		}
+	}
+	return StreamID{Ms: ms, Seq: seq}, nil
+}
+
+// String formats the ID as "<ms>-<seq>".
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Less reports whether id sorts before o.
+func (id StreamID) Less(o StreamID) bool {
+	return id.Ms < o.Ms || (id.Ms == o.Ms && id.Seq < o.Seq)
+}
+
+// Next returns the smallest ID after id; it returns false for MaxStreamID.
+func (id StreamID) Next() (StreamID, bool) {
+	switch {
+	case id.Seq < math.MaxUint64:
+		return StreamID{Ms: id.Ms, Seq: id.Seq + 1}, true
+	case id.Ms < math.MaxUint64:
+		return StreamID{Ms: id.Ms + 1}, true
+	}
+	return id, false
+}
+
+// Prev returns the largest ID before id; it returns false for 0-0.
+func (id StreamID) Prev() (StreamID, bool) {
+	switch {
+	case id.Seq > 0:
+		return StreamID{Ms: id.Ms, Seq: id.Seq - 1}, true
+	case id.Ms > 0:
+		return StreamID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// StreamEntry is one entry of a stream. Fields holds field names and
+// values alternately. Entries never change once added, so copies handed
+// out by the store share their field data with the stream.
+type StreamEntry struct {
+	ID     StreamID
+	Fields [][]byte
+}
+
+// PendingEntry is an entry delivered to a consumer and not yet acknowledged.
+type PendingEntry struct {
+	ID          StreamID
+	Consumer    string
+	DeliveredAt int64 // unix milliseconds of the last delivery
+	Deliveries  int64
+}
+
+// PendingSummary describes the pending entries of a consumer group.
+type PendingSummary struct {
+	Count     int
+	Min, Max  StreamID
+	Consumers []ConsumerPending // consumers with pending entries, by name
+}
+
+// ConsumerPending is the number of pending entries of one consumer.
+type ConsumerPending struct {
+	Name    string
+	Pending int
+}
+
+// StreamData is a copy of a stream with its consumer groups.
+type StreamData struct {
+	Entries []StreamEntry
+	LastID  StreamID
+	Groups  []StreamGroupData
+}
+
+// StreamGroupData is a copy of a consumer group.
+type StreamGroupData struct {
+	Name      string
+	LastID    StreamID
+	Consumers []StreamConsumerData
+	Pending   []PendingEntry // ascending by ID
+}
+
+// StreamConsumerData is a copy of a consumer.
+type StreamConsumerData struct {
+	Name   string
+	SeenAt int64 // unix milliseconds
+}
+
+// Stream represents a Redis-like stream.
+// The Stream itself is NOT thread-safe; concurrency is managed by the Store.
+type Stream struct {
+	entries []StreamEntry
+	lastID  StreamID // highest ID ever added, even if since deleted
+	groups  map[string]*streamGroup
+	bytes   int // memory of the entries' fields, see entrySize
+}
+
+type streamGroup struct {
+	lastID    StreamID // last entry delivered to the group
+	pending   map[StreamID]*PendingEntry
+	order     []StreamID // pending IDs, ascending
+	consumers map[string]*streamConsumer
+}
+
+type streamConsumer struct {
+	seenAt  int64
+	pending int
+}
+
+// NewStream creates a new empty Stream.
+func NewStream() *Stream {
+	return &Stream{groups: make(map[string]*streamGroup)}
+}
+
+// Len returns the number of entries.
+func (s *Stream) Len() int {
+	return len(s.entries)
+}
+
+// LastID returns the highest ID ever added to the stream.
+func (s *Stream) LastID() StreamID {
+	return s.lastID
+}
+
+// SetLastID sets the highest ID added to the stream.
+func (s *Stream) SetLastID(id StreamID) {
+	s.lastID = id
+}
+
+// search returns the index of the first entry with an ID >= id.
+func (s *Stream) search(id StreamID) int {
+	return sort.Search(len(s.entries), func(i int) bool {
+		return !s.entries[i].ID.Less(id)
+	})
+}
+
+// Add appends an entry. id must be greater than every ID in the stream.
+func (s *Stream) Add(id StreamID, fields [][]byte) {
+	copied := make([][]byte, len(fields))
+	for i, f := range fields {
+		copied[i] = cloneBytes(f)
+	}
+	s.entries = append(s.entries, StreamEntry{ID: id, Fields: copied})
+	s.bytes += entrySize(copied)
+	if s.lastID.Less(id) {
+		s.lastID = id
+	}
+}
+
+// Range returns up to count entries (0 = all) with IDs in [start, end],
+// in descending order if rev is set.
+func (s *Stream) Range(start, end StreamID, count int, rev bool) []StreamEntry {
+	if end.Less(start) {
+		return nil
+	}
+	lo, hi := s.search(start), len(s.entries)
+	if next, ok := end.Next(); ok {
+		hi = s.search(next)
+	}
+	n := hi - lo
+	if count > 0 && count < n {
+		n = count
+	}
+	if n <= 0 {
+		return nil
+	}
+	result := make([]StreamEntry, n)
+	if rev {
+		for i := range result {
+			result[i] = s.entries[hi-1-i]
+		}
+	} else {
+		copy(result, s.entries[lo:lo+n])
+	}
+	return result
+}
+
+// After returns up to count entries (0 = all) with IDs greater than id.
+func (s *Stream) After(id StreamID, count int) []StreamEntry {
+	next, ok := id.Next()
+	if !ok {
+		return nil
+	}
+	return s.Range(next, MaxStreamID, count, false)
+}
+
+// Get returns the entry with the given ID.
+func (s *Stream) Get(id StreamID) (StreamEntry, bool) {
+	i := s.search(id)
+	if i == len(s.entries) || s.entries[i].ID != id {
+		return StreamEntry{}, false
+	}
+	return s.entries[i], true
+}
+
+// Delete removes the entry with the given ID and reports whether it existed.
+func (s *Stream) Delete(id StreamID) bool {
+	i := s.search(id)
+	if i == len(s.entries) || s.entries[i].ID != id {
+		return false
+	}
+	s.bytes -= entrySize(s.entries[i].Fields)
+	copy(s.entries[i:], s.entries[i+1:])
+	s.entries[len(s.entries)-1] = StreamEntry{}
+	s.entries = s.entries[:len(s.entries)-1]
+	return true
+}
+
+// TrimMaxLen removes the oldest entries until at most n remain and returns
+// how many were removed.
+func (s *Stream) TrimMaxLen(n int) int {
+	return s.trimHead(len(s.entries) - n)
+}
+
+// TrimMinID removes the entries with IDs lower than id and returns how many
+// were removed.
+func (s *Stream) TrimMinID(id StreamID) int {
+	return s.trimHead(s.search(id))
+}
+
+// trimHead removes the first n entries.
+func (s *Stream) trimHead(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	for _, e := range s.entries[:n] {
+		s.bytes -= entrySize(e.Fields)
+	}
+	clear(s.entries[:n])
+	s.entries = s.entries[n:]
+	return n
+}
+
+// CreateGroup adds a consumer group that has been delivered everything up
+// to lastID. It returns false if the group exists.
+func (s *Stream) CreateGroup(name string, lastID StreamID) bool {
+	if _, ok := s.groups[name]; ok {
+		return false
+	}
+	s.groups[name] = &streamGroup{
+		lastID:    lastID,
+		pending:   make(map[StreamID]*PendingEntry),
+		consumers: make(map[string]*streamConsumer),
+	}
+	return true
+}
+
+// DestroyGroup removes a consumer group and reports whether it existed.
+func (s *Stream) DestroyGroup(name string) bool {
+	if _, ok := s.groups[name]; !ok {
+		return false
+	}
+	delete(s.groups, name)
+	return true
+}
+
+// GroupCount returns the number of consumer groups.
+func (s *Stream) GroupCount() int {
+	return len(s.groups)
+}
+
+// GroupLastID returns the last ID delivered to a group.
+func (s *Stream) GroupLastID(name string) (StreamID, bool) {
+	g, ok := s.groups[name]
+	if !ok {
+		return StreamID{}, false
+	}
+	return g.lastID, true
+}
+
+// SetGroupLastID sets the last ID delivered to a group.
+func (s *Stream) SetGroupLastID(name string, id StreamID) bool {
+	g, ok := s.groups[name]
+	if ok {
+		g.lastID = id
+	}
+	return ok
+}
+
+// HasConsumer reports whether a group has the named consumer.
+func (s *Stream) HasConsumer(group, name string) bool {
+	g, ok := s.groups[group]
+	if !ok {
+		return false
+	}
+	_, ok = g.consumers[name]
+	return ok
+}
+
+// CreateConsumer adds a consumer to a group. It returns false if the group
+// does not exist or already has the consumer.
+func (s *Stream) CreateConsumer(group, name string, seenAt int64) bool {
+	g, ok := s.groups[group]
+	if !ok {
+		return false
+	}
+	if _, ok := g.consumers[name]; ok {
+		return false
+	}
+	g.consumers[name] = &streamConsumer{seenAt: seenAt}
+	return true
+}
+
+// DeleteConsumer removes a consumer and its pending entries from a group.
+// It returns how many pending entries the consumer had.
+func (s *Stream) DeleteConsumer(group, name string) int {
+	g, ok := s.groups[group]
+	if !ok {
+		return 0
+	}
+	c, ok := g.consumers[name]
+	if !ok {
+		return 0
+	}
+	if c.pending > 0 {
+		kept := g.order[:0]
+		for _, id := range g.order {
+			if g.pending[id].Consumer == name {
+				delete(g.pending, id)
+			} else {
+				kept = append(kept, id)
+			}
+		}
+		g.order = kept
+	}
+	delete(g.consumers, name)
+	return c.pending
+}
+
+// Deliver records that the entry id was delivered to a consumer at the
+// given time, for the given number of times in total, making it pending
+// for that consumer. The consumer is created if needed. The group's last
+// delivered ID is left alone.
+func (s *Stream) Deliver(group, consumer string, id StreamID, at, deliveries int64) {
+	g, ok := s.groups[group]
+	if !ok {
+		return
+	}
+	c := g.consumer(consumer)
+	c.seenAt = max(c.seenAt, at)
+
+	if pe, ok := g.pending[id]; ok {
+		if pe.Consumer != consumer {
+			g.consumers[pe.Consumer].pending--
+			c.pending++
+		}
+		pe.Consumer, pe.DeliveredAt, pe.Deliveries = consumer, at, deliveries
+		return
+	}
+	g.pending[id] = &PendingEntry{ID: id, Consumer: consumer, DeliveredAt: at, Deliveries: deliveries}
+	c.pending++
+	i := sort.Search(len(g.order), func(i int) bool { return !g.order[i].Less(id) })
+	g.order = append(g.order, StreamID{})
+	copy(g.order[i+1:], g.order[i:])
+	g.order[i] = id
+}
+
+// Ack removes id from a group's pending entries and reports whether it was
+// pending.
+func (s *Stream) Ack(group string, id StreamID) bool {
+	g, ok := s.groups[group]
+	if !ok {
+		return false
+	}
+	pe, ok := g.pending[id]
+	if !ok {
+		return false
+	}
+	delete(g.pending, id)
+	g.consumers[pe.Consumer].pending--
+	i := sort.Search(len(g.order), func(i int) bool { return !g.order[i].Less(id) })
+	g.order = append(g.order[:i], g.order[i+1:]...)
+	return true
+}
+
+// Pending returns the pending entry for id in a group.
+func (s *Stream) Pending(group string, id StreamID) (PendingEntry, bool) {
+	g, ok := s.groups[group]
+	if !ok {
+		return PendingEntry{}, false
+	}
+	pe, ok := g.pending[id]
+	if !ok {
+		return PendingEntry{}, false
+	}
+	return *pe, true
+}
+
+// PendingRange returns up to count (0 = all) pending entries of a group
+// with IDs in [start, end], ascending. Unless they are zero, consumer
+// restricts the entries to one consumer and deliveredBy to those last
+// delivered at or before that time.
+func (s *Stream) PendingRange(group string, start, end StreamID, count int, consumer string, deliveredBy int64) []PendingEntry {
+	g, ok := s.groups[group]
+	if !ok {
+		return nil
+	}
+	var result []PendingEntry
+	i := sort.Search(len(g.order), func(i int) bool { return !g.order[i].Less(start) })
+	for ; i < len(g.order) && !end.Less(g.order[i]); i++ {
+		pe := g.pending[g.order[i]]
+		if (consumer != "" && pe.Consumer != consumer) || (deliveredBy != 0 && pe.DeliveredAt > deliveredBy) {
+			continue
+		}
+		result = append(result, *pe)
+		if count > 0 && len(result) == count {
+			break
+		}
+	}
+	return result
+}
+
+// PendingSummary summarizes the pending entries of a group.
+func (s *Stream) PendingSummary(group string) PendingSummary {
+	g, ok := s.groups[group]
+	if !ok || len(g.order) == 0 {
+		return PendingSummary{}
+	}
+	sum := PendingSummary{Count: len(g.order), Min: g.order[0], Max: g.order[len(g.order)-1]}
+	for name, c := range g.consumers {
+		if c.pending > 0 {
+			sum.Consumers = append(sum.Consumers, ConsumerPending{Name: name, Pending: c.pending})
+		}
+	}
+	sort.Slice(sum.Consumers, func(i, j int) bool {
+		return sum.Consumers[i].Name < sum.Consumers[j].Name
+	})
+	return sum
+}
+
+// consumer returns the named consumer, creating it if needed.
+func (g *streamGroup) consumer(name string) *streamConsumer {
+	c, ok := g.consumers[name]
+	if !ok {
+		c = &streamConsumer{}
+		g.consumers[name] = c
+	}
+	return c
+}
+
+// Data returns a copy of the stream and its groups.
+func (s *Stream) Data() StreamData {
+	d := StreamData{
+		Entries: append([]StreamEntry(nil), s.entries...),
+		LastID:  s.lastID,
+		Groups:  make([]StreamGroupData, 0, len(s.groups)),
+	}
+	for name, g := range s.groups {
+		gd := StreamGroupData{
+			Name:      name,
+			LastID:    g.lastID,
+			Consumers: make([]StreamConsumerData, 0, len(g.consumers)),
+			Pending:   make([]PendingEntry, len(g.order)),
+		}
+		for cname, c := range g.consumers {
+			gd.Consumers = append(gd.Consumers, StreamConsumerData{Name: cname, SeenAt: c.seenAt})
+		}
+		sort.Slice(gd.Consumers, func(i, j int) bool { return gd.Consumers[i].Name < gd.Consumers[j].Name })
+		for i, id := range g.order {
+			gd.Pending[i] = *g.pending[id]
+		}
+		d.Groups = append(d.Groups, gd)
+	}
+	sort.Slice(d.Groups, func(i, j int) bool { return d.Groups[i].Name < d.Groups[j].Name })
+	return d
+}
+
+// clone returns a deep copy of the stream. Entry fields are shared, since
+// they never change.
+func (s *Stream) clone() *Stream {
+	c := &Stream{
+		entries: append([]StreamEntry(nil), s.entries...),
+		lastID:  s.lastID,
+		groups:  make(map[string]*streamGroup, len(s.groups)),
+		bytes:   s.bytes,
+	}
+	for name, g := range s.groups {
+		cg := &streamGroup{
+			lastID:    g.lastID,
+			pending:   make(map[StreamID]*PendingEntry, len(g.pending)),
+			order:     append([]StreamID(nil), g.order...),
+			consumers: make(map[string]*streamConsumer, len(g.consumers)),
+		}
+		for id, pe := range g.pending {
+			copied := *pe
+			cg.pending[id] = &copied
+		}
+		for cname, con := range g.consumers {
+			copied := *con
+			cg.consumers[cname] = &copied
+		}
+		c.groups[name] = cg
+	}
+	return c
+}
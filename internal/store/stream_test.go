@@ -0,0 +1,169 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func streamFields(kv ...string) [][]byte {
+	fields := make([][]byte, len(kv))
+	for i, s := range kv {
+		fields[i] = []byte(s)
+	}
+	return fields
+}
+
+func streamIDs(entries []StreamEntry) []StreamID {
+	ids := make([]StreamID, len(entries))
+	for i, e := range entries {
+		ids[i] = e.ID
+	}
+	return ids
+}
+
+func TestStreamID_Parse(t *testing.T) {
+	id, err := ParseStreamID("1526919030474-55", 0)
+	require.NoError(t, err)
+	assert.Equal(t, StreamID{Ms: 1526919030474, Seq: 55}, id)
+	assert.Equal(t, "1526919030474-55", id.String())
+
+	id, err = ParseStreamID("7", 3)
+	require.NoError(t, err)
+	assert.Equal(t, StreamID{Ms: 7, Seq: 3}, id)
+
+	for _, bad := range []string{"", "-", "x-1", "1-x", "1-2-3", "-1"} {
+		_, err := ParseStreamID(bad, 0)
+		assert.ErrorIs(t, err, ErrInvalidStreamID, bad)
+	}
+
+	next, ok := StreamID{Ms: 1, Seq: MaxStreamID.Seq}.Next()
+	assert.True(t, ok)
+	assert.Equal(t, StreamID{Ms: 2}, next)
+	_, ok = MaxStreamID.Next()
+	assert.False(t, ok)
+	prev, ok := StreamID{Ms: 2}.Prev()
+	assert.True(t, ok)
+	assert.Equal(t, StreamID{Ms: 1, Seq: MaxStreamID.Seq}, prev)
+	_, ok = StreamID{}.Prev()
+	assert.False(t, ok)
+}
+
+func TestStream_AddRange(t *testing.T) {
+	st := NewStream()
+	for i := uint64(1); i <= 5; i++ {
+		st.Add(StreamID{Ms: i}, streamFields("n", "v"))
+	}
+	assert.Equal(t, 5, st.Len())
+	assert.Equal(t, StreamID{Ms: 5}, st.LastID())
+
+	all := st.Range(StreamID{}, MaxStreamID, 0, false)
+	assert.Equal(t, []StreamID{{Ms: 1}, {Ms: 2}, {Ms: 3}, {Ms: 4}, {Ms: 5}}, streamIDs(all))
+	assert.Equal(t, streamFields("n", "v"), all[0].Fields)
+
+	assert.Equal(t, []StreamID{{Ms: 4}, {Ms: 3}}, streamIDs(st.Range(StreamID{Ms: 2}, StreamID{Ms: 4}, 2, true)))
+	assert.Equal(t, []StreamID{{Ms: 4}, {Ms: 5}}, streamIDs(st.After(StreamID{Ms: 3}, 0)))
+	assert.Empty(t, st.Range(StreamID{Ms: 4}, StreamID{Ms: 2}, 0, false))
+
+	assert.True(t, st.Delete(StreamID{Ms: 3}))
+	assert.False(t, st.Delete(StreamID{Ms: 3}))
+	_, ok := st.Get(StreamID{Ms: 3})
+	assert.False(t, ok)
+	assert.Equal(t, StreamID{Ms: 5}, st.LastID())
+
+	assert.Equal(t, 1, st.TrimMinID(StreamID{Ms: 2}))
+	assert.Equal(t, 1, st.TrimMaxLen(2))
+	assert.Equal(t, 0, st.TrimMaxLen(2))
+	assert.Equal(t, []StreamID{{Ms: 4}, {Ms: 5}}, streamIDs(st.Range(StreamID{}, MaxStreamID, 0, false)))
+}
+
+func TestStream_ConsumerGroups(t *testing.T) {
+	st := NewStream()
+	for i := uint64(1); i <= 3; i++ {
+		st.Add(StreamID{Ms: i}, streamFields("n", "v"))
+	}
+	assert.True(t, st.CreateGroup("g", StreamID{}))
+	assert.False(t, st.CreateGroup("g", StreamID{}))
+
+	st.Deliver("g", "alice", StreamID{Ms: 1}, 100, 1)
+	st.Deliver("g", "alice", StreamID{Ms: 2}, 100, 1)
+	st.Deliver("g", "bob", StreamID{Ms: 3}, 200, 1)
+	assert.True(t, st.HasConsumer("g", "bob"))
+	last, _ := st.GroupLastID("g")
+	assert.Equal(t, StreamID{}, last, "delivering leaves the last ID alone")
+
+	sum := st.PendingSummary("g")
+	assert.Equal(t, 3, sum.Count)
+	assert.Equal(t, StreamID{Ms: 1}, sum.Min)
+	assert.Equal(t, StreamID{Ms: 3}, sum.Max)
+	assert.Equal(t, []ConsumerPending{{Name: "alice", Pending: 2}, {Name: "bob", Pending: 1}}, sum.Consumers)
+
+	// Claiming moves the entry to the new consumer.
+	st.Deliver("g", "bob", StreamID{Ms: 1}, 300, 2)
+	pe, ok := st.Pending("g", StreamID{Ms: 1})
+	require.True(t, ok)
+	assert.Equal(t, PendingEntry{ID: StreamID{Ms: 1}, Consumer: "bob", DeliveredAt: 300, Deliveries: 2}, pe)
+
+	bobs := st.PendingRange("g", StreamID{}, MaxStreamID, 0, "bob", 0)
+	assert.Len(t, bobs, 2)
+	assert.Len(t, st.PendingRange("g", StreamID{}, MaxStreamID, 0, "", 200), 2)
+	assert.Len(t, st.PendingRange("g", StreamID{}, MaxStreamID, 1, "", 0), 1)
+
+	assert.True(t, st.Ack("g", StreamID{Ms: 2}))
+	assert.False(t, st.Ack("g", StreamID{Ms: 2}))
+	assert.Equal(t, 2, st.DeleteConsumer("g", "bob"))
+	assert.Equal(t, 0, st.PendingSummary("g").Count)
+	assert.False(t, st.HasConsumer("g", "bob"))
+	assert.True(t, st.HasConsumer("g", "alice"))
+
+	assert.True(t, st.DestroyGroup("g"))
+	assert.Equal(t, 0, st.GroupCount())
+}
+
+func TestStore_Streams(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	require.NoError(t, s.XAdd("s", StreamID{Ms: 1}, streamFields("a", "1")))
+	assert.Equal(t, "stream", s.Type("s").String())
+	n, err := s.XLen("s")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	s.Set("str", []byte("x"))
+	assert.ErrorIs(t, s.XAdd("str", StreamID{Ms: 1}, streamFields("a", "1")), ErrWrongType)
+
+	assert.ErrorIs(t, s.XGroupCreate("missing", "g", StreamID{}, false), ErrNoStream)
+	require.NoError(t, s.XGroupCreate("missing", "g", StreamID{}, true))
+	n, _ = s.XLen("missing")
+	assert.Equal(t, 0, n)
+	assert.ErrorIs(t, s.XGroupCreate("missing", "g", StreamID{}, true), ErrBusyGroup)
+	_, err = s.XAck("s", "nogroup", StreamID{Ms: 1})
+	assert.ErrorIs(t, err, ErrNoGroup)
+
+	// Streams persist once empty.
+	removed, err := s.XDel("s", StreamID{Ms: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	last, ok, err := s.XLastID("s")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, StreamID{Ms: 1}, last)
+
+	// Views keep the stream as it was.
+	require.NoError(t, s.XGroupCreate("s", "g", StreamID{}, false))
+	view := s.OpenView()
+	defer view.Close()
+	require.NoError(t, s.XAdd("s", StreamID{Ms: 2}, streamFields("b", "2")))
+	require.NoError(t, s.XDeliver("s", "g", "c", StreamID{Ms: 2}, 1, 1))
+	var item Item
+	for i := 0; i < view.Len(); i++ {
+		if item = view.Item(i); item.Key == "s" {
+			break
+		}
+	}
+	require.Equal(t, "s", item.Key)
+	assert.Empty(t, item.Stream.Entries)
+	assert.Empty(t, item.Stream.Groups[0].Pending)
+}
@@ -150,6 +150,60 @@ func (s *Store) GetInfo(key string) (Info, error) {
 	return info, nil
 }
 
+// Create makes sure the series identified by key exists and sets its
+// retention and labels. Existing data points are kept.
+func (s *Store) Create(key string, retention time.Duration, labels map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ser, ok := s.series[key]
+	if !ok {
+		ser = &Series{Points: make([]DataPoint, 0, 64)}
+		s.series[key] = ser
+	}
+	ser.Retention = retention
+	ser.Labels = make(map[string]string, len(labels))
+	for k, v := range labels {
+		ser.Labels[k] = v
+	}
+}
+
+// Exists reports whether a series exists for key.
+func (s *Store) Exists(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.series[key]
+	return ok
+}
+
+// Snapshot returns a deep copy of the series identified by key.
+func (s *Store) Snapshot(key string) (Series, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ser, ok := s.series[key]
+	if !ok {
+		return Series{}, false
+	}
+	cp := Series{
+		Points:    make([]DataPoint, len(ser.Points)),
+		Retention: ser.Retention,
+		Labels:    make(map[string]string, len(ser.Labels)),
+	}
+	copy(cp.Points, ser.Points)
+	for k, v := range ser.Labels {
+		cp.Labels[k] = v
+	}
+	return cp, true
+}
+
+// Clear removes every series.
+func (s *Store) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.series = make(map[string]*Series)
+}
+
 // Delete removes a time-series key entirely.
 func (s *Store) Delete(key string) bool {
 	s.mu.Lock()
@@ -175,3 +175,38 @@ func TestSize(t *testing.T) {
 		t.Fatalf("expected 2, got %d", s.Size())
 	}
 }
+
+func TestCreateAndSnapshot(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	s.Add("cpu", 1000, 1.5, 0)
+	s.Create("cpu", time.Hour, map[string]string{"host": "a"})
+	s.Create("empty", time.Minute, nil)
+
+	ser, ok := s.Snapshot("cpu")
+	if !ok {
+		t.Fatal("expected series cpu")
+	}
+	if len(ser.Points) != 1 || ser.Points[0].Value != 1.5 {
+		t.Fatalf("unexpected points: %+v", ser.Points)
+	}
+	if ser.Retention != time.Hour || ser.Labels["host"] != "a" {
+		t.Fatalf("unexpected settings: %v %v", ser.Retention, ser.Labels)
+	}
+
+	// The copy is independent of the store.
+	ser.Points[0].Value = 99
+	p, _ := s.Get("cpu")
+	if p.Value != 1.5 {
+		t.Fatal("snapshot shares points with the store")
+	}
+
+	if !s.Exists("empty") {
+		t.Fatal("expected empty series to exist")
+	}
+	s.Clear()
+	if s.Size() != 0 {
+		t.Fatalf("expected 0 series after Clear, got %d", s.Size())
+	}
+}
@@ -0,0 +1,164 @@
+package wal
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SyncPolicy controls when WAL writes are fsynced to disk.
+type SyncPolicy int
+
+const (
+	// SyncAlways syncs before a write is acknowledged. Concurrent writers
+	// share a single fsync (group commit).
+	SyncAlways SyncPolicy = iota
+	// SyncEverySec syncs in the background once per second, so at most about
+	// a second of writes can be lost on power failure.
+	SyncEverySec
+	// SyncNo never syncs explicitly and leaves flushing to the OS.
+	SyncNo
+)
+
+// String returns the appendfsync name of the policy.
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncEverySec:
+		return "everysec"
+	case SyncNo:
+		return "no"
+	default:
+		return "always"
+	}
+}
+
+// ParseSyncPolicy parses an appendfsync value: always, everysec or no.
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch strings.ToLower(s) {
+	case "always":
+		return SyncAlways, nil
+	case "everysec":
+		return SyncEverySec, nil
+	case "no":
+		return SyncNo, nil
+	}
+	return SyncAlways, fmt.Errorf("wal: invalid sync policy %q", s)
+}
+
+// Stats describes the WAL size and fsync activity.
+type Stats struct {
+	Policy       SyncPolicy
+	Size         int64
+	PendingBytes int64 // written but not yet known to be on disk
+	Fsyncs       int64
+	LastFsync    time.Duration
+	AvgFsync     time.Duration
+}
+
+// Stats returns the current WAL statistics.
+func (w *WAL) Stats() Stats {
+	w.mu.Lock()
+	size, appended := w.size, w.appended
+	w.mu.Unlock()
+
+	w.syncMu.Lock()
+	pending := appended - w.synced
+	w.syncMu.Unlock()
+
+	st := Stats{
+		Policy:       w.policy,
+		Size:         size,
+		PendingBytes: pending,
+		Fsyncs:       w.fsyncs.Load(),
+		LastFsync:    time.Duration(w.lastFsync.Load()),
+	}
+	if st.Fsyncs > 0 {
+		st.AvgFsync = time.Duration(w.fsyncNanos.Load() / st.Fsyncs)
+	}
+	return st
+}
+
+// WaitDurable blocks until everything written so far is on disk if the
+// policy is SyncAlways. Under the other policies it returns immediately.
+func (w *WAL) WaitDurable() error {
+	if w.policy != SyncAlways {
+		return nil
+	}
+	return w.Sync()
+}
+
+// syncTo returns once every byte up to the append offset end is on disk.
+// Only one goroutine runs fsync at a time; the others wait for it and find
+// their data covered by that sync or start the next one, so a burst of
+// concurrent writers needs only a couple of fsyncs between them.
+func (w *WAL) syncTo(end int64) error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+
+	for w.synced < end {
+		if w.syncing {
+			w.syncCond.Wait()
+			continue
+		}
+
+		w.syncing = true
+		w.syncMu.Unlock()
+		covered, err := w.syncFile()
+		w.syncMu.Lock()
+		w.syncing = false
+		w.syncCond.Broadcast()
+
+		if err != nil {
+			return fmt.Errorf("wal: failed to sync: %w", err)
+		}
+		if covered > w.synced {
+			w.synced = covered
+		}
+	}
+	return nil
+}
+
+// syncFile fsyncs the current file and returns the append offset it covers.
+func (w *WAL) syncFile() (int64, error) {
+	w.mu.Lock()
+	file, covered := w.file, w.appended
+	w.fileMu.RLock()
+	w.mu.Unlock()
+	defer w.fileMu.RUnlock()
+
+	start := time.Now()
+	err := file.Sync()
+	elapsed := time.Since(start)
+
+	w.fsyncs.Add(1)
+	w.fsyncNanos.Add(int64(elapsed))
+	w.lastFsync.Store(int64(elapsed))
+	return covered, err
+}
+
+// markSynced records that everything written so far is on disk, after the
+// file was synced as a whole (must hold w.mu).
+func (w *WAL) markSynced() {
+	w.syncMu.Lock()
+	if w.appended > w.synced {
+		w.synced = w.appended
+	}
+	w.syncMu.Unlock()
+}
+
+// flushLoop syncs pending writes once per second under SyncEverySec.
+func (w *WAL) flushLoop() {
+	defer close(w.flushDone)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stopFlush:
+			return
+		case <-ticker.C:
+			w.Sync()
+		}
+	}
+}
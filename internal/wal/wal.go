@@ -11,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 )
 
 // Operation types for WAL records
@@ -20,6 +21,16 @@ const (
 	OpSetWithTTL byte = 0x03
 	OpExpire     byte = 0x04
 	OpPersist    byte = 0x05
+	OpRename     byte = 0x06 // Key = source, Value = destination
+	OpCopy       byte = 0x07 // Key = source, Value = destination (replaces)
+	OpCheckpoint byte = 0x08 // Key = snapshot ID, Value = snapshot creation time; state up to here is in the snapshot
+	OpSetRange   byte = 0x09 // Value = offset + bytes; pads the string with zero bytes as needed
+	OpSelect     byte = 0x0A // Key = database number; written and consumed by the WAL to carry Record.DB
+	OpFlushDB    byte = 0x0B // removes every key of the record's database
+	OpSwapDB     byte = 0x0C // Value = other database number; swaps the contents of the two databases
+	OpMove       byte = 0x0D // Value = destination database number; moves the key with its TTL
+	OpTxBegin    byte = 0x0E // starts the records of a transaction, see WriteTx
+	OpTxCommit   byte = 0x0F // ends them; without it ReadAll drops the transaction
 
 	// Sorted set operations
 	OpZAdd             byte = 0x10
@@ -46,8 +57,32 @@ const (
 	OpSPop byte = 0x42
 
 	// Time-series operations
-	OpTSAdd byte = 0x50
-	OpTSDel byte = 0x51
+	OpTSAdd  byte = 0x50
+	OpTSDel  byte = 0x51
+	OpTSMeta byte = 0x52 // Value = retention + labels; creates the series if missing
+
+	// Stream operations
+	OpXAdd            byte = 0x60 // Value = ID + fields
+	OpXDel            byte = 0x61 // Value = ID
+	OpXTrim           byte = 0x62 // Value = MAXLEN count or MINID
+	OpXSetID          byte = 0x63 // Value = last ID; creates the stream if missing
+	OpXGroupCreate    byte = 0x64 // Value = group + last delivered ID
+	OpXGroupDestroy   byte = 0x65 // Value = group
+	OpXGroupSetID     byte = 0x66 // Value = group + last delivered ID
+	OpXConsumerCreate byte = 0x67 // Value = group + consumer + seen time
+	OpXConsumerDel    byte = 0x68 // Value = group + consumer
+	OpXDeliver        byte = 0x69 // Value = group + consumer + ID + delivery time + delivery count
+	OpXAck            byte = 0x6A // Value = group + ID
+
+	// JSON operations; paths are normalized JSONPaths
+	OpJSONSet       byte = 0x70 // Value = path + JSON value; the root path creates the document
+	OpJSONDel       byte = 0x71 // Value = path; the root path deletes the key
+	OpJSONArrInsert byte = 0x72 // Value = path + index + JSON values
+	OpJSONArrTrim   byte = 0x73 // Value = path + start + stop
+
+	// Search index operations
+	OpFTCreate byte = 0x80 // Key = index name, Value = prefixes + fields; replaces an index of that name
+	OpFTDrop   byte = 0x81 // Key = index name
 )
 
 // Header size: CRC32 (4) + Type (1) + KeyLen (4) + ValueLen (4) + TTL (8) = 21 bytes
@@ -58,6 +93,10 @@ var (
 	ErrCorruptedRecord = errors.New("wal: corrupted record (CRC32 mismatch)")
 	// ErrInvalidOperation indicates an unknown operation type
 	ErrInvalidOperation = errors.New("wal: invalid operation type")
+	// ErrRewriteInProgress indicates that a rewrite has already been started
+	ErrRewriteInProgress = errors.New("wal: rewrite already in progress")
+	// ErrRewriteAborted indicates that the log was cleared while a rewrite was running
+	ErrRewriteAborted = errors.New("wal: rewrite aborted")
 )
 
 // Record represents a WAL record
@@ -66,6 +105,7 @@ type Record struct {
 	Key      []byte
 	Value    []byte
 	ExpireAt int64 // Unix timestamp in milliseconds, 0 means no expiration
+	DB       int   // logical database the record applies to
 }
 
 // bufPool pools byte slices used for record encoding to reduce allocations
@@ -82,11 +122,46 @@ type WAL struct {
 	mu       sync.Mutex
 	file     *os.File
 	filePath string
+	size     int64
+	appended int64 // total bytes ever written; unlike size it never shrinks
+
+	// db is the database in effect at the end of the file, which records
+	// carry over unless an OpSelect precedes them; -1 forces one.
+	db int
+
+	// While a rewrite is running, every appended record is also kept in
+	// rewriteBuf so it can be carried over into the new file.
+	rewriting  bool
+	rewriteBuf []byte
+
+	// fileMu keeps the file open while an fsync runs without w.mu held.
+	// Code that closes or replaces the file takes it after w.mu.
+	fileMu sync.RWMutex
+
+	// Group commit state, see syncTo.
+	policy   SyncPolicy
+	syncMu   sync.Mutex
+	syncCond *sync.Cond
+	syncing  bool
+	synced   int64 // appended offset known to be on disk
+
+	fsyncs     atomic.Int64
+	fsyncNanos atomic.Int64
+	lastFsync  atomic.Int64
+
+	stopFlush chan struct{}
+	flushDone chan struct{}
 }
 
-// Open opens or creates a WAL file at the specified path.
+// Open opens or creates a WAL file at the specified path, syncing every
+// write before it returns (SyncAlways).
 // If the directory doesn't exist, it will be created.
 func Open(path string) (*WAL, error) {
+	return OpenWithPolicy(path, SyncAlways)
+}
+
+// OpenWithPolicy opens or creates a WAL file with the given fsync policy.
+func OpenWithPolicy(path string, policy SyncPolicy) (*WAL, error) {
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, fmt.Errorf("wal: failed to create directory: %w", err)
@@ -98,64 +173,101 @@ func Open(path string) (*WAL, error) {
 		return nil, fmt.Errorf("wal: failed to open file: %w", err)
 	}
 
-	return &WAL{
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("wal: failed to stat file: %w", err)
+	}
+
+	w := &WAL{
 		file:     file,
 		filePath: path,
-	}, nil
+		size:     info.Size(),
+		policy:   policy,
+	}
+	if w.size > 0 {
+		w.db = -1 // known once ReadAll has run
+	}
+	w.syncCond = sync.NewCond(&w.syncMu)
+	if policy == SyncEverySec {
+		w.stopFlush = make(chan struct{})
+		w.flushDone = make(chan struct{})
+		go w.flushLoop()
+	}
+	return w, nil
 }
 
-// Append writes a record to the WAL.
-// The record is synced to disk before returning.
-func (w *WAL) Append(rec Record) error {
-	w.mu.Lock()
-	defer w.mu.Unlock()
-
-	// Seek to end before writing
+// write appends encoded records to the file and, during a rewrite, to the
+// rewrite buffer (must hold w.mu).
+func (w *WAL) write(data []byte) error {
 	if _, err := w.file.Seek(0, io.SeekEnd); err != nil {
 		return fmt.Errorf("wal: failed to seek to end: %w", err)
 	}
-
-	data := encodeRecord(rec)
 	if _, err := w.file.Write(data); err != nil {
 		return fmt.Errorf("wal: failed to write record: %w", err)
 	}
-
-	if err := w.file.Sync(); err != nil {
-		return fmt.Errorf("wal: failed to sync: %w", err)
+	w.size += int64(len(data))
+	w.appended += int64(len(data))
+	if w.rewriting {
+		w.rewriteBuf = append(w.rewriteBuf, data...)
 	}
-
 	return nil
 }
 
+// Append writes a record to the WAL.
+// Under SyncAlways the record is synced to disk before returning.
+func (w *WAL) Append(rec Record) error {
+	return w.AppendBatch([]Record{rec})
+}
+
 // AppendBatch writes multiple records to the WAL atomically.
-// All records are written into a pooled buffer and flushed + synced once,
+// All records are written into a pooled buffer with a single write call,
 // which is more efficient and ensures atomicity for multi-key operations.
+// Under SyncAlways they are synced to disk before returning.
 func (w *WAL) AppendBatch(records []Record) error {
+	if err := w.Write(records...); err != nil {
+		return err
+	}
+	return w.WaitDurable()
+}
+
+// Write writes records to the WAL without waiting for them to reach disk.
+// Callers that need durability call WaitDurable afterwards, ideally after
+// releasing their own locks so that concurrent writers share one fsync.
+func (w *WAL) Write(records ...Record) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	if _, err := w.file.Seek(0, io.SeekEnd); err != nil {
-		return fmt.Errorf("wal: failed to seek to end: %w", err)
-	}
-
 	// Accumulate into a pooled buffer so we issue a single write syscall.
 	bp := bufPool.Get().(*[]byte)
-	buf := (*bp)[:0]
-	for _, rec := range records {
-		buf = appendEncodedRecord(buf, rec)
+	buf := appendRecords((*bp)[:0], &w.db, records)
+	err := w.write(buf)
+	if err != nil {
+		w.db = -1
 	}
-	_, err := w.file.Write(buf)
 	*bp = buf
 	bufPool.Put(bp)
-	if err != nil {
-		return fmt.Errorf("wal: failed to write records: %w", err)
-	}
+	return err
+}
 
-	if err := w.file.Sync(); err != nil {
-		return fmt.Errorf("wal: failed to sync: %w", err)
-	}
+// WriteTx writes records as one transaction, between OpTxBegin and
+// OpTxCommit markers, with a single write. ReadAll only returns them once
+// the commit marker is on disk, so recovery sees all of them or none.
+func (w *WAL) WriteTx(records ...Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	return nil
+	bp := bufPool.Get().(*[]byte)
+	buf := appendEncodedRecord((*bp)[:0], Record{Type: OpTxBegin})
+	buf = appendRecords(buf, &w.db, records)
+	buf = appendEncodedRecord(buf, Record{Type: OpTxCommit})
+	err := w.write(buf)
+	if err != nil {
+		w.db = -1
+	}
+	*bp = buf
+	bufPool.Put(bp)
+	return err
 }
 
 // AppendNoSync writes a record to the WAL without calling fsync.
@@ -166,25 +278,23 @@ func (w *WAL) AppendNoSync(rec Record) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	if _, err := w.file.Seek(0, io.SeekEnd); err != nil {
-		return fmt.Errorf("wal: failed to seek to end: %w", err)
-	}
-
-	data := encodeRecord(rec)
-	if _, err := w.file.Write(data); err != nil {
-		return fmt.Errorf("wal: failed to write record: %w", err)
+	err := w.write(appendRecords(nil, &w.db, []Record{rec}))
+	if err != nil {
+		w.db = -1
 	}
-	return nil
+	return err
 }
 
-// Sync flushes the WAL file to durable storage.
+// Sync flushes everything written so far to durable storage,
+// regardless of the sync policy.
 func (w *WAL) Sync() error {
 	w.mu.Lock()
-	defer w.mu.Unlock()
-	return w.file.Sync()
+	end := w.appended
+	w.mu.Unlock()
+	return w.syncTo(end)
 }
 
-// ReadAll reads all valid records from the WAL.
+// ReadAll reads all valid records from the WAL, with their DB set.
 // Returns records up to the first corrupted or partial record.
 // The WAL file is truncated to remove any partial records.
 func (w *WAL) ReadAll() ([]Record, error) {
@@ -198,7 +308,16 @@ func (w *WAL) ReadAll() ([]Record, error) {
 
 	var records []Record
 	var validOffset int64 = 0
+	db := 0
 
+	// Records of a transaction wait in pending for its commit marker. A
+	// transaction cut short is dropped, and the log truncated where it began.
+	var pending []Record
+	inTx := false
+	var txOffset int64
+	txDB := 0
+
+read:
 	for {
 		rec, bytesRead, err := readRecord(w.file)
 		if err != nil {
@@ -208,14 +327,43 @@ func (w *WAL) ReadAll() ([]Record, error) {
 			// Partial or corrupted record - stop reading
 			break
 		}
-		records = append(records, rec)
+		switch rec.Type {
+		case OpSelect:
+			if len(rec.Key) != 4 {
+				break read
+			}
+			db = int(binary.LittleEndian.Uint32(rec.Key))
+		case OpTxBegin:
+			if inTx {
+				break read
+			}
+			inTx, txOffset, txDB = true, validOffset, db
+		case OpTxCommit:
+			if !inTx {
+				break read
+			}
+			records = append(records, pending...)
+			pending, inTx = nil, false
+		default:
+			rec.DB = db
+			if inTx {
+				pending = append(pending, rec)
+			} else {
+				records = append(records, rec)
+			}
+		}
 		validOffset += int64(bytesRead)
 	}
+	if inTx {
+		validOffset, db = txOffset, txDB
+	}
+	w.db = db
 
 	// Truncate to last valid record
 	if err := w.file.Truncate(validOffset); err != nil {
 		return nil, fmt.Errorf("wal: failed to truncate: %w", err)
 	}
+	w.size = validOffset
 
 	// Seek to end for appending
 	if _, err := w.file.Seek(0, io.SeekEnd); err != nil {
@@ -227,8 +375,16 @@ func (w *WAL) ReadAll() ([]Record, error) {
 
 // Close closes the WAL file.
 func (w *WAL) Close() error {
+	if w.stopFlush != nil {
+		close(w.stopFlush)
+		<-w.flushDone
+		w.stopFlush = nil
+	}
+
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	w.fileMu.Lock()
+	defer w.fileMu.Unlock()
 
 	if err := w.file.Sync(); err != nil {
 		return fmt.Errorf("wal: failed to sync on close: %w", err)
@@ -244,12 +400,186 @@ func (w *WAL) Clear() error {
 	if err := w.file.Truncate(0); err != nil {
 		return fmt.Errorf("wal: failed to truncate: %w", err)
 	}
+	w.size = 0
+	w.db = 0
+
+	// A rewrite in flight was based on the old contents; make it fail.
+	w.rewriting = false
+	w.rewriteBuf = nil
 
 	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
 		return fmt.Errorf("wal: failed to seek: %w", err)
 	}
 
-	return w.file.Sync()
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.markSynced()
+	return nil
+}
+
+// Size returns the current size of the WAL file in bytes.
+func (w *WAL) Size() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.size
+}
+
+// StartRewrite begins capturing appended records for a rewrite. The caller
+// must take the state it is going to write out at the same point in time,
+// so that every later change is either in that state or in the capture.
+func (w *WAL) StartRewrite() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.rewriting {
+		return ErrRewriteInProgress
+	}
+	w.rewriting = true
+	w.rewriteBuf = nil
+	// The captured records follow the rewritten ones, so the first of them
+	// must say which database it belongs to.
+	w.db = -1
+	return nil
+}
+
+// AbortRewrite stops capturing records and discards the capture.
+func (w *WAL) AbortRewrite() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.rewriting = false
+	w.rewriteBuf = nil
+}
+
+// FinishRewrite writes records to a new log file, followed by every record
+// appended since StartRewrite, and atomically replaces the current log with
+// it. Appends continue while the base records are written; they are only
+// blocked for the final catch-up and rename.
+func (w *WAL) FinishRewrite(records []Record) error {
+	tmpPath := w.filePath + ".rewrite"
+	tmp, db, err := writeLogFile(tmpPath, records)
+	if err != nil {
+		w.AbortRewrite()
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.rewriting {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return ErrRewriteAborted
+	}
+	w.rewriting = false
+	tail := w.rewriteBuf
+	w.rewriteBuf = nil
+
+	if _, err := tmp.Write(tail); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("wal: failed to write rewrite file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("wal: failed to sync rewrite file: %w", err)
+	}
+	if err := w.swapFile(tmp, tmpPath); err != nil {
+		return err
+	}
+	if w.db < 0 && len(tail) == 0 {
+		w.db = db
+	}
+	return nil
+}
+
+// Replace atomically replaces the whole log with records. A rewrite in
+// flight was based on the old contents and is made to fail.
+func (w *WAL) Replace(records []Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.rewriting = false
+	w.rewriteBuf = nil
+
+	tmpPath := w.filePath + ".replace"
+	tmp, db, err := writeLogFile(tmpPath, records)
+	if err != nil {
+		return err
+	}
+	if err := w.swapFile(tmp, tmpPath); err != nil {
+		return err
+	}
+	w.db = db
+	return nil
+}
+
+// writeLogFile writes records to a new synced file at path and returns it
+// open for further appends, along with the database in effect at its end.
+func writeLogFile(path string, records []Record) (*os.File, int, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, 0, fmt.Errorf("wal: failed to create rewrite file: %w", err)
+	}
+	fail := func(err error) (*os.File, int, error) {
+		f.Close()
+		os.Remove(path)
+		return nil, 0, err
+	}
+
+	var buf []byte
+	db := 0
+	for i := range records {
+		buf = appendRecords(buf, &db, records[i:i+1])
+		if len(buf) >= 1<<20 {
+			if _, err := f.Write(buf); err != nil {
+				return fail(fmt.Errorf("wal: failed to write rewrite file: %w", err))
+			}
+			buf = buf[:0]
+		}
+	}
+	if _, err := f.Write(buf); err != nil {
+		return fail(fmt.Errorf("wal: failed to write rewrite file: %w", err))
+	}
+	if err := f.Sync(); err != nil {
+		return fail(fmt.Errorf("wal: failed to sync rewrite file: %w", err))
+	}
+	return f, db, nil
+}
+
+// swapFile renames the synced file at tmpPath over the log and makes it the
+// file appends go to (must hold w.mu).
+func (w *WAL) swapFile(tmp *os.File, tmpPath string) error {
+	size, err := tmp.Seek(0, io.SeekEnd)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("wal: failed to seek to end: %w", err)
+	}
+	if err := os.Rename(tmpPath, w.filePath); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("wal: failed to replace log: %w", err)
+	}
+	syncDir(filepath.Dir(w.filePath))
+
+	w.fileMu.Lock()
+	w.file.Close()
+	w.file = tmp
+	w.fileMu.Unlock()
+	w.size = size
+	w.markSynced()
+	return nil
+}
+
+// syncDir fsyncs a directory so that a rename inside it is durable.
+// Errors are ignored because not every platform supports it.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
 }
 
 // appendEncodedRecord appends the encoded form of rec to dst (growing the
@@ -283,28 +613,19 @@ func appendEncodedRecord(dst []byte, rec Record) []byte {
 	return dst
 }
 
-// encodeRecord encodes a record into bytes with CRC32 checksum.
-// Format: CRC32 (4) + Type (1) + KeyLen (4) + ValueLen (4) + ExpireAt (8) + Key + Value
-func encodeRecord(rec Record) []byte {
-	keyLen := len(rec.Key)
-	valueLen := len(rec.Value)
-	totalLen := headerSize + keyLen + valueLen
-
-	data := make([]byte, totalLen)
-
-	// Skip CRC32 for now, write other fields first
-	data[4] = rec.Type
-	binary.LittleEndian.PutUint32(data[5:9], uint32(keyLen))
-	binary.LittleEndian.PutUint32(data[9:13], uint32(valueLen))
-	binary.LittleEndian.PutUint64(data[13:21], uint64(rec.ExpireAt))
-	copy(data[21:21+keyLen], rec.Key)
-	copy(data[21+keyLen:], rec.Value)
-
-	// Calculate CRC32 over everything except the CRC32 field itself
-	checksum := crc32.ChecksumIEEE(data[4:])
-	binary.LittleEndian.PutUint32(data[0:4], checksum)
-
-	return data
+// appendRecords appends the encoded form of records to dst, preceding every
+// change of database with an OpSelect record. db holds the database in
+// effect at the end of dst and is updated.
+func appendRecords(dst []byte, db *int, records []Record) []byte {
+	for _, rec := range records {
+		if rec.DB != *db {
+			key := binary.LittleEndian.AppendUint32(nil, uint32(rec.DB))
+			dst = appendEncodedRecord(dst, Record{Type: OpSelect, Key: key})
+			*db = rec.DB
+		}
+		dst = appendEncodedRecord(dst, rec)
+	}
+	return dst
 }
 
 // readRecord reads a single record from the reader.
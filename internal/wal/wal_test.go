@@ -3,6 +3,7 @@ package wal
 import (
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -182,3 +183,246 @@ func BenchmarkWALAppendBatch(b *testing.B) {
 		w.AppendBatch(batch)
 	}
 }
+
+func TestWAL_Rewrite(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+
+	w, err := Open(walPath)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, w.Append(Record{Type: OpSet, Key: []byte("key"), Value: []byte("old")}))
+	}
+	before := w.Size()
+
+	require.NoError(t, w.StartRewrite())
+	assert.ErrorIs(t, w.StartRewrite(), ErrRewriteInProgress)
+
+	// Appends made during the rewrite end up after the base records.
+	require.NoError(t, w.Append(Record{Type: OpSet, Key: []byte("other"), Value: []byte("new")}))
+	require.NoError(t, w.FinishRewrite([]Record{{Type: OpSet, Key: []byte("key"), Value: []byte("old")}}))
+	assert.Less(t, w.Size(), before)
+
+	require.NoError(t, w.Append(Record{Type: OpDelete, Key: []byte("key")}))
+	require.NoError(t, w.Close())
+
+	w2, err := Open(walPath)
+	require.NoError(t, err)
+	defer w2.Close()
+
+	records, err := w2.ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, []byte("key"), records[0].Key)
+	assert.Equal(t, []byte("other"), records[1].Key)
+	assert.Equal(t, OpDelete, records[2].Type)
+}
+
+func TestWAL_Databases(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+
+	w, err := Open(walPath)
+	require.NoError(t, err)
+
+	require.NoError(t, w.Write(
+		Record{Type: OpSet, Key: []byte("a"), DB: 0},
+		Record{Type: OpSet, Key: []byte("b"), DB: 3},
+		Record{Type: OpSet, Key: []byte("c"), DB: 3},
+	))
+	require.NoError(t, w.Append(Record{Type: OpSet, Key: []byte("d"), DB: 1}))
+
+	// The records captured during a rewrite keep their database even when
+	// the rewritten ones end in another.
+	require.NoError(t, w.StartRewrite())
+	require.NoError(t, w.Append(Record{Type: OpSet, Key: []byte("e"), DB: 1}))
+	require.NoError(t, w.FinishRewrite([]Record{{Type: OpSet, Key: []byte("f"), DB: 2}}))
+	require.NoError(t, w.Append(Record{Type: OpDelete, Key: []byte("e"), DB: 1}))
+	require.NoError(t, w.Close())
+
+	w, err = Open(walPath)
+	require.NoError(t, err)
+	records, err := w.ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, 2, records[0].DB)
+	assert.Equal(t, []byte("e"), records[1].Key)
+	assert.Equal(t, 1, records[1].DB)
+	assert.Equal(t, 1, records[2].DB)
+
+	// Appends after reading continue in the database the log ends in.
+	before := w.Size()
+	require.NoError(t, w.Append(Record{Type: OpSet, Key: []byte("g"), DB: 1}))
+	assert.Equal(t, before+headerSize+1, w.Size())
+	require.NoError(t, w.Replace([]Record{{Type: OpSet, Key: []byte("h"), DB: 5}}))
+	require.NoError(t, w.Append(Record{Type: OpSet, Key: []byte("i"), DB: 5}))
+	require.NoError(t, w.Close())
+
+	w, err = Open(walPath)
+	require.NoError(t, err)
+	defer w.Close()
+	records, err = w.ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, 5, records[0].DB)
+	assert.Equal(t, 5, records[1].DB)
+}
+
+func TestWAL_Transaction(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+
+	w, err := Open(walPath)
+	require.NoError(t, err)
+	require.NoError(t, w.Append(Record{Type: OpSet, Key: []byte("a")}))
+	require.NoError(t, w.WriteTx(
+		Record{Type: OpSet, Key: []byte("b"), DB: 2},
+		Record{Type: OpSet, Key: []byte("c"), DB: 2},
+	))
+	committed := w.Size()
+	require.NoError(t, w.WriteTx(
+		Record{Type: OpSet, Key: []byte("d")},
+		Record{Type: OpSet, Key: []byte("e")},
+	))
+	require.NoError(t, w.Close())
+
+	// A crash before the commit marker reached disk loses the whole
+	// transaction, and the log is cut back to where it began.
+	require.NoError(t, os.Truncate(walPath, w.Size()-1))
+	w, err = Open(walPath)
+	require.NoError(t, err)
+	records, err := w.ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, []byte("c"), records[2].Key)
+	assert.Equal(t, 2, records[2].DB)
+	assert.Equal(t, committed, w.Size())
+
+	// Appends carry on after the last committed record.
+	require.NoError(t, w.Append(Record{Type: OpSet, Key: []byte("f")}))
+	require.NoError(t, w.Close())
+	w, err = Open(walPath)
+	require.NoError(t, err)
+	defer w.Close()
+	records, err = w.ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+	assert.Equal(t, []byte("f"), records[3].Key)
+	assert.Equal(t, 0, records[3].DB)
+}
+
+func TestWAL_RewriteAbortedByClear(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+
+	w, err := Open(walPath)
+	require.NoError(t, err)
+	defer w.Close()
+
+	require.NoError(t, w.Append(Record{Type: OpSet, Key: []byte("key"), Value: []byte("v")}))
+	require.NoError(t, w.StartRewrite())
+	require.NoError(t, w.Clear())
+
+	err = w.FinishRewrite([]Record{{Type: OpSet, Key: []byte("key"), Value: []byte("v")}})
+	assert.ErrorIs(t, err, ErrRewriteAborted)
+
+	records, err := w.ReadAll()
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	for _, p := range []SyncPolicy{SyncAlways, SyncEverySec, SyncNo} {
+		got, err := ParseSyncPolicy(p.String())
+		require.NoError(t, err)
+		assert.Equal(t, p, got)
+	}
+
+	got, err := ParseSyncPolicy("EVERYSEC")
+	require.NoError(t, err)
+	assert.Equal(t, SyncEverySec, got)
+
+	_, err = ParseSyncPolicy("sometimes")
+	assert.Error(t, err)
+}
+
+func TestWAL_GroupCommit(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+
+	w, err := Open(walPath)
+	require.NoError(t, err)
+	defer w.Close()
+
+	const writers = 50
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, w.Append(Record{Type: OpSet, Key: []byte("key"), Value: []byte("value")}))
+		}()
+	}
+	wg.Wait()
+
+	st := w.Stats()
+	assert.Equal(t, SyncAlways, st.Policy)
+	assert.Zero(t, st.PendingBytes)
+	assert.LessOrEqual(t, st.Fsyncs, int64(writers))
+	assert.Positive(t, st.Fsyncs)
+
+	records, err := w.ReadAll()
+	require.NoError(t, err)
+	assert.Len(t, records, writers)
+}
+
+func TestWAL_SyncPolicyDeferred(t *testing.T) {
+	for _, policy := range []SyncPolicy{SyncEverySec, SyncNo} {
+		t.Run(policy.String(), func(t *testing.T) {
+			walPath := filepath.Join(t.TempDir(), "test.wal")
+
+			w, err := OpenWithPolicy(walPath, policy)
+			require.NoError(t, err)
+
+			require.NoError(t, w.Append(Record{Type: OpSet, Key: []byte("key"), Value: []byte("value")}))
+			st := w.Stats()
+			assert.Equal(t, policy, st.Policy)
+			assert.Positive(t, st.PendingBytes)
+
+			require.NoError(t, w.Sync())
+			assert.Zero(t, w.Stats().PendingBytes)
+			require.NoError(t, w.Close())
+
+			w2, err := Open(walPath)
+			require.NoError(t, err)
+			defer w2.Close()
+
+			records, err := w2.ReadAll()
+			require.NoError(t, err)
+			assert.Len(t, records, 1)
+		})
+	}
+}
+
+func TestWAL_Replace(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "test.wal")
+
+	w, err := Open(walPath)
+	require.NoError(t, err)
+
+	require.NoError(t, w.Append(Record{Type: OpSet, Key: []byte("old"), Value: []byte("v")}))
+	require.NoError(t, w.StartRewrite())
+	require.NoError(t, w.Replace([]Record{{Type: OpSet, Key: []byte("new"), Value: []byte("v")}}))
+
+	// The rewrite started before the replace is based on stale contents.
+	assert.ErrorIs(t, w.FinishRewrite(nil), ErrRewriteAborted)
+
+	require.NoError(t, w.Append(Record{Type: OpDelete, Key: []byte("new")}))
+	require.NoError(t, w.Close())
+
+	w2, err := Open(walPath)
+	require.NoError(t, err)
+	defer w2.Close()
+
+	records, err := w2.ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, []byte("new"), records[0].Key)
+	assert.Equal(t, OpDelete, records[1].Type)
+}
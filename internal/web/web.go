@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"math"
 	"net/http"
 	"regexp"
 	"runtime"
@@ -285,6 +286,12 @@ func (s *Server) handleExecute(w http.ResponseWriter, r *http.Request) {
 
 // executeCommand executes a FlashDB command.
 func (s *Server) executeCommand(cmd string, args []string) (interface{}, error) {
+	if denyOOMCmds[cmd] {
+		if err := s.engine.FreeMemory(); err != nil {
+			return nil, err
+		}
+	}
+
 	switch cmd {
 	case "PING":
 		if len(args) > 0 {
@@ -319,7 +326,10 @@ func (s *Server) executeCommand(cmd string, args []string) (interface{}, error)
 		if len(args) != 1 {
 			return nil, fmt.Errorf("wrong number of arguments for 'GET' command")
 		}
-		val, exists := s.engine.Get(args[0])
+		val, exists, err := s.engine.Get(args[0])
+		if err != nil {
+			return nil, err
+		}
 		if !exists {
 			return nil, nil
 		}
@@ -410,7 +420,7 @@ func (s *Server) executeCommand(cmd string, args []string) (interface{}, error)
 		members := make([]store.ScoredMember, 0, (len(args)-1)/2)
 		for i := 1; i < len(args); i += 2 {
 			score, err := strconv.ParseFloat(args[i], 64)
-			if err != nil {
+			if err != nil || math.IsNaN(score) {
 				return nil, fmt.Errorf("value is not a valid float")
 			}
 			members = append(members, store.ScoredMember{
@@ -424,7 +434,10 @@ func (s *Server) executeCommand(cmd string, args []string) (interface{}, error)
 		if len(args) != 2 {
 			return nil, fmt.Errorf("wrong number of arguments for 'ZSCORE' command")
 		}
-		score, exists := s.engine.ZScore(args[0], args[1])
+		score, exists, err := s.engine.ZScore(args[0], args[1])
+		if err != nil {
+			return nil, err
+		}
 		if !exists {
 			return nil, nil
 		}
@@ -444,7 +457,10 @@ func (s *Server) executeCommand(cmd string, args []string) (interface{}, error)
 		}
 
 		withScores := len(args) > 3 && strings.EqualFold(args[3], "WITHSCORES")
-		members := s.engine.ZRange(args[0], start, stop, withScores)
+		members, err := s.engine.ZRange(args[0], start, stop, withScores)
+		if err != nil {
+			return nil, err
+		}
 		if withScores {
 			result := make([]string, 0, len(members)*2)
 			for _, member := range members {
@@ -469,12 +485,15 @@ func (s *Server) executeCommand(cmd string, args []string) (interface{}, error)
 		if len(args) != 1 {
 			return nil, fmt.Errorf("wrong number of arguments for 'STRLEN' command")
 		}
-		return s.engine.StrLen(args[0]), nil
+		return s.engine.StrLen(args[0])
 
 	case "DBSIZE":
 		return s.engine.Size(), nil
 
-	case "FLUSHDB", "FLUSHALL":
+	case "FLUSHDB":
+		return "OK", s.engine.FlushDB()
+
+	case "FLUSHALL":
 		return "OK", s.engine.Clear()
 
 	case "TYPE":
@@ -511,7 +530,10 @@ func (s *Server) executeCommand(cmd string, args []string) (interface{}, error)
 		if len(args) != 2 {
 			return nil, fmt.Errorf("wrong number of arguments for 'HGET' command")
 		}
-		val, ok := s.engine.HGet(args[0], args[1])
+		val, ok, err := s.engine.HGet(args[0], args[1])
+		if err != nil {
+			return nil, err
+		}
 		if !ok {
 			return nil, nil
 		}
@@ -523,7 +545,10 @@ func (s *Server) executeCommand(cmd string, args []string) (interface{}, error)
 		}
 		result := make([]interface{}, len(args)-1)
 		for i := 1; i < len(args); i++ {
-			val, ok := s.engine.HGet(args[0], args[i])
+			val, ok, err := s.engine.HGet(args[0], args[i])
+			if err != nil {
+				return nil, err
+			}
 			if ok {
 				result[i-1] = string(val)
 			}
@@ -540,7 +565,11 @@ func (s *Server) executeCommand(cmd string, args []string) (interface{}, error)
 		if len(args) != 2 {
 			return nil, fmt.Errorf("wrong number of arguments for 'HEXISTS' command")
 		}
-		if s.engine.HExists(args[0], args[1]) {
+		exists, err := s.engine.HExists(args[0], args[1])
+		if err != nil {
+			return nil, err
+		}
+		if exists {
 			return 1, nil
 		}
 		return 0, nil
@@ -549,13 +578,16 @@ func (s *Server) executeCommand(cmd string, args []string) (interface{}, error)
 		if len(args) != 1 {
 			return nil, fmt.Errorf("wrong number of arguments for 'HLEN' command")
 		}
-		return s.engine.HLen(args[0]), nil
+		return s.engine.HLen(args[0])
 
 	case "HGETALL":
 		if len(args) != 1 {
 			return nil, fmt.Errorf("wrong number of arguments for 'HGETALL' command")
 		}
-		pairs := s.engine.HGetAll(args[0])
+		pairs, err := s.engine.HGetAll(args[0])
+		if err != nil {
+			return nil, err
+		}
 		result := make([]string, 0, len(pairs)*2)
 		for _, p := range pairs {
 			result = append(result, p.Field, string(p.Value))
@@ -566,13 +598,16 @@ func (s *Server) executeCommand(cmd string, args []string) (interface{}, error)
 		if len(args) != 1 {
 			return nil, fmt.Errorf("wrong number of arguments for 'HKEYS' command")
 		}
-		return s.engine.HKeys(args[0]), nil
+		return s.engine.HKeys(args[0])
 
 	case "HVALS":
 		if len(args) != 1 {
 			return nil, fmt.Errorf("wrong number of arguments for 'HVALS' command")
 		}
-		vals := s.engine.HVals(args[0])
+		vals, err := s.engine.HVals(args[0])
+		if err != nil {
+			return nil, err
+		}
 		result := make([]string, len(vals))
 		for i, v := range vals {
 			result[i] = string(v)
@@ -667,7 +702,7 @@ func (s *Server) executeCommand(cmd string, args []string) (interface{}, error)
 		if len(args) != 1 {
 			return nil, fmt.Errorf("wrong number of arguments for 'LLEN' command")
 		}
-		return s.engine.LLen(args[0]), nil
+		return s.engine.LLen(args[0])
 
 	case "LINDEX":
 		if len(args) != 2 {
@@ -677,7 +712,10 @@ func (s *Server) executeCommand(cmd string, args []string) (interface{}, error)
 		if err != nil {
 			return nil, fmt.Errorf("value is not an integer or out of range")
 		}
-		val, ok := s.engine.LIndex(args[0], idx)
+		val, ok, err := s.engine.LIndex(args[0], idx)
+		if err != nil {
+			return nil, err
+		}
 		if !ok {
 			return nil, nil
 		}
@@ -708,7 +746,10 @@ func (s *Server) executeCommand(cmd string, args []string) (interface{}, error)
 		if err != nil {
 			return nil, fmt.Errorf("value is not an integer or out of range")
 		}
-		items := s.engine.LRange(args[0], start, stop)
+		items, err := s.engine.LRange(args[0], start, stop)
+		if err != nil {
+			return nil, err
+		}
 		result := make([]string, len(items))
 		for i, v := range items {
 			result[i] = string(v)
@@ -769,7 +810,11 @@ func (s *Server) executeCommand(cmd string, args []string) (interface{}, error)
 		if len(args) != 2 {
 			return nil, fmt.Errorf("wrong number of arguments for 'SISMEMBER' command")
 		}
-		if s.engine.SIsMember(args[0], args[1]) {
+		exists, err := s.engine.SIsMember(args[0], args[1])
+		if err != nil {
+			return nil, err
+		}
+		if exists {
 			return 1, nil
 		}
 		return 0, nil
@@ -778,13 +823,13 @@ func (s *Server) executeCommand(cmd string, args []string) (interface{}, error)
 		if len(args) != 1 {
 			return nil, fmt.Errorf("wrong number of arguments for 'SCARD' command")
 		}
-		return s.engine.SCard(args[0]), nil
+		return s.engine.SCard(args[0])
 
 	case "SMEMBERS":
 		if len(args) != 1 {
 			return nil, fmt.Errorf("wrong number of arguments for 'SMEMBERS' command")
 		}
-		return s.engine.SMembers(args[0]), nil
+		return s.engine.SMembers(args[0])
 
 	case "SRANDMEMBER":
 		if len(args) < 1 || len(args) > 2 {
@@ -798,7 +843,10 @@ func (s *Server) executeCommand(cmd string, args []string) (interface{}, error)
 				return nil, fmt.Errorf("value is not an integer or out of range")
 			}
 		}
-		members := s.engine.SRandMember(args[0], count)
+		members, err := s.engine.SRandMember(args[0], count)
+		if err != nil {
+			return nil, err
+		}
 		if len(args) == 1 {
 			if len(members) == 0 {
 				return nil, nil
@@ -835,19 +883,19 @@ func (s *Server) executeCommand(cmd string, args []string) (interface{}, error)
 		if len(args) < 1 {
 			return nil, fmt.Errorf("wrong number of arguments for 'SINTER' command")
 		}
-		return s.engine.SInter(args...), nil
+		return s.engine.SInter(args...)
 
 	case "SUNION":
 		if len(args) < 1 {
 			return nil, fmt.Errorf("wrong number of arguments for 'SUNION' command")
 		}
-		return s.engine.SUnion(args...), nil
+		return s.engine.SUnion(args...)
 
 	case "SDIFF":
 		if len(args) < 1 {
 			return nil, fmt.Errorf("wrong number of arguments for 'SDIFF' command")
 		}
-		return s.engine.SDiff(args...), nil
+		return s.engine.SDiff(args...)
 
 	case "INFO":
 		stats := s.engine.GetStats()
@@ -955,11 +1003,11 @@ func (s *Server) handleKey(w http.ResponseWriter, r *http.Request) {
 		var value string
 		switch keyType {
 		case "string":
-			if val, ok := s.engine.Get(key); ok {
+			if val, ok, _ := s.engine.Get(key); ok {
 				value = string(val)
 			}
 		case "hash":
-			pairs := s.engine.HGetAll(key)
+			pairs, _ := s.engine.HGetAll(key)
 			m := make(map[string]string, len(pairs))
 			for _, p := range pairs {
 				m[p.Field] = string(p.Value)
@@ -967,7 +1015,7 @@ func (s *Server) handleKey(w http.ResponseWriter, r *http.Request) {
 			b, _ := json.Marshal(m)
 			value = string(b)
 		case "list":
-			items := s.engine.LRange(key, 0, -1)
+			items, _ := s.engine.LRange(key, 0, -1)
 			strs := make([]string, len(items))
 			for i, v := range items {
 				strs[i] = string(v)
@@ -975,17 +1023,34 @@ func (s *Server) handleKey(w http.ResponseWriter, r *http.Request) {
 			b, _ := json.Marshal(strs)
 			value = string(b)
 		case "set":
-			members := s.engine.SMembers(key)
+			members, _ := s.engine.SMembers(key)
 			b, _ := json.Marshal(members)
 			value = string(b)
 		case "zset":
-			members := s.engine.ZRange(key, 0, -1, true)
+			members, _ := s.engine.ZRange(key, 0, -1, true)
 			m := make(map[string]float64, len(members))
 			for _, sm := range members {
 				m[sm.Member] = sm.Score
 			}
 			b, _ := json.Marshal(m)
 			value = string(b)
+		case "stream":
+			entries, _ := s.engine.XRange(key, store.StreamID{}, store.MaxStreamID, 0, false)
+			out := make([]map[string]interface{}, len(entries))
+			for i, en := range entries {
+				fields := make(map[string]string, len(en.Fields)/2)
+				for j := 0; j+1 < len(en.Fields); j += 2 {
+					fields[string(en.Fields[j])] = string(en.Fields[j+1])
+				}
+				out[i] = map[string]interface{}{"id": en.ID.String(), "fields": fields}
+			}
+			b, _ := json.Marshal(out)
+			value = string(b)
+		case "ReJSON-RL":
+			// The document is JSON already; send it as is.
+			if doc, ok, _ := s.engine.JSONGet(key, store.JSONFormat{}); ok {
+				value = string(doc)
+			}
 		}
 		writeJSON(w, KeyInfo{
 			Key:   key,
@@ -1322,6 +1387,15 @@ func (s *Server) handleBenchmark(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, result)
 }
 
+// denyOOMCmds are the console commands that may add data, refused when
+// memory is over the limit and the eviction policy cannot free any.
+var denyOOMCmds = map[string]bool{
+	"SET": true, "INCR": true, "DECR": true, "INCRBY": true, "APPEND": true,
+	"ZADD": true, "HSET": true, "HMSET": true, "HINCRBY": true,
+	"HINCRBYFLOAT": true, "HSETNX": true, "LPUSH": true, "RPUSH": true,
+	"LSET": true, "LINSERT": true, "SADD": true,
+}
+
 // parseCommand parses a command string into parts, handling quoted strings.
 func parseCommand(input string) []string {
 	var parts []string
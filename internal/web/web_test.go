@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/flashdb/flashdb/internal/engine"
+	"github.com/flashdb/flashdb/internal/store"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -125,3 +126,23 @@ func executeV1(t *testing.T, handler http.Handler, payload string) CommandRespon
 	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
 	return resp
 }
+
+func TestKeyRouteRendersJSONDocuments(t *testing.T) {
+	s := newTestWebServer(t)
+	handler := corsMiddleware(s.routes())
+
+	path, err := store.ParseJSONPath("$")
+	require.NoError(t, err)
+	_, err = s.engine.JSONSet("doc", path, []byte(`{"name": "x", "tags": [1, 2]}`), false, false)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/key/doc", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var info KeyInfo
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &info))
+	assert.Equal(t, "ReJSON-RL", info.Type)
+	assert.JSONEq(t, `{"name":"x","tags":[1,2]}`, info.Value)
+}